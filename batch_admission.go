@@ -0,0 +1,84 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// BatchAdmission is an optional callback that admits a batch of the
+// docs of the given bytes onto the index of the key, as keyed by the
+// app_herder events, ahead of the execution of the batch, where it may
+// wait on the memory quota until the ctx is done.  A batch that isn't
+// admitted isn't executed, see admitBatch.
+var BatchAdmission func(ctx context.Context, key interface{},
+	bytes uint64) error
+
+// BatchAdmissionRetryBackoff is how long a batch that was rejected to
+// be retried waits before its next admission.
+var BatchAdmissionRetryBackoff = 100 * time.Millisecond
+
+// TotBatchAdmissionRetries tracks the admissions of the batches that
+// were retried after a rejection.
+var TotBatchAdmissionRetries uint64
+
+// The reasons that a batch may be rejected for.
+const (
+	BatchRejectWaitingBatches = "waitingBatches"
+	BatchRejectDraining       = "draining"
+)
+
+// BatchRejectedError is returned by the BatchAdmission for a batch that
+// isn't admitted, telling why, and whether the batch is to be retried
+// rather than failed.
+type BatchRejectedError struct {
+	// Reason is one of the BatchReject's.
+	Reason string
+
+	// Retry is whether the batch may be admitted later, as opposed to
+	// the batches that are to fail.
+	Retry bool
+}
+
+func (e *BatchRejectedError) Error() string {
+	return fmt.Sprintf("batch rejected, reason: %s, retry: %t",
+		e.Reason, e.Retry)
+}
+
+// admitBatch admits the batch of the index of the key with the
+// BatchAdmission, if any, where the rejections that are to be retried
+// are retried after the BatchAdmissionRetryBackoff, which keeps the
+// batch worker, and so the feeds of the index, paused until the batch
+// is admitted or the ctx is done.
+func admitBatch(ctx context.Context, key interface{}, bytes uint64) error {
+	if BatchAdmission == nil || key == nil {
+		return nil
+	}
+
+	for {
+		err := BatchAdmission(ctx, key, bytes)
+		if bre, ok := err.(*BatchRejectedError); !ok || !bre.Retry {
+			return err
+		}
+
+		atomic.AddUint64(&TotBatchAdmissionRetries, 1)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(BatchAdmissionRetryBackoff):
+		}
+	}
+}
@@ -0,0 +1,245 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/couchbase/cbgt"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestAdmitBatch(t *testing.T) {
+	defer func(f func(context.Context, interface{}, uint64) error,
+		d time.Duration) {
+		BatchAdmission, BatchAdmissionRetryBackoff = f, d
+	}(BatchAdmission, BatchAdmissionRetryBackoff)
+	BatchAdmissionRetryBackoff = time.Millisecond
+
+	retry := &BatchRejectedError{Reason: BatchRejectWaitingBatches, Retry: true}
+
+	// the rejections to be retried are retried until admitted
+	var admissions int
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		admissions++
+		if admissions < 3 {
+			return retry
+		}
+		return nil
+	}
+
+	retries := atomic.LoadUint64(&TotBatchAdmissionRetries)
+	if err := admitBatch(context.Background(), "index", 1); err != nil {
+		t.Errorf("expected the batch to be admitted, err: %v", err)
+	}
+	if admissions != 3 ||
+		atomic.LoadUint64(&TotBatchAdmissionRetries) != retries+2 {
+		t.Errorf("expected 2 retries, got admissions: %d", admissions)
+	}
+
+	// while the other errors fail the batch
+	rejected := errors.New("rejected")
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		return rejected
+	}
+	if err := admitBatch(context.Background(), "index", 1); err != rejected {
+		t.Errorf("expected the rejection, got: %v", err)
+	}
+
+	// as does the ctx being done while retrying
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		return retry
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := admitBatch(ctx, "index", 1); err != context.Canceled {
+		t.Errorf("expected the ctx err, got: %v", err)
+	}
+}
+
+func TestExecuteBatchNotAdmitted(t *testing.T) {
+	defer func(f func(context.Context, interface{}, uint64) error) {
+		BatchAdmission = f
+	}(BatchAdmission)
+
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	batch := index.NewBatch()
+	if err = batch.Index("doc", map[string]interface{}{"f": "v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := &BatchRejectedError{Reason: BatchRejectWaitingBatches}
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		return rejected
+	}
+
+	before := atomic.LoadUint64(&BatchBytesAdded) -
+		atomic.LoadUint64(&BatchBytesRemoved)
+
+	executed, err := execute(context.Background(), nil, nil, index, batch,
+		false)
+	if executed || err != rejected {
+		t.Errorf("expected the batch to be rejected, got: %v, err: %v",
+			executed, err)
+	}
+
+	if count, _ := index.DocCount(); count != 0 {
+		t.Errorf("expected the rejected batch not to be executed, got: %d"+
+			" docs", count)
+	}
+	if after := atomic.LoadUint64(&BatchBytesAdded) -
+		atomic.LoadUint64(&BatchBytesRemoved); after != before {
+		t.Errorf("expected the bytes of the batch to be released")
+	}
+}
+
+func TestExecuteBatchInChunks(t *testing.T) {
+	defer func(f func(context.Context, interface{}, uint64) error) {
+		BatchAdmission = f
+	}(BatchAdmission)
+
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	parts := []*bleve.Batch{index.NewBatch(), index.NewBatch()}
+	for i, part := range parts {
+		err = part.Index(fmt.Sprintf("doc%d", i),
+			map[string]interface{}{"f": "v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := index.NewBatch()
+	batch.Merge(parts[0])
+	batch.Merge(parts[1])
+
+	// only the parts are within the max
+	var admitted []uint64
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		admitted = append(admitted, bytes)
+		if bytes > parts[0].TotalDocsSize() {
+			return ErrBatchTooLarge
+		}
+		return nil
+	}
+
+	bdest := &BleveDest{
+		stats: cbgt.PIndexStoreStats{TimerBatchStore: metrics.NewTimer()},
+	}
+	bdp := []*BleveDestPartition{{bdest: bdest}, {bdest: bdest}}
+
+	chunked := atomic.LoadUint64(&TotBatchesChunked)
+	executeBatch(context.Background(), bdp, []uint64{1, 2}, index, batch,
+		parts)
+
+	if atomic.LoadUint64(&TotBatchesChunked) != chunked+1 ||
+		len(admitted) != 3 {
+		t.Errorf("expected the batch to be executed in chunks, admitted: %v",
+			admitted)
+	}
+	if count, _ := index.DocCount(); count != 2 {
+		t.Errorf("expected the docs of the parts, got: %d docs", count)
+	}
+	if bdp[0].seqMaxBatch != 1 || bdp[1].seqMaxBatch != 2 ||
+		bdp[0].lastAsyncBatchErr != nil {
+		t.Errorf("expected the seqs of the parts to be applied")
+	}
+
+	// a batch that can't be chunked goes ahead, as it was admitted on
+	// the memory quota
+	over := atomic.LoadUint64(&TotBatchesOverMaxBytes)
+	executed, err := execute(context.Background(), bdp[:1], []uint64{3}, index,
+		batch, false)
+	if !executed || err != nil ||
+		atomic.LoadUint64(&TotBatchesOverMaxBytes) != over+1 {
+		t.Errorf("expected the batch to be executed, err: %v", err)
+	}
+}
+
+func TestBatchWorkerAdmissionCancelledOnClose(t *testing.T) {
+	defer func(f func(context.Context, interface{}, uint64) error) {
+		BatchAdmission = f
+	}(BatchAdmission)
+
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	batch := index.NewBatch()
+	if err = batch.Index("doc", map[string]interface{}{"f": "v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the admission waits until its ctx is done
+	admittingCh := make(chan struct{})
+	BatchAdmission = func(ctx context.Context, key interface{},
+		bytes uint64) error {
+		close(admittingCh)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	bdest := &BleveDest{
+		stats: cbgt.PIndexStoreStats{TimerBatchStore: metrics.NewTimer()},
+	}
+	bdp := &BleveDestPartition{bdest: bdest}
+
+	requestCh := make(chan *batchRequest, 1)
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		runBatchWorker(requestCh, stopCh, index)
+		close(doneCh)
+	}()
+
+	requestCh <- &batchRequest{bdp: bdp, bindex: index, batch: batch}
+	<-admittingCh
+
+	close(stopCh)
+
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the batch worker to stop")
+	}
+
+	bdp.m.Lock()
+	lastErr := bdp.lastAsyncBatchErr
+	bdp.m.Unlock()
+	if lastErr != context.Canceled {
+		t.Errorf("expected the admission to be cancelled, got: %v", lastErr)
+	}
+	if count, _ := index.DocCount(); count != 0 {
+		t.Errorf("expected the batch not to be executed, got: %d docs", count)
+	}
+}
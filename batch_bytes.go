@@ -0,0 +1,144 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blevesearch/bleve"
+	"github.com/couchbase/moss"
+)
+
+var batchBytesM sync.Mutex
+
+// batchBytesInFlight are the bytes of the docs of the batches being
+// executed, keyed by the index they're executed on, as identified by
+// the events of the app_herder: the *scorch.Scorch of a scorch index
+// or the moss.Collection of an upsidedown index on moss.  An index has
+// an entry from its open until it's closed, so that the batches that
+// complete after the close don't recreate it.
+var batchBytesInFlight = map[interface{}]uint64{}
+
+// mossCollectioner is implemented by the moss KVStore of bleve.
+type mossCollectioner interface {
+	Collection() moss.Collection
+}
+
+// batchBytesKey returns the key of the batch bytes of the index, or nil
+// when the index isn't one of the app_herder's.
+func batchBytesKey(bindex bleve.Index) interface{} {
+	idx, kvstore, err := bindex.Advanced()
+	if err != nil {
+		return nil
+	}
+	if mc, ok := kvstore.(mossCollectioner); ok {
+		return mc.Collection()
+	}
+	return idx
+}
+
+// trackBatchBytes starts the per index accounting of the batch bytes
+// of the index of the key, as it's opened.
+func trackBatchBytes(key interface{}) {
+	if key == nil {
+		return
+	}
+	batchBytesM.Lock()
+	if _, exists := batchBytesInFlight[key]; !exists {
+		batchBytesInFlight[key] = 0
+	}
+	batchBytesM.Unlock()
+}
+
+// addBatchBytes accounts the bytes of a batch being executed on the
+// index of the key, both per index, unless the index isn't tracked,
+// such as once it's closed, and in the BatchBytesAdded.
+func addBatchBytes(key interface{}, n uint64) {
+	atomic.AddUint64(&BatchBytesAdded, n)
+
+	if key != nil {
+		batchBytesM.Lock()
+		if v, exists := batchBytesInFlight[key]; exists {
+			batchBytesInFlight[key] = v + n
+		}
+		batchBytesM.Unlock()
+	}
+}
+
+// removeBatchBytes accounts the bytes of a batch that was executed on
+// the index of the key.
+func removeBatchBytes(key interface{}, n uint64) {
+	if key != nil {
+		batchBytesM.Lock()
+		if v, exists := batchBytesInFlight[key]; exists {
+			if v > n {
+				batchBytesInFlight[key] = v - n
+			} else {
+				batchBytesInFlight[key] = 0
+			}
+		}
+		batchBytesM.Unlock()
+	}
+
+	atomic.AddUint64(&BatchBytesRemoved, n)
+}
+
+// forgetBatchBytes drops the entry of the index of the key, once the
+// index is closed.
+func forgetBatchBytes(key interface{}) {
+	if key == nil {
+		return
+	}
+	batchBytesM.Lock()
+	delete(batchBytesInFlight, key)
+	batchBytesM.Unlock()
+}
+
+// BatchBytesInFlight returns the bytes of the docs of the batches being
+// executed on an index, as keyed by the app_herder events, and whether
+// the index is known, where the BatchBytesAdded less the
+// BatchBytesRemoved remains the total across all the indexes.
+func BatchBytesInFlight(key interface{}) (uint64, bool) {
+	batchBytesM.Lock()
+	v, exists := batchBytesInFlight[key]
+	batchBytesM.Unlock()
+	return v, exists
+}
+
+// MaxBatchBytes is an optional callback that returns the max bytes of
+// the docs of a single batch, where 0 means no max, as the app_herder
+// rejects the larger batches.  The batches are chunked to stay within
+// it, short of a single doc that's larger by itself.
+var MaxBatchBytes func() uint64
+
+// ErrBatchTooLarge is returned by the app_herder for a batch whose docs
+// alone exceed the MaxBatchBytes, which the caller is to handle by
+// executing the batch in smaller chunks.
+var ErrBatchTooLarge = errors.New("batch too large, exceeds the max" +
+	" batch bytes")
+
+// maxBatchBytes returns the max bytes of a batch, or 0 for no max.
+func maxBatchBytes() uint64 {
+	if MaxBatchBytes != nil {
+		return MaxBatchBytes()
+	}
+	return 0
+}
+
+// batchOverMaxBytes returns whether the batch reached the max bytes,
+// so is to be executed ahead of adding to it.
+func batchOverMaxBytes(batch *bleve.Batch) bool {
+	max := maxBatchBytes()
+	return max > 0 && batch.TotalDocsSize() >= max
+}
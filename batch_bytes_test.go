@@ -0,0 +1,119 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestBatchBytesPerIndex(t *testing.T) {
+	a, b := &struct{ name string }{"a"}, &struct{ name string }{"b"}
+
+	total := func() uint64 {
+		return atomic.LoadUint64(&BatchBytesAdded) -
+			atomic.LoadUint64(&BatchBytesRemoved)
+	}
+	totalBefore := total()
+
+	if _, known := BatchBytesInFlight(a); known {
+		t.Errorf("expected the index to be unknown before it's opened")
+	}
+
+	trackBatchBytes(a)
+	trackBatchBytes(b)
+	if v, known := BatchBytesInFlight(a); !known || v != 0 {
+		t.Errorf("expected the opened index to be known, got: %d, %v",
+			v, known)
+	}
+
+	addBatchBytes(a, 100)
+	addBatchBytes(b, 10)
+	addBatchBytes(nil, 1)
+
+	if v, known := BatchBytesInFlight(a); !known || v != 100 {
+		t.Errorf("expected the bytes of the index, got: %d, %v", v, known)
+	}
+	if v, _ := BatchBytesInFlight(b); v != 10 {
+		t.Errorf("expected the bytes of the other index, got: %d", v)
+	}
+	if got := total() - totalBefore; got != 111 {
+		t.Errorf("expected the total across the indexes, got: %d", got)
+	}
+
+	removeBatchBytes(a, 100)
+	removeBatchBytes(nil, 1)
+
+	if v, known := BatchBytesInFlight(a); !known || v != 0 {
+		t.Errorf("expected the index to stay known, got: %d, %v", v, known)
+	}
+
+	forgetBatchBytes(a)
+	if _, known := BatchBytesInFlight(a); known {
+		t.Errorf("expected the closed index to be forgotten")
+	}
+
+	// a batch that completes after the close is still removed from the
+	// total, without recreating the entry
+	forgetBatchBytes(b)
+	removeBatchBytes(b, 10)
+	if _, known := BatchBytesInFlight(b); known {
+		t.Errorf("expected the closed index to stay forgotten")
+	}
+
+	// as is a batch that starts after the close
+	addBatchBytes(b, 5)
+	removeBatchBytes(b, 5)
+	if _, known := BatchBytesInFlight(b); known {
+		t.Errorf("expected no entry for a batch after the close")
+	}
+	if got := total() - totalBefore; got != 0 {
+		t.Errorf("expected no bytes left in flight, got: %d", got)
+	}
+}
+
+func TestBatchOverMaxBytes(t *testing.T) {
+	defer func(f func() uint64) { MaxBatchBytes = f }(MaxBatchBytes)
+
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	batch := index.NewBatch()
+	if err = batch.Index("doc", map[string]interface{}{"f": "v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	MaxBatchBytes = nil
+	if batchOverMaxBytes(batch) {
+		t.Errorf("expected no max without the callback")
+	}
+
+	MaxBatchBytes = func() uint64 { return 0 }
+	if batchOverMaxBytes(batch) {
+		t.Errorf("expected no max when disabled")
+	}
+
+	MaxBatchBytes = func() uint64 { return batch.TotalDocsSize() + 1 }
+	if batchOverMaxBytes(batch) {
+		t.Errorf("expected the batch to be within the max")
+	}
+
+	MaxBatchBytes = func() uint64 { return batch.TotalDocsSize() }
+	if !batchOverMaxBytes(batch) {
+		t.Errorf("expected the batch to reach the max")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/blevesearch/bleve"
@@ -111,6 +112,56 @@ func (m *cacheBleveIndex) Search(req *bleve.SearchRequest) (
 }
 
 func (m *cacheBleveIndex) SearchInContext(ctx context.Context,
+	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	res, err := m.searchAbandonable(ctx, req)
+	if c := pindexHitCountsFromContext(ctx); c != nil && m.pindex != nil {
+		if err == ErrPIndexAbandoned {
+			c.setReason(m.pindex.Name, PIndexStatusAbandoned)
+		} else if err == nil && res != nil {
+			c.add(m.pindex.Name, res.Total)
+			c.setReason(m.pindex.Name, pindexStatusForHits(res.Total))
+		} else if ctx.Err() == context.DeadlineExceeded {
+			c.setReason(m.pindex.Name, PIndexStatusTimedout)
+		} else {
+			c.setReason(m.pindex.Name, PIndexStatusError)
+		}
+	}
+
+	// the interim facets are best effort, as the final results carry
+	// the facets anyway
+	if fs := facetSnapshotsFromContext(ctx); fs != nil && err == nil &&
+		res != nil {
+		fs.addFacets(res.Facets, req.Facets)
+	}
+
+	return res, err
+}
+
+// searchAbandonable searches the pindex until the client abandons it,
+// if it may, in which case the ErrPIndexAbandoned is returned.
+func (m *cacheBleveIndex) searchAbandonable(ctx context.Context,
+	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	a := pindexAbandonmentFromContext(ctx)
+	if a == nil || m.pindex == nil {
+		return m.searchInContext(ctx, req)
+	}
+
+	actx, done := a.track(ctx, m.pindex.Name)
+	defer done()
+
+	// a pindex abandoned ahead of its search isn't searched at all
+	if !a.isAbandoned(m.pindex.Name) {
+		res, err := m.searchInContext(actx, req)
+		if err == nil || !a.isAbandoned(m.pindex.Name) {
+			return res, err
+		}
+	}
+
+	atomic.AddUint64(&totGrpcPIndexesAbandoned, 1)
+	return nil, ErrPIndexAbandoned
+}
+
+func (m *cacheBleveIndex) searchInContext(ctx context.Context,
 	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
 	if !ResultCache.enabled() {
 		return m.bindex.SearchInContext(ctx, req)
@@ -12,50 +12,354 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/blevesearch/bleve/index/scorch"
 	"github.com/couchbase/cbft"
-	"github.com/couchbase/cbgt/rest"
 	"github.com/couchbase/moss"
 
 	log "github.com/couchbase/clog"
+	metrics "github.com/rcrowley/go-metrics"
 )
 
 type sizeFunc func(interface{}) uint64
 
+// errTooManyWaitingBatches is returned by onBatchExecuteStart when the
+// max number of batches are already waiting on the memory quota, where
+// the batch is retried by the indexing, outside of the waiting batches.
+var errTooManyWaitingBatches = &cbft.BatchRejectedError{
+	Reason: cbft.BatchRejectWaitingBatches,
+	Retry:  true,
+}
+
+// errHerderDraining is returned by onBatchExecuteStart once the herder
+// is quiesced for the shutdown, where the batch fails rather than be
+// executed, and errQueryHerderDraining by onQueryStart.
+var errHerderDraining = &cbft.BatchRejectedError{
+	Reason: cbft.BatchRejectDraining,
+}
+
+var errQueryHerderDraining = &cbft.QueryRejectedError{
+	Limit: cbft.QueryRejectLimitDraining,
+}
+
+// defaultMaxDegradedQueries is how many queries may run in the degraded
+// mode at a time, unless set otherwise.
+const defaultMaxDegradedQueries = 4
+
+// wakeReasonStats tracks the waiting batches that were awoken for a
+// given reason, and whether they then proceeded or waited again.
+type wakeReasonStats struct {
+	TotAwoken          uint64
+	TotProceeded       uint64
+	TotRewaited        uint64
+	TotProceededWaitNS uint64 // Total wait of the batches that proceeded.
+}
+
+// indexQueryStats tracks the queries of an index, when the queries
+// are admitted per index, which are kept only while the index has
+// running queries, so that the dropped indexes don't pile up.
+type indexQueryStats struct {
+	RunningQueries      uint64
+	RunningQueryUsed    uint64
+	TotQueriesRejected  uint64
+	TotQueriesOverShare uint64 // Admitted beyond the share of the index.
+}
+
+// batchWaitBuckets are the upper bounds of the buckets of the batch
+// wait histogram, with a last bucket for the longer waits.
+var batchWaitBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// batchWaitBucketLabel returns the stats label of the i'th bucket.
+func batchWaitBucketLabel(i int) string {
+	if i < len(batchWaitBuckets) {
+		return "le" + batchWaitBuckets[i].String()
+	}
+	return "gt" + batchWaitBuckets[len(batchWaitBuckets)-1].String()
+}
+
 type appHerder struct {
+	// The quotas are guarded by the m, but may be atomically loaded
+	// without it, as they're reconfigurable by UpdateQuota.
 	memQuota   int64
 	appQuota   int64
 	indexQuota int64
 	queryQuota int64
 
+	appRatio   float64
+	indexRatio float64
+	queryRatio float64
+
 	overQuotaCh chan struct{}
 
+	// goverseer, when any, tunes the GC against the memQuota, which
+	// UpdateQuota keeps it up to date with.
+	goverseer *Goverseer
+
+	// The subscribers to the over-quota signals, such as the gRPC
+	// clients backing off, which are guarded by their own mutex, as
+	// the signals are sent both with and without the m held.
+	overQuotaSubsM sync.Mutex
+	overQuotaSubs  map[chan struct{}]struct{}
+
+	// memoryUsed provides the memory used by the process, which
+	// defaults to cbft.FetchCurMemoryUsed.
+	memoryUsed func() uint64
+
+	// batchBytesInFlight provides the bytes of the batches in flight
+	// for an index, which defaults to cbft.BatchBytesInFlight.
+	batchBytesInFlight func(interface{}) (uint64, bool)
+
+	// availableMemory provides the memory available to the system,
+	// which defaults to the MemAvailable of the /proc/meminfo.
+	availableMemory func() (uint64, error)
+
 	m        sync.Mutex
 	waitCond *sync.Cond
 	waiting  int
 
+	// The peak of the waiting batches, and a histogram of how long
+	// the batches that waited were blocked, with a count per bucket
+	// of the batchWaitBuckets.
+	waitingHighWater int
+	batchWaitCounts  []uint64
+	totBatchWaitNS   uint64
+
+	// Caps the batches waiting on the memory quota, where 0 means no
+	// cap, so that a wedged persister can't pile up batches.
+	maxWaitingBatches  int
+	totBatchesRejected uint64
+
+	// Bounds the wait of a batch on the memory quota, where 0 means no
+	// bound, after which the batch proceeds anyway, so that stalled
+	// persisters and mergers can't block the indexing forever.  The
+	// wakes for the timeouts are counted apart from the wakeReasons,
+	// as they're of a single batch and not a reason to recheck.
+	maxBatchWait             time.Duration
+	totBatchesWaitTimedOut   uint64
+	totBatchWaitTimeoutWakes uint64
+
+	// When non-zero, the fraction of the indexQuota that a single batch
+	// may use, as a batch that's larger by itself is rejected once
+	// admitted on the memory quota, so that it can't blow past the quota
+	// in one shot.  It's the math.Float64bits of the fraction, accessed
+	// atomically, as the indexing checks it on every mutation.
+	maxBatchFraction   uint64
+	totBatchesTooLarge uint64
+
+	// When non-zero, the indexing also waits while the available
+	// system memory is below the freeMemoryFloor, even when under the
+	// quotas, as other processes may have grown.  Conversely, when
+	// there's at least the freeMemoryHeadroom available, the indexing
+	// may exceed the indexQuota, but still not the appQuota.  The
+	// available memory is sampled every freeMemorySampleInterval, apart
+	// from the m, into the availableMemorySample, which is -1 while
+	// it's unknown.  The totals count the batches, not the checks.
+	freeMemoryFloor       uint64
+	freeMemoryHeadroom    uint64
+	freeMemoryStopCh      chan struct{}
+	availableMemorySample int64 // Accessed atomically.
+	totFreeMemoryBlocked  uint64
+	totFreeMemoryRelaxed  uint64
+
 	indexes map[interface{}]sizeFunc
 
+	// Tracks, per wake reason, how the waiting batches fared
+	wakeReasons    map[string]*wakeReasonStats
+	lastWakeReason string
+
 	// Tracks estimated memory used by running queries
 	runningQueryUsed uint64
+
+	// When non-zero, the fraction of the queryQuota that the running
+	// queries of each index may use, so that a heavy index can't
+	// starve the queries of the other indexes.  It's work-conserving,
+	// as an index may exceed its share while the others are idle.
+	// The totals outlive the per index stats of the idle indexes.
+	queryIndexFraction           float64
+	queryIndexes                 map[string]*indexQueryStats // Keyed by index name.
+	totQueriesIndexShareRejected uint64
+	totQueriesOverIndexShare     uint64
+
+	// When non-zero, the fraction of the queryQuota that's reserved
+	// for the high priority queries, as the low priority queries are
+	// rejected once the rest of the queryQuota is used.
+	queryHighPriorityReserve      float64
+	totLowPriorityQueriesRejected uint64
+
+	// When set, the queries that may degrade are admitted in the
+	// memory-bounded degraded mode, rather than rejected, once over
+	// the queryQuota while the appQuota still has headroom, up to the
+	// maxDegradedQueries running at a time.  They're accounted in the
+	// runningQueryUsed by their estimates, which are of the regular
+	// mode, so as to err on the conservative side.
+	queryDegradedMode      bool
+	maxDegradedQueries     int
+	runningDegradedQueries int
+	totQueriesDegraded     uint64
+
+	// When maxQueuedQueries is non-zero, the queries over the
+	// queryQuota wait in a FIFO queue, on the queryWaitCond, for the
+	// running queries to end, rather than being rejected right away,
+	// unless the queue is full or their wait exceeds the
+	// maxQueryQueueWait.  The queue holds the tickets of the queued
+	// queries in their order of arrival, where only the head of the
+	// queue may be admitted, while the queries that arrive under the
+	// queryQuota are admitted right away.
+	maxQueuedQueries        int
+	maxQueryQueueWait       time.Duration
+	queryWaitCond           *sync.Cond
+	queryQueue              []uint64
+	queryQueueNextTicket    uint64
+	queryQueueHighWater     int
+	queryQueueWaitCounts    []uint64 // Per bucket of the batchWaitBuckets.
+	totQueryQueueWaitNS     uint64
+	totQueriesQueued        uint64
+	totQueriesQueueAdmitted uint64
+	totQueriesQueueTimedOut uint64
+	totQueriesQueueFull     uint64
+
+	// Reserved query slots hold back memory of the quotas for the
+	// queries starting a burst after an idle period, as the free slots
+	// count towards the memory used by the admission of the other
+	// queries, so that a query that fits a free slot is admitted into
+	// it without being held to the index share or queued.  Nothing is
+	// allocated for the slots, they're only a reservation, and the
+	// queries in them are still counted in the runningQueryUsed.  The
+	// slot size follows the recent query estimates, where a query fits
+	// a slot of the size from before its own estimate.
+	queryReservedSlots       int
+	queryReservedSlotsUsed   int
+	queryReservedSlotSize    uint64
+	totQueryReservedSlotHit  uint64
+	totQueryReservedSlotMiss uint64
+
+	// When non-zero, the query estimates of each index are scaled by
+	// a correction factor, which follows the ratio of the result size
+	// reported by the ended queries of the index to their estimates,
+	// as a moving average that's bounded within
+	// [1/queryEstimateMaxCorrection, queryEstimateMaxCorrection].
+	queryEstimateMaxCorrection float64
+	queryEstimateCorrections   map[string]float64 // Keyed by index name.
+
+	// Track the herder's own decision latency (in nanoseconds), that
+	// is, the time spent in onBatchExecuteStart and onQueryStart
+	// acquiring the lock and evaluating the quotas, excluding any
+	// waiting on the memory quota.
+	batchDecisionHistogram metrics.Histogram
+	queryDecisionHistogram metrics.Histogram
+
+	// The stall watchdog, which intervenes once batches have been
+	// waiting on the memory quota for longer than the watchdogThreshold
+	// without any progress events, where 0 disables it.
+	watchdogThreshold        time.Duration
+	watchdogAction           string
+	watchdogStopCh           chan struct{}
+	lastProgress             time.Time
+	totWatchdogInterventions uint64
+
+	// When non-zero, the indexQuota and the queryQuota are rebalanced
+	// every quotaRebalanceInterval, by the quotaRebalanceStep, towards
+	// the side that hit its limit, where the indexQuotaFraction is the
+	// current fraction of their sum that's the indexQuota.
+	quotaRebalanceInterval time.Duration
+	quotaRebalanceStep     float64
+	quotaRebalanceStopCh   chan struct{}
+	indexQuotaFraction     float64
+	indexLimitHits         uint64 // Since the last rebalance.
+	queryLimitHits         uint64 // Since the last rebalance.
+	totQuotaRebalances     uint64
+
+	// The memory pressure, from 0 to 100, as of the last event.
+	pressure uint32
+
+	// Once set by Quiesce, for the shutdown, the batches and the queries
+	// are no longer admitted, and the waiting batches are released with
+	// the errHerderDraining.  It's set under the m, but may be
+	// atomically loaded without it.
+	draining           int32
+	totDrainedReleased uint64
+}
+
+// appHerderOption allows for optional appHerder settings.
+type appHerderOption func(*appHerder)
+
+// withMemoryUsed overrides the source of the memory used by the
+// process, for testing or for alternative memory sources.
+func withMemoryUsed(f func() uint64) appHerderOption {
+	return func(a *appHerder) {
+		a.memoryUsed = f
+	}
+}
+
+// withBatchBytesInFlight overrides the source of the bytes of the
+// batches in flight per index, for testing.
+func withBatchBytesInFlight(f func(interface{}) (uint64, bool)) appHerderOption {
+	return func(a *appHerder) {
+		a.batchBytesInFlight = f
+	}
+}
+
+// withAvailableMemory overrides the source of the memory available to
+// the system, for testing or for alternative memory sources.
+func withAvailableMemory(f func() (uint64, error)) appHerderOption {
+	return func(a *appHerder) {
+		a.availableMemory = f
+	}
 }
 
 func newAppHerder(memQuota uint64, appRatio, indexRatio,
-	queryRatio float64, overQuotaCh chan struct{}) *appHerder {
+	queryRatio float64, overQuotaCh chan struct{},
+	options ...appHerderOption) *appHerder {
 	ah := &appHerder{
 		memQuota:    int64(memQuota),
+		appRatio:    appRatio,
+		indexRatio:  indexRatio,
+		queryRatio:  queryRatio,
 		overQuotaCh: overQuotaCh,
+		memoryUsed:  cbft.FetchCurMemoryUsed,
+
+		batchBytesInFlight: cbft.BatchBytesInFlight,
+
+		availableMemory:       procMemAvailable,
+		availableMemorySample: -1,
+
 		indexes:     map[interface{}]sizeFunc{},
+		wakeReasons: map[string]*wakeReasonStats{},
+
+		maxDegradedQueries: defaultMaxDegradedQueries,
+
+		batchWaitCounts: make([]uint64, len(batchWaitBuckets)+1),
+
+		batchDecisionHistogram: metrics.NewHistogram(
+			metrics.NewExpDecaySample(1028, 0.015)),
+		queryDecisionHistogram: metrics.NewHistogram(
+			metrics.NewExpDecaySample(1028, 0.015)),
 	}
 
 	ah.appQuota = int64(float64(ah.memQuota) * appRatio)
 	ah.indexQuota = int64(float64(ah.appQuota) * indexRatio)
 	ah.queryQuota = int64(float64(ah.appQuota) * queryRatio)
 
+	for _, option := range options {
+		option(ah)
+	}
+
 	ah.waitCond = sync.NewCond(&ah.m)
+	ah.queryWaitCond = sync.NewCond(&ah.m)
 
 	log.Printf("app_herder: memQuota: %d, appQuota: %d, indexQuota: %d, "+
 		"queryQuota: %d", memQuota, ah.appQuota, ah.indexQuota, ah.queryQuota)
@@ -80,26 +384,556 @@ func newAppHerder(memQuota uint64, appRatio, indexRatio,
 }
 
 func (a *appHerder) Stats() map[string]interface{} {
-	return map[string]interface{}{
+	rv := map[string]interface{}{
+		"MemoryPressure":            a.MemoryPressure(),
 		"TotWaitingIn":              atomic.LoadUint64(&cbft.TotHerderWaitingIn),
 		"TotWaitingOut":             atomic.LoadUint64(&cbft.TotHerderWaitingOut),
 		"TotOnBatchExecuteStartBeg": atomic.LoadUint64(&cbft.TotHerderOnBatchExecuteStartBeg),
 		"TotOnBatchExecuteStartEnd": atomic.LoadUint64(&cbft.TotHerderOnBatchExecuteStartEnd),
 		"TotQueriesRejected":        atomic.LoadUint64(&cbft.TotHerderQueriesRejected),
 	}
+
+	addDecisionStats(rv, "BatchDecision", a.batchDecisionHistogram)
+	addDecisionStats(rv, "QueryDecision", a.queryDecisionHistogram)
+
+	a.m.Lock()
+	// the total across all the indexes, as the quotas are checked against
+	rv["PreIndexingMemory"] = a.preIndexingMemoryLOCKED()
+	rv["WaitingBatches"] = a.waiting
+	rv["WaitingBatchesHighWater"] = a.waitingHighWater
+	rv["TotBatchWaitNS"] = a.totBatchWaitNS
+	batchWaits := make(map[string]uint64, len(a.batchWaitCounts))
+	for i, n := range a.batchWaitCounts {
+		batchWaits[batchWaitBucketLabel(i)] = n
+	}
+	rv["BatchWaits"] = batchWaits
+	if a.maxWaitingBatches > 0 {
+		rv["MaxWaitingBatches"] = a.maxWaitingBatches
+		rv["TotBatchesRejected"] = a.totBatchesRejected
+	}
+	if a.maxBatchWait > 0 {
+		rv["MaxBatchWaitNS"] = int64(a.maxBatchWait)
+		rv["TotBatchesWaitTimedOut"] = a.totBatchesWaitTimedOut
+		rv["TotBatchWaitTimeoutWakes"] = a.totBatchWaitTimeoutWakes
+	}
+	if f := a.loadMaxBatchFraction(); f > 0 {
+		rv["MaxBatchFraction"] = f
+		rv["MaxBatchBytes"] = a.maxBatchBytes()
+		rv["TotBatchesTooLarge"] = a.totBatchesTooLarge
+	}
+
+	if len(a.wakeReasons) > 0 {
+		wakeReasons := make(map[string]wakeReasonStats, len(a.wakeReasons))
+		for reason, wrs := range a.wakeReasons {
+			wakeReasons[reason] = *wrs
+		}
+		rv["WakeReasons"] = wakeReasons
+	}
+
+	if a.queryIndexFraction > 0 {
+		rv["QueryIndexFraction"] = a.queryIndexFraction
+		queryIndexes := make(map[string]indexQueryStats, len(a.queryIndexes))
+		for indexName, iqs := range a.queryIndexes {
+			queryIndexes[indexName] = *iqs
+		}
+		rv["QueryIndexes"] = queryIndexes
+		rv["TotQueriesIndexShareRejected"] = a.totQueriesIndexShareRejected
+		rv["TotQueriesOverIndexShare"] = a.totQueriesOverIndexShare
+	}
+
+	if a.queryHighPriorityReserve > 0 {
+		rv["QueryHighPriorityReserve"] = a.queryHighPriorityReserve
+		rv["TotLowPriorityQueriesRejected"] = a.totLowPriorityQueriesRejected
+	}
+
+	if a.queryDegradedMode {
+		rv["MaxDegradedQueries"] = a.maxDegradedQueries
+		rv["RunningDegradedQueries"] = a.runningDegradedQueries
+		rv["TotQueriesDegraded"] = a.totQueriesDegraded
+	}
+
+	if a.maxQueuedQueries > 0 {
+		rv["MaxQueuedQueries"] = a.maxQueuedQueries
+		rv["MaxQueryQueueWaitNS"] = int64(a.maxQueryQueueWait)
+		rv["QueuedQueries"] = len(a.queryQueue)
+		rv["QueuedQueriesHighWater"] = a.queryQueueHighWater
+		rv["TotQueryQueueWaitNS"] = a.totQueryQueueWaitNS
+		queryQueueWaits := make(map[string]uint64,
+			len(a.queryQueueWaitCounts))
+		for i, n := range a.queryQueueWaitCounts {
+			queryQueueWaits[batchWaitBucketLabel(i)] = n
+		}
+		rv["QueryQueueWaits"] = queryQueueWaits
+		rv["TotQueriesQueued"] = a.totQueriesQueued
+		rv["TotQueriesQueueAdmitted"] = a.totQueriesQueueAdmitted
+		rv["TotQueriesQueueTimedOut"] = a.totQueriesQueueTimedOut
+		rv["TotQueriesQueueFull"] = a.totQueriesQueueFull
+	}
+
+	if a.freeMemoryFloor > 0 || a.freeMemoryHeadroom > 0 {
+		rv["FreeMemoryFloor"] = a.freeMemoryFloor
+		rv["FreeMemoryHeadroom"] = a.freeMemoryHeadroom
+		rv["TotFreeMemoryBlocked"] = a.totFreeMemoryBlocked
+		rv["TotFreeMemoryRelaxed"] = a.totFreeMemoryRelaxed
+	}
+
+	if a.watchdogThreshold > 0 {
+		rv["WatchdogThresholdNS"] = int64(a.watchdogThreshold)
+		rv["TotWatchdogInterventions"] = a.totWatchdogInterventions
+	}
+
+	if a.quotaRebalanceInterval > 0 {
+		rv["QuotaRebalanceIntervalNS"] = int64(a.quotaRebalanceInterval)
+		rv["QuotaRebalanceStep"] = a.quotaRebalanceStep
+		rv["EffectiveIndexQuota"] = a.indexQuota
+		rv["EffectiveQueryQuota"] = a.queryQuota
+		rv["IndexQuotaFraction"] = a.indexQuotaFraction
+		rv["TotQuotaRebalances"] = a.totQuotaRebalances
+	}
+
+	if a.queryEstimateMaxCorrection > 0 {
+		rv["QueryEstimateMaxCorrection"] = a.queryEstimateMaxCorrection
+		corrections := make(map[string]float64,
+			len(a.queryEstimateCorrections))
+		for indexName, f := range a.queryEstimateCorrections {
+			corrections[indexName] = f
+		}
+		rv["QueryEstimateCorrections"] = corrections
+	}
+
+	if atomic.LoadInt32(&a.draining) != 0 {
+		rv["Draining"] = true
+		rv["TotDrainedReleased"] = a.totDrainedReleased
+	}
+
+	if a.queryReservedSlots > 0 {
+		rv["QueryReservedSlots"] = a.queryReservedSlots
+		rv["QueryReservedSlotsUsed"] = a.queryReservedSlotsUsed
+		rv["QueryReservedSlotSize"] = a.queryReservedSlotSize
+		rv["TotQueryReservedSlotHit"] = a.totQueryReservedSlotHit
+		rv["TotQueryReservedSlotMiss"] = a.totQueryReservedSlotMiss
+		if tot := a.totQueryReservedSlotHit + a.totQueryReservedSlotMiss; tot > 0 {
+			rv["QueryReservedSlotHitRate"] = float64(a.totQueryReservedSlotHit) /
+				float64(tot)
+		}
+	}
+	a.m.Unlock()
+
+	return rv
+}
+
+// addDecisionStats adds the p50 and p99 decision latencies (in
+// nanoseconds) of the histogram to the stats, once there are any.
+func addDecisionStats(rv map[string]interface{}, prefix string,
+	h metrics.Histogram) {
+	if h.Count() > 0 {
+		ps := h.Percentiles([]float64{0.5, 0.99})
+		rv[prefix+"P50NS"] = ps[0]
+		rv[prefix+"P99NS"] = ps[1]
+	}
+}
+
+// herderQuotas is a snapshot of the effective quotas of the appHerder,
+// along with the ratios they were derived from.
+type herderQuotas struct {
+	MemQuota   int64 `json:"memQuota"`
+	AppQuota   int64 `json:"appQuota"`
+	IndexQuota int64 `json:"indexQuota"`
+	QueryQuota int64 `json:"queryQuota"`
+
+	AppRatio   float64 `json:"appRatio"`
+	IndexRatio float64 `json:"indexRatio"`
+	QueryRatio float64 `json:"queryRatio"`
+}
+
+// Quotas returns the current effective quotas.
+func (a *appHerder) Quotas() herderQuotas {
+	a.m.Lock()
+	rv := herderQuotas{
+		MemQuota:   a.memQuota,
+		AppQuota:   a.appQuota,
+		IndexQuota: a.indexQuota,
+		QueryQuota: a.queryQuota,
+		AppRatio:   a.appRatio,
+		IndexRatio: a.indexRatio,
+		QueryRatio: a.queryRatio,
+	}
+	a.m.Unlock()
+	return rv
+}
+
+// UpdateQuota recomputes the quotas from a new memQuota and ratios,
+// which take effect right away, as the waiting batches are awoken to
+// re-evaluate against the new quotas.
+func (a *appHerder) UpdateQuota(memQuota uint64,
+	appRatio, indexRatio, queryRatio float64) error {
+	if memQuota > math.MaxInt64 {
+		return fmt.Errorf("app_herder: UpdateQuota, invalid memQuota: %d",
+			memQuota)
+	}
+	for _, ratio := range []float64{appRatio, indexRatio, queryRatio} {
+		if math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+			return fmt.Errorf("app_herder: UpdateQuota, invalid ratio: %v,"+
+				" appRatio: %v, indexRatio: %v, queryRatio: %v",
+				ratio, appRatio, indexRatio, queryRatio)
+		}
+	}
+
+	appQuota := int64(float64(memQuota) * appRatio)
+	indexQuota := int64(float64(appQuota) * indexRatio)
+	queryQuota := int64(float64(appQuota) * queryRatio)
+
+	a.m.Lock()
+	prev := herderQuotas{
+		MemQuota:   a.memQuota,
+		AppQuota:   a.appQuota,
+		IndexQuota: a.indexQuota,
+		QueryQuota: a.queryQuota,
+	}
+
+	if prev.MemQuota == int64(memQuota) && prev.AppQuota == appQuota &&
+		prev.IndexQuota == indexQuota && prev.QueryQuota == queryQuota {
+		a.m.Unlock()
+		return nil
+	}
+
+	atomic.StoreInt64(&a.memQuota, int64(memQuota))
+	atomic.StoreInt64(&a.appQuota, appQuota)
+	atomic.StoreInt64(&a.indexQuota, indexQuota)
+	atomic.StoreInt64(&a.queryQuota, queryQuota)
+	a.appRatio = appRatio
+	a.indexRatio = indexRatio
+	a.queryRatio = queryRatio
+
+	// an adaptive split restarts from the new static split
+	if a.quotaRebalanceInterval > 0 {
+		a.indexLimitHits = 0
+		a.queryLimitHits = 0
+		a.indexQuotaFraction = staticIndexQuotaFraction(indexQuota, queryQuota)
+	}
+
+	a.awakeWaitersLOCKED("quota updated")
+	goverseer := a.goverseer
+	a.m.Unlock()
+
+	if goverseer != nil {
+		goverseer.SetQuota(memQuota)
+	}
+
+	log.Printf("app_herder: quota updated, memQuota: %d -> %d,"+
+		" appQuota: %d -> %d, indexQuota: %d -> %d, queryQuota: %d -> %d",
+		prev.MemQuota, memQuota, prev.AppQuota, appQuota,
+		prev.IndexQuota, indexQuota, prev.QueryQuota, queryQuota)
+
+	a.updatePressure(a.memoryUsed())
+
+	return nil
+}
+
+// Quiesce drains the herder for the shutdown, as the batches and the
+// queries are no longer admitted, and the waiting batches are awoken
+// to exit with the errHerderDraining.  It blocks until no batches are
+// waiting or until the timeout elapses, and returns the number of the
+// waiting batches that it released, along with an error when some
+// batches were still waiting at the timeout.
+func (a *appHerder) Quiesce(timeout time.Duration) (int, error) {
+	a.m.Lock()
+	atomic.StoreInt32(&a.draining, 1)
+	releasedPrev := a.totDrainedReleased
+	a.awakeWaitersLOCKED("quiescing")
+	a.m.Unlock()
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		a.m.Lock()
+		waiting := a.waiting
+		released := int(a.totDrainedReleased - releasedPrev)
+		a.m.Unlock()
+
+		if waiting <= 0 {
+			log.Printf("app_herder: quiesced, released: %d", released)
+			return released, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			log.Warnf("app_herder: quiesce timed out, released: %d,"+
+				" still waiting: %d, timeout: %v", released, waiting, timeout)
+			return released, fmt.Errorf("app_herder: Quiesce, timed out,"+
+				" still waiting: %d", waiting)
+		}
+
+		// a waiter may have been between its checks when awoken
+		a.awakeWaiters("quiescing")
+
+		time.Sleep(quiescePollInterval)
+	}
+}
+
+// quiescePollInterval is how often Quiesce checks on the waiting
+// batches.
+var quiescePollInterval = 10 * time.Millisecond
+
+// setGoverseer sets the goverseer that's kept up to date with the
+// memQuota.
+func (a *appHerder) setGoverseer(g *Goverseer) {
+	a.m.Lock()
+	a.goverseer = g
+	a.m.Unlock()
+}
+
+// setQueryReservedSlots sets the number of reserved query slots, where 0
+// disables them.
+func (a *appHerder) setQueryReservedSlots(n int) {
+	a.m.Lock()
+	a.queryReservedSlots = n
+	a.m.Unlock()
+
+	log.Printf("app_herder: queryReservedSlots: %d", n)
+}
+
+// setQueryIndexFraction sets the fraction of the queryQuota that the
+// running queries of each index may use, where 0 disables the per
+// index admission.
+func (a *appHerder) setQueryIndexFraction(f float64) {
+	a.m.Lock()
+	a.queryIndexFraction = f
+	if a.queryIndexes == nil {
+		a.queryIndexes = map[string]*indexQueryStats{}
+	}
+	a.m.Unlock()
+
+	log.Printf("app_herder: queryIndexFraction: %v", f)
+}
+
+// setQueryHighPriorityReserve sets the fraction of the queryQuota that's
+// reserved for the high priority queries, where 0 disables the
+// reservation.
+func (a *appHerder) setQueryHighPriorityReserve(f float64) {
+	a.m.Lock()
+	a.queryHighPriorityReserve = f
+	a.m.Unlock()
+
+	log.Printf("app_herder: queryHighPriorityReserve: %v", f)
+}
+
+// setQueryDegradedMode sets whether the queries that may degrade are
+// admitted in the degraded mode, rather than rejected, once over the
+// queryQuota.
+func (a *appHerder) setQueryDegradedMode(b bool) {
+	a.m.Lock()
+	a.queryDegradedMode = b
+	a.m.Unlock()
+
+	log.Printf("app_herder: queryDegradedMode: %t", b)
+}
+
+// setMaxDegradedQueries sets how many queries may run in the degraded
+// mode at a time, past which the queries are rejected as usual.
+func (a *appHerder) setMaxDegradedQueries(n int) {
+	a.m.Lock()
+	a.maxDegradedQueries = n
+	a.m.Unlock()
+
+	log.Printf("app_herder: maxDegradedQueries: %d", n)
+}
+
+// setQueryEstimateMaxCorrection sets the bound of the correction
+// factors of the query estimates, which is at least 1, where 0
+// disables the correction.
+func (a *appHerder) setQueryEstimateMaxCorrection(f float64) {
+	a.m.Lock()
+	a.queryEstimateMaxCorrection = f
+	if f <= 0 {
+		a.queryEstimateCorrections = nil
+	} else if a.queryEstimateCorrections == nil {
+		a.queryEstimateCorrections = map[string]float64{}
+	}
+	a.m.Unlock()
+
+	log.Printf("app_herder: queryEstimateMaxCorrection: %v", f)
+}
+
+// freeMemorySampleInterval is how often the available system memory
+// is sampled while the free memory bounds are set.
+var freeMemorySampleInterval = time.Second
+
+// setFreeMemoryBounds sets the floor of the available system memory,
+// below which the indexing waits, and the headroom, at or above which
+// the indexing may exceed the indexQuota, where 0 disables either.
+func (a *appHerder) setFreeMemoryBounds(floor, headroom uint64) {
+	enabled := floor > 0 || headroom > 0
+	if enabled {
+		if err := a.sampleAvailableMemory(); err != nil {
+			log.Warnf("app_herder: available memory unknown, the free"+
+				" memory bounds won't apply, err: %v", err)
+		}
+	}
+
+	a.m.Lock()
+	if a.freeMemoryStopCh != nil {
+		close(a.freeMemoryStopCh)
+		a.freeMemoryStopCh = nil
+	}
+	a.freeMemoryFloor = floor
+	a.freeMemoryHeadroom = headroom
+	if enabled {
+		a.freeMemoryStopCh = make(chan struct{})
+		go a.runAvailableMemorySampler(freeMemorySampleInterval,
+			a.freeMemoryStopCh)
+	}
+	a.m.Unlock()
+
+	log.Printf("app_herder: freeMemoryFloor: %d, freeMemoryHeadroom: %d",
+		floor, headroom)
+}
+
+func (a *appHerder) runAvailableMemorySampler(interval time.Duration,
+	stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			a.sampleAvailableMemory()
+		}
+	}
+}
+
+// sampleAvailableMemory samples the available system memory, without
+// the m held, as reading it may be slow.
+func (a *appHerder) sampleAvailableMemory() error {
+	available, err := a.availableMemory()
+	if err != nil || available > math.MaxInt64 {
+		atomic.StoreInt64(&a.availableMemorySample, -1)
+		return err
+	}
+	atomic.StoreInt64(&a.availableMemorySample, int64(available))
+	return nil
+}
+
+// setMaxWaitingBatches sets the max number of batches that may wait
+// on the memory quota, where 0 means no max.
+func (a *appHerder) setMaxWaitingBatches(n int) {
+	a.m.Lock()
+	a.maxWaitingBatches = n
+	a.m.Unlock()
+
+	log.Printf("app_herder: maxWaitingBatches: %d", n)
+}
+
+// setMaxBatchWait sets the max duration that a batch may wait on the
+// memory quota, where 0 means no max.
+func (a *appHerder) setMaxBatchWait(d time.Duration) {
+	a.m.Lock()
+	a.maxBatchWait = d
+	a.m.Unlock()
+
+	log.Printf("app_herder: maxBatchWait: %v", d)
+}
+
+// setMaxBatchFraction sets the fraction of the indexQuota that a single
+// batch may use, where 0 means no max.
+func (a *appHerder) setMaxBatchFraction(f float64) {
+	atomic.StoreUint64(&a.maxBatchFraction, math.Float64bits(f))
+
+	log.Printf("app_herder: maxBatchFraction: %v", f)
+}
+
+func (a *appHerder) loadMaxBatchFraction() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&a.maxBatchFraction))
+}
+
+// maxBatchBytes returns the max bytes of a single batch, as the
+// maxBatchFraction of the current indexQuota, where 0 means no max.
+func (a *appHerder) maxBatchBytes() uint64 {
+	f := a.loadMaxBatchFraction()
+	indexQuota := atomic.LoadInt64(&a.indexQuota)
+	if f <= 0 || indexQuota <= 0 {
+		return 0
+	}
+	return uint64(float64(indexQuota) * f)
+}
+
+// MemoryPressure returns the memory pressure, from 0 to 100, as of the
+// last herder event, which is the memory used by the process as a
+// percentage of the appQuota, capped at 100.  So, 100 means that the
+// appQuota is reached and the batches and queries are throttled, while
+// the values approaching 100 allow for slowing down ahead of that.  As
+// the indexQuota and queryQuota are portions of the appQuota, their
+// throttling may kick in at lower values.  It's always 0 when the
+// appQuota is disabled.
+func (a *appHerder) MemoryPressure() int {
+	return int(atomic.LoadUint32(&a.pressure))
+}
+
+// updatePressure updates the memory pressure with the given memory
+// used by the process.
+func (a *appHerder) updatePressure(memUsed uint64) {
+	var pressure uint64
+	if appQuota := atomic.LoadInt64(&a.appQuota); appQuota > 0 {
+		pressure = memUsed * 100 / uint64(appQuota)
+		if pressure > 100 {
+			pressure = 100
+		}
+	}
+	atomic.StoreUint32(&a.pressure, uint32(pressure))
+}
+
+// subscribeOverQuota subscribes the ch to the over-quota signals of
+// the herder, and returns the func that ends the subscription.  The
+// signals are sent without blocking, so a subscriber that's behind
+// misses signals rather than stalling the herder.
+func (a *appHerder) subscribeOverQuota(ch chan struct{}) func() {
+	a.overQuotaSubsM.Lock()
+	if a.overQuotaSubs == nil {
+		a.overQuotaSubs = map[chan struct{}]struct{}{}
+	}
+	a.overQuotaSubs[ch] = struct{}{}
+	a.overQuotaSubsM.Unlock()
+
+	return func() {
+		a.overQuotaSubsM.Lock()
+		delete(a.overQuotaSubs, ch)
+		a.overQuotaSubsM.Unlock()
+	}
+}
+
+// signalOverQuota signals the overQuotaCh, if any, and the subscribers
+// that the memory quota is exceeded.
+func (a *appHerder) signalOverQuota() {
+	if a.overQuotaCh != nil {
+		a.overQuotaCh <- struct{}{}
+	}
+
+	a.overQuotaSubsM.Lock()
+	for ch := range a.overQuotaSubs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	a.overQuotaSubsM.Unlock()
 }
 
 // *** Indexing Callbacks
 
 func (a *appHerder) onClose(c interface{}) {
+	a.updatePressure(a.memoryUsed())
+
 	a.m.Lock()
 	delete(a.indexes, c)
+	a.lastProgress = time.Now()
 	a.awakeWaitersLOCKED("closing index")
 	a.m.Unlock()
 }
 
 func (a *appHerder) onMemoryUsedDropped(curMemoryUsed, prevMemoryUsed uint64) {
-	a.awakeWaiters("memory used dropped")
+	a.updatePressure(curMemoryUsed)
+	a.awakeWaitersOnProgress("memory used dropped")
 }
 
 func (a *appHerder) awakeWaiters(msg string) {
@@ -108,39 +942,167 @@ func (a *appHerder) awakeWaiters(msg string) {
 	a.m.Unlock()
 }
 
+// awakeWaitersOnProgress is awakeWaiters for the progress events, which
+// also hold off the stall watchdog.
+func (a *appHerder) awakeWaitersOnProgress(msg string) {
+	a.m.Lock()
+	a.lastProgress = time.Now()
+	a.awakeWaitersLOCKED(msg)
+	a.m.Unlock()
+}
+
 func (a *appHerder) awakeWaitersLOCKED(msg string) {
+	if len(a.queryQueue) > 0 {
+		a.queryWaitCond.Broadcast()
+	}
+
 	if a.waiting > 0 {
 		log.Printf("app_herder: %s, indexes: %d, waiting: %d", msg,
 			len(a.indexes), a.waiting)
 
+		wrs := a.wakeReasons[msg]
+		if wrs == nil {
+			wrs = &wakeReasonStats{}
+			a.wakeReasons[msg] = wrs
+		}
+		wrs.TotAwoken += uint64(a.waiting)
+		a.lastWakeReason = msg
+
+		a.waitCond.Broadcast()
+	}
+}
+
+// timedWaitLOCKED waits on the waitCond until awoken or until the
+// deadline, where a zero deadline means no timeout.
+func (a *appHerder) timedWaitLOCKED(deadline time.Time) {
+	if deadline.IsZero() {
+		a.waitCond.Wait()
+		return
+	}
+
+	d := time.Until(deadline)
+	if d <= 0 {
+		return
+	}
+
+	timer := time.AfterFunc(d, a.awakeWaitersOnTimeout)
+	a.waitCond.Wait()
+	timer.Stop()
+}
+
+// awakeWaitersOnTimeout wakes the waiters once the wait of a batch has
+// timed out, which is only counted, and not recorded as the last wake
+// reason, so that the other waiters' outcomes aren't attributed to it.
+func (a *appHerder) awakeWaitersOnTimeout() {
+	a.m.Lock()
+	a.totBatchWaitTimeoutWakes++
+	if a.waiting > 0 {
 		a.waitCond.Broadcast()
 	}
+	a.m.Unlock()
+}
+
+// onBatchExecuteStart waits while indexing is over the memory quota,
+// and gives up the wait with the ctx's error if the ctx is done first.
+// When the max number of batches are already waiting, it fails fast
+// with errTooManyWaitingBatches instead of joining the wait.  When the
+// wait exceeds the maxBatchWait, the batch proceeds anyway.
+func (a *appHerder) onBatchExecuteStart(ctx context.Context,
+	c interface{}, s sizeFunc) error {
+	return a.onBatchBytesExecuteStart(ctx, c, s, 0)
 }
 
-func (a *appHerder) onBatchExecuteStart(c interface{}, s sizeFunc) {
+// onBatchBytesExecuteStart is like onBatchExecuteStart, for a batch of
+// the given bytes, which are already accounted in flight for the index
+// c, where the batch also waits while the other batches in flight for
+// the index exceed its share of the indexQuota, and 0 bytes skip that.
+func (a *appHerder) onBatchBytesExecuteStart(ctx context.Context,
+	c interface{}, s sizeFunc, batchBytes uint64) error {
 	// negative means ignore both appQuota and indexQuota and let the
 	// incoming batch proceed.  A zero indexQuota means ignore the
 	// indexQuota, but continue to check the appQuota for incoming
 	// batches.
-	if a.indexQuota < 0 {
-		return
+	if atomic.LoadInt32(&a.draining) != 0 {
+		return errHerderDraining
+	}
+
+	if atomic.LoadInt64(&a.indexQuota) < 0 {
+		return nil
 	}
 
 	atomic.AddUint64(&cbft.TotHerderOnBatchExecuteStartBeg, 1)
 
+	a.updatePressure(a.memoryUsed())
+
+	// a sync.Cond can't wait on a channel, so wake up the waiters
+	// when the ctx is done and let them recheck the ctx.
+	if ctx.Done() != nil {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				a.awakeWaiters("batch context done")
+			case <-stopCh:
+			}
+		}()
+	}
+
+	decisionStart := time.Now()
+	var waited time.Duration
+
 	a.m.Lock()
 
 	a.indexes[c] = s
 
+	var err error
 	wasWaiting := false
+	timedOut := false
+	var waitStart, deadline time.Time
 	var memUsedPrev, pimPrev, waitingPrev, indexesPrev int64
-	isOverQuota, preIndexingMemory, memUsed := a.overMemQuotaForIndexingLOCKED()
+	isOverQuota, preIndexingMemory, memUsed, freeMemory :=
+		a.overMemQuotaForIndexingLOCKED(c, batchBytes)
+	freeMemoryBlockedBatch := false
 
 	for isOverQuota {
+		if freeMemory == freeMemoryBlocked && !freeMemoryBlockedBatch {
+			freeMemoryBlockedBatch = true
+			a.totFreeMemoryBlocked++
+		}
+
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		if atomic.LoadInt32(&a.draining) != 0 {
+			err = errHerderDraining
+			break
+		}
+
+		if !wasWaiting {
+			if a.maxWaitingBatches > 0 && a.waiting >= a.maxWaitingBatches {
+				a.totBatchesRejected++
+				err = errTooManyWaitingBatches
+				break
+			}
+			waitStart = time.Now()
+			a.indexLimitHits++
+			// the stall is timed from the first of the waiting batches
+			if a.waiting == 0 {
+				a.lastProgress = waitStart
+			}
+			if a.maxBatchWait > 0 {
+				deadline = waitStart.Add(a.maxBatchWait)
+			}
+		}
 		wasWaiting = true
 
 		atomic.AddUint64(&cbft.TotHerderWaitingIn, 1)
 		a.waiting++
+		if a.waiting > a.waitingHighWater {
+			a.waitingHighWater = a.waiting
+		}
 
 		// If we're over the memory quota, then wait for persister,
 		// query or other progress.
@@ -153,11 +1115,13 @@ func (a *appHerder) onBatchExecuteStart(c interface{}, s sizeFunc) {
 				memUsed, preIndexingMemory, len(a.indexes), a.waiting)
 		}
 
-		if a.overQuotaCh != nil {
-			a.overQuotaCh <- struct{}{}
-		}
+		waitBeg := time.Now()
 
-		a.waitCond.Wait()
+		a.signalOverQuota()
+
+		a.timedWaitLOCKED(deadline)
+
+		waited += time.Since(waitBeg)
 
 		// remember the previous values
 		memUsedPrev = memUsed
@@ -168,17 +1132,140 @@ func (a *appHerder) onBatchExecuteStart(c interface{}, s sizeFunc) {
 		a.waiting--
 		atomic.AddUint64(&cbft.TotHerderWaitingOut, 1)
 
-		isOverQuota, preIndexingMemory, memUsed = a.overMemQuotaForIndexingLOCKED()
+		if atomic.LoadInt32(&a.draining) != 0 {
+			a.totDrainedReleased++
+			err = errHerderDraining
+			break
+		}
+
+		isOverQuota, preIndexingMemory, memUsed, freeMemory =
+			a.overMemQuotaForIndexingLOCKED(c, batchBytes)
+
+		if isOverQuota && !deadline.IsZero() && !time.Now().Before(deadline) {
+			a.totBatchesWaitTimedOut++
+			timedOut = true
+			break
+		}
+
+		if wrs := a.wakeReasons[a.lastWakeReason]; wrs != nil {
+			if isOverQuota {
+				wrs.TotRewaited++
+			} else {
+				wrs.TotProceeded++
+				wrs.TotProceededWaitNS += uint64(time.Since(waitStart))
+			}
+		}
 	}
 
-	if wasWaiting {
+	if err == nil && !isOverQuota && freeMemory == freeMemoryRelaxed {
+		a.totFreeMemoryRelaxed++
+	}
+
+	if err == errHerderDraining {
+		log.Printf("app_herder: indexing released, draining, indexes: %d,"+
+			" waiting: %d", len(a.indexes), a.waiting)
+	} else if err == errTooManyWaitingBatches {
+		log.Printf("app_herder: indexing rejected, indexes: %d,"+
+			" waiting: %d, maxWaitingBatches: %d", len(a.indexes), a.waiting,
+			a.maxWaitingBatches)
+	} else if err != nil {
+		log.Printf("app_herder: indexing wait cancelled, indexes: %d,"+
+			" waiting: %d, err: %v", len(a.indexes), a.waiting, err)
+	} else if timedOut {
+		log.Printf("app_herder: indexing wait timed out, proceeding over"+
+			" indexQuota: %d, indexes: %d, waiting: %d, maxBatchWait: %v",
+			a.indexQuota, len(a.indexes), a.waiting, a.maxBatchWait)
+	} else if wasWaiting {
 		log.Printf("app_herder: indexing proceeding, indexes: %d, waiting: %d, usage: %v",
-			len(a.indexes), a.waiting, cbft.FetchCurMemoryUsed())
+			len(a.indexes), a.waiting, a.memoryUsed())
+	}
+
+	if wasWaiting {
+		a.recordBatchWaitLOCKED(waited)
 	}
 
 	a.m.Unlock()
 
+	a.batchDecisionHistogram.Update(int64(time.Since(decisionStart) - waited))
+
 	atomic.AddUint64(&cbft.TotHerderOnBatchExecuteStartEnd, 1)
+
+	return err
+}
+
+// BatchAdmission returns the callback that admits the batches ahead of
+// their execution, see onBatchExecuteStart, for the indexes of the
+// app_herder's events, where the batches of the other indexes are
+// admitted right away.  The errors are to be honoured by not executing
+// the batch, or by retrying it, see cbft.BatchRejectedError.
+func (a *appHerder) BatchAdmission() func(context.Context,
+	interface{}, uint64) error {
+	return func(ctx context.Context, c interface{}, bytes uint64) error {
+		s := batchSizeFunc(c)
+		if s == nil {
+			return nil
+		}
+		// a batch that's too large is split before it waits on the quota
+		if err := a.checkBatchSize(bytes); err != nil {
+			return err
+		}
+		return a.onBatchBytesExecuteStart(ctx, c, s, bytes)
+	}
+}
+
+// batchSizeFunc returns the sizeFunc of the index of the app_herder's
+// events, or nil for the other indexes, which include the moss
+// collections without a lower level, as their events are ignored, see
+// onMossEvent.
+func batchSizeFunc(c interface{}) sizeFunc {
+	switch c := c.(type) {
+	case moss.Collection:
+		if c.Options().LowerLevelUpdate == nil {
+			return nil
+		}
+		return mossSize
+	case scorchIndex:
+		return scorchSize
+	}
+	return nil
+}
+
+// onBatchIntroduced accounts the index of a batch that's being
+// executed, which was admitted ahead by the BatchAdmission.
+func (a *appHerder) onBatchIntroduced(c interface{}, s sizeFunc) {
+	a.m.Lock()
+	a.indexes[c] = s
+	a.m.Unlock()
+}
+
+// checkBatchSize returns the cbft.ErrBatchTooLarge when the bytes of
+// the docs of a batch exceed the maxBatchBytes, which the caller is to
+// handle by executing the batch in smaller chunks, as the indexing does
+// ahead via the cbft.MaxBatchBytes.
+func (a *appHerder) checkBatchSize(bytes uint64) error {
+	max := a.maxBatchBytes()
+	if max == 0 || bytes <= max {
+		return nil
+	}
+
+	a.m.Lock()
+	a.totBatchesTooLarge++
+	a.m.Unlock()
+
+	log.Printf("app_herder: indexing rejected, batch too large, size: %d,"+
+		" maxBatchBytes: %d", bytes, max)
+
+	return cbft.ErrBatchTooLarge
+}
+
+// recordBatchWaitLOCKED adds the time a batch was blocked to the batch
+// wait histogram.
+func (a *appHerder) recordBatchWaitLOCKED(waited time.Duration) {
+	i := sort.Search(len(batchWaitBuckets), func(i int) bool {
+		return waited <= batchWaitBuckets[i]
+	})
+	a.batchWaitCounts[i]++
+	a.totBatchWaitNS += uint64(waited)
 }
 
 func (a *appHerder) indexingMemoryLOCKED() (rv uint64) {
@@ -189,6 +1276,9 @@ func (a *appHerder) indexingMemoryLOCKED() (rv uint64) {
 	return
 }
 
+// preIndexingMemoryLOCKED returns the bytes of the docs in the batches
+// being executed on all the indexes, see overIndexShareLOCKED for the
+// bytes of a single index.
 func (a *appHerder) preIndexingMemoryLOCKED() (rv uint64) {
 	// account for overhead from documents in batches
 	rv += atomic.LoadUint64(&cbft.BatchBytesAdded) -
@@ -197,37 +1287,89 @@ func (a *appHerder) preIndexingMemoryLOCKED() (rv uint64) {
 	return
 }
 
-func (a *appHerder) overMemQuotaForIndexingLOCKED() (bool, int64, int64) {
+// How the free memory bounds decided a check of the quotas, if at all.
+const (
+	freeMemoryUnused = iota
+	freeMemoryBlocked
+	freeMemoryRelaxed
+)
+
+// overMemQuotaForIndexingLOCKED returns whether a batch of the index c,
+// of the batchBytes, is over the quotas, or the batches in flight for
+// the index over its share of the indexQuota, along with the
+// pre-indexing memory, the memory used, and how the free memory bounds
+// decided it.
+func (a *appHerder) overMemQuotaForIndexingLOCKED(c interface{},
+	batchBytes uint64) (bool, int64, int64, int) {
 	// MB-29504 workaround to try and prevent indexing from becoming completely
 	// stuck.  The thinking is that if the indexing memUsed is 0, all data has
 	// been flushed to disk, and we should allow it to proceed (even if we're
 	// over quota in the bigger picture)
 	if a.indexingMemoryLOCKED() == 0 {
-		return false, 0, 0
+		return false, 0, 0, freeMemoryUnused
 	}
 
 	// fetch memory used by process
-	memUsed := int64(cbft.FetchCurMemoryUsed())
+	memUsed := int64(a.memoryUsed())
 
 	// now account for the overhead from documents ready in batches
-	// but not yet executed
+	// but not yet executed, across all the indexes, as the quotas are
 	preIndexingMemory := int64(a.preIndexingMemoryLOCKED())
 	memUsed += preIndexingMemory // TODO: NOTE: this is perhaps double-counting
 
+	overAppQuota := a.appQuota > 0 && memUsed > a.appQuota
+
 	// make sure indexing doesn't exceed the index portion of the quota
-	if a.indexQuota > 0 && memUsed > a.indexQuota {
-		return true, preIndexingMemory, memUsed
+	overIndexQuota := a.indexQuota > 0 && memUsed > a.indexQuota
+
+	// nor that the batches of one index crowd out the others
+	overIndexShare := a.overIndexShareLOCKED(c, batchBytes)
+
+	// the available system memory may override the indexQuota, either
+	// way, but never the appQuota
+	if !overAppQuota && (a.freeMemoryFloor > 0 || a.freeMemoryHeadroom > 0) {
+		if sample := atomic.LoadInt64(&a.availableMemorySample); sample >= 0 {
+			available := uint64(sample)
+			if a.freeMemoryFloor > 0 && available < a.freeMemoryFloor {
+				return true, preIndexingMemory, memUsed, freeMemoryBlocked
+			}
+			if overIndexQuota && a.freeMemoryHeadroom > 0 &&
+				available >= a.freeMemoryHeadroom {
+				return false, preIndexingMemory, memUsed, freeMemoryRelaxed
+			}
+		}
+	}
+
+	return overIndexQuota || overAppQuota || overIndexShare,
+		preIndexingMemory, memUsed, freeMemoryUnused
+}
+
+// overIndexShareLOCKED returns whether the batches in flight for the
+// index c, other than the one of the batchBytes, are already there,
+// and along with it exceed the index's even share of the indexQuota.
+func (a *appHerder) overIndexShareLOCKED(c interface{},
+	batchBytes uint64) bool {
+	if c == nil || batchBytes == 0 || a.indexQuota <= 0 ||
+		len(a.indexes) <= 1 {
+		return false
+	}
+
+	inFlight, known := a.batchBytesInFlight(c)
+	if !known || inFlight <= batchBytes {
+		return false
 	}
 
-	return a.appQuota > 0 && memUsed > a.appQuota, preIndexingMemory, memUsed
+	return int64(inFlight) > a.indexQuota/int64(len(a.indexes))
 }
 
 func (a *appHerder) onPersisterProgress() {
-	a.awakeWaiters("persister progress")
+	a.updatePressure(a.memoryUsed())
+	a.awakeWaitersOnProgress("persister progress")
 }
 
 func (a *appHerder) onMergerProgress() {
-	a.awakeWaiters("merger progress")
+	a.updatePressure(a.memoryUsed())
+	a.awakeWaitersOnProgress("merger progress")
 }
 
 // *** Query Interface
@@ -236,10 +1378,10 @@ func (a *appHerder) queryHerderOnEvent() func(int, cbft.QueryEvent, uint64) erro
 	return func(depth int, event cbft.QueryEvent, size uint64) error {
 		switch event.Kind {
 		case cbft.EventQueryStart:
-			return a.onQueryStart(depth, size)
+			return a.onQueryStart(depth, event, size)
 
 		case cbft.EventQueryEnd:
-			return a.onQueryEnd(depth, size)
+			return a.onQueryEnd(depth, event, size)
 
 		default:
 			return nil
@@ -247,17 +1389,108 @@ func (a *appHerder) queryHerderOnEvent() func(int, cbft.QueryEvent, uint64) erro
 	}
 }
 
-func (a *appHerder) onQueryStart(depth int, size uint64) error {
+// indexQueryStatsLOCKED returns the stats of the index of a top level
+// query, or nil when the queries aren't admitted per index.  The stats
+// of an index without running queries are only kept once its query is
+// admitted, see admitIndexQueryLOCKED.
+func (a *appHerder) indexQueryStatsLOCKED(depth int,
+	indexName string) *indexQueryStats {
+	if depth != 0 || indexName == "" || a.queryIndexFraction <= 0 {
+		return nil
+	}
+
+	iqs := a.queryIndexes[indexName]
+	if iqs == nil {
+		iqs = &indexQueryStats{}
+	}
+	return iqs
+}
+
+// admitIndexQueryLOCKED accounts an admitted query in the stats of its
+// index, if any.
+func (a *appHerder) admitIndexQueryLOCKED(indexName string,
+	iqs *indexQueryStats, size uint64) {
+	if iqs == nil {
+		return
+	}
+	iqs.RunningQueries++
+	iqs.RunningQueryUsed += size
+	a.queryIndexes[indexName] = iqs
+}
+
+// otherIndexesIdleLOCKED returns true when no index other than the
+// given one has running queries.
+func (a *appHerder) otherIndexesIdleLOCKED(indexName string) bool {
+	for name, iqs := range a.queryIndexes {
+		if name != indexName && iqs.RunningQueryUsed > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// onQueryStart admits or rejects a query right away, unless the query
+// queue is enabled, where the queries over the queryQuota wait in the
+// order of their arrival, see awaitQueryQuotaLOCKED.  There's no
+// ordering among the queries of different priorities other than the
+// low priority ones being rejected at a lower memory usage than the
+// high priority ones, which are never rejected by the reservation.
+func (a *appHerder) onQueryStart(depth int, event cbft.QueryEvent,
+	size uint64) error {
 	// negative queryQuota means ignore both appQuota and queryQuota
 	// and let the incoming query proceed.  A zero queryQuota means
 	// ignore the queryQuota, but continue to check the appQuota for
 	// incoming queries.
-	if a.queryQuota < 0 {
+	if atomic.LoadInt32(&a.draining) != 0 {
+		return errQueryHerderDraining
+	}
+
+	if atomic.LoadInt64(&a.queryQuota) < 0 {
 		return nil
 	}
 
+	a.updatePressure(a.memoryUsed())
+
+	decisionStart := time.Now()
+
 	a.m.Lock()
 
+	iqs := a.indexQueryStatsLOCKED(depth, event.IndexName)
+
+	if depth == 0 && a.queryReservedSlots > 0 {
+		// the query takes over the reservation of a free slot that it
+		// fits, as long as it's within the queryQuota and the appQuota
+		slotSize := a.queryReservedSlotSize
+		memUsed := a.queryMemUsedLOCKED(size) - int64(slotSize)
+		hit := event.Admission != nil &&
+			a.queryReservedSlotsUsed < a.queryReservedSlots &&
+			size <= slotSize &&
+			(a.queryQuota <= 0 || memUsed <= a.queryQuota) &&
+			(a.appQuota <= 0 || memUsed <= a.appQuota)
+
+		// follow the recent query estimates, slowly decaying the
+		// slot size when the queries get smaller
+		if size > a.queryReservedSlotSize {
+			a.queryReservedSlotSize = size
+		} else {
+			a.queryReservedSlotSize -= (a.queryReservedSlotSize - size) / 16
+		}
+
+		if hit {
+			a.queryReservedSlotsUsed++
+			event.Admission.ReservedSlot = true
+			a.totQueryReservedSlotHit++
+			a.runningQueryUsed += size
+			a.admitIndexQueryLOCKED(event.IndexName, iqs, size)
+
+			a.m.Unlock()
+			a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+			return nil
+		}
+
+		a.totQueryReservedSlotMiss++
+	}
+
 	// MB-30954 - similar to logic for indexing / MB-29504 (see:
 	// overMemQuotaForIndexingLOCKED) -- this workaround tries to
 	// prevent querying from becoming completely stuck, on the
@@ -265,27 +1498,93 @@ func (a *appHerder) onQueryStart(depth int, size uint64) error {
 	// yet, then allow a single query to proceed (even if we're over
 	// quota in the bigger picture).
 	if depth == 0 && a.runningQueryUsed > 0 {
-		// fetch memory used by process
-		memUsed := int64(cbft.FetchCurMemoryUsed())
-
-		// now account for overhead from the current query
-		memUsed += int64(size)
+		memUsed := a.queryMemUsedLOCKED(size)
+
+		// reject the low priority queries early, keeping the rest of
+		// the queryQuota for the high priority queries
+		if event.Priority == cbft.QueryPriorityLow &&
+			a.queryQuota > 0 && a.queryHighPriorityReserve > 0 {
+			threshold := int64(float64(a.queryQuota) *
+				(1 - a.queryHighPriorityReserve))
+			if memUsed > threshold {
+				log.Printf("app_herder: low priority querying over threshold: %d,"+
+					" estimated size: %d, runningQueryUsed: %d, memUsed: %d",
+					threshold, size, a.runningQueryUsed, memUsed)
+
+				a.totLowPriorityQueriesRejected++
+				a.queryLimitHits++
+
+				a.m.Unlock()
+				a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+
+				atomic.AddUint64(&cbft.TotHerderQueriesRejected, 1)
+				return &cbft.QueryRejectedError{
+					Limit:    cbft.QueryRejectLimitLowPriority,
+					Quota:    threshold,
+					Estimate: size,
+					Usage:    memUsed,
+				}
+			}
+		}
 
 		// first make sure querying (on it's own) doesn't exceed the
 		// query portion of the quota
+		if a.queryQuota > 0 && memUsed > a.queryQuota {
+			a.queryLimitHits++
+
+			// unless the appQuota is exceeded too, degrade rather than
+			// reject the queries that may degrade
+			if a.queryDegradedMode && event.CanDegrade &&
+				a.runningDegradedQueries < a.maxDegradedQueries &&
+				(a.appQuota <= 0 || memUsed <= a.appQuota) {
+				log.Printf("app_herder: querying over queryQuota: %d, degraded,"+
+					" estimated size: %d, runningQueryUsed: %d, memUsed: %d",
+					a.queryQuota, size, a.runningQueryUsed, memUsed)
+
+				a.runningDegradedQueries++
+				a.totQueriesDegraded++
+				a.runningQueryUsed += size
+				a.admitIndexQueryLOCKED(event.IndexName, iqs, size)
+
+				a.m.Unlock()
+				a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+
+				a.signalOverQuota()
+
+				return cbft.ErrQueryDegraded
+			}
+
+			// wait in the query queue for the running queries to end,
+			// when enabled, rather than reject the query right away,
+			// where the wait isn't part of the decision latency
+			var waited time.Duration
+			memUsed, waited = a.awaitQueryQuotaLOCKED(size, memUsed)
+			decisionStart = decisionStart.Add(waited)
+		}
+
+		if atomic.LoadInt32(&a.draining) != 0 {
+			a.m.Unlock()
+			a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+			return errQueryHerderDraining
+		}
+
 		if a.queryQuota > 0 && memUsed > a.queryQuota {
 			log.Printf("app_herder: querying over queryQuota: %d,"+
 				" estimated size: %d, runningQueryUsed: %d, memUsed: %d",
 				a.queryQuota, size, a.runningQueryUsed, memUsed)
 
 			a.m.Unlock()
+			a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
 
-			if a.overQuotaCh != nil {
-				a.overQuotaCh <- struct{}{}
-			}
+			a.signalOverQuota()
 
 			atomic.AddUint64(&cbft.TotHerderQueriesRejected, 1)
-			return rest.ErrorQueryReqRejected
+			return &cbft.QueryRejectedError{
+				Limit:    cbft.QueryRejectLimitQuery,
+				Quota:    a.queryQuota,
+				Estimate: size,
+				Usage:    memUsed,
+			}
 		}
 
 		if a.appQuota > 0 && memUsed > a.appQuota {
@@ -294,24 +1593,109 @@ func (a *appHerder) onQueryStart(depth int, size uint64) error {
 				a.appQuota, size, a.runningQueryUsed, memUsed)
 
 			a.m.Unlock()
+			a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+
+			a.signalOverQuota()
 
-			if a.overQuotaCh != nil {
-				a.overQuotaCh <- struct{}{}
+			return &cbft.QueryRejectedError{
+				Limit:    cbft.QueryRejectLimitApp,
+				Quota:    a.appQuota,
+				Estimate: size,
+				Usage:    memUsed,
 			}
+		}
 
-			return rest.ErrorQueryReqRejected
+		// lastly make sure the index stays within its share of the
+		// queryQuota, unless the other indexes are idle, in which
+		// case the overall quotas above suffice
+		if iqs != nil && a.queryQuota > 0 {
+			share := uint64(float64(a.queryQuota) * a.queryIndexFraction)
+			if iqs.RunningQueryUsed+size > share {
+				if !a.otherIndexesIdleLOCKED(event.IndexName) {
+					log.Printf("app_herder: querying over index share: %d,"+
+						" index: %s, estimated size: %d, index running: %d",
+						share, event.IndexName, size, iqs.RunningQueryUsed)
+
+					iqs.TotQueriesRejected++
+					a.totQueriesIndexShareRejected++
+
+					a.m.Unlock()
+					a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
+
+					atomic.AddUint64(&cbft.TotHerderQueriesRejected, 1)
+					return &cbft.QueryRejectedError{
+						Limit:    cbft.QueryRejectLimitIndexShare,
+						Quota:    int64(share),
+						Estimate: size,
+						Usage:    int64(iqs.RunningQueryUsed + size),
+					}
+				}
+
+				iqs.TotQueriesOverShare++
+				a.totQueriesOverIndexShare++
+			}
 		}
 	}
 
 	// record the addition
 	a.runningQueryUsed += size
+	a.admitIndexQueryLOCKED(event.IndexName, iqs, size)
 
 	a.m.Unlock()
+	a.queryDecisionHistogram.Update(int64(time.Since(decisionStart)))
 	return nil
 }
 
-func (a *appHerder) onQueryEnd(depth int, size uint64) error {
+// queryMemUsedLOCKED returns the memory used by the process, along with
+// the estimated size of the query and the memory reserved by the free
+// query slots, as the queries in the used slots are already part of
+// the memory used.
+func (a *appHerder) queryMemUsedLOCKED(size uint64) int64 {
+	rv := int64(a.memoryUsed()) + int64(size)
+	if free := a.queryReservedSlots - a.queryReservedSlotsUsed; free > 0 {
+		rv += int64(free) * int64(a.queryReservedSlotSize)
+	}
+	return rv
+}
+
+// onQueryEnd releases a query, which is regardless of its priority,
+// as the accounting is by size.
+func (a *appHerder) onQueryEnd(depth int, event cbft.QueryEvent,
+	size uint64) error {
+	a.updatePressure(a.memoryUsed())
+
 	a.m.Lock()
+	if depth == 0 && event.Degraded {
+		// the query ran in the degraded mode
+		a.runningDegradedQueries--
+	}
+
+	if depth == 0 && event.ResultSize > 0 {
+		a.recordQueryResultSizeLOCKED(event.IndexName, size,
+			event.ResultSize)
+	}
+
+	// regardless of the queryIndexFraction, as it may have been reset
+	// while the queries of the index ran
+	if iqs := a.queryIndexes[event.IndexName]; depth == 0 && iqs != nil {
+		if iqs.RunningQueries <= 1 {
+			delete(a.queryIndexes, event.IndexName)
+		} else {
+			iqs.RunningQueries--
+			if iqs.RunningQueryUsed >= size {
+				iqs.RunningQueryUsed -= size
+			} else {
+				iqs.RunningQueryUsed = 0
+			}
+		}
+	}
+
+	if depth == 0 && event.Admission != nil && event.Admission.ReservedSlot {
+		// the query ran in a reserved slot
+		event.Admission.ReservedSlot = false
+		a.queryReservedSlotsUsed--
+	}
+
 	a.runningQueryUsed -= size
 	a.awakeWaitersLOCKED("query ended")
 	a.m.Unlock()
@@ -319,6 +1703,50 @@ func (a *appHerder) onQueryEnd(depth int, size uint64) error {
 	return nil
 }
 
+// correctQueryEstimate returns the estimate of a query of the index,
+// scaled by the index's correction factor, if any.
+func (a *appHerder) correctQueryEstimate(indexName string,
+	size uint64) uint64 {
+	a.m.Lock()
+	f, exists := a.queryEstimateCorrections[indexName]
+	a.m.Unlock()
+
+	if !exists || size == 0 {
+		return size
+	}
+
+	if rv := uint64(float64(size) * f); rv > 0 {
+		return rv
+	}
+	return 1
+}
+
+// recordQueryResultSizeLOCKED moves the correction factor of the index
+// towards the one that would have had the (corrected) size estimate
+// of an ended query match its result size, by an 1/8th, bounded by
+// the queryEstimateMaxCorrection.
+func (a *appHerder) recordQueryResultSizeLOCKED(indexName string,
+	size, resultSize uint64) {
+	if a.queryEstimateMaxCorrection <= 0 || indexName == "" || size == 0 {
+		return
+	}
+
+	f, exists := a.queryEstimateCorrections[indexName]
+	if !exists {
+		f = 1
+	}
+
+	f += (f*float64(resultSize)/float64(size) - f) / 8
+
+	if max := a.queryEstimateMaxCorrection; f > max {
+		f = max
+	} else if f < 1/max {
+		f = 1 / max
+	}
+
+	a.queryEstimateCorrections[indexName] = f
+}
+
 // *** Moss Wrapper
 
 func (a *appHerder) MossHerderOnEvent() func(moss.Event) {
@@ -343,7 +1771,9 @@ func (a *appHerder) onMossEvent(event moss.Event) {
 		a.onClose(event.Collection)
 
 	case moss.EventKindBatchExecuteStart:
-		a.onBatchExecuteStart(event.Collection, mossSize)
+		// the batch was admitted ahead of its execution, see
+		// BatchAdmission, as the events can't hold back a batch
+		a.onBatchIntroduced(event.Collection, mossSize)
 
 	case moss.EventKindPersisterProgress:
 		a.onPersisterProgress()
@@ -358,21 +1788,44 @@ func (a *appHerder) ScorchHerderOnEvent() func(scorch.Event) {
 	return func(event scorch.Event) { a.onScorchEvent(event) }
 }
 
+// scorchIndex is the subset of the *scorch.Scorch methods that are
+// needed for sizing, which allows for a graceful fallback should the
+// scorch internals change.
+type scorchIndex interface {
+	Stats() json.Marshaler
+	MemoryUsed() uint64
+}
+
+var scorchSizeTypeWarnOnce sync.Once
+var scorchSizeStatsWarnOnce sync.Once
+
 func scorchSize(s interface{}) uint64 {
-	if ss, ok := s.(*scorch.Scorch); ok {
-		if stats, ok := ss.Stats().(*scorch.Stats); ok {
-			curEpoch := atomic.LoadUint64(&stats.CurRootEpoch)
-			lastMergedEpoch := atomic.LoadUint64(&stats.LastMergedEpoch)
-			lastPersistedEpoch := atomic.LoadUint64(&stats.LastPersistedEpoch)
-
-			if curEpoch == lastMergedEpoch &&
-				lastMergedEpoch == lastPersistedEpoch {
-				return 0
-			}
+	ss, ok := s.(scorchIndex)
+	if !ok {
+		scorchSizeTypeWarnOnce.Do(func() {
+			log.Warnf("app_herder: scorchSize, unexpected index type: %T,"+
+				" not accounting its memory", s)
+		})
+		return 0
+	}
+
+	if stats, ok := ss.Stats().(*scorch.Stats); ok {
+		curEpoch := atomic.LoadUint64(&stats.CurRootEpoch)
+		lastMergedEpoch := atomic.LoadUint64(&stats.LastMergedEpoch)
+		lastPersistedEpoch := atomic.LoadUint64(&stats.LastPersistedEpoch)
+
+		if curEpoch == lastMergedEpoch &&
+			lastMergedEpoch == lastPersistedEpoch {
+			return 0
 		}
+	} else {
+		scorchSizeStatsWarnOnce.Do(func() {
+			log.Warnf("app_herder: scorchSize, unexpected stats type: %T,"+
+				" skipping the epoch check", ss.Stats())
+		})
 	}
 
-	return s.(*scorch.Scorch).MemoryUsed()
+	return ss.MemoryUsed()
 }
 
 func (a *appHerder) onScorchEvent(event scorch.Event) {
@@ -381,7 +1834,9 @@ func (a *appHerder) onScorchEvent(event scorch.Event) {
 		a.onClose(event.Scorch)
 
 	case scorch.EventKindBatchIntroductionStart:
-		a.onBatchExecuteStart(event.Scorch, scorchSize)
+		// the batch was admitted ahead of its introduction, see
+		// BatchAdmission, as the events can't hold back a batch
+		a.onBatchIntroduced(event.Scorch, scorchSize)
 
 	case scorch.EventKindPersisterProgress:
 		a.onPersisterProgress()
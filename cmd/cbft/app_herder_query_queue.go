@@ -0,0 +1,114 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// defaultMaxQueryQueueWait is the default max duration that a query may
+// wait in the query queue.
+const defaultMaxQueryQueueWait = 100 * time.Millisecond
+
+// setQueryQueue sets the max number of queries that may wait in the
+// query queue, where 0 disables the queue, and the max duration of
+// their wait.
+func (a *appHerder) setQueryQueue(n int, d time.Duration) {
+	a.m.Lock()
+	a.maxQueuedQueries = n
+	a.maxQueryQueueWait = d
+	if a.queryQueueWaitCounts == nil {
+		a.queryQueueWaitCounts = make([]uint64, len(batchWaitBuckets)+1)
+	}
+	a.m.Unlock()
+
+	log.Printf("app_herder: maxQueuedQueries: %d, maxQueryQueueWait: %v",
+		n, d)
+}
+
+// awaitQueryQuotaLOCKED queues a query that's over the queryQuota,
+// when the query queue is enabled and not full, until the query is at
+// the head of the queue and under the queryQuota, or until its wait
+// exceeds the maxQueryQueueWait or the herder is draining.  It returns
+// the memory used as of the last check, where the query is admitted
+// when that's within the queryQuota, along with the wait.
+func (a *appHerder) awaitQueryQuotaLOCKED(size uint64, memUsed int64) (
+	int64, time.Duration) {
+	if a.maxQueuedQueries <= 0 {
+		return memUsed, 0
+	}
+
+	if len(a.queryQueue) >= a.maxQueuedQueries {
+		a.totQueriesQueueFull++
+		return memUsed, 0
+	}
+
+	ticket := a.queryQueueNextTicket
+	a.queryQueueNextTicket++
+	a.queryQueue = append(a.queryQueue, ticket)
+	if len(a.queryQueue) > a.queryQueueHighWater {
+		a.queryQueueHighWater = len(a.queryQueue)
+	}
+	a.totQueriesQueued++
+
+	a.signalOverQuota()
+
+	waitStart := time.Now()
+	deadline := waitStart.Add(a.maxQueryQueueWait)
+	timer := time.AfterFunc(a.maxQueryQueueWait, func() {
+		a.m.Lock()
+		a.queryWaitCond.Broadcast()
+		a.m.Unlock()
+	})
+
+	for atomic.LoadInt32(&a.draining) == 0 {
+		if a.queryQueue[0] == ticket {
+			memUsed = a.queryMemUsedLOCKED(size)
+			if a.queryQuota <= 0 || memUsed <= a.queryQuota {
+				a.totQueriesQueueAdmitted++
+				break
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			a.totQueriesQueueTimedOut++
+			break
+		}
+
+		a.queryWaitCond.Wait()
+	}
+
+	timer.Stop()
+
+	for i, t := range a.queryQueue {
+		if t == ticket {
+			a.queryQueue = append(a.queryQueue[:i], a.queryQueue[i+1:]...)
+			break
+		}
+	}
+
+	// the next in the queue may now be at its head
+	a.queryWaitCond.Broadcast()
+
+	waited := time.Since(waitStart)
+	i := sort.Search(len(batchWaitBuckets), func(i int) bool {
+		return waited <= batchWaitBuckets[i]
+	})
+	a.queryQueueWaitCounts[i]++
+	a.totQueryQueueWaitNS += uint64(waited)
+
+	return memUsed, waited
+}
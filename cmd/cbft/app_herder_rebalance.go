@@ -0,0 +1,180 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// quotaRebalanceMinFraction is the least fraction of the rebalanced
+// quotas that either of the indexing and the querying keeps, so that
+// neither is starved by a long one-sided workload.
+const quotaRebalanceMinFraction = 0.1
+
+// defaultQuotaRebalanceStep is the default fraction of the sum of the
+// quotas that a rebalance shifts between the indexing and the querying.
+const defaultQuotaRebalanceStep = 0.05
+
+// The directions of a quota rebalance.
+const (
+	quotaRebalanceToIndexing = "indexing"
+	quotaRebalanceToQuerying = "querying"
+	quotaRebalanceToStatic   = "static"
+)
+
+// setQuotaRebalance (re)starts the adaptive split of the quotas, where
+// every interval the indexQuota and the queryQuota are rebalanced by
+// the step, as a fraction of their sum, towards the side that hit its
+// limit, or else back towards the static split.  An interval of 0
+// stops it, restoring the static split.
+func (a *appHerder) setQuotaRebalance(interval time.Duration, step float64) {
+	a.m.Lock()
+	if a.quotaRebalanceStopCh != nil {
+		close(a.quotaRebalanceStopCh)
+		a.quotaRebalanceStopCh = nil
+	}
+	a.quotaRebalanceInterval = interval
+	a.quotaRebalanceStep = step
+	a.indexLimitHits = 0
+	a.queryLimitHits = 0
+
+	indexQuota, queryQuota := a.staticQuotasLOCKED()
+	atomic.StoreInt64(&a.indexQuota, indexQuota)
+	atomic.StoreInt64(&a.queryQuota, queryQuota)
+	a.indexQuotaFraction = staticIndexQuotaFraction(indexQuota, queryQuota)
+
+	if interval > 0 {
+		a.quotaRebalanceStopCh = make(chan struct{})
+		go a.runQuotaRebalance(interval, a.quotaRebalanceStopCh)
+	}
+	a.awakeWaitersLOCKED("quota rebalance set")
+	a.m.Unlock()
+
+	log.Printf("app_herder: quotaRebalanceInterval: %v,"+
+		" quotaRebalanceStep: %v", interval, step)
+}
+
+func (a *appHerder) runQuotaRebalance(interval time.Duration,
+	stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			a.rebalanceQuotas()
+		}
+	}
+}
+
+// staticQuotasLOCKED returns the indexQuota and the queryQuota of the
+// static split, by the ratios.
+func (a *appHerder) staticQuotasLOCKED() (int64, int64) {
+	return int64(float64(a.appQuota) * a.indexRatio),
+		int64(float64(a.appQuota) * a.queryRatio)
+}
+
+// staticIndexQuotaFraction returns the fraction of the sum of the
+// static quotas that's the indexQuota.
+func staticIndexQuotaFraction(indexQuota, queryQuota int64) float64 {
+	if indexQuota+queryQuota <= 0 {
+		return 0
+	}
+	return float64(indexQuota) / float64(indexQuota+queryQuota)
+}
+
+// rebalanceQuotas shifts the split of the quotas by a step towards the
+// side that hit its limit since the last rebalance, when only one of
+// them did, or else by a step back towards the static split.  Off the
+// static split, the sum of the quotas stays within the appQuota.  The quotas that are
+// disabled or ignored keep to the static split.  It returns the
+// direction of the rebalance, if any.
+func (a *appHerder) rebalanceQuotas() string {
+	a.m.Lock()
+
+	indexHits, queryHits := a.indexLimitHits, a.queryLimitHits
+	a.indexLimitHits, a.queryLimitHits = 0, 0
+
+	staticIndexQuota, staticQueryQuota := a.staticQuotasLOCKED()
+	if a.appQuota <= 0 || staticIndexQuota <= 0 || staticQueryQuota <= 0 {
+		a.m.Unlock()
+		return ""
+	}
+
+	static := staticIndexQuotaFraction(staticIndexQuota, staticQueryQuota)
+
+	f, direction := a.indexQuotaFraction, ""
+	switch {
+	case indexHits > 0 && queryHits == 0:
+		f, direction = f+a.quotaRebalanceStep, quotaRebalanceToIndexing
+	case queryHits > 0 && indexHits == 0:
+		f, direction = f-a.quotaRebalanceStep, quotaRebalanceToQuerying
+	case f > static:
+		f, direction = f-a.quotaRebalanceStep, quotaRebalanceToStatic
+		if f < static {
+			f = static
+		}
+	case f < static:
+		f, direction = f+a.quotaRebalanceStep, quotaRebalanceToStatic
+		if f > static {
+			f = static
+		}
+	}
+
+	if f < quotaRebalanceMinFraction {
+		f = quotaRebalanceMinFraction
+	} else if f > 1-quotaRebalanceMinFraction {
+		f = 1 - quotaRebalanceMinFraction
+	}
+
+	// back at the static split, the static quotas apply as is
+	indexQuota, queryQuota := staticIndexQuota, staticQueryQuota
+	if f != static {
+		total := staticIndexQuota + staticQueryQuota
+		if total > a.appQuota {
+			total = a.appQuota
+		}
+		indexQuota = int64(float64(total) * f)
+		queryQuota = total - indexQuota
+	}
+
+	prevIndexQuota, prevQueryQuota := a.indexQuota, a.queryQuota
+	if indexQuota == prevIndexQuota && queryQuota == prevQueryQuota {
+		a.indexQuotaFraction = f
+		a.m.Unlock()
+		return ""
+	}
+
+	if direction == "" {
+		direction = quotaRebalanceToStatic
+	}
+
+	a.indexQuotaFraction = f
+	atomic.StoreInt64(&a.indexQuota, indexQuota)
+	atomic.StoreInt64(&a.queryQuota, queryQuota)
+	a.totQuotaRebalances++
+
+	a.awakeWaitersLOCKED("quota rebalanced")
+	a.m.Unlock()
+
+	log.Printf("app_herder: quota rebalanced towards %s, indexLimitHits: %d,"+
+		" queryLimitHits: %d, indexQuota: %d -> %d, queryQuota: %d -> %d",
+		direction, indexHits, queryHits, prevIndexQuota, indexQuota,
+		prevQueryQuota, queryQuota)
+
+	return direction
+}
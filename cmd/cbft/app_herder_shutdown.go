@@ -0,0 +1,65 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// herderQuiesceTimeout bounds the draining of the app herder at the
+// shutdown, ahead of the exit.
+var herderQuiesceTimeout = 10 * time.Second
+
+// quiesceOnShutdown quiesces the app herder, if any, once the process
+// is asked to terminate, so that the waiting batches and queries are
+// released cleanly, and then terminates the process by the signal.
+func quiesceOnShutdown(ah *appHerder, timeout time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	quiesceOnSignal(sigCh, ah, timeout, func(sig os.Signal) {
+		// terminate by the signal, as without the quiesce
+		signal.Reset(sig)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	})
+}
+
+// quiesceOnSignal waits for a signal of the sigCh, and then quiesces
+// the app herder, if any, within the timeout, before calling the exit.
+func quiesceOnSignal(sigCh <-chan os.Signal, ah *appHerder,
+	timeout time.Duration, exit func(os.Signal)) {
+	sig := <-sigCh
+
+	log.Printf("app_herder: signal: %v, quiescing, timeout: %v",
+		sig, timeout)
+
+	if ah != nil {
+		released, err := ah.Quiesce(timeout)
+		if err != nil {
+			log.Warnf("app_herder: quiesce, released: %d, err: %v",
+				released, err)
+		}
+	}
+
+	exit(sig)
+}
@@ -0,0 +1,1665 @@
+//  Copyright (c) 2018 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbft"
+	"github.com/couchbase/moss"
+)
+
+// overQuotaForIndexing pushes the herder's pre-indexing memory over
+// the given quota, returning a func that undoes it.
+func overQuotaForIndexing(quota uint64) func() {
+	atomic.AddUint64(&cbft.BatchBytesAdded, quota+1)
+	return func() {
+		atomic.AddUint64(&cbft.BatchBytesRemoved, quota+1)
+	}
+}
+
+func TestAppHerderBatchWaitCancel(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	defer overQuotaForIndexing(1000)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(ctx, "index",
+			func(interface{}) uint64 { return 1 })
+	}()
+
+	select {
+	case err := <-doneCh:
+		t.Fatalf("expected batch to wait while over quota, err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case err := <-doneCh:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected batch wait to return promptly on cancel")
+	}
+}
+
+// admittedQueryEvent returns the start event of a top level query,
+// which notes how the query was admitted for its end event.
+func admittedQueryEvent() cbft.QueryEvent {
+	return cbft.QueryEvent{Admission: &cbft.QueryAdmission{}}
+}
+
+func TestAppHerderQueryReservedSlotSizeBeforeQuery(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	ah.setQueryReservedSlots(2)
+
+	if err := ah.onQueryStart(0, admittedQueryEvent(), 10); err != nil {
+		t.Fatalf("expected query to be admitted, err: %v", err)
+	}
+
+	// a query larger than the slot misses it, even as it grows the
+	// slot to its own size
+	if err := ah.onQueryStart(0, admittedQueryEvent(), 100); err != nil {
+		t.Fatalf("expected query to be admitted, err: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["TotQueryReservedSlotHit"] != uint64(0) ||
+		stats["TotQueryReservedSlotMiss"] != uint64(2) ||
+		stats["QueryReservedSlotSize"] != uint64(100) {
+		t.Errorf("expected the queries to miss the reserved slots, got: %v",
+			stats)
+	}
+}
+
+func TestAppHerderQueryReservedSlots(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	ah.setQueryReservedSlots(2)
+
+	// the first query sizes the slots, which the next ones then take
+	var events []cbft.QueryEvent
+	for i := 0; i < 3; i++ {
+		event := admittedQueryEvent()
+		if err := ah.onQueryStart(0, event, 10); err != nil {
+			t.Fatalf("expected query to be admitted, err: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	stats := ah.Stats()
+	if stats["QueryReservedSlotsUsed"] != 2 ||
+		stats["TotQueryReservedSlotHit"] != uint64(2) ||
+		stats["TotQueryReservedSlotMiss"] != uint64(1) {
+		t.Errorf("expected 2 reserved slot hits and 1 miss, got: %v", stats)
+	}
+	if ah.runningQueryUsed != 30 {
+		t.Errorf("expected all the queries to be accounted, got: %d",
+			ah.runningQueryUsed)
+	}
+
+	// the end of a query of the same size outside of the slots, or of
+	// a query that's not noting its admission, frees no slot
+	ah.onQueryEnd(0, events[0], 10)
+	ah.onQueryStart(0, cbft.QueryEvent{}, 10)
+	ah.onQueryEnd(0, cbft.QueryEvent{}, 10)
+	if stats = ah.Stats(); stats["QueryReservedSlotsUsed"] != 2 {
+		t.Errorf("expected the reserved slots to be kept, got: %v", stats)
+	}
+
+	for _, event := range events[1:] {
+		ah.onQueryEnd(0, event, 10)
+	}
+
+	stats = ah.Stats()
+	if stats["QueryReservedSlotsUsed"] != 0 || ah.runningQueryUsed != 0 {
+		t.Errorf("expected reserved slots and accounting to be released,"+
+			" got: %v, runningQueryUsed: %d", stats, ah.runningQueryUsed)
+	}
+}
+
+func TestAppHerderQueryReservedSlotsWithinQuotas(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+	ah.setQueryReservedSlots(2)
+
+	// size the slots
+	event := admittedQueryEvent()
+	if err := ah.onQueryStart(0, event, 100); err != nil {
+		t.Fatalf("expected query to be admitted, err: %v", err)
+	}
+	ah.onQueryEnd(0, event, 100)
+
+	// a slot isn't taken over the queryQuota
+	atomic.StoreUint64(&memUsed, 850)
+	event = admittedQueryEvent()
+	if err := ah.onQueryStart(0, event, 100); err != nil {
+		t.Fatalf("expected the first query to be let through, err: %v", err)
+	}
+	if stats := ah.Stats(); stats["QueryReservedSlotsUsed"] != 0 {
+		t.Errorf("expected no reserved slot over the queryQuota, got: %v",
+			stats)
+	}
+	ah.onQueryEnd(0, event, 100)
+
+	// only the free slots are reserved, as the queries in the used
+	// slots are part of the memory used
+	atomic.StoreUint64(&memUsed, 700)
+	for i := 0; i < 2; i++ {
+		if err := ah.onQueryStart(0, admittedQueryEvent(), 100); err != nil {
+			t.Fatalf("expected query to be admitted, err: %v", err)
+		}
+	}
+	if err := ah.onQueryStart(0, admittedQueryEvent(), 150); err != nil {
+		t.Errorf("expected the query within the queryQuota to be admitted,"+
+			" err: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["QueryReservedSlotsUsed"] != 2 ||
+		stats["TotQueryReservedSlotHit"] != uint64(2) ||
+		stats["TotQueryReservedSlotMiss"] != uint64(3) {
+		t.Errorf("expected 2 reserved slot hits and 3 misses, got: %v", stats)
+	}
+}
+
+func TestAppHerderQuotas(t *testing.T) {
+	ah := newAppHerder(1000, 0.5, 0.4, 0.8, nil)
+
+	exp := herderQuotas{
+		MemQuota:   1000,
+		AppQuota:   500,
+		IndexQuota: 200,
+		QueryQuota: 400,
+		AppRatio:   0.5,
+		IndexRatio: 0.4,
+		QueryRatio: 0.8,
+	}
+	if q := ah.Quotas(); q != exp {
+		t.Errorf("expected quotas: %+v, got: %+v", exp, q)
+	}
+}
+
+type stubScorchStats struct{}
+
+func (s *stubScorchStats) MarshalJSON() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
+type stubScorch struct {
+	memUsed uint64
+}
+
+func (s *stubScorch) Stats() json.Marshaler {
+	return &stubScorchStats{}
+}
+
+func (s *stubScorch) MemoryUsed() uint64 {
+	return s.memUsed
+}
+
+func TestScorchSizeUnexpectedTypes(t *testing.T) {
+	if size := scorchSize(&stubScorch{memUsed: 42}); size != 42 {
+		t.Errorf("expected MemoryUsed() fallback of 42, got: %d", size)
+	}
+
+	if size := scorchSize("not a scorch"); size != 0 {
+		t.Errorf("expected 0 for an unexpected index type, got: %d", size)
+	}
+}
+
+func TestAppHerderWakeReasons(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(context.Background(), "index",
+			func(interface{}) uint64 { return 1 })
+	}()
+
+	waitFor := func(cond func() bool) {
+		for i := 0; i < 500; i++ {
+			ah.m.Lock()
+			ok := cond()
+			ah.m.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected condition to be met")
+	}
+
+	waitFor(func() bool { return ah.waiting > 0 })
+
+	ah.onMergerProgress()
+
+	waitFor(func() bool {
+		wrs := ah.wakeReasons["merger progress"]
+		return wrs != nil && wrs.TotRewaited > 0
+	})
+
+	undo()
+	ah.onPersisterProgress()
+
+	if err := <-doneCh; err != nil {
+		t.Fatalf("expected batch to proceed, err: %v", err)
+	}
+
+	wakeReasons, ok := ah.Stats()["WakeReasons"].(map[string]wakeReasonStats)
+	if !ok {
+		t.Fatalf("expected WakeReasons in stats")
+	}
+	if wrs := wakeReasons["merger progress"]; wrs.TotAwoken != 1 ||
+		wrs.TotRewaited != 1 || wrs.TotProceeded != 0 {
+		t.Errorf("expected merger progress to rewait, got: %+v", wrs)
+	}
+	if wrs := wakeReasons["persister progress"]; wrs.TotAwoken != 1 ||
+		wrs.TotRewaited != 0 || wrs.TotProceeded != 1 {
+		t.Errorf("expected persister progress to proceed, got: %+v", wrs)
+	}
+}
+
+func TestAppHerderQueryQuotaWithMemoryUsed(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+
+	// the first query is always let through
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err != nil {
+		t.Fatalf("expected first query to be admitted, err: %v", err)
+	}
+
+	atomic.StoreUint64(&memUsed, 350)
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err != nil {
+		t.Errorf("expected query within queryQuota, err: %v", err)
+	}
+
+	atomic.StoreUint64(&memUsed, 450)
+	err := ah.onQueryStart(0, cbft.QueryEvent{}, 100)
+	qre, ok := err.(*cbft.QueryRejectedError)
+	if !ok {
+		t.Fatalf("expected query over queryQuota to be rejected, err: %v", err)
+	}
+	if qre.Limit != cbft.QueryRejectLimitQuery || qre.Quota != 500 ||
+		qre.Estimate != 100 || qre.Usage != 550 {
+		t.Errorf("unexpected rejection: %+v", qre)
+	}
+}
+
+func TestAppHerderQueryQueue(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+	ah.setQueryQueue(1, 50*time.Millisecond)
+
+	// the first query is always let through
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err != nil {
+		t.Fatalf("expected first query to be admitted, err: %v", err)
+	}
+
+	queuedQueries := func() int {
+		ah.m.Lock()
+		defer ah.m.Unlock()
+		return len(ah.queryQueue)
+	}
+
+	// a query over the queryQuota waits for a running one to end
+	atomic.StoreUint64(&memUsed, 450)
+	ch := make(chan error)
+	go func() {
+		ch <- ah.onQueryStart(0, cbft.QueryEvent{}, 100)
+	}()
+	for queuedQueries() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// while the queue is full, the next query is rejected right away
+	if _, ok := ah.onQueryStart(0, cbft.QueryEvent{},
+		100).(*cbft.QueryRejectedError); !ok {
+		t.Errorf("expected a rejection while the queue is full")
+	}
+
+	atomic.StoreUint64(&memUsed, 0)
+	ah.onQueryEnd(0, cbft.QueryEvent{}, 100)
+	if err := <-ch; err != nil {
+		t.Errorf("expected the queued query to be admitted, err: %v", err)
+	}
+
+	// a query that stays over the queryQuota times out
+	atomic.StoreUint64(&memUsed, 450)
+	err := ah.onQueryStart(0, cbft.QueryEvent{}, 100)
+	if _, ok := err.(*cbft.QueryRejectedError); !ok {
+		t.Errorf("expected the queued query to time out, err: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["TotQueriesQueued"] != uint64(2) ||
+		stats["TotQueriesQueueAdmitted"] != uint64(1) ||
+		stats["TotQueriesQueueTimedOut"] != uint64(1) ||
+		stats["TotQueriesQueueFull"] != uint64(1) ||
+		stats["QueuedQueries"] != 0 ||
+		stats["QueuedQueriesHighWater"] != 1 {
+		t.Errorf("unexpected query queue stats: %v", stats)
+	}
+	if queuedQueries() != 0 {
+		t.Errorf("expected an empty queue")
+	}
+}
+
+func TestAppHerderQueryIndexShare(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+	ah.setQueryIndexFraction(0.25)
+
+	// a lone index may exceed its share, as the overall quotas permit
+	for i := 0; i < 4; i++ {
+		if err := ah.onQueryStart(0, cbft.QueryEvent{IndexName: "a"}, 100); err != nil {
+			t.Fatalf("expected the work-conserving admission, err: %v", err)
+		}
+	}
+
+	// but not while it's crowding out another index
+	if err := ah.onQueryStart(0, cbft.QueryEvent{IndexName: "b"}, 100); err != nil {
+		t.Fatalf("expected the other index within its share, err: %v", err)
+	}
+	err := ah.onQueryStart(0, cbft.QueryEvent{IndexName: "a"}, 100)
+	if qre, ok := err.(*cbft.QueryRejectedError); !ok ||
+		qre.Limit != cbft.QueryRejectLimitIndexShare {
+		t.Errorf("expected the index over its share to be rejected, err: %v", err)
+	}
+
+	stats := ah.Stats()["QueryIndexes"].(map[string]indexQueryStats)
+	if stats["a"].RunningQueryUsed != 400 || stats["a"].RunningQueries != 4 ||
+		stats["a"].TotQueriesRejected != 1 ||
+		stats["a"].TotQueriesOverShare != 2 || stats["b"].RunningQueryUsed != 100 {
+		t.Errorf("unexpected per index stats: %+v", stats)
+	}
+
+	// the other index going idle lets the index over its share go on
+	ah.onQueryEnd(0, cbft.QueryEvent{IndexName: "b"}, 100)
+	if err := ah.onQueryStart(0, cbft.QueryEvent{IndexName: "a"}, 100); err != nil {
+		t.Errorf("expected the admission once the other index idles, err: %v", err)
+	}
+	if ah.runningQueryUsed != 500 {
+		t.Errorf("expected runningQueryUsed: 500, got: %d", ah.runningQueryUsed)
+	}
+
+	// the stats of an index are dropped once its queries end, unlike
+	// the totals
+	for i := 0; i < 5; i++ {
+		ah.onQueryEnd(0, cbft.QueryEvent{IndexName: "a"}, 100)
+	}
+	s := ah.Stats()
+	if qi := s["QueryIndexes"].(map[string]indexQueryStats); len(qi) != 0 {
+		t.Errorf("expected the idle indexes to be dropped, got: %+v", qi)
+	}
+	if s["TotQueriesIndexShareRejected"] != uint64(1) ||
+		s["TotQueriesOverIndexShare"] != uint64(3) {
+		t.Errorf("expected the totals to be kept, got: %v", s)
+	}
+	if ah.runningQueryUsed != 0 {
+		t.Errorf("expected runningQueryUsed: 0, got: %d", ah.runningQueryUsed)
+	}
+}
+
+func TestAppHerderQueryHighPriorityReserve(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+	ah.setQueryHighPriorityReserve(0.2)
+
+	low := cbft.QueryEvent{Priority: cbft.QueryPriorityLow}
+	high := cbft.QueryEvent{Priority: cbft.QueryPriorityHigh}
+
+	// the first query is always let through
+	if err := ah.onQueryStart(0, low, 100); err != nil {
+		t.Fatalf("expected first query to be admitted, err: %v", err)
+	}
+
+	atomic.StoreUint64(&memUsed, 650)
+	if err := ah.onQueryStart(0, low, 100); err != nil {
+		t.Errorf("expected low priority query below the reserve, err: %v", err)
+	}
+
+	// the reserve is kept for the high priority queries
+	atomic.StoreUint64(&memUsed, 750)
+	if err := ah.onQueryStart(0, low, 100); err == nil {
+		t.Errorf("expected low priority query into the reserve to be rejected")
+	}
+	if err := ah.onQueryStart(0, high, 100); err != nil {
+		t.Errorf("expected high priority query into the reserve, err: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["TotLowPriorityQueriesRejected"] != uint64(1) {
+		t.Errorf("expected 1 low priority rejection, got: %v", stats)
+	}
+
+	// the queries are released regardless of their priority
+	ah.onQueryEnd(0, low, 100)
+	ah.onQueryEnd(0, low, 100)
+	ah.onQueryEnd(0, high, 100)
+	if ah.runningQueryUsed != 0 {
+		t.Errorf("expected all the queries released, got: %d",
+			ah.runningQueryUsed)
+	}
+}
+
+func TestAppHerderQueryDegradedMode(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+	ah.setQueryDegradedMode(true)
+
+	event := cbft.QueryEvent{CanDegrade: true}
+
+	// the first query is always let through
+	if err := ah.onQueryStart(0, event, 100); err != nil {
+		t.Fatalf("expected first query to be admitted, err: %v", err)
+	}
+
+	// over the queryQuota, the queries that can't degrade are rejected
+	atomic.StoreUint64(&memUsed, 600)
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err == nil ||
+		err == cbft.ErrQueryDegraded {
+		t.Errorf("expected a rejection, err: %v", err)
+	}
+	if err := ah.onQueryStart(0, event, 100); err != cbft.ErrQueryDegraded {
+		t.Errorf("expected a degraded query, err: %v", err)
+	}
+
+	// but there's no degrading beyond the appQuota
+	atomic.StoreUint64(&memUsed, 950)
+	if err := ah.onQueryStart(0, event, 100); err == nil ||
+		err == cbft.ErrQueryDegraded {
+		t.Errorf("expected a rejection over the appQuota, err: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["TotQueriesDegraded"] != uint64(1) ||
+		stats["RunningDegradedQueries"] != 1 {
+		t.Errorf("expected 1 degraded query, got: %v", stats)
+	}
+	if ah.runningQueryUsed != 200 {
+		t.Errorf("expected the degraded query to be accounted, got: %d",
+			ah.runningQueryUsed)
+	}
+
+	// nor beyond the max degraded queries
+	ah.setMaxDegradedQueries(1)
+	atomic.StoreUint64(&memUsed, 600)
+	if err := ah.onQueryStart(0, event, 100); err == nil ||
+		err == cbft.ErrQueryDegraded {
+		t.Errorf("expected a rejection over the max degraded, err: %v", err)
+	}
+
+	// the degraded query is released along with the regular ones
+	ah.onQueryEnd(0, cbft.QueryEvent{Degraded: true}, 100)
+	ah.onQueryEnd(0, event, 100)
+	if ah.runningQueryUsed != 0 || ah.runningDegradedQueries != 0 {
+		t.Errorf("expected all the queries released, got: %d, %d",
+			ah.runningQueryUsed, ah.runningDegradedQueries)
+	}
+}
+
+func TestAppHerderSubscribeOverQuota(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+
+	ch := make(chan struct{}, 1)
+	unsubscribe := ah.subscribeOverQuota(ch)
+
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err != nil {
+		t.Fatalf("expected first query to be admitted, err: %v", err)
+	}
+
+	// the rejections signal the subscriber, without blocking on it
+	atomic.StoreUint64(&memUsed, 600)
+	for i := 0; i < 2; i++ {
+		if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err == nil {
+			t.Fatalf("expected a rejection")
+		}
+	}
+	select {
+	case <-ch:
+	default:
+		t.Errorf("expected an over-quota signal")
+	}
+
+	unsubscribe()
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err == nil {
+		t.Fatalf("expected a rejection")
+	}
+	select {
+	case <-ch:
+		t.Errorf("expected no signal after unsubscribing")
+	default:
+	}
+}
+
+func TestAppHerderWatchdog(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return 900 }))
+
+	start := time.Now()
+
+	ah.m.Lock()
+	ah.watchdogThreshold = time.Minute
+	ah.watchdogAction = watchdogActionBroadcast
+	ah.indexes["idx"] = func(interface{}) uint64 { return 100 }
+	ah.lastProgress = start
+	ah.m.Unlock()
+
+	// no intervention while no batches are waiting
+	if ah.checkStall(start.Add(2 * time.Minute)) {
+		t.Errorf("expected no intervention without waiting batches")
+	}
+
+	ah.m.Lock()
+	ah.waiting = 1
+	ah.m.Unlock()
+
+	if ah.checkStall(start.Add(30 * time.Second)) {
+		t.Errorf("expected no intervention before the threshold")
+	}
+
+	// progress events hold off the watchdog
+	ah.onPersisterProgress()
+	if ah.checkStall(time.Now().Add(30 * time.Second)) {
+		t.Errorf("expected no intervention after the progress")
+	}
+
+	stalled := time.Now().Add(2 * time.Minute)
+	if !ah.checkStall(stalled) {
+		t.Fatalf("expected an intervention once stalled")
+	}
+	// at most once per threshold
+	if ah.checkStall(stalled.Add(time.Second)) {
+		t.Errorf("expected no repeated intervention")
+	}
+
+	stats := ah.Stats()
+	if stats["TotWatchdogInterventions"] != uint64(1) {
+		t.Errorf("expected 1 intervention, got: %v", stats)
+	}
+	wakeReasons, _ := stats["WakeReasons"].(map[string]wakeReasonStats)
+	if wakeReasons["watchdog"].TotAwoken != 1 {
+		t.Errorf("expected the watchdog to awake the waiters, got: %v",
+			wakeReasons)
+	}
+}
+
+func TestAppHerderFreeMemoryBounds(t *testing.T) {
+	var memUsed, available uint64
+	ah := newAppHerder(1000, 1.0, 0.5, 0.5, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }),
+		withAvailableMemory(func() (uint64, error) {
+			return atomic.LoadUint64(&available), nil
+		}))
+
+	ah.m.Lock()
+	ah.indexes["idx"] = func(interface{}) uint64 { return 100 }
+	ah.m.Unlock()
+
+	setAvailable := func(v uint64) {
+		atomic.StoreUint64(&available, v)
+		ah.sampleAvailableMemory()
+	}
+
+	overQuota := func() bool {
+		ah.m.Lock()
+		defer ah.m.Unlock()
+		rv, _, _, _ := ah.overMemQuotaForIndexingLOCKED("idx", 0)
+		return rv
+	}
+
+	// off by default, where only the quotas apply
+	atomic.StoreUint64(&memUsed, 100)
+	if overQuota() {
+		t.Errorf("expected no wait under the quotas")
+	}
+
+	ah.setFreeMemoryBounds(200, 5000)
+	defer ah.setFreeMemoryBounds(0, 0)
+
+	// under the floor, the indexing waits even under the quotas
+	setAvailable(100)
+	if !overQuota() {
+		t.Errorf("expected a wait under the free memory floor")
+	}
+
+	// with the headroom, the indexing may exceed the indexQuota
+	atomic.StoreUint64(&memUsed, 700)
+	setAvailable(5000)
+	if overQuota() {
+		t.Errorf("expected no wait with the free memory headroom")
+	}
+	setAvailable(4000)
+	if !overQuota() {
+		t.Errorf("expected a wait over the indexQuota without the headroom")
+	}
+
+	// but never the appQuota
+	atomic.StoreUint64(&memUsed, 1100)
+	setAvailable(5000)
+	if !overQuota() {
+		t.Errorf("expected a wait over the appQuota")
+	}
+
+	// the checks themselves aren't counted, only the batches
+	stats := ah.Stats()
+	if stats["TotFreeMemoryBlocked"] != uint64(0) ||
+		stats["TotFreeMemoryRelaxed"] != uint64(0) {
+		t.Errorf("expected no batches counted, got: %v", stats)
+	}
+
+	sizeFunc := func(interface{}) uint64 { return 100 }
+
+	// a batch relaxed by the headroom
+	atomic.StoreUint64(&memUsed, 700)
+	if err := ah.onBatchExecuteStart(context.Background(), "idx",
+		sizeFunc); err != nil {
+		t.Fatalf("expected the batch to proceed, err: %v", err)
+	}
+
+	// a batch blocked by the floor, however many times it's awoken
+	atomic.StoreUint64(&memUsed, 100)
+	setAvailable(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(ctx, "idx", sizeFunc)
+	}()
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 1 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected the batch to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ah.awakeWaiters("test")
+	ah.awakeWaiters("test")
+	cancel()
+	ah.awakeWaiters("test")
+	if err := <-doneCh; err == nil {
+		t.Errorf("expected the blocked batch to be cancelled")
+	}
+
+	stats = ah.Stats()
+	if stats["TotFreeMemoryBlocked"] != uint64(1) ||
+		stats["TotFreeMemoryRelaxed"] != uint64(1) {
+		t.Errorf("expected 1 blocked and 1 relaxed batch, got: %v", stats)
+	}
+}
+
+func TestAppHerderIndexShare(t *testing.T) {
+	var inFlight uint64
+	ah := newAppHerder(10000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 100 }),
+		withBatchBytesInFlight(func(c interface{}) (uint64, bool) {
+			return atomic.LoadUint64(&inFlight), c == "idx0"
+		}))
+
+	ah.m.Lock()
+	ah.indexes["idx0"] = func(interface{}) uint64 { return 100 }
+	ah.indexes["idx1"] = func(interface{}) uint64 { return 100 }
+	ah.m.Unlock()
+
+	overQuota := func(c interface{}, batchBytes uint64) bool {
+		ah.m.Lock()
+		defer ah.m.Unlock()
+		rv, _, _, _ := ah.overMemQuotaForIndexingLOCKED(c, batchBytes)
+		return rv
+	}
+
+	// a batch alone may exceed the share of its index
+	atomic.StoreUint64(&inFlight, 6000)
+	if overQuota("idx0", 6000) {
+		t.Errorf("expected a lone batch of the index to proceed")
+	}
+
+	// but not along with the other batches in flight for the index
+	if !overQuota("idx0", 1000) {
+		t.Errorf("expected a wait over the share of the index")
+	}
+	atomic.StoreUint64(&inFlight, 4000)
+	if overQuota("idx0", 1000) {
+		t.Errorf("expected no wait within the share of the index")
+	}
+
+	// the share is unchecked for the unknown bytes or indexes
+	atomic.StoreUint64(&inFlight, 6000)
+	if overQuota("idx0", 0) || overQuota("idx1", 1000) {
+		t.Errorf("expected no share check without the bytes of the batch")
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := "MemTotal:       16314328 kB\n" +
+		"MemFree:          512000 kB\n" +
+		"MemAvailable:    8000000 kB\n"
+
+	available, err := parseMemAvailable(strings.NewReader(meminfo))
+	if err != nil || available != 8000000*1024 {
+		t.Errorf("expected the MemAvailable, got: %d, err: %v", available, err)
+	}
+
+	if _, err = parseMemAvailable(strings.NewReader("MemTotal: 1 kB\n")); err == nil {
+		t.Errorf("expected an err without the MemAvailable")
+	}
+}
+
+func TestAppHerderUpdateQuota(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+	defer undo()
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(context.Background(), "index0",
+			func(interface{}) uint64 { return 1 })
+	}()
+
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 1 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected the batch to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ah.UpdateQuota(1000, math.NaN(), 1.0, 1.0); err == nil {
+		t.Errorf("expected an invalid ratio to be rejected")
+	}
+	if err := ah.UpdateQuota(1000, 1.0, math.Inf(1), 1.0); err == nil {
+		t.Errorf("expected an infinite ratio to be rejected")
+	}
+	if q := ah.Quotas(); q.MemQuota != 1000 || q.IndexRatio != 1.0 {
+		t.Errorf("expected the rejected quotas not to apply, got: %+v", q)
+	}
+
+	// the ratios out of [0,1] are accepted, as by the constructor
+	if err := ah.UpdateQuota(1000, 1.0, 1.0, -0.5); err != nil {
+		t.Errorf("expected a negative ratio to be accepted, err: %v", err)
+	}
+	if q := ah.Quotas(); q.QueryRatio != -0.5 || q.QueryQuota != -500 {
+		t.Errorf("expected the negative ratio to apply, got: %+v", q)
+	}
+
+	g := NewGoverseer(time.Minute, 1000)
+	ah.setGoverseer(g)
+
+	// the waiting batch re-evaluates against the raised quota
+	if err := ah.UpdateQuota(100000, 1.0, 0.5, 0.25); err != nil {
+		t.Fatalf("expected the quota to be updated, err: %v", err)
+	}
+
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Errorf("expected the batch to proceed, err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the batch to proceed on the quota update")
+	}
+
+	q := ah.Quotas()
+	if q.MemQuota != 100000 || q.AppQuota != 100000 ||
+		q.IndexQuota != 50000 || q.QueryQuota != 25000 ||
+		q.IndexRatio != 0.5 || q.QueryRatio != 0.25 {
+		t.Errorf("expected the updated quotas, got: %+v", q)
+	}
+	if quota := atomic.LoadUint64(&g.quota); quota != 100000 {
+		t.Errorf("expected the goverseer's quota to be updated, got: %d",
+			quota)
+	}
+}
+
+func TestAppHerderQueryEstimateCorrection(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 0.5, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+
+	// without the correction, the estimates are as is
+	if got := ah.correctQueryEstimate("idx", 100); got != 100 {
+		t.Errorf("expected the estimate as is, got: %d", got)
+	}
+
+	ah.setQueryEstimateMaxCorrection(2)
+
+	// the queries of idx use 4x their estimates, which moves the
+	// correction of idx up, but no further than its bound
+	for i := 0; i < 50; i++ {
+		size := ah.correctQueryEstimate("idx", 100)
+		event := cbft.QueryEvent{IndexName: "idx"}
+		if err := ah.onQueryStart(0, event, size); err != nil {
+			t.Fatalf("expected the query to be admitted, err: %v", err)
+		}
+		event.ResultSize = 400
+		ah.onQueryEnd(0, event, size)
+	}
+
+	if got := ah.correctQueryEstimate("idx", 100); got != 200 {
+		t.Errorf("expected the bounded correction, got: %d", got)
+	}
+	if ah.runningQueryUsed != 0 {
+		t.Errorf("expected the corrected queries released, got: %d",
+			ah.runningQueryUsed)
+	}
+
+	// the queries of other indexes aren't corrected
+	if got := ah.correctQueryEstimate("other", 100); got != 100 {
+		t.Errorf("expected the estimate of other as is, got: %d", got)
+	}
+
+	// the queries of other use half their estimates
+	ah.onQueryStart(0, cbft.QueryEvent{IndexName: "other"}, 100)
+	ah.onQueryEnd(0, cbft.QueryEvent{IndexName: "other", ResultSize: 50}, 100)
+
+	corrections, _ := ah.Stats()["QueryEstimateCorrections"].(map[string]float64)
+	if corrections["idx"] != 2 ||
+		corrections["other"] <= 0.5 || corrections["other"] >= 1 {
+		t.Errorf("expected the corrections in the stats, got: %v", corrections)
+	}
+}
+
+func TestAppHerderMaxWaitingBatches(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	ah.setMaxWaitingBatches(1)
+	undo := overQuotaForIndexing(1000)
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(context.Background(), "index0",
+			func(interface{}) uint64 { return 1 })
+	}()
+
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 1 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected the first batch to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := ah.onBatchExecuteStart(context.Background(), "index1",
+		func(interface{}) uint64 { return 1 })
+	if err != errTooManyWaitingBatches {
+		t.Errorf("expected errTooManyWaitingBatches, got: %v", err)
+	}
+
+	stats := ah.Stats()
+	if stats["WaitingBatches"] != 1 || stats["MaxWaitingBatches"] != 1 ||
+		stats["TotBatchesRejected"] != uint64(1) {
+		t.Errorf("expected 1 waiting and 1 rejected batch, got: %v", stats)
+	}
+
+	undo()
+	ah.onPersisterProgress()
+
+	if err := <-doneCh; err != nil {
+		t.Errorf("expected the waiting batch to proceed, err: %v", err)
+	}
+}
+
+// dirtyCollection is a moss.Collection of the given dirty bytes.
+type dirtyCollection struct {
+	moss.Collection
+	dirty uint64
+}
+
+func (c *dirtyCollection) Stats() (*moss.CollectionStats, error) {
+	return &moss.CollectionStats{CurDirtyBytes: c.dirty}, nil
+}
+
+func (c *dirtyCollection) Options() moss.CollectionOptions {
+	return moss.CollectionOptions{LowerLevelUpdate: c.lowerLevelUpdate}
+}
+
+func (c *dirtyCollection) lowerLevelUpdate(higher moss.Snapshot) (
+	moss.Snapshot, error) {
+	return higher, nil
+}
+
+// lowerlessCollection is a moss.Collection without a lower level, whose
+// events the app_herder ignores.
+type lowerlessCollection struct {
+	dirtyCollection
+}
+
+func (c *lowerlessCollection) Options() moss.CollectionOptions {
+	return moss.CollectionOptions{}
+}
+
+func TestAppHerderBatchAdmission(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	ah.setMaxWaitingBatches(1)
+	undo := overQuotaForIndexing(1000)
+
+	admit := ah.BatchAdmission()
+	c0, c1 := &dirtyCollection{dirty: 1}, &dirtyCollection{dirty: 1}
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- admit(context.Background(), c0, 1)
+	}()
+
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 1 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected the first batch to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the batch over the max waiting batches is to be retried, rather
+	// than go ahead
+	err := admit(context.Background(), c1, 1)
+	if bre, ok := err.(*cbft.BatchRejectedError); !ok || !bre.Retry ||
+		bre.Reason != cbft.BatchRejectWaitingBatches {
+		t.Errorf("expected a rejection to be retried, got: %v", err)
+	}
+
+	// the events of the executing batches don't wait, as their batches
+	// were admitted ahead
+	eventCh := make(chan struct{})
+	go func() {
+		ah.onMossEvent(moss.Event{
+			Kind:       moss.EventKindBatchExecuteStart,
+			Collection: c1,
+		})
+		close(eventCh)
+	}()
+	select {
+	case <-eventCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the batch event not to wait")
+	}
+	if stats := ah.Stats(); stats["WaitingBatches"] != 1 {
+		t.Errorf("expected only the admitted batch waiting, got: %v", stats)
+	}
+
+	// the batches of the indexes of no events are admitted right away
+	if err = admit(context.Background(), "other", 1); err != nil {
+		t.Errorf("expected the batch of an unknown index to proceed,"+
+			" err: %v", err)
+	}
+	if err = admit(context.Background(), &lowerlessCollection{}, 1); err != nil {
+		t.Errorf("expected the batch of a collection without a lower level"+
+			" to proceed, err: %v", err)
+	}
+
+	undo()
+	ah.onPersisterProgress()
+
+	if err = <-doneCh; err != nil {
+		t.Errorf("expected the waiting batch to be admitted, err: %v", err)
+	}
+}
+
+func TestAppHerderMaxBatchFraction(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 0.5, 1.0, nil)
+	if got := ah.maxBatchBytes(); got != 0 {
+		t.Errorf("expected no max batch bytes by default, got: %d", got)
+	}
+
+	ah.setMaxBatchFraction(0.1)
+	if got := ah.maxBatchBytes(); got != 50 {
+		t.Errorf("expected a tenth of the indexQuota, got: %d", got)
+	}
+
+	// the max follows the updates of the indexQuota
+	if err := ah.UpdateQuota(2000, 1.0, 0.5, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	if got := ah.maxBatchBytes(); got != 100 {
+		t.Errorf("expected the max of the updated indexQuota, got: %d", got)
+	}
+
+	admit := ah.BatchAdmission()
+	c := &dirtyCollection{dirty: 1}
+
+	// the size of the batch itself counts, not the others in flight
+	if err := admit(context.Background(), c, 100); err != nil {
+		t.Errorf("expected the batch within the max to proceed, err: %v", err)
+	}
+
+	// a batch over the max is rejected without waiting on the quota
+	undo := overQuotaForIndexing(2000)
+	defer undo()
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- admit(context.Background(), c, 101)
+	}()
+
+	select {
+	case err := <-doneCh:
+		if err != cbft.ErrBatchTooLarge {
+			t.Errorf("expected the large batch to be rejected, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the large batch not to wait on the quota")
+	}
+	if stats := ah.Stats(); stats["WaitingBatches"] != 0 {
+		t.Errorf("expected no waiting batches, got: %v", stats)
+	}
+
+	stats := ah.Stats()
+	if stats["MaxBatchFraction"] != 0.1 || stats["MaxBatchBytes"] != uint64(100) ||
+		stats["TotBatchesTooLarge"] != uint64(1) {
+		t.Errorf("expected the max batch stats, got: %v", stats)
+	}
+}
+
+func TestAppHerderMaxBatchWait(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	ah.setMaxBatchWait(100 * time.Millisecond)
+	undo := overQuotaForIndexing(1000)
+	defer undo()
+
+	// without any progress, the batch proceeds after the max wait
+	start := time.Now()
+	err := ah.onBatchExecuteStart(context.Background(), "index0",
+		func(interface{}) uint64 { return 1 })
+	if err != nil {
+		t.Errorf("expected the batch to proceed, err: %v", err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond ||
+		waited > 5*time.Second {
+		t.Errorf("expected the batch to wait about the max, waited: %v", waited)
+	}
+
+	stats := ah.Stats()
+	if stats["TotBatchesWaitTimedOut"] != uint64(1) ||
+		stats["WaitingBatches"] != 0 {
+		t.Errorf("expected 1 timed out batch, got: %v", stats)
+	}
+	if stats["TotBatchWaitTimeoutWakes"] != uint64(1) {
+		t.Errorf("expected the timeout wake to be counted, got: %v", stats)
+	}
+	if _, exists := stats["WakeReasons"]; exists {
+		t.Errorf("expected no wake reasons for the timeout, got: %v",
+			stats["WakeReasons"])
+	}
+}
+
+func TestAppHerderBatchWaitStats(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+
+	doneCh := make(chan error, 2)
+	for _, index := range []string{"index0", "index1"} {
+		go func(index string) {
+			doneCh <- ah.onBatchExecuteStart(context.Background(), index,
+				func(interface{}) uint64 { return 1 })
+		}(index)
+	}
+
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 2 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected both batches to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	undo()
+	ah.onPersisterProgress()
+	for i := 0; i < 2; i++ {
+		if err := <-doneCh; err != nil {
+			t.Errorf("expected the waiting batch to proceed, err: %v", err)
+		}
+	}
+
+	// a batch under the quota doesn't count as waiting
+	ah.onBatchExecuteStart(context.Background(), "index2",
+		func(interface{}) uint64 { return 1 })
+
+	stats := ah.Stats()
+	if stats["WaitingBatches"] != 0 || stats["WaitingBatchesHighWater"] != 2 {
+		t.Errorf("expected a high water of 2 waiting batches, got: %v", stats)
+	}
+
+	var waits uint64
+	for _, n := range stats["BatchWaits"].(map[string]uint64) {
+		waits += n
+	}
+	if waits != 2 || stats["TotBatchWaitNS"].(uint64) == 0 {
+		t.Errorf("expected 2 batch waits in the histogram, got: %v", stats)
+	}
+}
+
+func TestBatchWaitBucketLabel(t *testing.T) {
+	if l := batchWaitBucketLabel(0); l != "le1ms" {
+		t.Errorf("expected le1ms, got: %s", l)
+	}
+	if l := batchWaitBucketLabel(len(batchWaitBuckets)); l != "gt10s" {
+		t.Errorf("expected gt10s, got: %s", l)
+	}
+}
+
+func TestAppHerderConcurrentCloseDuringBatchWaits(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 40)
+
+	// batches on the same and on different indexes, of which some are
+	// closed while their batches are waiting
+	indexes := []string{"index0", "index1", "index2", "index3"}
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(index string) {
+			defer wg.Done()
+			errCh <- ah.onBatchExecuteStart(context.Background(), index,
+				func(interface{}) uint64 { return 1 })
+		}(indexes[i%len(indexes)])
+	}
+
+	stopCh := make(chan struct{})
+	var eventsWG sync.WaitGroup
+	eventsWG.Add(1)
+	go func() {
+		defer eventsWG.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			switch i % 4 {
+			case 0:
+				ah.onClose(indexes[i%len(indexes)])
+			case 1:
+				ah.onPersisterProgress()
+			case 2:
+				ah.onMergerProgress()
+			case 3:
+				ah.onClose("unknown index")
+			}
+			ah.Stats()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// let all the batches through, while the events keep coming
+	undo()
+	ah.onPersisterProgress()
+
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected all the batches to proceed")
+	}
+
+	close(stopCh)
+	eventsWG.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			t.Errorf("expected batches to proceed, err: %v", err)
+		}
+	}
+
+	for _, index := range indexes {
+		ah.onClose(index)
+	}
+
+	ah.m.Lock()
+	defer ah.m.Unlock()
+	if ah.waiting != 0 || len(ah.indexes) != 0 {
+		t.Errorf("expected no waiting batches nor indexes, waiting: %d,"+
+			" indexes: %d", ah.waiting, len(ah.indexes))
+	}
+}
+
+func TestAppHerderDecisionLatencyStats(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+
+	stats := ah.Stats()
+	if _, exists := stats["BatchDecisionP50NS"]; exists {
+		t.Errorf("expected no batch decision stats before any batch")
+	}
+	if _, exists := stats["QueryDecisionP50NS"]; exists {
+		t.Errorf("expected no query decision stats before any query")
+	}
+
+	if err := ah.onBatchExecuteStart(context.Background(), "idx",
+		func(interface{}) uint64 { return 0 }); err != nil {
+		t.Fatalf("expected batch to proceed, err: %v", err)
+	}
+	if err := ah.onQueryStart(0, cbft.QueryEvent{}, 100); err != nil {
+		t.Fatalf("expected query to be admitted, err: %v", err)
+	}
+
+	stats = ah.Stats()
+	for _, k := range []string{"BatchDecisionP50NS", "BatchDecisionP99NS",
+		"QueryDecisionP50NS", "QueryDecisionP99NS"} {
+		if v, ok := stats[k].(float64); !ok || v < 0 {
+			t.Errorf("expected %s stat, got: %v", k, stats[k])
+		}
+	}
+}
+
+func TestAppHerderDecisionLatencyExcludesWait(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ah.onBatchExecuteStart(context.Background(), "idx",
+			func(interface{}) uint64 { return 1 })
+	}()
+
+	wait := 100 * time.Millisecond
+
+	select {
+	case err := <-doneCh:
+		t.Fatalf("expected batch to wait while over quota, err: %v", err)
+	case <-time.After(wait):
+	}
+
+	undo()
+	ah.onPersisterProgress()
+
+	if err := <-doneCh; err != nil {
+		t.Fatalf("expected batch to proceed, err: %v", err)
+	}
+
+	if p99 := ah.Stats()["BatchDecisionP99NS"].(float64); p99 >= float64(wait) {
+		t.Errorf("expected decision latency to exclude the wait of: %v,"+
+			" got: %v", wait, time.Duration(p99))
+	}
+}
+
+func TestAppHerderMemoryPressure(t *testing.T) {
+	var memUsed uint64
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return atomic.LoadUint64(&memUsed) }))
+
+	if p := ah.MemoryPressure(); p != 0 {
+		t.Errorf("expected no pressure before any event, got: %d", p)
+	}
+
+	tests := []struct {
+		memUsed     uint64
+		expPressure int
+	}{
+		{0, 0},
+		{250, 25},
+		{999, 99},
+		{1000, 100},
+		{5000, 100},
+	}
+	for _, test := range tests {
+		atomic.StoreUint64(&memUsed, test.memUsed)
+		ah.onPersisterProgress()
+		if p := ah.MemoryPressure(); p != test.expPressure {
+			t.Errorf("memUsed: %d, expected pressure: %d, got: %d",
+				test.memUsed, test.expPressure, p)
+		}
+	}
+
+	// the dropped memory used is taken as is
+	ah.onMemoryUsedDropped(500, 5000)
+	if p := ah.Stats()["MemoryPressure"]; p != 50 {
+		t.Errorf("expected pressure stat: 50, got: %v", p)
+	}
+
+	// no appQuota means no pressure
+	ah = newAppHerder(0, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 5000 }))
+	ah.onPersisterProgress()
+	if p := ah.MemoryPressure(); p != 0 {
+		t.Errorf("expected no pressure without an appQuota, got: %d", p)
+	}
+}
+
+func TestAppHerderQuiesce(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+	defer undo()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errCh <- ah.onBatchExecuteStart(context.Background(), "idx",
+				func(interface{}) uint64 { return 1 })
+		}()
+	}
+
+	for {
+		ah.m.Lock()
+		waiting := ah.waiting
+		ah.m.Unlock()
+		if waiting == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	released, err := ah.Quiesce(10 * time.Second)
+	if err != nil || released != 3 {
+		t.Errorf("expected the 3 waiting batches to be released,"+
+			" got: %d, err: %v", released, err)
+	}
+
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != errHerderDraining {
+			t.Errorf("expected errHerderDraining, got: %v", err)
+		}
+	}
+
+	// nothing is admitted once draining
+	err = ah.onBatchExecuteStart(context.Background(), "idx",
+		func(interface{}) uint64 { return 1 })
+	if err != errHerderDraining {
+		t.Errorf("expected the batch to be refused, got: %v", err)
+	}
+	err = ah.onQueryStart(0, cbft.QueryEvent{}, 1)
+	if qre, ok := err.(*cbft.QueryRejectedError); !ok ||
+		qre.Limit != cbft.QueryRejectLimitDraining {
+		t.Errorf("expected the query to be refused, got: %v", err)
+	}
+
+	if stats := ah.Stats(); stats["Draining"] != true ||
+		stats["TotDrainedReleased"] != uint64(3) {
+		t.Errorf("expected the draining stats, got: %v", stats)
+	}
+
+	// an idle herder quiesces right away
+	released, err = newAppHerder(1000, 1.0, 1.0, 1.0, nil).Quiesce(0)
+	if err != nil || released != 0 {
+		t.Errorf("expected nothing to release, got: %d, err: %v",
+			released, err)
+	}
+}
+
+func TestAppHerderQuiesceOnSignal(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+	defer undo()
+
+	admit := ah.BatchAdmission()
+	errCh := make(chan error)
+	go func() {
+		errCh <- admit(context.Background(), &dirtyCollection{dirty: 1}, 1)
+	}()
+
+	for i := 0; ; i++ {
+		if stats := ah.Stats(); stats["WaitingBatches"] == 1 {
+			break
+		}
+		if i >= 500 {
+			t.Fatalf("expected the batch to wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	exitCh := make(chan os.Signal, 1)
+	go quiesceOnSignal(sigCh, ah, 10*time.Second,
+		func(sig os.Signal) { exitCh <- sig })
+
+	sigCh <- syscall.SIGTERM
+
+	select {
+	case sig := <-exitCh:
+		if sig != syscall.SIGTERM {
+			t.Errorf("expected the exit by the signal, got: %v", sig)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the exit once quiesced")
+	}
+
+	// the waiting batch is failed, rather than executed
+	err := <-errCh
+	if bre, ok := err.(*cbft.BatchRejectedError); !ok || bre.Retry ||
+		bre.Reason != cbft.BatchRejectDraining {
+		t.Errorf("expected the batch to be refused, got: %v", err)
+	}
+}
+
+func TestAppHerderQuiesceTimeout(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+
+	// a stand-in for a batch that's stuck, never leaving the wait
+	ah.m.Lock()
+	ah.waiting = 1
+	ah.m.Unlock()
+
+	released, err := ah.Quiesce(20 * time.Millisecond)
+	if err == nil || released != 0 {
+		t.Errorf("expected the quiesce to time out, got: %d, err: %v",
+			released, err)
+	}
+}
+
+func TestAppHerderRebalanceQuotas(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 0.5, 0.5, nil)
+	ah.setQuotaRebalance(time.Hour, 0.1)
+	defer ah.setQuotaRebalance(0, 0)
+
+	checkQuotas := func(msg string, expIndexQuota, expQueryQuota int64) {
+		q := ah.Quotas()
+		if q.IndexQuota != expIndexQuota || q.QueryQuota != expQueryQuota {
+			t.Errorf("%s, expected indexQuota: %d, queryQuota: %d, got: %+v",
+				msg, expIndexQuota, expQueryQuota, q)
+		}
+	}
+
+	hits := func(indexHits, queryHits uint64) string {
+		ah.m.Lock()
+		ah.indexLimitHits = indexHits
+		ah.queryLimitHits = queryHits
+		ah.m.Unlock()
+		return ah.rebalanceQuotas()
+	}
+
+	if d := hits(0, 0); d != "" {
+		t.Errorf("expected no rebalance while idle, got: %s", d)
+	}
+	checkQuotas("idle", 500, 500)
+
+	if d := hits(3, 0); d != quotaRebalanceToIndexing {
+		t.Errorf("expected a rebalance towards indexing, got: %s", d)
+	}
+	checkQuotas("indexing", 600, 400)
+
+	// the demand of both sides keeps the split
+	if d := hits(1, 1); d != quotaRebalanceToStatic {
+		t.Errorf("expected a rebalance towards static, got: %s", d)
+	}
+	checkQuotas("both", 500, 500)
+
+	for i := 0; i < 20; i++ {
+		hits(0, 1)
+	}
+	checkQuotas("querying", 100, 900)
+
+	stats := ah.Stats()
+	if stats["EffectiveIndexQuota"] != int64(100) ||
+		stats["EffectiveQueryQuota"] != int64(900) {
+		t.Errorf("expected the effective quotas in the stats, got: %v", stats)
+	}
+
+	// disabling restores the static split
+	ah.setQuotaRebalance(0, 0)
+	checkQuotas("disabled", 500, 500)
+	if _, exists := ah.Stats()["EffectiveIndexQuota"]; exists {
+		t.Errorf("expected no rebalance stats once disabled")
+	}
+}
+
+func TestAppHerderRebalanceQuotasAfterUpdateQuota(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 0.5, 0.5, nil)
+	ah.setQuotaRebalance(time.Hour, 0.1)
+	defer ah.setQuotaRebalance(0, 0)
+
+	for i := 0; i < 3; i++ {
+		ah.m.Lock()
+		ah.indexLimitHits = 1
+		ah.m.Unlock()
+		ah.rebalanceQuotas()
+	}
+
+	// the hits since the last rebalance don't carry over the update
+	ah.m.Lock()
+	ah.indexLimitHits = 1
+	ah.m.Unlock()
+
+	if err := ah.UpdateQuota(1000, 1.0, 0.25, 0.75); err != nil {
+		t.Fatalf("expected the quota to be updated, err: %v", err)
+	}
+
+	// the split restarts from the new static split, which is kept
+	if d := ah.rebalanceQuotas(); d != "" {
+		t.Errorf("expected no rebalance after the update, got: %s", d)
+	}
+	q := ah.Quotas()
+	if q.IndexQuota != 250 || q.QueryQuota != 750 {
+		t.Errorf("expected the new static split, got: %+v", q)
+	}
+}
+
+func TestAppHerderRebalanceQuotasWithinAppQuota(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 0.8, 0.8, nil)
+	ah.setQuotaRebalance(time.Hour, 0.1)
+	defer ah.setQuotaRebalance(0, 0)
+
+	ah.m.Lock()
+	ah.indexLimitHits = 1
+	ah.m.Unlock()
+	ah.rebalanceQuotas()
+
+	q := ah.Quotas()
+	if q.IndexQuota+q.QueryQuota > q.AppQuota || q.IndexQuota != 600 {
+		t.Errorf("expected the quotas within the appQuota, got: %+v", q)
+	}
+
+	// the disabled quotas aren't rebalanced
+	ah = newAppHerder(0, 1.0, 0.5, 0.5, nil)
+	ah.setQuotaRebalance(time.Hour, 0.1)
+	defer ah.setQuotaRebalance(0, 0)
+	if d := ah.rebalanceQuotas(); d != "" {
+		t.Errorf("expected no rebalance without an appQuota, got: %s", d)
+	}
+}
+
+func TestAppHerderBatchWaitCountsIndexLimitHit(t *testing.T) {
+	ah := newAppHerder(1000, 1.0, 1.0, 1.0, nil)
+	undo := overQuotaForIndexing(1000)
+	defer undo()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	ah.onBatchExecuteStart(ctx, "idx", func(interface{}) uint64 { return 1 })
+
+	ah.m.Lock()
+	defer ah.m.Unlock()
+	if ah.indexLimitHits != 1 {
+		t.Errorf("expected the wait to count as an index limit hit, got: %d",
+			ah.indexLimitHits)
+	}
+}
+
+func BenchmarkAppHerderOnQueryStart(b *testing.B) {
+	ah := newAppHerder(1<<30, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ah.onQueryStart(0, cbft.QueryEvent{}, 100)
+		ah.onQueryEnd(0, cbft.QueryEvent{}, 100)
+	}
+}
+
+func BenchmarkAppHerderOnQueryStartParallel(b *testing.B) {
+	ah := newAppHerder(1<<30, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ah.onQueryStart(0, cbft.QueryEvent{}, 100)
+			ah.onQueryEnd(0, cbft.QueryEvent{}, 100)
+		}
+	})
+}
+
+func BenchmarkAppHerderOnBatchExecuteStart(b *testing.B) {
+	ah := newAppHerder(1<<30, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+	size := func(interface{}) uint64 { return 1 }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ah.onBatchExecuteStart(context.Background(), "idx", size)
+	}
+}
+
+func BenchmarkAppHerderOnBatchExecuteStartParallel(b *testing.B) {
+	ah := newAppHerder(1<<30, 1.0, 1.0, 1.0, nil,
+		withMemoryUsed(func() uint64 { return 0 }))
+	size := func(interface{}) uint64 { return 1 }
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ah.onBatchExecuteStart(context.Background(), "idx", size)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The actions of the stall watchdog, beyond logging a diagnostic
+// snapshot of the herder, which it always does.
+const (
+	// watchdogActionLog only logs the snapshot.
+	watchdogActionLog = "log"
+
+	// watchdogActionBroadcast also awakes the waiting batches, to have
+	// them recheck the memory quota.
+	watchdogActionBroadcast = "broadcast"
+
+	// watchdogActionGC also returns the freed memory to the OS before
+	// awaking the waiting batches, in case the memory used is only
+	// held by the garbage.
+	watchdogActionGC = "gc"
+)
+
+func validWatchdogAction(action string) bool {
+	return action == watchdogActionLog ||
+		action == watchdogActionBroadcast ||
+		action == watchdogActionGC
+}
+
+// setWatchdog (re)starts the stall watchdog with the threshold and the
+// action, or stops it for a threshold of 0.
+func (a *appHerder) setWatchdog(threshold time.Duration, action string) {
+	a.m.Lock()
+	if a.watchdogStopCh != nil {
+		close(a.watchdogStopCh)
+		a.watchdogStopCh = nil
+	}
+	a.watchdogThreshold = threshold
+	a.watchdogAction = action
+	if threshold > 0 {
+		a.watchdogStopCh = make(chan struct{})
+		go a.runWatchdog(threshold, a.watchdogStopCh)
+	}
+	a.m.Unlock()
+
+	log.Printf("app_herder: watchdogThreshold: %v, watchdogAction: %s",
+		threshold, action)
+}
+
+func (a *appHerder) runWatchdog(threshold time.Duration,
+	stopCh chan struct{}) {
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			a.checkStall(now)
+		}
+	}
+}
+
+// checkStall intervenes, returning true, when the batches have been
+// waiting on the memory quota for longer than the watchdogThreshold
+// as of the now, with no progress events in the meantime.  It logs a
+// snapshot of the herder and then takes the watchdogAction, at most
+// once per threshold.
+func (a *appHerder) checkStall(now time.Time) bool {
+	a.m.Lock()
+
+	stalledFor := now.Sub(a.lastProgress)
+	if a.watchdogThreshold <= 0 || a.waiting <= 0 ||
+		stalledFor < a.watchdogThreshold {
+		a.m.Unlock()
+		return false
+	}
+
+	a.totWatchdogInterventions++
+	a.lastProgress = now
+
+	var sizes []string
+	for index, indexSizeFunc := range a.indexes {
+		sizes = append(sizes, fmt.Sprintf("%s: %d",
+			watchdogIndexName(index), indexSizeFunc(index)))
+	}
+	sort.Strings(sizes)
+
+	log.Warnf("app_herder: watchdog, indexing stalled for: %v, waiting: %d,"+
+		" memUsed: %d, preIndexingMemory: %d, indexQuota: %d, appQuota: %d,"+
+		" indexes: %d, index sizes: [%s], lastWakeReason: %q, action: %s",
+		stalledFor, a.waiting, a.memoryUsed(), a.preIndexingMemoryLOCKED(),
+		a.indexQuota, a.appQuota, len(a.indexes), strings.Join(sizes, ", "),
+		a.lastWakeReason, a.watchdogAction)
+
+	action := a.watchdogAction
+
+	a.m.Unlock()
+
+	switch action {
+	case watchdogActionGC:
+		debug.FreeOSMemory()
+		a.awakeWaiters("watchdog")
+	case watchdogActionBroadcast:
+		a.awakeWaiters("watchdog")
+	}
+
+	return true
+}
+
+// watchdogIndexName returns the name of a herded index, when it has
+// one, or else its type and address.
+func watchdogIndexName(index interface{}) string {
+	if named, ok := index.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T@%p", index, index)
+}
@@ -14,6 +14,7 @@ package main
 import (
 	"runtime"
 	"runtime/debug"
+	"sync/atomic"
 	"time"
 
 	log "github.com/couchbase/clog"
@@ -22,7 +23,7 @@ import (
 type Goverseer struct {
 	interval time.Duration
 	kickCh   chan struct{}
-	quota    uint64
+	quota    uint64 // Accessed atomically, see SetQuota.
 	maxRatio float64
 	minRatio float64
 }
@@ -37,9 +38,18 @@ func NewGoverseer(d time.Duration, q uint64) *Goverseer {
 	}
 }
 
+// SetQuota changes the quota that the GC percent is tuned against, as
+// of the next interval.
+func (g *Goverseer) SetQuota(q uint64) {
+	prev := atomic.SwapUint64(&g.quota, q)
+	if prev != q {
+		log.Printf("goverseer: quota: %d -> %d", prev, q)
+	}
+}
+
 func (g *Goverseer) Run() {
 	log.Printf("goverseer: quota: %d, interval: %s, maxRatio: %f, minRatio: %f",
-		g.quota, g.interval, g.maxRatio, g.minRatio)
+		atomic.LoadUint64(&g.quota), g.interval, g.maxRatio, g.minRatio)
 
 	var memstats runtime.MemStats
 
@@ -52,9 +62,10 @@ func (g *Goverseer) Run() {
 
 	adjustGC := func(ratio float64, msg string) {
 		runtime.ReadMemStats(&memstats)
+		quota := atomic.LoadUint64(&g.quota)
 		var spaceRemaining uint64
-		if g.quota > memstats.HeapAlloc {
-			spaceRemaining = g.quota - memstats.HeapAlloc
+		if quota > memstats.HeapAlloc {
+			spaceRemaining = quota - memstats.HeapAlloc
 		}
 		if ratio == 0.0 {
 			ratio = float64(spaceRemaining) / float64(memstats.HeapAlloc)
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/cbft"
 	pb "github.com/couchbase/cbft/protobuf"
@@ -53,6 +54,202 @@ func closeAndClearGRPCSSLServerList() {
 	grpcServersMutex.Unlock()
 }
 
+func initGrpcOptions(options map[string]string) error {
+	grpcClientLogVerbose := options["grpcClientLogVerbose"]
+	if grpcClientLogVerbose != "" {
+		v, err := strconv.ParseBool(grpcClientLogVerbose)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcClientLogVerbose = v
+	}
+
+	grpcClientSlowThreshold := options["grpcClientSlowThreshold"]
+	if grpcClientSlowThreshold != "" {
+		v, err := time.ParseDuration(grpcClientSlowThreshold)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcClientSlowThreshold = v
+	}
+
+	grpcClientSlowSummaryInterval := options["grpcClientSlowSummaryInterval"]
+	if grpcClientSlowSummaryInterval != "" {
+		v, err := time.ParseDuration(grpcClientSlowSummaryInterval)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcClientSlowSummaryInterval = v
+	}
+
+	grpcStreamKeepAliveInterval := options["grpcStreamKeepAliveInterval"]
+	if grpcStreamKeepAliveInterval != "" {
+		v, err := time.ParseDuration(grpcStreamKeepAliveInterval)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcStreamKeepAliveInterval = v
+	}
+
+	grpcKeepAlivePermitWithoutStream := options["grpcKeepAlivePermitWithoutStream"]
+	if grpcKeepAlivePermitWithoutStream != "" {
+		v, err := strconv.ParseBool(grpcKeepAlivePermitWithoutStream)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcKeepAlivePermitWithoutStream = v
+	}
+
+	grpcClientMaxPIndexes := options["grpcClientMaxPIndexes"]
+	if grpcClientMaxPIndexes != "" {
+		v, err := strconv.Atoi(grpcClientMaxPIndexes)
+		if err != nil {
+			log.Warnf("init_grpc: parsing grpcClientMaxPIndexes: %q, err: %v,"+
+				" using the default: %d", grpcClientMaxPIndexes, err,
+				cbft.DefaultGrpcClientMaxPIndexes)
+		} else {
+			cbft.DefaultGrpcClientMaxPIndexes = v
+		}
+	}
+
+	grpcTracing := options["grpcTracing"]
+	if grpcTracing != "" {
+		v, err := strconv.ParseBool(grpcTracing)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcTracing = v
+	}
+
+	grpcBreakerFailures := options["grpcBreakerFailures"]
+	if grpcBreakerFailures != "" {
+		v, err := strconv.Atoi(grpcBreakerFailures)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcBreakerFailures = v
+	}
+
+	grpcBreakerWindow := options["grpcBreakerWindow"]
+	if grpcBreakerWindow != "" {
+		v, err := time.ParseDuration(grpcBreakerWindow)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcBreakerWindow = v
+	}
+
+	grpcBreakerOpenTimeout := options["grpcBreakerOpenTimeout"]
+	if grpcBreakerOpenTimeout != "" {
+		v, err := time.ParseDuration(grpcBreakerOpenTimeout)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcBreakerOpenTimeout = v
+	}
+
+	grpcScatterGatherWorkers := options["grpcScatterGatherWorkers"]
+	if grpcScatterGatherWorkers != "" {
+		v, err := strconv.Atoi(grpcScatterGatherWorkers)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcScatterGatherWorkers = v
+	}
+
+	grpcBatchSearchConcurrency := options["grpcBatchSearchConcurrency"]
+	if grpcBatchSearchConcurrency != "" {
+		v, err := strconv.Atoi(grpcBatchSearchConcurrency)
+		if err != nil {
+			return err
+		}
+
+		cbft.GrpcBatchSearchConcurrency = v
+	}
+
+	planReachabilityInterval := options["planReachabilityInterval"]
+	if planReachabilityInterval != "" {
+		v, err := time.ParseDuration(planReachabilityInterval)
+		if err != nil {
+			return err
+		}
+
+		cbft.PlanReachabilityInterval = v
+	}
+
+	grpcConnectionIdleTimeout := options["grpcConnectionIdleTimeout"]
+	if grpcConnectionIdleTimeout != "" {
+		v, err := time.ParseDuration(grpcConnectionIdleTimeout)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcConnectionIdleTimeout = v
+	}
+
+	grpcConnectionHeartBeatInterval := options["grpcConnectionHeartBeatInterval"]
+	if grpcConnectionHeartBeatInterval != "" {
+		v, err := time.ParseDuration(grpcConnectionHeartBeatInterval)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcConnectionHeartBeatInterval = v
+	}
+
+	grpcMaxBackOffDelay := options["grpcMaxBackOffDelay"]
+	if grpcMaxBackOffDelay != "" {
+		v, err := time.ParseDuration(grpcMaxBackOffDelay)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcMaxBackOffDelay = v
+	}
+
+	grpcMaxRecvMsgSize := options["grpcMaxRecvMsgSize"]
+	if grpcMaxRecvMsgSize != "" {
+		v, err := strconv.Atoi(grpcMaxRecvMsgSize)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcMaxRecvMsgSize = v
+	}
+
+	grpcMaxSendMsgSize := options["grpcMaxSendMsgSize"]
+	if grpcMaxSendMsgSize != "" {
+		v, err := strconv.Atoi(grpcMaxSendMsgSize)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcMaxSendMsgSize = v
+	}
+
+	grpcMaxConcurrentStreams := options["grpcMaxConcurrentStreams"]
+	if grpcMaxConcurrentStreams != "" {
+		v, err := strconv.ParseUint(grpcMaxConcurrentStreams, 10, 32)
+		if err != nil {
+			return err
+		}
+
+		cbft.DefaultGrpcMaxConcurrentStreams = uint32(v)
+	}
+
+	return nil
+}
+
 func setupGRPCListenersAndServ(mgr *cbgt.Manager,
 	options map[string]string) {
 
@@ -199,9 +396,11 @@ func startGrpcServer(mgr *cbgt.Manager, bindGRPC string, secure bool,
 func getGrpcOpts(secure bool, authType string) []grpc.ServerOption {
 	opts := []grpc.ServerOption{
 		cbft.AddServerInterceptor(),
+		cbft.AddUnaryServerInterceptor(),
+		cbft.KeepaliveEnforcementPolicy(),
 		grpc.MaxConcurrentStreams(cbft.DefaultGrpcMaxConcurrentStreams),
-		grpc.MaxSendMsgSize(cbft.DefaultGrpcMaxRecvMsgSize),
-		grpc.MaxRecvMsgSize(cbft.DefaultGrpcMaxSendMsgSize),
+		grpc.MaxSendMsgSize(cbft.DefaultGrpcMaxSendMsgSize),
+		grpc.MaxRecvMsgSize(cbft.DefaultGrpcMaxRecvMsgSize),
 		// TODO add more configurability
 	}
 
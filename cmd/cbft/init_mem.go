@@ -18,6 +18,8 @@ import (
 	"time"
 
 	"github.com/couchbase/cbft"
+
+	log "github.com/couchbase/clog"
 )
 
 var ftsHerder *appHerder
@@ -49,11 +51,12 @@ func initMemOptions(options map[string]string) (err error) {
 		}
 	}
 
+	var goverseer *Goverseer
 	var goverseerKickCh chan struct{}
 	if memCheckInterval > 0 && memQuota > 0 {
-		g := NewGoverseer(memCheckInterval, memQuota)
-		go g.Run()
-		goverseerKickCh = g.kickCh
+		goverseer = NewGoverseer(memCheckInterval, memQuota)
+		go goverseer.Run()
+		goverseerKickCh = goverseer.kickCh
 	}
 
 	ftsApplicationFraction, err := parseFTSMemApplicationFraction(options)
@@ -72,15 +75,245 @@ func initMemOptions(options map[string]string) (err error) {
 	ftsHerder = newAppHerder(memQuota, ftsApplicationFraction,
 		ftsIndexingFraction, ftsQueryingFraction, goverseerKickCh)
 
+	if goverseer != nil {
+		ftsHerder.setGoverseer(goverseer)
+	}
+
+	v, exists = options["memQueryReservedSlots"]
+	if exists {
+		n, err2 := strconv.Atoi(v)
+		if err2 != nil || n < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQueryReservedSlots: %q, err: %v", v, err2)
+		}
+		ftsHerder.setQueryReservedSlots(n)
+	}
+
+	v, exists = options["memQueryIndexFraction"]
+	if exists {
+		f, err2 := strconv.ParseFloat(v, 64)
+		if err2 != nil || f < 0 || f > 1 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQueryIndexFraction: %q, err: %v", v, err2)
+		}
+		ftsHerder.setQueryIndexFraction(f)
+	}
+
+	v, exists = options["memQueryHighPriorityReserve"]
+	if exists {
+		f, err2 := strconv.ParseFloat(v, 64)
+		if err2 != nil || f < 0 || f > 1 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQueryHighPriorityReserve: %q, err: %v", v, err2)
+		}
+		ftsHerder.setQueryHighPriorityReserve(f)
+	}
+
+	v, exists = options["memQueryDegradedMode"]
+	if exists {
+		b, err2 := strconv.ParseBool(v)
+		if err2 != nil {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQueryDegradedMode: %q, err: %v", v, err2)
+		}
+		ftsHerder.setQueryDegradedMode(b)
+	}
+
+	v, exists = options["memMaxDegradedQueries"]
+	if exists {
+		n, err2 := strconv.Atoi(v)
+		if err2 != nil || n < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memMaxDegradedQueries: %q, err: %v", v, err2)
+		}
+		ftsHerder.setMaxDegradedQueries(n)
+	}
+
+	v, exists = options["memMaxBatchWait"]
+	if exists {
+		d, err2 := time.ParseDuration(v)
+		if err2 != nil || d < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memMaxBatchWait: %q, err: %v", v, err2)
+		}
+		ftsHerder.setMaxBatchWait(d)
+	}
+
+	v, exists = options["memMaxWaitingBatches"]
+	if exists {
+		n, err2 := strconv.Atoi(v)
+		if err2 != nil || n < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memMaxWaitingBatches: %q, err: %v", v, err2)
+		}
+		ftsHerder.setMaxWaitingBatches(n)
+	}
+
+	v, exists = options["memMaxBatchFraction"]
+	if exists {
+		f, err2 := strconv.ParseFloat(v, 64)
+		if err2 != nil || f < 0 || f > 1 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memMaxBatchFraction: %q, err: %v", v, err2)
+		}
+		ftsHerder.setMaxBatchFraction(f)
+	}
+
+	v, exists = options["memMaxQueuedQueries"]
+	if exists {
+		n, err2 := strconv.Atoi(v)
+		if err2 != nil || n < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memMaxQueuedQueries: %q, err: %v", v, err2)
+		}
+
+		d := defaultMaxQueryQueueWait
+		if v, exists = options["memMaxQueryQueueWait"]; exists {
+			d, err2 = time.ParseDuration(v)
+			if err2 != nil || d <= 0 {
+				return fmt.Errorf("init_mem:"+
+					" parsing memMaxQueryQueueWait: %q, err: %v", v, err2)
+			}
+		}
+
+		ftsHerder.setQueryQueue(n, d)
+	}
+
+	v, exists = options["memQueryEstimateMaxCorrection"]
+	if exists {
+		f, err2 := strconv.ParseFloat(v, 64)
+		if err2 != nil || (f != 0 && f < 1) {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQueryEstimateMaxCorrection: %q, err: %v", v, err2)
+		}
+		ftsHerder.setQueryEstimateMaxCorrection(f)
+	}
+
+	var freeMemoryFloor, freeMemoryHeadroom uint64
+	v, exists = options["memFreeFloor"] // In bytes.
+	if exists {
+		var err2 error
+		freeMemoryFloor, err2 = strconv.ParseUint(v, 10, 64)
+		if err2 != nil {
+			return fmt.Errorf("init_mem:"+
+				" parsing memFreeFloor: %q, err: %v", v, err2)
+		}
+	}
+	v, exists = options["memFreeHeadroom"] // In bytes.
+	if exists {
+		var err2 error
+		freeMemoryHeadroom, err2 = strconv.ParseUint(v, 10, 64)
+		if err2 != nil {
+			return fmt.Errorf("init_mem:"+
+				" parsing memFreeHeadroom: %q, err: %v", v, err2)
+		}
+	}
+	if freeMemoryFloor > 0 && freeMemoryHeadroom > 0 &&
+		freeMemoryHeadroom <= freeMemoryFloor {
+		return fmt.Errorf("init_mem: memFreeHeadroom: %d, must be above"+
+			" the memFreeFloor: %d", freeMemoryHeadroom, freeMemoryFloor)
+	}
+	if freeMemoryFloor > 0 || freeMemoryHeadroom > 0 {
+		ftsHerder.setFreeMemoryBounds(freeMemoryFloor, freeMemoryHeadroom)
+	}
+
+	v, exists = options["memWatchdogThreshold"]
+	if exists {
+		d, err2 := time.ParseDuration(v)
+		if err2 != nil || d < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memWatchdogThreshold: %q, err: %v", v, err2)
+		}
+
+		action := watchdogActionLog
+		if v, exists = options["memWatchdogAction"]; exists {
+			if !validWatchdogAction(v) {
+				return fmt.Errorf("init_mem:"+
+					" parsing memWatchdogAction: %q", v)
+			}
+			action = v
+		}
+
+		ftsHerder.setWatchdog(d, action)
+	}
+
+	v, exists = options["memQuotaRebalanceInterval"]
+	if exists {
+		d, err2 := time.ParseDuration(v)
+		if err2 != nil || d < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing memQuotaRebalanceInterval: %q, err: %v", v, err2)
+		}
+
+		step := defaultQuotaRebalanceStep
+		if v, exists = options["memQuotaRebalanceStep"]; exists {
+			step, err2 = strconv.ParseFloat(v, 64)
+			if err2 != nil || step <= 0 || step > 1 {
+				return fmt.Errorf("init_mem:"+
+					" parsing memQuotaRebalanceStep: %q, err: %v", v, err2)
+			}
+		}
+
+		ftsHerder.setQuotaRebalance(d, step)
+	}
+
 	cbft.RegistryQueryEventCallback = ftsHerder.queryHerderOnEvent()
 
+	cbft.CurMemoryPressure = ftsHerder.MemoryPressure
+
+	cbft.MaxBatchBytes = ftsHerder.maxBatchBytes
+
+	cbft.BatchAdmission = ftsHerder.BatchAdmission()
+
+	cbft.CorrectQueryEstimate = ftsHerder.correctQueryEstimate
+
+	cbft.SetSubscribeOverQuota(ftsHerder.subscribeOverQuota)
+
 	cbft.OnMemoryUsedDropped = func(curMemoryUsed, prevMemoryUsed uint64) {
 		ftsHerder.onMemoryUsedDropped(curMemoryUsed, prevMemoryUsed)
 	}
 
+	cbft.OnManagerOptionsUpdated = func(options map[string]string) {
+		err := updateMemQuotaOptions(ftsHerder, options)
+		if err != nil {
+			log.Warnf("init_mem: updating the mem quota, err: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// updateMemQuotaOptions applies the mem quota and fractions of the
+// updated options to the herder.
+func updateMemQuotaOptions(ah *appHerder, options map[string]string) error {
+	var memQuota uint64
+	v, exists := options["ftsMemoryQuota"] // In bytes.
+	if exists {
+		fmq, err := strconv.Atoi(v)
+		if err != nil || fmq < 0 {
+			return fmt.Errorf("init_mem:"+
+				" parsing ftsMemoryQuota: %q, err: %v", v, err)
+		}
+		memQuota = uint64(fmq)
+	}
+
+	ftsApplicationFraction, err := parseFTSMemApplicationFraction(options)
+	if err != nil {
+		return err
+	}
+	ftsIndexingFraction, err := parseFTSMemIndexingFraction(options)
+	if err != nil {
+		return err
+	}
+	ftsQueryingFraction, err := parseFTSMemQueryingFraction(options)
+	if err != nil {
+		return err
+	}
+
+	return ah.UpdateQuota(memQuota, ftsApplicationFraction,
+		ftsIndexingFraction, ftsQueryingFraction)
+}
+
 // defaultFTSApplicationFraction is default ratio for the
 // memApplicationFraction of the mem quota (default 100%)
 var defaultFTSApplicationFraction = 1.0
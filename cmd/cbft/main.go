@@ -247,6 +247,9 @@ func main() {
 
 	setupHTTPListenersAndServ(routerInUse, bindHTTPList, options)
 
+	// drain the app herder ahead of the exit
+	go quiesceOnShutdown(ftsHerder, herderQuiesceTimeout)
+
 	<-(make(chan struct{})) // Block forever.
 }
 
@@ -331,6 +334,11 @@ func mainStart(cfg cbgt.Cfg, uuid string, tags []string, container string,
 		return nil, err
 	}
 
+	err = initGrpcOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
 	if options["logStatsEvery"] != "" {
 		var logStatsEvery int
 		logStatsEvery, err = strconv.Atoi(options["logStatsEvery"])
@@ -483,6 +491,8 @@ func mainStart(cfg cbgt.Cfg, uuid string, tags []string, container string,
 
 	setupGRPCListenersAndServ(mgr, options)
 
+	cbft.StartPlanReachabilityCheck(mgr, nil)
+
 	muxrouter, _, err :=
 		cbft.NewRESTRouter(version, mgr, staticDir, staticETag, mr, adtSvc)
 	if err != nil {
@@ -549,6 +559,9 @@ func mainStart(cfg cbgt.Cfg, uuid string, tags []string, container string,
 	handle(prefix+"/api/query/index/{indexName}", "GET",
 		cbft.NewQuerySupervisorDetails())
 
+	handle(prefix+"/api/planReachability", "GET",
+		cbft.NewPlanReachabilityHandler())
+
 	router := exportMuxRoutesToHttprouter(muxrouter)
 
 	router.Handler("PUT", prefix+"/api/managerOptions",
@@ -88,6 +88,12 @@ func tryBasicAuth(req interface{}, ctx context.Context,
 	authFunc = aw.authenticate
 
 	nctx := context.WithValue(ctx, gRPCAuthHandlerKey, authFunc)
+
+	// the queries that fan out from here are authorized for the user
+	// by the remote nodes too
+	nctx = WithQueryAuth(nctx, QueryAuth{User: creds.Name(),
+		Domain: creds.Domain()})
+
 	return nctx, nil
 }
 
@@ -191,16 +197,17 @@ func (rp *rpcRequestParser) GetPIndexName() (string, error) {
 }
 
 func verifyRPCAuth(ctx context.Context, indexName string, req interface{}) error {
-	if _, err := extractMetaHeader(ctx, rpcClusterActionKey); err == nil {
-		return nil
-	}
-
 	var authHandler gRPCAuthHandler
 	if aw := ctx.Value(gRPCAuthHandlerKey); aw != nil {
 		authHandler = aw.(gRPCAuthHandler)
 	}
 
 	if authHandler == nil {
+		// the scatter-gather requests that don't carry the auth of the
+		// user rely on the cluster-internal auth
+		if _, err := extractMetaHeader(ctx, rpcClusterActionKey); err == nil {
+			return nil
+		}
 		return fmt.Errorf("grpc_auth: invalid authHandler")
 	}
 
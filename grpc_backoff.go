@@ -0,0 +1,137 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+)
+
+// DefaultGrpcOverQuotaBackoff is how long after an over-quota signal
+// the gRPC clients hold back the dispatch of new rpcs, where 0, the
+// default, disables the backoff, which is opted into by the option.
+var DefaultGrpcOverQuotaBackoff = time.Duration(0)
+
+// totGrpcThrottledDispatches tracks the rpcs whose dispatch was held
+// back after an over-quota signal.
+var totGrpcThrottledDispatches uint64
+
+// lastOverQuotaNS is the time, in unix nanoseconds, of the last
+// over-quota signal seen by the gRPC clients, or 0 if none.
+var lastOverQuotaNS int64
+
+var overQuotaM sync.Mutex       // Protects the fields that follow.
+var overQuotaUnsubscribe func() // Ends the current subscription, if any.
+
+// SetSubscribeOverQuota subscribes the gRPC clients to the over-quota
+// signals of the app_herder with the subscribe, which subscribes the ch
+// to the signals, sent to it without blocking, and returns the func
+// that ends the subscription.  The gRPC clients then back off from
+// dispatching new rpcs after the signals, while the node is under
+// memory pressure, rather than piling on.  Each set ends the earlier
+// subscription, if any, where a nil subscribe just ends it.
+func SetSubscribeOverQuota(subscribe func(ch chan struct{}) func()) {
+	overQuotaM.Lock()
+	defer overQuotaM.Unlock()
+
+	if overQuotaUnsubscribe != nil {
+		overQuotaUnsubscribe()
+		overQuotaUnsubscribe = nil
+	}
+
+	if subscribe == nil {
+		return
+	}
+
+	ch := make(chan struct{}, 1)
+	stopCh := make(chan struct{})
+	unsubscribe := subscribe(ch)
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+				atomic.StoreInt64(&lastOverQuotaNS, time.Now().UnixNano())
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	overQuotaUnsubscribe = func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+		close(stopCh)
+	}
+}
+
+// overQuotaDelay returns how much longer a dispatch at the now should
+// be held back, after the over-quota signal at the last, within the
+// backoff, where the times are in unix nanoseconds.
+func overQuotaDelay(now, last int64, backoff time.Duration) time.Duration {
+	if last <= 0 || backoff <= 0 {
+		return 0
+	}
+	if delay := time.Duration(last + int64(backoff) - now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// throttleOnOverQuota holds back the dispatch of a new rpc until the
+// backoff after the last over-quota signal has passed, or until the
+// ctx is done, whose err is then returned.
+func throttleOnOverQuota(ctx context.Context, mgr *cbgt.Manager) error {
+	delay := overQuotaDelay(time.Now().UnixNano(),
+		atomic.LoadInt64(&lastOverQuotaNS), grpcOverQuotaBackoff(mgr))
+	if delay <= 0 {
+		return nil
+	}
+
+	atomic.AddUint64(&totGrpcThrottledDispatches, 1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// grpcOverQuotaBackoff returns the backoff after an over-quota signal,
+// from the "grpcOverQuotaBackoff" manager option, where 0 disables it.
+func grpcOverQuotaBackoff(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return DefaultGrpcOverQuotaBackoff
+	}
+
+	if v := mgr.Options()["grpcOverQuotaBackoff"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Warnf("grpc_client: invalid grpcOverQuotaBackoff: %q,"+
+				" err: %v", v, err)
+		} else {
+			return d
+		}
+	}
+
+	return DefaultGrpcOverQuotaBackoff
+}
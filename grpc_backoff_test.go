@@ -0,0 +1,128 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestOverQuotaDelay(t *testing.T) {
+	ms := int64(time.Millisecond)
+
+	tests := []struct {
+		now, last int64
+		backoff   time.Duration
+		exp       time.Duration
+	}{
+		{now: 100 * ms, last: 0, backoff: 50 * time.Millisecond, exp: 0},
+		{now: 100 * ms, last: 80 * ms, backoff: 50 * time.Millisecond,
+			exp: 30 * time.Millisecond},
+		{now: 100 * ms, last: 50 * ms, backoff: 50 * time.Millisecond, exp: 0},
+		{now: 100 * ms, last: 80 * ms, backoff: 0, exp: 0},
+	}
+
+	for i, test := range tests {
+		if got := overQuotaDelay(test.now, test.last, test.backoff); got !=
+			test.exp {
+			t.Errorf("test: %d, expected delay: %v, got: %v", i, test.exp, got)
+		}
+	}
+}
+
+func TestThrottleOnOverQuota(t *testing.T) {
+	prev := atomic.LoadUint64(&totGrpcThrottledDispatches)
+	defer atomic.StoreInt64(&lastOverQuotaNS, 0)
+
+	defer func(d time.Duration) {
+		DefaultGrpcOverQuotaBackoff = d
+	}(DefaultGrpcOverQuotaBackoff)
+	DefaultGrpcOverQuotaBackoff = 50 * time.Millisecond
+
+	// no dispatch is held back without any over-quota signal
+	if err := throttleOnOverQuota(context.Background(), nil); err != nil ||
+		atomic.LoadUint64(&totGrpcThrottledDispatches) != prev {
+		t.Errorf("expected no throttling, err: %v", err)
+	}
+
+	atomic.StoreInt64(&lastOverQuotaNS, time.Now().UnixNano())
+
+	start := time.Now()
+	if err := throttleOnOverQuota(context.Background(), nil); err != nil {
+		t.Errorf("expected the dispatch after the backoff, err: %v", err)
+	}
+	if time.Since(start) < DefaultGrpcOverQuotaBackoff/2 {
+		t.Errorf("expected the dispatch to be held back")
+	}
+
+	atomic.StoreInt64(&lastOverQuotaNS, time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := throttleOnOverQuota(ctx, nil); err != context.Canceled {
+		t.Errorf("expected the ctx err, got: %v", err)
+	}
+
+	if n := atomic.LoadUint64(&totGrpcThrottledDispatches) - prev; n != 2 {
+		t.Errorf("expected 2 throttled dispatches, got: %d", n)
+	}
+}
+
+func TestSetSubscribeOverQuota(t *testing.T) {
+	defer atomic.StoreInt64(&lastOverQuotaNS, 0)
+	defer SetSubscribeOverQuota(nil)
+
+	subscribe := func(chs chan chan struct{},
+		unsubscribed chan struct{}) func(chan struct{}) func() {
+		return func(ch chan struct{}) func() {
+			chs <- ch
+			return func() { close(unsubscribed) }
+		}
+	}
+
+	chs := make(chan chan struct{}, 1)
+	unsubscribed := make(chan struct{})
+	SetSubscribeOverQuota(subscribe(chs, unsubscribed))
+
+	// the signals are seen once subscribed
+	ch := <-chs
+	ch <- struct{}{}
+	for i := 0; atomic.LoadInt64(&lastOverQuotaNS) == 0; i++ {
+		if i >= 500 {
+			t.Fatalf("expected the over-quota signal to be seen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// each set subscribes anew, ending the earlier subscription
+	chs2 := make(chan chan struct{}, 1)
+	unsubscribed2 := make(chan struct{})
+	SetSubscribeOverQuota(subscribe(chs2, unsubscribed2))
+	select {
+	case <-unsubscribed:
+	default:
+		t.Errorf("expected the earlier subscription to be ended")
+	}
+	if ch2 := <-chs2; ch2 == ch {
+		t.Errorf("expected a subscription of its own")
+	}
+
+	SetSubscribeOverQuota(nil)
+	select {
+	case <-unsubscribed2:
+	default:
+		t.Errorf("expected the subscription to be ended")
+	}
+}
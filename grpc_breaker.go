@@ -0,0 +1,299 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcBreakerFailures is the number of consecutive failed calls to a
+// remote node, within the GrpcBreakerWindow, that opens the circuit
+// breaker of the node, so that the further calls to the node fail
+// fast rather than wait out their timeouts.  It's overridable by the
+// "grpcBreakerFailures" option, where 0 disables the breakers.
+var GrpcBreakerFailures = 0
+
+// GrpcBreakerWindow is the window within which the consecutive
+// failures are counted.
+var GrpcBreakerWindow = 10 * time.Second
+
+// GrpcBreakerOpenTimeout is how long a breaker stays open, before a
+// single probe call is let through to the node, which closes the
+// breaker on success or else reopens it.
+var GrpcBreakerOpenTimeout = 10 * time.Second
+
+// errGrpcBreakerOpen is the fast error of the calls to a node whose
+// breaker is open, which isn't retried.
+var errGrpcBreakerOpen = status.Error(codes.Unavailable,
+	"grpc_client: circuit breaker open for the node")
+
+// totGrpcBreakerOpened tracks the breakers that were opened, and
+// totGrpcBreakerRejected the calls that failed fast on them.
+var totGrpcBreakerOpened uint64
+var totGrpcBreakerRejected uint64
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// nodeBreaker is the circuit breaker of the calls to a remote node.
+type nodeBreaker struct {
+	m            sync.Mutex
+	state        breakerState
+	failures     int       // The consecutive failures.
+	firstFailure time.Time // Of the consecutive failures.
+	openedAt     time.Time
+	probeAt      time.Time // Of the in-flight probe, if any.
+}
+
+// rpcNodeBreakers are the breakers keyed by the hostPort of the remote
+// nodes, which outlive the connection pools of the nodes, so that an
+// idle eviction doesn't reset them.
+var rpcNodeBreakers = map[string]*nodeBreaker{}
+
+var rpcNodeBreakersMutex sync.Mutex
+
+func getNodeBreaker(hostPort string) *nodeBreaker {
+	rpcNodeBreakersMutex.Lock()
+	b, exists := rpcNodeBreakers[hostPort]
+	if !exists {
+		b = &nodeBreaker{}
+		rpcNodeBreakers[hostPort] = b
+	}
+	rpcNodeBreakersMutex.Unlock()
+	return b
+}
+
+// allow returns true when a call may proceed, which is always when the
+// breaker is closed, and only for a single probe at a time once an
+// open breaker has timed out.
+func (b *nodeBreaker) allow(now time.Time) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < GrpcBreakerOpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+
+	case breakerHalfOpen:
+		// a probe whose outcome was never seen, such as of an abandoned
+		// stream, is given up on after the open timeout
+		if !b.probeAt.IsZero() &&
+			now.Sub(b.probeAt) < GrpcBreakerOpenTimeout {
+			return false
+		}
+	}
+
+	if b.state == breakerHalfOpen {
+		b.probeAt = now
+	}
+
+	return true
+}
+
+// record accounts the outcome of a call to the node, made with the ctx.
+func (b *nodeBreaker) record(ctx context.Context, hostPort string,
+	now time.Time, err error) {
+	failed, ignored := breakerOutcome(ctx, err)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if ignored {
+		if b.state == breakerHalfOpen {
+			b.probeAt = time.Time{}
+		}
+		return
+	}
+
+	if !failed {
+		if b.state != breakerClosed {
+			log.Printf("grpc_client: circuit breaker closed for host: %s",
+				hostPort)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.probeAt = time.Time{}
+		return
+	}
+
+	switch b.state {
+	case breakerOpen:
+		// the calls started before the breaker was opened
+		return
+
+	case breakerHalfOpen:
+		b.openLOCKED(hostPort, now)
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > GrpcBreakerWindow {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+
+	if GrpcBreakerFailures > 0 && b.failures >= GrpcBreakerFailures {
+		b.openLOCKED(hostPort, now)
+	}
+}
+
+func (b *nodeBreaker) openLOCKED(hostPort string, now time.Time) {
+	log.Warnf("grpc_client: circuit breaker opened for host: %s,"+
+		" failures: %d, previous state: %s", hostPort, b.failures, b.state)
+
+	b.state = breakerOpen
+	b.failures = 0
+	b.openedAt = now
+	b.probeAt = time.Time{}
+
+	atomic.AddUint64(&totGrpcBreakerOpened, 1)
+}
+
+// breakerOutcome classifies the error of a call, where only the errors
+// that hint at an unhealthy node are failures, and the cancellations
+// by the caller, or the expiry of its own deadline, tell nothing about
+// the node.
+func breakerOutcome(ctx context.Context, err error) (failed, ignored bool) {
+	if err != nil && ctx.Err() != nil {
+		return false, true
+	}
+
+	switch grpcErrCode(err) {
+	case codes.OK:
+		return false, false
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return err != errGrpcBreakerOpen, err == errGrpcBreakerOpen
+	case codes.Canceled:
+		return false, true
+	}
+	// the node did respond
+	return false, false
+}
+
+// GrpcBreakerStates returns the states of the breakers that aren't
+// closed, keyed by the hostPort of the nodes.
+func GrpcBreakerStates() map[string]string {
+	rpcNodeBreakersMutex.Lock()
+	hostPorts := make([]string, 0, len(rpcNodeBreakers))
+	breakers := make([]*nodeBreaker, 0, len(rpcNodeBreakers))
+	for hostPort, b := range rpcNodeBreakers {
+		hostPorts = append(hostPorts, hostPort)
+		breakers = append(breakers, b)
+	}
+	rpcNodeBreakersMutex.Unlock()
+
+	rv := map[string]string{}
+	for i, b := range breakers {
+		b.m.Lock()
+		if b.state != breakerClosed {
+			rv[hostPorts[i]] = b.state.String()
+		}
+		b.m.Unlock()
+	}
+	return rv
+}
+
+// grpcBreakersOpen returns the number of breakers that aren't closed.
+func grpcBreakersOpen() int {
+	return len(GrpcBreakerStates())
+}
+
+// breakerUnaryClientInterceptor fails the unary calls fast while the
+// breaker of their node is open.
+func breakerUnaryClientInterceptor(ctx context.Context, method string,
+	req interface{}, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption) error {
+	b := getNodeBreaker(cc.Target())
+	if !b.allow(time.Now()) {
+		atomic.AddUint64(&totGrpcBreakerRejected, 1)
+		return errGrpcBreakerOpen
+	}
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.record(ctx, cc.Target(), time.Now(), err)
+	return err
+}
+
+// breakerStreamClientInterceptor fails the streaming calls fast while
+// the breaker of their node is open, where the outcome of a stream is
+// only known once it ends.
+func breakerStreamClientInterceptor(ctx context.Context,
+	desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (
+	grpc.ClientStream, error) {
+	b := getNodeBreaker(cc.Target())
+	if !b.allow(time.Now()) {
+		atomic.AddUint64(&totGrpcBreakerRejected, 1)
+		return nil, errGrpcBreakerOpen
+	}
+
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		b.record(ctx, cc.Target(), time.Now(), err)
+		return nil, err
+	}
+
+	return &breakerClientStream{ClientStream: cs,
+		ctx: ctx, breaker: b, hostPort: cc.Target()}, nil
+}
+
+// breakerClientStream wraps a grpc.ClientStream to record its outcome
+// once the stream ends, where the ctx is the caller's, as the ctx of
+// the stream itself is canceled as it ends.
+type breakerClientStream struct {
+	grpc.ClientStream
+
+	ctx      context.Context
+	breaker  *nodeBreaker
+	hostPort string
+	done     uint32
+}
+
+func (s *breakerClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && atomic.CompareAndSwapUint32(&s.done, 0, 1) {
+		if err == io.EOF {
+			s.breaker.record(s.ctx, s.hostPort, time.Now(), nil)
+		} else {
+			s.breaker.record(s.ctx, s.hostPort, time.Now(), err)
+		}
+	}
+	return err
+}
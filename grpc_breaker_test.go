@@ -0,0 +1,174 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setGrpcBreakerParams(failures int, window, openTimeout time.Duration) func() {
+	prevFailures := GrpcBreakerFailures
+	prevWindow := GrpcBreakerWindow
+	prevOpenTimeout := GrpcBreakerOpenTimeout
+	GrpcBreakerFailures = failures
+	GrpcBreakerWindow = window
+	GrpcBreakerOpenTimeout = openTimeout
+	return func() {
+		GrpcBreakerFailures = prevFailures
+		GrpcBreakerWindow = prevWindow
+		GrpcBreakerOpenTimeout = prevOpenTimeout
+	}
+}
+
+func TestNodeBreaker(t *testing.T) {
+	defer setGrpcBreakerParams(3, time.Second, 5*time.Second)()
+
+	ctx := context.Background()
+	unavailable := status.Error(codes.Unavailable, "down")
+	b := &nodeBreaker{}
+	now := time.Now()
+
+	// the failures spread beyond the window don't open the breaker
+	b.record(ctx, "h", now, unavailable)
+	b.record(ctx, "h", now.Add(100*time.Millisecond), unavailable)
+	now = now.Add(2 * time.Second)
+	b.record(ctx, "h", now, unavailable)
+	if b.state != breakerClosed || b.failures != 1 {
+		t.Fatalf("expected a closed breaker, got: %s, %d",
+			b.state, b.failures)
+	}
+
+	// nor do the failures interleaved with successes, or the errors
+	// from a responsive node
+	b.record(ctx, "h", now, nil)
+	b.record(ctx, "h", now, unavailable)
+	b.record(ctx, "h", now, status.Error(codes.InvalidArgument, "bad"))
+	b.record(ctx, "h", now, context.Canceled)
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Fatalf("expected a closed breaker, got: %s, %d",
+			b.state, b.failures)
+	}
+
+	// nor do the deadlines of the callers themselves expiring
+	expiredCtx, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	<-expiredCtx.Done()
+	b.record(ctx, "h", now, unavailable)
+	b.record(expiredCtx, "h", now, status.Error(codes.DeadlineExceeded,
+		"context deadline exceeded"))
+	b.record(expiredCtx, "h", now, context.DeadlineExceeded)
+	if b.state != breakerClosed || b.failures != 1 {
+		t.Fatalf("expected a closed breaker, got: %s, %d",
+			b.state, b.failures)
+	}
+	b.record(ctx, "h", now, nil)
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Fatalf("expected a closed breaker, got: %s, %d",
+			b.state, b.failures)
+	}
+
+	prevOpened := atomic.LoadUint64(&totGrpcBreakerOpened)
+	b.record(ctx, "h", now, unavailable)
+	b.record(ctx, "h", now, context.DeadlineExceeded)
+	b.record(ctx, "h", now, unavailable)
+	if b.state != breakerOpen ||
+		atomic.LoadUint64(&totGrpcBreakerOpened) != prevOpened+1 {
+		t.Fatalf("expected an opened breaker, got: %s", b.state)
+	}
+	if b.allow(now.Add(time.Second)) {
+		t.Errorf("expected an open breaker to fail fast")
+	}
+	if states := GrpcBreakerStates(); len(states) != 0 {
+		t.Errorf("expected no registered breakers, got: %v", states)
+	}
+
+	// a single probe goes through once the breaker has timed out, and
+	// reopens it on failure
+	now = now.Add(5 * time.Second)
+	if !b.allow(now) || b.state != breakerHalfOpen {
+		t.Fatalf("expected a probe, got: %s", b.state)
+	}
+	if b.allow(now) {
+		t.Errorf("expected a single probe at a time")
+	}
+	b.record(ctx, "h", now, unavailable)
+	if b.state != breakerOpen || b.allow(now) {
+		t.Fatalf("expected a reopened breaker, got: %s", b.state)
+	}
+
+	// an unseen probe is given up on, and a successful one closes it
+	now = now.Add(5 * time.Second)
+	if !b.allow(now) {
+		t.Fatalf("expected a probe")
+	}
+	now = now.Add(5 * time.Second)
+	if !b.allow(now) {
+		t.Fatalf("expected another probe, given up on the unseen one")
+	}
+	b.record(ctx, "h", now, nil)
+	if b.state != breakerClosed || !b.allow(now) || !b.allow(now) {
+		t.Fatalf("expected a closed breaker, got: %s", b.state)
+	}
+}
+
+func TestBreakerUnaryClientInterceptor(t *testing.T) {
+	defer setGrpcBreakerParams(2, time.Minute, time.Minute)()
+
+	cc, err := grpc.Dial("breaker-test-host:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	defer func() {
+		rpcNodeBreakersMutex.Lock()
+		delete(rpcNodeBreakers, cc.Target())
+		rpcNodeBreakersMutex.Unlock()
+	}()
+
+	var calls int
+	unavailable := status.Error(codes.Unavailable, "down")
+	invoker := func(ctx context.Context, method string,
+		req interface{}, reply interface{},
+		cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return unavailable
+	}
+
+	for i := 0; i < 2; i++ {
+		err = breakerUnaryClientInterceptor(context.Background(),
+			"/search/DocCount", nil, nil, cc, invoker)
+		if err != unavailable {
+			t.Fatalf("expected the invoker err, got: %v", err)
+		}
+	}
+
+	if states := GrpcBreakerStates(); states[cc.Target()] != "open" {
+		t.Fatalf("expected an open breaker, got: %v", states)
+	}
+
+	prevRejected := atomic.LoadUint64(&totGrpcBreakerRejected)
+	err = breakerUnaryClientInterceptor(context.Background(),
+		"/search/DocCount", nil, nil, cc, invoker)
+	if err != errGrpcBreakerOpen || calls != 2 {
+		t.Errorf("expected a fast error, got: %v, calls: %d", err, calls)
+	}
+	if atomic.LoadUint64(&totGrpcBreakerRejected) != prevRejected+1 {
+		t.Errorf("expected the fast error to be counted")
+	}
+}
@@ -15,11 +15,15 @@
 package cbft
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"math/rand"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
@@ -34,14 +38,66 @@ import (
 	pb "github.com/couchbase/cbft/protobuf"
 	"github.com/couchbase/cbgt"
 	log "github.com/couchbase/clog"
+	"github.com/golang/protobuf/proto"
+	metrics "github.com/rcrowley/go-metrics"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 const rpcClusterActionKey = "rpcclusteractionkey"
 
+// rpcDeadlineKey is the metadata key carrying the absolute deadline,
+// in unix nanoseconds, at which the server should abort the query.
+const rpcDeadlineKey = "rpcdeadline"
+
+// trailer metadata keys used by the server to report the outcome and
+// the duration (in nanoseconds) of a query's consistency wait.
+const rpcConsistencyWaitKey = "rpcconsistencywait"
+const rpcConsistencyWaitDurationKey = "rpcconsistencywaitduration"
+
+const consistencyWaitSucceeded = "succeeded"
+const consistencyWaitTimedout = "timedout"
+
+// totGrpcConsistencyWaitSucceeded and totGrpcConsistencyWaitTimedout
+// track the consistency wait outcomes reported by the remote servers.
+var totGrpcConsistencyWaitSucceeded uint64
+var totGrpcConsistencyWaitTimedout uint64
+
+// grpcConsistencyWaitHistogram tracks the consistency wait durations
+// (in nanoseconds) reported by the remote servers.
+var grpcConsistencyWaitHistogram = metrics.NewHistogram(
+	metrics.NewExpDecaySample(1028, 0.015))
+
+// stats tracked by the clientStreamInterceptor for the streaming
+// rpc's, like Search.
+var totGrpcClientStreams uint64
+var totGrpcClientStreamErrs uint64
+var totGrpcClientStreamSetupTimeNS uint64
+var totGrpcClientStreamMsgsRecv uint64
+var totGrpcClientStreamBytesRecv uint64
+
+// DefaultGrpcSearchRetries is the default max number of retries of a
+// search that failed as the remote node was unavailable, and the
+// DefaultGrpcSearchRetryBackoff is the base delay of their backoff.
+var DefaultGrpcSearchRetries = 2
+var DefaultGrpcSearchRetryBackoff = 100 * time.Millisecond
+
+// totGrpcSearchRetries tracks the retries of the searches, of which
+// totGrpcSearchRetriesSucceeded tracks the ones that succeeded.
+var totGrpcSearchRetries uint64
+var totGrpcSearchRetriesSucceeded uint64
+
+// totGrpcClientSelfLoopSkipped tracks the remote pindexes skipped for
+// being planned on the local node, which would be a gRPC self-call.
+var totGrpcClientSelfLoopSkipped uint64
+
+// totGrpcBulkDocCounts tracks the DocCount rpcs that counted all the
+// pindexes of a client in one round trip.
+var totGrpcBulkDocCounts uint64
+
 // GrpcClient implements the Search() and DocCount() subset of the
 // bleve.Index interface by accessing a remote cbft server via grpc
 // protocol.  This allows callers to add a GrpcClient as a target of
@@ -58,10 +114,45 @@ type GrpcClient struct {
 	Consistency *cbgt.ConsistencyParams
 	GrpcCli     pb.SearchServiceClient
 
+	// Secure is whether the connection to the node is over TLS, which
+	// the auth of the user of a query is only ever sent over.
+	Secure bool
+
+	// Deadline is an optional, absolute deadline hint, where the
+	// earlier of it and the ctx deadline bounds the query.
+	Deadline time.Time
+
+	// RequestOverhead is the time reserved out of the query's timeout
+	// for the round trip, where 0 means the RemoteRequestOverhead.
+	RequestOverhead time.Duration
+
+	// PerPIndexTimeout, when set, caps the timeout of a query at this
+	// much per pindex of the client.
+	PerPIndexTimeout time.Duration
+
+	// DispatchStagger, when set, holds back the dispatch of a query by
+	// up to this long, so that a wide fan-out doesn't hit all the nodes
+	// at once.
+	DispatchStagger time.Duration
+
+	// RequestRewriter, when set, rewrites the search requests sent to
+	// the node, in place of the registered SearchRequestRewriter.
+	RequestRewriter SearchRequestRewriter
+
 	lastMutex        sync.RWMutex
 	lastSearchStatus int
 	lastErrBody      []byte
+	lastConsistency  map[string]*PIndexConsistency
+	lastServedBy     *ServedBy
 	sc               streamHandler
+
+	// activeStream is the stream of the search in flight, if any, over
+	// which its pindexes may be abandoned, guarded by the lastMutex.
+	activeStream searchResultsStream
+
+	// connRefs are the references to the shared connections used by
+	// the client, which are released by Close().
+	connRefs []*rpcConnRef
 }
 
 func (g *GrpcClient) SetStreamHandler(sc streamHandler) {
@@ -78,6 +169,64 @@ func (g *GrpcClient) GetLast() (int, []byte) {
 	return g.lastSearchStatus, g.lastErrBody
 }
 
+// setLast records the outcome of the last search, where err is the
+// error of the RPC, if any.
+func (g *GrpcClient) setLast(err error) {
+	code := grpcErrCode(err)
+
+	var errBody []byte
+	if err != nil {
+		errBody, _ = MarshalJSON(struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}{
+			Status: code.String(),
+			Error:  err.Error(),
+		})
+	}
+
+	g.lastMutex.Lock()
+	g.lastSearchStatus = httpStatusCodes(code)
+	g.lastErrBody = errBody
+	g.lastMutex.Unlock()
+}
+
+// grpcErrCode returns the gRPC status code of the error of an RPC,
+// including of the ctx errors the client returns as is.
+func grpcErrCode(err error) codes.Code {
+	switch err {
+	case context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case context.Canceled:
+		return codes.Canceled
+	}
+	return status.Code(err)
+}
+
+// grpcMsgSizeRE matches the gRPC errors of the messages exceeding the
+// max message size, on either the client or the server.
+var grpcMsgSizeRE = regexp.MustCompile(
+	`message larger than max \((\d+) vs\. (\d+)\)`)
+
+// grpcMsgSizeErr rewrites the error of an RPC whose message exceeded
+// the max message size into one that states the size and the limit,
+// and how to raise it, leaving the other errors as is.
+func grpcMsgSizeErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	m := grpcMsgSizeRE.FindStringSubmatch(status.Convert(err).Message())
+	if m == nil {
+		return err
+	}
+
+	return status.Errorf(codes.ResourceExhausted,
+		"grpc_client: message size: %s bytes exceeds the limit: %s bytes,"+
+			" which is configurable by the grpcMaxRecvMsgSize and"+
+			" grpcMaxSendMsgSize options", m[1], m[2])
+}
+
 func (g *GrpcClient) Name() string {
 	return g.name
 }
@@ -102,14 +251,272 @@ func (g *GrpcClient) Document(id string) (*document.Document, error) {
 	return nil, indexClientUnimplementedErr
 }
 
+// DocCount returns the sum of the doc counts of all the pindexes of
+// the client, which may have been grouped onto it, where any failed
+// pindex fails the count, so that an undercount is never returned.
+// Use DocCountDetailed for a partial count instead.
 func (g *GrpcClient) DocCount() (uint64, error) {
-	request := &pb.DocCountRequest{IndexName: g.PIndexNames[0],
-		IndexUUID: ""}
-	res, err := g.GrpcCli.DocCount(context.Background(), request)
+	return g.docCount(context.Background())
+}
+
+// DocCountContext returns the doc count while honoring the ctx, where
+// the cancellation of the ctx cancels the underlying RPC and returns
+// the ctx's error.
+func (g *GrpcClient) DocCountContext(ctx context.Context) (uint64, error) {
+	return g.docCount(ctx)
+}
+
+func (g *GrpcClient) docCount(ctx context.Context) (uint64, error) {
+	details, err := g.DocCountDetailed(ctx)
 	if err != nil {
 		return 0, err
 	}
-	return uint64(res.DocCount), nil
+
+	// strictly, any failed pindex fails the count
+	for _, pindexName := range g.PIndexNames {
+		if er, exists := details.Errors[pindexName]; exists {
+			return 0, er
+		}
+	}
+
+	return details.Count, nil
+}
+
+// DocCountDetails is the doc count of the pindexes of a client, where
+// Count sums the pindexes that were counted and Errors holds the
+// errors of the pindexes that couldn't be counted, keyed by pindex
+// name.
+type DocCountDetails struct {
+	Count  uint64
+	Errors map[string]error
+}
+
+// DocCountDetailed counts each of the pindexes of the client, where a
+// failed pindex doesn't fail the whole count but is reported in the
+// Errors, letting the caller choose between strict and lenient counts.
+// Only the ctx being done fails the whole count.
+func (g *GrpcClient) DocCountDetailed(ctx context.Context) (
+	*DocCountDetails, error) {
+	return g.docCountDetailed(ctx, g.PIndexNames)
+}
+
+// docCountDetailed is like DocCountDetailed, but counts only the given
+// pindexes of the client.
+func (g *GrpcClient) docCountDetailed(ctx context.Context,
+	pindexNames []string) (*DocCountDetails, error) {
+	rv := &DocCountDetails{Errors: map[string]error{}}
+
+	// the pindexes are all counted in one round trip, where the servers
+	// of older versions leave all but the first to be counted one by one
+	if len(pindexNames) > 1 {
+		var err error
+		pindexNames, err = g.bulkDocCount(ctx, pindexNames, rv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pindexName := range pindexNames {
+		request := &pb.DocCountRequest{IndexName: pindexName,
+			IndexUUID: ""}
+		res, err := g.GrpcCli.DocCount(ctx, request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			rv.Errors[pindexName] = err
+			continue
+		}
+
+		setCachedDocCount(pindexName, g.IndexUUID, uint64(res.DocCount))
+
+		rv.Count += uint64(res.DocCount)
+	}
+
+	return rv, nil
+}
+
+// bulkDocCount counts the pindexes in a single DocCount rpc into the
+// rv, returning the pindexes that are left to be counted one by one.
+func (g *GrpcClient) bulkDocCount(ctx context.Context, pindexNames []string,
+	rv *DocCountDetails) ([]string, error) {
+	res, err := g.GrpcCli.DocCount(ctx, &pb.DocCountRequest{
+		IndexName:   pindexNames[0],
+		PIndexNames: pindexNames,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// only the first pindex failed, as far as an older server goes
+		rv.Errors[pindexNames[0]] = err
+		return pindexNames[1:], nil
+	}
+
+	// an older server counts just the first pindex
+	if len(res.GetPIndexDocCounts()) == 0 && len(res.GetErrors()) == 0 {
+		setCachedDocCount(pindexNames[0], g.IndexUUID, uint64(res.DocCount))
+		rv.Count += uint64(res.DocCount)
+		return pindexNames[1:], nil
+	}
+
+	atomic.AddUint64(&totGrpcBulkDocCounts, 1)
+
+	for _, pindexName := range pindexNames {
+		if errStr, exists := res.GetErrors()[pindexName]; exists {
+			rv.Errors[pindexName] = fmt.Errorf("grpc_client: DocCount,"+
+				" pindexName: %s, err: %s", pindexName, errStr)
+			continue
+		}
+
+		count, exists := res.GetPIndexDocCounts()[pindexName]
+		if !exists {
+			rv.Errors[pindexName] = fmt.Errorf("grpc_client: DocCount,"+
+				" pindexName: %s, not counted", pindexName)
+			continue
+		}
+
+		setCachedDocCount(pindexName, g.IndexUUID, uint64(count))
+
+		rv.Count += uint64(count)
+	}
+
+	return nil, nil
+}
+
+// DocCountInfo is a doc count along with whether it's the last known,
+// possibly stale, count and the age of that count.
+type DocCountInfo struct {
+	Count uint64
+	Stale bool
+	Age   time.Duration
+}
+
+// DocCountWithDeadline returns the doc count while honoring the
+// deadline of the given ctx.  When the ctx expires and allowStale is
+// true, the last known count is returned, marked as stale, instead of
+// an error; this is useful for dashboards that prefer slightly stale
+// counts over errors.
+func (g *GrpcClient) DocCountWithDeadline(ctx context.Context,
+	allowStale bool) (*DocCountInfo, error) {
+	count, err := g.docCount(ctx)
+	if err == nil {
+		return &DocCountInfo{Count: count}, nil
+	}
+
+	if !allowStale || ctx.Err() == nil {
+		return nil, err
+	}
+
+	// the last known count needs every pindex to have been counted,
+	// where the oldest count is the age of the sum
+	var rv DocCountInfo
+	for _, pindexName := range g.PIndexNames {
+		entry := getCachedDocCount(pindexName, g.IndexUUID)
+		if entry == nil {
+			return nil, err
+		}
+
+		rv.Count += entry.count
+		if age := time.Since(entry.at); age > rv.Age {
+			rv.Age = age
+		}
+	}
+	rv.Stale = true
+
+	log.Warnf("grpc_client: DocCountWithDeadline, using last known count, %s",
+		logFields("host", g.HostPort, "index", g.IndexName,
+			"pindexes", g.PIndexNames, "age", rv.Age, "err", err))
+
+	return &rv, nil
+}
+
+// FieldTypesResult is the result of a FieldsWithTypes request, where
+// Types holds the fields whose type agrees across all the reachable
+// pindexes, Conflicts holds the sorted, distinct types of the fields
+// whose type differs across pindexes, and Errors holds the errors of
+// the pindexes that couldn't be inspected, keyed by pindex name.
+type FieldTypesResult struct {
+	Types     map[string]string
+	Conflicts map[string][]string
+	Errors    map[string]error
+}
+
+// FieldsWithTypes returns the fields along with their types across
+// all the pindexes of the client, in a single round trip.  Failures
+// of individual pindexes are reported in the result's Errors, so
+// callers can still use the types from the remaining pindexes.
+func (g *GrpcClient) FieldsWithTypes() (*FieldTypesResult, error) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	res, err := g.GrpcCli.FieldsWithTypes(ctx, &pb.FieldsRequest{
+		IndexName:   g.IndexName,
+		IndexUUID:   g.IndexUUID,
+		PIndexNames: g.PIndexNames,
+	})
+	if err != nil {
+		log.Warnf("grpc_client: FieldsWithTypes, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"code", status.Code(err), "err", err))
+		return nil, err
+	}
+
+	rv := &FieldTypesResult{
+		Types:     res.GetFieldTypes(),
+		Conflicts: map[string][]string{},
+		Errors:    map[string]error{},
+	}
+	if rv.Types == nil {
+		rv.Types = map[string]string{}
+	}
+	for field, ft := range res.GetConflicts() {
+		rv.Conflicts[field] = ft.GetTypes()
+	}
+	for pindexName, errStr := range res.GetErrors() {
+		rv.Errors[pindexName] = fmt.Errorf("grpc_client: FieldsWithTypes,"+
+			" pindexName: %s, err: %s", pindexName, errStr)
+	}
+
+	return rv, nil
+}
+
+// docCountCacheEntry is the last known doc count of a remote pindex,
+// along with the UUID of the index that the pindex was counted for.
+type docCountCacheEntry struct {
+	count     uint64
+	indexUUID string
+	at        time.Time
+}
+
+var docCountCacheMutex sync.Mutex
+
+// docCountCache is keyed by pindex name.
+var docCountCache = map[string]*docCountCacheEntry{}
+
+func setCachedDocCount(pindexName, indexUUID string, count uint64) {
+	docCountCacheMutex.Lock()
+	docCountCache[pindexName] = &docCountCacheEntry{
+		count:     count,
+		indexUUID: indexUUID,
+		at:        time.Now(),
+	}
+	docCountCacheMutex.Unlock()
+}
+
+// getCachedDocCount returns the last known doc count of the pindex,
+// where the count of an index of a different UUID, as when the index
+// was recreated, is dropped.
+func getCachedDocCount(pindexName, indexUUID string) *docCountCacheEntry {
+	docCountCacheMutex.Lock()
+	defer docCountCacheMutex.Unlock()
+
+	entry := docCountCache[pindexName]
+	if entry != nil && entry.indexUUID != indexUUID {
+		delete(docCountCache, pindexName)
+		return nil
+	}
+	return entry
 }
 
 func (g *GrpcClient) Search(req *bleve.SearchRequest) (
@@ -123,113 +530,510 @@ func (g *GrpcClient) SearchInContext(ctx context.Context,
 		return nil, fmt.Errorf("grpc_client: SearchInContext, no req provided")
 	}
 
-	queryCtlParams := &cbgt.QueryCtlParams{
-		Ctl: cbgt.QueryCtl{
-			Consistency: g.Consistency,
-		},
+	ctx, requestID := ensureRequestID(ctx)
+
+	// hard-stop at the absolute deadline hint, where the ctx then has
+	// the earlier of its own deadline and the hint
+	if !g.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, g.Deadline)
+		defer cancel()
 	}
 
-	queryPIndexes := &QueryPIndexes{
-		PIndexNames: g.PIndexNames,
+	sr, err := g.newScatterRequest(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	queryCtlParams := sr.ctlParams
+
+	// when opted into, budget the query by the number of pindexes of
+	// the group, with the server told to abort at the group's deadline
+	if g.PerPIndexTimeout > 0 {
+		budget := g.PerPIndexTimeout * time.Duration(len(g.PIndexNames))
+		if budget < time.Millisecond {
+			budget = time.Millisecond
+		}
+		if queryCtlParams.Ctl.Timeout <= 0 ||
+			budget < time.Duration(queryCtlParams.Ctl.Timeout)*time.Millisecond {
+			queryCtlParams.Ctl.Timeout = int64(budget / time.Millisecond)
 
-	// if timeout was set, compute time remaining
-	if deadline, ok := ctx.Deadline(); ok {
-		remaining := deadline.Sub(time.Now())
-		// FIXME arbitrarily reducing the timeout, to increase the liklihood
-		// that a live system replies via HTTP round-trip before we give up
-		// on the request externally
-		remaining -= RemoteRequestOverhead
-		if remaining <= 0 {
-			// not enough time left
-			return nil, context.DeadlineExceeded
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, budget+g.requestOverhead())
+			defer cancel()
 		}
-		queryCtlParams.Ctl.Timeout = int64(remaining / time.Millisecond)
+		sr.deadline = time.Now().Add(
+			time.Duration(queryCtlParams.Ctl.Timeout) * time.Millisecond)
 	}
 
-	sr := &scatterRequest{
-		ctlParams:     queryCtlParams,
-		onlyPIndexes:  queryPIndexes,
-		searchRequest: req,
-	}
+	// tear down the stream of the worker below as soon as the results
+	// are no longer awaited, such as after the ctx.Done() path
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	resultCh := make(chan *bleve.SearchResult)
+	// buffered, so that the worker below can always send and move on,
+	// even after the ctx.Done() path has been taken
+	resultCh := make(chan *bleve.SearchResult, 1)
 
-	go func() {
+	err = scatterPool().run(ctx, func(ctx context.Context) {
 		rv, err := g.Query(ctx, sr)
 		if err != nil {
-			log.Warnf("grpc_client: Query() returned error from host: %v,"+
-				" err: %v", g.HostPort, err)
+			log.Warnf("grpc_client: Query() returned error, %s",
+				logFields("requestID", requestID, "host", g.HostPort,
+					"index", g.IndexName, "pindexes", len(g.PIndexNames),
+					"code", status.Code(err), "err", err))
 			resultCh <- makeSearchResultErr(req, g.PIndexNames, err)
 			return
 		}
 
 		resultCh <- rv
-	}()
+	})
+	if err != nil {
+		log.Warnf("grpc_client: scatter-gather worker unavailable, %s",
+			logFields("requestID", requestID, "host", g.HostPort,
+				"index", g.IndexName, "pindexes", len(g.PIndexNames),
+				"err", err))
+		return makeSearchResultErr(req, g.PIndexNames, err), nil
+	}
 
 	select {
 	case <-ctx.Done():
-		log.Warnf("grpc_client: scatter-gather error while awaiting results"+
-			" from host: %v, err: %v", g.HostPort, ctx.Err())
+		log.Warnf("grpc_client: scatter-gather error while awaiting results, %s",
+			logFields("requestID", requestID, "host", g.HostPort,
+				"index", g.IndexName, "pindexes", len(g.PIndexNames),
+				"err", ctx.Err()))
 		return makeSearchResultErr(req, g.PIndexNames, ctx.Err()), nil
 	case rv := <-resultCh:
 		return rv, nil
 	}
 }
 
+// retryBackoff returns the max backoff delay before the given retry,
+// where retry 1 is the second attempt, growing exponentially from the
+// base delay.  Any jitter applied must stay within this delay.
+func retryBackoff(base time.Duration, retry int) time.Duration {
+	if retry <= 0 {
+		return 0
+	}
+	return base << uint(retry-1)
+}
+
+// attemptTimeout returns the share of the remaining time budget that
+// the given attempt (0-based) out of the total attempts may use.  The
+// backoff delays of the later retries are reserved first and the rest
+// is split evenly across the attempts left, so that all the attempts
+// together fit within the caller's deadline.  When the backoffs don't
+// fit, fewer attempts are planned for, down to just this one.
+func attemptTimeout(remaining time.Duration, attempt, attempts int,
+	backoffBase time.Duration) time.Duration {
+	if remaining <= 0 {
+		return 0
+	}
+
+	for attemptsLeft := attempts - attempt; attemptsLeft > 1; attemptsLeft-- {
+		var reserve time.Duration
+		for retry := attempt + 1; retry < attempt+attemptsLeft; retry++ {
+			reserve += retryBackoff(backoffBase, retry)
+		}
+		if reserve < remaining {
+			return (remaining - reserve) / time.Duration(attemptsLeft)
+		}
+	}
+
+	return remaining
+}
+
+// requestOverhead returns the overhead by which the timeout of a
+// request is reduced, to increase the liklihood that a live system
+// replies via the round-trip before we give up on the request
+// externally.
+func (g *GrpcClient) requestOverhead() time.Duration {
+	if g.RequestOverhead > 0 {
+		return g.RequestOverhead
+	}
+	return RemoteRequestOverhead
+}
+
+// newScatterRequest returns the scatter-gather request of the search
+// request for the pindexes of the client, with the timeout of the ctx,
+// if any, reduced by the request overhead.
+func (g *GrpcClient) newScatterRequest(ctx context.Context,
+	req *bleve.SearchRequest) (*scatterRequest, error) {
+	queryCtlParams := &cbgt.QueryCtlParams{
+		Ctl: cbgt.QueryCtl{
+			Consistency: g.Consistency,
+		},
+	}
+
+	// if timeout was set, compute time remaining
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := deadline.Sub(time.Now()) - g.requestOverhead()
+		if remaining <= 0 {
+			// not enough time left
+			return nil, context.DeadlineExceeded
+		}
+		queryCtlParams.Ctl.Timeout = int64(remaining / time.Millisecond)
+	}
+
+	return &scatterRequest{
+		ctlParams: queryCtlParams,
+		onlyPIndexes: &QueryPIndexes{
+			PIndexNames: g.PIndexNames,
+		},
+		searchRequest: req,
+	}, nil
+}
+
 type scatterRequest struct {
 	ctlParams     *cbgt.QueryCtlParams
 	onlyPIndexes  *QueryPIndexes
 	searchRequest *bleve.SearchRequest
+
+	// deadline, when set, is sent to the server as the rpcDeadlineKey.
+	deadline time.Time
 }
 
+// Fields returns the sorted, distinct fields across all the pindexes
+// of the client, in a single round trip, where any failed pindex fails
+// the request, so that a partial list of fields is never returned.
 func (g *GrpcClient) Fields() ([]string, error) {
-	return nil, indexClientUnimplementedErr
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	res, err := g.GrpcCli.Fields(ctx, &pb.FieldsRequest{
+		IndexName:   g.IndexName,
+		IndexUUID:   g.IndexUUID,
+		PIndexNames: g.PIndexNames,
+	})
+	if err != nil {
+		// servers of older versions don't serve the fields
+		if status.Code(err) == codes.Unimplemented {
+			return nil, indexClientUnimplementedErr
+		}
+		log.Warnf("grpc_client: Fields, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"code", status.Code(err), "err", err))
+		return nil, err
+	}
+
+	for _, pindexName := range g.PIndexNames {
+		if errStr, exists := res.GetErrors()[pindexName]; exists {
+			return nil, fmt.Errorf("grpc_client: Fields,"+
+				" pindexName: %s, err: %s", pindexName, errStr)
+		}
+	}
+
+	return res.GetFields(), nil
 }
 
 func (g *GrpcClient) FieldDict(field string) (index.FieldDict, error) {
-	return nil, indexClientUnimplementedErr
+	return g.fieldDict(&pb.TermDictionaryRequest{
+		Field: field,
+		Kind:  termDictKindAll,
+	})
 }
 
 func (g *GrpcClient) FieldDictRange(field string,
 	startTerm []byte, endTerm []byte) (index.FieldDict, error) {
-	return nil, indexClientUnimplementedErr
+	return g.fieldDict(&pb.TermDictionaryRequest{
+		Field:     field,
+		Kind:      termDictKindRange,
+		StartTerm: startTerm,
+		EndTerm:   endTerm,
+	})
 }
 
 func (g *GrpcClient) FieldDictPrefix(field string,
 	termPrefix []byte) (index.FieldDict, error) {
-	return nil, indexClientUnimplementedErr
+	return g.fieldDict(&pb.TermDictionaryRequest{
+		Field:      field,
+		Kind:       termDictKindPrefix,
+		TermPrefix: termPrefix,
+	})
 }
 
-func (g *GrpcClient) DumpAll() chan interface{} {
+// fieldDict returns the dictionary of the terms of a field, merged
+// across the pindexes of the client, which is backed by a stream that
+// the caller must end by closing the dictionary.
+func (g *GrpcClient) fieldDict(req *pb.TermDictionaryRequest) (
+	index.FieldDict, error) {
+	req.IndexName = g.IndexName
+	req.IndexUUID = g.IndexUUID
+	req.PIndexNames = g.PIndexNames
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	stream, err := g.GrpcCli.TermDictionary(ctx, req)
+	rv := &grpcFieldDict{stream: stream, cancel: cancel}
+	if err == nil {
+		// the errors of the request only surface with the first batch
+		err = rv.recv()
+	}
+	if err != nil {
+		cancel()
+		// servers of older versions don't serve the term dictionaries
+		if status.Code(err) == codes.Unimplemented {
+			return nil, indexClientUnimplementedErr
+		}
+		log.Warnf("grpc_client: TermDictionary, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"field", req.Field, "kind", req.Kind,
+				"code", status.Code(err), "err", err))
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// grpcFieldDict is an index.FieldDict over a TermDictionary stream.
+type grpcFieldDict struct {
+	stream  pb.SearchService_TermDictionaryClient
+	cancel  context.CancelFunc
+	entries []*pb.TermDictionaryEntry // The rest of the current batch.
+	done    bool
+}
+
+func (d *grpcFieldDict) recv() error {
+	res, err := d.stream.Recv()
+	if err == io.EOF {
+		d.done = true
+		d.cancel()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d.entries = res.Entries
 	return nil
 }
 
-func (g *GrpcClient) DumpDoc(id string) chan interface{} {
+func (d *grpcFieldDict) Next() (*index.DictEntry, error) {
+	for len(d.entries) == 0 {
+		if d.done {
+			return nil, nil
+		}
+		if err := d.recv(); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := d.entries[0]
+	d.entries = d.entries[1:]
+
+	return &index.DictEntry{Term: entry.Term, Count: entry.Count}, nil
+}
+
+// Close cancels the stream, when not yet done.
+func (d *grpcFieldDict) Close() error {
+	d.cancel()
+	d.done = true
+	d.entries = nil
 	return nil
 }
 
+func (g *GrpcClient) DumpAll() chan interface{} {
+	return g.dump(dumpKindAll, "")
+}
+
+// DumpDoc returns the rows of the doc across the pindexes of the
+// client, where the returned channel is closed without any rows when
+// the doc isn't found.
+func (g *GrpcClient) DumpDoc(id string) chan interface{} {
+	return g.dump(dumpKindDoc, id)
+}
+
 func (g *GrpcClient) DumpFields() chan interface{} {
-	return nil
+	return g.dump(dumpKindFields, "")
 }
 
+// dump streams the rows dumped by the pindexes of the client, along
+// with any errors, just like the dump methods of a local bleve index,
+// onto the returned channel, which is closed once the dump is done.
+func (g *GrpcClient) dump(kind, docID string) chan interface{} {
+	rv := make(chan interface{})
+
+	go func() {
+		defer close(rv)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			rpcClusterActionKey, clusterActionScatterGather)
+
+		stream, err := g.GrpcCli.Dump(ctx, &pb.DumpRequest{
+			IndexName:   g.IndexName,
+			IndexUUID:   g.IndexUUID,
+			PIndexNames: g.PIndexNames,
+			Kind:        kind,
+			DocID:       docID,
+		})
+		for err == nil {
+			var res *pb.DumpResult
+			res, err = stream.Recv()
+			if err != nil {
+				break
+			}
+
+			if res.Error != "" {
+				rv <- fmt.Errorf("grpc_client: Dump, pindexName: %s, err: %s",
+					res.PIndexName, res.Error)
+				continue
+			}
+
+			rv <- &dumpRow{key: res.Key, value: res.Value}
+		}
+
+		if err == io.EOF {
+			return
+		}
+
+		// servers of older versions don't serve the dumps
+		if status.Code(err) == codes.Unimplemented {
+			rv <- indexClientUnimplementedErr
+			return
+		}
+
+		log.Warnf("grpc_client: Dump, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"kind", kind, "code", status.Code(err), "err", err))
+		rv <- err
+	}()
+
+	return rv
+}
+
+// dumpRow is a row dumped by a remote pindex, which has the methods of
+// the rows dumped by a local upsidedown index, so that the dump tooling
+// can handle both alike.
+type dumpRow struct {
+	key, value []byte
+}
+
+func (r *dumpRow) KeySize() int {
+	return len(r.key)
+}
+
+func (r *dumpRow) KeyTo(buf []byte) (int, error) {
+	return copy(buf, r.key), nil
+}
+
+func (r *dumpRow) Key() []byte {
+	return r.key
+}
+
+func (r *dumpRow) ValueSize() int {
+	return len(r.value)
+}
+
+func (r *dumpRow) ValueTo(buf []byte) (int, error) {
+	return copy(buf, r.value), nil
+}
+
+func (r *dumpRow) Value() []byte {
+	return r.value
+}
+
+// Close releases the client's references to the shared connections,
+// which are only closed once unreferenced by all the clients.
 func (g *GrpcClient) Close() error {
-	return indexClientUnimplementedErr
+	for _, ref := range g.connRefs {
+		ref.release()
+	}
+	return nil
+}
+
+// closeRemoteClients closes the gRPC clients among the remoteClients.
+func closeRemoteClients(remoteClients []RemoteClient) {
+	for _, remoteClient := range remoteClients {
+		if gc, ok := remoteClient.(*GrpcClient); ok {
+			gc.Close()
+		}
+	}
 }
 
+// Mapping returns the mapping of the remote index, which is fetched
+// once per index UUID and then cached, or nil if the mapping can't be
+// retrieved, as from servers of older versions.
 func (g *GrpcClient) Mapping() mapping.IndexMapping {
-	return nil
+	if im := getCachedMapping(g.IndexName, g.IndexUUID); im != nil {
+		return im
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	res, err := g.GrpcCli.GetMapping(ctx, &pb.MappingRequest{
+		IndexName:   g.IndexName,
+		IndexUUID:   g.IndexUUID,
+		PIndexNames: g.PIndexNames,
+	})
+	if err != nil {
+		log.Warnf("grpc_client: GetMapping, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"code", status.Code(err), "err", err))
+		return nil
+	}
+
+	im := mapping.NewIndexMapping()
+	err = UnmarshalJSON(res.GetMapping(), im)
+	if err != nil {
+		log.Warnf("grpc_client: GetMapping, %s",
+			logFields("host", g.HostPort, "index", g.IndexName, "err", err))
+		return nil
+	}
+
+	setCachedMapping(g.IndexName, g.IndexUUID, im)
+
+	return im
 }
 
-func (g *GrpcClient) NewBatch() *bleve.Batch {
-	return nil
+// mappingCacheEntry is the mapping of an index UUID.
+type mappingCacheEntry struct {
+	indexUUID string
+	mapping   mapping.IndexMapping
 }
 
-func (g *GrpcClient) Stats() *bleve.IndexStat {
+var mappingCacheMutex sync.Mutex
+
+// mappingCache is keyed by index name, holding the mapping of only the
+// latest index UUID, so that a recreated or updated index invalidates
+// the mapping of its previous UUID.
+var mappingCache = map[string]*mappingCacheEntry{}
+
+func setCachedMapping(indexName, indexUUID string, im mapping.IndexMapping) {
+	if indexUUID == "" {
+		return
+	}
+	mappingCacheMutex.Lock()
+	mappingCache[indexName] = &mappingCacheEntry{
+		indexUUID: indexUUID,
+		mapping:   im,
+	}
+	mappingCacheMutex.Unlock()
+}
+
+func getCachedMapping(indexName, indexUUID string) mapping.IndexMapping {
+	mappingCacheMutex.Lock()
+	defer mappingCacheMutex.Unlock()
+	entry := mappingCache[indexName]
+	if entry == nil || indexUUID == "" {
+		return nil
+	}
+	if entry.indexUUID != indexUUID {
+		delete(mappingCache, indexName)
+		return nil
+	}
+	return entry.mapping
+}
+
+func (g *GrpcClient) NewBatch() *bleve.Batch {
 	return nil
 }
 
-func (g *GrpcClient) StatsMap() map[string]interface{} {
+func (g *GrpcClient) Stats() *bleve.IndexStat {
 	return nil
 }
 
@@ -245,33 +1049,98 @@ func (g *GrpcClient) DeleteInternal(key []byte) error {
 	return indexClientUnimplementedErr
 }
 
-func (g *GrpcClient) Count() (uint64, error) {
-	return g.DocCount()
-}
+func (g *GrpcClient) SearchRPC(ctx context.Context, req *scatterRequest,
+	pbReq *pb.SearchRequest) (*bleve.SearchResult, error) {
+	searchResult, _, err := g.searchRPC(ctx, req, pbReq)
+	return searchResult, err
+}
+
+// searchRPC is like SearchRPC, but also returns whether any hits were
+// written to the stream handler, after which the search can't be
+// retried without duplicating those hits.
+func (g *GrpcClient) searchRPC(ctx context.Context, req *scatterRequest,
+	pbReq *pb.SearchRequest) (*bleve.SearchResult, bool, error) {
+	g.setLastServedBy(nil)
+
+	// queue for the max concurrent searches of the node, if limited
+	releaseSearchSlot, err := g.acquireSearchSlot(ctx)
+	if err != nil {
+		log.Warnf("grpc_client: search not admitted, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"host", g.HostPort, "index", g.IndexName, "err", err))
+		g.setLast(err)
+		return nil, false, err
+	}
+	defer releaseSearchSlot()
+
+	startTime := time.Now()
 
-func (g *GrpcClient) SearchRPC(ctx context.Context, req *scatterRequest,
-	pbReq *pb.SearchRequest) (*bleve.SearchResult, error) {
-	res, err := g.GrpcCli.Search(ctx, pbReq)
+	// close the stream as the search returns, such as when it's aborted
+	// while a write to the stream handler blocks
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res, err := g.openSearchStream(ctx, pbReq)
 	if err != nil || res == nil {
-		log.Errorf("grpc_client: search err: %v", err)
-		return nil, err
+		err = grpcMsgSizeErr(err)
+		log.Errorf("grpc_client: search err, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"host", g.HostPort, "index", g.IndexName,
+				"code", status.Code(err), "err", err))
+		g.setLast(err)
+		g.recordSearchLatency(ctx, time.Since(startTime), err)
+		return nil, false, err
+	}
+
+	g.setActiveStream(res)
+	defer g.setActiveStream(nil)
+
+	// a node coordinating a search whose client abandons pindexes
+	// forwards the abandoned pindexes of this client to its node
+	if a := pindexAbandonmentFromContext(ctx); a != nil {
+		defer a.trackClient(g)()
 	}
 
+	// the replica that served the search, for the per-node stats, which
+	// is taken from the header for the searches that fail mid-stream,
+	// as their trailer isn't in yet
+	var servedBy *ServedBy
+	var ended bool
+	defer func() {
+		if !ended {
+			servedBy = servedByFromHeader(res)
+		}
+		g.setLastServedBy(servedBy)
+		recordServedBy(servedBy, time.Since(startTime), err)
+	}()
+
+	var streamed bool
+
 	searchResult := &bleve.SearchResult{
 		Status: &bleve.SearchStatus{
 			Errors: make(map[string]error)},
 		Request: req.searchRequest,
 	}
 
+	// the latency of the node is as of its first response, as the rest
+	// of the stream is paced by the consumption of the hits
+	var firstResponse time.Duration
+
 	var response *pb.StreamSearchResults
 	for {
 		response, err = res.Recv()
+		if firstResponse == 0 {
+			firstResponse = time.Since(startTime)
+		}
 		if err == io.EOF {
 			err = nil
 			break
 		}
 		if err != nil {
-			log.Errorf("grpc_client: recv err: %v", err)
+			log.Errorf("grpc_client: recv err, %s",
+				logFields("requestID", requestIDFromContext(ctx),
+					"host", g.HostPort, "index", g.IndexName,
+					"code", status.Code(err), "err", err))
 			break
 		}
 
@@ -279,33 +1148,121 @@ func (g *GrpcClient) SearchRPC(ctx context.Context, req *scatterRequest,
 
 		case *pb.StreamSearchResults_Hits:
 			if sw, ok := g.sc.(streamHandler); ok {
-				err = sw.write(r.Hits.Bytes, r.Hits.Offsets, int(r.Hits.Total))
+				var b []byte
+				b, err = decodeContents(r.Hits.Bytes, response.ContentEncoding)
+				if err != nil {
+					g.setLast(err)
+					return searchResult, streamed, err
+				}
+				streamed = true
+				// a slow consumer pauses the loop, until the ctx is done
+				err = writeStream(ctx, sw, b, r.Hits.Offsets,
+					int(r.Hits.Total))
 				if err != nil {
-					break
+					g.setLast(err)
+					return searchResult, streamed, err
 				}
 			}
 
+			// pace the server by the consumption of the hits
+			err = grantSearchCredit(res)
+			if err != nil {
+				g.setLast(err)
+				return searchResult, streamed, err
+			}
+
 		case *pb.StreamSearchResults_SearchResult:
 			if r.SearchResult != nil {
-				err = UnmarshalJSON(r.SearchResult, &searchResult)
+				var b []byte
+				b, err = decodeContents(r.SearchResult, response.ContentEncoding)
+				if err != nil {
+					g.setLast(err)
+					return searchResult, streamed, err
+				}
+				err = UnmarshalJSON(b, &searchResult)
+				if err != nil {
+					g.setLast(err)
+					return searchResult, streamed, err
+				}
+			}
+			mergePIndexErrors(searchResult, response.PIndexErrors)
+
+		case *pb.StreamSearchResults_Facets:
+			atomic.AddUint64(&totGrpcFacetSnapshots, 1)
+			if fs := facetSnapshotsFromContext(ctx); fs != nil {
+				var b []byte
+				b, err = decodeContents(r.Facets, response.ContentEncoding)
 				if err != nil {
-					return searchResult, err
+					g.setLast(err)
+					return searchResult, streamed, err
+				}
+				// the interim facets are best effort, as the
+				// SearchResult carries the facets anyway
+				if er := fs.add(b, req.searchRequest.Facets); er != nil {
+					log.Warnf("grpc_client: interim facets, %s",
+						logFields("requestID", requestIDFromContext(ctx),
+							"host", g.HostPort, "index", g.IndexName,
+							"err", er))
 				}
 			}
 		}
 	}
 
-	return searchResult, err
+	err = grpcMsgSizeErr(err)
+	g.setLast(err)
+
+	ended = true
+	trailer := res.Trailer()
+	updateConsistencyWaitStats(trailer)
+
+	// the reason of a rejection, which the status only has as text
+	if err != nil {
+		if qre := queryRejectedFromTrailer(trailer); qre != nil {
+			err = qre
+		}
+	}
+
+	servedBy = servedByFromMetadata(trailer)
+	g.recordSearchLatency(ctx, firstResponse, err)
+
+	var consistency map[string]*PIndexConsistency
+	if hasConsistencyVectors(req.ctlParams) {
+		var er error
+		consistency, er = consistencyFromTrailer(trailer)
+		if er != nil {
+			log.Warnf("grpc_client: consistency vectors, %s",
+				logFields("requestID", requestIDFromContext(ctx),
+					"host", g.HostPort, "index", g.IndexName, "err", er))
+		}
+	}
+	g.setLastConsistency(consistency)
+
+	if c := pindexHitCountsFromContext(ctx); c != nil {
+		if er := c.addFromTrailer(trailer); er != nil {
+			log.Warnf("grpc_client: pindex hit counts, %s",
+				logFields("requestID", requestIDFromContext(ctx),
+					"host", g.HostPort, "index", g.IndexName, "err", er))
+		}
+	}
+
+	return searchResult, streamed, err
 }
 
-func (g *GrpcClient) Query(ctx context.Context,
-	req *scatterRequest) (*bleve.SearchResult, error) {
+// pbSearchRequest returns the protobuf of the scatter-gather request.
+func (g *GrpcClient) pbSearchRequest(ctx context.Context,
+	req *scatterRequest) (*pb.SearchRequest, error) {
 	scatterGatherReq := &pb.SearchRequest{
 		IndexName: g.IndexName,
 		IndexUUID: g.IndexUUID,
 	}
 
-	b, err := MarshalJSON(req.searchRequest)
+	// apply the cross-cutting query policy, if any
+	searchRequest, err := g.rewriteSearchRequest(ctx, req.searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := MarshalJSON(searchRequest)
 	if err != nil {
 		return nil, err
 	}
@@ -330,27 +1287,240 @@ func (g *GrpcClient) Query(ctx context.Context,
 		}
 	}
 
+	// ask for the interim facets, if opted into
+	if facetSnapshotsFromContext(ctx) != nil &&
+		len(searchRequest.Facets) > 0 &&
+		grpcPeerSupports(g.HostPort, grpcCapStreamFacets) {
+		scatterGatherReq.StreamFacets = true
+	}
+
+	return scatterGatherReq, nil
+}
+
+// outgoingSearchContext returns the ctx of a search rpc to the node of
+// the hostPort, along with the metadata of the query, where a non-zero
+// deadline is the one that the server is told to abort at, and secure
+// is whether the connection to the node is over TLS.
+func outgoingSearchContext(ctx context.Context, hostPort string,
+	secure bool, deadline time.Time) (context.Context, error) {
 	// mark that its a scatter gather query
 	nctx := metadata.AppendToOutgoingContext(ctx,
-		rpcClusterActionKey, clusterActionScatterGather)
+		rpcClusterActionKey, clusterActionFromContext(ctx))
+
+	// ask the server for the per-pindex hit counts, if opted into
+	if pindexHitCountsFromContext(ctx) != nil {
+		nctx = metadata.AppendToOutgoingContext(nctx,
+			rpcPIndexHitCountsKey, "true")
+	}
+
+	if !deadline.IsZero() {
+		nctx = metadata.AppendToOutgoingContext(nctx,
+			rpcDeadlineKey, strconv.FormatInt(deadline.UnixNano(), 10))
+	}
+
+	// the server compresses the larger responses, when enabled
+	if grpcPeerSupports(hostPort, grpcCapCompression) {
+		nctx = metadata.AppendToOutgoingContext(nctx,
+			rpcAcceptContentEncodingKey, contentEncodingGzip)
+	}
+
+	nctx, err := appendQueryLabels(nctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nctx = appendQueryPriority(nctx)
+
+	// the request ID correlates the log lines of the client and servers
+	nctx = appendRequestID(nctx)
+
+	// the user's auth, if any, goes along with the cluster-internal auth
+	return appendQueryAuth(nctx, secure), nil
+}
 
-	result, er := g.SearchRPC(nctx, req, scatterGatherReq)
-	if st, ok := status.FromError(er); ok {
-		g.lastSearchStatus = httpStatusCodes(st.Code())
-		if g.lastSearchStatus == http.StatusOK {
-			return result, nil
+func (g *GrpcClient) Query(ctx context.Context,
+	req *scatterRequest) (*bleve.SearchResult, error) {
+	// spread the dispatch of a wide fan-out, when opted into
+	if err := g.staggerDispatch(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// back off while the node is under memory pressure
+	if err := throttleOnOverQuota(ctx, g.Mgr); err != nil {
+		return nil, err
+	}
+
+	ctx, _ = ensureRequestID(ctx)
+
+	scatterGatherReq, err := g.pbSearchRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	nctx, err := outgoingSearchContext(ctx, g.HostPort, g.Secure,
+		req.deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// ask the server for the seqs reached by its pindexes, if any wait
+	if hasConsistencyVectors(req.ctlParams) {
+		nctx = metadata.AppendToOutgoingContext(nctx,
+			rpcConsistencyVectorsKey, "true")
+	}
+
+	// the queries in flight drive the least outstanding replica selection
+	if len(g.connRefs) > 0 {
+		atomic.AddInt64(&g.connRefs[0].pool.outstanding, 1)
+		defer atomic.AddInt64(&g.connRefs[0].pool.outstanding, -1)
+	}
+
+	result, er := g.searchWithRetries(nctx, req, scatterGatherReq)
+	if er == nil {
+		return result, nil
+	}
+
+	lastSearchStatus, _ := g.GetLast()
+
+	// explain the pindexes the server couldn't report on
+	if c := pindexHitCountsFromContext(ctx); c != nil {
+		reason := pindexStatusForHttpStatus(lastSearchStatus)
+		for _, pindexName := range g.PIndexNames {
+			c.setReason(pindexName, reason)
+		}
+	}
+
+	// a rejection keeps its reason, for the callers to act on
+	if qre, ok := er.(*QueryRejectedError); ok {
+		return nil, qre
+	}
+
+	return nil, fmt.Errorf("grpc_client: query got status code: %d,"+
+		" resp: %#v, err: %v", lastSearchStatus, result, er)
+}
+
+// searchWithRetries runs the search, retrying it with an exponential
+// backoff and jitter on transient failures, as long as no hits were
+// streamed yet and the ctx isn't done.
+func (g *GrpcClient) searchWithRetries(ctx context.Context,
+	req *scatterRequest, pbReq *pb.SearchRequest) (
+	*bleve.SearchResult, error) {
+	retries, backoffBase := grpcSearchRetryParams(g.Mgr)
+
+	for retry := 0; ; retry++ {
+		// each attempt gets its share of the caller's deadline, so that
+		// a hung attempt still leaves time for the retries
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		attemptReq := pbReq
+		if deadline, ok := ctx.Deadline(); ok && retries > 0 {
+			timeout := attemptTimeout(time.Until(deadline), retry,
+				retries+1, backoffBase)
+			r, err := g.attemptSearchRequest(req, pbReq, timeout)
+			if err != nil {
+				return nil, err
+			}
+			actx, cancel = context.WithTimeout(ctx, timeout)
+			attemptReq = r
+		}
+
+		result, streamed, err := g.searchRPC(actx, req, attemptReq)
+		attemptTimedOut := actx.Err() == context.DeadlineExceeded &&
+			ctx.Err() == nil
+		cancel()
+
+		if retry > 0 && err == nil {
+			atomic.AddUint64(&totGrpcSearchRetriesSucceeded, 1)
+		}
+		// the fast errors of an open breaker aren't retried
+		if err == nil || retry >= retries || streamed ||
+			err == errGrpcBreakerOpen ||
+			(status.Code(err) != codes.Unavailable && !attemptTimedOut) {
+			return result, err
+		}
+
+		backoff := retryBackoff(backoffBase, retry+1)
+		if backoff > 0 {
+			backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
+		if deadline, ok := ctx.Deadline(); ok &&
+			time.Now().Add(backoff).After(deadline) {
+			return result, err
 		}
-		g.lastErrBody, _ = MarshalJSON(err)
-		return nil, fmt.Errorf("grpc_client: query got status code: %d,"+
-			" resp: %#v, err: %v",
-			g.lastSearchStatus, result, er)
+
+		log.Warnf("grpc_client: retrying search, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"host", g.HostPort, "index", g.IndexName,
+				"retry", retry+1, "backoff", backoff, "err", err))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+
+		atomic.AddUint64(&totGrpcSearchRetries, 1)
+	}
+}
+
+// attemptSearchRequest returns a copy of the search request whose
+// query control params carry the timeout of the attempt, reduced by the
+// request overhead while that leaves any time.
+func (g *GrpcClient) attemptSearchRequest(req *scatterRequest,
+	pbReq *pb.SearchRequest, timeout time.Duration) (
+	*pb.SearchRequest, error) {
+	var ctlParams cbgt.QueryCtlParams
+	if req.ctlParams != nil {
+		ctlParams = *req.ctlParams
+	}
+
+	if t := timeout - g.requestOverhead(); t > 0 {
+		timeout = t
 	}
+	ctlParams.Ctl.Timeout = int64(timeout / time.Millisecond)
 
-	return result, fmt.Errorf("grpc_client: invalid status code, err: %v", er)
+	b, err := MarshalJSON(&ctlParams)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := *pbReq
+	rv.QueryCtlParams = b
+	return &rv, nil
 }
 
-func (g *GrpcClient) Advanced() (index.Index, store.KVStore, error) {
-	return nil, nil, indexClientUnimplementedErr
+// grpcSearchRetryParams returns the max number of retries of a search
+// and the base delay of their backoff, from the "grpcSearchRetries" and
+// "grpcSearchRetryBackoff" manager options.
+func grpcSearchRetryParams(mgr *cbgt.Manager) (int, time.Duration) {
+	retries, backoffBase := DefaultGrpcSearchRetries,
+		DefaultGrpcSearchRetryBackoff
+	if mgr == nil {
+		return retries, backoffBase
+	}
+
+	if v := mgr.Options()["grpcSearchRetries"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Warnf("grpc_client: invalid grpcSearchRetries: %q, err: %v",
+				v, err)
+		} else {
+			retries = n
+		}
+	}
+
+	if v := mgr.Options()["grpcSearchRetryBackoff"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Warnf("grpc_client: invalid grpcSearchRetryBackoff: %q,"+
+				" err: %v", v, err)
+		} else {
+			backoffBase = d
+		}
+	}
+
+	return retries, backoffBase
 }
 
 // -----------------------------------------------------
@@ -368,19 +1538,268 @@ func (g *GrpcClient) HandleTask(in []byte) (*cbgt.TaskRequestStatus, error) {
 	return nil, nil
 }
 
+// updateConsistencyWaitStats accounts for the consistency wait
+// outcome, if any, reported by the server in the trailer metadata.
+func updateConsistencyWaitStats(md metadata.MD) {
+	outcome := md.Get(rpcConsistencyWaitKey)
+	if len(outcome) == 0 {
+		return
+	}
+
+	switch outcome[0] {
+	case consistencyWaitSucceeded:
+		atomic.AddUint64(&totGrpcConsistencyWaitSucceeded, 1)
+	case consistencyWaitTimedout:
+		atomic.AddUint64(&totGrpcConsistencyWaitTimedout, 1)
+	default:
+		return
+	}
+
+	if d := md.Get(rpcConsistencyWaitDurationKey); len(d) > 0 {
+		if v, err := strconv.ParseInt(d[0], 10, 64); err == nil {
+			grpcConsistencyWaitHistogram.Update(v)
+		}
+	}
+}
+
 func clientInterceptor(ctx context.Context, method string,
 	req interface{}, reply interface{},
 	cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
 	opts ...grpc.CallOption) error {
 	start := time.Now()
 	err := invoker(ctx, method, req, reply, cc, opts...)
-	log.Printf("grpc_client: invoke rpc method: %s duration: %f sec"+
-		" err: %v", method, time.Since(start).Seconds(), err)
+	latency := time.Since(start)
+	recordGrpcCall(method, latency, err)
+	if GrpcClientLogVerbose || err != nil {
+		log.Printf("grpc_client: invoke rpc, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"method", method, "target", cc.Target(),
+				"latency", latency, "code", status.Code(err),
+				"err", err))
+	} else if grpcClientSlowCall(latency) {
+		log.Printf("grpc_client: slow rpc, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"method", method, "target", cc.Target(),
+				"latency", latency))
+	}
 	return err
 }
 
-func addClientInterceptor() grpc.DialOption {
-	return grpc.WithUnaryInterceptor(clientInterceptor)
+// clientStreamInterceptor observes the streaming rpc's, like Search,
+// by timing the stream setup and by counting the messages and bytes
+// received until the stream ends.
+func clientStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc,
+	cc *grpc.ClientConn, method string, streamer grpc.Streamer,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	setupDur := time.Since(start)
+
+	atomic.AddUint64(&totGrpcClientStreams, 1)
+	atomic.AddUint64(&totGrpcClientStreamSetupTimeNS, uint64(setupDur))
+	if err != nil {
+		recordGrpcCall(method, setupDur, err)
+		atomic.AddUint64(&totGrpcClientStreamErrs, 1)
+		log.Printf("grpc_client: new stream rpc, %s",
+			logFields("requestID", requestIDFromContext(ctx),
+				"method", method, "target", cc.Target(),
+				"latency", setupDur, "code", status.Code(err), "err", err))
+		return nil, err
+	}
+
+	return &observedClientStream{
+		ClientStream: cs,
+		requestID:    requestIDFromContext(ctx),
+		method:       method,
+		start:        start,
+		setupDur:     setupDur,
+	}, nil
+}
+
+// observedClientStream wraps a grpc.ClientStream to account for the
+// messages received over it.
+type observedClientStream struct {
+	grpc.ClientStream
+
+	requestID string
+	method    string
+	start     time.Time
+	setupDur  time.Duration
+	numMsgs   uint64
+	numBytes  uint64
+	done      uint32
+}
+
+func (s *observedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+
+	s.numMsgs++
+	if pm, ok := m.(proto.Message); ok {
+		s.numBytes += uint64(proto.Size(pm))
+	}
+
+	return nil
+}
+
+func (s *observedClientStream) finish(err error) {
+	if !atomic.CompareAndSwapUint32(&s.done, 0, 1) {
+		return
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	atomic.AddUint64(&totGrpcClientStreamMsgsRecv, s.numMsgs)
+	atomic.AddUint64(&totGrpcClientStreamBytesRecv, s.numBytes)
+	if err != nil {
+		atomic.AddUint64(&totGrpcClientStreamErrs, 1)
+	}
+
+	// a stream's latency spans until its end
+	latency := time.Since(s.start)
+	recordGrpcCall(s.method, latency, err)
+
+	if GrpcClientLogVerbose || err != nil {
+		log.Printf("grpc_client: stream rpc, %s",
+			logFields("requestID", s.requestID,
+				"method", s.method, "setup", s.setupDur,
+				"latency", latency, "msgs", s.numMsgs,
+				"bytes", s.numBytes, "code", status.Code(err), "err", err))
+	} else if grpcClientSlowCall(latency) {
+		log.Printf("grpc_client: slow stream rpc, %s",
+			logFields("requestID", s.requestID,
+				"method", s.method, "setup", s.setupDur,
+				"latency", latency, "msgs", s.numMsgs,
+				"bytes", s.numBytes))
+	}
+}
+
+var clientInterceptorsMutex sync.Mutex
+
+// unaryClientInterceptors and streamClientInterceptors are the ordered
+// lists of interceptors that are chained into every gRPC client
+// connection, with the logging clientInterceptor as a default entry.
+var unaryClientInterceptors = []grpc.UnaryClientInterceptor{clientInterceptor}
+var streamClientInterceptors = []grpc.StreamClientInterceptor{
+	clientStreamInterceptor}
+
+// RegisterUnaryClientInterceptor appends an interceptor to the chain
+// of unary client interceptors.  Interceptors are invoked in their
+// order of registration, so the first registered interceptor is the
+// outermost one and sees the call first and the response last.
+// Registration only applies to the client connections that are dialed
+// afterwards, so it should be done during process initialization.
+func RegisterUnaryClientInterceptor(i grpc.UnaryClientInterceptor) {
+	clientInterceptorsMutex.Lock()
+	unaryClientInterceptors = append(unaryClientInterceptors, i)
+	clientInterceptorsMutex.Unlock()
+}
+
+// RegisterStreamClientInterceptor appends an interceptor to the chain
+// of stream client interceptors, with the same ordering semantics
+// as RegisterUnaryClientInterceptor.
+func RegisterStreamClientInterceptor(i grpc.StreamClientInterceptor) {
+	clientInterceptorsMutex.Lock()
+	streamClientInterceptors = append(streamClientInterceptors, i)
+	clientInterceptorsMutex.Unlock()
+}
+
+// addClientInterceptors returns the dial options that install the
+// currently registered client interceptor chains.
+func addClientInterceptors() []grpc.DialOption {
+	clientInterceptorsMutex.Lock()
+	unary := append([]grpc.UnaryClientInterceptor(nil),
+		unaryClientInterceptors...)
+	stream := append([]grpc.StreamClientInterceptor(nil),
+		streamClientInterceptors...)
+	// the breaker fails the calls fast ahead of the registered
+	// interceptors, but within the tracing, so that those are traced
+	if GrpcBreakerFailures > 0 {
+		unary = append([]grpc.UnaryClientInterceptor{
+			breakerUnaryClientInterceptor}, unary...)
+		stream = append([]grpc.StreamClientInterceptor{
+			breakerStreamClientInterceptor}, stream...)
+	}
+	// the tracing is outermost, so that the spans cover the whole call
+	if GrpcTracing {
+		unary = append([]grpc.UnaryClientInterceptor{
+			tracingUnaryClientInterceptor(grpcTracer)}, unary...)
+		stream = append([]grpc.StreamClientInterceptor{
+			tracingStreamClientInterceptor(grpcTracer)}, stream...)
+	}
+	clientInterceptorsMutex.Unlock()
+
+	// the protocol version is negotiated over every call, innermost,
+	// so that the calls failed fast by the breaker don't count
+	unary = append(unary, protocolVersionUnaryClientInterceptor)
+	stream = append(stream, protocolVersionStreamClientInterceptor)
+
+	var rv []grpc.DialOption
+	if len(unary) > 0 {
+		rv = append(rv,
+			grpc.WithUnaryInterceptor(chainUnaryClientInterceptors(unary)))
+	}
+	if len(stream) > 0 {
+		rv = append(rv,
+			grpc.WithStreamInterceptor(chainStreamClientInterceptors(stream)))
+	}
+	return rv
+}
+
+// chainUnaryClientInterceptors folds the interceptors into a single
+// one, as the grpc version in use allows only one per connection.
+func chainUnaryClientInterceptors(
+	interceptors []grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string,
+		req interface{}, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+		return interceptors[0](ctx, method, req, reply, cc,
+			chainedUnaryInvoker(interceptors, 1, invoker), opts...)
+	}
+}
+
+func chainedUnaryInvoker(interceptors []grpc.UnaryClientInterceptor,
+	curr int, invoker grpc.UnaryInvoker) grpc.UnaryInvoker {
+	if curr >= len(interceptors) {
+		return invoker
+	}
+	return func(ctx context.Context, method string,
+		req interface{}, reply interface{},
+		cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return interceptors[curr](ctx, method, req, reply, cc,
+			chainedUnaryInvoker(interceptors, curr+1, invoker), opts...)
+	}
+}
+
+// chainStreamClientInterceptors folds the interceptors into a single
+// one, as the grpc version in use allows only one per connection.
+func chainStreamClientInterceptors(
+	interceptors []grpc.StreamClientInterceptor) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc,
+		cc *grpc.ClientConn, method string, streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return interceptors[0](ctx, desc, cc, method,
+			chainedStreamer(interceptors, 1, streamer), opts...)
+	}
+}
+
+func chainedStreamer(interceptors []grpc.StreamClientInterceptor,
+	curr int, streamer grpc.Streamer) grpc.Streamer {
+	if curr >= len(interceptors) {
+		return streamer
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc,
+		cc *grpc.ClientConn, method string,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return interceptors[curr](ctx, desc, cc, method,
+			chainedStreamer(interceptors, curr+1, streamer), opts...)
+	}
 }
 
 func addGrpcClients(mgr *cbgt.Manager, indexName, indexUUID string,
@@ -390,76 +1809,227 @@ func addGrpcClients(mgr *cbgt.Manager, indexName, indexUUID string,
 	remoteClients := make([]*GrpcClient, 0, len(remotePlanPIndexes))
 	rv := make([]RemoteClient, 0, len(remotePlanPIndexes))
 
+	requestOverhead := grpcRequestOverhead(mgr)
+	perPIndexTimeout := grpcPerPIndexTimeout(mgr)
+
+	// spread the load across the replicas, when opted into
+	if selector := grpcReplicaSelector(mgr); selector != nil {
+		nodeDefs, err := mgr.GetNodeDefs(cbgt.NODE_DEFS_WANTED, false)
+		if err != nil {
+			log.Warnf("grpc_client: replica selection, GetNodeDefs, err: %v", err)
+		} else {
+			remotePlanPIndexes = selectReplicas(selector, nodeDefs, mgr.UUID(),
+				remotePlanPIndexes)
+		}
+	}
+
+	// the remote pindexes of the nodes without a gRPC port, which are
+	// reached over http instead, so as not to drop them from the results
+	var httpPlanPIndexes []*cbgt.RemotePlanPIndex
+
+	fanout := grpcFanout{pindexes: len(remotePlanPIndexes)}
+
 	for _, remotePlanPIndex := range remotePlanPIndexes {
 		if onlyPIndexes != nil &&
 			!onlyPIndexes[remotePlanPIndex.PlanPIndex.Name] {
+			fanout.filtered++
 			continue
 		}
 
-		delimiterPos := strings.LastIndex(remotePlanPIndex.NodeDef.HostPort, ":")
-		if delimiterPos < 0 ||
-			delimiterPos >= len(remotePlanPIndex.NodeDef.HostPort)-1 {
-			// No port available
-			log.Warnf("grpc_client: grpcClient with no possible port into: %v",
-				remotePlanPIndex.NodeDef.HostPort)
+		// a plan listing the local node as a remote target would have
+		// the scatter gRPC to itself, so skip such pindexes
+		if mgr != nil && remotePlanPIndex.NodeDef.UUID == mgr.UUID() {
+			atomic.AddUint64(&totGrpcClientSelfLoopSkipped, 1)
+			log.Warnf("grpc_client: skipping remote pindex on the local node, %s",
+				logFields("host", remotePlanPIndex.NodeDef.HostPort,
+					"index", indexName,
+					"pindex", remotePlanPIndex.PlanPIndex.Name))
 			continue
 		}
-		host := remotePlanPIndex.NodeDef.HostPort[:delimiterPos]
 
-		var port string
-		bindPort, err := getPortFromNodeDefs(remotePlanPIndex.NodeDef, "bindGRPC")
-		if err == nil {
-			port = bindPort
+		host, certInBytes, err := grpcHostPort(remotePlanPIndex.NodeDef)
+		if err == errGrpcNoPossiblePort {
+			log.Warnf("grpc_client: grpcClient with no possible port, %s",
+				logFields("host", remotePlanPIndex.NodeDef.HostPort,
+					"index", indexName,
+					"pindex", remotePlanPIndex.PlanPIndex.Name))
+			fanout.skipNode(&fanout.noPort, remotePlanPIndex.NodeDef)
+			continue
 		}
-
-		ss := cbgt.GetSecuritySetting()
-		var certInBytes []byte
-		if ss.EncryptionEnabled {
-			bindPort, err = getPortFromNodeDefs(remotePlanPIndex.NodeDef, "bindGRPCSSL")
-			if err == nil {
-				port = bindPort
-				certInBytes = ss.CertInBytes
-			}
+		if err == errGrpcNoGrpcPort {
+			log.Warnf("grpc_client: no grpc port, falling back to http, %s",
+				logFields("host", remotePlanPIndex.NodeDef.HostPort,
+					"index", indexName,
+					"pindex", remotePlanPIndex.PlanPIndex.Name))
+			httpPlanPIndexes = append(httpPlanPIndexes, remotePlanPIndex)
+			fanout.httpFallback++
+			continue
 		}
-
-		if port == "" {
-			return nil, fmt.Errorf("grpc_client: no ports found for host: %s", host)
+		if err != nil {
+			for _, remoteClient := range remoteClients {
+				remoteClient.Close()
+			}
+			return nil, err
 		}
 
-		host = host + ":" + port
-
-		cli, err := getRpcClient(remotePlanPIndex.NodeDef.UUID, host, certInBytes)
+		cli, connRef, err := getRpcClient(remotePlanPIndex.NodeDef.UUID,
+			host, grpcTLSServerName(mgr, remotePlanPIndex.NodeDef),
+			certInBytes)
 		if err != nil {
-			log.Errorf("grpc_client: getRpcClient err: %v", err)
+			log.Errorf("grpc_client: getRpcClient err, %s",
+				logFields("host", host, "index", indexName,
+					"pindex", remotePlanPIndex.PlanPIndex.Name, "err", err))
+			fanout.skipNode(&fanout.errored, remotePlanPIndex.NodeDef)
 			continue
 		}
 
+		fanout.addClient(host, remotePlanPIndex.NodeDef)
+
 		grpcClient := &GrpcClient{
-			Mgr:         mgr,
-			name:        fmt.Sprintf("grpcClient - %s", host),
-			HostPort:    host,
-			IndexName:   indexName,
-			IndexUUID:   indexUUID,
-			PIndexNames: []string{remotePlanPIndex.PlanPIndex.Name},
-			Consistency: consistencyParams,
-			GrpcCli:     cli,
+			Mgr:              mgr,
+			name:             fmt.Sprintf("grpcClient - %s", host),
+			HostPort:         host,
+			IndexName:        indexName,
+			IndexUUID:        indexUUID,
+			PIndexNames:      []string{remotePlanPIndex.PlanPIndex.Name},
+			Consistency:      consistencyParams,
+			GrpcCli:          cli,
+			RequestOverhead:  requestOverhead,
+			PerPIndexTimeout: perPIndexTimeout,
+			Secure:           len(certInBytes) != 0,
+			connRefs:         []*rpcConnRef{connRef},
 		}
 
 		remoteClients = append(remoteClients, grpcClient)
 	}
 
-	if groupByNode {
-		remoteClients = GroupGrpcClientsByHostPort(remoteClients)
+	if groupByNode && !scatterGatherGroupingDisabled(mgr) {
+		remoteClients = GroupGrpcClientsByHostPortWithLimit(remoteClients,
+			grpcClientMaxPIndexes(mgr))
 	}
 
+	// prune the nodes that don't answer a ping, when opted into
+	if grpcPreflightPing(mgr) {
+		reachable := pruneUnreachableGrpcClients(remoteClients, collector)
+		fanout.skipUnreachable(remoteClients, reachable)
+		remoteClients = reachable
+	}
+
+	fanout.record(remoteClients)
+
+	staggerGrpcClients(remoteClients, grpcDispatchMaxJitter(mgr))
+
 	for _, remoteClient := range remoteClients {
 		collector.Add(remoteClient)
 		rv = append(rv, remoteClient)
 	}
 
+	if len(httpPlanPIndexes) > 0 {
+		httpClients, err := addIndexClients(mgr, indexName, indexUUID,
+			httpPlanPIndexes, consistencyParams, nil, collector, groupByNode)
+		if err != nil {
+			closeRemoteClients(rv)
+			return nil, err
+		}
+		for _, httpClient := range httpClients {
+			if ic, ok := httpClient.(*IndexClient); ok {
+				ic.grpcFallback = true
+			}
+		}
+		rv = append(rv, httpClients...)
+	}
+
 	return rv, nil
 }
 
+// grpcRequestOverhead returns the "grpcRequestOverhead" manager option,
+// parsed as a duration, or else the RemoteRequestOverhead, which is
+// also used for an overhead of 0.
+func grpcRequestOverhead(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return RemoteRequestOverhead
+	}
+
+	v := mgr.Options()["grpcRequestOverhead"]
+	if v == "" {
+		return RemoteRequestOverhead
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Warnf("grpc_client: invalid grpcRequestOverhead: %q,"+
+			" using the default: %v, err: %v", v, RemoteRequestOverhead, err)
+		return RemoteRequestOverhead
+	}
+	if d == 0 {
+		return RemoteRequestOverhead
+	}
+
+	return d
+}
+
+// grpcPerPIndexTimeout returns the "grpcPerPIndexTimeout" manager
+// option, parsed as a duration, where 0 leaves the timeouts of the
+// queries independent of their number of pindexes.
+func grpcPerPIndexTimeout(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return 0
+	}
+
+	v := mgr.Options()["grpcPerPIndexTimeout"]
+	if v == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Warnf("grpc_client: invalid grpcPerPIndexTimeout: %q, err: %v",
+			v, err)
+		return 0
+	}
+
+	return d
+}
+
+var errGrpcNoPossiblePort = errors.New("grpc_client: no possible port")
+
+// errGrpcNoGrpcPort is returned for the nodes that don't advertise a
+// gRPC port, like the nodes of older versions during rolling upgrades.
+var errGrpcNoGrpcPort = errors.New("grpc_client: no grpc port")
+
+// grpcHostPort returns the gRPC hostPort of a node, which is the TLS
+// one along with the cert when encryption is enabled.
+func grpcHostPort(nodeDef *cbgt.NodeDef) (string, []byte, error) {
+	delimiterPos := strings.LastIndex(nodeDef.HostPort, ":")
+	if delimiterPos < 0 || delimiterPos >= len(nodeDef.HostPort)-1 {
+		// No port available
+		return "", nil, errGrpcNoPossiblePort
+	}
+	host := nodeDef.HostPort[:delimiterPos]
+
+	var port string
+	bindPort, err := getPortFromNodeDefs(nodeDef, "bindGRPC")
+	if err == nil {
+		port = bindPort
+	}
+
+	ss := cbgt.GetSecuritySetting()
+	var certInBytes []byte
+	if ss.EncryptionEnabled {
+		bindPort, err = getPortFromNodeDefs(nodeDef, "bindGRPCSSL")
+		if err == nil {
+			port = bindPort
+			certInBytes = ss.CertInBytes
+		}
+	}
+
+	if port == "" {
+		return "", nil, errGrpcNoGrpcPort
+	}
+
+	return host + ":" + port, certInBytes, nil
+}
+
 func getPortFromNodeDefs(nodeDef *cbgt.NodeDef, key string) (string, error) {
 	var bindPort string
 	bindValue, err := nodeDef.GetFromParsedExtras(key)
@@ -474,26 +2044,73 @@ func getPortFromNodeDefs(nodeDef *cbgt.NodeDef, key string) (string, error) {
 	return bindPort, err
 }
 
+// DefaultGrpcClientMaxPIndexes is the default max number of pindexes
+// that a single grouped gRPC client serves, beyond which the group is
+// split into more clients, so that a failure only affects a bounded
+// number of pindexes and the RPCs stay bounded in size.  Larger values
+// mean fewer RPCs per query.  It's overridable by the
+// "grpcClientMaxPIndexes" manager option, where 0 means no limit.
+var DefaultGrpcClientMaxPIndexes = 64
+
+// grpcClientMaxPIndexes returns the max number of pindexes per grouped
+// gRPC client from the "grpcClientMaxPIndexes" manager option, falling
+// back to the DefaultGrpcClientMaxPIndexes when it's unset or invalid,
+// so that a bad option doesn't fail the queries.
+func grpcClientMaxPIndexes(mgr *cbgt.Manager) int {
+	if mgr != nil {
+		v, err := strconv.Atoi(mgr.Options()["grpcClientMaxPIndexes"])
+		if err == nil {
+			return v
+		}
+	}
+	return DefaultGrpcClientMaxPIndexes
+}
+
 // GroupGrpcClientsByHostPort groups the gRPC clients by their
 // HostPort, merging the pindexNames.  This is an enabler to allow
 // scatter/gather to use fewer gRPC calls.
 func GroupGrpcClientsByHostPort(clients []*GrpcClient) (rv []*GrpcClient) {
+	return GroupGrpcClientsByHostPortWithLimit(clients,
+		DefaultGrpcClientMaxPIndexes)
+}
+
+// GroupGrpcClientsByHostPortWithLimit is like GroupGrpcClientsByHostPort,
+// but splits a group into multiple clients whenever it would serve
+// more than maxPIndexes pindexes.  A maxPIndexes <= 0 means no limit.
+func GroupGrpcClientsByHostPortWithLimit(clients []*GrpcClient,
+	maxPIndexes int) (rv []*GrpcClient) {
 	m := map[string]*GrpcClient{}
+	splits := map[string]int{}
 
 	for _, client := range clients {
 		groupByKey := client.HostPort +
 			"/" + client.IndexName + "/" + client.IndexUUID
 
 		c, exists := m[groupByKey]
+		if exists && maxPIndexes > 0 && len(c.PIndexNames) >= maxPIndexes {
+			exists = false
+		}
 		if !exists {
+			name := groupByKey
+			if n := splits[groupByKey]; n > 0 {
+				name = fmt.Sprintf("%s#%d", groupByKey, n)
+			}
+			splits[groupByKey]++
+
 			c = &GrpcClient{
-				Mgr:         client.Mgr,
-				name:        groupByKey,
-				HostPort:    client.HostPort,
-				IndexName:   client.IndexName,
-				IndexUUID:   client.IndexUUID,
-				Consistency: client.Consistency,
-				GrpcCli:     client.GrpcCli,
+				Mgr:              client.Mgr,
+				name:             name,
+				HostPort:         client.HostPort,
+				IndexName:        client.IndexName,
+				IndexUUID:        client.IndexUUID,
+				Consistency:      client.Consistency,
+				GrpcCli:          client.GrpcCli,
+				Deadline:         client.Deadline,
+				RequestOverhead:  client.RequestOverhead,
+				PerPIndexTimeout: client.PerPIndexTimeout,
+				DispatchStagger:  client.DispatchStagger,
+				RequestRewriter:  client.RequestRewriter,
+				Secure:           client.Secure,
 			}
 
 			m[groupByKey] = c
@@ -502,6 +2119,7 @@ func GroupGrpcClientsByHostPort(clients []*GrpcClient) (rv []*GrpcClient) {
 		}
 
 		c.PIndexNames = append(c.PIndexNames, client.PIndexNames...)
+		c.connRefs = append(c.connRefs, client.connRefs...)
 	}
 
 	return rv
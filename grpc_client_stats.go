@@ -0,0 +1,121 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// GrpcCallStats are the stats of the gRPC client calls of a method
+// with an outcome, where the latencies are in nanoseconds.
+type GrpcCallStats struct {
+	TotCalls     uint64
+	AvgLatencyNS float64
+	P50LatencyNS float64
+	P99LatencyNS float64
+}
+
+// GrpcMethodStats are the stats of the gRPC client calls of a method,
+// by outcome.
+type GrpcMethodStats struct {
+	Succeeded GrpcCallStats
+	Failed    GrpcCallStats
+}
+
+type grpcCallStats struct {
+	totCalls uint64
+	latency  metrics.Histogram
+}
+
+func newGrpcCallStats() *grpcCallStats {
+	return &grpcCallStats{
+		latency: metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015)),
+	}
+}
+
+func (s *grpcCallStats) snapshot() GrpcCallStats {
+	rv := GrpcCallStats{TotCalls: atomic.LoadUint64(&s.totCalls)}
+	if s.latency.Count() > 0 {
+		ps := s.latency.Percentiles([]float64{0.5, 0.99})
+		rv.AvgLatencyNS = s.latency.Mean()
+		rv.P50LatencyNS = ps[0]
+		rv.P99LatencyNS = ps[1]
+	}
+	return rv
+}
+
+type grpcMethodStats struct {
+	succeeded *grpcCallStats
+	failed    *grpcCallStats
+}
+
+var grpcClientStatsMutex sync.Mutex
+
+// grpcClientStats are keyed by the short method name, like "Search",
+// where the methods of the service bound the number of entries.
+var grpcClientStats = map[string]*grpcMethodStats{}
+
+// grpcMethodName returns the short name of a full gRPC method name,
+// such as "Search" for "/search.SearchService/Search".
+func grpcMethodName(method string) string {
+	return method[strings.LastIndex(method, "/")+1:]
+}
+
+// recordGrpcCall accounts a completed gRPC client call.
+func recordGrpcCall(method string, d time.Duration, err error) {
+	name := grpcMethodName(method)
+
+	grpcClientStatsMutex.Lock()
+	ms, exists := grpcClientStats[name]
+	if !exists {
+		ms = &grpcMethodStats{
+			succeeded: newGrpcCallStats(),
+			failed:    newGrpcCallStats(),
+		}
+		grpcClientStats[name] = ms
+	}
+	grpcClientStatsMutex.Unlock()
+
+	s := ms.succeeded
+	if err != nil {
+		s = ms.failed
+	}
+	atomic.AddUint64(&s.totCalls, 1)
+	s.latency.Update(int64(d))
+}
+
+// GrpcClientStats returns a snapshot of the stats of the gRPC client
+// calls, keyed by the short method name, like "Search" or "DocCount".
+func GrpcClientStats() map[string]GrpcMethodStats {
+	grpcClientStatsMutex.Lock()
+	names := make([]string, 0, len(grpcClientStats))
+	stats := make([]*grpcMethodStats, 0, len(grpcClientStats))
+	for name, ms := range grpcClientStats {
+		names = append(names, name)
+		stats = append(stats, ms)
+	}
+	grpcClientStatsMutex.Unlock()
+
+	rv := make(map[string]GrpcMethodStats, len(names))
+	for i, ms := range stats {
+		rv[names[i]] = GrpcMethodStats{
+			Succeeded: ms.succeeded.snapshot(),
+			Failed:    ms.failed.snapshot(),
+		}
+	}
+	return rv
+}
@@ -0,0 +1,56 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGrpcMethodName(t *testing.T) {
+	tests := map[string]string{
+		"/search.SearchService/Search":   "Search",
+		"/search.SearchService/DocCount": "DocCount",
+		"Check":                          "Check",
+	}
+	for method, exp := range tests {
+		if got := grpcMethodName(method); got != exp {
+			t.Errorf("method: %q, expected: %q, got: %q", method, exp, got)
+		}
+	}
+}
+
+func TestGrpcClientStats(t *testing.T) {
+	method := "/search.SearchService/TestGrpcClientStats"
+
+	for i := 1; i <= 100; i++ {
+		recordGrpcCall(method, time.Duration(i)*time.Millisecond, nil)
+	}
+	recordGrpcCall(method, time.Second, fmt.Errorf("unavailable"))
+
+	s, exists := GrpcClientStats()["TestGrpcClientStats"]
+	if !exists {
+		t.Fatalf("expected the stats of the method")
+	}
+	if s.Succeeded.TotCalls != 100 || s.Failed.TotCalls != 1 {
+		t.Errorf("expected the calls by outcome, got: %+v", s)
+	}
+	if s.Succeeded.P50LatencyNS < float64(45*time.Millisecond) ||
+		s.Succeeded.P50LatencyNS > float64(55*time.Millisecond) ||
+		s.Succeeded.P99LatencyNS < float64(95*time.Millisecond) {
+		t.Errorf("expected the latency percentiles, got: %+v", s.Succeeded)
+	}
+	if s.Failed.AvgLatencyNS != float64(time.Second) {
+		t.Errorf("expected the failed latency apart, got: %+v", s.Failed)
+	}
+}
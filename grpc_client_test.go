@@ -0,0 +1,2267 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// blockingSearchClient is a pb.SearchServiceClient whose Search blocks
+// until released and then fails.
+type blockingSearchClient struct {
+	pb.SearchServiceClient
+	releaseCh chan struct{}
+}
+
+func (c *blockingSearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	<-c.releaseCh
+	return nil, fmt.Errorf("search failed")
+}
+
+func TestGrpcClientSearchInContextCancelNoLeak(t *testing.T) {
+	cli := &blockingSearchClient{releaseCh: make(chan struct{})}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     cli,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sr, err := g.SearchInContext(ctx,
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != nil || sr == nil || sr.Status == nil ||
+		len(sr.Status.Errors) != 1 {
+		t.Fatalf("expected an error search result, got: %#v, err: %v",
+			sr, err)
+	}
+
+	// let the Query goroutine complete after the caller has given up
+	close(cli.releaseCh)
+
+	// the goroutine gives up its worker of the scatter pool as it
+	// exits, so taking up all the workers awaits its exit
+	p := scatterPool()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < p.size; i++ {
+		if err := p.acquire(ctx); err != nil {
+			t.Fatalf("expected the Query goroutine to exit, err: %v", err)
+		}
+		defer p.release()
+	}
+}
+
+// cancelObservingServer streams a batch of hits and then searches until
+// its ctx, which is canceled once the stream is done, is done.
+type cancelObservingServer struct {
+	pb.SearchServiceServer
+	sentCh     chan struct{}
+	canceledCh chan time.Time
+}
+
+func (s *cancelObservingServer) Search(req *pb.SearchRequest,
+	stream pb.SearchService_SearchServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cancelOnStreamDone(stream.Context(), ctx, cancel)
+
+	err := stream.Send(&pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{Bytes: []byte("[]")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	close(s.sentCh)
+
+	<-ctx.Done()
+	s.canceledCh <- time.Now()
+	return ctx.Err()
+}
+
+func TestGrpcClientSearchInContextCancelMidStream(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &cancelObservingServer{
+		sentCh:     make(chan struct{}),
+		canceledCh: make(chan time.Time, 1),
+	}
+	s := grpc.NewServer()
+	pb.RegisterSearchServiceServer(s, srv)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	g := &GrpcClient{
+		HostPort:    lis.Addr().String(),
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     pb.NewSearchServiceClient(conn),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type searchRV struct {
+		sr  *bleve.SearchResult
+		err error
+	}
+	searchCh := make(chan searchRV, 1)
+	go func() {
+		sr, err := g.SearchInContext(ctx,
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+		searchCh <- searchRV{sr, err}
+	}()
+
+	select {
+	case <-srv.sentCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to stream the hits")
+	}
+
+	canceledAt := time.Now()
+	cancel()
+
+	select {
+	case rv := <-searchCh:
+		if rv.err != nil || rv.sr == nil || rv.sr.Status == nil ||
+			len(rv.sr.Status.Errors) != 1 {
+			t.Errorf("expected an error search result, got: %#v, err: %v",
+				rv.sr, rv.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the search to return on the cancellation")
+	}
+
+	select {
+	case observedAt := <-srv.canceledCh:
+		if d := observedAt.Sub(canceledAt); d > time.Second {
+			t.Errorf("expected the server to observe the cancellation"+
+				" promptly, took: %v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to observe the cancellation")
+	}
+}
+
+func TestChainUnaryClientInterceptors(t *testing.T) {
+	var calls []string
+
+	mk := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string,
+			req interface{}, reply interface{},
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption) error {
+			calls = append(calls, name+"-before")
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			calls = append(calls, name+"-after")
+			return err
+		}
+	}
+
+	chain := chainUnaryClientInterceptors(
+		[]grpc.UnaryClientInterceptor{mk("a"), mk("b")})
+
+	err := chain(context.Background(), "/test", nil, nil, nil,
+		func(ctx context.Context, method string,
+			req interface{}, reply interface{},
+			cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			calls = append(calls, "invoker")
+			return nil
+		})
+	if err != nil {
+		t.Errorf("expected no err, got: %v", err)
+	}
+
+	exp := []string{"a-before", "b-before", "invoker", "b-after", "a-after"}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Errorf("expected calls: %v, got: %v", exp, calls)
+	}
+}
+
+func TestGroupGrpcClientsByHostPortWithLimit(t *testing.T) {
+	var clients []*GrpcClient
+	for i := 0; i < 10; i++ {
+		for _, hostPort := range []string{"a:15000", "b:15000"} {
+			clients = append(clients, &GrpcClient{
+				HostPort:    hostPort,
+				IndexName:   "idx",
+				IndexUUID:   "uuid",
+				PIndexNames: []string{fmt.Sprintf("%s-p%d", hostPort, i)},
+			})
+		}
+	}
+
+	grouped := GroupGrpcClientsByHostPortWithLimit(clients, 3)
+	if len(grouped) != 8 {
+		t.Errorf("expected 8 grouped clients, got: %d", len(grouped))
+	}
+
+	seen := map[string]int{}
+	for _, c := range grouped {
+		if len(c.PIndexNames) > 3 {
+			t.Errorf("expected at most 3 pindexes per client, got: %v",
+				c.PIndexNames)
+		}
+		for _, pindexName := range c.PIndexNames {
+			if !strings.HasPrefix(pindexName, c.HostPort) {
+				t.Errorf("expected pindex: %s on host: %s",
+					pindexName, c.HostPort)
+			}
+			seen[pindexName]++
+		}
+	}
+
+	for _, client := range clients {
+		if seen[client.PIndexNames[0]] != 1 {
+			t.Errorf("expected pindex: %s to be served exactly once,"+
+				" got: %d", client.PIndexNames[0], seen[client.PIndexNames[0]])
+		}
+	}
+
+	grouped = GroupGrpcClientsByHostPortWithLimit(clients, 0)
+	if len(grouped) != 2 {
+		t.Errorf("expected 2 grouped clients with no limit, got: %d",
+			len(grouped))
+	}
+}
+
+func TestGrpcClientMaxPIndexesOption(t *testing.T) {
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil)
+
+	tests := []struct {
+		option string
+		exp    int
+	}{
+		{"", DefaultGrpcClientMaxPIndexes},
+		{"not-a-number", DefaultGrpcClientMaxPIndexes},
+		{"8", 8},
+		{"0", 0},
+	}
+
+	for i, test := range tests {
+		mgr.SetOptions(map[string]string{"grpcClientMaxPIndexes": test.option})
+		if got := grpcClientMaxPIndexes(mgr); got != test.exp {
+			t.Errorf("test: %d, option: %q, expected: %d, got: %d",
+				i, test.option, test.exp, got)
+		}
+	}
+}
+
+// hangingSearchClient is a search client whose Searches hang until
+// their ctx is done, capturing the timeouts of their query control
+// params.
+type hangingSearchClient struct {
+	pb.SearchServiceClient
+	timeouts []int64
+}
+
+func (c *hangingSearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	var queryCtlParams cbgt.QueryCtlParams
+	err := json.Unmarshal(in.QueryCtlParams, &queryCtlParams)
+	if err != nil {
+		return nil, err
+	}
+	c.timeouts = append(c.timeouts, queryCtlParams.Ctl.Timeout)
+
+	<-ctx.Done()
+	return nil, status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+}
+
+func TestGrpcClientSearchAttemptsFitDeadline(t *testing.T) {
+	defer func(d time.Duration) { DefaultGrpcSearchRetryBackoff = d }(
+		DefaultGrpcSearchRetryBackoff)
+	DefaultGrpcSearchRetryBackoff = 10 * time.Millisecond
+
+	budget := 600 * time.Millisecond
+
+	cli := &hangingSearchClient{}
+	g := &GrpcClient{
+		HostPort:        "localhost:15000",
+		IndexName:       "idx",
+		PIndexNames:     []string{"idx_pindex"},
+		GrpcCli:         cli,
+		RequestOverhead: 10 * time.Millisecond,
+	}
+
+	beforeRetries := atomic.LoadUint64(&totGrpcSearchRetries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+
+	start := time.Now()
+	g.SearchInContext(ctx, bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	elapsed := time.Since(start)
+
+	// every hung attempt is retried, all within the caller's deadline
+	if len(cli.timeouts) != DefaultGrpcSearchRetries+1 {
+		t.Fatalf("expected %d attempts, got: %d",
+			DefaultGrpcSearchRetries+1, len(cli.timeouts))
+	}
+	if retries := atomic.LoadUint64(&totGrpcSearchRetries) -
+		beforeRetries; retries != uint64(DefaultGrpcSearchRetries) {
+		t.Errorf("expected retries: %d, got: %d",
+			DefaultGrpcSearchRetries, retries)
+	}
+	if elapsed > budget+200*time.Millisecond {
+		t.Errorf("expected the attempts to fit the budget: %v, elapsed: %v",
+			budget, elapsed)
+	}
+
+	// the remote is told the timeout of its attempt, not the whole budget
+	var total int64
+	for i, timeout := range cli.timeouts {
+		if timeout <= 0 || timeout >= int64(budget/time.Millisecond) {
+			t.Errorf("expected attempt %d to get a share of the budget,"+
+				" got timeout: %dms", i, timeout)
+		}
+		total += timeout
+	}
+	if total > int64(budget/time.Millisecond) {
+		t.Errorf("expected the attempt timeouts to fit the budget,"+
+			" got: %v", cli.timeouts)
+	}
+}
+
+// ctlCapturingSearchClient is a pb.SearchServiceClient that captures
+// the query control params of a Search and then fails it.
+type ctlCapturingSearchClient struct {
+	pb.SearchServiceClient
+	queryCtlParams cbgt.QueryCtlParams
+	md             metadata.MD
+}
+
+func (c *ctlCapturingSearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	err := json.Unmarshal(in.QueryCtlParams, &c.queryCtlParams)
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("search failed")
+}
+
+func TestGrpcClientSearchInContextDeadlineHint(t *testing.T) {
+	tests := []struct {
+		ctxTimeout time.Duration
+		hint       time.Duration
+		expTimeout time.Duration
+	}{
+		{10 * time.Second, 0, 10 * time.Second},
+		{10 * time.Second, 3 * time.Second, 3 * time.Second},
+		{3 * time.Second, 10 * time.Second, 3 * time.Second},
+		{0, 3 * time.Second, 3 * time.Second},
+	}
+
+	for _, test := range tests {
+		cli := &ctlCapturingSearchClient{}
+		g := &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"idx_pindex"},
+			GrpcCli:     cli,
+		}
+		if test.hint > 0 {
+			g.Deadline = time.Now().Add(test.hint)
+		}
+
+		ctx := context.Background()
+		if test.ctxTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, test.ctxTimeout)
+			defer cancel()
+		}
+
+		_, err := g.SearchInContext(ctx,
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+		if err != nil {
+			t.Fatalf("expected an error search result, err: %v", err)
+		}
+
+		// the first attempt gets its share of the deadline
+		exp := int64((attemptTimeout(test.expTimeout, 0,
+			DefaultGrpcSearchRetries+1, DefaultGrpcSearchRetryBackoff) -
+			RemoteRequestOverhead) / time.Millisecond)
+		got := cli.queryCtlParams.Ctl.Timeout
+		if got > exp || got < exp-1000 {
+			t.Errorf("expected timeout of about %dms, got: %dms, test: %+v",
+				exp, got, test)
+		}
+	}
+
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     &ctlCapturingSearchClient{},
+		Deadline:    time.Now().Add(-time.Second),
+	}
+	_, err := g.SearchInContext(context.Background(),
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded for a past hint, got: %v", err)
+	}
+}
+
+func TestGrpcClientQueryAuth(t *testing.T) {
+	tests := []struct {
+		auth          *QueryAuth
+		insecure      bool
+		expToken      []string
+		expOnBehalfOf []string
+	}{
+		{nil, false, nil, nil},
+		{&QueryAuth{}, false, nil, nil},
+		{&QueryAuth{BearerToken: "t0k3n", User: "alice", Domain: "local"},
+			false, []string{"Bearer t0k3n"}, nil},
+		{&QueryAuth{User: "alice", Domain: "local"},
+			false, nil, []string{"YWxpY2U6bG9jYWw="}}, // alice:local
+		// never over the connections without TLS
+		{&QueryAuth{BearerToken: "t0k3n", User: "alice", Domain: "local"},
+			true, nil, nil},
+		{&QueryAuth{User: "alice", Domain: "local"},
+			true, nil, nil},
+	}
+
+	for i, test := range tests {
+		cli := &ctlCapturingSearchClient{}
+		g := &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"idx_pindex"},
+			GrpcCli:     cli,
+			Secure:      !test.insecure,
+		}
+
+		ctx := context.Background()
+		if test.auth != nil {
+			ctx = WithQueryAuth(ctx, *test.auth)
+		}
+
+		_, err := g.SearchInContext(ctx,
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+		if err != nil {
+			t.Fatalf("test %d, expected an error search result, err: %v", i, err)
+		}
+
+		if got := cli.md.Get(rpcAuthTokenKey); !reflect.DeepEqual(got,
+			test.expToken) {
+			t.Errorf("test %d, expected token: %v, got: %v",
+				i, test.expToken, got)
+		}
+		if got := cli.md.Get(rpcOnBehalfOfKey); !reflect.DeepEqual(got,
+			test.expOnBehalfOf) {
+			t.Errorf("test %d, expected on behalf of: %v, got: %v",
+				i, test.expOnBehalfOf, got)
+		}
+
+		// the requests are still marked as scatter-gather
+		if got := cli.md.Get(rpcClusterActionKey); len(got) != 1 ||
+			got[0] != clusterActionScatterGather {
+			t.Errorf("test %d, expected the cluster action, got: %v", i, got)
+		}
+	}
+}
+
+func TestGrpcRequestOverhead(t *testing.T) {
+	tests := []struct {
+		option      string
+		expOverhead time.Duration
+	}{
+		{"", RemoteRequestOverhead},
+		{"2s", 2 * time.Second},
+		{"0s", RemoteRequestOverhead},
+		{"-1s", RemoteRequestOverhead},
+		{"soon", RemoteRequestOverhead},
+	}
+
+	for _, test := range tests {
+		mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+			nil, "", 1, "", ":1000", "", "some-datasource",
+			map[string]string{"grpcRequestOverhead": test.option})
+		if d := grpcRequestOverhead(mgr); d != test.expOverhead {
+			t.Errorf("option: %q, expected overhead: %v, got: %v",
+				test.option, test.expOverhead, d)
+		}
+	}
+
+	cli := &ctlCapturingSearchClient{}
+	g := &GrpcClient{
+		HostPort:        "localhost:15000",
+		IndexName:       "idx",
+		PIndexNames:     []string{"idx_pindex"},
+		GrpcCli:         cli,
+		RequestOverhead: 2 * time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := g.SearchInContext(ctx,
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != nil {
+		t.Fatalf("expected an error search result, err: %v", err)
+	}
+
+	exp := int64(8 * time.Second / time.Millisecond)
+	got := cli.queryCtlParams.Ctl.Timeout
+	if got > exp || got < exp-1000 {
+		t.Errorf("expected timeout of about %dms, got: %dms", exp, got)
+	}
+}
+
+func TestGrpcClientPerPIndexTimeout(t *testing.T) {
+	tests := []struct {
+		perPIndexTimeout time.Duration
+		ctxTimeout       time.Duration
+		expTimeout       time.Duration
+		expDeadline      bool
+	}{
+		{0, 10 * time.Second, 10*time.Second - RemoteRequestOverhead, false},
+		{time.Second, 10 * time.Second, 3 * time.Second, true},
+		{5 * time.Second, 10 * time.Second,
+			10*time.Second - RemoteRequestOverhead, true},
+		{time.Second, 0, 3 * time.Second, true},
+	}
+
+	for _, test := range tests {
+		cli := &ctlCapturingSearchClient{}
+		g := &GrpcClient{
+			HostPort:         "localhost:15000",
+			IndexName:        "idx",
+			PIndexNames:      []string{"p1", "p2", "p3"},
+			GrpcCli:          cli,
+			PerPIndexTimeout: test.perPIndexTimeout,
+		}
+
+		ctx := context.Background()
+		if test.ctxTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, test.ctxTimeout)
+			defer cancel()
+		}
+
+		_, err := g.SearchInContext(ctx,
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+		if err != nil {
+			t.Fatalf("expected an error search result, err: %v", err)
+		}
+
+		exp := int64(test.expTimeout / time.Millisecond)
+		got := cli.queryCtlParams.Ctl.Timeout
+		if got > exp || got < exp-1000 {
+			t.Errorf("expected timeout of about %dms, got: %dms, test: %+v",
+				exp, got, test)
+		}
+
+		vals := cli.md.Get(rpcDeadlineKey)
+		if (len(vals) > 0) != test.expDeadline {
+			t.Errorf("expected deadline sent: %v, got: %v, test: %+v",
+				test.expDeadline, vals, test)
+		}
+		if len(vals) > 0 {
+			nanos, _ := strconv.ParseInt(vals[0], 10, 64)
+			if d := time.Until(time.Unix(0, nanos)); d > test.expTimeout ||
+				d < test.expTimeout-time.Second {
+				t.Errorf("expected deadline in about %v, got: %v, test: %+v",
+					test.expTimeout, d, test)
+			}
+		}
+	}
+}
+
+func TestAddGrpcClientsSkipsLocalNode(t *testing.T) {
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil)
+
+	remotePlanPIndexes := []*cbgt.RemotePlanPIndex{{
+		PlanPIndex: &cbgt.PlanPIndex{Name: "idx_pindex_0"},
+		NodeDef: &cbgt.NodeDef{
+			UUID:     mgr.UUID(),
+			HostPort: "localhost:1000",
+		},
+	}}
+
+	before := atomic.LoadUint64(&totGrpcClientSelfLoopSkipped)
+
+	clients, err := addGrpcClients(mgr, "idx", "uuid", remotePlanPIndexes,
+		nil, nil, nil, false)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if len(clients) != 0 {
+		t.Errorf("expected the local node to be skipped, got: %d clients",
+			len(clients))
+	}
+	if atomic.LoadUint64(&totGrpcClientSelfLoopSkipped) != before+1 {
+		t.Errorf("expected the self-loop skip to be counted")
+	}
+}
+
+// indexesCollector is a BleveIndexCollector of the added indexes.
+type indexesCollector struct {
+	indexes []bleve.Index
+}
+
+func (c *indexesCollector) Add(i ...bleve.Index) {
+	c.indexes = append(c.indexes, i...)
+}
+
+func (c *indexesCollector) VisitIndexes(visit func(bleve.Index)) {
+	for _, i := range c.indexes {
+		visit(i)
+	}
+}
+
+func TestAddGrpcClientsHttpFallback(t *testing.T) {
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil)
+
+	// the node of an older version doesn't advertise a gRPC port
+	remotePlanPIndexes := []*cbgt.RemotePlanPIndex{{
+		PlanPIndex: &cbgt.PlanPIndex{Name: "idx_pindex_0"},
+		NodeDef: &cbgt.NodeDef{
+			UUID:     cbgt.NewUUID(),
+			HostPort: "otherhost:1000",
+		},
+	}}
+
+	collector := &indexesCollector{}
+
+	clients, err := addGrpcClients(mgr, "idx", "uuid", remotePlanPIndexes,
+		nil, nil, collector, false)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if len(clients) != 1 || len(collector.indexes) != 1 {
+		t.Fatalf("expected the pindex to be kept, got: %d clients",
+			len(clients))
+	}
+
+	ic, ok := clients[0].(*IndexClient)
+	if !ok || !ic.grpcFallback || ic.HostPort != "otherhost:1000" {
+		t.Errorf("expected an http fallback client, got: %#v", clients[0])
+	}
+
+	transports := pindexTransports(clients)
+	if transports["idx_pindex_0"] != RemoteTransportHTTP {
+		t.Errorf("expected the http transport, got: %v", transports)
+	}
+}
+
+func TestGrpcClientKeepaliveParams(t *testing.T) {
+	defer func(v time.Duration) { GrpcStreamKeepAliveInterval = v }(
+		GrpcStreamKeepAliveInterval)
+
+	GrpcStreamKeepAliveInterval = 0
+	if kp := grpcClientKeepaliveParams(); kp.Time !=
+		DefaultGrpcConnectionHeartBeatInterval {
+		t.Errorf("expected the heart beat interval, got: %v", kp.Time)
+	}
+
+	GrpcStreamKeepAliveInterval = 15 * time.Second
+	if kp := grpcClientKeepaliveParams(); kp.Time != 15*time.Second {
+		t.Errorf("expected the stream keepalive interval, got: %v", kp.Time)
+	}
+}
+
+// slowStreamServer streams large batches of hits, more than the flow
+// control windows can hold, so that the stream stalls on a slow
+// consumer.
+type slowStreamServer struct {
+	pb.SearchServiceServer
+}
+
+func (s *slowStreamServer) Search(req *pb.SearchRequest,
+	stream pb.SearchService_SearchServer) error {
+	for i := 0; i < 4; i++ {
+		err := stream.Send(&pb.StreamSearchResults{
+			Contents: &pb.StreamSearchResults_Hits{
+				Hits: &pb.StreamSearchResults_Batch{
+					Bytes: make([]byte, 1024*1024),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idleReapingProxy forwards a connection, closing it once no bytes
+// have flowed either way for the idle timeout, like some proxies do.
+type idleReapingProxy struct {
+	lis          net.Listener
+	backend      string
+	idleTimeout  time.Duration
+	lastActivity int64
+	reaped       int32
+}
+
+func (p *idleReapingProxy) touch() {
+	atomic.StoreInt64(&p.lastActivity, time.Now().UnixNano())
+}
+
+func (p *idleReapingProxy) copy(dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.touch()
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *idleReapingProxy) serve() {
+	for {
+		conn, err := p.lis.Accept()
+		if err != nil {
+			return
+		}
+
+		backendConn, err := net.Dial("tcp", p.backend)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		p.touch()
+		go p.copy(backendConn, conn)
+		go p.copy(conn, backendConn)
+		go func() {
+			for range time.Tick(p.idleTimeout / 10) {
+				last := time.Unix(0, atomic.LoadInt64(&p.lastActivity))
+				if time.Since(last) > p.idleTimeout {
+					atomic.StoreInt32(&p.reaped, 1)
+					conn.Close()
+					backendConn.Close()
+					return
+				}
+			}
+		}()
+	}
+}
+
+// keepAliveTestInterval is the keepalive interval of the idle proxy
+// tests, which is kept short so that the tests run quickly, where the
+// idle timeout of the proxy and the stalls of the tests scale with it.
+var keepAliveTestInterval = 500 * time.Millisecond
+
+func TestGrpcClientSlowStreamSurvivesIdleProxy(t *testing.T) {
+	defer func(v time.Duration) { GrpcStreamKeepAliveInterval = v }(
+		GrpcStreamKeepAliveInterval)
+	GrpcStreamKeepAliveInterval = keepAliveTestInterval
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(KeepaliveEnforcementPolicy())
+	pb.RegisterSearchServiceServer(s, &slowStreamServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	proxyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyLis.Close()
+
+	// keepalive pings go out between one and two intervals after the
+	// last read, so the proxy must tolerate more than two intervals
+	proxy := &idleReapingProxy{
+		lis:         proxyLis,
+		backend:     lis.Addr().String(),
+		idleTimeout: keepAliveTestInterval * 22 / 10,
+	}
+	go proxy.serve()
+
+	conn, err := grpc.Dial(proxyLis.Addr().String(), grpc.WithInsecure(),
+		grpc.WithKeepaliveParams(grpcClientKeepaliveParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewSearchServiceClient(conn).Search(
+		context.Background(), &pb.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stream.Recv(); err != nil {
+		t.Fatalf("expected the first batch, err: %v", err)
+	}
+
+	// the slow consumer stalls the stream past the proxy idle timeout
+	time.Sleep(3 * keepAliveTestInterval)
+
+	for n := 1; ; n++ {
+		_, err = stream.Recv()
+		if err == io.EOF {
+			if n != 4 {
+				t.Errorf("expected 4 batches, got: %d", n)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected the slow stream to survive, err: %v", err)
+		}
+	}
+
+	if atomic.LoadInt32(&proxy.reaped) != 0 {
+		t.Errorf("expected the proxy to not reap the slow stream")
+	}
+}
+
+// blockingDocCountClient is a pb.SearchServiceClient whose DocCount
+// blocks until its ctx is done, like an RPC to an unresponsive server,
+// where the returnedCh is closed once it has returned.
+type blockingDocCountClient struct {
+	pb.SearchServiceClient
+	startedCh  chan struct{}
+	returnedCh chan struct{}
+}
+
+func (c *blockingDocCountClient) DocCount(ctx context.Context,
+	in *pb.DocCountRequest, opts ...grpc.CallOption) (
+	*pb.DocCountResult, error) {
+	defer close(c.returnedCh)
+	close(c.startedCh)
+	<-ctx.Done()
+	return nil, fmt.Errorf("rpc error: %v", ctx.Err())
+}
+
+func TestGrpcClientDocCountContextCancel(t *testing.T) {
+	cli := &blockingDocCountClient{
+		startedCh:  make(chan struct{}),
+		returnedCh: make(chan struct{}),
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     cli,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	type countResult struct {
+		count uint64
+		err   error
+	}
+	resultCh := make(chan countResult, 1)
+	go func() {
+		count, err := g.DocCountContext(ctx)
+		resultCh <- countResult{count, err}
+	}()
+
+	<-cli.startedCh
+	cancel()
+
+	select {
+	case r := <-resultCh:
+		if r.err != context.Canceled || r.count != 0 {
+			t.Errorf("expected context.Canceled, got: %d, err: %v",
+				r.count, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected DocCountContext to return promptly on cancel")
+	}
+
+	// the rpc isn't left blocked behind the canceled count
+	select {
+	case <-cli.returnedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the DocCount rpc to return on cancel")
+	}
+}
+
+// pindexDocCountClient is a pb.SearchServiceClient whose DocCount
+// returns the configured count or error of each pindex.
+type pindexDocCountClient struct {
+	pb.SearchServiceClient
+	counts map[string]int64
+	errs   map[string]error
+	calls  int64
+}
+
+func (c *pindexDocCountClient) DocCount(ctx context.Context,
+	in *pb.DocCountRequest, opts ...grpc.CallOption) (
+	*pb.DocCountResult, error) {
+	atomic.AddInt64(&c.calls, 1)
+	if err := c.errs[in.IndexName]; err != nil {
+		return nil, err
+	}
+	return &pb.DocCountResult{DocCount: c.counts[in.IndexName]}, nil
+}
+
+func TestGrpcClientDocCountDetailed(t *testing.T) {
+	errP2 := fmt.Errorf("p2 unavailable")
+	errP3 := fmt.Errorf("p3 unavailable")
+
+	tests := []struct {
+		name      string
+		errs      map[string]error
+		expCount  uint64
+		expErrs   map[string]error
+		expStrict error
+	}{
+		{"all-success", nil, 6, map[string]error{}, nil},
+		{"all-fail", map[string]error{"p1": errP2, "p2": errP2, "p3": errP3}, 0,
+			map[string]error{"p1": errP2, "p2": errP2, "p3": errP3}, errP2},
+		{"mixed", map[string]error{"p2": errP2, "p3": errP3}, 1,
+			map[string]error{"p2": errP2, "p3": errP3}, errP2},
+	}
+
+	for _, test := range tests {
+		g := &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"p1", "p2", "p3"},
+			GrpcCli: &pindexDocCountClient{
+				counts: map[string]int64{"p1": 1, "p2": 2, "p3": 3},
+				errs:   test.errs,
+			},
+		}
+
+		details, err := g.DocCountDetailed(context.Background())
+		if err != nil {
+			t.Fatalf("%s, expected no err, got: %v", test.name, err)
+		}
+		if details.Count != test.expCount {
+			t.Errorf("%s, expected count: %d, got: %d",
+				test.name, test.expCount, details.Count)
+		}
+		if !reflect.DeepEqual(details.Errors, test.expErrs) {
+			t.Errorf("%s, expected errs: %v, got: %v",
+				test.name, test.expErrs, details.Errors)
+		}
+
+		// the simple DocCount fails on any failed pindex
+		count, err := g.DocCount()
+		if err != test.expStrict {
+			t.Errorf("%s, expected strict err: %v, got: %v",
+				test.name, test.expStrict, err)
+		}
+		if err == nil && count != test.expCount {
+			t.Errorf("%s, expected strict count: %d, got: %d",
+				test.name, test.expCount, count)
+		}
+	}
+}
+
+func TestGrpcClientDocCountGrouped(t *testing.T) {
+	cli := &pindexDocCountClient{
+		counts: map[string]int64{"p1": 1, "p2": 2, "p3": 3, "p4": 4},
+	}
+
+	var clients []*GrpcClient
+	for _, pindexName := range []string{"p1", "p2", "p3", "p4"} {
+		clients = append(clients, &GrpcClient{
+			HostPort:    "a:15000",
+			IndexName:   "idx",
+			IndexUUID:   "uuid",
+			PIndexNames: []string{pindexName},
+			GrpcCli:     cli,
+		})
+	}
+
+	grouped := GroupGrpcClientsByHostPort(clients)
+	if len(grouped) != 1 {
+		t.Fatalf("expected 1 grouped client, got: %d", len(grouped))
+	}
+
+	count, err := grouped[0].DocCount()
+	if err != nil || count != 10 {
+		t.Errorf("expected the count of all the grouped pindexes: 10,"+
+			" got: %d, err: %v", count, err)
+	}
+	if cli.calls != 4 {
+		t.Errorf("expected a DocCount RPC per pindex, got: %d", cli.calls)
+	}
+
+	// no pindexes means no RPC
+	cli.calls = 0
+	count, err = (&GrpcClient{GrpcCli: cli}).DocCount()
+	if err != nil || count != 0 || cli.calls != 0 {
+		t.Errorf("expected a zero count without any RPC, got: %d,"+
+			" err: %v, calls: %d", count, err, cli.calls)
+	}
+}
+
+// bulkDocCountClient is a pindexDocCountClient of a server that counts
+// all the PIndexNames of a request in one round trip.
+type bulkDocCountClient struct {
+	pindexDocCountClient
+}
+
+func (c *bulkDocCountClient) DocCount(ctx context.Context,
+	in *pb.DocCountRequest, opts ...grpc.CallOption) (
+	*pb.DocCountResult, error) {
+	if len(in.PIndexNames) == 0 {
+		return c.pindexDocCountClient.DocCount(ctx, in, opts...)
+	}
+
+	atomic.AddInt64(&c.calls, 1)
+	rv := &pb.DocCountResult{PIndexDocCounts: map[string]int64{}}
+	for _, pindexName := range in.PIndexNames {
+		if err := c.errs[pindexName]; err != nil {
+			if rv.Errors == nil {
+				rv.Errors = map[string]string{}
+			}
+			rv.Errors[pindexName] = err.Error()
+			continue
+		}
+		rv.PIndexDocCounts[pindexName] = c.counts[pindexName]
+		rv.DocCount += c.counts[pindexName]
+	}
+	return rv, nil
+}
+
+func TestGrpcClientBulkDocCount(t *testing.T) {
+	cli := &bulkDocCountClient{pindexDocCountClient{
+		counts: map[string]int64{"p1": 1, "p2": 2, "p3": 3},
+		errs:   map[string]error{"p3": fmt.Errorf("p3 unavailable")},
+	}}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		IndexUUID:   "bulk-uuid",
+		PIndexNames: []string{"p1", "p2", "p3"},
+		GrpcCli:     cli,
+	}
+
+	details, err := g.DocCountDetailed(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Count != 3 || len(details.Errors) != 1 ||
+		details.Errors["p3"] == nil {
+		t.Errorf("expected the counts and errs of the pindexes, got: %+v",
+			details)
+	}
+	if cli.calls != 1 {
+		t.Errorf("expected a single DocCount RPC, got: %d", cli.calls)
+	}
+
+	if entry := getCachedDocCount("p2", "bulk-uuid"); entry == nil ||
+		entry.count != 2 {
+		t.Errorf("expected the count of the pindex to be cached, got: %+v",
+			entry)
+	}
+}
+
+func TestRpcConnPool(t *testing.T) {
+	resetGrpcClients()
+	defer resetGrpcClients()
+
+	defer func(v int) { connPoolSize = v }(connPoolSize)
+	connPoolSize = 1
+
+	// a live server keeps the connections from being replaced as failed
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	go s.Serve(lis)
+	defer s.Stop()
+
+	dials := 0
+	dial := func() (*grpc.ClientConn, error) {
+		dials++
+		return grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	}
+
+	acquire := func(key string, cert []byte) (*rpcConnRef, *grpc.ClientConn) {
+		pool, conn, err := acquireRpcConnPool(key, cert, dial)
+		if err != nil {
+			t.Fatalf("expected no acquire err, got: %v", err)
+		}
+		return &rpcConnRef{pool: pool}, conn
+	}
+
+	// concurrent clients of a node share its connections
+	r1, conn1 := acquire("n1-a:15000", nil)
+	r2, conn2 := acquire("n1-a:15000", nil)
+	if dials != 1 || conn1 != conn2 {
+		t.Fatalf("expected a single shared dial, got dials: %d", dials)
+	}
+
+	// closing a grouped client releases its references just once
+	grouped := GroupGrpcClientsByHostPort([]*GrpcClient{
+		{HostPort: "a:15000", connRefs: []*rpcConnRef{r1}},
+		{HostPort: "a:15000", connRefs: []*rpcConnRef{r2}},
+	})
+	grouped[0].Close()
+	grouped[0].Close()
+	if refs := rpcConnPoolRefs()["n1-a:15000"]; refs != 0 {
+		t.Errorf("expected no refs after close, got: %d", refs)
+	}
+	if conn1.GetState() == connectivity.Shutdown {
+		t.Errorf("expected the unreferenced conn to be kept until idle")
+	}
+
+	// a rotated cert replaces the connections
+	r3, conn3 := acquire("n1-a:15000", []byte("cert"))
+	if dials != 2 || conn3 == conn1 {
+		t.Fatalf("expected a new dial on cert change, got dials: %d", dials)
+	}
+	if conn1.GetState() != connectivity.Shutdown {
+		t.Errorf("expected the conn of the old cert to be closed")
+	}
+
+	// a reset keeps the referenced connections until released
+	resetGrpcClients()
+	if len(rpcConnPoolRefs()) != 0 {
+		t.Errorf("expected an empty cache after reset")
+	}
+	if conn3.GetState() == connectivity.Shutdown {
+		t.Errorf("expected the referenced conn to stay open")
+	}
+	r3.release()
+	if conn3.GetState() != connectivity.Shutdown {
+		t.Errorf("expected the conn to be closed on its last release")
+	}
+
+	// unreferenced connections are evicted once idle
+	prevIdleTimeout := DefaultGrpcConnPoolIdleTimeout
+	DefaultGrpcConnPoolIdleTimeout = 0
+	defer func() { DefaultGrpcConnPoolIdleTimeout = prevIdleTimeout }()
+
+	r4, conn4 := acquire("n2-b:15000", nil)
+	r4.release()
+	r5, _ := acquire("n3-c:15000", nil)
+	defer r5.release()
+	if _, exists := rpcConnPoolRefs()["n2-b:15000"]; exists ||
+		conn4.GetState() != connectivity.Shutdown {
+		t.Errorf("expected the idle conn to be evicted and closed")
+	}
+}
+
+func TestRpcConnPoolReplacesFailedConns(t *testing.T) {
+	resetGrpcClients()
+	defer resetGrpcClients()
+
+	defer func(v int) { connPoolSize = v }(connPoolSize)
+	connPoolSize = 1
+
+	// a port without a listener fails the connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, grpc.WithInsecure())
+	}
+
+	pool, conn1, err := acquireRpcConnPool("n1-"+addr, nil, dial)
+	if err != nil {
+		t.Fatalf("expected no acquire err, got: %v", err)
+	}
+	ref1 := &rpcConnRef{pool: pool}
+	defer ref1.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for state := conn1.GetState(); state != connectivity.TransientFailure; {
+		if !conn1.WaitForStateChange(ctx, state) {
+			t.Fatalf("expected the conn to fail, state: %s", state)
+		}
+		state = conn1.GetState()
+	}
+
+	prevReplaced := atomic.LoadUint64(&totGrpcConnsReplaced)
+
+	pool, conn2, err := acquireRpcConnPool("n1-"+addr, nil, dial)
+	if err != nil {
+		t.Fatalf("expected no acquire err, got: %v", err)
+	}
+	ref2 := &rpcConnRef{pool: pool}
+	defer ref2.release()
+
+	if conn2 == conn1 {
+		t.Fatalf("expected the failed conn to be replaced")
+	}
+	if atomic.LoadUint64(&totGrpcConnsReplaced) != prevReplaced+1 {
+		t.Errorf("expected the replacement to be counted")
+	}
+
+	// the failed conn stays open for the references that may use it
+	if conn1.GetState() == connectivity.Shutdown {
+		t.Errorf("expected the failed conn to stay open while referenced")
+	}
+	ref1.release()
+	if conn1.GetState() == connectivity.Shutdown {
+		t.Errorf("expected the failed conn to stay open while referenced")
+	}
+
+	// and is closed once the pool is unreferenced, unlike the pool
+	ref2.release()
+	if conn1.GetState() != connectivity.Shutdown {
+		t.Errorf("expected the failed conn to be closed")
+	}
+	if conn2.GetState() == connectivity.Shutdown {
+		t.Errorf("expected the replacing conn to stay open")
+	}
+}
+
+// checkServer is a pb.SearchServiceServer that only serves Check.
+type checkServer struct {
+	pb.SearchServiceServer
+}
+
+func (s *checkServer) Check(ctx context.Context,
+	in *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
+	return &pb.HealthCheckResponse{
+		Status: pb.HealthCheckResponse_SERVING,
+	}, nil
+}
+
+func TestGrpcClientIdleConnSurvivesIdleProxy(t *testing.T) {
+	defer func(v time.Duration) { GrpcStreamKeepAliveInterval = v }(
+		GrpcStreamKeepAliveInterval)
+	GrpcStreamKeepAliveInterval = keepAliveTestInterval
+	defer func(v bool) { GrpcKeepAlivePermitWithoutStream = v }(
+		GrpcKeepAlivePermitWithoutStream)
+	GrpcKeepAlivePermitWithoutStream = true
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(KeepaliveEnforcementPolicy())
+	pb.RegisterSearchServiceServer(s, &checkServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	proxyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyLis.Close()
+
+	proxy := &idleReapingProxy{
+		lis:         proxyLis,
+		backend:     lis.Addr().String(),
+		idleTimeout: keepAliveTestInterval * 22 / 10,
+	}
+	go proxy.serve()
+
+	conn, err := grpc.Dial(proxyLis.Addr().String(), grpc.WithInsecure(),
+		grpc.WithKeepaliveParams(grpcClientKeepaliveParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cli := pb.NewSearchServiceClient(conn)
+	check := &pb.HealthCheckRequest{Service: "Search"}
+	if _, err = cli.Check(context.Background(), check); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection stays without streams past the proxy idle timeout
+	time.Sleep(3 * keepAliveTestInterval)
+
+	if atomic.LoadInt32(&proxy.reaped) != 0 {
+		t.Errorf("expected the pings to keep the idle conn alive")
+	}
+	if state := conn.GetState(); state != connectivity.Ready {
+		t.Errorf("expected the idle conn to stay ready, state: %s", state)
+	}
+	if _, err = cli.Check(context.Background(), check); err != nil {
+		t.Errorf("expected the idle conn to be usable, err: %v", err)
+	}
+}
+
+// fieldsClient is a pb.SearchServiceClient whose Fields returns the
+// configured result or error.
+type fieldsClient struct {
+	pb.SearchServiceClient
+	res *pb.FieldsResult
+	err error
+	req *pb.FieldsRequest
+}
+
+func (c *fieldsClient) Fields(ctx context.Context,
+	in *pb.FieldsRequest, opts ...grpc.CallOption) (
+	*pb.FieldsResult, error) {
+	c.req = in
+	return c.res, c.err
+}
+
+func TestGrpcClientFields(t *testing.T) {
+	cli := &fieldsClient{res: &pb.FieldsResult{
+		Fields: []string{"age", "born", "name"},
+	}}
+	g := &GrpcClient{
+		IndexName:   "idx",
+		IndexUUID:   "uuid",
+		PIndexNames: []string{"p1", "p2"},
+		GrpcCli:     cli,
+	}
+
+	fields, err := g.Fields()
+	if err != nil || !reflect.DeepEqual(fields, cli.res.Fields) {
+		t.Errorf("expected the fields, got: %v, err: %v", fields, err)
+	}
+	if !reflect.DeepEqual(cli.req.PIndexNames, g.PIndexNames) {
+		t.Errorf("expected a single request for all the pindexes,"+
+			" got: %v", cli.req.PIndexNames)
+	}
+
+	// any failed pindex fails the fields
+	cli.res.Errors = map[string]string{"p2": "p2 unavailable"}
+	fields, err = g.Fields()
+	if err == nil || !strings.Contains(err.Error(), "p2 unavailable") ||
+		fields != nil {
+		t.Errorf("expected the pindex err, got: %v, err: %v", fields, err)
+	}
+
+	// servers of older versions don't implement the fields
+	cli.err = status.Error(codes.Unimplemented, "unknown method Fields")
+	if _, err = g.Fields(); err != indexClientUnimplementedErr {
+		t.Errorf("expected unimplemented, got: %v", err)
+	}
+}
+
+// mappingClient is a pb.SearchServiceClient whose GetMapping returns
+// the configured result or error.
+type mappingClient struct {
+	pb.SearchServiceClient
+	res   *pb.MappingResult
+	err   error
+	calls int
+}
+
+func (c *mappingClient) GetMapping(ctx context.Context,
+	in *pb.MappingRequest, opts ...grpc.CallOption) (
+	*pb.MappingResult, error) {
+	c.calls++
+	return c.res, c.err
+}
+
+func TestGrpcClientMapping(t *testing.T) {
+	im := mapping.NewIndexMapping()
+	im.DefaultAnalyzer = "keyword"
+	buf, err := MarshalJSON(im)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &mappingClient{res: &pb.MappingResult{Mapping: buf}}
+	g := &GrpcClient{
+		IndexName:   "mappingIdx",
+		IndexUUID:   "uuid",
+		PIndexNames: []string{"p1"},
+		GrpcCli:     cli,
+	}
+	defer func() {
+		mappingCacheMutex.Lock()
+		delete(mappingCache, g.IndexName)
+		mappingCacheMutex.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		m, ok := g.Mapping().(*mapping.IndexMappingImpl)
+		if !ok || m.DefaultAnalyzer != "keyword" {
+			t.Fatalf("expected the remote mapping, got: %#v", m)
+		}
+	}
+	if cli.calls != 1 {
+		t.Errorf("expected the mapping to be cached, calls: %d", cli.calls)
+	}
+
+	// a new index UUID invalidates the cached mapping
+	g.IndexUUID = "uuid2"
+	if g.Mapping() == nil || cli.calls != 2 {
+		t.Errorf("expected the mapping to be refetched, calls: %d", cli.calls)
+	}
+
+	// servers of older versions don't implement the mapping
+	g.IndexUUID = "uuid3"
+	cli.err = status.Error(codes.Unimplemented, "unknown method GetMapping")
+	if m := g.Mapping(); m != nil {
+		t.Errorf("expected no mapping, got: %#v", m)
+	}
+}
+
+// dumpClient is a pb.SearchServiceClient whose Dump streams the
+// configured results, or fails with the configured error.
+type dumpClient struct {
+	pb.SearchServiceClient
+	results []*pb.DumpResult
+	err     error
+	req     *pb.DumpRequest
+}
+
+func (c *dumpClient) Dump(ctx context.Context,
+	in *pb.DumpRequest, opts ...grpc.CallOption) (
+	pb.SearchService_DumpClient, error) {
+	c.req = in
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &dumpStream{results: c.results}, nil
+}
+
+type dumpStream struct {
+	grpc.ClientStream
+	results []*pb.DumpResult
+}
+
+func (s *dumpStream) Recv() (*pb.DumpResult, error) {
+	if len(s.results) == 0 {
+		return nil, io.EOF
+	}
+	rv := s.results[0]
+	s.results = s.results[1:]
+	return rv, nil
+}
+
+func TestGrpcClientDump(t *testing.T) {
+	cli := &dumpClient{results: []*pb.DumpResult{
+		{PIndexName: "p1", Key: []byte("k1"), Value: []byte("v1")},
+		{PIndexName: "p2", Error: "p2 unavailable"},
+		{PIndexName: "p2", Key: []byte("k2"), Value: []byte("v2")},
+	}}
+	g := &GrpcClient{
+		IndexName:   "idx",
+		IndexUUID:   "uuid",
+		PIndexNames: []string{"p1", "p2"},
+		GrpcCli:     cli,
+	}
+
+	var items []interface{}
+	for item := range g.DumpAll() {
+		items = append(items, item)
+	}
+	if cli.req.Kind != dumpKindAll ||
+		!reflect.DeepEqual(cli.req.PIndexNames, g.PIndexNames) {
+		t.Errorf("expected a dump of all the pindexes, got: %+v", cli.req)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items, got: %v", items)
+	}
+	row, ok := items[0].(*dumpRow)
+	if !ok || string(row.Key()) != "k1" || string(row.Value()) != "v1" {
+		t.Errorf("expected the first row, got: %v", items[0])
+	}
+	if err, ok := items[1].(error); !ok ||
+		!strings.Contains(err.Error(), "p2 unavailable") {
+		t.Errorf("expected the pindex err, got: %v", items[1])
+	}
+	if row, ok = items[2].(*dumpRow); !ok || string(row.Key()) != "k2" {
+		t.Errorf("expected the dump to go on after the err, got: %v", items[2])
+	}
+
+	// a doc that isn't found closes the channel without any rows
+	cli.results = nil
+	ch := g.DumpDoc("missing")
+	if ch == nil {
+		t.Fatalf("expected a channel for a missing doc")
+	}
+	for item := range ch {
+		t.Errorf("expected no rows for a missing doc, got: %v", item)
+	}
+	if cli.req.Kind != dumpKindDoc || cli.req.DocID != "missing" {
+		t.Errorf("expected a doc dump, got: %+v", cli.req)
+	}
+
+	// servers of older versions don't implement the dumps
+	cli.err = status.Error(codes.Unimplemented, "unknown method Dump")
+	items = nil
+	for item := range g.DumpFields() {
+		items = append(items, item)
+	}
+	if len(items) != 1 || items[0] != indexClientUnimplementedErr {
+		t.Errorf("expected unimplemented, got: %v", items)
+	}
+}
+
+// termDictClient is a pb.SearchServiceClient that streams the batches
+// of terms, or else fails with the err.
+type termDictClient struct {
+	pb.SearchServiceClient
+	batches [][]*pb.TermDictionaryEntry
+	err     error
+	req     *pb.TermDictionaryRequest
+	ctx     context.Context
+}
+
+func (c *termDictClient) TermDictionary(ctx context.Context,
+	in *pb.TermDictionaryRequest, opts ...grpc.CallOption) (
+	pb.SearchService_TermDictionaryClient, error) {
+	c.req = in
+	c.ctx = ctx
+	return &termDictStream{batches: c.batches, err: c.err}, nil
+}
+
+type termDictStream struct {
+	grpc.ClientStream
+	batches [][]*pb.TermDictionaryEntry
+	err     error
+}
+
+func (s *termDictStream) Recv() (*pb.TermDictionaryResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(s.batches) == 0 {
+		return nil, io.EOF
+	}
+	rv := &pb.TermDictionaryResult{Entries: s.batches[0]}
+	s.batches = s.batches[1:]
+	return rv, nil
+}
+
+func TestGrpcClientFieldDict(t *testing.T) {
+	cli := &termDictClient{batches: [][]*pb.TermDictionaryEntry{
+		{{Term: "a", Count: 1}, {Term: "b", Count: 2}},
+		{},
+		{{Term: "c", Count: 3}},
+	}}
+	g := &GrpcClient{
+		IndexName:   "idx",
+		IndexUUID:   "uuid",
+		PIndexNames: []string{"p1", "p2"},
+		GrpcCli:     cli,
+	}
+
+	dict, err := g.FieldDictRange("name", []byte("a"), []byte("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli.req.Kind != termDictKindRange || cli.req.Field != "name" ||
+		string(cli.req.StartTerm) != "a" || string(cli.req.EndTerm) != "z" ||
+		!reflect.DeepEqual(cli.req.PIndexNames, g.PIndexNames) {
+		t.Errorf("expected a range of all the pindexes, got: %+v", cli.req)
+	}
+
+	var terms []string
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		terms = append(terms, fmt.Sprintf("%s:%d", entry.Term, entry.Count))
+	}
+	if !reflect.DeepEqual(terms, []string{"a:1", "b:2", "c:3"}) {
+		t.Errorf("expected the terms across the batches, got: %v", terms)
+	}
+	if cli.ctx.Err() == nil {
+		t.Errorf("expected the stream to be done once the terms are")
+	}
+	dict.Close()
+
+	// closing the dict early cancels the stream
+	dict, err = g.FieldDictPrefix("name", []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli.req.Kind != termDictKindPrefix || string(cli.req.TermPrefix) != "b" {
+		t.Errorf("expected a prefix, got: %+v", cli.req)
+	}
+	if cli.ctx.Err() != nil {
+		t.Errorf("expected the stream to still be open")
+	}
+	dict.Close()
+	if cli.ctx.Err() == nil {
+		t.Errorf("expected the stream to be canceled on close")
+	}
+	if entry, err := dict.Next(); entry != nil || err != nil {
+		t.Errorf("expected no more terms once closed, got: %v, %v", entry, err)
+	}
+
+	// servers of older versions don't implement the term dictionaries
+	cli.err = status.Error(codes.Unimplemented,
+		"unknown method TermDictionary")
+	if _, err = g.FieldDict("name"); err != indexClientUnimplementedErr {
+		t.Errorf("expected unimplemented, got: %v", err)
+	}
+	if cli.ctx.Err() == nil {
+		t.Errorf("expected the failed stream to be canceled")
+	}
+}
+
+// flakySearchClient is a pb.SearchServiceClient whose Searches fail
+// with the errs, in turn, before streaming the msgs.
+type flakySearchClient struct {
+	pb.SearchServiceClient
+	errs     []error
+	msgs     []*pb.StreamSearchResults
+	searches int
+}
+
+func (c *flakySearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	c.searches++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+		// a mid-stream failure, after the msgs
+		return &resetStream{
+			contentsStream: contentsStream{msgs: c.msgs},
+		}, nil
+	}
+	return &contentsStream{msgs: c.msgs}, nil
+}
+
+// resetStream is a stream that fails as unavailable after its msgs.
+type resetStream struct {
+	contentsStream
+}
+
+func (s *resetStream) Recv() (*pb.StreamSearchResults, error) {
+	rv, err := s.contentsStream.Recv()
+	if err == io.EOF {
+		return nil, status.Error(codes.Unavailable, "reset")
+	}
+	return rv, err
+}
+
+func TestGrpcClientSearchRetries(t *testing.T) {
+	defer func(d time.Duration) { DefaultGrpcSearchRetryBackoff = d }(
+		DefaultGrpcSearchRetryBackoff)
+	DefaultGrpcSearchRetryBackoff = time.Millisecond
+
+	unavailable := status.Error(codes.Unavailable, "down")
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"total_hits":1}`),
+		},
+	}
+	hits := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{Bytes: []byte(`[]`)},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		errs        []error
+		msgs        []*pb.StreamSearchResults
+		sc          streamHandler
+		expSearches int
+		expErr      bool
+	}{
+		{"recovered", []error{unavailable, unavailable},
+			[]*pb.StreamSearchResults{result}, nil, 3, false},
+		{"exhausted", []error{unavailable, unavailable, unavailable},
+			[]*pb.StreamSearchResults{result}, nil, 3, true},
+		{"not transient", []error{status.Error(codes.InvalidArgument, "bad")},
+			[]*pb.StreamSearchResults{result}, nil, 1, true},
+		{"mid-stream before hits", []error{nil},
+			nil, &capturingStreamHandler{}, 2, false},
+		{"mid-stream after hits", []error{nil},
+			[]*pb.StreamSearchResults{hits}, &capturingStreamHandler{}, 1, true},
+	}
+
+	for _, test := range tests {
+		cli := &flakySearchClient{errs: test.errs, msgs: test.msgs}
+		g := &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"idx_pindex"},
+			GrpcCli:     cli,
+			sc:          test.sc,
+		}
+
+		beforeRetries := atomic.LoadUint64(&totGrpcSearchRetries)
+
+		_, err := g.Query(context.Background(), &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		if (err != nil) != test.expErr {
+			t.Errorf("%s, expected err: %v, got: %v", test.name, test.expErr, err)
+		}
+		if cli.searches != test.expSearches {
+			t.Errorf("%s, expected searches: %d, got: %d",
+				test.name, test.expSearches, cli.searches)
+		}
+		if retries := atomic.LoadUint64(&totGrpcSearchRetries) -
+			beforeRetries; retries != uint64(test.expSearches-1) {
+			t.Errorf("%s, expected retries: %d, got: %d",
+				test.name, test.expSearches-1, retries)
+		}
+	}
+
+	// a done ctx aborts the retries
+	cli := &flakySearchClient{errs: []error{unavailable, unavailable}}
+	g := &GrpcClient{GrpcCli: cli, PIndexNames: []string{"idx_pindex"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := g.Query(ctx, &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err == nil || cli.searches != 1 {
+		t.Errorf("expected no retries on a done ctx, got searches: %d,"+
+			" err: %v", cli.searches, err)
+	}
+}
+
+// A failed client yields an error search result rather than an err,
+// which the alias merges with the results of the healthy clients into
+// partial results, unless the query asked for complete results.
+func TestGrpcClientSearchInContextPartialResults(t *testing.T) {
+	hits := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{
+				Bytes:   []byte(`[{"id":"a"}]`),
+				Offsets: []uint64{11},
+				Total:   1,
+			},
+		},
+	}
+
+	// a mid-stream failure, after some hits were streamed
+	sc := &capturingStreamHandler{}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_p1", "idx_p2"},
+		GrpcCli: &flakySearchClient{
+			errs: []error{nil},
+			msgs: []*pb.StreamSearchResults{hits},
+		},
+		sc: sc,
+	}
+
+	res, err := g.SearchInContext(context.Background(),
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != nil {
+		t.Fatalf("expected an error search result, err: %v", err)
+	}
+	if res.Status.Total != 2 || res.Status.Failed != 2 ||
+		res.Status.Successful != 0 || len(res.Status.Errors) != 2 ||
+		res.Status.Errors["idx_p1"] == nil || res.Status.Errors["idx_p2"] == nil {
+		t.Errorf("expected the failed pindexes, got: %+v", res.Status)
+	}
+	if len(sc.writes) != 1 {
+		t.Errorf("expected the streamed hits to be kept, got: %d writes",
+			len(sc.writes))
+	}
+}
+
+func TestGrpcMsgSizeErr(t *testing.T) {
+	tests := []struct {
+		err    error
+		expMsg string
+	}{
+		{nil, ""},
+		{status.Error(codes.ResourceExhausted, "busy"), "busy"},
+		{status.Error(codes.ResourceExhausted,
+			"grpc: received message larger than max (62914560 vs. 52428800)"),
+			"grpc_client: message size: 62914560 bytes exceeds the limit:" +
+				" 52428800 bytes"},
+		{status.Error(codes.Internal, "grpc_server: Search stream send,"+
+			" err: grpc: trying to send message larger than max (7 vs. 5)"),
+			"grpc_client: message size: 7 bytes exceeds the limit: 5 bytes"},
+	}
+
+	for i, test := range tests {
+		err := grpcMsgSizeErr(test.err)
+		if test.expMsg == "" {
+			if err != nil {
+				t.Errorf("test %d, expected no err, got: %v", i, err)
+			}
+			continue
+		}
+		if !strings.HasPrefix(status.Convert(err).Message(), test.expMsg) {
+			t.Errorf("test %d, expected err: %q, got: %v", i, test.expMsg, err)
+		}
+	}
+
+	// the searches report the rewritten error
+	g := &GrpcClient{
+		GrpcCli: &failingSearchClient{recvErr: status.Error(
+			codes.ResourceExhausted,
+			"grpc: received message larger than max (62914560 vs. 52428800)")},
+		PIndexNames: []string{"idx_pindex"},
+	}
+	_, err := g.Query(context.Background(), &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err == nil || !strings.Contains(err.Error(), "grpcMaxRecvMsgSize") {
+		t.Errorf("expected the message size err, got: %v", err)
+	}
+	if _, body := g.GetLast(); !strings.Contains(string(body),
+		"exceeds the limit") {
+		t.Errorf("expected the message size err body, got: %s", body)
+	}
+}
+
+// failingSearchClient is a pb.SearchServiceClient whose Search fails
+// with the searchErr, or else streams nothing but the recvErr.
+type failingSearchClient struct {
+	pb.SearchServiceClient
+	searchErr error
+	recvErr   error
+}
+
+func (c *failingSearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	if c.searchErr != nil {
+		return nil, c.searchErr
+	}
+	return &failingStream{err: c.recvErr}, nil
+}
+
+type failingStream struct {
+	contentsStream
+	err error
+}
+
+func (s *failingStream) Recv() (*pb.StreamSearchResults, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.contentsStream.Recv()
+}
+
+func TestGrpcClientGetLastAfterQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		searchErr error
+		recvErr   error
+		expStatus int
+		expCode   string
+	}{
+		{"ok", nil, nil, 200, ""},
+		{"unavailable", status.Error(codes.Unavailable, "down"), nil,
+			503, "Unavailable"},
+		{"deadline", nil, status.Error(codes.DeadlineExceeded, "slow"),
+			504, "DeadlineExceeded"},
+		{"ctx deadline", nil, context.DeadlineExceeded, 504, "DeadlineExceeded"},
+		{"ctx canceled", context.Canceled, nil, 499, "Canceled"},
+		{"rejected", nil, status.Error(codes.ResourceExhausted, "busy"),
+			429, "ResourceExhausted"},
+		{"unknown", fmt.Errorf("broken"), nil, 500, "Unknown"},
+	}
+
+	for _, test := range tests {
+		g := &GrpcClient{
+			HostPort:  "localhost:15000",
+			IndexName: "idx",
+			GrpcCli: &failingSearchClient{
+				searchErr: test.searchErr,
+				recvErr:   test.recvErr,
+			},
+			// a stale outcome of an earlier search
+			lastSearchStatus: 503,
+			lastErrBody:      []byte("stale"),
+		}
+
+		_, err := g.Query(context.Background(), &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+
+		lastStatus, lastErrBody := g.GetLast()
+		if lastStatus != test.expStatus {
+			t.Errorf("%s, expected last status: %d, got: %d",
+				test.name, test.expStatus, lastStatus)
+		}
+
+		if test.expStatus == 200 {
+			if err != nil || lastErrBody != nil {
+				t.Errorf("%s, expected no err and no err body, got: %v, %s",
+					test.name, err, lastErrBody)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(),
+			fmt.Sprintf("status code: %d", test.expStatus)) {
+			t.Errorf("%s, expected status code in err, got: %v", test.name, err)
+		}
+
+		var body struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if er := json.Unmarshal(lastErrBody, &body); er != nil {
+			t.Fatalf("%s, expected a json err body, got: %s, err: %v",
+				test.name, lastErrBody, er)
+		}
+		if body.Status != test.expCode || body.Error == "" {
+			t.Errorf("%s, expected err body with code: %s, got: %+v",
+				test.name, test.expCode, body)
+		}
+	}
+}
+
+// pacedSearchClient is a pb.SearchServiceClient whose stream counts the
+// msgs received from it, and is closed with the ctx of its Search.
+type pacedSearchClient struct {
+	pb.SearchServiceClient
+	msgs   []*pb.StreamSearchResults
+	stream *pacedStream
+}
+
+func (c *pacedSearchClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	c.stream = &pacedStream{
+		contentsStream: contentsStream{msgs: c.msgs},
+		ctx:            ctx,
+	}
+	return c.stream, nil
+}
+
+type pacedStream struct {
+	contentsStream
+	ctx  context.Context
+	recv int64 // Accessed atomically.
+}
+
+func (s *pacedStream) Recv() (*pb.StreamSearchResults, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+	atomic.AddInt64(&s.recv, 1)
+	return s.contentsStream.Recv()
+}
+
+// slowStreamHandler is an artificially slow consumer, whose writes
+// block until released or until the ctx of the search is done, where
+// the recv, when set, is sampled as the writes are released.
+type slowStreamHandler struct {
+	writing chan struct{}
+	release chan struct{}
+	writes  int64 // Accessed atomically.
+
+	recv          func() int64
+	recvAtRelease []int64
+}
+
+func (h *slowStreamHandler) write(b []byte, offsets []uint64,
+	hitsCount int) error {
+	return h.writeContext(context.Background(), b, offsets, hitsCount)
+}
+
+func (h *slowStreamHandler) writeContext(ctx context.Context, b []byte,
+	offsets []uint64, hitsCount int) error {
+	h.writing <- struct{}{}
+	select {
+	case <-h.release:
+		if h.recv != nil {
+			h.recvAtRelease = append(h.recvAtRelease, h.recv())
+		}
+		atomic.AddInt64(&h.writes, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestGrpcClientStreamBackpressure(t *testing.T) {
+	hits := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{
+				Bytes:   []byte(`[{"id":"a"}]`),
+				Offsets: []uint64{11},
+				Total:   1,
+			},
+		},
+	}
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"total_hits":3}`),
+		},
+	}
+
+	newClient := func(sc streamHandler) (*GrpcClient, *pacedSearchClient) {
+		cli := &pacedSearchClient{
+			msgs: []*pb.StreamSearchResults{hits, hits, hits, result},
+		}
+		return &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"idx_pindex"},
+			GrpcCli:     cli,
+			sc:          sc,
+		}, cli
+	}
+
+	query := func(ctx context.Context, g *GrpcClient) chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := g.Query(ctx, &scatterRequest{
+				searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+			})
+			errCh <- err
+		}()
+		return errCh
+	}
+
+	// a slow consumer pauses the receive loop
+	sc := &slowStreamHandler{
+		writing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	g, cli := newClient(sc)
+	sc.recv = func() int64 { return atomic.LoadInt64(&cli.stream.recv) }
+	errCh := query(context.Background(), g)
+
+	for i := 0; i < 3; i++ {
+		<-sc.writing
+		sc.release <- struct{}{}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("expected the search to complete, err: %v", err)
+	}
+	if writes := atomic.LoadInt64(&sc.writes); writes != 3 {
+		t.Errorf("expected all the hits written, got: %d writes", writes)
+	}
+	// the msgs received by the time each write was released
+	if !reflect.DeepEqual(sc.recvAtRelease, []int64{1, 2, 3}) {
+		t.Errorf("expected no recv while the writes block, got: %v",
+			sc.recvAtRelease)
+	}
+
+	// until the consumer's ctx is done, which aborts the loop promptly
+	aborts := atomic.LoadUint64(&totGrpcStreamWriteAborts)
+
+	sc = &slowStreamHandler{
+		writing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	g, cli = newClient(sc)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh = query(ctx, g)
+
+	<-sc.writing
+	cancel()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("expected the search to be aborted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the search to be aborted promptly")
+	}
+	if cli.stream.ctx.Err() == nil {
+		t.Errorf("expected the stream to be closed")
+	}
+	if recv := atomic.LoadInt64(&cli.stream.recv); recv != 1 {
+		t.Errorf("expected no recv after the abort, got: %d", recv)
+	}
+	if atomic.LoadUint64(&totGrpcStreamWriteAborts) != aborts+1 {
+		t.Errorf("expected the aborted write to be counted")
+	}
+}
+
+// blockingSearchServer is a pb.SearchService_SearchServer whose sends
+// block until released, or until the ctx of the stream is done,
+// tracking whether any sends overlapped.
+type blockingSearchServer struct {
+	pb.SearchService_SearchServer
+	ctx      context.Context
+	sending  chan struct{}
+	release  chan struct{}
+	sent     int64 // Accessed atomically.
+	inFlight int64 // Accessed atomically.
+	overlaps int64 // Accessed atomically.
+}
+
+func (s *blockingSearchServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *blockingSearchServer) Send(m *pb.StreamSearchResults) error {
+	if atomic.AddInt64(&s.inFlight, 1) > 1 {
+		atomic.AddInt64(&s.overlaps, 1)
+	}
+	defer atomic.AddInt64(&s.inFlight, -1)
+
+	select {
+	case s.sending <- struct{}{}:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	select {
+	case <-s.release:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	atomic.AddInt64(&s.sent, 1)
+	return nil
+}
+
+func TestGrpcClientStreamerSendCancel(t *testing.T) {
+	newServer := func(ctx context.Context) (*blockingSearchServer, *streamer) {
+		srv := &blockingSearchServer{
+			ctx:     ctx,
+			sending: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		return srv, newStreamHandler("idx",
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()), srv)
+	}
+
+	hits := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{
+				Bytes:   []byte(`[{"id":"a"}]`),
+				Offsets: []uint64{11},
+				Total:   1,
+			},
+		},
+	}
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"total_hits":1}`),
+		},
+	}
+
+	// the ctx of the search is derived from the ctx of the stream
+	streamCtx, cancel := context.WithCancel(context.Background())
+	srv, sh := newServer(streamCtx)
+
+	cli := &pacedSearchClient{msgs: []*pb.StreamSearchResults{hits, hits}}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     cli,
+		sc:          sh,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := g.Query(streamCtx, &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		errCh <- err
+	}()
+
+	// while the send of the hits blocks, the later sends wait for it
+	<-srv.sending
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- sh.send(streamCtx, result)
+	}()
+
+	// and once the stream is done, they're all given up promptly
+	cancel()
+	for _, ch := range []chan error{errCh, sendErrCh} {
+		select {
+		case err := <-ch:
+			if err == nil {
+				t.Errorf("expected the send to be aborted")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the send to be aborted promptly")
+		}
+	}
+	if sent := atomic.LoadInt64(&srv.sent); sent != 0 {
+		t.Errorf("expected no sends, got: %d", sent)
+	}
+
+	// on a live stream, the sends are serialized
+	srv, sh = newServer(context.Background())
+
+	writeErrCh := make(chan error, 1)
+	go func() {
+		writeErrCh <- sh.write([]byte(`[{"id":"a"}]`), []uint64{11}, 1)
+	}()
+	<-srv.sending
+
+	// where a send whose ctx is done gives up its wait
+	doneCtx, doneCancel := context.WithCancel(context.Background())
+	doneCancel()
+	if err := sh.send(doneCtx, result); err == nil {
+		t.Errorf("expected the send of a done ctx to be given up")
+	}
+
+	go func() {
+		sendErrCh <- sh.send(context.Background(), result)
+	}()
+
+	srv.release <- struct{}{}
+	<-srv.sending
+	srv.release <- struct{}{}
+	for _, ch := range []chan error{writeErrCh, sendErrCh} {
+		if err := <-ch; err != nil {
+			t.Errorf("expected the send to complete, err: %v", err)
+		}
+	}
+
+	if atomic.LoadInt64(&srv.sent) != 2 ||
+		atomic.LoadInt64(&srv.overlaps) != 0 {
+		t.Errorf("expected 2 serialized sends, got: %d, overlaps: %d",
+			atomic.LoadInt64(&srv.sent), atomic.LoadInt64(&srv.overlaps))
+	}
+}
@@ -0,0 +1,136 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// The search stream messages are compressed one by one, rather than
+// by the gRPC transport, which compresses every message of a stream,
+// so that the small messages can skip the compression.
+
+// rpcAcceptContentEncodingKey is the metadata key used by the client to
+// advertise the content encodings it can decode, so that other clients
+// keep receiving uncompressed contents.
+const rpcAcceptContentEncodingKey = "rpcacceptcontentencoding"
+
+const contentEncodingGzip = "gzip"
+
+// DefaultGrpcCompressionMinBytes is the default size below which the
+// search stream messages aren't compressed, when gRPC compression is
+// enabled.  It's overridable by the "grpcCompressionMinBytes" manager
+// option.
+var DefaultGrpcCompressionMinBytes = 1024
+
+// totGrpcStreamMsgsCompressed and totGrpcStreamMsgsUncompressed track
+// the search stream messages sent by the server while the compression
+// is enabled, where the uncompressed ones were below the threshold.
+var totGrpcStreamMsgsCompressed uint64
+var totGrpcStreamMsgsUncompressed uint64
+
+// totGrpcStreamBytesBeforeCompression and
+// totGrpcStreamBytesAfterCompression track the sizes of the compressed
+// search stream messages, so that the benefit can be judged.
+var totGrpcStreamBytesBeforeCompression uint64
+var totGrpcStreamBytesAfterCompression uint64
+
+// grpcCompressionMinBytes returns the size from which the search
+// stream messages are compressed, or -1 when the compression is
+// disabled.
+func grpcCompressionMinBytes(mgr *cbgt.Manager) int {
+	if !grpcCompressionEnabled(mgr) {
+		return -1
+	}
+
+	if v := mgr.Options()["grpcCompression"]; v != contentEncodingGzip {
+		log.Warnf("grpc_compression: unsupported grpcCompression: %s", v)
+		return -1
+	}
+
+	if v := mgr.Options()["grpcCompressionMinBytes"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Warnf("grpc_compression: invalid grpcCompressionMinBytes: %s,"+
+				" err: %v", v, err)
+		} else {
+			return n
+		}
+	}
+
+	return DefaultGrpcCompressionMinBytes
+}
+
+// acceptsGzipContents returns true when the client of the incoming
+// request can decode gzip compressed contents.
+func acceptsGzipContents(ctx context.Context) bool {
+	v, err := extractMetaHeader(ctx, rpcAcceptContentEncodingKey)
+	return err == nil && v == contentEncodingGzip
+}
+
+// encodeContents compresses the contents of a search stream message
+// when they're at least minBytes in size, where a negative minBytes
+// means no compression, returning the contents and their encoding.
+func encodeContents(b []byte, minBytes int) ([]byte, string, error) {
+	if minBytes < 0 {
+		return b, "", nil
+	}
+
+	if len(b) < minBytes {
+		atomic.AddUint64(&totGrpcStreamMsgsUncompressed, 1)
+		return b, "", nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	atomic.AddUint64(&totGrpcStreamMsgsCompressed, 1)
+	atomic.AddUint64(&totGrpcStreamBytesBeforeCompression, uint64(len(b)))
+	atomic.AddUint64(&totGrpcStreamBytesAfterCompression, uint64(buf.Len()))
+
+	return buf.Bytes(), contentEncodingGzip, nil
+}
+
+// decodeContents returns the uncompressed contents of a search stream
+// message, given its encoding.
+func decodeContents(b []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return b, nil
+
+	case contentEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("grpc_compression: unknown content encoding: %q",
+		encoding)
+}
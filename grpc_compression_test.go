@@ -0,0 +1,198 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestEncodeContents(t *testing.T) {
+	small := []byte(`[{"id":"a"}]`)
+	large := bytes.Repeat([]byte(`{"id":"a"},`), 100)
+
+	tests := []struct {
+		b           []byte
+		minBytes    int
+		expEncoding string
+	}{
+		{large, -1, ""},
+		{small, 64, ""},
+		{large, 64, contentEncodingGzip},
+		{small, 0, contentEncodingGzip},
+	}
+
+	for i, test := range tests {
+		b, encoding, err := encodeContents(test.b, test.minBytes)
+		if err != nil {
+			t.Fatalf("test %d, expected no encode err, got: %v", i, err)
+		}
+		if encoding != test.expEncoding {
+			t.Errorf("test %d, expected encoding: %q, got: %q",
+				i, test.expEncoding, encoding)
+		}
+
+		b, err = decodeContents(b, encoding)
+		if err != nil || !bytes.Equal(b, test.b) {
+			t.Errorf("test %d, expected round trip, got: %s, err: %v",
+				i, b, err)
+		}
+	}
+
+	if _, err := decodeContents(small, "br"); err == nil {
+		t.Errorf("expected unknown encoding err")
+	}
+}
+
+func TestEncodeContentsBytesStats(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"id":"a"},`), 100)
+
+	prevBefore := atomic.LoadUint64(&totGrpcStreamBytesBeforeCompression)
+	prevAfter := atomic.LoadUint64(&totGrpcStreamBytesAfterCompression)
+
+	// the uncompressed messages aren't counted
+	if _, _, err := encodeContents(large, len(large)+1); err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := encodeContents(large, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := atomic.LoadUint64(&totGrpcStreamBytesBeforeCompression) - prevBefore
+	after := atomic.LoadUint64(&totGrpcStreamBytesAfterCompression) - prevAfter
+	if before != uint64(len(large)) || after != uint64(len(b)) {
+		t.Errorf("expected bytes before: %d, after: %d, got: %d, %d",
+			len(large), len(b), before, after)
+	}
+	if after >= before {
+		t.Errorf("expected the compression to shrink the bytes")
+	}
+}
+
+// contentsStreamClient is a pb.SearchServiceClient whose Search
+// streams the given messages.
+type contentsStreamClient struct {
+	pb.SearchServiceClient
+	msgs []*pb.StreamSearchResults
+}
+
+func (c *contentsStreamClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	return &contentsStream{msgs: c.msgs}, nil
+}
+
+type contentsStream struct {
+	grpc.ClientStream
+	msgs []*pb.StreamSearchResults
+}
+
+func (s *contentsStream) Recv() (*pb.StreamSearchResults, error) {
+	if len(s.msgs) == 0 {
+		return nil, io.EOF
+	}
+	rv := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return rv, nil
+}
+
+func (s *contentsStream) Header() (metadata.MD, error) {
+	return nil, nil
+}
+
+func (s *contentsStream) Trailer() metadata.MD {
+	return nil
+}
+
+// capturingStreamHandler captures the hits bytes written to it.
+type capturingStreamHandler struct {
+	writes [][]byte
+}
+
+func (h *capturingStreamHandler) write(b []byte, offsets []uint64,
+	hitsCount int) error {
+	h.writes = append(h.writes, b)
+	return nil
+}
+
+func TestGrpcClientMixedCompressedStream(t *testing.T) {
+	smallHits := []byte(`[{"id":"a"}]`)
+	largeHits := []byte(`[` + string(bytes.Repeat([]byte(`{"id":"b"},`), 99)) +
+		`{"id":"b"}]`)
+	result := []byte(`{"total_hits":101,"status":{"total":1,"successful":1}}`)
+
+	hitsMsg := func(b []byte, minBytes int) *pb.StreamSearchResults {
+		b, encoding, err := encodeContents(b, minBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.StreamSearchResults{
+			Contents: &pb.StreamSearchResults_Hits{
+				Hits: &pb.StreamSearchResults_Batch{Bytes: b},
+			},
+			ContentEncoding: encoding,
+		}
+	}
+
+	compressedResult, encoding, err := encodeContents(result, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &contentsStreamClient{msgs: []*pb.StreamSearchResults{
+		hitsMsg(smallHits, 64),
+		hitsMsg(largeHits, 64),
+		{
+			Contents: &pb.StreamSearchResults_SearchResult{
+				SearchResult: compressedResult,
+			},
+			ContentEncoding: encoding,
+		},
+	}}
+	if cli.msgs[0].ContentEncoding != "" ||
+		cli.msgs[1].ContentEncoding != contentEncodingGzip {
+		t.Fatalf("expected a mix of compressed and uncompressed messages")
+	}
+
+	sh := &capturingStreamHandler{}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex"},
+		GrpcCli:     cli,
+		sc:          sh,
+	}
+
+	sr, err := g.SearchRPC(context.Background(), &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	}, &pb.SearchRequest{})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	if len(sh.writes) != 2 || !bytes.Equal(sh.writes[0], smallHits) ||
+		!bytes.Equal(sh.writes[1], largeHits) {
+		t.Errorf("expected the decoded hits, got: %q", sh.writes)
+	}
+	if sr.Total != 101 {
+		t.Errorf("expected the decoded search result, got: %+v", sr)
+	}
+}
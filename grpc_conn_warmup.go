@@ -0,0 +1,120 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcConnWarmup controls whether the freshly dialed connections of a
+// node are warmed up in the background with a ping, so that the first
+// queries on them don't pay for the TCP and TLS handshakes.
+var GrpcConnWarmup = true
+
+// GrpcConnWarmupSuspectTimeout is how long a node whose connection
+// failed its warmup stays suspect, unless a later ping of the node
+// succeeds first.
+var GrpcConnWarmupSuspectTimeout = 30 * time.Second
+
+// totGrpcConnWarmups tracks the connections that were warmed up, and
+// totGrpcConnWarmupFailures the ones whose warmup failed.
+var totGrpcConnWarmups uint64
+var totGrpcConnWarmupFailures uint64
+
+// warmUp pings the node over each of the connections in the
+// background, without holding up the caller.
+func (pool *rpcConnPool) warmUp(conns ...*grpc.ClientConn) {
+	if !GrpcConnWarmup {
+		return
+	}
+
+	for _, conn := range conns {
+		go pool.warmUpConn(conn)
+	}
+}
+
+// warmUpConn pings the node over the connection, which completes its
+// handshakes, marking the node as suspect when the ping fails.  The
+// nodes of older versions, which don't serve the pings, still answer
+// over the warmed up connection.
+func (pool *rpcConnPool) warmUpConn(conn *grpc.ClientConn) {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		DefaultGrpcPingTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+
+	_, err := pb.NewSearchServiceClient(conn).Ping(ctx, &pb.PingRequest{})
+	if err == nil || status.Code(err) == codes.Unimplemented {
+		atomic.AddUint64(&totGrpcConnWarmups, 1)
+		pool.recordPing(time.Since(startTime))
+		return
+	}
+
+	// a connection closed meanwhile tells nothing about the node
+	if conn.GetState() == connectivity.Shutdown {
+		return
+	}
+
+	atomic.AddUint64(&totGrpcConnWarmupFailures, 1)
+	atomic.StoreInt64(&pool.suspectAt, time.Now().UnixNano())
+
+	log.Warnf("grpc_client: connection warmup failed, marking node suspect, %s",
+		logFields("host", pool.key, "err", err))
+}
+
+// suspect returns whether a connection of the node failed its warmup
+// within the GrpcConnWarmupSuspectTimeout.
+func (pool *rpcConnPool) suspect(now time.Time) bool {
+	at := atomic.LoadInt64(&pool.suspectAt)
+	return at > 0 && now.Sub(time.Unix(0, at)) < GrpcConnWarmupSuspectTimeout
+}
+
+// rpcNodeSuspect returns whether any of the pools of a node is suspect.
+func rpcNodeSuspect(nodeUUID string) bool {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	now := time.Now()
+	for key, pool := range rpcConnPools {
+		if strings.HasPrefix(key, nodeUUID+"-") && pool.suspect(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrpcSuspectNodes returns the keys of the node connection pools that
+// are suspect, as of a failed warmup.
+func GrpcSuspectNodes() []string {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	var rv []string
+	now := time.Now()
+	for key, pool := range rpcConnPools {
+		if pool.suspect(now) {
+			rv = append(rv, key)
+		}
+	}
+	return rv
+}
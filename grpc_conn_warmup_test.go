@@ -0,0 +1,154 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc"
+)
+
+func TestRpcConnPoolWarmUp(t *testing.T) {
+	resetGrpcClients()
+	defer resetGrpcClients()
+
+	defer func(v int) { connPoolSize = v }(connPoolSize)
+	connPoolSize = 1
+
+	// the warmups are run inline below
+	defer func(v bool) { GrpcConnWarmup = v }(GrpcConnWarmup)
+	GrpcConnWarmup = false
+
+	// a server without the search service answers as an older node
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	go s.Serve(lis)
+	defer s.Stop()
+
+	pool, conn, err := acquireRpcConnPool("n1-"+lis.Addr().String(), nil,
+		func() (*grpc.ClientConn, error) {
+			return grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		})
+	if err != nil {
+		t.Fatalf("expected no acquire err, got: %v", err)
+	}
+	defer (&rpcConnRef{pool: pool}).release()
+
+	prevWarmups := atomic.LoadUint64(&totGrpcConnWarmups)
+
+	pool.warmUpConn(conn)
+	if atomic.LoadUint64(&totGrpcConnWarmups) != prevWarmups+1 {
+		t.Errorf("expected the warmup to be counted")
+	}
+	if rpcNodePingLatency("n1") <= 0 {
+		t.Errorf("expected the warmup to seed the ping latency")
+	}
+	if rpcNodeSuspect("n1") {
+		t.Errorf("expected the warmed up node not to be suspect")
+	}
+
+	// a port without a listener fails the warmup
+	lis2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis2.Addr().String()
+	lis2.Close()
+
+	pool2, conn2, err := acquireRpcConnPool("n2-"+addr, nil,
+		func() (*grpc.ClientConn, error) {
+			return grpc.Dial(addr, grpc.WithInsecure())
+		})
+	if err != nil {
+		t.Fatalf("expected no acquire err, got: %v", err)
+	}
+	defer (&rpcConnRef{pool: pool2}).release()
+
+	prevFailures := atomic.LoadUint64(&totGrpcConnWarmupFailures)
+
+	pool2.warmUpConn(conn2)
+	if atomic.LoadUint64(&totGrpcConnWarmupFailures) != prevFailures+1 {
+		t.Errorf("expected the warmup failure to be counted")
+	}
+	if !rpcNodeSuspect("n2") {
+		t.Errorf("expected the node to be suspect")
+	}
+	if nodes := GrpcSuspectNodes(); len(nodes) != 1 || nodes[0] != "n2-"+addr {
+		t.Errorf("expected the suspect node, got: %v", nodes)
+	}
+
+	// the suspicion lapses, or is cleared by a later ping
+	if pool2.suspect(time.Now().Add(GrpcConnWarmupSuspectTimeout)) {
+		t.Errorf("expected the suspicion to lapse")
+	}
+	pool2.recordPing(time.Millisecond)
+	if rpcNodeSuspect("n2") {
+		t.Errorf("expected the answered ping to clear the suspicion")
+	}
+}
+
+func TestSelectReplicasPassesOverSuspectNodes(t *testing.T) {
+	resetGrpcClients()
+	defer resetGrpcClients()
+
+	rpcConnMutex.Lock()
+	rpcConnPools["a-a:15000"] = &rpcConnPool{key: "a-a:15000",
+		suspectAt: time.Now().UnixNano()}
+	rpcConnMutex.Unlock()
+
+	nodeDefs := &cbgt.NodeDefs{NodeDefs: map[string]*cbgt.NodeDef{
+		"a": {UUID: "a", HostPort: "a:8094"},
+		"b": {UUID: "b", HostPort: "b:8094"},
+	}}
+
+	replicated := &cbgt.PlanPIndex{
+		Name: "replicated",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"a": {CanRead: true},
+			"b": {CanRead: true},
+		},
+	}
+
+	remotePlanPIndexes := []*cbgt.RemotePlanPIndex{
+		{PlanPIndex: replicated, NodeDef: nodeDefs.NodeDefs["a"]},
+	}
+
+	selector := &roundRobinReplicaSelector{}
+	for i := 0; i < 2; i++ {
+		rv := selectReplicas(selector, nodeDefs, "self", remotePlanPIndexes)
+		if len(rv) != 1 || rv[0].NodeDef.UUID != "b" {
+			t.Errorf("expected the replica of the other node, got: %+v", rv)
+		}
+	}
+
+	// with every replica suspect, they're all still chosen from
+	rpcConnMutex.Lock()
+	rpcConnPools["b-b:15000"] = &rpcConnPool{key: "b-b:15000",
+		suspectAt: time.Now().UnixNano()}
+	rpcConnMutex.Unlock()
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rv := selectReplicas(selector, nodeDefs, "self", remotePlanPIndexes)
+		seen[rv[0].NodeDef.UUID] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected turns across the suspect replicas, got: %v", seen)
+	}
+}
@@ -0,0 +1,180 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// rpcConsistencyVectorsKey is the metadata key used by the client to
+// ask for the seqs that the pindexes of a search reached for its
+// consistency vectors, which the server then returns as a JSON encoded
+// trailer under the same key.
+const rpcConsistencyVectorsKey = "rpcconsistencyvectors"
+
+// totGrpcConsistencyUnsatisfied tracks the pindexes that the remote
+// servers reported as short of the consistency vectors of a search.
+var totGrpcConsistencyUnsatisfied uint64
+
+// PIndexConsistency is the outcome of the consistency wait of a pindex,
+// where Achieved holds the seqs reached by the partitions of the pindex
+// that the consistency vector asked for, keyed by partition.
+type PIndexConsistency struct {
+	Satisfied bool              `json:"satisfied"`
+	Achieved  map[string]uint64 `json:"achieved,omitempty"`
+}
+
+// hasConsistencyVectors returns whether the query waits for any
+// consistency vectors.
+func hasConsistencyVectors(ctlParams *cbgt.QueryCtlParams) bool {
+	return ctlParams != nil && ctlParams.Ctl.Consistency != nil &&
+		len(ctlParams.Ctl.Consistency.Vectors) > 0
+}
+
+// pindexConsistency compares the partition seqs of a pindex with the
+// consistency vector, which is keyed by partition or by partition and
+// partition UUID, where only the partitions of the pindex count, and
+// where a partition of a different UUID falls short.
+func pindexConsistency(vector cbgt.ConsistencyVector,
+	seqs map[string]cbgt.UUIDSeq) *PIndexConsistency {
+	rv := &PIndexConsistency{Satisfied: true}
+
+	for key, wanted := range vector {
+		partition, partitionUUID := key, ""
+		if i := strings.Index(key, "/"); i >= 0 {
+			partition, partitionUUID = key[:i], key[i+1:]
+		}
+
+		uuidSeq, exists := seqs[partition]
+		if !exists {
+			continue
+		}
+
+		if rv.Achieved == nil {
+			rv.Achieved = map[string]uint64{}
+		}
+		rv.Achieved[partition] = uuidSeq.Seq
+
+		if uuidSeq.Seq < wanted ||
+			(partitionUUID != "" && partitionUUID != uuidSeq.UUID) {
+			rv.Satisfied = false
+		}
+	}
+
+	return rv
+}
+
+// localPIndexesConsistency returns the consistency of the local
+// pindexes of the index, or of only the onlyPIndexes, if any, with the
+// consistency vector, keyed by pindex name.
+func localPIndexesConsistency(mgr *cbgt.Manager, indexName string,
+	onlyPIndexes map[string]bool,
+	vector cbgt.ConsistencyVector) map[string]*PIndexConsistency {
+	rv := map[string]*PIndexConsistency{}
+
+	_, pindexes := mgr.CurrentMaps()
+	for _, pindex := range pindexes {
+		if pindex.IndexName != indexName ||
+			(onlyPIndexes != nil && !onlyPIndexes[pindex.Name]) {
+			continue
+		}
+
+		destFwd, ok := pindex.Dest.(*cbgt.DestForwarder)
+		if !ok || destFwd == nil {
+			continue
+		}
+		provider, ok := destFwd.DestProvider.(PartitionSeqsProvider)
+		if !ok {
+			continue
+		}
+		seqs, err := provider.PartitionSeqs()
+		if err != nil {
+			continue
+		}
+
+		rv[pindex.Name] = pindexConsistency(vector, seqs)
+	}
+
+	return rv
+}
+
+// setConsistencyVectorsTrailer reports the consistency of the local
+// pindexes of a search with its consistency vector as trailer metadata
+// on the stream, when the client asked for it.
+func setConsistencyVectorsTrailer(stream grpc.ServerStream,
+	mgr *cbgt.Manager, indexName string, onlyPIndexes map[string]bool,
+	consistencyParams *cbgt.ConsistencyParams) {
+	if _, er := extractMetaHeader(stream.Context(),
+		rpcConsistencyVectorsKey); er != nil {
+		return
+	}
+
+	vector := consistencyParams.Vectors[indexName]
+	if len(vector) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(localPIndexesConsistency(mgr, indexName,
+		onlyPIndexes, vector))
+	if err != nil {
+		return
+	}
+
+	stream.SetTrailer(metadata.Pairs(rpcConsistencyVectorsKey, string(b)))
+}
+
+// consistencyFromTrailer returns the consistency of the pindexes
+// reported by a remote server in its trailer, if any.
+func consistencyFromTrailer(md metadata.MD) (
+	map[string]*PIndexConsistency, error) {
+	vals := md.Get(rpcConsistencyVectorsKey)
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	var rv map[string]*PIndexConsistency
+	err := json.Unmarshal([]byte(vals[0]), &rv)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range rv {
+		if c != nil && !c.Satisfied {
+			atomic.AddUint64(&totGrpcConsistencyUnsatisfied, 1)
+		}
+	}
+
+	return rv, nil
+}
+
+// LastConsistency returns the consistency of the pindexes of the last
+// search of the client with its consistency vectors, keyed by pindex
+// name, which tells a stale result apart from a failed one, or nil if
+// the search had no consistency vectors or the server didn't report
+// them, as with servers of older versions.
+func (g *GrpcClient) LastConsistency() map[string]*PIndexConsistency {
+	g.lastMutex.RLock()
+	defer g.lastMutex.RUnlock()
+	return g.lastConsistency
+}
+
+func (g *GrpcClient) setLastConsistency(c map[string]*PIndexConsistency) {
+	g.lastMutex.Lock()
+	g.lastConsistency = c
+	g.lastMutex.Unlock()
+}
@@ -0,0 +1,153 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPIndexConsistency(t *testing.T) {
+	seqs := map[string]cbgt.UUIDSeq{
+		"0": {UUID: "u0", Seq: 10},
+		"1": {UUID: "u1", Seq: 5},
+	}
+
+	tests := []struct {
+		name         string
+		vector       cbgt.ConsistencyVector
+		expSatisfied bool
+		expAchieved  map[string]uint64
+	}{
+		{"reached", cbgt.ConsistencyVector{"0": 10, "1": 5}, true,
+			map[string]uint64{"0": 10, "1": 5}},
+		{"behind", cbgt.ConsistencyVector{"0": 10, "1": 6}, false,
+			map[string]uint64{"0": 10, "1": 5}},
+		{"uuid matched", cbgt.ConsistencyVector{"0/u0": 8}, true,
+			map[string]uint64{"0": 10}},
+		{"uuid mismatched", cbgt.ConsistencyVector{"0/other": 8}, false,
+			map[string]uint64{"0": 10}},
+		{"other pindex's partitions", cbgt.ConsistencyVector{"7": 100}, true,
+			nil},
+	}
+
+	for _, test := range tests {
+		got := pindexConsistency(test.vector, seqs)
+		if got.Satisfied != test.expSatisfied {
+			t.Errorf("%s, expected satisfied: %t, got: %t",
+				test.name, test.expSatisfied, got.Satisfied)
+		}
+		if !reflect.DeepEqual(got.Achieved, test.expAchieved) {
+			t.Errorf("%s, expected achieved: %v, got: %v",
+				test.name, test.expAchieved, got.Achieved)
+		}
+	}
+}
+
+// trailerStreamClient is a pb.SearchServiceClient whose Searches stream
+// the msgs and then the trailer, recording the ctx of the last search.
+type trailerStreamClient struct {
+	pb.SearchServiceClient
+	msgs    []*pb.StreamSearchResults
+	trailer metadata.MD
+	ctx     context.Context
+}
+
+func (c *trailerStreamClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	c.ctx = ctx
+	return &trailerStream{
+		contentsStream: contentsStream{msgs: c.msgs},
+		trailer:        c.trailer,
+	}, nil
+}
+
+type trailerStream struct {
+	contentsStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func TestGrpcClientLastConsistency(t *testing.T) {
+	cli := &trailerStreamClient{
+		msgs: []*pb.StreamSearchResults{{
+			Contents: &pb.StreamSearchResults_SearchResult{
+				SearchResult: []byte(`{"total_hits":1}`),
+			},
+		}},
+		trailer: metadata.Pairs(rpcConsistencyVectorsKey,
+			`{"idx_pindex_0":{"satisfied":true,"achieved":{"0":10}},`+
+				`"idx_pindex_1":{"satisfied":false,"achieved":{"1":3}}}`),
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0", "idx_pindex_1"},
+		GrpcCli:     cli,
+	}
+
+	prev := atomic.LoadUint64(&totGrpcConsistencyUnsatisfied)
+
+	_, err := g.Query(context.Background(), &scatterRequest{
+		ctlParams: &cbgt.QueryCtlParams{Ctl: cbgt.QueryCtl{
+			Consistency: &cbgt.ConsistencyParams{
+				Level: "at_plus",
+				Vectors: map[string]cbgt.ConsistencyVector{
+					"idx": {"0": 10, "1": 5},
+				},
+			},
+		}},
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(cli.ctx)
+	if len(md[rpcConsistencyVectorsKey]) == 0 {
+		t.Errorf("expected the consistency vectors to be asked for")
+	}
+
+	c := g.LastConsistency()
+	if c["idx_pindex_0"] == nil || !c["idx_pindex_0"].Satisfied ||
+		c["idx_pindex_1"] == nil || c["idx_pindex_1"].Satisfied ||
+		c["idx_pindex_1"].Achieved["1"] != 3 {
+		t.Errorf("expected the reported consistency, got: %+v", c)
+	}
+
+	if atomic.LoadUint64(&totGrpcConsistencyUnsatisfied) != prev+1 {
+		t.Errorf("expected the unsatisfied pindex to be counted")
+	}
+
+	// a search without consistency vectors reports none
+	_, err = g.Query(context.Background(), &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := g.LastConsistency(); c != nil {
+		t.Errorf("expected no consistency, got: %+v", c)
+	}
+}
@@ -0,0 +1,108 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+)
+
+// DefaultGrpcCountCacheTTL is the default age up to which Count()
+// reuses the last doc count of a remote pindex instead of sending
+// another DocCount RPC, which cuts the RPCs of the alias targets that
+// are polled frequently for their counts, at the cost of counts that
+// are stale by up to the TTL.  The default of 0 disables the cache, so
+// that it's opt-in with the "grpcCountCacheTTL" manager option.
+var DefaultGrpcCountCacheTTL = time.Duration(0)
+
+// totGrpcCountCacheHits tracks the pindexes whose doc counts Count()
+// took from the cache, and totGrpcCountCacheMisses tracks the ones
+// that it counted with a DocCount RPC.
+var totGrpcCountCacheHits uint64
+var totGrpcCountCacheMisses uint64
+
+// grpcCountCacheTTL returns the "grpcCountCacheTTL" manager option,
+// parsed as a duration, or else the DefaultGrpcCountCacheTTL.
+func grpcCountCacheTTL(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return DefaultGrpcCountCacheTTL
+	}
+
+	if v := mgr.Options()["grpcCountCacheTTL"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Warnf("grpc_client: invalid grpcCountCacheTTL: %q, err: %v",
+				v, err)
+		} else {
+			return d
+		}
+	}
+
+	return DefaultGrpcCountCacheTTL
+}
+
+// Count returns the doc count of the pindexes of the client, reusing
+// the last doc counts of the pindexes that were counted within the
+// count cache TTL for the same index UUID, and sending DocCount RPCs
+// only for the rest.  As with DocCount, any failed pindex fails the
+// count.  Use CountFresh where a possibly stale count isn't acceptable.
+func (g *GrpcClient) Count() (uint64, error) {
+	ttl := grpcCountCacheTTL(g.Mgr)
+	if ttl <= 0 {
+		return g.CountFresh()
+	}
+
+	var rv uint64
+	var staleNames []string
+
+	for _, pindexName := range g.PIndexNames {
+		entry := getCachedDocCount(pindexName, g.IndexUUID)
+		if entry == nil || time.Since(entry.at) >= ttl {
+			staleNames = append(staleNames, pindexName)
+			continue
+		}
+		rv += entry.count
+	}
+
+	atomic.AddUint64(&totGrpcCountCacheHits,
+		uint64(len(g.PIndexNames)-len(staleNames)))
+
+	if len(staleNames) == 0 {
+		return rv, nil
+	}
+
+	atomic.AddUint64(&totGrpcCountCacheMisses, uint64(len(staleNames)))
+
+	details, err := g.docCountDetailed(context.Background(), staleNames)
+	if err != nil {
+		return 0, err
+	}
+	for _, pindexName := range staleNames {
+		if er, exists := details.Errors[pindexName]; exists {
+			return 0, er
+		}
+	}
+
+	return rv + details.Count, nil
+}
+
+// CountFresh returns the doc count of the pindexes of the client,
+// always counting them with DocCount RPCs, bypassing the count cache,
+// for the callers that are sensitive to the correctness of the count.
+// The fresh counts refresh the cache.
+func (g *GrpcClient) CountFresh() (uint64, error) {
+	return g.DocCount()
+}
@@ -0,0 +1,107 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGrpcClientCountCache(t *testing.T) {
+	defer func(v time.Duration) { DefaultGrpcCountCacheTTL = v }(
+		DefaultGrpcCountCacheTTL)
+	DefaultGrpcCountCacheTTL = time.Hour
+
+	cli := &pindexDocCountClient{
+		counts: map[string]int64{"cc_p1": 1, "cc_p2": 2},
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		IndexUUID:   "uuid0",
+		PIndexNames: []string{"cc_p1", "cc_p2"},
+		GrpcCli:     cli,
+	}
+
+	count, err := g.Count()
+	if err != nil || count != 3 || cli.calls != 2 {
+		t.Fatalf("expected the first count to be sent, got: %d, err: %v,"+
+			" calls: %d", count, err, cli.calls)
+	}
+
+	// a stable count is served from the cache
+	cli.counts["cc_p1"] = 10
+	count, err = g.Count()
+	if err != nil || count != 3 || cli.calls != 2 {
+		t.Errorf("expected the cached count, got: %d, err: %v, calls: %d",
+			count, err, cli.calls)
+	}
+
+	// the fresh count bypasses, and refreshes, the cache
+	count, err = g.CountFresh()
+	if err != nil || count != 12 || cli.calls != 4 {
+		t.Errorf("expected the fresh count, got: %d, err: %v, calls: %d",
+			count, err, cli.calls)
+	}
+	count, err = g.Count()
+	if err != nil || count != 12 || cli.calls != 4 {
+		t.Errorf("expected the refreshed count, got: %d, err: %v, calls: %d",
+			count, err, cli.calls)
+	}
+
+	// a recreated index invalidates the cached counts
+	cli.counts["cc_p2"] = 20
+	g.IndexUUID = "uuid1"
+	count, err = g.Count()
+	if err != nil || count != 30 || cli.calls != 6 {
+		t.Errorf("expected the counts of the new index, got: %d, err: %v,"+
+			" calls: %d", count, err, cli.calls)
+	}
+
+	// only the stale pindexes are counted again
+	setCachedDocCount("cc_p1", "uuid1", 10)
+	docCountCacheMutex.Lock()
+	docCountCache["cc_p2"].at = time.Now().Add(-2 * time.Hour)
+	docCountCacheMutex.Unlock()
+
+	cli.errs = map[string]error{"cc_p2": fmt.Errorf("p2 unavailable")}
+	if _, err = g.Count(); err == nil {
+		t.Errorf("expected the failed stale pindex to fail the count")
+	}
+	if cli.calls != 7 {
+		t.Errorf("expected only the stale pindex to be counted, calls: %d",
+			cli.calls)
+	}
+}
+
+func TestGrpcClientCountCacheDisabled(t *testing.T) {
+	// the cache is opt-in
+	cli := &pindexDocCountClient{
+		counts: map[string]int64{"ccd_p1": 1},
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"ccd_p1"},
+		GrpcCli:     cli,
+	}
+
+	for i := 0; i < 3; i++ {
+		if count, err := g.Count(); err != nil || count != 1 {
+			t.Fatalf("expected the count, got: %d, err: %v", count, err)
+		}
+	}
+	if cli.calls != 3 {
+		t.Errorf("expected a DocCount RPC per count, got: %d", cli.calls)
+	}
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+)
+
+// DefaultGrpcDispatchMaxJitter is the default max random stagger of the
+// dispatch of the scatter-gather rpcs to the different nodes, which
+// keeps the very wide fan-outs from hitting all the nodes at once.
+// It's overridable by the "grpcDispatchMaxJitter" manager option,
+// where 0 disables the stagger.
+var DefaultGrpcDispatchMaxJitter = time.Duration(0)
+
+// GrpcDispatchJitterTimeoutFraction is the max fraction of the time
+// left to a query that its dispatch may be staggered by.
+var GrpcDispatchJitterTimeoutFraction = 0.1
+
+// totGrpcDispatchesStaggered tracks the staggered dispatches, and
+// totGrpcDispatchSpreadNS the total time they were staggered by.
+var totGrpcDispatchesStaggered uint64
+var totGrpcDispatchSpreadNS uint64
+
+// grpcDispatchMaxJitter returns the "grpcDispatchMaxJitter" manager
+// option, or else the DefaultGrpcDispatchMaxJitter.
+func grpcDispatchMaxJitter(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return DefaultGrpcDispatchMaxJitter
+	}
+
+	if v := mgr.Options()["grpcDispatchMaxJitter"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Warnf("grpc_client: invalid grpcDispatchMaxJitter: %q,"+
+				" err: %v", v, err)
+		} else {
+			return d
+		}
+	}
+
+	return DefaultGrpcDispatchMaxJitter
+}
+
+// staggerGrpcClients assigns each node of a fan-out a random dispatch
+// stagger within the max jitter, shared by the clients of the node.
+func staggerGrpcClients(clients []*GrpcClient, maxJitter time.Duration) {
+	if maxJitter <= 0 || len(clients) < 2 {
+		return
+	}
+
+	staggers := make(map[string]time.Duration, len(clients))
+	for _, c := range clients {
+		stagger, exists := staggers[c.HostPort]
+		if !exists {
+			stagger = time.Duration(rand.Int63n(int64(maxJitter)))
+			staggers[c.HostPort] = stagger
+		}
+		c.DispatchStagger = stagger
+	}
+}
+
+// dispatchStagger returns how long to stagger the dispatch of a query
+// by, which is capped at a fraction of the time left until the
+// deadline, if any.
+func dispatchStagger(stagger time.Duration, now, deadline time.Time) time.Duration {
+	if stagger <= 0 || deadline.IsZero() {
+		return stagger
+	}
+
+	max := time.Duration(float64(deadline.Sub(now)) *
+		GrpcDispatchJitterTimeoutFraction)
+	if stagger > max {
+		stagger = max
+	}
+	if stagger < 0 {
+		return 0
+	}
+	return stagger
+}
+
+// staggerDispatch holds back the dispatch of a query by the stagger of
+// the client, or until the ctx is done, whose err is then returned.
+func (g *GrpcClient) staggerDispatch(ctx context.Context,
+	req *scatterRequest) error {
+	deadline := req.deadline
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	stagger := dispatchStagger(g.DispatchStagger, time.Now(), deadline)
+	if stagger <= 0 {
+		return nil
+	}
+
+	atomic.AddUint64(&totGrpcDispatchesStaggered, 1)
+	atomic.AddUint64(&totGrpcDispatchSpreadNS, uint64(stagger))
+
+	timer := time.NewTimer(stagger)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
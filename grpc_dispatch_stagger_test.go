@@ -0,0 +1,66 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaggerGrpcClients(t *testing.T) {
+	clients := []*GrpcClient{
+		{HostPort: "a:15000"},
+		{HostPort: "b:15000"},
+		{HostPort: "a:15000"},
+	}
+
+	staggerGrpcClients(clients, 0)
+	for _, c := range clients {
+		if c.DispatchStagger != 0 {
+			t.Errorf("expected no stagger by default, got: %v", c.DispatchStagger)
+		}
+	}
+
+	staggerGrpcClients(clients, 100*time.Millisecond)
+	for _, c := range clients {
+		if c.DispatchStagger < 0 || c.DispatchStagger >= 100*time.Millisecond {
+			t.Errorf("expected the stagger within the jitter, got: %v",
+				c.DispatchStagger)
+		}
+	}
+	if clients[0].DispatchStagger != clients[2].DispatchStagger {
+		t.Errorf("expected the clients of a node to share the stagger")
+	}
+}
+
+func TestDispatchStagger(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		stagger  time.Duration
+		deadline time.Time
+		exp      time.Duration
+	}{
+		{0, now.Add(time.Second), 0},
+		{50 * time.Millisecond, time.Time{}, 50 * time.Millisecond},
+		{50 * time.Millisecond, now.Add(time.Second), 50 * time.Millisecond},
+		// capped at a fraction of the time left
+		{500 * time.Millisecond, now.Add(time.Second), 100 * time.Millisecond},
+		{50 * time.Millisecond, now.Add(-time.Second), 0},
+	}
+
+	for i, test := range tests {
+		if got := dispatchStagger(test.stagger, now, test.deadline); got != test.exp {
+			t.Errorf("test: %d, expected: %v, got: %v", i, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"sync"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+// totGrpcFacetSnapshots tracks the interim facets received from the
+// remote servers.
+var totGrpcFacetSnapshots uint64
+
+// FacetSnapshotCallback is invoked with the interim facets of a search,
+// as merged from the pindexes that completed so far.
+type FacetSnapshotCallback func(facets search.FacetResults)
+
+type facetSnapshotsKeyType string
+
+const facetSnapshotsKey = facetSnapshotsKeyType("facetSnapshots")
+
+// facetSnapshots collects the facets of the pindexes of a search as
+// they complete, which are either relayed onto the stream of a server,
+// or else merged into the interim facets for the callback.
+type facetSnapshots struct {
+	m        sync.Mutex
+	relay    func(facets []byte) error
+	callback FacetSnapshotCallback
+	merged   search.FacetResults
+	closed   bool
+}
+
+// WithFacetSnapshots returns a ctx that has the faceted queries made
+// with it ask the remote servers for the facets of their pindexes as
+// they complete, ahead of their results, where the callback is invoked
+// with the interim facets merged so far, trimmed to the sizes of the
+// facet requests.  The facets of the final search result supersede
+// the interim ones, which may miss the pindexes of the servers of
+// older versions.  The callback is invoked serially.
+func WithFacetSnapshots(ctx context.Context,
+	callback FacetSnapshotCallback) context.Context {
+	return context.WithValue(ctx, facetSnapshotsKey,
+		&facetSnapshots{callback: callback})
+}
+
+// withFacetRelay returns a ctx that has the facets of the pindexes of
+// a search relayed as they complete.
+func withFacetRelay(ctx context.Context,
+	relay func(facets []byte) error) (context.Context, *facetSnapshots) {
+	fs := &facetSnapshots{relay: relay}
+	return context.WithValue(ctx, facetSnapshotsKey, fs), fs
+}
+
+// facetSnapshotsFromContext returns the facetSnapshots of the ctx, or
+// nil when the search didn't opt into the interim facets.
+func facetSnapshotsFromContext(ctx context.Context) *facetSnapshots {
+	fs, _ := ctx.Value(facetSnapshotsKey).(*facetSnapshots)
+	return fs
+}
+
+// addFacets adds the facets of a completed pindex.
+func (fs *facetSnapshots) addFacets(facets search.FacetResults,
+	facetsReq bleve.FacetsRequest) error {
+	if len(facets) == 0 {
+		return nil
+	}
+
+	b, err := MarshalJSON(facets)
+	if err != nil {
+		return err
+	}
+
+	return fs.add(b, facetsReq)
+}
+
+// add relays, or else merges, the JSON encoded facets of the pindexes
+// that completed, unless the search is already done.
+func (fs *facetSnapshots) add(b []byte,
+	facetsReq bleve.FacetsRequest) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if fs.closed {
+		return nil
+	}
+
+	if fs.relay != nil {
+		return fs.relay(b)
+	}
+
+	if fs.callback == nil {
+		return nil
+	}
+
+	var facets search.FacetResults
+	err := UnmarshalJSON(b, &facets)
+	if err != nil {
+		return err
+	}
+
+	if fs.merged == nil {
+		fs.merged = search.FacetResults{}
+	}
+	mergeFacetResults(fs.merged, facets)
+
+	// the merged facets keep all their terms for the later merges, so
+	// the callback gets a trimmed copy
+	b, err = MarshalJSON(fs.merged)
+	if err != nil {
+		return err
+	}
+	var snapshot search.FacetResults
+	err = UnmarshalJSON(b, &snapshot)
+	if err != nil {
+		return err
+	}
+	for name, fr := range facetsReq {
+		snapshot.Fixup(name, fr.Size)
+	}
+
+	fs.callback(snapshot)
+
+	return nil
+}
+
+// close drops the facets of the pindexes that complete after the
+// search is done, as when it timed out.
+func (fs *facetSnapshots) close() {
+	fs.m.Lock()
+	fs.closed = true
+	fs.m.Unlock()
+}
+
+// mergeFacetResults merges the other facets into the facets, where a
+// facet that has no terms or ranges yet, as of an empty pindex, still
+// takes those of the other facet.
+func mergeFacetResults(facets, other search.FacetResults) {
+	for name, o := range other {
+		fr, exists := facets[name]
+		if !exists {
+			facets[name] = o
+			continue
+		}
+
+		if fr.Terms == nil && o.Terms != nil {
+			fr.Terms = search.TermFacets{}
+		}
+		if fr.NumericRanges == nil && o.NumericRanges != nil {
+			fr.NumericRanges = search.NumericRangeFacets{}
+		}
+		if fr.DateRanges == nil && o.DateRanges != nil {
+			fr.DateRanges = search.DateRangeFacets{}
+		}
+		fr.Merge(o)
+	}
+}
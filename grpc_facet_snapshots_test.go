@@ -0,0 +1,173 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func typeFacets(counts map[string]int) search.FacetResults {
+	fr := &search.FacetResult{Field: "type"}
+	for term, count := range counts {
+		fr.Total += count
+		fr.Terms = fr.Terms.Add(&search.TermFacet{Term: term, Count: count})
+	}
+	return search.FacetResults{"types": fr}
+}
+
+func TestFacetSnapshotsMerge(t *testing.T) {
+	facetsReq := bleve.FacetsRequest{"types": bleve.NewFacetRequest("type", 1)}
+
+	var snapshots []search.FacetResults
+	ctx := WithFacetSnapshots(context.Background(),
+		func(facets search.FacetResults) {
+			snapshots = append(snapshots, facets)
+		})
+	fs := facetSnapshotsFromContext(ctx)
+
+	// an empty pindex has no terms yet
+	err := fs.addFacets(search.FacetResults{
+		"types": &search.FacetResult{Field: "type"},
+	}, facetsReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.addFacets(typeFacets(map[string]int{"a": 2, "b": 1}), facetsReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.addFacets(typeFacets(map[string]int{"b": 3}), facetsReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 3 {
+		t.Fatalf("expected a snapshot per pindex, got: %d", len(snapshots))
+	}
+
+	last := snapshots[2]["types"]
+	if last == nil || last.Total != 6 || len(last.Terms) != 1 ||
+		last.Terms[0].Term != "b" || last.Terms[0].Count != 4 ||
+		last.Other != 2 {
+		t.Errorf("expected the merged and trimmed facets, got: %+v", last)
+	}
+
+	// the snapshots are copies, trimmed apart from the merged facets
+	if len(fs.merged["types"].Terms) != 2 {
+		t.Errorf("expected all the merged terms to be kept, got: %+v",
+			fs.merged["types"].Terms)
+	}
+}
+
+func TestFacetRelayClosed(t *testing.T) {
+	var relayed int
+	_, fs := withFacetRelay(context.Background(), func(facets []byte) error {
+		relayed++
+		return nil
+	})
+
+	facets := typeFacets(map[string]int{"a": 1})
+	fs.addFacets(facets, nil)
+	fs.close()
+	fs.addFacets(facets, nil)
+
+	if relayed != 1 {
+		t.Errorf("expected no facets relayed once closed, got: %d", relayed)
+	}
+}
+
+// requestCapturingClient is a contentsStreamClient that captures the
+// request of the last search.
+type requestCapturingClient struct {
+	contentsStreamClient
+	req *pb.SearchRequest
+}
+
+func (c *requestCapturingClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	c.req = in
+	return c.contentsStreamClient.Search(ctx, in, opts...)
+}
+
+func TestGrpcClientFacetSnapshots(t *testing.T) {
+	facetsMsg := func(counts map[string]int) *pb.StreamSearchResults {
+		b, err := MarshalJSON(typeFacets(counts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.StreamSearchResults{
+			Contents: &pb.StreamSearchResults_Facets{Facets: b},
+		}
+	}
+
+	cli := &requestCapturingClient{
+		contentsStreamClient: contentsStreamClient{
+			msgs: []*pb.StreamSearchResults{
+				facetsMsg(map[string]int{"a": 1}),
+				facetsMsg(map[string]int{"a": 2}),
+				{Contents: &pb.StreamSearchResults_SearchResult{
+					SearchResult: []byte(`{"total_hits":3,"facets":{"types":` +
+						`{"field":"type","total":3,"missing":0,"other":0,` +
+						`"terms":[{"term":"a","count":3}]}}}`),
+				}},
+			},
+		},
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0", "idx_pindex_1"},
+		GrpcCli:     cli,
+	}
+
+	var interim []int
+	ctx := WithFacetSnapshots(context.Background(),
+		func(facets search.FacetResults) {
+			interim = append(interim, facets["types"].Total)
+		})
+
+	searchRequest := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	searchRequest.AddFacet("types", bleve.NewFacetRequest("type", 10))
+
+	res, err := g.Query(ctx, &scatterRequest{searchRequest: searchRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cli.req.StreamFacets {
+		t.Errorf("expected the interim facets to be asked for")
+	}
+	if len(interim) != 2 || interim[0] != 1 || interim[1] != 3 {
+		t.Errorf("expected the merged interim facets, got: %v", interim)
+	}
+	if res.Facets["types"] == nil || res.Facets["types"].Total != 3 {
+		t.Errorf("expected the facets of the final result, got: %+v",
+			res.Facets)
+	}
+
+	// without the opt-in, no interim facets are asked for
+	_, err = g.Query(context.Background(),
+		&scatterRequest{searchRequest: searchRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli.req.StreamFacets {
+		t.Errorf("expected no interim facets to be asked for")
+	}
+}
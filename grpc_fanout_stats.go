@@ -0,0 +1,168 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/cbgt"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// The fan-out stats track the remote pindexes that the scatter-gather
+// queries considered in addGrpcClients, which of them were skipped and
+// why, and the gRPC clients that the rest were grouped into.
+var totGrpcFanoutQueries uint64
+var totGrpcFanoutPIndexes uint64
+var totGrpcFanoutSkippedFiltered uint64
+var totGrpcFanoutSkippedNoPort uint64
+var totGrpcFanoutSkippedErrored uint64
+var totGrpcFanoutSkippedUnreachable uint64
+var totGrpcFanoutHttpFallbacks uint64
+var totGrpcFanoutClients uint64
+
+// The fan-out histograms track the remote pindexes considered, the
+// gRPC clients and the distinct nodes of the clients, per query.
+var grpcFanoutPIndexesHistogram = metrics.NewHistogram(
+	metrics.NewExpDecaySample(1028, 0.015))
+var grpcFanoutClientsHistogram = metrics.NewHistogram(
+	metrics.NewExpDecaySample(1028, 0.015))
+var grpcFanoutNodesHistogram = metrics.NewHistogram(
+	metrics.NewExpDecaySample(1028, 0.015))
+
+var grpcFanoutSkippedNodesMutex sync.Mutex
+
+// grpcFanoutSkippedNodes are the numbers of the remote pindexes skipped
+// per node, as the node had no port, its client errored or it didn't
+// answer the pre-flight ping, keyed by the hostPort of the node.
+var grpcFanoutSkippedNodes = map[string]uint64{}
+
+// grpcFanout is the fan-out of a scatter-gather query.
+type grpcFanout struct {
+	pindexes     int // The remote pindexes considered.
+	filtered     int // Not in the onlyPIndexes of the query.
+	noPort       int
+	errored      int
+	unreachable  int // Pruned by the pre-flight ping.
+	httpFallback int // Reached over http, as there's no gRPC port.
+
+	// The hostPorts of the nodes of the skipped pindexes.
+	skippedNodes []string
+
+	// The hostPorts of the nodes of the clients, keyed by the gRPC
+	// hostPorts of the clients.
+	clientNodes map[string]string
+}
+
+// skipNode accounts a remote pindex skipped due to its node.
+func (f *grpcFanout) skipNode(counter *int, nodeDef *cbgt.NodeDef) {
+	*counter++
+	f.skippedNodes = append(f.skippedNodes, nodeDef.HostPort)
+}
+
+// addClient accounts the node of a client, by its gRPC hostPort.
+func (f *grpcFanout) addClient(hostPort string, nodeDef *cbgt.NodeDef) {
+	if f.clientNodes == nil {
+		f.clientNodes = map[string]string{}
+	}
+	f.clientNodes[hostPort] = nodeDef.HostPort
+}
+
+// skipUnreachable accounts the pindexes of the clients that weren't
+// kept as reachable by the pre-flight ping.
+func (f *grpcFanout) skipUnreachable(clients, reachable []*GrpcClient) {
+	kept := make(map[*GrpcClient]bool, len(reachable))
+	for _, c := range reachable {
+		kept[c] = true
+	}
+
+	for _, c := range clients {
+		if kept[c] {
+			continue
+		}
+
+		hostPort, exists := f.clientNodes[c.HostPort]
+		if !exists {
+			hostPort = c.HostPort
+		}
+		for range c.PIndexNames {
+			f.unreachable++
+			f.skippedNodes = append(f.skippedNodes, hostPort)
+		}
+	}
+}
+
+// record adds the fan-out of the query, with its resulting clients, to
+// the fan-out stats.
+func (f *grpcFanout) record(clients []*GrpcClient) {
+	atomic.AddUint64(&totGrpcFanoutQueries, 1)
+	atomic.AddUint64(&totGrpcFanoutPIndexes, uint64(f.pindexes))
+	atomic.AddUint64(&totGrpcFanoutSkippedFiltered, uint64(f.filtered))
+	atomic.AddUint64(&totGrpcFanoutSkippedNoPort, uint64(f.noPort))
+	atomic.AddUint64(&totGrpcFanoutSkippedErrored, uint64(f.errored))
+	atomic.AddUint64(&totGrpcFanoutSkippedUnreachable, uint64(f.unreachable))
+	atomic.AddUint64(&totGrpcFanoutHttpFallbacks, uint64(f.httpFallback))
+	atomic.AddUint64(&totGrpcFanoutClients, uint64(len(clients)))
+
+	nodes := map[string]struct{}{}
+	for _, c := range clients {
+		nodes[c.HostPort] = struct{}{}
+	}
+
+	grpcFanoutPIndexesHistogram.Update(int64(f.pindexes))
+	grpcFanoutClientsHistogram.Update(int64(len(clients)))
+	grpcFanoutNodesHistogram.Update(int64(len(nodes)))
+
+	if len(f.skippedNodes) > 0 {
+		grpcFanoutSkippedNodesMutex.Lock()
+		for _, hostPort := range f.skippedNodes {
+			grpcFanoutSkippedNodes[hostPort]++
+		}
+		grpcFanoutSkippedNodesMutex.Unlock()
+	}
+}
+
+// pruneGrpcFanoutSkippedNodes forgets the skips of the nodes that are
+// no longer in the nodeDefs, so that the nodes that left the cluster
+// don't keep their stats forever.
+func pruneGrpcFanoutSkippedNodes(nodeDefs *cbgt.NodeDefs) {
+	if nodeDefs == nil {
+		return
+	}
+
+	hostPorts := make(map[string]bool, len(nodeDefs.NodeDefs))
+	for _, nodeDef := range nodeDefs.NodeDefs {
+		hostPorts[nodeDef.HostPort] = true
+	}
+
+	grpcFanoutSkippedNodesMutex.Lock()
+	for hostPort := range grpcFanoutSkippedNodes {
+		if !hostPorts[hostPort] {
+			delete(grpcFanoutSkippedNodes, hostPort)
+		}
+	}
+	grpcFanoutSkippedNodesMutex.Unlock()
+}
+
+// GrpcFanoutSkippedNodes returns the numbers of the remote pindexes
+// that the queries skipped per node, keyed by the hostPort of the node.
+func GrpcFanoutSkippedNodes() map[string]uint64 {
+	grpcFanoutSkippedNodesMutex.Lock()
+	defer grpcFanoutSkippedNodesMutex.Unlock()
+
+	rv := make(map[string]uint64, len(grpcFanoutSkippedNodes))
+	for hostPort, n := range grpcFanoutSkippedNodes {
+		rv[hostPort] = n
+	}
+	return rv
+}
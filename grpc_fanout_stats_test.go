@@ -0,0 +1,134 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestAddGrpcClientsFanoutStats(t *testing.T) {
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil)
+
+	noPortNode := &cbgt.NodeDef{UUID: "n1", HostPort: "fanout-test-host"}
+	remotePlanPIndexes := []*cbgt.RemotePlanPIndex{{
+		PlanPIndex: &cbgt.PlanPIndex{Name: "idx_pindex_0"},
+		NodeDef:    noPortNode,
+	}, {
+		PlanPIndex: &cbgt.PlanPIndex{Name: "idx_pindex_1"},
+		NodeDef:    noPortNode,
+	}, {
+		PlanPIndex: &cbgt.PlanPIndex{Name: "idx_pindex_2"},
+		NodeDef:    noPortNode,
+	}}
+
+	queries := atomic.LoadUint64(&totGrpcFanoutQueries)
+	pindexes := atomic.LoadUint64(&totGrpcFanoutPIndexes)
+	filtered := atomic.LoadUint64(&totGrpcFanoutSkippedFiltered)
+	noPort := atomic.LoadUint64(&totGrpcFanoutSkippedNoPort)
+	skipped := GrpcFanoutSkippedNodes()[noPortNode.HostPort]
+
+	_, err := addGrpcClients(mgr, "idx", "uuid", remotePlanPIndexes, nil,
+		map[string]bool{"idx_pindex_0": true, "idx_pindex_1": true},
+		nil, false)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	if atomic.LoadUint64(&totGrpcFanoutQueries) != queries+1 ||
+		atomic.LoadUint64(&totGrpcFanoutPIndexes) != pindexes+3 {
+		t.Errorf("expected the query and its pindexes to be counted")
+	}
+	if atomic.LoadUint64(&totGrpcFanoutSkippedFiltered) != filtered+1 {
+		t.Errorf("expected the filtered pindex to be counted")
+	}
+	if atomic.LoadUint64(&totGrpcFanoutSkippedNoPort) != noPort+2 {
+		t.Errorf("expected the pindexes without a port to be counted")
+	}
+	if n := GrpcFanoutSkippedNodes()[noPortNode.HostPort]; n != skipped+2 {
+		t.Errorf("expected the skips of the node to be counted, got: %d", n)
+	}
+}
+
+func TestGrpcFanoutRecord(t *testing.T) {
+	clients := atomic.LoadUint64(&totGrpcFanoutClients)
+	count := grpcFanoutNodesHistogram.Count()
+
+	f := &grpcFanout{pindexes: 4}
+	f.record([]*GrpcClient{
+		{HostPort: "a:1", PIndexNames: []string{"p0", "p1"}},
+		{HostPort: "a:1", PIndexNames: []string{"p2"}},
+		{HostPort: "b:1", PIndexNames: []string{"p3"}},
+	})
+
+	if atomic.LoadUint64(&totGrpcFanoutClients) != clients+3 {
+		t.Errorf("expected the clients to be counted")
+	}
+	if grpcFanoutNodesHistogram.Count() != count+1 ||
+		grpcFanoutNodesHistogram.Max() < 2 {
+		t.Errorf("expected the distinct nodes to be recorded")
+	}
+}
+
+func TestGrpcFanoutSkipUnreachable(t *testing.T) {
+	unreachable := atomic.LoadUint64(&totGrpcFanoutSkippedUnreachable)
+	skipped := GrpcFanoutSkippedNodes()["unreachable-test-host:8094"]
+
+	a := &GrpcClient{HostPort: "unreachable-test-host:9130",
+		PIndexNames: []string{"p0", "p1"}}
+	b := &GrpcClient{HostPort: "reachable-test-host:9130",
+		PIndexNames: []string{"p2"}}
+
+	f := &grpcFanout{pindexes: 3}
+	f.addClient(a.HostPort,
+		&cbgt.NodeDef{HostPort: "unreachable-test-host:8094"})
+	f.addClient(b.HostPort,
+		&cbgt.NodeDef{HostPort: "reachable-test-host:8094"})
+	f.skipUnreachable([]*GrpcClient{a, b}, []*GrpcClient{b})
+	f.record([]*GrpcClient{b})
+
+	if atomic.LoadUint64(&totGrpcFanoutSkippedUnreachable) != unreachable+2 {
+		t.Errorf("expected the pindexes of the pruned client to be counted")
+	}
+	n := GrpcFanoutSkippedNodes()["unreachable-test-host:8094"]
+	if n != skipped+2 {
+		t.Errorf("expected the skips of the node to be counted, got: %d", n)
+	}
+}
+
+func TestPruneGrpcFanoutSkippedNodes(t *testing.T) {
+	f := &grpcFanout{skippedNodes: []string{"kept-test-host:8094",
+		"gone-test-host:8094"}}
+	f.record(nil)
+
+	pruneGrpcFanoutSkippedNodes(nil)
+	if _, exists := GrpcFanoutSkippedNodes()["gone-test-host:8094"]; !exists {
+		t.Fatalf("expected no pruning without the nodeDefs")
+	}
+
+	pruneGrpcFanoutSkippedNodes(&cbgt.NodeDefs{
+		NodeDefs: map[string]*cbgt.NodeDef{
+			"n1": {UUID: "n1", HostPort: "kept-test-host:8094"},
+		},
+	})
+
+	skipped := GrpcFanoutSkippedNodes()
+	if _, exists := skipped["gone-test-host:8094"]; exists {
+		t.Errorf("expected the node that left to be pruned")
+	}
+	if skipped["kept-test-host:8094"] == 0 {
+		t.Errorf("expected the node in the nodeDefs to be kept")
+	}
+}
@@ -0,0 +1,334 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultGrpcStreamWindow is the default number of messages of hits
+// that the server of a flow controlled search may send ahead of the
+// client's consumption of them.
+var DefaultGrpcStreamWindow = 8
+
+// totGrpcStreamCreditWaits tracks the times the servers of the flow
+// controlled searches waited on the clients for credits, and the
+// totGrpcStreamFlowControlFallbacks tracks the flow controlled searches
+// that fell back to the plain searches, as the server was of an older
+// version.
+var totGrpcStreamCreditWaits uint64
+var totGrpcStreamFlowControlFallbacks uint64
+
+// errFlowControlFallback is returned by the sends of the client of a
+// flow controlled search that fell back to the plain search.
+var errFlowControlFallback = errors.New("grpc_client: flow controlled" +
+	" search fell back to the plain search")
+
+// grpcStreamWindow returns the window of the flow controlled searches,
+// or 0 when they're not opted into with the "grpcStreamFlowControl"
+// manager option, where the "grpcStreamWindow" option overrides the
+// DefaultGrpcStreamWindow.
+func grpcStreamWindow(mgr *cbgt.Manager) int {
+	if mgr == nil {
+		return 0
+	}
+
+	options := mgr.Options()
+	if enabled, _ := strconv.ParseBool(options["grpcStreamFlowControl"]); !enabled {
+		return 0
+	}
+
+	if v := options["grpcStreamWindow"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Warnf("grpc_client: invalid grpcStreamWindow: %q, err: %v",
+				v, err)
+		} else {
+			return n
+		}
+	}
+
+	return DefaultGrpcStreamWindow
+}
+
+// SearchWithFlowControl is the Search where the streamed hits are paced
+// by the client, which grants the credits for the messages of hits as
+// it consumes them, so that a slow consumer can't have the hits pile up
+// in the buffers of the server.
+func (s *SearchService) SearchWithFlowControl(
+	stream pb.SearchService_SearchWithFlowControlServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Request == nil {
+		return status.Error(codes.FailedPrecondition,
+			"grpc_server: SearchWithFlowControl empty search request")
+	}
+
+	credits := newStreamCredits(first.Credits)
+
+	abandonment := newPIndexAbandonment()
+
+	// the later messages of the client grant credits or abandon
+	// pindexes, where the client half-closing its side ends the flow
+	// control
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				credits.close()
+				return
+			}
+			if msg.Credits > 0 {
+				credits.grant(msg.Credits)
+			}
+			if len(msg.AbandonPIndexes) > 0 {
+				abandonment.abandon(msg.AbandonPIndexes)
+			}
+		}
+	}()
+
+	return s.Search(first.Request, &flowControlledSearchServer{
+		SearchService_SearchWithFlowControlServer: stream,
+		credits:     credits,
+		abandonment: abandonment,
+	})
+}
+
+// flowControlledSearchServer is the pb.SearchService_SearchServer of a
+// flow controlled search, whose messages of hits each take a credit.
+type flowControlledSearchServer struct {
+	pb.SearchService_SearchWithFlowControlServer
+	credits     *streamCredits
+	abandonment *pindexAbandonment
+}
+
+func (f *flowControlledSearchServer) Send(m *pb.StreamSearchResults) error {
+	if _, ok := m.Contents.(*pb.StreamSearchResults_Hits); ok {
+		err := f.credits.acquire(f.Context())
+		if err != nil {
+			return err
+		}
+	}
+	return f.SearchService_SearchWithFlowControlServer.Send(m)
+}
+
+// streamCredits are the credits granted by the client of a stream.
+type streamCredits struct {
+	m       sync.Mutex
+	credits uint64
+	closed  bool
+
+	// grantCh is signaled, without blocking, on the new credits.
+	grantCh chan struct{}
+}
+
+func newStreamCredits(window uint32) *streamCredits {
+	if window == 0 {
+		window = uint32(DefaultGrpcStreamWindow)
+	}
+	return &streamCredits{
+		credits: uint64(window),
+		grantCh: make(chan struct{}, 1),
+	}
+}
+
+func (c *streamCredits) grant(n uint32) {
+	c.m.Lock()
+	c.credits += uint64(n)
+	c.m.Unlock()
+
+	c.signal()
+}
+
+// close lifts the flow control, as no more credits are coming.
+func (c *streamCredits) close() {
+	c.m.Lock()
+	c.closed = true
+	c.m.Unlock()
+
+	c.signal()
+}
+
+func (c *streamCredits) signal() {
+	select {
+	case c.grantCh <- struct{}{}:
+	default:
+	}
+}
+
+// acquire takes a credit, waiting for one to be granted while there
+// are none, or until the ctx is done.
+func (c *streamCredits) acquire(ctx context.Context) error {
+	waited := false
+	for {
+		c.m.Lock()
+		if c.closed {
+			c.m.Unlock()
+			return nil
+		}
+		if c.credits > 0 {
+			c.credits--
+			c.m.Unlock()
+			return nil
+		}
+		c.m.Unlock()
+
+		if !waited {
+			waited = true
+			atomic.AddUint64(&totGrpcStreamCreditWaits, 1)
+		}
+
+		select {
+		case <-c.grantCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// searchResultsStream is the client side of the streamed results of a
+// search.
+type searchResultsStream interface {
+	Recv() (*pb.StreamSearchResults, error)
+	Header() (metadata.MD, error)
+	Trailer() metadata.MD
+}
+
+// flowControlledSearchClient is the client side of a flow controlled
+// search, which falls back to the plain search when the server doesn't
+// serve the flow controlled ones.
+type flowControlledSearchClient struct {
+	stream   pb.SearchService_SearchWithFlowControlClient
+	received bool
+
+	openFallback func() (pb.SearchService_SearchClient, error)
+
+	// sendM serializes the sends of the credits and of the abandoned
+	// pindexes, and guards the setting of the fallback.
+	sendM    sync.Mutex
+	fallback pb.SearchService_SearchClient
+}
+
+// openSearchStream opens the stream of the results of the search, with
+// the hits paced by the client when the flow control is opted into, in
+// which case the client must grant a credit per consumed message of
+// hits with grantSearchCredit.
+func (g *GrpcClient) openSearchStream(ctx context.Context,
+	pbReq *pb.SearchRequest) (searchResultsStream, error) {
+	window := grpcStreamWindow(g.Mgr)
+	if !pbReq.Stream || window <= 0 {
+		return g.GrpcCli.Search(ctx, pbReq)
+	}
+
+	stream, err := g.GrpcCli.SearchWithFlowControl(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&pb.SearchFlowRequest{
+		Request: pbReq,
+		Credits: uint32(window),
+	})
+	// the status of a failed send surfaces with the Recv
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return &flowControlledSearchClient{
+		stream: stream,
+		openFallback: func() (pb.SearchService_SearchClient, error) {
+			return g.GrpcCli.Search(ctx, pbReq)
+		},
+	}, nil
+}
+
+func (f *flowControlledSearchClient) Recv() (*pb.StreamSearchResults, error) {
+	if f.fallback != nil {
+		return f.fallback.Recv()
+	}
+
+	rv, err := f.stream.Recv()
+	// servers of older versions don't serve the flow controlled searches
+	if !f.received && status.Code(err) == codes.Unimplemented {
+		atomic.AddUint64(&totGrpcStreamFlowControlFallbacks, 1)
+
+		fallback, err := f.openFallback()
+		if err != nil {
+			return nil, err
+		}
+
+		f.sendM.Lock()
+		f.fallback = fallback
+		f.sendM.Unlock()
+
+		return fallback.Recv()
+	}
+	f.received = true
+
+	return rv, err
+}
+
+func (f *flowControlledSearchClient) Header() (metadata.MD, error) {
+	if f.fallback != nil {
+		return f.fallback.Header()
+	}
+	return f.stream.Header()
+}
+
+func (f *flowControlledSearchClient) Trailer() metadata.MD {
+	if f.fallback != nil {
+		return f.fallback.Trailer()
+	}
+	return f.stream.Trailer()
+}
+
+// grantSearchCredit grants the server of a flow controlled search the
+// credit for another message of hits, once one was consumed.
+func grantSearchCredit(res searchResultsStream) error {
+	f, ok := res.(*flowControlledSearchClient)
+	if !ok || f.fallback != nil {
+		return nil
+	}
+
+	err := f.send(&pb.SearchFlowRequest{Credits: 1})
+	// the server may be done already, whose status comes with the Recv
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// send sends a message to the server, unless the search fell back to
+// the plain search.
+func (f *flowControlledSearchClient) send(m *pb.SearchFlowRequest) error {
+	f.sendM.Lock()
+	defer f.sendM.Unlock()
+
+	if f.fallback != nil {
+		return errFlowControlFallback
+	}
+	return f.stream.Send(m)
+}
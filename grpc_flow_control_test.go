@@ -0,0 +1,202 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamCredits(t *testing.T) {
+	c := newStreamCredits(2)
+
+	for i := 0; i < 2; i++ {
+		if err := c.acquire(context.Background()); err != nil {
+			t.Fatalf("expected a credit of the window, err: %v", err)
+		}
+	}
+
+	// out of credits, the acquire waits for the ctx
+	prevWaits := atomic.LoadUint64(&totGrpcStreamCreditWaits)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	err := c.acquire(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+	if atomic.LoadUint64(&totGrpcStreamCreditWaits) != prevWaits+1 {
+		t.Errorf("expected the wait to be counted")
+	}
+
+	// a grant releases the waiting acquire
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- c.acquire(context.Background())
+	}()
+	time.Sleep(5 * time.Millisecond)
+	c.grant(1)
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Errorf("expected the granted credit, err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the grant to release the acquire")
+	}
+
+	// closing lifts the flow control
+	c.close()
+	for i := 0; i < 3; i++ {
+		if err := c.acquire(context.Background()); err != nil {
+			t.Errorf("expected no flow control once closed, err: %v", err)
+		}
+	}
+}
+
+// capturingFlowServer is a pb.SearchService_SearchWithFlowControlServer
+// that captures the messages sent on it.
+type capturingFlowServer struct {
+	pb.SearchService_SearchWithFlowControlServer
+	ctx  context.Context
+	sent []*pb.StreamSearchResults
+}
+
+func (s *capturingFlowServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *capturingFlowServer) Send(m *pb.StreamSearchResults) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func TestFlowControlledSearchServerSend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &capturingFlowServer{ctx: ctx}
+	f := &flowControlledSearchServer{
+		SearchService_SearchWithFlowControlServer: stream,
+		credits: newStreamCredits(1),
+	}
+
+	hits := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_Hits{
+			Hits: &pb.StreamSearchResults_Batch{Bytes: []byte(`[]`)},
+		},
+	}
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"total_hits":1}`),
+		},
+	}
+
+	if err := f.Send(hits); err != nil {
+		t.Fatalf("expected the hits within the window, err: %v", err)
+	}
+
+	// the search result takes no credit
+	if err := f.Send(result); err != nil {
+		t.Fatalf("expected the result without credits, err: %v", err)
+	}
+
+	// more hits wait on the client's credits
+	cancel()
+	if err := f.Send(hits); err != context.Canceled {
+		t.Errorf("expected the hits to wait for a credit, got: %v", err)
+	}
+
+	if len(stream.sent) != 2 {
+		t.Errorf("expected 2 sent msgs, got: %d", len(stream.sent))
+	}
+}
+
+// unimplementedFlowClient is a pb.SearchServiceClient of a server of an
+// older version, which doesn't serve the flow controlled searches.
+type unimplementedFlowClient struct {
+	contentsStreamClient
+	credits []uint32
+}
+
+func (c *unimplementedFlowClient) SearchWithFlowControl(ctx context.Context,
+	opts ...grpc.CallOption) (pb.SearchService_SearchWithFlowControlClient,
+	error) {
+	return &unimplementedFlowStream{cli: c}, nil
+}
+
+type unimplementedFlowStream struct {
+	grpc.ClientStream
+	cli *unimplementedFlowClient
+}
+
+func (s *unimplementedFlowStream) Send(m *pb.SearchFlowRequest) error {
+	s.cli.credits = append(s.cli.credits, m.Credits)
+	return nil
+}
+
+func (s *unimplementedFlowStream) Recv() (*pb.StreamSearchResults, error) {
+	return nil, status.Error(codes.Unimplemented, "unknown method")
+}
+
+func TestFlowControlledSearchClientFallback(t *testing.T) {
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"total_hits":1}`),
+		},
+	}
+
+	cli := &unimplementedFlowClient{
+		contentsStreamClient: contentsStreamClient{
+			msgs: []*pb.StreamSearchResults{result},
+		},
+	}
+
+	stream, err := cli.SearchWithFlowControl(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&pb.SearchFlowRequest{Credits: 4})
+
+	res := &flowControlledSearchClient{
+		stream: stream,
+		openFallback: func() (pb.SearchService_SearchClient, error) {
+			return cli.Search(context.Background(), &pb.SearchRequest{})
+		},
+	}
+
+	prev := atomic.LoadUint64(&totGrpcStreamFlowControlFallbacks)
+
+	got, err := res.Recv()
+	if err != nil || got != result {
+		t.Fatalf("expected the result of the plain search, got: %v, err: %v",
+			got, err)
+	}
+	if atomic.LoadUint64(&totGrpcStreamFlowControlFallbacks) != prev+1 {
+		t.Errorf("expected the fallback to be counted")
+	}
+
+	// the fallen back search takes no more credits
+	if err := grantSearchCredit(res); err != nil {
+		t.Errorf("expected no err, got: %v", err)
+	}
+	if len(cli.credits) != 1 {
+		t.Errorf("expected only the initial credits, got: %v", cli.credits)
+	}
+}
@@ -0,0 +1,220 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IndexStats returns the stats maps of the local pindexes of the
+// request, each along with its doc count and its pindex UUID.
+func (s *SearchService) IndexStats(ctx context.Context,
+	req *pb.IndexStatsRequest) (*pb.IndexStatsResult, error) {
+	err := verifyRPCAuth(ctx, req.IndexName, req)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"grpc_server: IndexStats err: %v", err)
+	}
+
+	rv := &pb.IndexStatsResult{
+		PIndexStats: make(map[string][]byte, len(req.PIndexNames)),
+	}
+
+	for _, pindexName := range req.PIndexNames {
+		b, err := s.pindexStats(pindexName, req.IndexUUID)
+		if err != nil {
+			if rv.Errors == nil {
+				rv.Errors = map[string]string{}
+			}
+			rv.Errors[pindexName] = err.Error()
+			continue
+		}
+		rv.PIndexStats[pindexName] = b
+	}
+
+	return rv, nil
+}
+
+// pindexStats returns the JSON encoded stats map of a local pindex.
+func (s *SearchService) pindexStats(pindexName, indexUUID string) (
+	[]byte, error) {
+	bindex, err := s.localBleveIndex(pindexName, indexUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := bindex.DocCount()
+	if err != nil {
+		return nil, fmt.Errorf("grpc_server: IndexStats, DocCount,"+
+			" pindexName: %s, err: %v", pindexName, err)
+	}
+
+	m := map[string]interface{}{}
+	for k, v := range bindex.StatsMap() {
+		m[k] = v
+	}
+	m["doc_count"] = count
+	if pindex := s.mgr.GetPIndex(pindexName); pindex != nil {
+		m["pindex_uuid"] = pindex.UUID
+	}
+
+	return MarshalJSON(m)
+}
+
+// GrpcIndexStatsTimeout bounds the IndexStats request of the StatsMap
+// of a GrpcClient.
+var GrpcIndexStatsTimeout = 10 * time.Second
+
+// StatsMap returns the stats of the pindexes of the client, as merged
+// by mergePIndexStats, or nil when the node fails to report them, such
+// as when it's of an older version.
+func (g *GrpcClient) StatsMap() map[string]interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		GrpcIndexStatsTimeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	res, err := g.GrpcCli.IndexStats(ctx, &pb.IndexStatsRequest{
+		IndexName:   g.IndexName,
+		IndexUUID:   g.IndexUUID,
+		PIndexNames: g.PIndexNames,
+	})
+	if err != nil {
+		log.Warnf("grpc_client: IndexStats, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"code", status.Code(err), "err", err))
+		return nil
+	}
+
+	pindexStats := make(map[string]map[string]interface{},
+		len(res.GetPIndexStats()))
+	for pindexName, b := range res.GetPIndexStats() {
+		var m map[string]interface{}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&m); err != nil {
+			log.Warnf("grpc_client: IndexStats, %s",
+				logFields("host", g.HostPort, "pindex", pindexName,
+					"err", err))
+			continue
+		}
+		pindexStats[pindexName] = m
+	}
+
+	rv := mergePIndexStats(pindexStats)
+
+	for pindexName, errStr := range res.GetErrors() {
+		rv["pindexes"].(map[string]interface{})[pindexName] =
+			map[string]interface{}{"error": errStr}
+	}
+
+	return rv
+}
+
+// mergePIndexStats merges the stats maps of the pindexes, where the
+// numeric stats that add up, see summableStat, are summed, and the
+// other stats, like the UUIDs, the epochs and the ratios, are kept per
+// pindex under "pindexes", along with the doc count of each pindex.
+func mergePIndexStats(
+	pindexStats map[string]map[string]interface{}) map[string]interface{} {
+	rv := map[string]interface{}{}
+	pindexes := map[string]interface{}{}
+
+	for pindexName, m := range pindexStats {
+		own := map[string]interface{}{}
+		mergeStats(rv, own, m)
+		if count, exists := m["doc_count"]; exists {
+			own["doc_count"] = count
+		}
+		pindexes[pindexName] = own
+	}
+
+	rv["pindexes"] = pindexes
+
+	return rv
+}
+
+// mergeStats sums the summable stats of the src into the sum, and keeps
+// the others in the own stats of the pindex, level by level.
+func mergeStats(sum, own, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			subSum, _ := sum[k].(map[string]interface{})
+			if subSum == nil {
+				subSum = map[string]interface{}{}
+				sum[k] = subSum
+			}
+			subOwn := map[string]interface{}{}
+			mergeStats(subSum, subOwn, sub)
+			if len(subOwn) > 0 {
+				own[k] = subOwn
+			}
+			continue
+		}
+
+		n, ok := v.(json.Number)
+		if !ok || !summableStat(k) {
+			own[k] = v
+			continue
+		}
+
+		sum[k] = addStatNumbers(sum[k], n)
+	}
+}
+
+// summableStat returns whether the numeric stat adds up across the
+// pindexes, which is only known of the doc counts, the byte sizes and
+// the Tot counters, as the other stats, like the epochs, the ratios
+// and the averages, don't add up.
+func summableStat(k string) bool {
+	return k == "doc_count" ||
+		strings.HasPrefix(k, "Tot") ||
+		strings.HasPrefix(k, "tot_") ||
+		strings.Contains(strings.ToLower(k), "bytes")
+}
+
+// addStatNumbers returns the sum of the stats, as an int64 while both
+// are integers, and as a float64 otherwise.
+func addStatNumbers(prev interface{}, n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		switch p := prev.(type) {
+		case nil:
+			return i
+		case int64:
+			return p + i
+		case float64:
+			return p + float64(i)
+		}
+	}
+
+	f, _ := n.Float64()
+	switch p := prev.(type) {
+	case int64:
+		return float64(p) + f
+	case float64:
+		return p + f
+	}
+	return f
+}
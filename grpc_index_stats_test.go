@@ -0,0 +1,104 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"testing"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// indexStatsClient is a pb.SearchServiceClient whose IndexStats returns
+// the configured result or error.
+type indexStatsClient struct {
+	pb.SearchServiceClient
+	res *pb.IndexStatsResult
+	err error
+	req *pb.IndexStatsRequest
+
+	hasDeadline bool
+}
+
+func (c *indexStatsClient) IndexStats(ctx context.Context,
+	in *pb.IndexStatsRequest, opts ...grpc.CallOption) (
+	*pb.IndexStatsResult, error) {
+	c.req = in
+	_, c.hasDeadline = ctx.Deadline()
+	return c.res, c.err
+}
+
+func TestGrpcClientStatsMap(t *testing.T) {
+	cli := &indexStatsClient{res: &pb.IndexStatsResult{
+		PIndexStats: map[string][]byte{
+			"p0": []byte(`{"doc_count":3,"pindex_uuid":"u0",` +
+				`"index":{"TotUpdates":10,"CurRootEpoch":7,"ratio":0.5,` +
+				`"num_bytes_used_disk":100}}`),
+			"p1": []byte(`{"doc_count":4,"pindex_uuid":"u1",` +
+				`"index":{"TotUpdates":20,"CurRootEpoch":9,"ratio":1,` +
+				`"num_bytes_used_disk":200}}`),
+		},
+		Errors: map[string]string{"p2": "no pindex"},
+	}}
+	g := &GrpcClient{
+		IndexName:   "idx",
+		IndexUUID:   "uuid",
+		PIndexNames: []string{"p0", "p1", "p2"},
+		GrpcCli:     cli,
+	}
+
+	m := g.StatsMap()
+	if cli.req == nil || len(cli.req.PIndexNames) != 3 ||
+		cli.req.IndexUUID != "uuid" {
+		t.Fatalf("unexpected request: %+v", cli.req)
+	}
+	if !cli.hasDeadline {
+		t.Errorf("expected the request to be bounded by a timeout")
+	}
+
+	if m["doc_count"] != int64(7) {
+		t.Errorf("expected the summed doc count, got: %#v", m["doc_count"])
+	}
+	index := m["index"].(map[string]interface{})
+	if index["TotUpdates"] != int64(30) ||
+		index["num_bytes_used_disk"] != int64(300) {
+		t.Errorf("expected the summed index stats, got: %#v", index)
+	}
+	for _, k := range []string{"CurRootEpoch", "ratio"} {
+		if _, exists := index[k]; exists {
+			t.Errorf("expected the %s not to be summed, got: %#v", k, index)
+		}
+	}
+
+	pindexes := m["pindexes"].(map[string]interface{})
+	p0 := pindexes["p0"].(map[string]interface{})
+	if p0["pindex_uuid"] != "u0" || p0["doc_count"] != json.Number("3") ||
+		p0["index"].(map[string]interface{})["CurRootEpoch"] !=
+			json.Number("7") ||
+		p0["index"].(map[string]interface{})["ratio"] != json.Number("0.5") {
+		t.Errorf("unexpected stats of p0: %#v", p0)
+	}
+	p2 := pindexes["p2"].(map[string]interface{})
+	if p2["error"] != "no pindex" {
+		t.Errorf("expected the error of p2, got: %#v", p2)
+	}
+
+	// servers of older versions don't implement the stats
+	cli.err = status.Error(codes.Unimplemented, "unknown method IndexStats")
+	if m := g.StatsMap(); m != nil {
+		t.Errorf("expected no stats, got: %#v", m)
+	}
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcLatencyErrorPenalty is the factor by which the latency of a node
+// is inflated, for the GrpcLatencyErrorPenaltyWindow after one of its
+// searches failed, so that the latency based replica selection passes
+// over the nodes that recently failed, without ruling them out.
+var GrpcLatencyErrorPenalty = int64(4)
+
+// GrpcLatencyErrorPenaltyWindow is how long a failed search of a node
+// inflates its latency.
+var GrpcLatencyErrorPenaltyWindow = 30 * time.Second
+
+// recordSearch smooths the latency of a successful search of the node
+// into the pool's search latency, weighting the latest search by 1/8th,
+// as with the pings, where a failed search penalizes the node instead.
+func (pool *rpcConnPool) recordSearch(d time.Duration, failed bool) {
+	if failed {
+		atomic.StoreInt64(&pool.penalizedAt, time.Now().UnixNano())
+		return
+	}
+
+	if d <= 0 {
+		d = 1
+	}
+	for {
+		prev := atomic.LoadInt64(&pool.searchLatencyNS)
+		next := int64(d)
+		if prev > 0 {
+			next = prev + (int64(d)-prev)/8
+		}
+		if atomic.CompareAndSwapInt64(&pool.searchLatencyNS, prev, next) {
+			return
+		}
+	}
+}
+
+// latency returns the smoothed search latency of the node, or else its
+// smoothed ping latency while it served no searches, inflated while the
+// node is penalized, or 0 when it's unknown.
+func (pool *rpcConnPool) latency(now time.Time) time.Duration {
+	rv := atomic.LoadInt64(&pool.searchLatencyNS)
+	if rv <= 0 {
+		rv = atomic.LoadInt64(&pool.pingLatencyNS)
+	}
+
+	penalizedAt := atomic.LoadInt64(&pool.penalizedAt)
+	if penalizedAt > 0 && GrpcLatencyErrorPenalty > 1 &&
+		now.Sub(time.Unix(0, penalizedAt)) < GrpcLatencyErrorPenaltyWindow {
+		rv *= GrpcLatencyErrorPenalty
+	}
+
+	return time.Duration(rv)
+}
+
+// searchFailedOnNode returns whether the search err tells of the
+// health of the node, as with the transport or server failures, unlike
+// a rejected query or a bad request, which the node served fine.
+func searchFailedOnNode(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal,
+		codes.Unknown, codes.DataLoss, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// recordSearchLatency records the outcome of a search in the pool of
+// the client's node, where d is the time to the first response of the
+// node, and only the node health failures, while the ctx of the query
+// wasn't done, count against the node.
+func (g *GrpcClient) recordSearchLatency(ctx context.Context,
+	d time.Duration, err error) {
+	if len(g.connRefs) == 0 {
+		return
+	}
+	if err != nil && (ctx.Err() != nil || !searchFailedOnNode(err)) {
+		return
+	}
+	g.connRefs[0].pool.recordSearch(d, err != nil)
+}
+
+// rpcNodeLatency returns the lowest latency of a node across its pools,
+// as of their searches and pings, or 0 when it's unknown.
+func rpcNodeLatency(nodeUUID string) time.Duration {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	var rv time.Duration
+	now := time.Now()
+	for key, pool := range rpcConnPools {
+		if strings.HasPrefix(key, nodeUUID+"-") {
+			if d := pool.latency(now); d > 0 && (rv == 0 || d < rv) {
+				rv = d
+			}
+		}
+	}
+	return rv
+}
+
+// GrpcNodeLatencies returns the latency of each of the cached pools, as
+// used by the latency based replica selection, keyed by the nodeUUID
+// and hostPort of the nodes, where the nodes of unknown latency are
+// left out.
+func GrpcNodeLatencies() map[string]time.Duration {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	rv := make(map[string]time.Duration, len(rpcConnPools))
+	now := time.Now()
+	for key, pool := range rpcConnPools {
+		if d := pool.latency(now); d > 0 {
+			rv[key] = d
+		}
+	}
+	return rv
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecordSearchLatency(t *testing.T) {
+	pool := &rpcConnPool{}
+	now := time.Now()
+
+	if d := pool.latency(now); d != 0 {
+		t.Errorf("expected an unknown latency, got: %v", d)
+	}
+
+	// the pings stand in until the node served searches
+	pool.recordPing(time.Millisecond)
+	if d := pool.latency(now); d != time.Millisecond {
+		t.Errorf("expected the ping latency, got: %v", d)
+	}
+
+	pool.recordSearch(8*time.Millisecond, false)
+	pool.recordSearch(16*time.Millisecond, false)
+	if d := pool.latency(now); d != 9*time.Millisecond {
+		t.Errorf("expected the smoothed search latency, got: %v", d)
+	}
+
+	// a failed search inflates the latency for a while
+	pool.recordSearch(time.Second, true)
+	if got := atomic.LoadInt64(&pool.searchLatencyNS); got !=
+		int64(9*time.Millisecond) {
+		t.Errorf("expected the failure not to be smoothed in, got: %v",
+			time.Duration(got))
+	}
+	if d := pool.latency(time.Now()); d !=
+		time.Duration(GrpcLatencyErrorPenalty)*9*time.Millisecond {
+		t.Errorf("expected the penalized latency, got: %v", d)
+	}
+	if d := pool.latency(time.Now().Add(GrpcLatencyErrorPenaltyWindow)); d !=
+		9*time.Millisecond {
+		t.Errorf("expected the penalty to expire, got: %v", d)
+	}
+}
+
+func TestRecordSearchLatencyNodeFailures(t *testing.T) {
+	tests := []struct {
+		err       error
+		penalized bool
+	}{
+		{status.Error(codes.Unavailable, "down"), true},
+		{status.Error(codes.DeadlineExceeded, "slow"), true},
+		{status.Error(codes.Internal, "broken"), true},
+		{status.Error(codes.InvalidArgument, "bad query"), false},
+		{status.Error(codes.ResourceExhausted, "rejected"), false},
+		{status.Error(codes.PermissionDenied, "no access"), false},
+	}
+
+	for i, test := range tests {
+		pool := &rpcConnPool{searchLatencyNS: int64(time.Millisecond)}
+		g := &GrpcClient{connRefs: []*rpcConnRef{{pool: pool}}}
+
+		g.recordSearchLatency(context.Background(), time.Second, test.err)
+		if got := atomic.LoadInt64(&pool.penalizedAt) > 0; got != test.penalized {
+			t.Errorf("test: %d, err: %v, expected penalized: %t",
+				i, test.err, test.penalized)
+		}
+		if got := atomic.LoadInt64(&pool.searchLatencyNS); got !=
+			int64(time.Millisecond) {
+			t.Errorf("test: %d, expected the failure not to be smoothed in,"+
+				" got: %v", i, time.Duration(got))
+		}
+	}
+
+	// the failures as the query's own ctx is done don't count
+	pool := &rpcConnPool{}
+	g := &GrpcClient{connRefs: []*rpcConnRef{{pool: pool}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.recordSearchLatency(ctx, time.Second,
+		status.Error(codes.DeadlineExceeded, "cancelled"))
+	if atomic.LoadInt64(&pool.penalizedAt) != 0 {
+		t.Errorf("expected no penalty as the query's ctx is done")
+	}
+}
+
+func TestGrpcNodeLatencies(t *testing.T) {
+	fast := &rpcConnPool{searchLatencyNS: int64(time.Millisecond)}
+	slow := &rpcConnPool{searchLatencyNS: int64(time.Second)}
+	failed := &rpcConnPool{searchLatencyNS: int64(time.Millisecond),
+		penalizedAt: time.Now().UnixNano()}
+
+	rpcConnMutex.Lock()
+	rpcConnPools["latency-a-host:9130"] = fast
+	rpcConnPools["latency-a-host:9131"] = slow
+	rpcConnPools["latency-b-host:9130"] = failed
+	rpcConnPools["latency-c-host:9130"] = &rpcConnPool{}
+	rpcConnMutex.Unlock()
+	defer func() {
+		rpcConnMutex.Lock()
+		delete(rpcConnPools, "latency-a-host:9130")
+		delete(rpcConnPools, "latency-a-host:9131")
+		delete(rpcConnPools, "latency-b-host:9130")
+		delete(rpcConnPools, "latency-c-host:9130")
+		rpcConnMutex.Unlock()
+	}()
+
+	if d := rpcNodeLatency("latency-a"); d != time.Millisecond {
+		t.Errorf("expected the lowest latency of the node, got: %v", d)
+	}
+	if d := rpcNodeLatency("latency-b"); d <= time.Millisecond {
+		t.Errorf("expected the penalized latency, got: %v", d)
+	}
+
+	latencies := GrpcNodeLatencies()
+	if latencies["latency-a-host:9131"] != time.Second {
+		t.Errorf("expected the latency of each pool, got: %v", latencies)
+	}
+	if _, exists := latencies["latency-c-host:9130"]; exists {
+		t.Errorf("expected no latency of an unknown node, got: %v", latencies)
+	}
+}
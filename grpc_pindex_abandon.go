@@ -0,0 +1,220 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	log "github.com/couchbase/clog"
+)
+
+// A pindex of a search may be abandoned by the client once the results
+// of the other pindexes already satisfy it, such as a top-K whose hits
+// all score above a threshold, so that the node stops searching the
+// pindex and releases its index snapshot.
+//
+// The results of such a search are partial: the hits, the totals and
+// the facets of the abandoned pindexes are left out, and the abandoned
+// pindexes are reported among the errors of the results' status, and
+// with the "abandoned" reason of the per-pindex hit counts.  A pindex
+// that completed before it was abandoned still contributes its
+// results.  The searches that expect complete results, by the
+// consistency results of "complete", don't abandon any pindexes.
+//
+// The pindexes are abandoned over the stream of a flow controlled
+// search, as opted into with the "grpcStreamFlowControl" option.  A
+// node coordinating such a search forwards the abandoned pindexes that
+// are remote to it onto the searches of their nodes.
+
+// ErrPIndexAbandoned is the error of the search of a pindex that was
+// abandoned by the client.
+var ErrPIndexAbandoned = errors.New("pindex search abandoned by the client")
+
+// ErrPIndexAbandonUnavailable is returned when abandoning the pindexes
+// of a client that has no flow controlled search in flight.
+var ErrPIndexAbandonUnavailable = errors.New("grpc_client: no flow" +
+	" controlled search in flight to abandon the pindexes of")
+
+// totGrpcPIndexesAbandoned tracks the pindex searches that a server
+// stopped, as they were abandoned by the client.
+var totGrpcPIndexesAbandoned uint64
+
+type pindexAbandonmentKeyType string
+
+const pindexAbandonmentKey = pindexAbandonmentKeyType("pindexAbandonment")
+
+// pindexAbandonment tracks the pindexes of a search that the client
+// abandoned, along with the cancels of the ones being searched, and the
+// gRPC clients searching the remote ones.
+type pindexAbandonment struct {
+	m         sync.Mutex
+	abandoned map[string]bool
+	cancels   map[string]context.CancelFunc
+	clients   map[*GrpcClient]struct{}
+}
+
+func newPIndexAbandonment() *pindexAbandonment {
+	return &pindexAbandonment{
+		abandoned: map[string]bool{},
+		cancels:   map[string]context.CancelFunc{},
+		clients:   map[*GrpcClient]struct{}{},
+	}
+}
+
+// withPIndexAbandonment returns a ctx whose pindex searches stop once
+// their pindexes are abandoned.
+func withPIndexAbandonment(ctx context.Context,
+	a *pindexAbandonment) context.Context {
+	return context.WithValue(ctx, pindexAbandonmentKey, a)
+}
+
+func pindexAbandonmentFromContext(ctx context.Context) *pindexAbandonment {
+	a, _ := ctx.Value(pindexAbandonmentKey).(*pindexAbandonment)
+	return a
+}
+
+// abandon stops the searches of the pindexes, including the ones that
+// are yet to start, and forwards the remote ones to their nodes.
+func (a *pindexAbandonment) abandon(pindexNames []string) {
+	a.m.Lock()
+	for _, pindexName := range pindexNames {
+		a.abandoned[pindexName] = true
+		if cancel := a.cancels[pindexName]; cancel != nil {
+			cancel()
+		}
+	}
+	forwards := make(map[*GrpcClient][]string, len(a.clients))
+	for g := range a.clients {
+		forwards[g] = a.clientPIndexesLOCKED(g, pindexNames)
+	}
+	a.m.Unlock()
+
+	for g, names := range forwards {
+		forwardAbandonedPIndexes(g, names)
+	}
+}
+
+// trackClient has the pindexes of the client abandoned on its node for
+// as long as its search is in flight, until the returned func is
+// called, including the ones that were abandoned before.
+func (a *pindexAbandonment) trackClient(g *GrpcClient) func() {
+	a.m.Lock()
+	a.clients[g] = struct{}{}
+	var names []string
+	for pindexName := range a.abandoned {
+		names = append(names, pindexName)
+	}
+	names = a.clientPIndexesLOCKED(g, names)
+	a.m.Unlock()
+
+	forwardAbandonedPIndexes(g, names)
+
+	return func() {
+		a.m.Lock()
+		delete(a.clients, g)
+		a.m.Unlock()
+	}
+}
+
+// clientPIndexesLOCKED returns the pindexes among the names that the
+// client searches.
+func (a *pindexAbandonment) clientPIndexesLOCKED(g *GrpcClient,
+	pindexNames []string) (rv []string) {
+	for _, pindexName := range pindexNames {
+		for _, name := range g.PIndexNames {
+			if name == pindexName {
+				rv = append(rv, pindexName)
+				break
+			}
+		}
+	}
+	return rv
+}
+
+// forwardAbandonedPIndexes abandons the pindexes on the node of the
+// client, where a node that can't abandon them just completes them.
+func forwardAbandonedPIndexes(g *GrpcClient, pindexNames []string) {
+	err := g.AbandonPIndexes(pindexNames...)
+	if err != nil && err != ErrPIndexAbandonUnavailable {
+		log.Warnf("grpc_client: forwarding abandoned pindexes, %s",
+			logFields("host", g.HostPort, "index", g.IndexName,
+				"pindexes", pindexNames, "err", err))
+	}
+}
+
+// track returns the ctx of the search of a pindex, which is done once
+// the pindex is abandoned, along with the func to call once the search
+// of the pindex returns.
+func (a *pindexAbandonment) track(ctx context.Context,
+	pindexName string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	a.m.Lock()
+	if a.abandoned[pindexName] {
+		cancel()
+	} else {
+		a.cancels[pindexName] = cancel
+	}
+	a.m.Unlock()
+
+	return ctx, func() {
+		a.m.Lock()
+		delete(a.cancels, pindexName)
+		a.m.Unlock()
+		cancel()
+	}
+}
+
+func (a *pindexAbandonment) isAbandoned(pindexName string) bool {
+	a.m.Lock()
+	rv := a.abandoned[pindexName]
+	a.m.Unlock()
+	return rv
+}
+
+// AbandonPIndexes asks the node of the client to stop searching the
+// pindexes of the search in flight, whose results then leave them out.
+// ErrPIndexAbandonUnavailable is returned when there's no flow
+// controlled search in flight, such as when the node is of an older
+// version, in which case the search goes on as is.
+func (g *GrpcClient) AbandonPIndexes(pindexNames ...string) error {
+	if len(pindexNames) == 0 {
+		return nil
+	}
+
+	g.lastMutex.RLock()
+	f, ok := g.activeStream.(*flowControlledSearchClient)
+	g.lastMutex.RUnlock()
+	if !ok {
+		return ErrPIndexAbandonUnavailable
+	}
+
+	err := f.send(&pb.SearchFlowRequest{AbandonPIndexes: pindexNames})
+	if err == errFlowControlFallback {
+		return ErrPIndexAbandonUnavailable
+	}
+	// the server may be done already
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (g *GrpcClient) setActiveStream(res searchResultsStream) {
+	g.lastMutex.Lock()
+	g.activeStream = res
+	g.lastMutex.Unlock()
+}
@@ -0,0 +1,172 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc"
+)
+
+// blockingIndex is a bleve.Index whose searches run until their ctx is
+// done, once the started chan is signaled.
+type blockingIndex struct {
+	bleve.Index
+	started chan struct{}
+}
+
+func (b *blockingIndex) SearchInContext(ctx context.Context,
+	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	close(b.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCacheBleveIndexAbandoned(t *testing.T) {
+	a := newPIndexAbandonment()
+	hitCounts := &pindexHitCounts{}
+
+	ctx := withPIndexAbandonment(context.Background(), a)
+	ctx = withPIndexHitCounts(ctx, hitCounts)
+
+	bindex := &blockingIndex{started: make(chan struct{})}
+	m := &cacheBleveIndex{
+		pindex: &cbgt.PIndex{Name: "p0"},
+		bindex: bindex,
+		name:   "p0",
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := m.SearchInContext(ctx,
+			bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+		errCh <- err
+	}()
+
+	<-bindex.started
+	a.abandon([]string{"p0"})
+
+	select {
+	case err := <-errCh:
+		if err != ErrPIndexAbandoned {
+			t.Errorf("expected the search to be abandoned, got: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the abandoned search to stop")
+	}
+
+	if reason := hitCounts.reasons["p0"]; reason != PIndexStatusAbandoned {
+		t.Errorf("expected the abandoned reason, got: %q", reason)
+	}
+
+	// a pindex abandoned ahead of its search isn't searched at all
+	m.bindex = &blockingIndex{}
+	_, err := m.SearchInContext(ctx,
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != ErrPIndexAbandoned {
+		t.Errorf("expected the search to be abandoned, got: %v", err)
+	}
+}
+
+// capturingFlowClientStream is the client side of a flow controlled
+// search that records the messages sent to the server.
+type capturingFlowClientStream struct {
+	grpc.ClientStream
+	sent []*pb.SearchFlowRequest
+}
+
+func (s *capturingFlowClientStream) Send(m *pb.SearchFlowRequest) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *capturingFlowClientStream) Recv() (*pb.StreamSearchResults, error) {
+	return nil, io.EOF
+}
+
+func TestGrpcClientAbandonPIndexes(t *testing.T) {
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"p0", "p1"},
+	}
+
+	if err := g.AbandonPIndexes("p1"); err != ErrPIndexAbandonUnavailable {
+		t.Errorf("expected no search to abandon, got: %v", err)
+	}
+
+	stream := &capturingFlowClientStream{}
+	f := &flowControlledSearchClient{stream: stream}
+	g.setActiveStream(f)
+
+	if err := g.AbandonPIndexes("p1"); err != nil {
+		t.Fatalf("expected the pindexes to be abandoned, err: %v", err)
+	}
+	if len(stream.sent) != 1 || len(stream.sent[0].AbandonPIndexes) != 1 ||
+		stream.sent[0].AbandonPIndexes[0] != "p1" ||
+		stream.sent[0].Credits != 0 {
+		t.Errorf("expected the abandoned pindexes to be sent, got: %+v",
+			stream.sent)
+	}
+
+	// a search that fell back to the plain search can't abandon
+	f.fallback = &contentsStream{}
+	if err := g.AbandonPIndexes("p0"); err != ErrPIndexAbandonUnavailable {
+		t.Errorf("expected the fallen back search not to abandon, got: %v",
+			err)
+	}
+}
+
+func TestPIndexAbandonmentForwardsToClients(t *testing.T) {
+	a := newPIndexAbandonment()
+
+	// a pindex abandoned before the search of its client is forwarded
+	// as the search starts
+	a.abandon([]string{"p0"})
+
+	stream := &capturingFlowClientStream{}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"p0", "p1"},
+	}
+	g.setActiveStream(&flowControlledSearchClient{stream: stream})
+
+	untrack := a.trackClient(g)
+	if len(stream.sent) != 1 || len(stream.sent[0].AbandonPIndexes) != 1 ||
+		stream.sent[0].AbandonPIndexes[0] != "p0" {
+		t.Fatalf("expected the earlier abandoned pindex, got: %+v",
+			stream.sent)
+	}
+
+	// only the pindexes of the client are forwarded to its node
+	a.abandon([]string{"p1", "local_p2"})
+	if len(stream.sent) != 2 || len(stream.sent[1].AbandonPIndexes) != 1 ||
+		stream.sent[1].AbandonPIndexes[0] != "p1" {
+		t.Fatalf("expected the abandoned pindex of the client, got: %+v",
+			stream.sent)
+	}
+
+	// nor after the search of the client is done
+	untrack()
+	a.abandon([]string{"p1"})
+	if len(stream.sent) != 2 {
+		t.Errorf("expected nothing forwarded once untracked, got: %+v",
+			stream.sent)
+	}
+}
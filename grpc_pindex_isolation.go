@@ -0,0 +1,151 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// totGrpcIsolatedPIndexes tracks the pindexes of the grouped searches
+// that failed on their own, without failing the rest of their group.
+var totGrpcIsolatedPIndexes uint64
+
+// pindexAliasFunc returns the alias of the onlyPIndexes of a search,
+// along with its remote clients and the number of pindexes of the
+// index, as with the bleveIndexAlias.
+type pindexAliasFunc func(onlyPIndexes map[string]bool) (
+	bleve.IndexAlias, []RemoteClient, int, error)
+
+// canIsolatePIndexes returns whether the pindexes of a grouped search
+// may fail on their own after its alias failed with the err, where the
+// searches expecting complete results and the failed consistency waits
+// fail as a whole.
+func canIsolatePIndexes(pindexNames []string,
+	queryCtlParams *cbgt.QueryCtlParams, err error) bool {
+	if len(pindexNames) <= 1 {
+		return false
+	}
+	if _, ok := err.(*cbgt.ErrorConsistencyWait); ok {
+		return false
+	}
+	return queryCtlParams.Ctl.Consistency == nil ||
+		queryCtlParams.Ctl.Consistency.Results != "complete"
+}
+
+// isolatePIndexes builds the alias of a grouped search pindex by
+// pindex, concurrently, so that the pindexes that fail are reported as
+// the returned pindex errors, rather than failing the whole group.  The
+// err of the first pindex is returned when all of them fail.
+func isolatePIndexes(pindexNames []string, aliasFor pindexAliasFunc) (
+	bleve.IndexAlias, []RemoteClient, int, []*pb.PIndexError, error) {
+	type pindexAlias struct {
+		alias         bleve.IndexAlias
+		remoteClients []RemoteClient
+		numPIndexes   int
+		err           error
+	}
+
+	// the aliases may each wait, such as on the consistency of their
+	// pindex, so they're built side by side
+	results := make([]pindexAlias, len(pindexNames))
+
+	var wg sync.WaitGroup
+	for i, pindexName := range pindexNames {
+		wg.Add(1)
+		go func(i int, pindexName string) {
+			defer wg.Done()
+			r := &results[i]
+			r.alias, r.remoteClients, r.numPIndexes, r.err =
+				aliasFor(map[string]bool{pindexName: true})
+		}(i, pindexName)
+	}
+	wg.Wait()
+
+	alias := bleve.NewIndexAlias()
+
+	var remoteClients []RemoteClient
+	var numPIndexes int
+	var pindexErrors []*pb.PIndexError
+	var firstErr error
+
+	for i, r := range results {
+		remoteClients = append(remoteClients, r.remoteClients...)
+		if r.numPIndexes > numPIndexes {
+			numPIndexes = r.numPIndexes
+		}
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			pindexErrors = append(pindexErrors, &pb.PIndexError{
+				PIndexName: pindexNames[i],
+				Code:       uint32(pindexErrCode(r.err)),
+				Error:      r.err.Error(),
+			})
+			continue
+		}
+		alias.Add(r.alias)
+	}
+
+	if len(pindexErrors) >= len(pindexNames) {
+		return nil, remoteClients, numPIndexes, nil, firstErr
+	}
+
+	atomic.AddUint64(&totGrpcIsolatedPIndexes, uint64(len(pindexErrors)))
+
+	return alias, remoteClients, numPIndexes, pindexErrors, nil
+}
+
+// pindexErrCode returns the gRPC status code of the error of a pindex
+// that failed on its own, where the errors of unknown kinds are Unknown.
+func pindexErrCode(err error) codes.Code {
+	switch err.(type) {
+	case *cbgt.ErrorLocalPIndexHealth:
+		return codes.Unavailable
+	case *cbgt.ErrorConsistencyWait:
+		return codes.FailedPrecondition
+	}
+	return grpcErrCode(err)
+}
+
+// mergePIndexErrors merges the errors of the pindexes of a grouped
+// search that failed on their own into the status of its results.
+func mergePIndexErrors(searchResult *bleve.SearchResult,
+	pindexErrors []*pb.PIndexError) {
+	if len(pindexErrors) == 0 {
+		return
+	}
+
+	if searchResult.Status == nil {
+		searchResult.Status = &bleve.SearchStatus{}
+	}
+	if searchResult.Status.Errors == nil {
+		searchResult.Status.Errors = make(map[string]error)
+	}
+
+	for _, pe := range pindexErrors {
+		if _, exists := searchResult.Status.Errors[pe.PIndexName]; exists {
+			continue
+		}
+		searchResult.Status.Errors[pe.PIndexName] =
+			status.Error(codes.Code(pe.Code), pe.Error)
+		searchResult.Status.Total++
+		searchResult.Status.Failed++
+	}
+}
@@ -0,0 +1,216 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCanIsolatePIndexes(t *testing.T) {
+	grouped := []string{"p0", "p1"}
+	complete := &cbgt.QueryCtlParams{Ctl: cbgt.QueryCtl{
+		Consistency: &cbgt.ConsistencyParams{Results: "complete"},
+	}}
+
+	tests := []struct {
+		name        string
+		pindexNames []string
+		params      *cbgt.QueryCtlParams
+		err         error
+		exp         bool
+	}{
+		{"grouped", grouped, &cbgt.QueryCtlParams{}, fmt.Errorf("bad"), true},
+		{"single pindex", []string{"p0"}, &cbgt.QueryCtlParams{},
+			fmt.Errorf("bad"), false},
+		{"complete results", grouped, complete, fmt.Errorf("bad"), false},
+		{"consistency wait", grouped, &cbgt.QueryCtlParams{},
+			&cbgt.ErrorConsistencyWait{}, false},
+	}
+
+	for _, test := range tests {
+		if got := canIsolatePIndexes(test.pindexNames, test.params,
+			test.err); got != test.exp {
+			t.Errorf("%s, expected: %t, got: %t", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestIsolatePIndexesOneOfN(t *testing.T) {
+	pindexNames := []string{"p0", "p1", "p2"}
+
+	bindexes := map[string]bleve.Index{}
+	for i, pindexName := range pindexNames {
+		bindex, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bindex.Close()
+
+		err = bindex.Index(fmt.Sprintf("doc-%d", i),
+			map[string]interface{}{"name": "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bindexes[pindexName] = bindex
+	}
+
+	// the aliases of the pindexes are built side by side
+	var arrived sync.WaitGroup
+	arrived.Add(len(pindexNames))
+	allArrivedCh := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrivedCh)
+	}()
+
+	aliasFor := func(only map[string]bool) (bleve.IndexAlias,
+		[]RemoteClient, int, error) {
+		arrived.Done()
+		select {
+		case <-allArrivedCh:
+		case <-time.After(5 * time.Second):
+			return nil, nil, 3, fmt.Errorf("expected the aliases to be" +
+				" built concurrently")
+		}
+
+		if only["p1"] {
+			return nil, nil, 3, status.Error(codes.NotFound, "p1 is gone")
+		}
+		alias := bleve.NewIndexAlias()
+		for pindexName := range only {
+			alias.Add(bindexes[pindexName])
+		}
+		return alias, nil, 3, nil
+	}
+
+	prev := atomic.LoadUint64(&totGrpcIsolatedPIndexes)
+
+	alias, _, numPIndexes, pindexErrors, err :=
+		isolatePIndexes(pindexNames, aliasFor)
+	if err != nil {
+		t.Fatalf("expected the healthy pindexes to be searched, err: %v", err)
+	}
+	if numPIndexes != 3 {
+		t.Errorf("expected 3 pindexes, got: %d", numPIndexes)
+	}
+	if len(pindexErrors) != 1 || pindexErrors[0].PIndexName != "p1" ||
+		codes.Code(pindexErrors[0].Code) != codes.NotFound {
+		t.Errorf("expected only p1 to fail with its code, got: %+v",
+			pindexErrors)
+	}
+	if atomic.LoadUint64(&totGrpcIsolatedPIndexes) != prev+1 {
+		t.Errorf("expected the isolated pindex to be counted")
+	}
+
+	res, err := alias.Search(bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 2 {
+		t.Errorf("expected the hits of the 2 healthy pindexes, got: %d",
+			res.Total)
+	}
+}
+
+func TestIsolatePIndexesAllFail(t *testing.T) {
+	aliasFor := func(only map[string]bool) (bleve.IndexAlias,
+		[]RemoteClient, int, error) {
+		for pindexName := range only {
+			return nil, nil, 2, fmt.Errorf("%s is broken", pindexName)
+		}
+		return nil, nil, 2, nil
+	}
+
+	_, _, _, pindexErrors, err :=
+		isolatePIndexes([]string{"p0", "p1"}, aliasFor)
+	if err == nil || err.Error() != "p0 is broken" {
+		t.Errorf("expected the err of the first pindex, got: %v", err)
+	}
+	if pindexErrors != nil {
+		t.Errorf("expected no pindex errors, got: %+v", pindexErrors)
+	}
+}
+
+func TestPIndexErrCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&cbgt.ErrorLocalPIndexHealth{}, codes.Unavailable},
+		{&cbgt.ErrorConsistencyWait{}, codes.FailedPrecondition},
+		{context.Canceled, codes.Canceled},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{status.Error(codes.PermissionDenied, "denied"),
+			codes.PermissionDenied},
+		{fmt.Errorf("broken"), codes.Unknown},
+	}
+
+	for i, test := range tests {
+		if code := pindexErrCode(test.err); code != test.code {
+			t.Errorf("test: %d, err: %v, expected code: %s, got: %s",
+				i, test.err, test.code, code)
+		}
+	}
+}
+
+func TestGrpcClientMergesPIndexErrors(t *testing.T) {
+	result := &pb.StreamSearchResults{
+		Contents: &pb.StreamSearchResults_SearchResult{
+			SearchResult: []byte(`{"status":{"total":2,"failed":0,` +
+				`"successful":2},"total_hits":4}`),
+		},
+		PIndexErrors: []*pb.PIndexError{{
+			PIndexName: "idx_pindex_1",
+			Code:       uint32(codes.Unavailable),
+			Error:      "broken",
+		}},
+	}
+
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0", "idx_pindex_1", "idx_pindex_2"},
+		GrpcCli: &contentsStreamClient{
+			msgs: []*pb.StreamSearchResults{result},
+		},
+	}
+
+	res, err := g.Query(context.Background(), &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err != nil {
+		t.Fatalf("expected the results of the healthy pindexes, err: %v", err)
+	}
+
+	if res.Total != 4 {
+		t.Errorf("expected the hits of the healthy pindexes, got: %d",
+			res.Total)
+	}
+	if res.Status.Total != 3 || res.Status.Failed != 1 ||
+		res.Status.Successful != 2 {
+		t.Errorf("expected 1 of 3 pindexes failed, got: %+v", res.Status)
+	}
+	if status.Code(res.Status.Errors["idx_pindex_1"]) != codes.Unavailable {
+		t.Errorf("expected the pindex err, got: %v", res.Status.Errors)
+	}
+}
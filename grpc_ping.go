@@ -0,0 +1,230 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultGrpcPingTimeout is the default timeout of the pings of the
+// nodes, which is kept short and apart from the timeouts of the
+// queries, as a ping only checks on the node.  It's overridable by the
+// "grpcPingTimeout" manager option.
+var DefaultGrpcPingTimeout = time.Second
+
+// totGrpcPingFailures tracks the failed pings of the nodes, and the
+// totGrpcPreflightPruned tracks the clients of the unreachable or not
+// serving nodes that the pre-flight pings pruned from the queries.
+var totGrpcPingFailures uint64
+var totGrpcPreflightPruned uint64
+
+// Ping reports the status of the node, along with its approximate load
+// and its current UUID of the index of the request, if any.
+func (s *SearchService) Ping(ctx context.Context,
+	req *pb.PingRequest) (*pb.PingResult, error) {
+	rv := &pb.PingResult{
+		Status:        pb.HealthCheckResponse_SERVING,
+		ActiveQueries: querySupervisor.Count(),
+	}
+
+	if CurMemoryPressure != nil {
+		if p := CurMemoryPressure(); p > 0 {
+			rv.MemoryPressure = uint32(p)
+		}
+		// a node at full memory pressure is throttling its work
+		if rv.MemoryPressure >= 100 {
+			rv.Status = pb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	if req.IndexName == "" {
+		return rv, nil
+	}
+
+	err := verifyRPCAuth(ctx, req.IndexName, req)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"grpc_server: Ping err: %v", err)
+	}
+
+	_, indexDefsByName, err := s.mgr.GetIndexDefs(false)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable,
+			"grpc_server: Ping GetIndexDefs, err: %v", err)
+	}
+
+	if indexDef := indexDefsByName[req.IndexName]; indexDef != nil {
+		rv.IndexUUID = indexDef.UUID
+		rv.IndexUUIDCurrent = req.IndexUUID == "" ||
+			req.IndexUUID == indexDef.UUID
+	}
+
+	return rv, nil
+}
+
+// grpcPingTimeout returns the "grpcPingTimeout" manager option, parsed
+// as a duration, or else the DefaultGrpcPingTimeout.
+func grpcPingTimeout(mgr *cbgt.Manager) time.Duration {
+	if mgr == nil {
+		return DefaultGrpcPingTimeout
+	}
+
+	if v := mgr.Options()["grpcPingTimeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Warnf("grpc_client: invalid grpcPingTimeout: %q, err: %v",
+				v, err)
+		} else {
+			return d
+		}
+	}
+
+	return DefaultGrpcPingTimeout
+}
+
+// Ping checks that the node of the client is reachable and serving,
+// within the ping timeout, recording the latency of the ping in the
+// node's connection pool, for the replica selection.
+func (g *GrpcClient) Ping(ctx context.Context) (*pb.PingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, grpcPingTimeout(g.Mgr))
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	startTime := time.Now()
+
+	res, err := g.GrpcCli.Ping(ctx, &pb.PingRequest{
+		IndexName: g.IndexName,
+		IndexUUID: g.IndexUUID,
+	})
+	if err != nil {
+		atomic.AddUint64(&totGrpcPingFailures, 1)
+		return nil, err
+	}
+
+	if len(g.connRefs) > 0 {
+		g.connRefs[0].pool.recordPing(time.Since(startTime))
+	}
+
+	return res, nil
+}
+
+// recordPing smooths the latency of a ping into the pool's latency,
+// weighting the latest ping by 1/8th, where the node having answered
+// is no longer suspect.
+func (pool *rpcConnPool) recordPing(d time.Duration) {
+	atomic.StoreInt64(&pool.suspectAt, 0)
+
+	if d <= 0 {
+		d = 1
+	}
+	for {
+		prev := atomic.LoadInt64(&pool.pingLatencyNS)
+		next := int64(d)
+		if prev > 0 {
+			next = prev + (int64(d)-prev)/8
+		}
+		if atomic.CompareAndSwapInt64(&pool.pingLatencyNS, prev, next) {
+			return
+		}
+	}
+}
+
+// rpcNodePingLatency returns the lowest smoothed ping latency of a
+// node, across its pools, or 0 when the node was never pinged.
+func rpcNodePingLatency(nodeUUID string) time.Duration {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	var rv int64
+	for key, pool := range rpcConnPools {
+		if strings.HasPrefix(key, nodeUUID+"-") {
+			if n := atomic.LoadInt64(&pool.pingLatencyNS); n > 0 &&
+				(rv == 0 || n < rv) {
+				rv = n
+			}
+		}
+	}
+	return time.Duration(rv)
+}
+
+// grpcPreflightPing returns whether the "grpcPreflightPing" manager
+// option has the nodes pinged ahead of the scatter-gather.
+func grpcPreflightPing(mgr *cbgt.Manager) bool {
+	if mgr == nil {
+		return false
+	}
+	enabled, _ := strconv.ParseBool(mgr.Options()["grpcPreflightPing"])
+	return enabled
+}
+
+// pruneUnreachableGrpcClients pings the nodes of the clients in
+// parallel, returning the clients of the nodes that answered as
+// serving.  The clients of the other nodes are closed, with their
+// pindexes added to the collector as missing, so that they're reported
+// as unavailable rather than holding up the query until its timeout.
+// Nodes of older versions, which don't serve the pings, are kept.
+func pruneUnreachableGrpcClients(clients []*GrpcClient,
+	collector BleveIndexCollector) []*GrpcClient {
+	reachable := make([]bool, len(clients))
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *GrpcClient) {
+			defer wg.Done()
+
+			res, err := client.Ping(context.Background())
+			if status.Code(err) == codes.Unimplemented ||
+				(err == nil && res.Status == pb.HealthCheckResponse_SERVING) {
+				reachable[i] = true
+				return
+			}
+
+			log.Warnf("grpc_client: pre-flight ping, pruning node, %s",
+				logFields("host", client.HostPort, "index", client.IndexName,
+					"pindexes", len(client.PIndexNames),
+					"status", res.GetStatus(), "err", err))
+		}(i, client)
+	}
+	wg.Wait()
+
+	rv := make([]*GrpcClient, 0, len(clients))
+	for i, client := range clients {
+		if reachable[i] {
+			rv = append(rv, client)
+			continue
+		}
+
+		atomic.AddUint64(&totGrpcPreflightPruned, 1)
+
+		client.Close()
+		for _, pindexName := range client.PIndexNames {
+			collector.Add(&MissingPIndex{name: pindexName})
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,148 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// pingClient is a pb.SearchServiceClient whose Pings answer with the
+// res or fail with the err, recording the ctx of the last ping.
+type pingClient struct {
+	pb.SearchServiceClient
+	res *pb.PingResult
+	err error
+	ctx context.Context
+}
+
+func (c *pingClient) Ping(ctx context.Context, in *pb.PingRequest,
+	opts ...grpc.CallOption) (*pb.PingResult, error) {
+	c.ctx = ctx
+	return c.res, c.err
+}
+
+func TestGrpcClientPing(t *testing.T) {
+	cli := &pingClient{res: &pb.PingResult{
+		Status: pb.HealthCheckResponse_SERVING,
+	}}
+	pool := &rpcConnPool{}
+	g := &GrpcClient{
+		HostPort:  "localhost:15000",
+		IndexName: "idx",
+		GrpcCli:   cli,
+		connRefs:  []*rpcConnRef{{pool: pool}},
+	}
+
+	// the ping has its own short timeout, apart from the query's
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	res, err := g.Ping(ctx)
+	if err != nil || res.Status != pb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected serving, got: %v, err: %v", res, err)
+	}
+
+	deadline, ok := cli.ctx.Deadline()
+	if !ok || time.Until(deadline) > DefaultGrpcPingTimeout {
+		t.Errorf("expected the ping timeout, got deadline: %v", deadline)
+	}
+
+	md, _ := metadata.FromOutgoingContext(cli.ctx)
+	if len(md[rpcClusterActionKey]) == 0 {
+		t.Errorf("expected the cluster action metadata, got: %v", md)
+	}
+
+	if atomic.LoadInt64(&pool.pingLatencyNS) <= 0 {
+		t.Errorf("expected the ping latency to be recorded")
+	}
+
+	prev := atomic.LoadUint64(&totGrpcPingFailures)
+	cli.err = status.Error(codes.Unavailable, "down")
+	if _, err = g.Ping(ctx); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable, got: %v", err)
+	}
+	if atomic.LoadUint64(&totGrpcPingFailures) != prev+1 {
+		t.Errorf("expected the failed ping to be counted")
+	}
+}
+
+func TestRecordPing(t *testing.T) {
+	pool := &rpcConnPool{}
+
+	pool.recordPing(8 * time.Millisecond)
+	if got := atomic.LoadInt64(&pool.pingLatencyNS); got !=
+		int64(8*time.Millisecond) {
+		t.Errorf("expected the first ping as is, got: %v", time.Duration(got))
+	}
+
+	pool.recordPing(16 * time.Millisecond)
+	if got := atomic.LoadInt64(&pool.pingLatencyNS); got !=
+		int64(9*time.Millisecond) {
+		t.Errorf("expected the smoothed latency, got: %v", time.Duration(got))
+	}
+}
+
+func TestPruneUnreachableGrpcClients(t *testing.T) {
+	serving := &pb.PingResult{Status: pb.HealthCheckResponse_SERVING}
+
+	newClient := func(pindexName string, cli *pingClient) *GrpcClient {
+		return &GrpcClient{
+			HostPort:    pindexName + ":15000",
+			IndexName:   "idx",
+			PIndexNames: []string{pindexName},
+			GrpcCli:     cli,
+		}
+	}
+
+	clients := []*GrpcClient{
+		newClient("serving", &pingClient{res: serving}),
+		newClient("down", &pingClient{
+			err: status.Error(codes.Unavailable, "down")}),
+		newClient("throttling", &pingClient{res: &pb.PingResult{
+			Status: pb.HealthCheckResponse_NOT_SERVING}}),
+		newClient("older", &pingClient{
+			err: status.Error(codes.Unimplemented, "unknown method")}),
+	}
+
+	prev := atomic.LoadUint64(&totGrpcPreflightPruned)
+
+	collector := &indexesCollector{}
+	rv := pruneUnreachableGrpcClients(clients, collector)
+
+	if len(rv) != 2 || rv[0].PIndexNames[0] != "serving" ||
+		rv[1].PIndexNames[0] != "older" {
+		t.Errorf("expected the serving and the older nodes, got: %v", rv)
+	}
+
+	var missing []string
+	for _, index := range collector.indexes {
+		if m, ok := index.(*MissingPIndex); ok {
+			missing = append(missing, m.Name())
+		}
+	}
+	if len(missing) != 2 || missing[0] != "down" || missing[1] != "throttling" {
+		t.Errorf("expected the pruned pindexes as missing, got: %v", missing)
+	}
+
+	if atomic.LoadUint64(&totGrpcPreflightPruned) != prev+2 {
+		t.Errorf("expected the pruned clients to be counted")
+	}
+}
@@ -0,0 +1,233 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The nodes of a cluster may run different versions during a rolling
+// upgrade, where a node of an older version silently ignores the
+// fields of the requests and the responses that it doesn't know of.
+// The clients send their GrpcProtocolVersion in the metadata of every
+// rpc, and the servers report theirs back in the response headers, so
+// that a client only uses the features that its peer supports, as
+// tracked per node by grpcPeerSupports.
+
+// GrpcProtocolVersion is the version of the gRPC protocol between the
+// nodes, which is bumped along with the features that the nodes of
+// older versions don't support, as listed in grpcCapabilityVersions.
+const GrpcProtocolVersion = 2
+
+// grpcProtocolVersionLegacy is the protocol version of the nodes that
+// predate the negotiation, as they don't report their version.
+const grpcProtocolVersionLegacy = 1
+
+// rpcProtocolVersionKey is the metadata key carrying the protocol
+// version of the client in the requests, and of the server in the
+// headers of its responses.
+const rpcProtocolVersionKey = "rpcprotocolversion"
+
+// grpcCapability is a feature of the protocol that a peer supports
+// from a protocol version on.
+type grpcCapability string
+
+const (
+	// grpcCapCompression is the compression of the larger responses.
+	grpcCapCompression = grpcCapability("compression")
+
+	// grpcCapStreamFacets is the streaming of the interim facets.
+	grpcCapStreamFacets = grpcCapability("streamFacets")
+)
+
+// grpcCapabilityVersions are the protocol versions that introduced the
+// capabilities.
+var grpcCapabilityVersions = map[grpcCapability]int{
+	grpcCapCompression:  2,
+	grpcCapStreamFacets: 2,
+}
+
+// totGrpcCapabilityFallbacks tracks the requests that left out a
+// feature, as their node is of an older protocol version.
+var totGrpcCapabilityFallbacks uint64
+
+// rpcNodeProtocolVersions are the protocol versions reported by the
+// remote nodes, keyed by hostPort, where a node that didn't respond yet
+// has no entry.
+var rpcNodeProtocolVersions = map[string]int{}
+
+var rpcNodeProtocolVersionsMutex sync.Mutex
+
+// recordPeerProtocolVersion records the protocol version of the node of
+// the hostPort, as reported in the header of one of its responses,
+// logging the node becoming of a different version than this node.
+func recordPeerProtocolVersion(hostPort string, header metadata.MD) {
+	version := grpcProtocolVersionLegacy
+	if vals := header.Get(rpcProtocolVersionKey); len(vals) > 0 {
+		v, err := strconv.Atoi(vals[0])
+		if err != nil {
+			log.Warnf("grpc_client: invalid protocol version, %s",
+				logFields("host", hostPort, "version", vals[0], "err", err))
+			return
+		}
+		version = v
+	}
+
+	rpcNodeProtocolVersionsMutex.Lock()
+	prev, exists := rpcNodeProtocolVersions[hostPort]
+	rpcNodeProtocolVersions[hostPort] = version
+	rpcNodeProtocolVersionsMutex.Unlock()
+
+	if exists && prev == version {
+		return
+	}
+
+	if version < GrpcProtocolVersion {
+		log.Warnf("grpc_client: node of an older protocol version, falling"+
+			" back to the requests that it supports, %s",
+			logFields("host", hostPort, "version", version,
+				"ourVersion", GrpcProtocolVersion))
+	} else if version > GrpcProtocolVersion {
+		log.Printf("grpc_client: node of a newer protocol version, %s",
+			logFields("host", hostPort, "version", version,
+				"ourVersion", GrpcProtocolVersion))
+	}
+}
+
+// grpcPeerProtocolVersion returns the protocol version of the node of
+// the hostPort, or 0 while it's unknown.
+func grpcPeerProtocolVersion(hostPort string) int {
+	rpcNodeProtocolVersionsMutex.Lock()
+	rv := rpcNodeProtocolVersions[hostPort]
+	rpcNodeProtocolVersionsMutex.Unlock()
+	return rv
+}
+
+// grpcPeerSupports returns whether the node of the hostPort supports the
+// capability.  A node whose version is still unknown is assumed to be
+// current, as the connection warmups learn the versions of the nodes
+// ahead of their first queries, and as the nodes of older versions
+// ignore the features anyway, only without saying so.
+func grpcPeerSupports(hostPort string, c grpcCapability) bool {
+	version := grpcPeerProtocolVersion(hostPort)
+	if version == 0 || version >= grpcCapabilityVersions[c] {
+		return true
+	}
+
+	atomic.AddUint64(&totGrpcCapabilityFallbacks, 1)
+	return false
+}
+
+// GrpcNodeProtocolVersions returns the protocol versions of the remote
+// nodes that differ from the GrpcProtocolVersion, keyed by hostPort.
+func GrpcNodeProtocolVersions() map[string]int {
+	rpcNodeProtocolVersionsMutex.Lock()
+	defer rpcNodeProtocolVersionsMutex.Unlock()
+
+	rv := map[string]int{}
+	for hostPort, version := range rpcNodeProtocolVersions {
+		if version != GrpcProtocolVersion {
+			rv[hostPort] = version
+		}
+	}
+	return rv
+}
+
+var grpcProtocolVersionStr = strconv.Itoa(GrpcProtocolVersion)
+
+// protocolVersionUnaryClientInterceptor sends the protocol version of
+// the client with the unary calls, recording the version of the node
+// from the header of its response.
+func protocolVersionUnaryClientInterceptor(ctx context.Context,
+	method string, req interface{}, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption) error {
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcProtocolVersionKey, grpcProtocolVersionStr)
+
+	var header metadata.MD
+	err := invoker(ctx, method, req, reply, cc,
+		append(opts, grpc.Header(&header))...)
+	// a node of an older version fails the rpc's it doesn't know of
+	if err == nil || status.Code(err) == codes.Unimplemented {
+		recordPeerProtocolVersion(cc.Target(), header)
+	}
+	return err
+}
+
+// protocolVersionStreamClientInterceptor sends the protocol version of
+// the client with the streaming calls, recording the version of the
+// node from the header of the stream.
+func protocolVersionStreamClientInterceptor(ctx context.Context,
+	desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (
+	grpc.ClientStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcProtocolVersionKey, grpcProtocolVersionStr)
+
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocolVersionClientStream{ClientStream: cs,
+		hostPort: cc.Target()}, nil
+}
+
+// protocolVersionClientStream wraps a grpc.ClientStream to record the
+// protocol version of its node, once the header of the stream arrived
+// along with its first message.
+type protocolVersionClientStream struct {
+	grpc.ClientStream
+
+	hostPort string
+	recorded uint32
+}
+
+func (s *protocolVersionClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if (err == nil || err == io.EOF ||
+		status.Code(err) == codes.Unimplemented) &&
+		atomic.CompareAndSwapUint32(&s.recorded, 0, 1) {
+		if header, er := s.ClientStream.Header(); er == nil {
+			recordPeerProtocolVersion(s.hostPort, header)
+		}
+	}
+	return err
+}
+
+// protocolVersionUnaryServerInterceptor reports the protocol version of
+// the server in the header of the unary calls, where the streaming
+// calls report it from the serverInterceptor.
+func protocolVersionUnaryServerInterceptor(ctx context.Context,
+	req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	grpc.SetHeader(ctx, metadata.Pairs(rpcProtocolVersionKey,
+		grpcProtocolVersionStr))
+	return handler(ctx, req)
+}
+
+// AddUnaryServerInterceptor returns the server option that reports the
+// protocol version of the server with the unary calls.
+func AddUnaryServerInterceptor() grpc.ServerOption {
+	return grpc.UnaryInterceptor(protocolVersionUnaryServerInterceptor)
+}
@@ -0,0 +1,150 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	pb "github.com/couchbase/cbft/protobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func forgetPeerProtocolVersion(hostPort string) {
+	rpcNodeProtocolVersionsMutex.Lock()
+	delete(rpcNodeProtocolVersions, hostPort)
+	rpcNodeProtocolVersionsMutex.Unlock()
+}
+
+func TestGrpcPeerSupports(t *testing.T) {
+	hostPort := "protocol-version-test-host:1"
+	defer forgetPeerProtocolVersion(hostPort)
+
+	// a node of an unknown version is assumed to be current
+	if !grpcPeerSupports(hostPort, grpcCapCompression) {
+		t.Errorf("expected an unknown node to support compression")
+	}
+
+	// a node that doesn't report its version predates the negotiation
+	recordPeerProtocolVersion(hostPort, metadata.MD{})
+	if v := grpcPeerProtocolVersion(hostPort); v != grpcProtocolVersionLegacy {
+		t.Errorf("expected the legacy version, got: %d", v)
+	}
+	if GrpcNodeProtocolVersions()[hostPort] != grpcProtocolVersionLegacy {
+		t.Errorf("expected the legacy node to be reported, got: %v",
+			GrpcNodeProtocolVersions())
+	}
+
+	prev := atomic.LoadUint64(&totGrpcCapabilityFallbacks)
+	if grpcPeerSupports(hostPort, grpcCapCompression) ||
+		grpcPeerSupports(hostPort, grpcCapStreamFacets) {
+		t.Errorf("expected a legacy node not to support the capabilities")
+	}
+	if atomic.LoadUint64(&totGrpcCapabilityFallbacks) != prev+2 {
+		t.Errorf("expected the fallbacks to be counted")
+	}
+
+	// an upgraded node reports its version
+	recordPeerProtocolVersion(hostPort,
+		metadata.Pairs(rpcProtocolVersionKey, grpcProtocolVersionStr))
+	if !grpcPeerSupports(hostPort, grpcCapStreamFacets) {
+		t.Errorf("expected an upgraded node to support streaming facets")
+	}
+	if _, exists := GrpcNodeProtocolVersions()[hostPort]; exists {
+		t.Errorf("expected a current node not to be reported")
+	}
+}
+
+func TestGrpcProtocolVersionUnaryNegotiation(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts []grpc.ServerOption
+		if !legacy {
+			opts = append(opts, AddUnaryServerInterceptor())
+		}
+		s := grpc.NewServer(opts...)
+		pb.RegisterSearchServiceServer(s, &SearchService{})
+		go s.Serve(lis)
+
+		hostPort := lis.Addr().String()
+		conn, err := grpc.Dial(hostPort, grpc.WithInsecure(),
+			grpc.WithUnaryInterceptor(protocolVersionUnaryClientInterceptor))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = pb.NewSearchServiceClient(conn).Ping(context.Background(),
+			&pb.PingRequest{})
+		if err != nil {
+			t.Fatalf("legacy: %t, ping err: %v", legacy, err)
+		}
+
+		expected := GrpcProtocolVersion
+		if legacy {
+			expected = grpcProtocolVersionLegacy
+		}
+		if v := grpcPeerProtocolVersion(hostPort); v != expected {
+			t.Errorf("legacy: %t, expected version: %d, got: %d",
+				legacy, expected, v)
+		}
+
+		conn.Close()
+		s.Stop()
+		forgetPeerProtocolVersion(hostPort)
+	}
+}
+
+// headerClientStream is a grpc.ClientStream with the given header,
+// whose messages have all been received.
+type headerClientStream struct {
+	grpc.ClientStream
+	header  metadata.MD
+	headers int
+}
+
+func (s *headerClientStream) Header() (metadata.MD, error) {
+	s.headers++
+	return s.header, nil
+}
+
+func (s *headerClientStream) RecvMsg(m interface{}) error {
+	return io.EOF
+}
+
+func TestGrpcProtocolVersionClientStream(t *testing.T) {
+	hostPort := "protocol-version-test-host:2"
+	defer forgetPeerProtocolVersion(hostPort)
+
+	cs := &headerClientStream{
+		header: metadata.Pairs(rpcProtocolVersionKey, "3"),
+	}
+	s := &protocolVersionClientStream{ClientStream: cs, hostPort: hostPort}
+
+	for i := 0; i < 2; i++ {
+		if err := s.RecvMsg(nil); err != io.EOF {
+			t.Fatalf("expected the err of the stream, got: %v", err)
+		}
+	}
+	if cs.headers != 1 {
+		t.Errorf("expected the header to be read once, got: %d", cs.headers)
+	}
+	if v := grpcPeerProtocolVersion(hostPort); v != 3 {
+		t.Errorf("expected the newer version, got: %d", v)
+	}
+}
@@ -0,0 +1,189 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/index/store"
+)
+
+// ErrRemoteIndexReadOnly is the error of the mutating operations of the
+// index of a GrpcClient, as returned by its Advanced().
+var ErrRemoteIndexReadOnly = errors.New("grpc_client: remote index is" +
+	" read-only")
+
+// ErrRemoteIndexUnsupported is the error of the read operations that
+// the index of a GrpcClient doesn't proxy to the remote node.
+var ErrRemoteIndexUnsupported = errors.New("grpc_client: unsupported by" +
+	" the remote index")
+
+// Advanced returns a read-only index.Index backed by the pindexes of
+// the client on the remote node, and no KVStore.  Its reader proxies
+// the DocCount, Fields, FieldDict, FieldDictRange, FieldDictPrefix,
+// DumpAll, DumpDoc and DumpFields over gRPC, where the servers of older
+// versions may still fail them as unimplemented.  The rest of the read
+// operations fail with the ErrRemoteIndexUnsupported, and the mutating
+// ones with the ErrRemoteIndexReadOnly.
+func (g *GrpcClient) Advanced() (index.Index, store.KVStore, error) {
+	return &remoteIndex{g: g}, nil, nil
+}
+
+// remoteIndex is the read-only index.Index of a GrpcClient.
+type remoteIndex struct {
+	g *GrpcClient
+}
+
+func (r *remoteIndex) Open() error {
+	return nil
+}
+
+// Close is a no-op, as the connections are owned by the GrpcClient.
+func (r *remoteIndex) Close() error {
+	return nil
+}
+
+func (r *remoteIndex) Update(doc *document.Document) error {
+	return ErrRemoteIndexReadOnly
+}
+
+func (r *remoteIndex) Delete(id string) error {
+	return ErrRemoteIndexReadOnly
+}
+
+func (r *remoteIndex) Batch(batch *index.Batch) error {
+	return ErrRemoteIndexReadOnly
+}
+
+func (r *remoteIndex) SetInternal(key, val []byte) error {
+	return ErrRemoteIndexReadOnly
+}
+
+func (r *remoteIndex) DeleteInternal(key []byte) error {
+	return ErrRemoteIndexReadOnly
+}
+
+func (r *remoteIndex) Reader() (index.IndexReader, error) {
+	return &remoteIndexReader{g: r.g}, nil
+}
+
+func (r *remoteIndex) Stats() json.Marshaler {
+	if s := r.g.Stats(); s != nil {
+		return s
+	}
+	return nil
+}
+
+func (r *remoteIndex) StatsMap() map[string]interface{} {
+	return r.g.StatsMap()
+}
+
+// Analyze isn't proxied, as the analysis of a doc is by the mapping,
+// which is local to the caller anyway.
+func (r *remoteIndex) Analyze(d *document.Document) *index.AnalysisResult {
+	return nil
+}
+
+func (r *remoteIndex) Advanced() (store.KVStore, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+// remoteIndexReader is the index.IndexReader of a remoteIndex, which
+// reads from the remote pindexes as of each call, rather than of a
+// snapshot.
+type remoteIndexReader struct {
+	g *GrpcClient
+}
+
+func (r *remoteIndexReader) TermFieldReader(term []byte, field string,
+	includeFreq, includeNorm, includeTermVectors bool) (
+	index.TermFieldReader, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DocIDReaderAll() (index.DocIDReader, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DocIDReaderOnly(ids []string) (
+	index.DocIDReader, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) FieldDict(field string) (index.FieldDict, error) {
+	return r.g.FieldDict(field)
+}
+
+func (r *remoteIndexReader) FieldDictRange(field string,
+	startTerm []byte, endTerm []byte) (index.FieldDict, error) {
+	return r.g.FieldDictRange(field, startTerm, endTerm)
+}
+
+func (r *remoteIndexReader) FieldDictPrefix(field string,
+	termPrefix []byte) (index.FieldDict, error) {
+	return r.g.FieldDictPrefix(field, termPrefix)
+}
+
+func (r *remoteIndexReader) Document(id string) (*document.Document, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DocumentVisitFieldTerms(id index.IndexInternalID,
+	fields []string, visitor index.DocumentFieldTermVisitor) error {
+	return ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DocValueReader(fields []string) (
+	index.DocValueReader, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) Fields() ([]string, error) {
+	return r.g.Fields()
+}
+
+func (r *remoteIndexReader) GetInternal(key []byte) ([]byte, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DocCount() (uint64, error) {
+	return r.g.DocCount()
+}
+
+func (r *remoteIndexReader) ExternalID(id index.IndexInternalID) (
+	string, error) {
+	return "", ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) InternalID(id string) (
+	index.IndexInternalID, error) {
+	return nil, ErrRemoteIndexUnsupported
+}
+
+func (r *remoteIndexReader) DumpAll() chan interface{} {
+	return r.g.DumpAll()
+}
+
+func (r *remoteIndexReader) DumpDoc(id string) chan interface{} {
+	return r.g.DumpDoc(id)
+}
+
+func (r *remoteIndexReader) DumpFields() chan interface{} {
+	return r.g.DumpFields()
+}
+
+func (r *remoteIndexReader) Close() error {
+	return nil
+}
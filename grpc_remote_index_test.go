@@ -0,0 +1,68 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+)
+
+var _ index.Index = &remoteIndex{}
+var _ index.IndexReader = &remoteIndexReader{}
+
+func TestGrpcClientAdvanced(t *testing.T) {
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"p1", "p2"},
+		GrpcCli: &pindexDocCountClient{
+			counts: map[string]int64{"p1": 2, "p2": 3},
+		},
+	}
+
+	idx, kvstore, err := g.Advanced()
+	if err != nil || idx == nil || kvstore != nil {
+		t.Fatalf("expected a remote index, got: %v, %v, %v", idx, kvstore, err)
+	}
+
+	r, err := idx.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	count, err := r.DocCount()
+	if err != nil || count != 5 {
+		t.Errorf("expected the doc count of the pindexes, got: %d, %v",
+			count, err)
+	}
+
+	if _, err = r.Document("a"); err != ErrRemoteIndexUnsupported {
+		t.Errorf("expected an unsupported read, got: %v", err)
+	}
+
+	if err = idx.Update(document.NewDocument("a")); err != ErrRemoteIndexReadOnly {
+		t.Errorf("expected a read-only update, got: %v", err)
+	}
+	if err = idx.Delete("a"); err != ErrRemoteIndexReadOnly {
+		t.Errorf("expected a read-only delete, got: %v", err)
+	}
+	if err = idx.Batch(index.NewBatch()); err != ErrRemoteIndexReadOnly {
+		t.Errorf("expected a read-only batch, got: %v", err)
+	}
+
+	if idx.Stats() != nil {
+		t.Errorf("expected no stats")
+	}
+}
@@ -0,0 +1,224 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// ReplicaSelector chooses the node that serves the scatter-gather
+// requests of a remote pindex, among the nodes of its readable
+// replicas, so that the query load is spread across the replicas.
+type ReplicaSelector interface {
+	// Select returns the chosen node among the candidates, which are
+	// sorted by UUID and number at least two.
+	Select(pindexName string, candidates []*cbgt.NodeDef) *cbgt.NodeDef
+}
+
+// The built-in replica selection policies, by the values of the
+// "grpcReplicaSelection" option, where none keeps the replicas chosen
+// by the plan.
+const (
+	ReplicaSelectionRoundRobin       = "round_robin"
+	ReplicaSelectionLeastOutstanding = "least_outstanding"
+	ReplicaSelectionLowestLatency    = "lowest_latency"
+)
+
+var replicaSelectorsMutex sync.Mutex
+
+var replicaSelectors = map[string]ReplicaSelector{
+	ReplicaSelectionRoundRobin:       &roundRobinReplicaSelector{},
+	ReplicaSelectionLeastOutstanding: &leastOutstandingReplicaSelector{},
+	ReplicaSelectionLowestLatency:    &lowestLatencyReplicaSelector{},
+}
+
+// RegisterReplicaSelector registers a replica selection policy, which
+// is then selectable by its name with the "grpcReplicaSelection"
+// option.
+func RegisterReplicaSelector(name string, s ReplicaSelector) {
+	replicaSelectorsMutex.Lock()
+	replicaSelectors[name] = s
+	replicaSelectorsMutex.Unlock()
+}
+
+// grpcReplicaSelector returns the replica selection policy of the
+// "grpcReplicaSelection" option, if any.
+func grpcReplicaSelector(mgr *cbgt.Manager) ReplicaSelector {
+	if mgr == nil {
+		return nil
+	}
+
+	name := mgr.Options()["grpcReplicaSelection"]
+	if name == "" {
+		return nil
+	}
+
+	replicaSelectorsMutex.Lock()
+	s, exists := replicaSelectors[name]
+	replicaSelectorsMutex.Unlock()
+	if !exists {
+		log.Warnf("grpc_client: unknown grpcReplicaSelection: %s", name)
+	}
+	return s
+}
+
+// roundRobinReplicaSelector takes turns across the replicas.
+type roundRobinReplicaSelector struct {
+	next uint64
+}
+
+func (s *roundRobinReplicaSelector) Select(pindexName string,
+	candidates []*cbgt.NodeDef) *cbgt.NodeDef {
+	i := atomic.AddUint64(&s.next, 1)
+	return candidates[i%uint64(len(candidates))]
+}
+
+// leastOutstandingReplicaSelector favors the replica with the fewest
+// queries in flight to its node, taking turns across the tied ones.
+type leastOutstandingReplicaSelector struct {
+	next uint64
+
+	// outstanding is overridable for testing.
+	outstanding func(nodeUUID string) int64
+}
+
+func (s *leastOutstandingReplicaSelector) Select(pindexName string,
+	candidates []*cbgt.NodeDef) *cbgt.NodeDef {
+	outstanding := s.outstanding
+	if outstanding == nil {
+		outstanding = rpcNodeOutstanding
+	}
+
+	start := int(atomic.AddUint64(&s.next, 1) % uint64(len(candidates)))
+
+	var rv *cbgt.NodeDef
+	var min int64
+	for i := range candidates {
+		c := candidates[(start+i)%len(candidates)]
+		if n := outstanding(c.UUID); rv == nil || n < min {
+			rv, min = c, n
+		}
+	}
+	return rv
+}
+
+// lowestLatencyReplicaSelector favors the replica whose node served the
+// searches the fastest, or else answered the pings the fastest, with
+// the nodes that recently failed penalized, taking turns across the
+// tied ones, where the nodes of unknown latency rank after the others.
+type lowestLatencyReplicaSelector struct {
+	next uint64
+
+	// latency is overridable for testing.
+	latency func(nodeUUID string) time.Duration
+}
+
+func (s *lowestLatencyReplicaSelector) Select(pindexName string,
+	candidates []*cbgt.NodeDef) *cbgt.NodeDef {
+	latency := s.latency
+	if latency == nil {
+		latency = rpcNodeLatency
+	}
+
+	start := int(atomic.AddUint64(&s.next, 1) % uint64(len(candidates)))
+
+	var rv *cbgt.NodeDef
+	var min time.Duration
+	for i := range candidates {
+		c := candidates[(start+i)%len(candidates)]
+		d := latency(c.UUID)
+		if rv == nil || (d > 0 && (min <= 0 || d < min)) {
+			rv, min = c, d
+		}
+	}
+	return rv
+}
+
+// selectReplicas returns the remote pindexes with their nodes chosen
+// by the selector, among the nodes of their readable replicas, other
+// than the local node.
+func selectReplicas(selector ReplicaSelector, nodeDefs *cbgt.NodeDefs,
+	selfUUID string,
+	remotePlanPIndexes []*cbgt.RemotePlanPIndex) []*cbgt.RemotePlanPIndex {
+	if nodeDefs == nil {
+		return remotePlanPIndexes
+	}
+
+	rv := make([]*cbgt.RemotePlanPIndex, 0, len(remotePlanPIndexes))
+	for _, remotePlanPIndex := range remotePlanPIndexes {
+		var candidates []*cbgt.NodeDef
+		if remotePlanPIndex.PlanPIndex != nil {
+			for nodeUUID, planPIndexNode := range remotePlanPIndex.PlanPIndex.Nodes {
+				if nodeUUID == selfUUID ||
+					planPIndexNode == nil || !planPIndexNode.CanRead {
+					continue
+				}
+				if nodeDef := nodeDefs.NodeDefs[nodeUUID]; nodeDef != nil {
+					candidates = append(candidates, nodeDef)
+				}
+			}
+		}
+
+		if len(candidates) < 2 {
+			rv = append(rv, remotePlanPIndex)
+			continue
+		}
+
+		// pass over the suspect nodes while there are others
+		candidates = unsuspectNodeDefs(candidates)
+		if len(candidates) == 1 {
+			rv = append(rv, &cbgt.RemotePlanPIndex{
+				PlanPIndex: remotePlanPIndex.PlanPIndex,
+				NodeDef:    candidates[0],
+			})
+			continue
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].UUID < candidates[j].UUID
+		})
+
+		nodeDef := selector.Select(remotePlanPIndex.PlanPIndex.Name, candidates)
+		if nodeDef == nil {
+			rv = append(rv, remotePlanPIndex)
+			continue
+		}
+
+		rv = append(rv, &cbgt.RemotePlanPIndex{
+			PlanPIndex: remotePlanPIndex.PlanPIndex,
+			NodeDef:    nodeDef,
+		})
+	}
+
+	return rv
+}
+
+// unsuspectNodeDefs returns the nodes that aren't suspect, as of a
+// failed connection warmup, or else all the nodes.
+func unsuspectNodeDefs(nodeDefs []*cbgt.NodeDef) []*cbgt.NodeDef {
+	var rv []*cbgt.NodeDef
+	for _, nodeDef := range nodeDefs {
+		if !rpcNodeSuspect(nodeDef.UUID) {
+			rv = append(rv, nodeDef)
+		}
+	}
+	if len(rv) == 0 {
+		return nodeDefs
+	}
+	return rv
+}
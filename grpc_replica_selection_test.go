@@ -0,0 +1,153 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestSelectReplicas(t *testing.T) {
+	nodeDefs := &cbgt.NodeDefs{NodeDefs: map[string]*cbgt.NodeDef{
+		"self": {UUID: "self", HostPort: "self:8094"},
+		"a":    {UUID: "a", HostPort: "a:8094"},
+		"b":    {UUID: "b", HostPort: "b:8094"},
+		"c":    {UUID: "c", HostPort: "c:8094"},
+	}}
+
+	replicated := &cbgt.PlanPIndex{
+		Name: "replicated",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"self": {CanRead: true},
+			"a":    {CanRead: true},
+			"b":    {CanRead: true},
+			"c":    {CanRead: false},
+		},
+	}
+	single := &cbgt.PlanPIndex{
+		Name: "single",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"self": {CanRead: true},
+			"c":    {CanRead: true},
+		},
+	}
+
+	remotePlanPIndexes := []*cbgt.RemotePlanPIndex{
+		{PlanPIndex: replicated, NodeDef: nodeDefs.NodeDefs["a"]},
+		{PlanPIndex: single, NodeDef: nodeDefs.NodeDefs["c"]},
+	}
+
+	selector := &roundRobinReplicaSelector{}
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		rv := selectReplicas(selector, nodeDefs, "self", remotePlanPIndexes)
+		if len(rv) != 2 {
+			t.Fatalf("expected all the remote pindexes, got: %d", len(rv))
+		}
+		if rv[0].PlanPIndex != replicated {
+			t.Fatalf("expected the plan pindex to be kept")
+		}
+		seen[rv[0].NodeDef.UUID]++
+
+		if rv[1] != remotePlanPIndexes[1] {
+			t.Errorf("expected a lone replica to be kept")
+		}
+	}
+
+	// the local node and the unreadable replica are never chosen
+	if len(seen) != 2 || seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("expected turns across the readable replicas, got: %v", seen)
+	}
+
+	if remotePlanPIndexes[0].NodeDef.UUID != "a" {
+		t.Errorf("expected the remote pindexes to be left unchanged")
+	}
+}
+
+func TestLeastOutstandingReplicaSelector(t *testing.T) {
+	candidates := []*cbgt.NodeDef{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}}
+
+	outstanding := map[string]int64{"a": 3, "b": 1, "c": 2}
+	s := &leastOutstandingReplicaSelector{
+		outstanding: func(nodeUUID string) int64 {
+			return outstanding[nodeUUID]
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := s.Select("p", candidates); got.UUID != "b" {
+			t.Errorf("expected the least loaded node, got: %s", got.UUID)
+		}
+	}
+
+	// the tied nodes take turns
+	outstanding["a"] = 1
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[s.Select("p", candidates).UUID]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("expected turns across the tied nodes, got: %v", seen)
+	}
+}
+
+func TestLowestLatencyReplicaSelector(t *testing.T) {
+	candidates := []*cbgt.NodeDef{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}}
+
+	latencies := map[string]time.Duration{"a": 3 * time.Millisecond,
+		"b": time.Millisecond}
+	s := &lowestLatencyReplicaSelector{
+		latency: func(nodeUUID string) time.Duration {
+			return latencies[nodeUUID]
+		},
+	}
+
+	// the never pinged node c ranks after the pinged ones
+	for i := 0; i < 3; i++ {
+		if got := s.Select("p", candidates); got.UUID != "b" {
+			t.Errorf("expected the fastest node, got: %s", got.UUID)
+		}
+	}
+
+	// without any pings, the nodes take turns
+	latencies = map[string]time.Duration{}
+	seen := map[string]int{}
+	for i := 0; i < 3; i++ {
+		seen[s.Select("p", candidates).UUID]++
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected turns across the unpinged nodes, got: %v", seen)
+	}
+}
+
+func TestRPCNodeOutstanding(t *testing.T) {
+	rpcConnMutex.Lock()
+	rpcConnPools["outstanding-a-host:9130"] = &rpcConnPool{outstanding: 2}
+	rpcConnPools["outstanding-ab-host:9130"] = &rpcConnPool{outstanding: 5}
+	rpcConnMutex.Unlock()
+	defer func() {
+		rpcConnMutex.Lock()
+		delete(rpcConnPools, "outstanding-a-host:9130")
+		delete(rpcConnPools, "outstanding-ab-host:9130")
+		rpcConnMutex.Unlock()
+	}()
+
+	if n := rpcNodeOutstanding("outstanding-a"); n != 2 {
+		t.Errorf("expected the outstanding of the node alone, got: %d", n)
+	}
+	if n := rpcNodeOutstanding("outstanding-b"); n != 0 {
+		t.Errorf("expected no match of another node, got: %d", n)
+	}
+}
@@ -0,0 +1,84 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blevesearch/bleve"
+	"golang.org/x/net/context"
+)
+
+// SearchRequestRewriter rewrites the search request of a query before
+// it's sent to a remote node, such as to add a tenant filter or to cap
+// the size, returning the request to send in its place.  The req is
+// shared by all the remote nodes of the query, so a rewriter that
+// changes it must return a modified copy rather than modify it in
+// place.  An error rejects the query.
+type SearchRequestRewriter func(ctx context.Context, indexName string,
+	req *bleve.SearchRequest) (*bleve.SearchRequest, error)
+
+var searchRequestRewriterMutex sync.RWMutex
+var searchRequestRewriter SearchRequestRewriter
+
+// totGrpcSearchRequestsRewritten tracks the remote search requests that
+// were rewritten, and totGrpcSearchRequestsRejected the ones that were
+// rejected by the rewriter.
+var totGrpcSearchRequestsRewritten uint64
+var totGrpcSearchRequestsRejected uint64
+
+// RegisterSearchRequestRewriter registers the rewriter of the search
+// requests sent to the remote nodes, which a GrpcClient's own
+// RequestRewriter overrides, where nil unregisters it.  There's no
+// rewriter by default.
+func RegisterSearchRequestRewriter(r SearchRequestRewriter) {
+	searchRequestRewriterMutex.Lock()
+	searchRequestRewriter = r
+	searchRequestRewriterMutex.Unlock()
+}
+
+func getSearchRequestRewriter() SearchRequestRewriter {
+	searchRequestRewriterMutex.RLock()
+	rv := searchRequestRewriter
+	searchRequestRewriterMutex.RUnlock()
+	return rv
+}
+
+// rewriteSearchRequest returns the search request to send to the node
+// of the client, as rewritten by its rewriter, if any.
+func (g *GrpcClient) rewriteSearchRequest(ctx context.Context,
+	req *bleve.SearchRequest) (*bleve.SearchRequest, error) {
+	r := g.RequestRewriter
+	if r == nil {
+		r = getSearchRequestRewriter()
+	}
+	if r == nil {
+		return req, nil
+	}
+
+	rv, err := r(ctx, g.IndexName, req)
+	if err != nil {
+		atomic.AddUint64(&totGrpcSearchRequestsRejected, 1)
+		return nil, fmt.Errorf("grpc_client: search request rejected,"+
+			" index: %s, err: %v", g.IndexName, err)
+	}
+	if rv == nil {
+		return nil, fmt.Errorf("grpc_client: search request rewritten"+
+			" to nil, index: %s", g.IndexName)
+	}
+
+	atomic.AddUint64(&totGrpcSearchRequestsRewritten, 1)
+
+	return rv, nil
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+)
+
+type tenantKey struct{}
+
+func TestGrpcClientSearchRequestRewriter(t *testing.T) {
+	defer RegisterSearchRequestRewriter(nil)
+
+	// caps the size, and rejects the queries of no tenant
+	RegisterSearchRequestRewriter(func(ctx context.Context, indexName string,
+		req *bleve.SearchRequest) (*bleve.SearchRequest, error) {
+		if ctx.Value(tenantKey{}) == nil {
+			return nil, fmt.Errorf("no tenant")
+		}
+		rv := *req
+		if rv.Size > 5 {
+			rv.Size = 5
+		}
+		return &rv, nil
+	})
+
+	newClient := func() (*GrpcClient, *requestCapturingClient) {
+		cli := &requestCapturingClient{
+			contentsStreamClient: contentsStreamClient{
+				msgs: []*pb.StreamSearchResults{
+					{Contents: &pb.StreamSearchResults_SearchResult{
+						SearchResult: []byte(`{"total_hits":0}`),
+					}},
+				},
+			},
+		}
+		return &GrpcClient{
+			HostPort:    "localhost:15000",
+			IndexName:   "idx",
+			PIndexNames: []string{"idx_pindex_0"},
+			GrpcCli:     cli,
+		}, cli
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(),
+		100, 0, false)
+
+	g, cli := newClient()
+	ctx := context.WithValue(context.Background(), tenantKey{}, "t1")
+	if _, err := g.Query(ctx, &scatterRequest{searchRequest: searchRequest}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent bleve.SearchRequest
+	if err := UnmarshalJSON(cli.req.Contents, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Size != 5 {
+		t.Errorf("expected the rewritten size, got: %d", sent.Size)
+	}
+	if searchRequest.Size != 100 {
+		t.Errorf("expected the shared request to be left unchanged")
+	}
+
+	// a rejected request is never sent
+	g, cli = newClient()
+	_, err := g.Query(context.Background(),
+		&scatterRequest{searchRequest: searchRequest})
+	if err == nil || cli.req != nil {
+		t.Errorf("expected the request to be rejected, err: %v", err)
+	}
+
+	// the client's own rewriter overrides the registered one
+	g, cli = newClient()
+	g.RequestRewriter = func(ctx context.Context, indexName string,
+		req *bleve.SearchRequest) (*bleve.SearchRequest, error) {
+		return req, nil
+	}
+	if _, err := g.Query(context.Background(),
+		&scatterRequest{searchRequest: searchRequest}); err != nil {
+		t.Errorf("expected the client's rewriter to be used, err: %v", err)
+	}
+}
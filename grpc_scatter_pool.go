@@ -0,0 +1,173 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcScatterGatherWorkers is the number of the workers shared by the
+// scatter-gather queries of the gRPC clients, which bounds the searches
+// that run concurrently across the queries, so that a query of a wide
+// alias doesn't have a goroutine per client.  A search that gets no
+// worker before its ctx is done fails.  A search gives up its worker
+// while it writes the hits to a slow consumer, so that a query that's
+// slow to consume doesn't starve the other queries of the workers.
+// The default of 0 has grpcScatterGatherWorkersPerCPU workers per
+// GOMAXPROCS, as the searches mostly await the remote nodes.  It's read
+// only once, as the pool starts on the first search, so that a later
+// change has no effect until a restart.
+var GrpcScatterGatherWorkers = 0
+
+const grpcScatterGatherWorkersPerCPU = 64
+
+// totGrpcScatterPoolSaturated tracks the searches that found all the
+// workers busy, and totGrpcScatterPoolTimeouts the ones whose ctx was
+// done before they got a worker.
+var totGrpcScatterPoolSaturated uint64
+var totGrpcScatterPoolTimeouts uint64
+
+// totGrpcScatterPoolYields tracks the stream writes that gave up their
+// worker while they waited on the consumer.
+var totGrpcScatterPoolYields uint64
+
+// grpcWorkerPool is a fixed number of worker slots that the tasks run
+// in, where a task waits for an idle slot.
+type grpcWorkerPool struct {
+	size  int
+	slots chan struct{}
+	busy  int64 // Accessed atomically.
+}
+
+func newGrpcWorkerPool(size int) *grpcWorkerPool {
+	return &grpcWorkerPool{size: size, slots: make(chan struct{}, size)}
+}
+
+// acquire takes an idle slot, waiting for one until the ctx is done, in
+// which case it returns the status error of the ctx.
+func (p *grpcWorkerPool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		atomic.AddInt64(&p.busy, 1)
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&totGrpcScatterPoolSaturated, 1)
+
+	select {
+	case p.slots <- struct{}{}:
+		atomic.AddInt64(&p.busy, 1)
+		return nil
+	case <-ctx.Done():
+	}
+
+	atomic.AddUint64(&totGrpcScatterPoolTimeouts, 1)
+
+	code := codes.Canceled
+	if ctx.Err() == context.DeadlineExceeded {
+		code = codes.DeadlineExceeded
+	}
+	return status.Errorf(code, "grpc_client: no scatter-gather worker"+
+		" became available, all %d workers busy, err: %v", p.size, ctx.Err())
+}
+
+func (p *grpcWorkerPool) release() {
+	atomic.AddInt64(&p.busy, -1)
+	<-p.slots
+}
+
+// run hands the task to an idle worker, waiting for one until the ctx
+// is done, in which case it returns the status error of the ctx and the
+// task isn't run.  The ctx given to the task lets it give up the worker
+// for a while, see yieldScatterWorker().
+func (p *grpcWorkerPool) run(ctx context.Context,
+	task func(ctx context.Context)) error {
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+
+	w := &scatterWorker{pool: p, held: true}
+	go func() {
+		defer w.done()
+		task(context.WithValue(ctx, scatterWorkerKey, w))
+	}()
+
+	return nil
+}
+
+type scatterWorkerKeyType string
+
+const scatterWorkerKey = scatterWorkerKeyType("scatterWorker")
+
+// scatterWorker is the slot of the pool held by a running task.
+type scatterWorker struct {
+	pool *grpcWorkerPool
+	held bool // Only used by the task's goroutine.
+}
+
+func (w *scatterWorker) done() {
+	if w.held {
+		w.held = false
+		w.pool.release()
+	}
+}
+
+// yieldScatterWorker gives up the worker of the task of the ctx, if
+// any, such as ahead of a write to a slow consumer, and returns the
+// func that takes up a worker again, which fails once the ctx is done.
+func yieldScatterWorker(ctx context.Context) func() error {
+	w, _ := ctx.Value(scatterWorkerKey).(*scatterWorker)
+	if w == nil || !w.held {
+		return func() error { return nil }
+	}
+
+	atomic.AddUint64(&totGrpcScatterPoolYields, 1)
+	w.done()
+
+	return func() error {
+		if err := w.pool.acquire(ctx); err != nil {
+			return err
+		}
+		w.held = true
+		return nil
+	}
+}
+
+var grpcScatterPoolOnce sync.Once
+var grpcScatterPool *grpcWorkerPool
+
+// scatterPool returns the workers of the scatter-gather queries,
+// sizing them as of the GrpcScatterGatherWorkers on the first call.
+func scatterPool() *grpcWorkerPool {
+	grpcScatterPoolOnce.Do(func() {
+		size := GrpcScatterGatherWorkers
+		if size <= 0 {
+			size = grpcScatterGatherWorkersPerCPU * runtime.GOMAXPROCS(0)
+		}
+		grpcScatterPool = newGrpcWorkerPool(size)
+	})
+	return grpcScatterPool
+}
+
+// GrpcScatterPoolUsage returns the number of the scatter-gather workers
+// and of the busy ones.
+func GrpcScatterPoolUsage() (int, int64) {
+	p := scatterPool()
+	return p.size, atomic.LoadInt64(&p.busy)
+}
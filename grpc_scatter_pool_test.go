@@ -0,0 +1,112 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcWorkerPool(t *testing.T) {
+	p := newGrpcWorkerPool(1)
+
+	// the only worker is kept busy
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	err := p.run(context.Background(), func(context.Context) {
+		close(started)
+		<-unblock
+	})
+	if err != nil {
+		t.Fatalf("expected the task to run, err: %v", err)
+	}
+	<-started
+	if busy := atomic.LoadInt64(&p.busy); busy != 1 {
+		t.Errorf("expected a busy worker, got: %d", busy)
+	}
+
+	saturated := atomic.LoadUint64(&totGrpcScatterPoolSaturated)
+	timeouts := atomic.LoadUint64(&totGrpcScatterPoolTimeouts)
+
+	// so the next task can't get a worker within its deadline
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	err = p.run(ctx, func(context.Context) {
+		t.Errorf("expected the task not to run")
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a deadline exceeded err, got: %v", err)
+	}
+	if atomic.LoadUint64(&totGrpcScatterPoolSaturated) != saturated+1 ||
+		atomic.LoadUint64(&totGrpcScatterPoolTimeouts) != timeouts+1 {
+		t.Errorf("expected the saturation and the timeout to be counted")
+	}
+
+	// until the worker is done
+	done := make(chan struct{})
+	go func() {
+		err := p.run(context.Background(), func(context.Context) {
+			close(done)
+		})
+		if err != nil {
+			t.Errorf("expected the queued task to run, err: %v", err)
+		}
+	}()
+	close(unblock)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the queued task to run")
+	}
+}
+
+func TestGrpcWorkerPoolYield(t *testing.T) {
+	p := newGrpcWorkerPool(1)
+
+	// the only worker is taken by a task that's blocked on a write
+	yielded := make(chan struct{})
+	unblock := make(chan struct{})
+	resumed := make(chan error, 1)
+	err := p.run(context.Background(), func(ctx context.Context) {
+		resume := yieldScatterWorker(ctx)
+		close(yielded)
+		<-unblock
+		resumed <- resume()
+	})
+	if err != nil {
+		t.Fatalf("expected the task to run, err: %v", err)
+	}
+	<-yielded
+
+	// which leaves the worker to the other queries meanwhile
+	done := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = p.run(ctx, func(context.Context) { close(done) })
+	if err != nil {
+		t.Fatalf("expected a worker while the other task yielded, err: %v",
+			err)
+	}
+	<-done
+
+	// and the blocked task takes up a worker again once it's written
+	close(unblock)
+	if err = <-resumed; err != nil {
+		t.Errorf("expected the task to resume, err: %v", err)
+	}
+}
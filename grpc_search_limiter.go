@@ -0,0 +1,180 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultGrpcMaxConcurrentSearches is the default max number of the
+// Search RPCs that run concurrently against a remote node, over the
+// connections of its pool, where the excess searches queue until a
+// search completes or their ctx is done, so that a large alias query
+// doesn't overwhelm the node.  It's overridable by the
+// "grpcMaxConcurrentSearches" manager option, where 0 is unlimited.
+var DefaultGrpcMaxConcurrentSearches = 0
+
+// totGrpcSearchesQueued tracks the searches that had to queue for the
+// concurrency limit of their node, and totGrpcSearchQueueTimeouts the
+// ones whose ctx was done while queued.
+var totGrpcSearchesQueued uint64
+var totGrpcSearchQueueTimeouts uint64
+
+// grpcMaxConcurrentSearches returns the "grpcMaxConcurrentSearches"
+// manager option, or else the DefaultGrpcMaxConcurrentSearches.
+func grpcMaxConcurrentSearches(mgr *cbgt.Manager) int {
+	if mgr == nil {
+		return DefaultGrpcMaxConcurrentSearches
+	}
+
+	if v := mgr.Options()["grpcMaxConcurrentSearches"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Warnf("grpc_client: invalid grpcMaxConcurrentSearches: %q,"+
+				" err: %v", v, err)
+		} else {
+			return n
+		}
+	}
+
+	return DefaultGrpcMaxConcurrentSearches
+}
+
+// searchLimiter is the semaphore of the concurrent searches against a
+// node, whose waiters are admitted in their arrival order.
+type searchLimiter struct {
+	m        sync.Mutex
+	inFlight int
+	waiters  []chan struct{}
+}
+
+// acquire admits a search, when fewer than limit searches are in
+// flight, or else queues it until one completes or the ctx is done, in
+// which case it returns the status error of the ctx.  A limit <= 0 is
+// unlimited.  An admitted search must be released.
+func (l *searchLimiter) acquire(ctx context.Context, limit int) error {
+	l.m.Lock()
+	if limit <= 0 || (l.inFlight < limit && len(l.waiters) == 0) {
+		l.inFlight++
+		l.m.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.m.Unlock()
+
+	atomic.AddUint64(&totGrpcSearchesQueued, 1)
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.m.Lock()
+	admitted := true
+	for i, w := range l.waiters {
+		if w == ch {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			admitted = false
+			break
+		}
+	}
+	l.m.Unlock()
+
+	// the search was admitted just as its ctx was done
+	if admitted {
+		l.release(limit)
+	}
+
+	atomic.AddUint64(&totGrpcSearchQueueTimeouts, 1)
+
+	code := codes.Canceled
+	if ctx.Err() == context.DeadlineExceeded {
+		code = codes.DeadlineExceeded
+	}
+	return status.Errorf(code, "grpc_client: queued for the max concurrent"+
+		" searches of the node, err: %v", ctx.Err())
+}
+
+// release completes an admitted search, admitting the queued searches
+// that the limit now allows.
+func (l *searchLimiter) release(limit int) {
+	l.m.Lock()
+	l.inFlight--
+	for len(l.waiters) > 0 && (limit <= 0 || l.inFlight < limit) {
+		l.inFlight++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+	l.m.Unlock()
+}
+
+// counts returns the number of the searches in flight and queued.
+func (l *searchLimiter) counts() (int, int) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.inFlight, len(l.waiters)
+}
+
+// GrpcSearchConcurrency is the number of the Search RPCs in flight to
+// a remote node and of the ones queued for its concurrency limit.
+type GrpcSearchConcurrency struct {
+	InFlight int `json:"inFlight"`
+	Queued   int `json:"queued"`
+}
+
+// GrpcSearchConcurrencies returns the search concurrency of each of the
+// cached pools, keyed by the nodeUUID and hostPort of the nodes.
+func GrpcSearchConcurrencies() map[string]GrpcSearchConcurrency {
+	rpcConnMutex.Lock()
+	keys := make([]string, 0, len(rpcConnPools))
+	pools := make([]*rpcConnPool, 0, len(rpcConnPools))
+	for key, pool := range rpcConnPools {
+		keys = append(keys, key)
+		pools = append(pools, pool)
+	}
+	rpcConnMutex.Unlock()
+
+	rv := make(map[string]GrpcSearchConcurrency, len(keys))
+	for i, pool := range pools {
+		inFlight, queued := pool.searches.counts()
+		rv[keys[i]] = GrpcSearchConcurrency{InFlight: inFlight, Queued: queued}
+	}
+	return rv
+}
+
+// acquireSearchSlot admits a search of the client against its node, as
+// limited by the "grpcMaxConcurrentSearches" option, returning the
+// func that releases it.
+func (g *GrpcClient) acquireSearchSlot(ctx context.Context) (func(), error) {
+	if len(g.connRefs) == 0 {
+		return func() {}, nil
+	}
+
+	limit := grpcMaxConcurrentSearches(g.Mgr)
+	searches := &g.connRefs[0].pool.searches
+	if err := searches.acquire(ctx, limit); err != nil {
+		return nil, err
+	}
+
+	return func() { searches.release(limit) }, nil
+}
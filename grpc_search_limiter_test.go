@@ -0,0 +1,136 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSearchLimiterQueues(t *testing.T) {
+	var l searchLimiter
+
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(context.Background(), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admitted := make(chan error)
+	go func() {
+		admitted <- l.acquire(context.Background(), 2)
+	}()
+
+	for {
+		if _, queued := l.counts(); queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-admitted:
+		t.Fatalf("expected the search to queue over the limit")
+	default:
+	}
+
+	l.release(2)
+	if err := <-admitted; err != nil {
+		t.Fatal(err)
+	}
+
+	if inFlight, queued := l.counts(); inFlight != 2 || queued != 0 {
+		t.Errorf("expected 2 in flight and none queued, got: %d, %d",
+			inFlight, queued)
+	}
+}
+
+func TestSearchLimiterDeadline(t *testing.T) {
+	var l searchLimiter
+
+	if err := l.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	err := l.acquire(ctx, 1)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a deadline exceeded, got: %v", err)
+	}
+
+	if inFlight, queued := l.counts(); inFlight != 1 || queued != 0 {
+		t.Errorf("expected the timed out search to be dequeued, got: %d, %d",
+			inFlight, queued)
+	}
+
+	l.release(1)
+	if err := l.acquire(context.Background(), 1); err != nil {
+		t.Errorf("expected the released slot to be reused, got: %v", err)
+	}
+}
+
+func TestGrpcClientMaxConcurrentSearches(t *testing.T) {
+	prev := DefaultGrpcMaxConcurrentSearches
+	DefaultGrpcMaxConcurrentSearches = 1
+	defer func() { DefaultGrpcMaxConcurrentSearches = prev }()
+
+	pool := &rpcConnPool{}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0"},
+		GrpcCli: &contentsStreamClient{
+			msgs: []*pb.StreamSearchResults{{
+				Contents: &pb.StreamSearchResults_SearchResult{
+					SearchResult: []byte(`{"total_hits":1}`),
+				},
+			}},
+		},
+		connRefs: []*rpcConnRef{{pool: pool}},
+	}
+
+	query := func(ctx context.Context) error {
+		_, err := g.Query(ctx, &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		return err
+	}
+
+	if err := query(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if inFlight, _ := pool.searches.counts(); inFlight != 0 {
+		t.Errorf("expected the search to be released, got: %d", inFlight)
+	}
+
+	// with the only slot taken, the search times out in the queue
+	if err := pool.searches.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	if err := query(ctx); err == nil {
+		t.Errorf("expected the queued search to time out")
+	}
+}
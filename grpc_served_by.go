@@ -0,0 +1,162 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// rpcServedByNodeUUIDKey and rpcServedByHostKey are the metadata keys
+// of the UUID and the host of the node that served a search, which
+// tell the replicas of a pindex apart on the client.
+const rpcServedByNodeUUIDKey = "rpcservedbynodeuuid"
+const rpcServedByHostKey = "rpcservedbyhost"
+
+// ServedBy is the node that served a search.
+type ServedBy struct {
+	NodeUUID string `json:"nodeUUID"`
+	Host     string `json:"host"`
+}
+
+// setServedBy reports the node of the server in the trailer of a
+// search, and in its header too, for the clients whose searches fail
+// mid-stream, before the trailer.
+func setServedBy(stream grpc.ServerStream, mgr *cbgt.Manager) {
+	if mgr == nil {
+		return
+	}
+
+	md := metadata.Pairs(
+		rpcServedByNodeUUIDKey, mgr.UUID(),
+		rpcServedByHostKey, mgr.BindHttp())
+	stream.SetHeader(md)
+	stream.SetTrailer(md)
+}
+
+// servedByFromMetadata returns the node that served a search, or nil
+// when not reported, as by the servers of older versions.
+func servedByFromMetadata(md metadata.MD) *ServedBy {
+	nodeUUIDs := md.Get(rpcServedByNodeUUIDKey)
+	if len(nodeUUIDs) == 0 || nodeUUIDs[0] == "" {
+		return nil
+	}
+
+	rv := &ServedBy{NodeUUID: nodeUUIDs[0]}
+	if hosts := md.Get(rpcServedByHostKey); len(hosts) > 0 {
+		rv.Host = hosts[0]
+	}
+	return rv
+}
+
+// LastServedBy returns the node that served the last search of the
+// client, which is one of the replicas of its pindexes, or nil if the
+// search failed before reaching a node or the server didn't report
+// itself, as with servers of older versions.
+func (g *GrpcClient) LastServedBy() *ServedBy {
+	g.lastMutex.RLock()
+	defer g.lastMutex.RUnlock()
+	return g.lastServedBy
+}
+
+// servedByFromHeader returns the node that's serving a search from the
+// header of its stream, which is in once a response was received.
+func servedByFromHeader(res searchResultsStream) *ServedBy {
+	md, err := res.Header()
+	if err != nil {
+		return nil
+	}
+	return servedByFromMetadata(md)
+}
+
+func (g *GrpcClient) setLastServedBy(servedBy *ServedBy) {
+	g.lastMutex.Lock()
+	g.lastServedBy = servedBy
+	g.lastMutex.Unlock()
+}
+
+// GrpcServedByStats are the stats of the searches served by a node, by
+// outcome, as seen by the clients of this node.
+type GrpcServedByStats struct {
+	Host      string
+	Succeeded GrpcCallStats
+	Failed    GrpcCallStats
+}
+
+type grpcServedByStats struct {
+	host      atomic.Value // The last reported host, as a string.
+	succeeded *grpcCallStats
+	failed    *grpcCallStats
+}
+
+var grpcServedByStatsMutex sync.Mutex
+
+// grpcServedByStatsMap are keyed by the UUID of the serving node, where
+// the nodes of the cluster bound the number of entries.
+var grpcServedByStatsMap = map[string]*grpcServedByStats{}
+
+// recordServedBy accounts a search served by a node.
+func recordServedBy(servedBy *ServedBy, d time.Duration, err error) {
+	if servedBy == nil {
+		return
+	}
+
+	grpcServedByStatsMutex.Lock()
+	ss, exists := grpcServedByStatsMap[servedBy.NodeUUID]
+	if !exists {
+		ss = &grpcServedByStats{
+			succeeded: newGrpcCallStats(),
+			failed:    newGrpcCallStats(),
+		}
+		grpcServedByStatsMap[servedBy.NodeUUID] = ss
+	}
+	grpcServedByStatsMutex.Unlock()
+
+	ss.host.Store(servedBy.Host)
+
+	s := ss.succeeded
+	if err != nil {
+		s = ss.failed
+	}
+	atomic.AddUint64(&s.totCalls, 1)
+	s.latency.Update(int64(d))
+}
+
+// GrpcServedBy returns a snapshot of the stats of the searches served
+// by each node, keyed by the node UUID, so that a replica that's
+// repeatedly slow stands out.
+func GrpcServedBy() map[string]GrpcServedByStats {
+	grpcServedByStatsMutex.Lock()
+	nodeUUIDs := make([]string, 0, len(grpcServedByStatsMap))
+	stats := make([]*grpcServedByStats, 0, len(grpcServedByStatsMap))
+	for nodeUUID, ss := range grpcServedByStatsMap {
+		nodeUUIDs = append(nodeUUIDs, nodeUUID)
+		stats = append(stats, ss)
+	}
+	grpcServedByStatsMutex.Unlock()
+
+	rv := make(map[string]GrpcServedByStats, len(nodeUUIDs))
+	for i, ss := range stats {
+		host, _ := ss.host.Load().(string)
+		rv[nodeUUIDs[i]] = GrpcServedByStats{
+			Host:      host,
+			Succeeded: ss.succeeded.snapshot(),
+			Failed:    ss.failed.snapshot(),
+		}
+	}
+	return rv
+}
@@ -0,0 +1,135 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGrpcClientLastServedBy(t *testing.T) {
+	cli := &trailerStreamClient{
+		msgs: []*pb.StreamSearchResults{{
+			Contents: &pb.StreamSearchResults_SearchResult{
+				SearchResult: []byte(`{"total_hits":1}`),
+			},
+		}},
+		trailer: metadata.Pairs(
+			rpcServedByNodeUUIDKey, "served-by-n1",
+			rpcServedByHostKey, "10.0.0.1:8094"),
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0"},
+		GrpcCli:     cli,
+	}
+
+	query := func() {
+		_, err := g.Query(context.Background(), &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query()
+
+	servedBy := g.LastServedBy()
+	if servedBy == nil || servedBy.NodeUUID != "served-by-n1" ||
+		servedBy.Host != "10.0.0.1:8094" {
+		t.Errorf("expected the serving node, got: %+v", servedBy)
+	}
+
+	s, exists := GrpcServedBy()["served-by-n1"]
+	if !exists || s.Host != "10.0.0.1:8094" || s.Succeeded.TotCalls != 1 {
+		t.Errorf("expected the search in the per-node stats, got: %+v", s)
+	}
+
+	// servers of older versions don't report themselves
+	cli.trailer = nil
+	query()
+
+	if servedBy := g.LastServedBy(); servedBy != nil {
+		t.Errorf("expected no serving node, got: %+v", servedBy)
+	}
+}
+
+// headerStreamClient is a pb.SearchServiceClient whose Searches stream
+// the msgs with the given header.
+type headerStreamClient struct {
+	pb.SearchServiceClient
+	msgs   []*pb.StreamSearchResults
+	header metadata.MD
+}
+
+func (c *headerStreamClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	return &headerStream{
+		contentsStream: contentsStream{msgs: c.msgs},
+		header:         c.header,
+	}, nil
+}
+
+type headerStream struct {
+	contentsStream
+	header metadata.MD
+}
+
+func (s *headerStream) Header() (metadata.MD, error) {
+	return s.header, nil
+}
+
+func TestGrpcClientServedByMidStreamFailure(t *testing.T) {
+	cli := &headerStreamClient{
+		msgs: []*pb.StreamSearchResults{{
+			Contents: &pb.StreamSearchResults_SearchResult{
+				SearchResult: []byte(`{"total_hits":`),
+			},
+		}},
+		header: metadata.Pairs(
+			rpcServedByNodeUUIDKey, "served-by-n2",
+			rpcServedByHostKey, "10.0.0.2:8094"),
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0"},
+		GrpcCli:     cli,
+	}
+
+	_, err := g.Query(context.Background(), &scatterRequest{
+		searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+	})
+	if err == nil {
+		t.Fatalf("expected the malformed result to fail the search")
+	}
+
+	servedBy := g.LastServedBy()
+	if servedBy == nil || servedBy.NodeUUID != "served-by-n2" {
+		t.Errorf("expected the serving node from the header, got: %+v",
+			servedBy)
+	}
+
+	s := GrpcServedBy()["served-by-n2"]
+	if s.Failed.TotCalls != 1 || s.Succeeded.TotCalls != 0 {
+		t.Errorf("expected the failed search in the per-node stats,"+
+			" got: %+v", s)
+	}
+}
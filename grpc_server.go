@@ -16,12 +16,16 @@ package cbft
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search"
 	"github.com/blevesearch/bleve/search/query"
 	pb "github.com/couchbase/cbft/protobuf"
@@ -30,6 +34,7 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -57,7 +62,11 @@ func (s *SearchService) SetManager(mgr *cbgt.Manager) {
 func (s *SearchService) Check(ctx context.Context,
 	in *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
 	if in.Service == "" || in.Service == "Search" ||
-		in.Service == "DocCount" {
+		in.Service == "DocCount" || in.Service == "FieldsWithTypes" ||
+		in.Service == "Fields" || in.Service == "Dump" ||
+		in.Service == "TermDictionary" || in.Service == "BatchSearch" ||
+		in.Service == "GetMapping" || in.Service == "SearchWithFlowControl" ||
+		in.Service == "Ping" {
 		return &pb.HealthCheckResponse{
 			Status: pb.HealthCheckResponse_SERVING,
 		}, nil
@@ -65,34 +74,533 @@ func (s *SearchService) Check(ctx context.Context,
 	return nil, status.Error(codes.NotFound, "unknown service")
 }
 
+// DocCount counts the pindex of the IndexName, or else each of the
+// PIndexNames, when set, in a single round trip, where the pindexes
+// that fail are reported in the Errors rather than failing the rpc.
 func (s *SearchService) DocCount(ctx context.Context,
 	req *pb.DocCountRequest) (*pb.DocCountResult, error) {
-	pindex := s.mgr.GetPIndex(req.IndexName)
+	if len(req.PIndexNames) > 0 {
+		rv := &pb.DocCountResult{
+			PIndexDocCounts: make(map[string]int64, len(req.PIndexNames)),
+		}
+		for _, pindexName := range req.PIndexNames {
+			count, err := s.countPIndex(pindexName, "")
+			if err != nil {
+				if rv.Errors == nil {
+					rv.Errors = map[string]string{}
+				}
+				rv.Errors[pindexName] = err.Error()
+				continue
+			}
+			rv.PIndexDocCounts[pindexName] = int64(count)
+			rv.DocCount += int64(count)
+		}
+		return rv, nil
+	}
+
+	count, err := s.countPIndex(req.IndexName, req.IndexUUID)
+	if err != nil {
+		return &pb.DocCountResult{DocCount: 0}, err
+	}
+
+	return &pb.DocCountResult{DocCount: int64(count)}, nil
+}
+
+func (s *SearchService) countPIndex(pindexName, pindexUUID string) (
+	uint64, error) {
+	pindex := s.mgr.GetPIndex(pindexName)
 	if pindex == nil {
-		return &pb.DocCountResult{DocCount: 0}, fmt.Errorf("grpc_server: "+
-			"CountPIndex, no pindex, pindexName: %s", req.IndexName)
+		return 0, fmt.Errorf("grpc_server: "+
+			"CountPIndex, no pindex, pindexName: %s", pindexName)
 	}
 	if pindex.Dest == nil {
-		return &pb.DocCountResult{DocCount: 0}, fmt.Errorf("grpc_server: "+
-			"CountPIndex, no pindex.Dest, pindexName: %s", req.IndexName)
-
+		return 0, fmt.Errorf("grpc_server: "+
+			"CountPIndex, no pindex.Dest, pindexName: %s", pindexName)
 	}
 
-	if req.IndexUUID != "" && pindex.UUID != req.IndexUUID {
-		return &pb.DocCountResult{DocCount: 0}, fmt.Errorf("grpc_server: "+
+	if pindexUUID != "" && pindex.UUID != pindexUUID {
+		return 0, fmt.Errorf("grpc_server: "+
 			"CountPIndex, wrong pindexUUID: %s, pindex.UUID: %s, pindexName: %s",
-			req.IndexUUID, pindex.UUID, req.IndexName)
+			pindexUUID, pindex.UUID, pindexName)
 	}
 
 	count, err := pindex.Dest.Count(pindex, nil)
 	if err != nil {
-		return &pb.DocCountResult{DocCount: 0}, fmt.Errorf("grpc_server: "+
-			"CountPIndex, pindexName: %s, req: %#v, err: %v",
-			req.IndexName, req, err)
+		return 0, fmt.Errorf("grpc_server: "+
+			"CountPIndex, pindexName: %s, err: %v", pindexName, err)
+	}
+
+	return count, nil
+}
 
+func (s *SearchService) FieldsWithTypes(ctx context.Context,
+	req *pb.FieldsRequest) (*pb.FieldsWithTypesResult, error) {
+	err := verifyRPCAuth(ctx, req.IndexName, req)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"grpc_server: FieldsWithTypes err: %v", err)
 	}
 
-	return &pb.DocCountResult{DocCount: int64(count)}, nil
+	rv := &pb.FieldsWithTypesResult{}
+
+	var pindexesFieldTypes []map[string]string
+	for _, pindexName := range req.PIndexNames {
+		fieldTypes, err := s.pindexFieldTypes(pindexName, req.IndexUUID)
+		if err != nil {
+			if rv.Errors == nil {
+				rv.Errors = map[string]string{}
+			}
+			rv.Errors[pindexName] = err.Error()
+			continue
+		}
+		pindexesFieldTypes = append(pindexesFieldTypes, fieldTypes)
+	}
+
+	rv.FieldTypes, rv.Conflicts = reconcileFieldTypes(pindexesFieldTypes)
+
+	return rv, nil
+}
+
+func (s *SearchService) Fields(ctx context.Context,
+	req *pb.FieldsRequest) (*pb.FieldsResult, error) {
+	err := verifyRPCAuth(ctx, req.IndexName, req)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"grpc_server: Fields err: %v", err)
+	}
+
+	rv := &pb.FieldsResult{}
+
+	var pindexesFields [][]string
+	for _, pindexName := range req.PIndexNames {
+		bindex, err := s.localBleveIndex(pindexName, req.IndexUUID)
+		if err == nil {
+			var fields []string
+			fields, err = bindex.Fields()
+			if err == nil {
+				pindexesFields = append(pindexesFields, fields)
+				continue
+			}
+		}
+
+		if rv.Errors == nil {
+			rv.Errors = map[string]string{}
+		}
+		rv.Errors[pindexName] = err.Error()
+	}
+
+	rv.Fields = unionFields(pindexesFields)
+
+	return rv, nil
+}
+
+// GetMapping returns the serialized mapping of the index, from the
+// first of the local pindexes of the request that has it, as all the
+// pindexes of an index share its mapping.
+func (s *SearchService) GetMapping(ctx context.Context,
+	req *pb.MappingRequest) (*pb.MappingResult, error) {
+	err := verifyRPCAuth(ctx, req.IndexName, req)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"grpc_server: GetMapping err: %v", err)
+	}
+
+	err = fmt.Errorf("grpc_server: GetMapping, no pindexes,"+
+		" indexName: %s", req.IndexName)
+	for _, pindexName := range req.PIndexNames {
+		var bindex bleve.Index
+		bindex, err = s.localBleveIndex(pindexName, req.IndexUUID)
+		if err != nil {
+			continue
+		}
+
+		var buf []byte
+		buf, err = MarshalJSON(bindex.Mapping())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"grpc_server: GetMapping, pindexName: %s, err: %v",
+				pindexName, err)
+		}
+
+		return &pb.MappingResult{Mapping: buf}, nil
+	}
+
+	return nil, status.Error(codes.NotFound, err.Error())
+}
+
+// The kinds of the Dump RPC, after the bleve index dump methods.
+const (
+	dumpKindAll    = "all"
+	dumpKindDoc    = "doc"
+	dumpKindFields = "fields"
+)
+
+// Dump streams the rows dumped by the local pindexes of the request,
+// for debugging, where a pindex that can't be dumped streams its error
+// rather than failing the others.
+func (s *SearchService) Dump(req *pb.DumpRequest,
+	stream pb.SearchService_DumpServer) error {
+	err := verifyRPCAuth(stream.Context(), req.IndexName, req)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied,
+			"grpc_server: Dump err: %v", err)
+	}
+
+	if req.Kind != dumpKindAll && req.Kind != dumpKindDoc &&
+		req.Kind != dumpKindFields {
+		return status.Errorf(codes.InvalidArgument,
+			"grpc_server: Dump unknown kind: %q", req.Kind)
+	}
+
+	for _, pindexName := range req.PIndexNames {
+		bindex, err := s.localBleveIndex(pindexName, req.IndexUUID)
+		if err != nil {
+			err = stream.Send(dumpResult(pindexName, err))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var ch chan interface{}
+		switch req.Kind {
+		case dumpKindAll:
+			ch = bindex.DumpAll()
+		case dumpKindDoc:
+			ch = bindex.DumpDoc(req.DocID)
+		case dumpKindFields:
+			ch = bindex.DumpFields()
+		}
+
+		// some index types, like scorch, can't be dumped
+		if ch == nil {
+			err = stream.Send(dumpResult(pindexName,
+				fmt.Errorf("grpc_server: Dump unsupported, pindexName: %s",
+					pindexName)))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for item := range ch {
+			err = stream.Send(dumpResult(pindexName, item))
+			if err != nil {
+				// unblock the dumping goroutine of the index
+				go func() {
+					for range ch {
+					}
+				}()
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dumpResult converts an item dumped by a pindex, which is either an
+// error or a row, into its DumpResult.
+func dumpResult(pindexName string, item interface{}) *pb.DumpResult {
+	rv := &pb.DumpResult{PIndexName: pindexName}
+
+	switch item := item.(type) {
+	case error:
+		rv.Error = item.Error()
+	case interface {
+		Key() []byte
+		Value() []byte
+	}:
+		rv.Key = item.Key()
+		rv.Value = item.Value()
+	default:
+		rv.Error = fmt.Sprintf("grpc_server: Dump unknown row type: %T", item)
+	}
+
+	return rv
+}
+
+// The kinds of the TermDictionary RPC, after the bleve index field
+// dictionary methods.
+const (
+	termDictKindAll    = "all"
+	termDictKindRange  = "range"
+	termDictKindPrefix = "prefix"
+)
+
+// termDictBatchSize is the number of terms sent per TermDictionary
+// stream message.
+var termDictBatchSize = 1000
+
+// TermDictionary streams the sorted terms of a field, merged across
+// the local pindexes of the request, where any failed pindex fails the
+// request, so that the counts of the terms are never partial.
+func (s *SearchService) TermDictionary(req *pb.TermDictionaryRequest,
+	stream pb.SearchService_TermDictionaryServer) error {
+	err := verifyRPCAuth(stream.Context(), req.IndexName, req)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied,
+			"grpc_server: TermDictionary err: %v", err)
+	}
+
+	if req.Kind != termDictKindAll && req.Kind != termDictKindRange &&
+		req.Kind != termDictKindPrefix {
+		return status.Errorf(codes.InvalidArgument,
+			"grpc_server: TermDictionary unknown kind: %q", req.Kind)
+	}
+
+	dict := &mergedFieldDict{}
+	defer dict.Close()
+
+	for _, pindexName := range req.PIndexNames {
+		bindex, err := s.localBleveIndex(pindexName, req.IndexUUID)
+		if err == nil {
+			var pindexDict index.FieldDict
+			switch req.Kind {
+			case termDictKindAll:
+				pindexDict, err = bindex.FieldDict(req.Field)
+			case termDictKindRange:
+				pindexDict, err = bindex.FieldDictRange(req.Field,
+					req.StartTerm, req.EndTerm)
+			case termDictKindPrefix:
+				pindexDict, err = bindex.FieldDictPrefix(req.Field,
+					req.TermPrefix)
+			}
+			if err == nil {
+				dict.dicts = append(dict.dicts, pindexDict)
+				continue
+			}
+		}
+
+		return status.Errorf(codes.Internal, "grpc_server: TermDictionary,"+
+			" pindexName: %s, err: %v", pindexName, err)
+	}
+
+	entries := make([]*pb.TermDictionaryEntry, 0, termDictBatchSize)
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return status.Errorf(codes.Internal,
+				"grpc_server: TermDictionary, err: %v", err)
+		}
+		if entry == nil {
+			break
+		}
+
+		entries = append(entries, &pb.TermDictionaryEntry{
+			Term:  entry.Term,
+			Count: entry.Count,
+		})
+		if len(entries) >= termDictBatchSize {
+			err = stream.Send(&pb.TermDictionaryResult{Entries: entries})
+			if err != nil {
+				return err
+			}
+			entries = make([]*pb.TermDictionaryEntry, 0, termDictBatchSize)
+		}
+	}
+
+	if len(entries) > 0 {
+		return stream.Send(&pb.TermDictionaryResult{Entries: entries})
+	}
+
+	return nil
+}
+
+// mergedFieldDict merges the sorted terms of several field
+// dictionaries, summing up the counts of the identical terms.
+type mergedFieldDict struct {
+	dicts []index.FieldDict
+	heads []*index.DictEntry // The next entry of each dict, if any.
+}
+
+func (d *mergedFieldDict) Next() (*index.DictEntry, error) {
+	if d.heads == nil {
+		d.heads = make([]*index.DictEntry, len(d.dicts))
+		for i, dict := range d.dicts {
+			head, err := dict.Next()
+			if err != nil {
+				return nil, err
+			}
+			d.heads[i] = head
+		}
+	}
+
+	var min *index.DictEntry
+	for _, head := range d.heads {
+		if head != nil && (min == nil || head.Term < min.Term) {
+			min = head
+		}
+	}
+	if min == nil {
+		return nil, nil
+	}
+
+	rv := &index.DictEntry{Term: min.Term}
+
+	// the dicts may reuse their entries, so the counts are summed up
+	// before advancing the dicts
+	for i, head := range d.heads {
+		if head != nil && head.Term == rv.Term {
+			rv.Count += head.Count
+
+			next, err := d.dicts[i].Next()
+			if err != nil {
+				return nil, err
+			}
+			d.heads[i] = next
+		}
+	}
+
+	return rv, nil
+}
+
+func (d *mergedFieldDict) Close() error {
+	var rv error
+	for _, dict := range d.dicts {
+		if err := dict.Close(); err != nil && rv == nil {
+			rv = err
+		}
+	}
+	return rv
+}
+
+// unionFields returns the sorted, distinct fields of several pindexes,
+// which may each have a different set of fields.
+func unionFields(pindexesFields [][]string) []string {
+	seen := map[string]struct{}{}
+	for _, fields := range pindexesFields {
+		for _, field := range fields {
+			seen[field] = struct{}{}
+		}
+	}
+
+	rv := make([]string, 0, len(seen))
+	for field := range seen {
+		rv = append(rv, field)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// localBleveIndex returns the bleve index of a local pindex, which
+// must belong to the index of the indexUUID, when given.
+func (s *SearchService) localBleveIndex(pindexName, indexUUID string) (
+	bleve.Index, error) {
+	pindex := s.mgr.GetPIndex(pindexName)
+	if pindex == nil {
+		return nil, fmt.Errorf("grpc_server: localBleveIndex, no pindex,"+
+			" pindexName: %s", pindexName)
+	}
+
+	if indexUUID != "" && pindex.IndexUUID != indexUUID {
+		return nil, fmt.Errorf("grpc_server: localBleveIndex, wrong"+
+			" indexUUID: %s, pindex.IndexUUID: %s, pindexName: %s",
+			indexUUID, pindex.IndexUUID, pindexName)
+	}
+
+	bindex, _, _, err := bleveIndex(pindex)
+	return bindex, err
+}
+
+// pindexFieldTypes returns the types of the fields of a local pindex,
+// keyed by field name, where the indexed fields that aren't explicitly
+// mapped have the type "dynamic".
+func (s *SearchService) pindexFieldTypes(pindexName, indexUUID string) (
+	map[string]string, error) {
+	bindex, err := s.localBleveIndex(pindexName, indexUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := map[string]string{}
+
+	if im, ok := bindex.Mapping().(*mapping.IndexMappingImpl); ok {
+		addFieldTypes(rv, nil, im.DefaultMapping)
+		for _, dm := range im.TypeMapping {
+			addFieldTypes(rv, nil, dm)
+		}
+	}
+
+	fields, err := bindex.Fields()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if _, exists := rv[field]; !exists && !strings.HasPrefix(field, "_") {
+			rv[field] = "dynamic"
+		}
+	}
+
+	return rv, nil
+}
+
+// addFieldTypes adds the types of the fields of a document mapping,
+// recursively, following bleve's field naming rules.
+func addFieldTypes(rv map[string]string, path []string,
+	dm *mapping.DocumentMapping) {
+	if dm == nil || !dm.Enabled {
+		return
+	}
+
+	for _, fm := range dm.Fields {
+		fieldName := strings.Join(path, ".")
+		if fm.Name != "" && len(path) > 0 {
+			fieldName = strings.Join(append(path[:len(path)-1:len(path)-1],
+				fm.Name), ".")
+		} else if fm.Name != "" {
+			fieldName = fm.Name
+		}
+		if fieldName != "" {
+			rv[fieldName] = fm.Type
+		}
+	}
+
+	for name, subDM := range dm.Properties {
+		addFieldTypes(rv, append(path[:len(path):len(path)], name), subDM)
+	}
+}
+
+// reconcileFieldTypes merges the field types of several pindexes,
+// separating the fields whose types differ across the pindexes.
+func reconcileFieldTypes(pindexesFieldTypes []map[string]string) (
+	map[string]string, map[string]*pb.FieldTypes) {
+	types := map[string]map[string]bool{}
+	for _, fieldTypes := range pindexesFieldTypes {
+		for field, fieldType := range fieldTypes {
+			if types[field] == nil {
+				types[field] = map[string]bool{}
+			}
+			types[field][fieldType] = true
+		}
+	}
+
+	var rv map[string]string
+	var conflicts map[string]*pb.FieldTypes
+	for field, fieldTypes := range types {
+		if len(fieldTypes) == 1 {
+			if rv == nil {
+				rv = map[string]string{}
+			}
+			for fieldType := range fieldTypes {
+				rv[field] = fieldType
+			}
+			continue
+		}
+
+		if conflicts == nil {
+			conflicts = map[string]*pb.FieldTypes{}
+		}
+		ft := &pb.FieldTypes{}
+		for fieldType := range fieldTypes {
+			ft.Types = append(ft.Types, fieldType)
+		}
+		sort.Strings(ft.Types)
+		conflicts[field] = ft
+	}
+
+	return rv, conflicts
 }
 
 func (s *SearchService) Search(req *pb.SearchRequest,
@@ -107,12 +615,33 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 		updateRpcFocusStats(startTime, s.mgr, req, stream.Context(), err)
 	}()
 
+	// the client tells the replicas apart by the node that served them
+	setServedBy(stream, s.mgr)
+
 	err = verifyRPCAuth(stream.Context(), req.IndexName, req)
 	if err != nil {
 		return status.Errorf(codes.PermissionDenied,
 			"grpc_server: Search err: %v", err)
 	}
 
+	labels, err := queryLabelsFromMetadata(stream.Context())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"grpc_server: Search err: %v", err)
+	}
+	priority, err := queryPriorityFromMetadata(stream.Context())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"grpc_server: Search err: %v", err)
+	}
+
+	if len(labels) > 0 {
+		_, er := extractMetaHeader(stream.Context(), rpcClusterActionKey)
+		defer func() {
+			updateQueryLabelStats(labels, er == nil, time.Since(startTime), err)
+		}()
+	}
+
 	queryCtlParams := cbgt.QueryCtlParams{
 		Ctl: cbgt.QueryCtl{
 			Timeout: cbgt.QUERY_CTL_DEFAULT_TIMEOUT_MS,
@@ -149,11 +678,23 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 			"grpc_server: Search processing searchRequest, err: %v", err)
 	}
 
+	// leave out the stored fields of the streamed hits, when asked to,
+	// where the scatter-gather requests then don't load them either
+	if req.Stream && !sr.streamsStoredFields() {
+		searchRequest.Fields = nil
+	}
+
+	// only the coordinator of a query transforms its hits
+	hv, _ := extractMetaHeader(stream.Context(), rpcClusterActionKey)
+	var transform HitTransform
+	if !isScatterGatherClusterAction(hv) {
+		transform = getHitTransform()
+	}
+
 	// pre process the query if applicable
 	var undecoratedQuery query.Query
 	if strings.Compare(cbgt.CfgAppVersion, "7.0.0") >= 0 {
-		hv, _ := extractMetaHeader(stream.Context(), rpcClusterActionKey)
-		if hv != clusterActionScatterGather {
+		if !isScatterGatherClusterAction(hv) {
 			undecoratedQuery, searchRequest.Query = sr.decorateQuery(req.IndexName,
 				searchRequest.Query, nil)
 		}
@@ -191,6 +732,23 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 		}
 	}
 
+	err = validateFacetLimits(s.mgr, searchRequest)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"grpc_server: Search validating facets, err: %v", err)
+	}
+
+	// abort at the deadline of the client, if any
+	if v, er := extractMetaHeader(stream.Context(), rpcDeadlineKey); er == nil {
+		var timeout int64
+		timeout, err = deadlineTimeout(v, queryCtlParams.Ctl.Timeout)
+		if err != nil {
+			return status.Errorf(codes.DeadlineExceeded,
+				"grpc_server: Search err: %v", err)
+		}
+		queryCtlParams.Ctl.Timeout = timeout
+	}
+
 	// phase 1 - set up timeouts, wait for local consistency reqiurements
 	// to be satisfied, could return err 412
 
@@ -200,14 +758,72 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 	// setupContextAndCancelCh always exits
 	defer cancel()
 
+	cancelOnStreamDone(stream.Context(), ctx, cancel)
+
+	// forward the labels, the priority and the request ID to the remote
+	// servers
+	if len(labels) > 0 {
+		ctx = context.WithValue(ctx, queryLabelsKey, labels)
+	}
+	if priority != QueryPriorityHigh {
+		ctx = WithQueryPriority(ctx, priority)
+	}
+	if requestID := requestIDFromContext(stream.Context()); requestID != "" {
+		ctx = WithRequestID(ctx, requestID)
+	}
+
+	// let the client abandon the pindexes of a flow controlled search,
+	// unless it expects complete results
+	if fs, ok := stream.(*flowControlledSearchServer); ok &&
+		fs.abandonment != nil &&
+		(queryCtlParams.Ctl.Consistency == nil ||
+			queryCtlParams.Ctl.Consistency.Results != "complete") {
+		ctx = withPIndexAbandonment(ctx, fs.abandonment)
+	}
+
 	var onlyPIndexes map[string]bool
 	if len(queryPIndexes.PIndexNames) > 0 {
 		onlyPIndexes = cbgt.StringsToMap(queryPIndexes.PIndexNames)
 	}
 
+	aliasStartTime := time.Now()
+
 	alias, remoteClients, numPIndexes, er := bleveIndexAlias(s.mgr, req.IndexName,
 		req.IndexUUID, true, queryCtlParams.Ctl.Consistency, cancelCh, true,
 		onlyPIndexes, queryCtlParams.Ctl.PartitionSelection, addGrpcClients)
+	defer closeRemoteClients(remoteClients)
+
+	// report the consistency wait outcome back to the client
+	if queryCtlParams.Ctl.Consistency != nil &&
+		len(queryCtlParams.Ctl.Consistency.Vectors) > 0 {
+		setConsistencyWaitTrailer(stream, er, time.Since(aliasStartTime))
+		setConsistencyVectorsTrailer(stream, s.mgr, req.IndexName,
+			onlyPIndexes, queryCtlParams.Ctl.Consistency)
+	}
+
+	// a grouped search fails only on the pindexes that fail on their own
+	var pindexErrors []*pb.PIndexError
+	if er != nil {
+		if _, ok := er.(*cbgt.ErrorLocalPIndexHealth); !ok &&
+			canIsolatePIndexes(queryPIndexes.PIndexNames, &queryCtlParams, er) {
+			ialias, irc, inum, ipe, ier := isolatePIndexes(
+				queryPIndexes.PIndexNames,
+				func(only map[string]bool) (bleve.IndexAlias,
+					[]RemoteClient, int, error) {
+					return bleveIndexAlias(s.mgr, req.IndexName,
+						req.IndexUUID, true, queryCtlParams.Ctl.Consistency,
+						cancelCh, true, only,
+						queryCtlParams.Ctl.PartitionSelection, addGrpcClients)
+				})
+			defer closeRemoteClients(irc)
+			// the whole group fails as before, when all its pindexes do
+			if ier == nil {
+				alias, remoteClients, numPIndexes, pindexErrors, er =
+					ialias, irc, inum, ipe, nil
+			}
+		}
+	}
+
 	if er != nil {
 		if _, ok := er.(*cbgt.ErrorLocalPIndexHealth); !ok {
 			err = status.Errorf(codes.Unavailable,
@@ -216,11 +832,19 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 		}
 	}
 
+	// compress the larger responses, if the client can decode them
+	compressMinBytes := -1
+	if acceptsGzipContents(stream.Context()) {
+		compressMinBytes = grpcCompressionMinBytes(s.mgr)
+	}
+
 	var sh *streamer
 	var handlerMaker search.MakeDocumentMatchHandler
 	// check if the client requested streamed results/hits.
 	if req.Stream {
 		sh = newStreamHandler(req.IndexName, searchRequest, stream)
+		sh.transform = transform
+		sh.compressMinBytes = compressMinBytes
 		handlerMaker = sh.MakeDocumentMatchHandler
 		ctx = context.WithValue(ctx, search.MakeDocumentMatchHandlerKey,
 			handlerMaker)
@@ -231,17 +855,58 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 		}
 	}
 
+	// relay the facets of the pindexes as they complete, if asked for
+	var facetRelay *facetSnapshots
+	if req.StreamFacets && len(searchRequest.Facets) > 0 {
+		send := stream.Send
+		if sh != nil {
+			send = func(m *pb.StreamSearchResults) error {
+				return sh.send(ctx, m)
+			}
+		}
+		ctx, facetRelay = withFacetRelay(ctx, func(facets []byte) error {
+			return send(&pb.StreamSearchResults{
+				Contents: &pb.StreamSearchResults_Facets{Facets: facets},
+			})
+		})
+	}
+
 	// estimate memory needed for merging search results from all
 	// the pindexes
 	mergeEstimate := uint64(numPIndexes) * bleve.MemoryNeededForSearchResult(searchRequest)
-	err = fireQueryEvent(0, EventQueryStart, 0, mergeEstimate)
+	// account for the compression buffers of the gRPC streams
+	mergeEstimate = addGrpcCompressionAllowance(s.mgr, mergeEstimate)
+	// calibrate the estimate by the earlier queries of the index
+	mergeEstimate = correctQueryEstimate(req.IndexName, mergeEstimate)
+	queryEvent := QueryEvent{
+		Kind:      EventQueryStart,
+		IndexName: req.IndexName,
+		Priority:  priority,
+		Admission: &QueryAdmission{},
+	}
+	err = fireQueryEvent(0, queryEvent, mergeEstimate)
 	if err != nil {
 		atomic.AddUint64(&totGrpcQueryRejectOnNotEnoughQuota, 1)
+		// the client decodes the reason from the trailer
+		if qre, ok := err.(*QueryRejectedError); ok {
+			setQueryRejectedTrailer(stream, qre)
+			return status.Errorf(qre.GRPCStatus().Code(),
+				"grpc_server: Search query reject on not enough quota: %v", err)
+		}
 		return status.Errorf(codes.ResourceExhausted,
 			"grpc_server: Search query reject on not enough quota: %v", err)
 	}
 
-	defer fireQueryEvent(0, EventQueryEnd, 0, mergeEstimate)
+	queryEvent.Kind = EventQueryEnd
+	var searchResult *bleve.SearchResult
+	defer func() {
+		// the size of the merged results is what the estimate of
+		// the query is of
+		if searchResult != nil {
+			queryEvent.ResultSize = uint64(searchResult.Size())
+		}
+		fireQueryEvent(0, queryEvent, mergeEstimate)
+	}()
 
 	// set query start/end callbacks
 	ctx = context.WithValue(ctx, bleve.SearchQueryStartCallbackKey,
@@ -260,8 +925,40 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 	})
 	defer querySupervisor.DeleteEntry(id)
 
-	var searchResult *bleve.SearchResult
+	// track the per-pindex hit counts, if the client asked for them
+	var hitCounts *pindexHitCounts
+	if _, er := extractMetaHeader(stream.Context(),
+		rpcPIndexHitCountsKey); er == nil {
+		hitCounts = &pindexHitCounts{}
+		ctx = withPIndexHitCounts(ctx, hitCounts)
+
+		for _, pe := range pindexErrors {
+			hitCounts.setReason(pe.PIndexName, PIndexStatusUnavailable)
+		}
+	}
+
 	searchResult, err = alias.SearchInContext(ctx, searchRequest)
+	if facetRelay != nil {
+		// no facets may follow the results, as of the pindexes that
+		// complete after a timeout
+		facetRelay.close()
+	}
+	if sh != nil {
+		if er := sh.TransformErr(); er != nil {
+			return status.Errorf(codes.Internal,
+				"grpc_server: Search aborted, err: %v", er)
+		}
+	} else if transform != nil && searchResult != nil {
+		if er := transformHits(transform, searchResult.Hits); er != nil {
+			return status.Errorf(codes.Internal,
+				"grpc_server: Search aborted, err: %v", er)
+		}
+	}
+	if hitCounts != nil {
+		if trailer, er := hitCounts.trailer(); er == nil {
+			stream.SetTrailer(trailer)
+		}
+	}
 	if searchResult != nil {
 		// if the query decoration happens for collection targeted or docID
 		// queries for multi collection indexes, then restore the original
@@ -271,6 +968,10 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 		}
 		err1 := processSearchResult(&queryCtlParams, req.IndexName, searchResult,
 			remoteClients, err, er)
+		if _, ok := err1.(*ErrorNoHealthyNodes); ok {
+			return status.Error(codes.Unavailable,
+				fmt.Sprintf("grpc_server: Search searchInContext err: %v", err1))
+		}
 		if err1 != nil {
 			err = status.Error(codes.DeadlineExceeded,
 				fmt.Sprintf("grpc_server: Search searchInContext err: %v", err1))
@@ -293,12 +994,29 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 			return err
 		}
 
+		response, encoding, er2 := encodeContents(response, compressMinBytes)
+		if er2 != nil {
+			err = status.Errorf(codes.Internal,
+				"grpc_server: Search response compress err: %v", er2)
+			return err
+		}
+
 		rv := &pb.StreamSearchResults{
 			Contents: &pb.StreamSearchResults_SearchResult{
 				SearchResult: response,
-			}}
+			},
+			ContentEncoding: encoding,
+			PIndexErrors:    pindexErrors,
+		}
 
-		if err = stream.Send(rv); err != nil {
+		// serialized with the sends of the hits that may be in flight
+		send := stream.Send
+		if sh != nil {
+			send = func(m *pb.StreamSearchResults) error {
+				return sh.send(ctx, m)
+			}
+		}
+		if err = send(rv); err != nil {
 			return status.Errorf(codes.Internal,
 				"grpc_server: Search stream send, err: %v", err)
 		}
@@ -307,6 +1025,156 @@ func (s *SearchService) Search(req *pb.SearchRequest,
 	return err
 }
 
+// GrpcBatchSearchConcurrency bounds how many of the search requests of
+// a batch run at a time, where the rest wait for their turn.
+var GrpcBatchSearchConcurrency = 4
+
+// BatchSearch runs the search requests of a batch concurrently, each as
+// a Search of its own, up to the GrpcBatchSearchConcurrency at a time,
+// and streams back their results tagged with the positions of the
+// requests, where the headers and trailers of the requests, like their
+// pindex hit counts, aren't sent back.
+func (s *SearchService) BatchSearch(req *pb.BatchSearchRequest,
+	stream pb.SearchService_BatchSearchServer) error {
+	if req == nil || len(req.Requests) == 0 {
+		return status.Error(codes.FailedPrecondition,
+			"grpc_server: BatchSearch empty batch search request")
+	}
+
+	var m sync.Mutex // Serializes the sends of the concurrent searches.
+	var sendErr error
+
+	send := func(rv *pb.BatchSearchResult) error {
+		m.Lock()
+		defer m.Unlock()
+		if sendErr == nil {
+			sendErr = stream.Send(rv)
+		}
+		return sendErr
+	}
+
+	forEachBounded(len(req.Requests), GrpcBatchSearchConcurrency,
+		func(i int) {
+			err := s.Search(req.Requests[i], &batchSearchStream{
+				ServerStream: stream,
+				requestIndex: uint32(i),
+				send:         send,
+			})
+
+			done := &pb.BatchSearchResult{RequestIndex: uint32(i), Done: true}
+			if err != nil {
+				st := status.Convert(err)
+				done.Code = uint32(st.Code())
+				done.Error = st.Message()
+			}
+			send(done)
+		})
+
+	if sendErr != nil {
+		return status.Errorf(codes.Internal,
+			"grpc_server: BatchSearch stream send, err: %v", sendErr)
+	}
+
+	return nil
+}
+
+// forEachBounded calls the f for each of the n positions concurrently,
+// up to the limit at a time, where a limit below 1 means 1, and returns
+// once all the calls have.
+func forEachBounded(n, limit int, f func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// batchSearchStream is the stream of a request of a batch, which tags
+// the results of the request with its position in the batch.
+type batchSearchStream struct {
+	grpc.ServerStream
+
+	requestIndex uint32
+	send         func(*pb.BatchSearchResult) error
+}
+
+func (s *batchSearchStream) Send(rv *pb.StreamSearchResults) error {
+	return s.send(&pb.BatchSearchResult{
+		RequestIndex: s.requestIndex,
+		Results:      rv,
+	})
+}
+
+func (s *batchSearchStream) SetHeader(metadata.MD) error  { return nil }
+func (s *batchSearchStream) SendHeader(metadata.MD) error { return nil }
+func (s *batchSearchStream) SetTrailer(metadata.MD)       {}
+
+// cancelOnStreamDone cancels the ctx of a search once its stream is
+// done, as when the client cancels it or goes away, so that the search
+// stops rather than run to completion, as the ctx of the search isn't
+// derived from the ctx of the stream.
+func cancelOnStreamDone(streamCtx, ctx context.Context,
+	cancel context.CancelFunc) {
+	go func() {
+		select {
+		case <-streamCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// deadlineTimeout returns the timeout, in milliseconds, of a query
+// whose client sent the given rpcDeadlineKey, which is the earlier of
+// the deadline and the query's own timeout.
+func deadlineTimeout(v string, timeout int64) (int64, error) {
+	nanos, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("grpc_server: parsing deadline: %q, err: %v",
+			v, err)
+	}
+
+	remaining := time.Until(time.Unix(0, nanos))
+	if remaining < time.Millisecond {
+		return 0, fmt.Errorf("grpc_server: deadline passed by: %v",
+			-remaining)
+	}
+
+	if ms := int64(remaining / time.Millisecond); timeout <= 0 || ms < timeout {
+		return ms, nil
+	}
+
+	return timeout, nil
+}
+
+// setConsistencyWaitTrailer sets the outcome and the duration of the
+// consistency wait as trailer metadata on the stream, so that the
+// scatter-gather client can account for it.
+func setConsistencyWaitTrailer(stream grpc.ServerStream, err error,
+	d time.Duration) {
+	outcome := consistencyWaitSucceeded
+	if _, ok := err.(*cbgt.ErrorConsistencyWait); ok {
+		outcome = consistencyWaitTimedout
+	}
+
+	stream.SetTrailer(metadata.Pairs(
+		rpcConsistencyWaitKey, outcome,
+		rpcConsistencyWaitDurationKey, strconv.FormatInt(int64(d), 10)))
+}
+
 // TODO chaining of unary & stream interceptors can be done
 // if neeeded for more stats/request tracking or debugging.
 // eg: https://github.com/grpc-ecosystem/go-grpc-middleware
@@ -354,17 +1222,37 @@ func serverInterceptor(
 	ss grpc.ServerStream,
 	info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler) (err error) {
+	// correlate with the request ID of the client, or else with a new
+	// one for the requests that fan out from here, echoing it back
+	// along with the protocol version of the server
+	requestID := requestIDFromMetadata(ss.Context())
+	if requestID == "" {
+		requestID = cbgt.NewUUID()
+	}
+	ss.SetHeader(metadata.Pairs(rpcRequestIDKey, requestID,
+		rpcProtocolVersionKey, grpcProtocolVersionStr))
+	ctx := WithRequestID(ss.Context(), requestID)
+
 	// skip the authCallbacks wrapping/authentication for scatter gather calls,
-	// as the user is already authenticated at the original node.
-	if _, err = extractMetaHeader(ss.Context(), rpcClusterActionKey); err == nil {
+	// as the user is already authenticated at the original node, other
+	// than to verify the auth of the user that the call may carry.
+	if _, err = extractMetaHeader(ctx, rpcClusterActionKey); err == nil {
+		nctx, err := wrapQueryAuthCallbacks(req, ctx, info.FullMethod)
+		if err != nil {
+			log.Errorf("grpc_server: query auth err: %+v, requestID: %s",
+				err, requestID)
+			return err
+		}
+
 		w := wrapServerStream(ss)
-		w.wrappedContext = ss.Context()
+		w.wrappedContext = nctx
 		return handler(req, w)
 	}
 
-	nctx, err := wrapAuthCallbacks(req, ss.Context(), info.FullMethod)
+	nctx, err := wrapAuthCallbacks(req, ctx, info.FullMethod)
 	if err != nil {
-		log.Errorf("grpc_server: authenticate err: %+v", err)
+		log.Errorf("grpc_server: authenticate err: %+v, requestID: %s",
+			err, requestID)
 		return err
 	}
 
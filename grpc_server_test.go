@@ -0,0 +1,275 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	pb "github.com/couchbase/cbft/protobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddFieldTypes(t *testing.T) {
+	addr := mapping.NewDocumentMapping()
+	addr.AddFieldMappingsAt("city", mapping.NewTextFieldMapping())
+	zip := mapping.NewNumericFieldMapping()
+	zip.Name = "zipcode"
+	addr.AddFieldMappingsAt("zip", zip)
+
+	dm := mapping.NewDocumentMapping()
+	dm.AddFieldMappingsAt("age", mapping.NewNumericFieldMapping())
+	dm.AddSubDocumentMapping("addr", addr)
+
+	disabled := mapping.NewDocumentDisabledMapping()
+	dm.AddSubDocumentMapping("ignored", disabled)
+
+	rv := map[string]string{}
+	addFieldTypes(rv, nil, dm)
+
+	exp := map[string]string{
+		"age":          "number",
+		"addr.city":    "text",
+		"addr.zipcode": "number",
+	}
+	if !reflect.DeepEqual(rv, exp) {
+		t.Errorf("expected field types: %v, got: %v", exp, rv)
+	}
+}
+
+func TestReconcileFieldTypes(t *testing.T) {
+	fieldTypes, conflicts := reconcileFieldTypes([]map[string]string{
+		{"name": "text", "age": "number", "born": "datetime"},
+		{"name": "text", "age": "text"},
+		{"age": "number", "born": "text"},
+	})
+
+	if !reflect.DeepEqual(fieldTypes, map[string]string{"name": "text"}) {
+		t.Errorf("expected only name to agree, got: %v", fieldTypes)
+	}
+	if len(conflicts) != 2 ||
+		!reflect.DeepEqual(conflicts["age"].Types, []string{"number", "text"}) ||
+		!reflect.DeepEqual(conflicts["born"].Types, []string{"datetime", "text"}) {
+		t.Errorf("expected conflicts for age and born, got: %v", conflicts)
+	}
+}
+
+func TestUnionFields(t *testing.T) {
+	fields := unionFields([][]string{
+		{"name", "age", "_all"},
+		{"age", "born"},
+		nil,
+	})
+
+	exp := []string{"_all", "age", "born", "name"}
+	if !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected fields: %v, got: %v", exp, fields)
+	}
+
+	if fields = unionFields(nil); len(fields) != 0 {
+		t.Errorf("expected no fields, got: %v", fields)
+	}
+}
+
+func TestDumpResult(t *testing.T) {
+	rv := dumpResult("p1", &dumpRow{key: []byte("k"), value: []byte("v")})
+	if rv.PIndexName != "p1" || string(rv.Key) != "k" ||
+		string(rv.Value) != "v" || rv.Error != "" {
+		t.Errorf("expected the row, got: %+v", rv)
+	}
+
+	rv = dumpResult("p1", fmt.Errorf("dump failed"))
+	if rv.Error != "dump failed" || rv.Key != nil {
+		t.Errorf("expected the err, got: %+v", rv)
+	}
+
+	if rv = dumpResult("p1", 42); rv.Error == "" {
+		t.Errorf("expected an err for an unknown row type")
+	}
+}
+
+// sliceFieldDict is an index.FieldDict over sorted entries, which
+// reuses its entry, like the scorch field dictionaries do.
+type sliceFieldDict struct {
+	entries []index.DictEntry
+	entry   index.DictEntry
+	closed  bool
+}
+
+func (d *sliceFieldDict) Next() (*index.DictEntry, error) {
+	if len(d.entries) == 0 {
+		return nil, nil
+	}
+	d.entry = d.entries[0]
+	d.entries = d.entries[1:]
+	return &d.entry, nil
+}
+
+func (d *sliceFieldDict) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestMergedFieldDict(t *testing.T) {
+	d1 := &sliceFieldDict{entries: []index.DictEntry{
+		{Term: "apple", Count: 1}, {Term: "cherry", Count: 2},
+	}}
+	d2 := &sliceFieldDict{}
+	d3 := &sliceFieldDict{entries: []index.DictEntry{
+		{Term: "banana", Count: 4}, {Term: "cherry", Count: 3},
+		{Term: "date", Count: 5},
+	}}
+	dict := &mergedFieldDict{
+		dicts: []index.FieldDict{d1, d2, d3},
+	}
+
+	var entries []index.DictEntry
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, *entry)
+	}
+
+	exp := []index.DictEntry{
+		{Term: "apple", Count: 1}, {Term: "banana", Count: 4},
+		{Term: "cherry", Count: 5}, {Term: "date", Count: 5},
+	}
+	if !reflect.DeepEqual(entries, exp) {
+		t.Errorf("expected the merged terms: %v, got: %v", exp, entries)
+	}
+
+	dict.Close()
+	if !d1.closed || !d2.closed || !d3.closed {
+		t.Errorf("expected all the dicts to be closed")
+	}
+}
+
+func TestDeadlineTimeout(t *testing.T) {
+	in2s := strconv.FormatInt(time.Now().Add(2*time.Second).UnixNano(), 10)
+	ago := strconv.FormatInt(time.Now().Add(-time.Second).UnixNano(), 10)
+
+	tests := []struct {
+		deadline   string
+		timeout    int64
+		expTimeout int64
+		expErr     bool
+	}{
+		{in2s, 10000, 2000, false},
+		{in2s, 0, 2000, false},
+		{in2s, 500, 500, false},
+		{ago, 10000, 0, true},
+		{"soon", 10000, 0, true},
+	}
+
+	for _, test := range tests {
+		timeout, err := deadlineTimeout(test.deadline, test.timeout)
+		if (err != nil) != test.expErr {
+			t.Errorf("expected err: %v, got: %v, test: %+v",
+				test.expErr, err, test)
+		}
+		if timeout > test.expTimeout || timeout < test.expTimeout-1000 {
+			t.Errorf("expected timeout of about %dms, got: %dms, test: %+v",
+				test.expTimeout, timeout, test)
+		}
+	}
+}
+
+// capturingBatchSearchServer is a pb.SearchService_BatchSearchServer
+// that captures the sent results.
+type capturingBatchSearchServer struct {
+	grpc.ServerStream
+	results []*pb.BatchSearchResult
+}
+
+func (s *capturingBatchSearchServer) Send(rv *pb.BatchSearchResult) error {
+	s.results = append(s.results, rv)
+	return nil
+}
+
+func TestSearchServiceBatchSearch(t *testing.T) {
+	s := &SearchService{}
+
+	if err := s.BatchSearch(&pb.BatchSearchRequest{},
+		&capturingBatchSearchServer{}); status.Code(err) !=
+		codes.FailedPrecondition {
+		t.Errorf("expected an empty batch to be rejected, err: %v", err)
+	}
+
+	// the requests fail on their own, each with a last message
+	stream := &capturingBatchSearchServer{}
+	err := s.BatchSearch(&pb.BatchSearchRequest{
+		Requests: []*pb.SearchRequest{nil, nil},
+	}, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[uint32]bool{}
+	for _, rv := range stream.results {
+		if !rv.Done || rv.Code != uint32(codes.FailedPrecondition) {
+			t.Errorf("expected the failure of the request, got: %v", rv)
+		}
+		seen[rv.RequestIndex] = true
+	}
+	if len(stream.results) != 2 || !seen[0] || !seen[1] {
+		t.Errorf("expected a last message per request, got: %v",
+			stream.results)
+	}
+}
+
+func TestForEachBounded(t *testing.T) {
+	var running, maxRunning, calls int64
+	release := make(chan struct{})
+
+	doneCh := make(chan struct{})
+	go func() {
+		forEachBounded(10, 3, func(i int) {
+			n := atomic.AddInt64(&running, 1)
+			for {
+				m := atomic.LoadInt64(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt64(&running, -1)
+			atomic.AddInt64(&calls, 1)
+		})
+		close(doneCh)
+	}()
+
+	for i := 0; i < 10; i++ {
+		release <- struct{}{}
+	}
+	<-doneCh
+
+	if calls != 10 || maxRunning > 3 {
+		t.Errorf("expected 10 calls, 3 at a time, got: %d, max: %d",
+			calls, maxRunning)
+	}
+}
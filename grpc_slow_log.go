@@ -0,0 +1,140 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// GrpcClientSlowThreshold, when non-zero, is the latency above which
+// the successful rpc's of the gRPC client are logged one by one, while
+// the faster ones are only logged as a periodic summary.  It's
+// overridable by the "grpcClientSlowThreshold" option, where 0 keeps
+// the successful rpc's from being logged, unless verbose.
+var GrpcClientSlowThreshold time.Duration
+
+// GrpcClientSlowSummaryInterval is the interval of the summaries of the
+// rpc's under the GrpcClientSlowThreshold, which is overridable by the
+// "grpcClientSlowSummaryInterval" option.
+var GrpcClientSlowSummaryInterval = time.Minute
+
+// grpcLatencySamples is the size of the sample of the latencies of the
+// rpc's of a summary interval, out of which the p99 is estimated.
+const grpcLatencySamples = 1024
+
+// totGrpcClientSlowCalls tracks the rpc's over the slow threshold.
+var totGrpcClientSlowCalls uint64
+
+// grpcLatencySummary is the summary of the rpc's under the slow
+// threshold over an interval.
+type grpcLatencySummary struct {
+	Count    uint64
+	Max      time.Duration
+	P99      time.Duration
+	Interval time.Duration
+}
+
+// grpcLatencySampler accumulates the rpc's of a summary interval, with
+// a reservoir sample of their latencies.
+type grpcLatencySampler struct {
+	m       sync.Mutex
+	start   time.Time
+	count   uint64
+	max     time.Duration
+	samples []time.Duration
+}
+
+var grpcClientLatencySampler grpcLatencySampler
+
+// add accounts an rpc, returning the summary of the interval that
+// ended before it, if any.
+func (s *grpcLatencySampler) add(d time.Duration, now time.Time,
+	interval time.Duration) (*grpcLatencySummary, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var rv *grpcLatencySummary
+	if s.start.IsZero() {
+		s.start = now
+	} else if elapsed := now.Sub(s.start); elapsed >= interval {
+		if s.count > 0 {
+			rv = &grpcLatencySummary{
+				Count:    s.count,
+				Max:      s.max,
+				P99:      latencyPercentile(s.samples, 0.99),
+				Interval: elapsed,
+			}
+		}
+		s.start = now
+		s.count = 0
+		s.max = 0
+		s.samples = s.samples[:0]
+	}
+
+	s.count++
+	if d > s.max {
+		s.max = d
+	}
+	if len(s.samples) < grpcLatencySamples {
+		s.samples = append(s.samples, d)
+	} else if i := rand.Int63n(int64(s.count)); i < grpcLatencySamples {
+		s.samples[i] = d
+	}
+
+	return rv, rv != nil
+}
+
+// latencyPercentile returns the latency at the percentile p, from 0 to
+// 1, of the samples, which are sorted in place.
+func latencyPercentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i]
+}
+
+// grpcClientSlowCall returns whether a successful rpc is over the slow
+// threshold, so is to be logged on its own, where the faster rpc's are
+// accounted into the periodic summary, which is logged as its interval
+// ends.
+func grpcClientSlowCall(d time.Duration) bool {
+	if GrpcClientSlowThreshold <= 0 {
+		return false
+	}
+
+	if d >= GrpcClientSlowThreshold {
+		atomic.AddUint64(&totGrpcClientSlowCalls, 1)
+		return true
+	}
+
+	if summary, ok := grpcClientLatencySampler.add(d, time.Now(),
+		GrpcClientSlowSummaryInterval); ok {
+		log.Printf("grpc_client: rpc latency summary, %s",
+			logFields("calls", summary.Count, "max", summary.Max,
+				"p99", summary.P99, "interval", summary.Interval,
+				"slowThreshold", GrpcClientSlowThreshold))
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGrpcLatencySampler(t *testing.T) {
+	var s grpcLatencySampler
+
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		if _, ok := s.add(time.Duration(i)*time.Millisecond, now,
+			time.Minute); ok {
+			t.Fatalf("expected no summary within the interval")
+		}
+	}
+
+	// the first rpc after the interval ends it
+	summary, ok := s.add(time.Millisecond, now.Add(time.Minute), time.Minute)
+	if !ok {
+		t.Fatalf("expected the summary of the interval")
+	}
+	if summary.Count != 100 || summary.Max != 100*time.Millisecond ||
+		summary.P99 != 99*time.Millisecond ||
+		summary.Interval != time.Minute {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	// and starts the next one
+	if s.count != 1 || len(s.samples) != 1 {
+		t.Errorf("expected a fresh interval, count: %d, samples: %d",
+			s.count, len(s.samples))
+	}
+
+	// the sample is bounded
+	for i := 0; i < 2*grpcLatencySamples; i++ {
+		s.add(time.Millisecond, now.Add(time.Minute), time.Minute)
+	}
+	if len(s.samples) != grpcLatencySamples {
+		t.Errorf("expected a bounded sample, got: %d", len(s.samples))
+	}
+}
+
+func TestGrpcClientSlowCall(t *testing.T) {
+	defer func(v time.Duration) { GrpcClientSlowThreshold = v }(
+		GrpcClientSlowThreshold)
+
+	GrpcClientSlowThreshold = 0
+	if grpcClientSlowCall(time.Hour) {
+		t.Errorf("expected no slow rpc's without a threshold")
+	}
+
+	GrpcClientSlowThreshold = 100 * time.Millisecond
+	prev := atomic.LoadUint64(&totGrpcClientSlowCalls)
+
+	if grpcClientSlowCall(time.Millisecond) {
+		t.Errorf("expected the fast rpc to be summarized")
+	}
+	if !grpcClientSlowCall(time.Second) {
+		t.Errorf("expected the slow rpc to be logged")
+	}
+	if atomic.LoadUint64(&totGrpcClientSlowCalls) != prev+1 {
+		t.Errorf("expected the slow rpc to be counted")
+	}
+}
@@ -0,0 +1,246 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcClientTLSConfig returns the tls config of the gRPC clients, which
+// verifies the servers with the CA certs of the certInBytes.  When the
+// security settings have the servers authenticate the clients, the
+// clients present the key pair of the certFile and keyFile, which is
+// required to load when the client certs are mandatory.
+func grpcClientTLSConfig(ss *cbgt.SecuritySetting, certInBytes []byte,
+	certFile, keyFile string) (*tls.Config, error) {
+	// create a certificate pool from the CA
+	certPool := x509.NewCertPool()
+	// append the certificates from the CA
+	ok := certPool.AppendCertsFromPEM(certInBytes)
+	if !ok {
+		return nil, fmt.Errorf("grpc_util: failed to append ca certs")
+	}
+
+	config := &tls.Config{RootCAs: certPool}
+
+	if ss == nil || ss.ClientAuthType == nil ||
+		*ss.ClientAuthType == tls.NoClientCert {
+		return config, nil
+	}
+
+	required := *ss.ClientAuthType == tls.RequireAnyClientCert ||
+		*ss.ClientAuthType == tls.RequireAndVerifyClientCert
+
+	if certFile == "" || keyFile == "" {
+		if required {
+			return nil, fmt.Errorf("grpc_util: client certs are required," +
+				" but no client cert is configured")
+		}
+		return config, nil
+	}
+
+	loader := &clientCertLoader{certFile: certFile, keyFile: keyFile}
+
+	_, err := loader.load()
+	if err != nil {
+		if required {
+			return nil, fmt.Errorf("grpc_util: client certs are required,"+
+				" but the client cert can't be loaded, err: %v", err)
+		}
+		log.Warnf("grpc_util: client cert not loaded, err: %v", err)
+		return config, nil
+	}
+
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (
+		*tls.Certificate, error) {
+		return loader.load()
+	}
+
+	return config, nil
+}
+
+// clientCertLoader loads the client key pair of its files, which it
+// reloads once either file is modified, as when the certs are rotated,
+// so that the new handshakes present the latest client cert.
+type clientCertLoader struct {
+	certFile string
+	keyFile  string
+
+	m       sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (l *clientCertLoader) load() (*tls.Certificate, error) {
+	certInfo, err := os.Stat(l.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.cert != nil && certInfo.ModTime().Equal(l.certMod) &&
+		keyInfo.ModTime().Equal(l.keyMod) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.cert != nil {
+		log.Printf("grpc_util: client cert reloaded, certFile: %s",
+			l.certFile)
+	}
+
+	l.cert = &cert
+	l.certMod = certInfo.ModTime()
+	l.keyMod = keyInfo.ModTime()
+
+	return l.cert, nil
+}
+
+// grpcTLSServerNameKey is the key of the TLS server name of the nodes,
+// both in the extras of a node def and in the manager options.
+const grpcTLSServerNameKey = "grpcTLSServerName"
+
+// GrpcVerifyPeerCertificate, when set, is called by the gRPC clients
+// with the certs presented by the node of the hostPort, following their
+// regular verification, so that the certs may be further checked, such
+// as pinned, where an error fails the handshake.
+var GrpcVerifyPeerCertificate func(hostPort string, rawCerts [][]byte,
+	verifiedChains [][]*x509.Certificate) error
+
+// grpcTLSServerName returns the name that the certs of the node are
+// verified against, for when it differs from the host of the node, as
+// when the nodes are behind a shared load balancer or their certs have
+// the SANs of a proxy or a wildcard.  It's the grpcTLSServerName of the
+// extras of the node def, or else of the manager options, or else ""
+// to verify the certs against the host of the node.
+func grpcTLSServerName(mgr *cbgt.Manager, nodeDef *cbgt.NodeDef) string {
+	if nodeDef != nil {
+		v, err := nodeDef.GetFromParsedExtras(grpcTLSServerNameKey)
+		if s, ok := v.(string); err == nil && ok && s != "" {
+			return s
+		}
+	}
+
+	if mgr != nil {
+		return mgr.Options()[grpcTLSServerNameKey]
+	}
+
+	return ""
+}
+
+// validateGrpcTLSServerName returns an error when the server name can't
+// match the name of a cert, such as when it has a port.
+func validateGrpcTLSServerName(hostPort, serverName string) error {
+	if serverName == "" {
+		return nil
+	}
+
+	if strings.TrimSpace(serverName) != serverName ||
+		(strings.ContainsAny(serverName, " \t/:") &&
+			net.ParseIP(serverName) == nil) {
+		return fmt.Errorf("grpc_util: invalid %s: %q for host: %s, which"+
+			" must be a DNS name or an IP address without a port, as in"+
+			" the SANs of the cert of the node", grpcTLSServerNameKey,
+			serverName, hostPort)
+	}
+
+	return nil
+}
+
+// grpcClientTLSConfigFor returns the tls config of the gRPC clients of
+// the node of the hostPort, which verifies the certs of the node against
+// the serverName, when given, and with the GrpcVerifyPeerCertificate.
+func grpcClientTLSConfigFor(hostPort, serverName string,
+	config *tls.Config) (*tls.Config, error) {
+	err := validateGrpcTLSServerName(hostPort, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ServerName = serverName
+
+	if verify := GrpcVerifyPeerCertificate; verify != nil {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte,
+			verifiedChains [][]*x509.Certificate) error {
+			return verify(hostPort, rawCerts, verifiedChains)
+		}
+	}
+
+	return config, nil
+}
+
+// handshakeErrCreds wraps the TLS credentials of the gRPC clients, to
+// explain the failed handshakes with a node in terms of the server
+// name that its certs were verified against, as the misconfigured
+// server names otherwise only surface as unavailable nodes.
+type handshakeErrCreds struct {
+	credentials.TransportCredentials
+
+	hostPort   string
+	serverName string
+}
+
+func (c *handshakeErrCreds) ClientHandshake(ctx context.Context,
+	authority string, rawConn net.Conn) (
+	net.Conn, credentials.AuthInfo, error) {
+	if c.serverName != "" {
+		// the server name is verified as the authority of the handshake
+		authority = c.serverName
+	}
+
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx,
+		authority, rawConn)
+	if err != nil && ctx.Err() == nil {
+		serverName := c.serverName
+		if serverName == "" {
+			serverName = authority
+		}
+		err = fmt.Errorf("grpc_util: TLS handshake with host: %s failed,"+
+			" verifying the certs against the server name: %s, where the"+
+			" %s of the node def or of the manager options overrides the"+
+			" server name to match the SANs of the certs, err: %v",
+			c.hostPort, serverName, grpcTLSServerNameKey, err)
+		log.Warnf("%v", err)
+	}
+	return conn, authInfo, err
+}
+
+func (c *handshakeErrCreds) Clone() credentials.TransportCredentials {
+	return &handshakeErrCreds{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		hostPort:             c.hostPort,
+		serverName:           c.serverName,
+	}
+}
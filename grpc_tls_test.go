@@ -0,0 +1,275 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc/credentials"
+)
+
+// writeTestKeyPair writes a new self-signed cert and its key to the
+// files, and returns the PEM of the cert.
+func writeTestKeyPair(t *testing.T, certFile, keyFile string,
+	serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+		Bytes: keyDer})
+
+	if err = ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPEM
+}
+
+func TestGrpcClientTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpc_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "chain.pem")
+	keyFile := filepath.Join(dir, "pkey.key")
+	certInBytes := writeTestKeyPair(t, certFile, keyFile, 1)
+
+	settings := func(clientAuthType tls.ClientAuthType) *cbgt.SecuritySetting {
+		return &cbgt.SecuritySetting{ClientAuthType: &clientAuthType}
+	}
+
+	// the one-way handshake presents no client cert
+	config, err := grpcClientTLSConfig(settings(tls.NoClientCert),
+		certInBytes, certFile, keyFile)
+	if err != nil || config.GetClientCertificate != nil {
+		t.Errorf("expected no client cert, err: %v", err)
+	}
+
+	if _, err = grpcClientTLSConfig(nil, []byte("junk"), "", ""); err == nil {
+		t.Errorf("expected the invalid ca certs to be rejected")
+	}
+
+	// the mandatory client certs must be configured
+	_, err = grpcClientTLSConfig(settings(tls.RequireAndVerifyClientCert),
+		certInBytes, "", "")
+	if err == nil {
+		t.Errorf("expected the missing client cert to be rejected")
+	}
+	_, err = grpcClientTLSConfig(settings(tls.RequireAndVerifyClientCert),
+		certInBytes, certFile, filepath.Join(dir, "missing.key"))
+	if err == nil {
+		t.Errorf("expected the unloadable client cert to be rejected")
+	}
+
+	// but the optional ones may not be
+	config, err = grpcClientTLSConfig(settings(tls.VerifyClientCertIfGiven),
+		certInBytes, "", "")
+	if err != nil || config.GetClientCertificate != nil {
+		t.Errorf("expected no client cert, err: %v", err)
+	}
+
+	config, err = grpcClientTLSConfig(settings(tls.RequireAndVerifyClientCert),
+		certInBytes, certFile, keyFile)
+	if err != nil || config.GetClientCertificate == nil {
+		t.Fatalf("expected the client cert, err: %v", err)
+	}
+
+	cert, err := config.GetClientCertificate(nil)
+	if err != nil || len(cert.Certificate) == 0 {
+		t.Fatalf("expected the client cert, err: %v", err)
+	}
+
+	// the rotated client cert is reloaded
+	rotatedPEM := writeTestKeyPair(t, certFile, keyFile, 2)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	rotated, err := config.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rotatedPEM)
+	if !bytes.Equal(rotated.Certificate[0], block.Bytes) ||
+		bytes.Equal(rotated.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("expected the rotated client cert")
+	}
+}
+
+func TestValidateGrpcTLSServerName(t *testing.T) {
+	for _, serverName := range []string{"", "fts.example.com",
+		"*.example.com", "10.1.2.3", "::1"} {
+		if err := validateGrpcTLSServerName("h:1", serverName); err != nil {
+			t.Errorf("expected %q to be valid, err: %v", serverName, err)
+		}
+	}
+
+	for _, serverName := range []string{"fts.example.com:18095",
+		" fts.example.com", "fts example", "https://fts.example.com"} {
+		err := validateGrpcTLSServerName("h:1", serverName)
+		if err == nil || !strings.Contains(err.Error(), grpcTLSServerNameKey) {
+			t.Errorf("expected %q to be rejected, err: %v", serverName, err)
+		}
+	}
+}
+
+func TestGrpcClientTLSServerName(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fts.example.com"},
+		DNSNames:              []string{"fts.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign |
+			x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der},
+			PrivateKey: key}},
+		NextProtos: []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	hostPort := lis.Addr().String()
+
+	handshake := func(serverName string) error {
+		config, err := grpcClientTLSConfig(nil, certPEM, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err = grpcClientTLSConfigFor(hostPort, serverName, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds := &handshakeErrCreds{
+			TransportCredentials: credentials.NewTLS(config),
+			hostPort:             hostPort,
+			serverName:           serverName,
+		}
+
+		rawConn, err := net.Dial("tcp", hostPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rawConn.Close()
+
+		_, _, err = creds.Clone().ClientHandshake(context.Background(),
+			hostPort, rawConn)
+		return err
+	}
+
+	// the cert isn't valid for the host of the node
+	err = handshake("")
+	if err == nil || !strings.Contains(err.Error(), grpcTLSServerNameKey) {
+		t.Errorf("expected an actionable handshake err, got: %v", err)
+	}
+
+	if err = handshake("fts.example.com"); err != nil {
+		t.Errorf("expected the cert to match the server name, err: %v", err)
+	}
+
+	// the certs may be pinned
+	pinned := der
+	defer func() { GrpcVerifyPeerCertificate = nil }()
+	GrpcVerifyPeerCertificate = func(h string, rawCerts [][]byte,
+		verifiedChains [][]*x509.Certificate) error {
+		if h != hostPort || len(verifiedChains) == 0 {
+			t.Errorf("unexpected verification of host: %s", h)
+		}
+		if !bytes.Equal(rawCerts[0], pinned) {
+			return fmt.Errorf("unpinned cert")
+		}
+		return nil
+	}
+	if err = handshake("fts.example.com"); err != nil {
+		t.Errorf("expected the pinned cert to be accepted, err: %v", err)
+	}
+
+	pinned = nil
+	err = handshake("fts.example.com")
+	if err == nil || !strings.Contains(err.Error(), "unpinned cert") {
+		t.Errorf("expected the unpinned cert to be rejected, err: %v", err)
+	}
+}
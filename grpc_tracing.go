@@ -0,0 +1,161 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// GrpcTracing, when enabled by the "grpcTracing" option, has every
+// gRPC client call traced by the registered Tracer.  The tracing
+// interceptors are only chained into the client connections when
+// enabled, so there's no overhead otherwise.
+var GrpcTracing = false
+
+// Tracer starts the spans of the gRPC client calls, and is shaped
+// after the OpenTelemetry tracer and propagator, so that one can be
+// plugged in with a thin adapter.
+type Tracer interface {
+	// Start starts a span that's a child of the span of the ctx, if
+	// any, such as the span of a scatter-gather query, and returns a
+	// ctx carrying the new span.
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+
+	// Inject adds the trace context of the span of the ctx to the
+	// carrier, such as the W3C "traceparent", which is then sent
+	// along as the outgoing metadata of the call.
+	Inject(ctx context.Context, carrier map[string]string)
+}
+
+// Span is a traced gRPC client call.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, spanName string) (
+	context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(ctx context.Context, carrier map[string]string) {}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+// grpcTracer is guarded by the clientInterceptorsMutex.
+var grpcTracer Tracer = noopTracer{}
+
+// SetGrpcTracer registers the tracer of the gRPC client calls, which
+// only applies to the client connections that are dialed afterwards,
+// so it should be done during process initialization.
+func SetGrpcTracer(t Tracer) {
+	clientInterceptorsMutex.Lock()
+	grpcTracer = t
+	clientInterceptorsMutex.Unlock()
+}
+
+// tracingUnaryClientInterceptor traces each unary call with a span.
+func tracingUnaryClientInterceptor(tracer Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string,
+		req interface{}, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+		ctx, span := startRpcSpan(ctx, tracer, method, cc)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endRpcSpan(span, err)
+		return err
+	}
+}
+
+// tracingStreamClientInterceptor traces each streaming call with a
+// span, which ends when the stream does.
+func tracingStreamClientInterceptor(
+	tracer Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc,
+		cc *grpc.ClientConn, method string, streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startRpcSpan(ctx, tracer, method, cc)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endRpcSpan(span, err)
+			return nil, err
+		}
+		return &tracedClientStream{ClientStream: cs, span: span}, nil
+	}
+}
+
+// startRpcSpan starts the span of a call and propagates its trace
+// context to the server through the outgoing metadata.
+func startRpcSpan(ctx context.Context, tracer Tracer, method string,
+	cc *grpc.ClientConn) (context.Context, Span) {
+	ctx, span := tracer.Start(ctx, method)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	if cc != nil {
+		span.SetAttribute("net.peer.name", cc.Target())
+	}
+
+	// the scatter-gather calls of a coordinating node
+	if md, ok := metadata.FromOutgoingContext(ctx); ok &&
+		len(md.Get(rpcClusterActionKey)) > 0 {
+		span.SetAttribute("cbft.scatter_gather", true)
+	}
+
+	carrier := map[string]string{}
+	tracer.Inject(ctx, carrier)
+	for k, v := range carrier {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+
+	return ctx, span
+}
+
+func endRpcSpan(span Span, err error) {
+	span.SetAttribute("rpc.grpc.status_code", int64(grpcErrCode(err)))
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// tracedClientStream wraps a grpc.ClientStream to end its span once
+// the stream ends.
+type tracedClientStream struct {
+	grpc.ClientStream
+
+	span Span
+	done uint32
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && atomic.CompareAndSwapUint32(&s.done, 0, 1) {
+		if err == io.EOF {
+			endRpcSpan(s.span, nil)
+		} else {
+			endRpcSpan(s.span, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type spanKeyType string
+
+const spanKey = spanKeyType("span")
+
+// recordingTracer is a Tracer that records its spans, which are
+// children of the span of the ctx, if any.
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, spanName string) (
+	context.Context, Span) {
+	span := &recordingSpan{name: spanName, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey).(*recordingSpan); ok {
+		span.parent = parent
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+func (t *recordingTracer) Inject(ctx context.Context,
+	carrier map[string]string) {
+	if span, ok := ctx.Value(spanKey).(*recordingSpan); ok {
+		carrier["traceparent"] = "00-trace-" + span.name + "-01"
+	}
+}
+
+type recordingSpan struct {
+	name   string
+	parent *recordingSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  int
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *recordingSpan) End() {
+	s.ended++
+}
+
+func TestTracingUnaryClientInterceptor(t *testing.T) {
+	tracer := &recordingTracer{}
+
+	// the scatter-gather query is the parent of its calls
+	ctx, parent := tracer.Start(context.Background(), "query")
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		rpcClusterActionKey, clusterActionScatterGather)
+
+	var md metadata.MD
+	unavailable := status.Error(codes.Unavailable, "down")
+	err := tracingUnaryClientInterceptor(tracer)(ctx, "/search/DocCount",
+		nil, nil, nil,
+		func(ctx context.Context, method string,
+			req interface{}, reply interface{},
+			cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return unavailable
+		})
+	if err != unavailable {
+		t.Fatalf("expected the invoker err, got: %v", err)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected a span for the call, got: %d", len(tracer.spans))
+	}
+	span := tracer.spans[1]
+	if span.parent != parent || span.name != "/search/DocCount" {
+		t.Errorf("expected a child span of the query, got: %+v", span)
+	}
+	if vals := md.Get("traceparent"); len(vals) != 1 ||
+		vals[0] != "00-trace-/search/DocCount-01" {
+		t.Errorf("expected the trace context in the metadata, got: %v", md)
+	}
+	if span.attrs["rpc.grpc.status_code"] != int64(codes.Unavailable) ||
+		span.attrs["cbft.scatter_gather"] != true || span.err != unavailable {
+		t.Errorf("expected the status and err attributes, got: %+v", span)
+	}
+	if span.ended != 1 {
+		t.Errorf("expected the span to end once, got: %d", span.ended)
+	}
+}
+
+// eofClientStream is a grpc.ClientStream that ends after n messages.
+type eofClientStream struct {
+	grpc.ClientStream
+	n int
+}
+
+func (s *eofClientStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	return nil
+}
+
+func TestTracingStreamClientInterceptor(t *testing.T) {
+	tracer := &recordingTracer{}
+
+	cs, err := tracingStreamClientInterceptor(tracer)(context.Background(),
+		&grpc.StreamDesc{}, nil, "/search/Search",
+		func(ctx context.Context, desc *grpc.StreamDesc,
+			cc *grpc.ClientConn, method string,
+			opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &eofClientStream{n: 2}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := tracer.spans[0]
+	for err == nil {
+		if span.ended != 0 {
+			t.Fatalf("expected the span to last as long as the stream")
+		}
+		err = cs.RecvMsg(nil)
+	}
+	cs.RecvMsg(nil)
+
+	if span.ended != 1 || span.err != nil ||
+		span.attrs["rpc.grpc.status_code"] != int64(codes.OK) {
+		t.Errorf("expected the span to end once as ok, got: %+v", span)
+	}
+}
@@ -16,11 +16,14 @@ package cbft
 
 import (
 	"context"
-	"crypto/x509"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -33,15 +36,65 @@ import (
 	"github.com/couchbase/cbauth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 )
 
-// RPCClientConn represent the gRPC client connection cache.
-var RPCClientConn map[string][]*grpc.ClientConn
+// rpcConnPool is a pool of client connections to a remote node, which
+// is shared by the GrpcClients of the node and is only closed once no
+// longer referenced by any of them.
+type rpcConnPool struct {
+	// The queries in flight to the node, accessed atomically, and first
+	// for its 64-bit alignment.
+	outstanding int64
+
+	// The smoothed latency of the pings of the node, in nanoseconds,
+	// accessed atomically, where 0 means it was never pinged.
+	pingLatencyNS int64
+
+	// The smoothed latency of the successful searches of the node, in
+	// nanoseconds, and when a search of the node last failed, in unix
+	// nanoseconds, both accessed atomically.
+	searchLatencyNS int64
+	penalizedAt     int64
+
+	// When a connection last failed its warmup, in unix nanoseconds,
+	// accessed atomically, where 0 means the node isn't suspect.
+	suspectAt int64
+
+	key             string // The nodeUUID and hostPort of the node.
+	certFingerprint string // Of the cert used by the connections.
+	conns           []*grpc.ClientConn
+	dial            func() (*grpc.ClientConn, error)
+
+	// The Search RPCs in flight to the node, up to its concurrency limit.
+	searches searchLimiter
+
+	// The following are guarded by the rpcConnMutex.
+	refs     int64
+	lastUsed time.Time
+	stale    bool // When set, the pool is closed once unreferenced.
+
+	// The replaced connections, which the references to the pool may
+	// still be using, and so are closed once the pool is unreferenced.
+	retired []*grpc.ClientConn
+}
+
+// rpcConnPools is the gRPC client connection cache, keyed by the
+// nodeUUID and hostPort of the remote nodes.
+var rpcConnPools map[string]*rpcConnPool
 
 var rpcConnMutex sync.Mutex
 
+// totGrpcConnsReplaced tracks the failed client connections that were
+// replaced before being used.
+var totGrpcConnsReplaced uint64
+
+// DefaultGrpcConnPoolIdleTimeout is how long the connections of a node
+// are kept while unreferenced, before they're evicted and closed.
+var DefaultGrpcConnPoolIdleTimeout = 5 * time.Minute
+
 // rpc.ClientConn pool size/static connections per remote host
 var connPoolSize = 5
 
@@ -50,12 +103,32 @@ var defaultRPCClientCacheSize = 10
 // GrpcPort represents the port used with gRPC.
 var GrpcPort = ":15000"
 
-// default values same as that for http/rest connections
+// default values same as that for http/rest connections, which are
+// overridable by the "grpcConnectionIdleTimeout" and
+// "grpcConnectionHeartBeatInterval" options
 var DefaultGrpcConnectionIdleTimeout = time.Duration(60) * time.Second
 var DefaultGrpcConnectionHeartBeatInterval = time.Duration(60) * time.Second
 
+// GrpcStreamKeepAliveInterval, when non-zero and shorter than the
+// heart beat interval, is the interval of the keepalive pings sent by
+// the client while it has active streams, so that slowly consumed
+// Search streams aren't reaped as idle by intermediate proxies.  gRPC
+// enforces a minimum of 10 seconds.
+var GrpcStreamKeepAliveInterval time.Duration
+
+// GrpcKeepAlivePermitWithoutStream controls whether the keepalive
+// pings are also sent on connections without any active streams, so
+// that idle connections aren't silently dropped by intermediate load
+// balancers between the query bursts.
+var GrpcKeepAlivePermitWithoutStream = false
+
 var DefaultGrpcMaxBackOffDelay = time.Duration(10) * time.Second
 
+// DefaultGrpcMaxRecvMsgSize and DefaultGrpcMaxSendMsgSize are the max
+// sizes of the gRPC messages of both the clients and the server, well
+// above the 4MB gRPC default, so that the large search results and
+// hits batches fit.  They're overridable by the "grpcMaxRecvMsgSize"
+// and "grpcMaxSendMsgSize" options.
 var DefaultGrpcMaxRecvMsgSize = 1024 * 1024 * 50 // 50 MB
 var DefaultGrpcMaxSendMsgSize = 1024 * 1024 * 50 // 50 MB
 
@@ -65,11 +138,24 @@ var DefaultGrpcMaxSendMsgSize = 1024 * 1024 * 50 // 50 MB
 // streams/requests on either the client or server.
 var DefaultGrpcMaxConcurrentStreams = uint32(math.MaxInt32)
 
+// DefaultGrpcCompressionBufferAllowance is the default fraction of a
+// query's estimated result memory that's additionally accounted for,
+// as the compression/decompression buffers, when gRPC compression is
+// enabled.  It's overridable by the "grpcCompressionBufferAllowance"
+// manager option.
+var DefaultGrpcCompressionBufferAllowance = 0.5
+
+// GrpcClientLogVerbose controls whether the gRPC client logs every
+// rpc, which is the default, or only the failed ones, as the latencies
+// of the rpc's are aggregated into the GrpcClientStats too.  It's
+// overridable by the "grpcClientLogVerbose" option.
+var GrpcClientLogVerbose = true
+
 var rsource rand.Source
 var r1 *rand.Rand
 
 func init() {
-	RPCClientConn = make(map[string][]*grpc.ClientConn, defaultRPCClientCacheSize)
+	rpcConnPools = make(map[string]*rpcConnPool, defaultRPCClientCacheSize)
 	rsource = rand.NewSource(time.Now().UnixNano())
 	r1 = rand.New(rsource)
 }
@@ -79,7 +165,10 @@ func init() {
 // and the latest configs.
 func resetGrpcClients() error {
 	rpcConnMutex.Lock()
-	RPCClientConn = make(map[string][]*grpc.ClientConn, defaultRPCClientCacheSize)
+	for _, pool := range rpcConnPools {
+		retireRpcConnPoolLOCKED(pool)
+	}
+	rpcConnPools = make(map[string]*rpcConnPool, defaultRPCClientCacheSize)
 	rpcConnMutex.Unlock()
 	return nil
 }
@@ -112,55 +201,277 @@ func basicAuth(username, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
-func getRpcClient(nodeUUID, hostPort string, certInBytes []byte) (
-	pb.SearchServiceClient, error) {
-	var hostPool []*grpc.ClientConn
-	var initialised bool
+// getRpcClient returns a client of a remote node, which uses one of the
+// node's shared connections until the returned reference is released.
+// With TLS, the certs of the node are verified against the serverName,
+// when given, instead of against the host of the hostPort.
+func getRpcClient(nodeUUID, hostPort, serverName string,
+	certInBytes []byte) (pb.SearchServiceClient, *rpcConnRef, error) {
+	if len(certInBytes) == 0 {
+		serverName = ""
+	}
 
-	key := nodeUUID + "-" + hostPort
-	index := r1.Intn(connPoolSize)
+	var opts []grpc.DialOption
+	dial := func() (*grpc.ClientConn, error) {
+		if opts == nil {
+			var err error
+			opts, err = getGrpcOpts(hostPort, serverName, certInBytes)
+			if err != nil {
+				log.Errorf("grpc_client: getGrpcOpts, host port: %s, err: %v",
+					hostPort, err)
+				return nil, err
+			}
+		}
 
-	rpcConnMutex.Lock()
-	if hostPool, initialised = RPCClientConn[key]; !initialised {
-		opts, err := getGrpcOpts(hostPort, certInBytes)
+		conn, err := grpc.Dial(hostPort, opts...)
 		if err != nil {
-			rpcConnMutex.Unlock()
-			log.Errorf("grpc_client: getGrpcOpts, host port: %s, err: %v",
-				hostPort, err)
+			log.Errorf("grpc_client: grpc.Dial, err: %v", err)
 			return nil, err
 		}
 
+		log.Printf("grpc_client: grpc ClientConn created for host: %s", hostPort)
+
+		return conn, nil
+	}
+
+	// the connections verifying a different server name aren't shared
+	key := nodeUUID + "-" + hostPort
+	if serverName != "" {
+		key += "/" + serverName
+	}
+
+	pool, conn, err := acquireRpcConnPool(key, certInBytes, dial)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cli := pb.NewSearchServiceClient(conn)
+
+	if len(certInBytes) == 0 {
+		atomic.AddUint64(&totRemoteGrpc, 1)
+	} else {
+		atomic.AddUint64(&totRemoteGrpcSecure, 1)
+	}
+
+	return cli, &rpcConnRef{pool: pool}, nil
+}
+
+// acquireRpcConnPool returns a referenced pool of the node of the key,
+// dialing a new one when there's none or when the cert of the existing
+// one was rotated, along with one of its healthy connections.
+func acquireRpcConnPool(key string, certInBytes []byte,
+	dial func() (*grpc.ClientConn, error)) (
+	*rpcConnPool, *grpc.ClientConn, error) {
+	fingerprint := certFingerprint(certInBytes)
+
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
+
+	now := time.Now()
+	evictIdleRpcConnPoolsLOCKED(now)
+
+	pool := rpcConnPools[key]
+	if pool != nil && pool.certFingerprint != fingerprint {
+		log.Printf("grpc_client: cert changed, replacing the connections"+
+			" for host: %s", key)
+		retireRpcConnPoolLOCKED(pool)
+		pool = nil
+	}
+
+	if pool == nil {
+		pool = &rpcConnPool{
+			key:             key,
+			certFingerprint: fingerprint,
+			dial:            dial,
+		}
 		for i := 0; i < connPoolSize; i++ {
-			conn, err := grpc.Dial(hostPort, opts...)
+			conn, err := dial()
 			if err != nil {
-				rpcConnMutex.Unlock()
-				log.Errorf("grpc_client: grpc.Dial, err: %v", err)
-				return nil, err
+				pool.closeLOCKED()
+				return nil, nil, err
 			}
+			pool.conns = append(pool.conns, conn)
+		}
+		rpcConnPools[key] = pool
+
+		pool.warmUp(pool.conns...)
+	}
+
+	pool.refs++
+	pool.lastUsed = now
+
+	return pool, pool.healthyConnLOCKED(r1.Intn(len(pool.conns))), nil
+}
+
+// healthyConnLOCKED returns the i'th connection of the pool, which is
+// first replaced when it has failed, rather than have the query wait
+// out the reconnection backoff of the failed connection.  The failed
+// connection is shared by the other references to the pool, and so is
+// only closed once they're released.
+func (pool *rpcConnPool) healthyConnLOCKED(i int) *grpc.ClientConn {
+	state := pool.conns[i].GetState()
+	if state != connectivity.TransientFailure &&
+		state != connectivity.Shutdown {
+		return pool.conns[i]
+	}
+
+	conn, err := pool.dial()
+	if err != nil {
+		return pool.conns[i]
+	}
+
+	log.Printf("grpc_client: replacing the %s connection %d for host: %s",
+		state, i, pool.key)
+
+	pool.retired = append(pool.retired, pool.conns[i])
+	pool.conns[i] = conn
 
-			log.Printf("grpc_client: grpc ClientConn Created %d for host: %s", i, key)
+	pool.warmUp(conn)
 
-			RPCClientConn[key] = append(RPCClientConn[key], conn)
+	atomic.AddUint64(&totGrpcConnsReplaced, 1)
+
+	return conn
+}
+
+func certFingerprint(certInBytes []byte) string {
+	if len(certInBytes) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certInBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// evictIdleRpcConnPoolsLOCKED retires the pools that have been
+// unreferenced for longer than the DefaultGrpcConnPoolIdleTimeout.
+func evictIdleRpcConnPoolsLOCKED(now time.Time) {
+	for _, pool := range rpcConnPools {
+		if pool.refs <= 0 &&
+			now.Sub(pool.lastUsed) >= DefaultGrpcConnPoolIdleTimeout {
+			log.Printf("grpc_client: evicting the idle connections"+
+				" for host: %s", pool.key)
+			retireRpcConnPoolLOCKED(pool)
 		}
-		hostPool = RPCClientConn[key]
 	}
+}
 
+// retireRpcConnPoolLOCKED removes a pool from the cache, where the pool
+// is closed right away when unreferenced, or else on its last release.
+func retireRpcConnPoolLOCKED(pool *rpcConnPool) {
+	if rpcConnPools[pool.key] == pool {
+		delete(rpcConnPools, pool.key)
+	}
+
+	pool.stale = true
+	if pool.refs <= 0 {
+		pool.closeLOCKED()
+	}
+}
+
+func (pool *rpcConnPool) closeLOCKED() {
+	for _, conn := range pool.conns {
+		conn.Close()
+	}
+	pool.conns = nil
+	pool.closeRetiredLOCKED()
+}
+
+// closeRetiredLOCKED closes the replaced connections of the pool.
+func (pool *rpcConnPool) closeRetiredLOCKED() {
+	for _, conn := range pool.retired {
+		conn.Close()
+	}
+	pool.retired = nil
+}
+
+// rpcConnRef is a reference to a pool, held by a GrpcClient.
+type rpcConnRef struct {
+	pool     *rpcConnPool
+	released int32
+}
+
+// release drops the reference, at most once.
+func (r *rpcConnRef) release() {
+	if r == nil || !atomic.CompareAndSwapInt32(&r.released, 0, 1) {
+		return
+	}
+
+	rpcConnMutex.Lock()
+	r.pool.refs--
+	r.pool.lastUsed = time.Now()
+	if r.pool.refs <= 0 {
+		if r.pool.stale {
+			r.pool.closeLOCKED()
+		} else {
+			r.pool.closeRetiredLOCKED()
+		}
+	}
 	rpcConnMutex.Unlock()
+}
 
-	// TODO connection mgmt
-	// when to perform explicit conn.Close()?
-	cli := pb.NewSearchServiceClient(hostPool[index])
+// rpcConnPoolRefs returns the number of references to each of the
+// cached pools, keyed by nodeUUID and hostPort.
+func rpcConnPoolRefs() map[string]int64 {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
 
-	if len(certInBytes) == 0 {
-		atomic.AddUint64(&totRemoteGrpc, 1)
-	} else {
-		atomic.AddUint64(&totRemoteGrpcSecure, 1)
+	rv := make(map[string]int64, len(rpcConnPools))
+	for key, pool := range rpcConnPools {
+		rv[key] = pool.refs
 	}
+	return rv
+}
+
+// rpcNodeOutstanding returns the number of queries in flight to a node,
+// across its pools.
+func rpcNodeOutstanding(nodeUUID string) int64 {
+	rpcConnMutex.Lock()
+	defer rpcConnMutex.Unlock()
 
-	return cli, nil
+	var rv int64
+	for key, pool := range rpcConnPools {
+		if strings.HasPrefix(key, nodeUUID+"-") {
+			rv += atomic.LoadInt64(&pool.outstanding)
+		}
+	}
+	return rv
 }
 
-func getGrpcOpts(hostPort string, certInBytes []byte) ([]grpc.DialOption, error) {
+// grpcKeepAliveInterval returns the interval of the keepalive pings on
+// connections with active streams.
+func grpcKeepAliveInterval() time.Duration {
+	if GrpcStreamKeepAliveInterval > 0 &&
+		GrpcStreamKeepAliveInterval < DefaultGrpcConnectionHeartBeatInterval {
+		return GrpcStreamKeepAliveInterval
+	}
+	return DefaultGrpcConnectionHeartBeatInterval
+}
+
+func grpcClientKeepaliveParams() keepalive.ClientParameters {
+	return keepalive.ClientParameters{
+		// send keepalive every 60 seconds, or every
+		// GrpcStreamKeepAliveInterval, to check the connection
+		// livliness and keep active streams from looking idle
+		Time: grpcKeepAliveInterval(),
+		// timeout value for an inactive connection
+		Timeout: DefaultGrpcConnectionIdleTimeout,
+		// keep pinging the idle connections, if enabled
+		PermitWithoutStream: GrpcKeepAlivePermitWithoutStream,
+	}
+}
+
+// KeepaliveEnforcementPolicy returns the server option that permits
+// the keepalive pings sent by the clients on active streams, as the
+// server would otherwise close connections pinged more than once
+// every 5 minutes.
+func KeepaliveEnforcementPolicy() grpc.ServerOption {
+	return grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             grpcKeepAliveInterval(),
+		PermitWithoutStream: GrpcKeepAlivePermitWithoutStream,
+	})
+}
+
+func getGrpcOpts(hostPort, serverName string, certInBytes []byte) (
+	[]grpc.DialOption, error) {
 	cbUser, cbPasswd, err := cbauth.GetHTTPServiceAuth(hostPort)
 	if err != nil {
 		return nil, fmt.Errorf("grpc_util: cbauth err: %v", err)
@@ -178,13 +489,7 @@ func getGrpcOpts(hostPort string, certInBytes []byte) ([]grpc.DialOption, error)
 	opts := []grpc.DialOption{
 		grpc.WithBackoffMaxDelay(DefaultGrpcMaxBackOffDelay),
 
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			// send keepalive every 60 seconds to check the
-			// connection livliness
-			Time: DefaultGrpcConnectionHeartBeatInterval,
-			// timeout value for an inactive connection
-			Timeout: DefaultGrpcConnectionIdleTimeout,
-		}),
+		grpc.WithKeepaliveParams(grpcClientKeepaliveParams()),
 
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(DefaultGrpcMaxRecvMsgSize),
@@ -194,15 +499,24 @@ func getGrpcOpts(hostPort string, certInBytes []byte) ([]grpc.DialOption, error)
 		grpc.WithPerRPCCredentials(bac),
 	}
 
+	opts = append(opts, addClientInterceptors()...)
+
 	if len(certInBytes) != 0 {
-		// create a certificate pool from the CA
-		certPool := x509.NewCertPool()
-		// append the certificates from the CA
-		ok := certPool.AppendCertsFromPEM(certInBytes)
-		if !ok {
-			return nil, fmt.Errorf("grpc_util: failed to append ca certs")
+		// the node's cert doubles as the client cert for the mutual TLS
+		config, err := grpcClientTLSConfig(cbgt.GetSecuritySetting(),
+			certInBytes, cbgt.TLSCertFile, cbgt.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config, err = grpcClientTLSConfigFor(hostPort, serverName, config)
+		if err != nil {
+			return nil, err
+		}
+		creds := &handshakeErrCreds{
+			TransportCredentials: credentials.NewTLS(config),
+			hostPort:             hostPort,
+			serverName:           serverName,
 		}
-		creds := credentials.NewClientTLSFromCert(certPool, "")
 
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else {
@@ -212,6 +526,56 @@ func getGrpcOpts(hostPort string, certInBytes []byte) ([]grpc.DialOption, error)
 	return opts, nil
 }
 
+// grpcCompressionEnabled returns true when the "grpcCompression"
+// manager option names a compressor for the gRPC search streams.
+func grpcCompressionEnabled(mgr *cbgt.Manager) bool {
+	if mgr == nil {
+		return false
+	}
+	v := mgr.Options()["grpcCompression"]
+	return v != "" && v != "none"
+}
+
+// addGrpcCompressionAllowance adds an allowance for the compression
+// buffers to the given query memory estimate, but only when gRPC
+// compression is enabled.
+func addGrpcCompressionAllowance(mgr *cbgt.Manager, estimate uint64) uint64 {
+	if !grpcCompressionEnabled(mgr) {
+		return estimate
+	}
+
+	allowance := DefaultGrpcCompressionBufferAllowance
+	if v := mgr.Options()["grpcCompressionBufferAllowance"]; v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Warnf("grpc_util: invalid grpcCompressionBufferAllowance: %s,"+
+				" err: %v", v, err)
+		} else {
+			allowance = f
+		}
+	}
+
+	return estimate + uint64(float64(estimate)*allowance)
+}
+
+// logFields renders the alternating keys and values as space separated
+// key=value pairs, quoting the values where needed, so that the log
+// lines remain readable while being indexable by log aggregators.
+func logFields(kvs ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		v := fmt.Sprintf("%v", kvs[i+1])
+		if v == "" || strings.ContainsAny(v, " \t\n=\"") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, "%v=%s", kvs[i], v)
+	}
+	return b.String()
+}
+
 func parseStringTime(t string) (time.Time, error) {
 	dateTimeParser, err := cache.DateTimeParserNamed(query.QueryDateTimeParser)
 	if err != nil {
@@ -297,8 +661,9 @@ func updateRpcFocusStats(startTime time.Time, mgr *cbgt.Manager,
 					d := time.Since(startTime)
 					if d > slowQueryLogTimeout {
 						log.Warnf("grpc_util: slow-query index: %s,"+
-							" query: %s, duration: %v, err: %v",
-							req.IndexName, string(req.Contents), d, err)
+							" query: %s, duration: %v, requestID: %s, err: %v",
+							req.IndexName, string(req.Contents), d,
+							requestIDFromContext(ctx), err)
 
 						atomic.AddUint64(&focusStats.TotGrpcRequestSlow, 1)
 					}
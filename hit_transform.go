@@ -0,0 +1,92 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+// HitTransform computes derived fields of a hit, such as a distance
+// from a point, by modifying the decoded hit in place.  An error
+// aborts the query.
+type HitTransform func(hit *search.DocumentMatch) error
+
+var hitTransformMutex sync.RWMutex
+var hitTransform HitTransform
+
+// RegisterHitTransform registers the transform that's applied to each
+// hit by the coordinator of a query, as the hits are gathered from the
+// local and remote pindexes, where nil unregisters it.  There's no
+// transform by default.
+func RegisterHitTransform(t HitTransform) {
+	hitTransformMutex.Lock()
+	hitTransform = t
+	hitTransformMutex.Unlock()
+}
+
+func getHitTransform() HitTransform {
+	hitTransformMutex.RLock()
+	rv := hitTransform
+	hitTransformMutex.RUnlock()
+	return rv
+}
+
+// transformHits applies the transform to all the hits, stopping at
+// the first error.
+func transformHits(t HitTransform, hits search.DocumentMatchCollection) error {
+	for _, hit := range hits {
+		if err := t(hit); err != nil {
+			return fmt.Errorf("hit_transform: transform failed, hit: %s,"+
+				" err: %v", hit.ID, err)
+		}
+	}
+	return nil
+}
+
+// transformHitsBytes applies the transform to a streamed batch of JSON
+// encoded hits, returning the re-encoded batch along with the end
+// offsets of each of its hits.
+func transformHitsBytes(t HitTransform, b []byte) ([]byte, []uint64, error) {
+	var hits search.DocumentMatchCollection
+	err := UnmarshalJSON(b, &hits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hit_transform: decoding hits, err: %v",
+			err)
+	}
+
+	err = transformHits(t, hits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rv := make([]byte, 0, len(b))
+	rv = append(rv, sliceStart...)
+	offsets := make([]uint64, 0, len(hits))
+	for i, hit := range hits {
+		hb, err := MarshalJSON(hit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hit_transform: encoding hit: %s,"+
+				" err: %v", hit.ID, err)
+		}
+		if i > 0 {
+			rv = append(rv, itemGlue...)
+		}
+		rv = append(rv, hb...)
+		offsets = append(offsets, uint64(len(rv)))
+	}
+	rv = append(rv, sliceEnd...)
+
+	return rv, offsets, nil
+}
@@ -0,0 +1,77 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+func TestTransformHitsBytes(t *testing.T) {
+	b := []byte(`[{"id":"a","score":1},{"id":"b","score":2}]`)
+
+	transform := func(hit *search.DocumentMatch) error {
+		if hit.Fields == nil {
+			hit.Fields = map[string]interface{}{}
+		}
+		hit.Fields["derived"] = "x-" + hit.ID
+		return nil
+	}
+
+	rv, offsets, err := transformHitsBytes(transform, b)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if len(offsets) != 2 {
+		t.Fatalf("expected 2 offsets, got: %v", offsets)
+	}
+
+	var hits search.DocumentMatchCollection
+	if err = UnmarshalJSON(rv, &hits); err != nil {
+		t.Fatalf("expected valid hits, got: %s, err: %v", rv, err)
+	}
+	for _, hit := range hits {
+		if hit.Fields["derived"] != "x-"+hit.ID {
+			t.Errorf("expected derived field for hit: %s, got: %v",
+				hit.ID, hit.Fields)
+		}
+	}
+
+	// each offset ends a hit, followed by glue or the slice end
+	if rv[offsets[0]] != ',' || rv[offsets[1]] != ']' ||
+		int(offsets[1]) != len(rv)-1 {
+		t.Errorf("expected offsets to end each hit, got: %v, hits: %s",
+			offsets, rv)
+	}
+}
+
+func TestTransformHitsBytesError(t *testing.T) {
+	b := []byte(`[{"id":"a","score":1},{"id":"b","score":2}]`)
+
+	transform := func(hit *search.DocumentMatch) error {
+		if hit.ID == "b" {
+			return fmt.Errorf("no derived field")
+		}
+		return nil
+	}
+
+	rv, offsets, err := transformHitsBytes(transform, b)
+	if err == nil || !strings.Contains(err.Error(), "hit: b") {
+		t.Errorf("expected an error naming the failed hit, got: %v", err)
+	}
+	if rv != nil || offsets != nil {
+		t.Errorf("expected no partially transformed hits, got: %s", rv)
+	}
+}
@@ -62,6 +62,9 @@ func (m *MissingPIndex) Search(req *bleve.SearchRequest) (
 
 func (m *MissingPIndex) SearchInContext(ctx context.Context,
 	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	if c := pindexHitCountsFromContext(ctx); c != nil {
+		c.setReason(m.name, PIndexStatusUnavailable)
+	}
 	return nil, fmt.Errorf("pindex not available")
 }
 
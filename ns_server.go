@@ -73,6 +73,11 @@ var currentMemoryUsed uint64
 // last sampling.
 var OnMemoryUsedDropped func(curMemoryUsed, prevMemoryUsed uint64)
 
+// Optional callback that returns the memory pressure, from 0 to 100,
+// as computed by the app_herder, for the components that slow down
+// ahead of the hard throttling.
+var CurMemoryPressure func() int
+
 // PartitionSeqsProvider represents source object that can provide
 // partition seqs, such as some pindex or dest implementations.
 type PartitionSeqsProvider interface {
@@ -476,8 +481,27 @@ func gatherTopLevelStats(rd *recentInfo) map[string]interface{} {
 	topLevelStats["pct_cpu_gc"] = rd.memStats.GCCPUFraction
 	topLevelStats["tot_remote_http"] = atomic.LoadUint64(&totRemoteHttp)
 	topLevelStats["tot_remote_http2"] = atomic.LoadUint64(&totRemoteHttp2)
+	topLevelStats["tot_remote_http_fallback"] =
+		atomic.LoadUint64(&totRemoteHttpFallback)
 	topLevelStats["tot_queryreject_on_memquota"] =
 		atomic.LoadUint64(&totQueryRejectOnNotEnoughQuota)
+	topLevelStats["tot_query_degraded"] = atomic.LoadUint64(&totQueryDegraded)
+	topLevelStats["tot_queryreject_on_too_many_facets"] =
+		atomic.LoadUint64(&totQueryRejectOnTooManyFacets)
+	topLevelStats["tot_queryreject_on_too_many_facet_fields"] =
+		atomic.LoadUint64(&totQueryRejectOnTooManyFacetFields)
+	topLevelStats["tot_query_no_healthy_nodes"] =
+		atomic.LoadUint64(&totQueryNoHealthyNodes)
+	for label, s := range queryLabelStatsSnapshot() {
+		prefix := "query_label:" + label + ":"
+		topLevelStats[prefix+"total_grpc_queries"] = s.TotGrpcRequest
+		topLevelStats[prefix+"total_grpc_request_time"] = s.TotGrpcRequestTimeNS
+		topLevelStats[prefix+"total_grpc_queries_error"] = s.TotGrpcRequestErr
+		topLevelStats[prefix+"total_grpc_internal_queries"] =
+			s.TotGrpcInternalRequest
+		topLevelStats[prefix+"total_grpc_internal_request_time"] =
+			s.TotGrpcInternalRequestTimeNS
+	}
 
 	topLevelStats["tot_http_limitlisteners_opened"] =
 		atomic.LoadUint64(&TotHTTPLimitListenersOpened)
@@ -493,6 +517,182 @@ func gatherTopLevelStats(rd *recentInfo) map[string]interface{} {
 	topLevelStats["tot_grpc_queryreject_on_memquota"] =
 		atomic.LoadUint64(&totGrpcQueryRejectOnNotEnoughQuota)
 
+	topLevelStats["tot_grpc_client_streams"] =
+		atomic.LoadUint64(&totGrpcClientStreams)
+	topLevelStats["tot_grpc_client_stream_errors"] =
+		atomic.LoadUint64(&totGrpcClientStreamErrs)
+	topLevelStats["tot_grpc_client_stream_setup_time"] =
+		atomic.LoadUint64(&totGrpcClientStreamSetupTimeNS)
+	topLevelStats["tot_grpc_client_stream_msgs_recv"] =
+		atomic.LoadUint64(&totGrpcClientStreamMsgsRecv)
+	topLevelStats["tot_grpc_client_stream_bytes_recv"] =
+		atomic.LoadUint64(&totGrpcClientStreamBytesRecv)
+	topLevelStats["tot_grpc_client_slow_calls"] =
+		atomic.LoadUint64(&totGrpcClientSlowCalls)
+	topLevelStats["tot_grpc_client_self_loop_skipped"] =
+		atomic.LoadUint64(&totGrpcClientSelfLoopSkipped)
+	topLevelStats["tot_grpc_search_retries"] =
+		atomic.LoadUint64(&totGrpcSearchRetries)
+	topLevelStats["tot_grpc_search_retries_succeeded"] =
+		atomic.LoadUint64(&totGrpcSearchRetriesSucceeded)
+	topLevelStats["tot_grpc_throttled_dispatches"] =
+		atomic.LoadUint64(&totGrpcThrottledDispatches)
+	topLevelStats["tot_grpc_dispatches_staggered"] =
+		atomic.LoadUint64(&totGrpcDispatchesStaggered)
+	topLevelStats["tot_grpc_dispatch_spread_time"] =
+		atomic.LoadUint64(&totGrpcDispatchSpreadNS)
+	topLevelStats["tot_grpc_search_requests_rewritten"] =
+		atomic.LoadUint64(&totGrpcSearchRequestsRewritten)
+	topLevelStats["tot_grpc_search_requests_rejected"] =
+		atomic.LoadUint64(&totGrpcSearchRequestsRejected)
+	topLevelStats["tot_grpc_stream_credit_waits"] =
+		atomic.LoadUint64(&totGrpcStreamCreditWaits)
+	topLevelStats["tot_grpc_stream_flow_control_fallbacks"] =
+		atomic.LoadUint64(&totGrpcStreamFlowControlFallbacks)
+	topLevelStats["tot_grpc_stream_write_time"] =
+		atomic.LoadUint64(&totGrpcStreamWriteTimeNS)
+	topLevelStats["tot_grpc_stream_write_aborts"] =
+		atomic.LoadUint64(&totGrpcStreamWriteAborts)
+	topLevelStats["tot_grpc_isolated_pindexes"] =
+		atomic.LoadUint64(&totGrpcIsolatedPIndexes)
+	topLevelStats["tot_grpc_pindexes_abandoned"] =
+		atomic.LoadUint64(&totGrpcPIndexesAbandoned)
+	topLevelStats["tot_grpc_ping_failures"] =
+		atomic.LoadUint64(&totGrpcPingFailures)
+	topLevelStats["tot_grpc_conn_warmups"] =
+		atomic.LoadUint64(&totGrpcConnWarmups)
+	topLevelStats["tot_grpc_conn_warmup_failures"] =
+		atomic.LoadUint64(&totGrpcConnWarmupFailures)
+	topLevelStats["tot_grpc_preflight_pruned"] =
+		atomic.LoadUint64(&totGrpcPreflightPruned)
+	topLevelStats["tot_grpc_consistency_unsatisfied"] =
+		atomic.LoadUint64(&totGrpcConsistencyUnsatisfied)
+	topLevelStats["tot_grpc_bulk_doc_counts"] =
+		atomic.LoadUint64(&totGrpcBulkDocCounts)
+	topLevelStats["tot_grpc_count_cache_hits"] =
+		atomic.LoadUint64(&totGrpcCountCacheHits)
+	topLevelStats["tot_grpc_count_cache_misses"] =
+		atomic.LoadUint64(&totGrpcCountCacheMisses)
+	topLevelStats["tot_grpc_facet_snapshots"] =
+		atomic.LoadUint64(&totGrpcFacetSnapshots)
+	topLevelStats["tot_grpc_searches_queued"] =
+		atomic.LoadUint64(&totGrpcSearchesQueued)
+	topLevelStats["tot_grpc_search_queue_timeouts"] =
+		atomic.LoadUint64(&totGrpcSearchQueueTimeouts)
+	topLevelStats["tot_grpc_capability_fallbacks"] =
+		atomic.LoadUint64(&totGrpcCapabilityFallbacks)
+	topLevelStats["tot_grpc_conns_replaced"] =
+		atomic.LoadUint64(&totGrpcConnsReplaced)
+	topLevelStats["tot_grpc_breaker_opened"] =
+		atomic.LoadUint64(&totGrpcBreakerOpened)
+	topLevelStats["tot_grpc_breaker_rejected"] =
+		atomic.LoadUint64(&totGrpcBreakerRejected)
+	topLevelStats["num_grpc_breakers_open"] = grpcBreakersOpen()
+	topLevelStats["tot_grpc_stream_msgs_compressed"] =
+		atomic.LoadUint64(&totGrpcStreamMsgsCompressed)
+	topLevelStats["tot_grpc_stream_msgs_uncompressed"] =
+		atomic.LoadUint64(&totGrpcStreamMsgsUncompressed)
+	topLevelStats["tot_grpc_stream_bytes_before_compression"] =
+		atomic.LoadUint64(&totGrpcStreamBytesBeforeCompression)
+	topLevelStats["tot_grpc_stream_bytes_after_compression"] =
+		atomic.LoadUint64(&totGrpcStreamBytesAfterCompression)
+
+	topLevelStats["tot_grpc_consistency_wait_succeeded"] =
+		atomic.LoadUint64(&totGrpcConsistencyWaitSucceeded)
+	topLevelStats["tot_grpc_consistency_wait_timedout"] =
+		atomic.LoadUint64(&totGrpcConsistencyWaitTimedout)
+	if grpcConsistencyWaitHistogram.Count() > 0 {
+		ps := grpcConsistencyWaitHistogram.Percentiles([]float64{0.5, 0.99})
+		topLevelStats["avg_grpc_consistency_wait_time"] =
+			grpcConsistencyWaitHistogram.Mean()
+		topLevelStats["p50_grpc_consistency_wait_time"] = ps[0]
+		topLevelStats["p99_grpc_consistency_wait_time"] = ps[1]
+	}
+
+	topLevelStats["tot_grpc_scatter_pool_saturated"] =
+		atomic.LoadUint64(&totGrpcScatterPoolSaturated)
+	topLevelStats["tot_grpc_scatter_pool_timeouts"] =
+		atomic.LoadUint64(&totGrpcScatterPoolTimeouts)
+	topLevelStats["tot_grpc_scatter_pool_yields"] =
+		atomic.LoadUint64(&totGrpcScatterPoolYields)
+	workers, busyWorkers := GrpcScatterPoolUsage()
+	topLevelStats["num_grpc_scatter_pool_workers"] = workers
+	topLevelStats["num_grpc_scatter_pool_busy"] = busyWorkers
+
+	topLevelStats["tot_grpc_fanout_queries"] =
+		atomic.LoadUint64(&totGrpcFanoutQueries)
+	topLevelStats["tot_grpc_fanout_pindexes"] =
+		atomic.LoadUint64(&totGrpcFanoutPIndexes)
+	topLevelStats["tot_grpc_fanout_skipped_filtered"] =
+		atomic.LoadUint64(&totGrpcFanoutSkippedFiltered)
+	topLevelStats["tot_grpc_fanout_skipped_no_port"] =
+		atomic.LoadUint64(&totGrpcFanoutSkippedNoPort)
+	topLevelStats["tot_grpc_fanout_skipped_errored"] =
+		atomic.LoadUint64(&totGrpcFanoutSkippedErrored)
+	topLevelStats["tot_grpc_fanout_skipped_unreachable"] =
+		atomic.LoadUint64(&totGrpcFanoutSkippedUnreachable)
+	topLevelStats["tot_grpc_fanout_http_fallbacks"] =
+		atomic.LoadUint64(&totGrpcFanoutHttpFallbacks)
+	topLevelStats["tot_grpc_fanout_clients"] =
+		atomic.LoadUint64(&totGrpcFanoutClients)
+	if grpcFanoutPIndexesHistogram.Count() > 0 {
+		topLevelStats["avg_grpc_fanout_pindexes"] =
+			grpcFanoutPIndexesHistogram.Mean()
+		topLevelStats["p99_grpc_fanout_pindexes"] =
+			grpcFanoutPIndexesHistogram.Percentile(0.99)
+		topLevelStats["avg_grpc_fanout_clients"] =
+			grpcFanoutClientsHistogram.Mean()
+		topLevelStats["p99_grpc_fanout_clients"] =
+			grpcFanoutClientsHistogram.Percentile(0.99)
+		topLevelStats["avg_grpc_fanout_nodes"] =
+			grpcFanoutNodesHistogram.Mean()
+		topLevelStats["p99_grpc_fanout_nodes"] =
+			grpcFanoutNodesHistogram.Percentile(0.99)
+	}
+
+	pruneGrpcFanoutSkippedNodes(rd.nodeDefs)
+	for hostPort, n := range GrpcFanoutSkippedNodes() {
+		topLevelStats["grpc_node:"+hostPort+":tot_fanout_skipped"] = n
+	}
+
+	for method, s := range GrpcClientStats() {
+		prefix := "grpc_client:" + method + ":"
+		topLevelStats[prefix+"tot_calls"] = s.Succeeded.TotCalls
+		topLevelStats[prefix+"tot_calls_error"] = s.Failed.TotCalls
+		topLevelStats[prefix+"avg_latency"] = s.Succeeded.AvgLatencyNS
+		topLevelStats[prefix+"p50_latency"] = s.Succeeded.P50LatencyNS
+		topLevelStats[prefix+"p99_latency"] = s.Succeeded.P99LatencyNS
+		topLevelStats[prefix+"avg_latency_error"] = s.Failed.AvgLatencyNS
+		topLevelStats[prefix+"p99_latency_error"] = s.Failed.P99LatencyNS
+	}
+
+	for nodeUUID, s := range GrpcServedBy() {
+		prefix := "grpc_served_by:" + nodeUUID + ":"
+		topLevelStats[prefix+"tot_searches"] = s.Succeeded.TotCalls
+		topLevelStats[prefix+"tot_searches_error"] = s.Failed.TotCalls
+		topLevelStats[prefix+"avg_latency"] = s.Succeeded.AvgLatencyNS
+		topLevelStats[prefix+"p50_latency"] = s.Succeeded.P50LatencyNS
+		topLevelStats[prefix+"p99_latency"] = s.Succeeded.P99LatencyNS
+	}
+
+	for node, c := range GrpcSearchConcurrencies() {
+		prefix := "grpc_node:" + node + ":"
+		topLevelStats[prefix+"searches_in_flight"] = c.InFlight
+		topLevelStats[prefix+"searches_queued"] = c.Queued
+	}
+
+	for node, d := range GrpcNodeLatencies() {
+		topLevelStats["grpc_node:"+node+":latency"] = int64(d)
+	}
+
+	for _, node := range GrpcSuspectNodes() {
+		topLevelStats["grpc_node:"+node+":suspect"] = 1
+	}
+
+	for hostPort, version := range GrpcNodeProtocolVersions() {
+		topLevelStats["grpc_node:"+hostPort+":protocol_version"] = version
+	}
+
 	topLevelStats["tot_grpc_listeners_opened"] =
 		atomic.LoadUint64(&TotGRPCListenersOpened)
 	topLevelStats["tot_grpc_listeners_closed"] =
@@ -506,9 +706,13 @@ func gatherTopLevelStats(rd *recentInfo) map[string]interface{} {
 	topLevelStats["batch_bytes_removed"] = atomic.LoadUint64(&BatchBytesRemoved)
 
 	topLevelStats["tot_batches_flushed_on_maxops"] = atomic.LoadUint64(&TotBatchesFlushedOnMaxOps)
+	topLevelStats["tot_batches_flushed_on_maxbytes"] = atomic.LoadUint64(&TotBatchesFlushedOnMaxBytes)
+	topLevelStats["tot_batches_chunked"] = atomic.LoadUint64(&TotBatchesChunked)
+	topLevelStats["tot_batches_over_maxbytes"] = atomic.LoadUint64(&TotBatchesOverMaxBytes)
 	topLevelStats["tot_batches_flushed_on_timer"] = atomic.LoadUint64(&TotBatchesFlushedOnTimer)
 	topLevelStats["tot_batches_new"] = atomic.LoadUint64(&TotBatchesNew)
 	topLevelStats["tot_batches_merged"] = atomic.LoadUint64(&TotBatchesMerged)
+	topLevelStats["tot_batch_admission_retries"] = atomic.LoadUint64(&TotBatchAdmissionRetries)
 
 	topLevelStats["tot_bleve_dest_opened"] = atomic.LoadUint64(&TotBleveDestOpened)
 	topLevelStats["tot_bleve_dest_closed"] = atomic.LoadUint64(&TotBleveDestClosed)
@@ -518,6 +722,9 @@ func gatherTopLevelStats(rd *recentInfo) map[string]interface{} {
 			atomic.LoadUint64(&TotHerderOnBatchExecuteStartBeg)
 	topLevelStats["total_queries_rejected_by_herder"] =
 		atomic.LoadUint64(&TotHerderQueriesRejected)
+	if CurMemoryPressure != nil {
+		topLevelStats["mem_pressure"] = CurMemoryPressure()
+	}
 
 	return topLevelStats
 }
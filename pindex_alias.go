@@ -82,13 +82,14 @@ func ValidateAlias(indexType, indexName, indexParams string) error {
 
 func CountAlias(mgr *cbgt.Manager,
 	indexName, indexUUID string) (uint64, error) {
-	alias, err := bleveIndexAliasForUserIndexAlias(mgr,
+	alias, remoteClients, err := bleveIndexAliasForUserIndexAlias(mgr,
 		indexName, indexUUID, false, nil, nil, false, "")
 	if err != nil {
 		return 0, fmt.Errorf("alias: CountAlias indexAlias error,"+
 			" indexName: %s, indexUUID: %s, err: %v",
 			indexName, indexUUID, err)
 	}
+	defer closeRemoteClients(remoteClients)
 
 	return alias.DocCount()
 }
@@ -124,13 +125,14 @@ func QueryAlias(mgr *cbgt.Manager, indexName, indexUUID string,
 	// setupContextAndCancelCh always exits
 	defer cancel()
 
-	alias, err := bleveIndexAliasForUserIndexAlias(mgr,
+	alias, remoteClients, err := bleveIndexAliasForUserIndexAlias(mgr,
 		indexName, indexUUID, true,
 		queryCtlParams.Ctl.Consistency, cancelCh, true,
 		queryCtlParams.Ctl.PartitionSelection)
 	if err != nil {
 		return err
 	}
+	defer closeRemoteClients(remoteClients)
 
 	searchResponse, err := alias.SearchInContext(ctx, searchRequest)
 	if err != nil {
@@ -179,15 +181,17 @@ func bleveIndexAliasForUserIndexAlias(mgr *cbgt.Manager,
 	consistencyParams *cbgt.ConsistencyParams,
 	cancelCh <-chan bool, groupByNode bool,
 	partitionSelection string) (
-	bleve.IndexAlias, error) {
+	bleve.IndexAlias, []RemoteClient, error) {
 	alias := bleve.NewIndexAlias()
 
 	indexDefs, _, err := mgr.GetIndexDefs(false)
 	if err != nil {
-		return nil, fmt.Errorf("alias: could not get indexDefs,"+
+		return nil, nil, fmt.Errorf("alias: could not get indexDefs,"+
 			" indexName: %s, err: %v", indexName, err)
 	}
 
+	var remoteClients []RemoteClient
+
 	num := 0
 
 	var fillAlias func(aliasName, aliasUUID string) error
@@ -250,10 +254,12 @@ func bleveIndexAliasForUserIndexAlias(mgr *cbgt.Manager,
 				}
 			} else if strings.HasPrefix(targetDef.Type, "fulltext-index") {
 				var subAlias bleve.IndexAlias
-				subAlias, _, _, err = bleveIndexAlias(mgr,
+				var subRemoteClients []RemoteClient
+				subAlias, subRemoteClients, _, err = bleveIndexAlias(mgr,
 					targetName, targetSpec.IndexUUID, ensureCanRead,
 					consistencyParams, cancelCh, true, nil,
 					partitionSelection, getRemoteClients(mgr))
+				remoteClients = append(remoteClients, subRemoteClients...)
 				if err != nil {
 					return err
 				}
@@ -271,8 +277,9 @@ func bleveIndexAliasForUserIndexAlias(mgr *cbgt.Manager,
 
 	err = fillAlias(indexName, indexUUID)
 	if err != nil {
-		return nil, err
+		closeRemoteClients(remoteClients)
+		return nil, nil, err
 	}
 
-	return alias, nil
+	return alias, remoteClients, nil
 }
@@ -60,6 +60,9 @@ var BatchBytesAdded uint64
 var BatchBytesRemoved uint64
 
 var TotBatchesFlushedOnMaxOps uint64
+var TotBatchesFlushedOnMaxBytes uint64
+var TotBatchesChunked uint64
+var TotBatchesOverMaxBytes uint64
 var TotBatchesFlushedOnTimer uint64
 var TotBatchesNew uint64
 var TotBatchesMerged uint64
@@ -296,6 +299,17 @@ type SearchRequest struct {
 	Limit            *int                    `json:"limit,omitempty"`
 	Offset           *int                    `json:"offset,omitempty"`
 	Collections      []string                `json:"collections,omitempty"`
+
+	// StreamStoredFields, when false, has the streamed hits carry only
+	// their IDs, scores and sort values, without the stored fields,
+	// for the callers that fetch the documents separately.
+	StreamStoredFields *bool `json:"streamStoredFields,omitempty"`
+}
+
+// streamsStoredFields returns whether the stored fields of the hits are
+// included inline in the streamed hits, which is the default.
+func (sr *SearchRequest) streamsStoredFields() bool {
+	return sr.StreamStoredFields == nil || *sr.StreamStoredFields
 }
 
 func (sr *SearchRequest) ConvertToBleveSearchRequest() (*bleve.SearchRequest, error) {
@@ -420,6 +434,8 @@ func NewBleveDest(path string, bindex bleve.Index,
 		stopCh: make(chan struct{}),
 	}
 
+	trackBatchBytes(batchBytesKey(bindex))
+
 	bleveDest.batchReqChs = make([]chan *batchRequest, asyncBatchWorkerCount)
 	for i := 0; i < asyncBatchWorkerCount; i++ {
 		bleveDest.batchReqChs[i] = make(chan *batchRequest, 1)
@@ -1121,6 +1137,68 @@ func indexPartitions(bic BleveIndexCollector) (handlers []cbgt.TaskRequestHandle
 // search requests on hitting the memory threshold for query
 var totQueryRejectOnNotEnoughQuota uint64
 
+// totQueryRejectOnTooManyFacets and totQueryRejectOnTooManyFacetFields
+// track the number of search requests rejected for exceeding the
+// bleveMaxFacets and bleveMaxFacetFields limits respectively.
+var totQueryRejectOnTooManyFacets uint64
+var totQueryRejectOnTooManyFacetFields uint64
+
+// totQueryNoHealthyNodes tracks the number of queries that failed as
+// none of the nodes hosting their pindexes were healthy.
+var totQueryNoHealthyNodes uint64
+
+// validateFacetLimits rejects the search requests that ask for more
+// facets, or for facets over more fields, than allowed by the
+// "bleveMaxFacets" and "bleveMaxFacetFields" manager options, which
+// protects the nodes from expensive aggregation queries.
+func validateFacetLimits(mgr *cbgt.Manager,
+	searchRequest *bleve.SearchRequest) error {
+	if len(searchRequest.Facets) == 0 {
+		return nil
+	}
+
+	options := mgr.Options()
+
+	if v, exists := options["bleveMaxFacets"]; exists {
+		maxFacets, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("bleve: validateFacetLimits"+
+				" atoi: %v, err: %v", v, err)
+		}
+
+		if len(searchRequest.Facets) > maxFacets {
+			atomic.AddUint64(&totQueryRejectOnTooManyFacets, 1)
+			return fmt.Errorf("bleve: bleveMaxFacets exceeded,"+
+				" facets: %d, bleveMaxFacets: %d",
+				len(searchRequest.Facets), maxFacets)
+		}
+	}
+
+	if v, exists := options["bleveMaxFacetFields"]; exists {
+		maxFacetFields, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("bleve: validateFacetLimits"+
+				" atoi: %v, err: %v", v, err)
+		}
+
+		fields := map[string]struct{}{}
+		for _, facet := range searchRequest.Facets {
+			if facet != nil {
+				fields[facet.Field] = struct{}{}
+			}
+		}
+
+		if len(fields) > maxFacetFields {
+			atomic.AddUint64(&totQueryRejectOnTooManyFacetFields, 1)
+			return fmt.Errorf("bleve: bleveMaxFacetFields exceeded,"+
+				" facet fields: %d, bleveMaxFacetFields: %d",
+				len(fields), maxFacetFields)
+		}
+	}
+
+	return nil
+}
+
 // QueryPIndexes defines the part of the JSON query request that
 // allows the client to specify which pindexes the server should
 // consider during query processing.
@@ -1130,19 +1208,62 @@ type QueryPIndexes struct {
 	PIndexNames []string `json:"pindexNames,omitempty"`
 }
 
-func fireQueryEvent(depth int, kind QueryEventKind, dur time.Duration, size uint64) error {
+// QueryCtlExtras are the optional query control params beyond those
+// of cbgt.QueryCtl, where the absolute Deadline hint allows callers to
+// pin a wall-clock instant by which the query must stop, independent
+// of the ctl timeout.  QueryPlan, PIndexHitCounts and PIndexStatus
+// opt into returning the effective scatter plan of the query, the hits
+// contributed by each pindex and the reason for each pindex's
+// contribution, like "no matches", in the debug section of the results.
+// ClusterAction overrides the cluster action of the query's
+// scatter-gather requests, see ClusterActionScatterGatherBackground.
+type QueryCtlExtras struct {
+	Ctl struct {
+		Deadline        time.Time `json:"deadline,omitempty"`
+		ClusterAction   string    `json:"clusterAction,omitempty"`
+		QueryPlan       bool      `json:"queryPlan,omitempty"`
+		PIndexHitCounts bool      `json:"pindexHitCounts,omitempty"`
+		PIndexStatus    bool      `json:"pindexStatus,omitempty"`
+
+		// Labels are forwarded to the remote servers, which attribute
+		// their stats to them.
+		Labels map[string]string `json:"labels,omitempty"`
+
+		// Priority is the admission priority of the query, "high" by
+		// default or "low", which is also forwarded to the remote
+		// servers.
+		Priority string `json:"priority,omitempty"`
+	} `json:"ctl"`
+}
+
+// SearchResultDebug is the opt-in debug section of a search result.
+type SearchResultDebug struct {
+	QueryPlan       *QueryPlan        `json:"queryPlan,omitempty"`
+	PIndexHitCounts map[string]uint64 `json:"pindexHitCounts,omitempty"`
+	PIndexStatus    map[string]string `json:"pindexStatus,omitempty"`
+}
+
+// searchResultWithDebug is a search result decorated with its debug
+// section, and with its execution mode when it's not the regular one.
+type searchResultWithDebug struct {
+	*bleve.SearchResult
+	Debug         *SearchResultDebug `json:"debug,omitempty"`
+	ExecutionMode string             `json:"executionMode,omitempty"`
+}
+
+func fireQueryEvent(depth int, event QueryEvent, size uint64) error {
 	if RegistryQueryEventCallback != nil {
-		return RegistryQueryEventCallback(depth, QueryEvent{Kind: kind, Duration: dur}, size)
+		return RegistryQueryEventCallback(depth, event, size)
 	}
 	return nil
 }
 
 func bleveCtxQueryStartCallback(size uint64) error {
-	return fireQueryEvent(1, EventQueryStart, 0, size)
+	return fireQueryEvent(1, QueryEvent{Kind: EventQueryStart}, size)
 }
 
 func bleveCtxQueryEndCallback(size uint64) error {
-	return fireQueryEvent(1, EventQueryEnd, 0, size)
+	return fireQueryEvent(1, QueryEvent{Kind: EventQueryEnd}, size)
 }
 
 func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
@@ -1167,6 +1288,28 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 			" parsing queryPIndexes, err: %v", err)
 	}
 
+	queryCtlExtras := QueryCtlExtras{}
+	err = UnmarshalJSON(req, &queryCtlExtras)
+	if err != nil {
+		return fmt.Errorf("bleve: QueryBleve"+
+			" parsing queryCtlExtras, err: %v", err)
+	}
+	if queryCtlExtras.Ctl.ClusterAction != "" &&
+		!isScatterGatherClusterAction(queryCtlExtras.Ctl.ClusterAction) {
+		return fmt.Errorf("bleve: QueryBleve"+
+			" unknown clusterAction: %q", queryCtlExtras.Ctl.ClusterAction)
+	}
+	err = validateQueryLabels(queryCtlExtras.Ctl.Labels)
+	if err != nil {
+		return fmt.Errorf("bleve: QueryBleve"+
+			" validating labels, err: %v", err)
+	}
+	priority, err := ParseQueryPriority(queryCtlExtras.Ctl.Priority)
+	if err != nil {
+		return fmt.Errorf("bleve: QueryBleve"+
+			" parsing priority, err: %v", err)
+	}
+
 	var sr *SearchRequest
 	err = UnmarshalJSON(req, &sr)
 	if err != nil {
@@ -1212,6 +1355,11 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 		}
 	}
 
+	err = validateFacetLimits(mgr, searchRequest)
+	if err != nil {
+		return err
+	}
+
 	// phase 1 - set up timeouts, wait for local consistency reqiurements
 	// to be satisfied, could return err 412
 
@@ -1234,17 +1382,60 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 			return err1
 		}
 	}
+	defer closeRemoteClients(remoteClients)
+
+	if !queryCtlExtras.Ctl.Deadline.IsZero() {
+		for _, remoteClient := range remoteClients {
+			if gc, ok := remoteClient.(*GrpcClient); ok {
+				gc.Deadline = queryCtlExtras.Ctl.Deadline
+			}
+		}
+	}
 
 	// estimate memory needed for merging search results from all
 	// the pindexes
 	mergeEstimate := uint64(numPIndexes) * bleve.MemoryNeededForSearchResult(searchRequest)
-	err = fireQueryEvent(0, EventQueryStart, 0, mergeEstimate)
-	if err != nil {
+	// account for the compression buffers of the gRPC streams
+	mergeEstimate = addGrpcCompressionAllowance(mgr, mergeEstimate)
+	// calibrate the estimate by the earlier queries of the index
+	mergeEstimate = correctQueryEstimate(indexName, mergeEstimate)
+	queryEvent := QueryEvent{
+		Kind:       EventQueryStart,
+		IndexName:  indexName,
+		Priority:   priority,
+		CanDegrade: true,
+		Admission:  &QueryAdmission{},
+	}
+	err = fireQueryEvent(0, queryEvent, mergeEstimate)
+	degraded := err == ErrQueryDegraded
+	if err != nil && !degraded {
 		atomic.AddUint64(&totQueryRejectOnNotEnoughQuota, 1)
+		// tell the client which limit the query hit, as the REST layer
+		// only knows of the untyped rejections
+		if qre, ok := err.(*QueryRejectedError); ok {
+			if rw, ok := res.(http.ResponseWriter); ok {
+				writeQueryRejected(rw, qre)
+				return nil
+			}
+		}
 		return err
 	}
 
-	defer fireQueryEvent(0, EventQueryEnd, 0, mergeEstimate)
+	queryEvent.Kind = EventQueryEnd
+	queryEvent.Degraded = degraded
+	var searchResult *bleve.SearchResult
+	defer func() {
+		// the size of the merged results is what the estimate of
+		// the query is of
+		if searchResult != nil {
+			queryEvent.ResultSize = uint64(searchResult.Size())
+		}
+		fireQueryEvent(0, queryEvent, mergeEstimate)
+	}()
+
+	// the fan-out of the query to the remote pindexes shares a request
+	// ID, which correlates the log lines of the nodes
+	ctx, _ = ensureRequestID(ctx)
 
 	// set query start/end callbacks
 	ctx = context.WithValue(ctx, bleve.SearchQueryStartCallbackKey,
@@ -1264,7 +1455,44 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 
 	defer querySupervisor.DeleteEntry(id)
 
-	searchResult, err := alias.SearchInContext(ctx, searchRequest)
+	if queryCtlExtras.Ctl.ClusterAction != "" {
+		ctx, err = WithClusterAction(ctx, queryCtlExtras.Ctl.ClusterAction)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(queryCtlExtras.Ctl.Labels) > 0 {
+		ctx, err = WithQueryLabels(ctx, queryCtlExtras.Ctl.Labels)
+		if err != nil {
+			return err
+		}
+	}
+
+	if priority != QueryPriorityHigh {
+		ctx = WithQueryPriority(ctx, priority)
+	}
+
+	var hitCounts *pindexHitCounts
+	if queryCtlExtras.Ctl.PIndexHitCounts || queryCtlExtras.Ctl.PIndexStatus {
+		hitCounts = &pindexHitCounts{}
+		ctx = withPIndexHitCounts(ctx, hitCounts)
+	}
+
+	if degraded {
+		searchResult, err = spillSearch(ctx, querySpillDir(mgr), alias,
+			searchRequest)
+	} else {
+		searchResult, err = alias.SearchInContext(ctx, searchRequest)
+	}
+	// only the coordinator of a query transforms its hits, where the
+	// scatter-gather requests always target explicit pindexes
+	if transform := getHitTransform(); transform != nil &&
+		searchResult != nil && onlyPIndexes == nil {
+		if er := transformHits(transform, searchResult.Hits); er != nil {
+			return fmt.Errorf("bleve: QueryBleve aborted, err: %v", er)
+		}
+	}
 	if searchResult != nil {
 		// if the query decoration happens for collection targeted or docID
 		// queries for multi collection indexes, then restore the original
@@ -1275,6 +1503,9 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 		err = processSearchResult(&queryCtlParams, indexName, searchResult,
 			remoteClients, err, err1)
 
+		// the failed pindexes, such as of the unhealthy remote nodes, are
+		// in the status of the partial results, which are returned unless
+		// the query asked for complete results
 		if searchResult.Status != nil &&
 			len(searchResult.Status.Errors) > 0 &&
 			queryCtlParams.Ctl.Consistency != nil &&
@@ -1284,7 +1515,36 @@ func QueryBleve(mgr *cbgt.Manager, indexName, indexUUID string,
 				" index partitions: %d", len(searchResult.Status.Errors))
 		}
 
-		mustEncode(res, searchResult)
+		var executionMode string
+		if degraded {
+			executionMode = ExecutionModeDegraded
+		}
+
+		if queryCtlExtras.Ctl.QueryPlan || hitCounts != nil {
+			debug := &SearchResultDebug{}
+			if queryCtlExtras.Ctl.QueryPlan {
+				debug.QueryPlan = newQueryPlan(numPIndexes, remoteClients)
+			}
+			if queryCtlExtras.Ctl.PIndexHitCounts {
+				debug.PIndexHitCounts = hitCounts.Counts()
+			}
+			if queryCtlExtras.Ctl.PIndexStatus {
+				hitCounts.setRemoteReasons(remoteClients)
+				debug.PIndexStatus = hitCounts.Reasons()
+			}
+			mustEncode(res, &searchResultWithDebug{
+				SearchResult:  searchResult,
+				Debug:         debug,
+				ExecutionMode: executionMode,
+			})
+		} else if executionMode != "" {
+			mustEncode(res, &searchResultWithDebug{
+				SearchResult:  searchResult,
+				ExecutionMode: executionMode,
+			})
+		} else {
+			mustEncode(res, searchResult)
+		}
 
 		// update return error status to indicate any errors within the
 		// search result that was already propagated as response.
@@ -1300,6 +1560,12 @@ func processSearchResult(queryCtlParams *cbgt.QueryCtlParams, indexName string,
 	searchResult *bleve.SearchResult, remoteClients []RemoteClient,
 	searchErr, aliasErr error) error {
 	if searchResult != nil {
+		if transports := pindexTransports(remoteClients); transports != nil {
+			atomic.AddUint64(&totRemoteHttpFallback, 1)
+			log.Printf("bleve: processSearchResult, index: %s,"+
+				" remote pindex transports: %v", indexName, transports)
+		}
+
 		if len(searchResult.Hits) > 0 {
 			// if this is a multi collection index, then strip the collection UID
 			// from the hit ID and fill the details of source collection
@@ -1322,6 +1588,15 @@ func processSearchResult(queryCtlParams *cbgt.QueryCtlParams, indexName string,
 			}
 		}
 
+		// when every node of the query was unhealthy, explain that
+		// rather than the per-pindex errors
+		if err := noHealthyNodesErr(indexName, searchResult,
+			remoteClients); err != nil {
+			atomic.AddUint64(&totQueryNoHealthyNodes, 1)
+			log.Printf("bleve: processSearchResult, err: %v", err)
+			return err
+		}
+
 		// check to see if any of the remote searches returned anything
 		// other than 0, 200, 412, 429, these are returned to the user as
 		// error status 400, and appear as phase 0 errors detected late.
@@ -1453,6 +1728,7 @@ func (t *BleveDest) closeLOCKED() error {
 	partitions := t.partitions
 	t.partitions = make(map[string]*BleveDestPartition)
 
+	forgetBatchBytes(batchBytesKey(t.bindex))
 	t.bindex.Close()
 	t.bindex = nil
 
@@ -2234,7 +2510,8 @@ func (t *BleveDestPartition) updateSeqLOCKED(seq uint64) (bool, error) {
 	}
 
 	if (!t.osoSnapshot && seq < t.seqSnapEnd) &&
-		(BleveMaxOpsPerBatch <= 0 || BleveMaxOpsPerBatch > t.batch.Size()) {
+		(BleveMaxOpsPerBatch <= 0 || BleveMaxOpsPerBatch > t.batch.Size()) &&
+		!batchOverMaxBytes(t.batch) {
 		return false, t.lastAsyncBatchErr
 	}
 
@@ -2291,11 +2568,26 @@ func (t *BleveDestPartition) setLastAsyncBatchErr(err error) {
 func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 	bindex bleve.Index) {
 	var targetBatch *bleve.Batch
+	// the batches merged into the targetBatch, kept apart when the max
+	// batch bytes is enabled, as the chunks of the targetBatch
+	var targetParts []*bleve.Batch
 	bdp := make([]*BleveDestPartition, 0, 50)
 	bdpMaxSeqNums := make([]uint64, 0, 50)
 	var ticker *time.Ticker
 	batchFlushDuration := BleveBatchFlushDuration
 
+	// the admissions of the batches are given up once the dest is
+	// closed, see admitBatch
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	index, _, err := bindex.Advanced()
 	if err != nil {
 		log.Printf("pindex_bleve: batchWorker stopped err: %v", err)
@@ -2315,8 +2607,9 @@ func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 	for {
 		// trigger batch execution if we have enough items in batch
 		if targetBatch != nil && targetBatch.Size() >= BleveMaxOpsPerBatch {
-			executeBatch(bdp, bdpMaxSeqNums, bindex, targetBatch)
-			targetBatch = nil
+			executeBatch(ctx, bdp, bdpMaxSeqNums, bindex, targetBatch,
+				targetParts)
+			targetBatch, targetParts = nil, nil
 			atomic.AddUint64(&TotBatchesFlushedOnMaxOps, 1)
 		}
 
@@ -2343,7 +2636,8 @@ func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 				bdp = append(bdp, batchReq.bdp)
 				bdpMaxSeqNums = append(bdpMaxSeqNums, batchReq.bdp.seqMax)
 				batchReq.bdp.m.Unlock()
-				executeBatch(bdp, bdpMaxSeqNums, batchReq.bindex, batchReq.batch)
+				executeBatch(ctx, bdp, bdpMaxSeqNums, batchReq.bindex,
+					batchReq.batch, nil)
 				break
 			}
 
@@ -2356,11 +2650,47 @@ func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 				batchReq.bdp.m.Unlock()
 				bindex = batchReq.bindex
 				targetBatch = batchReq.batch
+				targetParts = append(targetParts[:0], batchReq.batch)
+				atomic.AddUint64(&TotBatchesNew, 1)
+				break
+			}
+
+			// chunk the target batch rather than have it exceed the
+			// max batch bytes
+			if max := maxBatchBytes(); max > 0 &&
+				targetBatch.TotalDocsSize()+
+					batchReq.batch.TotalDocsSize() > max {
+				executeBatch(ctx, bdp, bdpMaxSeqNums, bindex, targetBatch,
+					targetParts)
+				atomic.AddUint64(&TotBatchesFlushedOnMaxBytes, 1)
+
+				bdp = bdp[:0]
+				bdpMaxSeqNums = bdpMaxSeqNums[:0]
+				batchReq.bdp.m.Lock()
+				bdp = append(bdp, batchReq.bdp)
+				bdpMaxSeqNums = append(bdpMaxSeqNums, batchReq.bdp.seqMax)
+				batchReq.bdp.m.Unlock()
+				targetBatch = batchReq.batch
+				targetParts = append(targetParts[:0], batchReq.batch)
 				atomic.AddUint64(&TotBatchesNew, 1)
 				break
 			}
 
+			// merge into a batch of its own, which leaves the first part
+			// intact, unless the parts aren't needed
+			if len(targetParts) == 1 {
+				if maxBatchBytes() > 0 {
+					merged := bindex.NewBatch()
+					merged.Merge(targetBatch)
+					targetBatch = merged
+				} else {
+					targetParts = targetParts[:0]
+				}
+			}
 			targetBatch.Merge(batchReq.batch)
+			if len(targetParts) > 0 {
+				targetParts = append(targetParts, batchReq.batch)
+			}
 			atomic.AddUint64(&TotBatchesMerged, 1)
 			batchReq.bdp.m.Lock()
 			bdp = append(bdp, batchReq.bdp)
@@ -2369,8 +2699,9 @@ func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 
 		case <-tickerCh:
 			if targetBatch != nil {
-				executeBatch(bdp, bdpMaxSeqNums, bindex, targetBatch)
-				targetBatch = nil
+				executeBatch(ctx, bdp, bdpMaxSeqNums, bindex, targetBatch,
+					targetParts)
+				targetBatch, targetParts = nil, nil
 				atomic.AddUint64(&TotBatchesFlushedOnTimer, 1)
 			}
 			tickerCh = nil
@@ -2383,16 +2714,34 @@ func runBatchWorker(requestCh chan *batchRequest, stopCh chan struct{},
 	}
 }
 
-func executeBatch(bdp []*BleveDestPartition, bdpMaxSeqNums []uint64,
-	index bleve.Index, batch *bleve.Batch) {
-	_, err := execute(bdp, bdpMaxSeqNums, index, batch)
+// executeBatch executes the batch, which is merged from the parts of
+// the bdp, if any, where a batch that's too large is executed in the
+// chunks of its parts.  The ctx bounds the admissions of the batch.
+func executeBatch(ctx context.Context, bdp []*BleveDestPartition,
+	bdpMaxSeqNums []uint64, index bleve.Index, batch *bleve.Batch,
+	parts []*bleve.Batch) {
+	_, err := execute(ctx, bdp, bdpMaxSeqNums, index, batch, len(parts) > 1)
+	if err == ErrBatchTooLarge {
+		atomic.AddUint64(&TotBatchesChunked, 1)
+		for i, part := range parts {
+			_, err = execute(ctx, bdp[i:i+1], bdpMaxSeqNums[i:i+1], index,
+				part, false)
+			if err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
 		bdp[0].setLastAsyncBatchErr(err)
 	}
 }
 
-func execute(bdp []*BleveDestPartition, bdpMaxSeqNums []uint64,
-	bindex bleve.Index, batch *bleve.Batch) (bool, error) {
+// execute executes the batch once it's admitted, where a chunkable
+// batch that's too large isn't executed, and the ErrBatchTooLarge is
+// returned for it to be executed in chunks.
+func execute(ctx context.Context, bdp []*BleveDestPartition,
+	bdpMaxSeqNums []uint64, bindex bleve.Index, batch *bleve.Batch,
+	chunkable bool) (bool, error) {
 	if batch == nil {
 		return false, fmt.Errorf("pindex_bleve: executeBatch batch nil")
 	}
@@ -2402,9 +2751,25 @@ func execute(bdp []*BleveDestPartition, bdpMaxSeqNums []uint64,
 	}
 
 	batchTotalDocsSize := batch.TotalDocsSize()
-	atomic.AddUint64(&BatchBytesAdded, batchTotalDocsSize)
+	batchKey := batchBytesKey(bindex)
+	addBatchBytes(batchKey, batchTotalDocsSize)
 
-	err := cbgt.Timer(func() error {
+	// a batch that's not admitted by the app_herder isn't executed
+	err := admitBatch(ctx, batchKey, batchTotalDocsSize)
+	if err == ErrBatchTooLarge && !chunkable {
+		// the batch was admitted on the memory quota, and is bounded
+		// by the chunking ahead to a doc past the max batch bytes
+		atomic.AddUint64(&TotBatchesOverMaxBytes, 1)
+		err = nil
+	}
+	if err != nil {
+		removeBatchBytes(batchKey, batchTotalDocsSize)
+		log.Warnf("pindex_bleve: executeBatch, batch not admitted, err: %v",
+			err)
+		return false, err
+	}
+
+	err = cbgt.Timer(func() error {
 		atomic.AddUint64(&aggregateBDPStats.TotExecuteBatchBeg, 1)
 		err := bindex.Batch(batch)
 		atomic.AddUint64(&aggregateBDPStats.TotExecuteBatchEnd, 1)
@@ -2414,7 +2779,7 @@ func execute(bdp []*BleveDestPartition, bdpMaxSeqNums []uint64,
 		return err
 	}, bdp[0].bdest.stats.TimerBatchStore)
 
-	atomic.AddUint64(&BatchBytesRemoved, batchTotalDocsSize)
+	removeBatchBytes(batchKey, batchTotalDocsSize)
 
 	if err != nil {
 		return false, err
@@ -2453,6 +2818,10 @@ func (t *BleveDestPartition) incRev() {
 var totRemoteHttp uint64
 var totRemoteHttp2 uint64
 
+// totRemoteHttpFallback tracks the number of queries in which some
+// remote pindexes were reached over http instead of gRPC.
+var totRemoteHttpFallback uint64
+
 // ---------------------------------------------------------
 
 var http2ClientLock sync.RWMutex
@@ -2534,6 +2903,7 @@ func bleveIndexAlias(mgr *cbgt.Manager, indexName, indexUUID string,
 		if _, ok := err.(*cbgt.ErrorLocalPIndexHealth); ok {
 			return alias, remoteClients, numPIndexes, err
 		}
+		closeRemoteClients(remoteClients)
 		return nil, nil, 0, err
 	}
 
@@ -2615,7 +2985,7 @@ func addIndexClients(mgr *cbgt.Manager, indexName, indexUUID string,
 		remoteClients = append(remoteClients, indexClient)
 	}
 
-	if groupByNode {
+	if groupByNode && !scatterGatherGroupingDisabled(mgr) {
 		remoteClients, _ = GroupIndexClientsByHostPort(remoteClients)
 	}
 
@@ -18,6 +18,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -687,6 +688,39 @@ func TestSearchRequestExt(t *testing.T) {
 	}
 }
 
+func TestSearchRequestStreamStoredFields(t *testing.T) {
+	tests := []struct {
+		req    string
+		expect bool
+	}{
+		{`{"query": {"query": "california"}, "fields": ["*"]}`, true},
+		{`{"query": {"query": "california"}, "fields": ["*"],` +
+			` "streamStoredFields": true}`, true},
+		{`{"query": {"query": "california"}, "fields": ["*"],` +
+			` "streamStoredFields": false}`, false},
+	}
+
+	for i, test := range tests {
+		var sr *SearchRequest
+		err := json.Unmarshal([]byte(test.req), &sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sr.streamsStoredFields() != test.expect {
+			t.Errorf("(%d) expected streamsStoredFields: %t", i+1, test.expect)
+		}
+
+		// the fields of the non-streamed results are unaffected
+		bsr, err := sr.ConvertToBleveSearchRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bsr.Fields) != 1 {
+			t.Errorf("(%d) expected the fields, got: %v", i+1, bsr.Fields)
+		}
+	}
+}
+
 func getTestCache() *collMetaFieldCache {
 	cache := make(map[string]string)
 	cache["ftsIndexA$colA"] = "_$suid_$cuidA"
@@ -870,3 +904,50 @@ func TestCollectionSearchRequestDocIDQuery(t *testing.T) {
 
 	}
 }
+
+func TestValidateFacetLimits(t *testing.T) {
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource",
+		map[string]string{
+			"bleveMaxFacets":      "3",
+			"bleveMaxFacetFields": "2",
+		})
+
+	newRequest := func(fields ...string) *bleve.SearchRequest {
+		sr := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+		for i, field := range fields {
+			sr.AddFacet(fmt.Sprintf("f%d", i), bleve.NewFacetRequest(field, 3))
+		}
+		return sr
+	}
+
+	facetsBefore := atomic.LoadUint64(&totQueryRejectOnTooManyFacets)
+	fieldsBefore := atomic.LoadUint64(&totQueryRejectOnTooManyFacetFields)
+
+	if err := validateFacetLimits(mgr, newRequest()); err != nil {
+		t.Errorf("expected no facets to pass, err: %v", err)
+	}
+	if err := validateFacetLimits(mgr, newRequest("a", "a", "b")); err != nil {
+		t.Errorf("expected facets within limits to pass, err: %v", err)
+	}
+
+	err := validateFacetLimits(mgr, newRequest("a", "a", "a", "a"))
+	if err == nil || !strings.Contains(err.Error(), "bleveMaxFacets exceeded") {
+		t.Errorf("expected too many facets err, got: %v", err)
+	}
+
+	err = validateFacetLimits(mgr, newRequest("a", "b", "c"))
+	if err == nil ||
+		!strings.Contains(err.Error(), "bleveMaxFacetFields exceeded") {
+		t.Errorf("expected too many facet fields err, got: %v", err)
+	}
+
+	if n := atomic.LoadUint64(&totQueryRejectOnTooManyFacets) -
+		facetsBefore; n != 1 {
+		t.Errorf("expected 1 too many facets rejection, got: %d", n)
+	}
+	if n := atomic.LoadUint64(&totQueryRejectOnTooManyFacetFields) -
+		fieldsBefore; n != 1 {
+		t.Errorf("expected 1 too many facet fields rejection, got: %d", n)
+	}
+}
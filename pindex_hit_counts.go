@@ -0,0 +1,207 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// rpcPIndexHitCountsKey is the metadata key used by the client to ask
+// for the per-pindex hit counts and status reasons of a search, which
+// the server then returns as a JSON encoded trailer under the same key.
+const rpcPIndexHitCountsKey = "rpcpindexhitcounts"
+
+// The reasons for a pindex's contribution to a search, which explain
+// an empty result.
+const (
+	PIndexStatusMatched       = "matched"
+	PIndexStatusNoMatches     = "no matches"
+	PIndexStatusUnavailable   = "unavailable"
+	PIndexStatusNotConsistent = "not consistent"
+	PIndexStatusRejected      = "rejected"
+	PIndexStatusTimedout      = "timed out"
+	PIndexStatusError         = "error"
+	PIndexStatusAbandoned     = "abandoned"
+)
+
+// pindexStatusForHits returns the status reason of a pindex that
+// contributed the given total hits.
+func pindexStatusForHits(total uint64) string {
+	if total > 0 {
+		return PIndexStatusMatched
+	}
+	return PIndexStatusNoMatches
+}
+
+// pindexStatusForHttpStatus returns the status reason of the pindexes
+// of a remote client that failed with the given http status.
+func pindexStatusForHttpStatus(code int) string {
+	switch code {
+	case http.StatusPreconditionFailed:
+		return PIndexStatusNotConsistent
+	case http.StatusTooManyRequests:
+		return PIndexStatusRejected
+	case http.StatusGatewayTimeout:
+		return PIndexStatusTimedout
+	case http.StatusServiceUnavailable:
+		return PIndexStatusUnavailable
+	}
+	return PIndexStatusError
+}
+
+// setRemoteReasons explains the pindexes of the remote clients that
+// failed without reporting on their pindexes, such as http clients.
+func (c *pindexHitCounts) setRemoteReasons(remoteClients []RemoteClient) {
+	for _, remoteClient := range remoteClients {
+		var pindexNames []string
+		switch rc := remoteClient.(type) {
+		case *GrpcClient:
+			pindexNames = rc.PIndexNames
+		case *IndexClient:
+			pindexNames = rc.PIndexNames
+		}
+
+		var reason string
+		switch lastStatus, _ := remoteClient.GetLast(); lastStatus {
+		case http.StatusOK:
+			continue
+		case 0: // never heard back
+			reason = PIndexStatusUnavailable
+		default:
+			reason = pindexStatusForHttpStatus(lastStatus)
+		}
+
+		for _, pindexName := range pindexNames {
+			c.setReason(pindexName, reason)
+		}
+	}
+}
+
+// pindexHitCountsTrailer is the JSON encoding of the trailer.
+type pindexHitCountsTrailer struct {
+	Counts  map[string]uint64 `json:"counts,omitempty"`
+	Reasons map[string]string `json:"reasons,omitempty"`
+}
+
+type pindexHitCountsKeyType string
+
+const pindexHitCountsKey = pindexHitCountsKeyType("pindexHitCounts")
+
+// pindexHitCounts tracks the total hits contributed by each pindex to
+// a search, which helps reveal data skew and empty partitions, along
+// with the status reason of each pindex's contribution.
+type pindexHitCounts struct {
+	m       sync.Mutex
+	counts  map[string]uint64
+	reasons map[string]string
+}
+
+// withPIndexHitCounts returns a ctx that opts a search into tracking
+// its per-pindex hit counts into the given pindexHitCounts.
+func withPIndexHitCounts(ctx context.Context,
+	c *pindexHitCounts) context.Context {
+	return context.WithValue(ctx, pindexHitCountsKey, c)
+}
+
+// pindexHitCountsFromContext returns the pindexHitCounts of the ctx,
+// or nil when the search didn't opt into tracking them.
+func pindexHitCountsFromContext(ctx context.Context) *pindexHitCounts {
+	c, _ := ctx.Value(pindexHitCountsKey).(*pindexHitCounts)
+	return c
+}
+
+func (c *pindexHitCounts) add(pindexName string, hits uint64) {
+	c.m.Lock()
+	if c.counts == nil {
+		c.counts = map[string]uint64{}
+	}
+	c.counts[pindexName] += hits
+	c.m.Unlock()
+}
+
+// setReason records the status reason of a pindex's contribution,
+// where the first reason recorded for a pindex wins.
+func (c *pindexHitCounts) setReason(pindexName, reason string) {
+	c.m.Lock()
+	if c.reasons == nil {
+		c.reasons = map[string]string{}
+	}
+	if _, exists := c.reasons[pindexName]; !exists {
+		c.reasons[pindexName] = reason
+	}
+	c.m.Unlock()
+}
+
+// Reasons returns a copy of the per-pindex status reasons.
+func (c *pindexHitCounts) Reasons() map[string]string {
+	c.m.Lock()
+	rv := make(map[string]string, len(c.reasons))
+	for pindexName, reason := range c.reasons {
+		rv[pindexName] = reason
+	}
+	c.m.Unlock()
+	return rv
+}
+
+// Counts returns a copy of the per-pindex hit counts.
+func (c *pindexHitCounts) Counts() map[string]uint64 {
+	c.m.Lock()
+	rv := make(map[string]uint64, len(c.counts))
+	for pindexName, hits := range c.counts {
+		rv[pindexName] = hits
+	}
+	c.m.Unlock()
+	return rv
+}
+
+// addFromTrailer adds the per-pindex hit counts and status reasons
+// reported by a remote server in its trailer, if any.
+func (c *pindexHitCounts) addFromTrailer(md metadata.MD) error {
+	vals := md.Get(rpcPIndexHitCountsKey)
+	if len(vals) == 0 {
+		return nil
+	}
+
+	var t pindexHitCountsTrailer
+	err := json.Unmarshal([]byte(vals[0]), &t)
+	if err != nil {
+		return err
+	}
+
+	for pindexName, hits := range t.Counts {
+		c.add(pindexName, hits)
+	}
+	for pindexName, reason := range t.Reasons {
+		c.setReason(pindexName, reason)
+	}
+
+	return nil
+}
+
+// trailer returns the metadata for reporting the per-pindex hit counts
+// and status reasons back to a remote client.
+func (c *pindexHitCounts) trailer() (metadata.MD, error) {
+	b, err := json.Marshal(&pindexHitCountsTrailer{
+		Counts:  c.Counts(),
+		Reasons: c.Reasons(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.Pairs(rpcPIndexHitCountsKey, string(b)), nil
+}
@@ -0,0 +1,162 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/couchbase/cbgt"
+)
+
+func TestPIndexHitCountsSumToTotal(t *testing.T) {
+	alias := bleve.NewIndexAlias()
+
+	numDocs := []int{3, 0, 5}
+	for i, n := range numDocs {
+		bindex, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bindex.Close()
+
+		for j := 0; j < n; j++ {
+			err = bindex.Index(fmt.Sprintf("doc-%d-%d", i, j),
+				map[string]interface{}{"name": "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		alias.Add(&cacheBleveIndex{
+			pindex: &cbgt.PIndex{Name: fmt.Sprintf("p%d", i)},
+			bindex: bindex,
+			name:   bindex.Name(),
+		})
+	}
+
+	hitCounts := &pindexHitCounts{}
+	ctx := withPIndexHitCounts(context.Background(), hitCounts)
+
+	res, err := alias.SearchInContext(ctx,
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := hitCounts.Counts()
+	exp := map[string]uint64{"p0": 3, "p1": 0, "p2": 5}
+	if !reflect.DeepEqual(counts, exp) {
+		t.Errorf("expected pindex hit counts: %v, got: %v", exp, counts)
+	}
+
+	var sum uint64
+	for _, hits := range counts {
+		sum += hits
+	}
+	if sum != res.Total {
+		t.Errorf("expected pindex hit counts to sum to total: %d, got: %d",
+			res.Total, sum)
+	}
+}
+
+func TestPIndexHitCountsReasons(t *testing.T) {
+	alias := bleve.NewIndexAlias()
+
+	for i, n := range []int{2, 0} {
+		bindex, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bindex.Close()
+
+		for j := 0; j < n; j++ {
+			err = bindex.Index(fmt.Sprintf("doc-%d", j),
+				map[string]interface{}{"name": "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		alias.Add(&cacheBleveIndex{
+			pindex: &cbgt.PIndex{Name: fmt.Sprintf("p%d", i)},
+			bindex: bindex,
+			name:   bindex.Name(),
+		})
+	}
+	alias.Add(&MissingPIndex{name: "p2"})
+
+	hitCounts := &pindexHitCounts{}
+	ctx := withPIndexHitCounts(context.Background(), hitCounts)
+
+	// the missing pindex fails the search, or shows up in its status
+	alias.SearchInContext(ctx,
+		bleve.NewSearchRequest(bleve.NewMatchAllQuery()))
+
+	hitCounts.setRemoteReasons([]RemoteClient{
+		&GrpcClient{
+			PIndexNames:      []string{"p3", "p4"},
+			lastSearchStatus: 412,
+		},
+		&IndexClient{
+			PIndexNames: []string{"p5"},
+		},
+		&IndexClient{
+			PIndexNames:      []string{"p6"},
+			lastSearchStatus: 200,
+		},
+	})
+
+	exp := map[string]string{
+		"p0": PIndexStatusMatched,
+		"p1": PIndexStatusNoMatches,
+		"p2": PIndexStatusUnavailable,
+		"p3": PIndexStatusNotConsistent,
+		"p4": PIndexStatusNotConsistent,
+		"p5": PIndexStatusUnavailable,
+	}
+	if reasons := hitCounts.Reasons(); !reflect.DeepEqual(reasons, exp) {
+		t.Errorf("expected pindex status reasons: %v, got: %v", exp, reasons)
+	}
+}
+
+func TestPIndexHitCountsTrailer(t *testing.T) {
+	remote := &pindexHitCounts{}
+	remote.add("p0", 2)
+	remote.add("p1", 7)
+	remote.setReason("p0", PIndexStatusMatched)
+
+	trailer, err := remote.trailer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := &pindexHitCounts{}
+	local.add("p2", 1)
+	if err = local.addFromTrailer(trailer); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]uint64{"p0": 2, "p1": 7, "p2": 1}
+	if counts := local.Counts(); !reflect.DeepEqual(counts, exp) {
+		t.Errorf("expected pindex hit counts: %v, got: %v", exp, counts)
+	}
+
+	expReasons := map[string]string{"p0": PIndexStatusMatched}
+	if reasons := local.Reasons(); !reflect.DeepEqual(reasons, expReasons) {
+		t.Errorf("expected pindex status reasons: %v, got: %v",
+			expReasons, reasons)
+	}
+}
@@ -0,0 +1,243 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest"
+	log "github.com/couchbase/clog"
+
+	pb "github.com/couchbase/cbft/protobuf"
+)
+
+// PlanReachabilityInterval is the interval of the background check
+// that pings, over gRPC, every remote node in the plans of the indexes,
+// where 0 disables the check.
+var PlanReachabilityInterval = time.Duration(0)
+
+// PlanReachabilityPingTimeout bounds each ping of the check.
+var PlanReachabilityPingTimeout = 5 * time.Second
+
+// RemoteTransportGRPCTLS is the transport of the nodes reached over
+// gRPC with TLS.
+const RemoteTransportGRPCTLS = "grpc-tls"
+
+// NodeReachability is the outcome of pinging a node.
+type NodeReachability struct {
+	NodeUUID  string `json:"nodeUUID"`
+	HostPort  string `json:"hostPort"`
+	Transport string `json:"transport,omitempty"`
+	Reachable bool   `json:"reachable"`
+	LatencyNS int64  `json:"latencyNS"`
+	Err       string `json:"err,omitempty"`
+}
+
+// PlanReachability is a snapshot of the reachability of the remote
+// nodes in the plans of the indexes, keyed by index name.
+type PlanReachability struct {
+	CheckedAt time.Time                     `json:"checkedAt"`
+	Indexes   map[string][]NodeReachability `json:"indexes"`
+}
+
+var planReachabilityMutex sync.Mutex
+var planReachability *PlanReachability
+
+// PlanReachabilitySnapshot returns the results of the last completed
+// check, or nil when there wasn't one yet.
+func PlanReachabilitySnapshot() *PlanReachability {
+	planReachabilityMutex.Lock()
+	defer planReachabilityMutex.Unlock()
+
+	if planReachability == nil {
+		return nil
+	}
+
+	rv := &PlanReachability{
+		CheckedAt: planReachability.CheckedAt,
+		Indexes:   make(map[string][]NodeReachability, len(planReachability.Indexes)),
+	}
+	for indexName, nodes := range planReachability.Indexes {
+		rv.Indexes[indexName] = append([]NodeReachability(nil), nodes...)
+	}
+	return rv
+}
+
+// StartPlanReachabilityCheck starts the background check, which runs
+// every PlanReachabilityInterval until the stopCh is closed.
+func StartPlanReachabilityCheck(mgr *cbgt.Manager, stopCh <-chan struct{}) {
+	if PlanReachabilityInterval <= 0 {
+		return
+	}
+
+	log.Printf("plan_reachability: started, interval: %v",
+		PlanReachabilityInterval)
+
+	go func() {
+		ticker := time.NewTicker(PlanReachabilityInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+
+			case <-ticker.C:
+				err := checkPlanReachability(mgr,
+					func(nodeDef *cbgt.NodeDef) NodeReachability {
+						return pingNode(mgr, nodeDef)
+					})
+				if err != nil {
+					log.Warnf("plan_reachability: check, err: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// checkPlanReachability pings each remote node in the plans of the
+// indexes once, and then records the results as the latest snapshot.
+func checkPlanReachability(mgr *cbgt.Manager,
+	ping func(*cbgt.NodeDef) NodeReachability) error {
+	planPIndexes, _, err := mgr.GetPlanPIndexes(false)
+	if err != nil {
+		return err
+	}
+
+	nodeDefs, err := mgr.GetNodeDefs(cbgt.NODE_DEFS_WANTED, false)
+	if err != nil {
+		return err
+	}
+
+	rv := &PlanReachability{
+		CheckedAt: time.Now(),
+		Indexes:   map[string][]NodeReachability{},
+	}
+
+	pinged := map[string]NodeReachability{}
+	for indexName, nodeUUIDs := range planNodes(planPIndexes, mgr.UUID()) {
+		for _, nodeUUID := range nodeUUIDs {
+			nr, exists := pinged[nodeUUID]
+			if !exists {
+				nr = NodeReachability{NodeUUID: nodeUUID, Err: "unknown node"}
+				if nodeDefs != nil && nodeDefs.NodeDefs[nodeUUID] != nil {
+					nr = ping(nodeDefs.NodeDefs[nodeUUID])
+				}
+				pinged[nodeUUID] = nr
+			}
+
+			rv.Indexes[indexName] = append(rv.Indexes[indexName], nr)
+		}
+	}
+
+	planReachabilityMutex.Lock()
+	planReachability = rv
+	planReachabilityMutex.Unlock()
+
+	return nil
+}
+
+// planNodes returns the sorted UUIDs of the remote nodes in the plan
+// of each index.
+func planNodes(planPIndexes *cbgt.PlanPIndexes,
+	selfUUID string) map[string][]string {
+	seen := map[string]map[string]bool{}
+	if planPIndexes != nil {
+		for _, planPIndex := range planPIndexes.PlanPIndexes {
+			for nodeUUID := range planPIndex.Nodes {
+				if nodeUUID == selfUUID {
+					continue
+				}
+				if seen[planPIndex.IndexName] == nil {
+					seen[planPIndex.IndexName] = map[string]bool{}
+				}
+				seen[planPIndex.IndexName][nodeUUID] = true
+			}
+		}
+	}
+
+	rv := make(map[string][]string, len(seen))
+	for indexName, nodeUUIDs := range seen {
+		for nodeUUID := range nodeUUIDs {
+			rv[indexName] = append(rv[indexName], nodeUUID)
+		}
+		sort.Strings(rv[indexName])
+	}
+	return rv
+}
+
+// pingNode pings a node with a gRPC health check.
+func pingNode(mgr *cbgt.Manager, nodeDef *cbgt.NodeDef) NodeReachability {
+	rv := NodeReachability{NodeUUID: nodeDef.UUID, HostPort: nodeDef.HostPort}
+
+	hostPort, certInBytes, err := grpcHostPort(nodeDef)
+	if err != nil {
+		rv.Err = err.Error()
+		return rv
+	}
+	rv.HostPort = hostPort
+
+	rv.Transport = RemoteTransportGRPC
+	if len(certInBytes) > 0 {
+		rv.Transport = RemoteTransportGRPCTLS
+	}
+
+	cli, connRef, err := getRpcClient(nodeDef.UUID, hostPort,
+		grpcTLSServerName(mgr, nodeDef), certInBytes)
+	if err != nil {
+		rv.Err = err.Error()
+		return rv
+	}
+	defer connRef.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		PlanReachabilityPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err = cli.Check(ctx, &pb.HealthCheckRequest{Service: "Search"})
+	rv.LatencyNS = int64(time.Since(start))
+	if err != nil {
+		rv.Err = err.Error()
+		return rv
+	}
+
+	rv.Reachable = true
+	return rv
+}
+
+// ---------------------------------------------------------
+
+// PlanReachabilityHandler is a REST handler that returns the results
+// of the last plan reachability check.
+type PlanReachabilityHandler struct{}
+
+func NewPlanReachabilityHandler() *PlanReachabilityHandler {
+	return &PlanReachabilityHandler{}
+}
+
+func (h *PlanReachabilityHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	rv := struct {
+		Status           string            `json:"status"`
+		PlanReachability *PlanReachability `json:"planReachability"`
+	}{
+		Status:           "ok",
+		PlanReachability: PlanReachabilitySnapshot(),
+	}
+	rest.MustEncode(w, rv)
+}
@@ -0,0 +1,51 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestPlanNodes(t *testing.T) {
+	planPIndexes := &cbgt.PlanPIndexes{
+		PlanPIndexes: map[string]*cbgt.PlanPIndex{
+			"a_0": {
+				IndexName: "a",
+				Nodes: map[string]*cbgt.PlanPIndexNode{
+					"self": {}, "n2": {},
+				},
+			},
+			"a_1": {
+				IndexName: "a",
+				Nodes: map[string]*cbgt.PlanPIndexNode{
+					"n3": {}, "n2": {},
+				},
+			},
+			"b_0": {
+				IndexName: "b",
+				Nodes:     map[string]*cbgt.PlanPIndexNode{"self": {}},
+			},
+		},
+	}
+
+	exp := map[string][]string{"a": {"n2", "n3"}}
+	if got := planNodes(planPIndexes, "self"); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected plan nodes: %v, got: %v", exp, got)
+	}
+
+	if got := planNodes(nil, "self"); len(got) != 0 {
+		t.Errorf("expected no plan nodes without a plan, got: %v", got)
+	}
+}
@@ -24,7 +24,8 @@ import (
 
 // TODO finalise the high/low cardinality stats.
 // highCardinalityStats enumerates a minimum essential subset of index
-//  level stats for ns_server/prometheus uses.
+//
+//	level stats for ns_server/prometheus uses.
 var prometheusStats = map[string]string{
 	"doc_count":                      "counter",
 	"total_grpc_internal_queries":    "counter",
@@ -44,26 +45,91 @@ var prometheusStats = map[string]string{
 	"total_grpc_queries_error":       "counter",
 	"total_term_searchers_finished":  "counter",
 
-	"tot_batches_flushed_on_maxops":  "counter",
-	"tot_batches_flushed_on_timer":   "counter",
-	"tot_bleve_dest_opened":          "counter",
-	"tot_bleve_dest_closed":          "counter",
-	"tot_http_limitlisteners_opened": "counter",
-	"tot_http_limitlisteners_closed": "counter",
-	"tot_grpc_listeners_opened":      "counter",
-	"tot_grpc_listeners_closed":      "counter",
-	"tot_grpcs_listeners_opened":     "counter",
-	"tot_grpcs_listeners_closed":     "counter",
-
-	"tot_remote_http2":                 "counter",
-	"tot_remote_grpc":                  "counter",
-	"tot_remote_grpc_tls":              "counter",
-	"tot_queryreject_on_memquota":      "counter",
-	"tot_https_limitlisteners_opened":  "counter",
-	"tot_https_limitlisteners_closed":  "counter",
-	"tot_grpc_queryreject_on_memquota": "counter",
+	"tot_batches_flushed_on_maxops":   "counter",
+	"tot_batches_flushed_on_maxbytes": "counter",
+	"tot_batches_chunked":             "counter",
+	"tot_batches_over_maxbytes":       "counter",
+	"tot_batches_flushed_on_timer":    "counter",
+	"tot_batch_admission_retries":     "counter",
+	"tot_bleve_dest_opened":           "counter",
+	"tot_bleve_dest_closed":           "counter",
+	"tot_http_limitlisteners_opened":  "counter",
+	"tot_http_limitlisteners_closed":  "counter",
+	"tot_grpc_listeners_opened":       "counter",
+	"tot_grpc_listeners_closed":       "counter",
+	"tot_grpcs_listeners_opened":      "counter",
+	"tot_grpcs_listeners_closed":      "counter",
+
+	"tot_remote_http2":                         "counter",
+	"tot_remote_grpc":                          "counter",
+	"tot_remote_grpc_tls":                      "counter",
+	"tot_queryreject_on_memquota":              "counter",
+	"tot_query_degraded":                       "counter",
+	"tot_queryreject_on_too_many_facets":       "counter",
+	"tot_queryreject_on_too_many_facet_fields": "counter",
+	"tot_query_no_healthy_nodes":               "counter",
+	"tot_https_limitlisteners_opened":          "counter",
+	"tot_https_limitlisteners_closed":          "counter",
+	"tot_grpc_queryreject_on_memquota":         "counter",
+
+	"tot_grpc_client_streams":                  "counter",
+	"tot_grpc_client_stream_errors":            "counter",
+	"tot_grpc_client_stream_setup_time":        "counter",
+	"tot_grpc_client_stream_msgs_recv":         "counter",
+	"tot_grpc_client_stream_bytes_recv":        "counter",
+	"tot_grpc_client_slow_calls":               "counter",
+	"tot_grpc_client_self_loop_skipped":        "counter",
+	"tot_grpc_search_retries":                  "counter",
+	"tot_grpc_search_retries_succeeded":        "counter",
+	"tot_grpc_throttled_dispatches":            "counter",
+	"tot_grpc_dispatches_staggered":            "counter",
+	"tot_grpc_dispatch_spread_time":            "counter",
+	"tot_grpc_search_requests_rewritten":       "counter",
+	"tot_grpc_search_requests_rejected":        "counter",
+	"tot_grpc_stream_credit_waits":             "counter",
+	"tot_grpc_stream_flow_control_fallbacks":   "counter",
+	"tot_grpc_stream_write_time":               "counter",
+	"tot_grpc_stream_write_aborts":             "counter",
+	"tot_grpc_isolated_pindexes":               "counter",
+	"tot_grpc_pindexes_abandoned":              "counter",
+	"tot_grpc_ping_failures":                   "counter",
+	"tot_grpc_conn_warmups":                    "counter",
+	"tot_grpc_conn_warmup_failures":            "counter",
+	"tot_grpc_preflight_pruned":                "counter",
+	"tot_grpc_consistency_unsatisfied":         "counter",
+	"tot_grpc_bulk_doc_counts":                 "counter",
+	"tot_grpc_count_cache_hits":                "counter",
+	"tot_grpc_count_cache_misses":              "counter",
+	"tot_grpc_facet_snapshots":                 "counter",
+	"tot_grpc_searches_queued":                 "counter",
+	"tot_grpc_search_queue_timeouts":           "counter",
+	"tot_grpc_capability_fallbacks":            "counter",
+	"tot_grpc_conns_replaced":                  "counter",
+	"tot_grpc_breaker_opened":                  "counter",
+	"tot_grpc_breaker_rejected":                "counter",
+	"num_grpc_breakers_open":                   "gauge",
+	"tot_grpc_stream_msgs_compressed":          "counter",
+	"tot_grpc_stream_msgs_uncompressed":        "counter",
+	"tot_grpc_stream_bytes_before_compression": "counter",
+	"tot_grpc_stream_bytes_after_compression":  "counter",
+	"tot_grpc_consistency_wait_succeeded":      "counter",
+	"tot_grpc_consistency_wait_timedout":       "counter",
+	"tot_grpc_scatter_pool_saturated":          "counter",
+	"tot_grpc_scatter_pool_timeouts":           "counter",
+	"tot_grpc_scatter_pool_yields":             "counter",
+	"num_grpc_scatter_pool_workers":            "gauge",
+	"num_grpc_scatter_pool_busy":               "gauge",
+	"tot_grpc_fanout_queries":                  "counter",
+	"tot_grpc_fanout_pindexes":                 "counter",
+	"tot_grpc_fanout_skipped_filtered":         "counter",
+	"tot_grpc_fanout_skipped_no_port":          "counter",
+	"tot_grpc_fanout_skipped_errored":          "counter",
+	"tot_grpc_fanout_skipped_unreachable":      "counter",
+	"tot_grpc_fanout_http_fallbacks":           "counter",
+	"tot_grpc_fanout_clients":                  "counter",
 
 	"tot_remote_http":                  "counter",
+	"tot_remote_http_fallback":         "counter",
 	"total_queries_rejected_by_herder": "counter",
 	"total_gc":                         "counter",
 	"batch_bytes_added":                "counter",
@@ -71,6 +137,7 @@ var prometheusStats = map[string]string{
 
 	"pct_cpu_gc":                     "gauge",
 	"num_bytes_used_ram":             "gauge",
+	"mem_pressure":                   "gauge",
 	"avg_grpc_queries_latency":       "gauge",
 	"num_files_on_disk":              "gauge",
 	"num_pindexes_actual":            "gauge",
@@ -84,6 +151,17 @@ var prometheusStats = map[string]string{
 	"num_root_filesegments":          "gauge",
 	"num_root_memorysegments":        "gauge",
 	"curr_batches_blocked_by_herder": "gauge",
+
+	"avg_grpc_consistency_wait_time": "gauge",
+	"p50_grpc_consistency_wait_time": "gauge",
+	"p99_grpc_consistency_wait_time": "gauge",
+
+	"avg_grpc_fanout_pindexes": "gauge",
+	"p99_grpc_fanout_pindexes": "gauge",
+	"avg_grpc_fanout_clients":  "gauge",
+	"p99_grpc_fanout_clients":  "gauge",
+	"avg_grpc_fanout_nodes":    "gauge",
+	"p99_grpc_fanout_nodes":    "gauge",
 }
 
 var bline = []byte("\n")
@@ -129,8 +129,12 @@ func (m *HealthCheckResponse) GetStatus() HealthCheckResponse_ServingStatus {
 }
 
 type DocCountRequest struct {
-	IndexName            string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
-	IndexUUID            string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	IndexName string `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID string `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	// When set, the pindexes are all counted in one round trip, where
+	// IndexName is the first of them, which the servers of older
+	// versions count alone.
+	PIndexNames          []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -175,11 +179,24 @@ func (m *DocCountRequest) GetIndexUUID() string {
 	return ""
 }
 
+func (m *DocCountRequest) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
+	}
+	return nil
+}
+
 type DocCountResult struct {
-	DocCount             int64    `protobuf:"varint,1,opt,name=DocCount,proto3" json:"DocCount,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	DocCount int64 `protobuf:"varint,1,opt,name=DocCount,proto3" json:"DocCount,omitempty"`
+	// Keyed by pindex name, for the pindexes of the PIndexNames that
+	// were counted.
+	PIndexDocCounts map[string]int64 `protobuf:"bytes,2,rep,name=PIndexDocCounts,proto3" json:"PIndexDocCounts,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	// Keyed by pindex name, for the pindexes of the PIndexNames that
+	// failed.
+	Errors               map[string]string `protobuf:"bytes,3,rep,name=Errors,proto3" json:"Errors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *DocCountResult) Reset()         { *m = DocCountResult{} }
@@ -214,686 +231,2120 @@ func (m *DocCountResult) GetDocCount() int64 {
 	return 0
 }
 
-// Key is partition or partition/partitionUUID.  Value is seq.
-// For example, a DCP data source might have the key as either
-// "vbucketId" or "vbucketId/vbucketUUID".
-type ConsistencyVectors struct {
-	ConsistencyVector    map[string]uint64 `protobuf:"bytes,1,rep,name=ConsistencyVector,proto3" json:"ConsistencyVector,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+func (m *DocCountResult) GetPIndexDocCounts() map[string]int64 {
+	if m != nil {
+		return m.PIndexDocCounts
+	}
+	return nil
 }
 
-func (m *ConsistencyVectors) Reset()         { *m = ConsistencyVectors{} }
-func (m *ConsistencyVectors) String() string { return proto.CompactTextString(m) }
-func (*ConsistencyVectors) ProtoMessage()    {}
-func (*ConsistencyVectors) Descriptor() ([]byte, []int) {
+func (m *DocCountResult) GetErrors() map[string]string {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+type FieldsRequest struct {
+	IndexName            string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID            string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	PIndexNames          []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FieldsRequest) Reset()         { *m = FieldsRequest{} }
+func (m *FieldsRequest) String() string { return proto.CompactTextString(m) }
+func (*FieldsRequest) ProtoMessage()    {}
+func (*FieldsRequest) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{4}
 }
 
-func (m *ConsistencyVectors) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ConsistencyVectors.Unmarshal(m, b)
+func (m *FieldsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FieldsRequest.Unmarshal(m, b)
 }
-func (m *ConsistencyVectors) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ConsistencyVectors.Marshal(b, m, deterministic)
+func (m *FieldsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FieldsRequest.Marshal(b, m, deterministic)
 }
-func (m *ConsistencyVectors) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ConsistencyVectors.Merge(m, src)
+func (m *FieldsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FieldsRequest.Merge(m, src)
 }
-func (m *ConsistencyVectors) XXX_Size() int {
-	return xxx_messageInfo_ConsistencyVectors.Size(m)
+func (m *FieldsRequest) XXX_Size() int {
+	return xxx_messageInfo_FieldsRequest.Size(m)
 }
-func (m *ConsistencyVectors) XXX_DiscardUnknown() {
-	xxx_messageInfo_ConsistencyVectors.DiscardUnknown(m)
+func (m *FieldsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FieldsRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_ConsistencyVectors proto.InternalMessageInfo
+var xxx_messageInfo_FieldsRequest proto.InternalMessageInfo
 
-func (m *ConsistencyVectors) GetConsistencyVector() map[string]uint64 {
+func (m *FieldsRequest) GetIndexName() string {
 	if m != nil {
-		return m.ConsistencyVector
+		return m.IndexName
+	}
+	return ""
+}
+
+func (m *FieldsRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *FieldsRequest) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
 	}
 	return nil
 }
 
-// ConsistencyParams represent the consistency requirements of a
-// client's request.
-type ConsistencyParams struct {
-	// A Level value of "" means stale is ok; "at_plus" means we need
-	// consistency at least at or beyond the consistency vector but
-	// not before.
-	Level string `protobuf:"bytes,1,opt,name=Level,proto3" json:"Level,omitempty"`
-	// Keyed by indexName.
-	Vectors              map[string]*ConsistencyVectors `protobuf:"bytes,2,rep,name=Vectors,proto3" json:"Vectors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Results              string                         `protobuf:"bytes,3,opt,name=Results,proto3" json:"Results,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}                       `json:"-"`
-	XXX_unrecognized     []byte                         `json:"-"`
-	XXX_sizecache        int32                          `json:"-"`
+type FieldTypes struct {
+	Types                []string `protobuf:"bytes,1,rep,name=Types,proto3" json:"Types,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *ConsistencyParams) Reset()         { *m = ConsistencyParams{} }
-func (m *ConsistencyParams) String() string { return proto.CompactTextString(m) }
-func (*ConsistencyParams) ProtoMessage()    {}
-func (*ConsistencyParams) Descriptor() ([]byte, []int) {
+func (m *FieldTypes) Reset()         { *m = FieldTypes{} }
+func (m *FieldTypes) String() string { return proto.CompactTextString(m) }
+func (*FieldTypes) ProtoMessage()    {}
+func (*FieldTypes) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{5}
 }
 
-func (m *ConsistencyParams) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ConsistencyParams.Unmarshal(m, b)
+func (m *FieldTypes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FieldTypes.Unmarshal(m, b)
 }
-func (m *ConsistencyParams) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ConsistencyParams.Marshal(b, m, deterministic)
+func (m *FieldTypes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FieldTypes.Marshal(b, m, deterministic)
 }
-func (m *ConsistencyParams) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ConsistencyParams.Merge(m, src)
+func (m *FieldTypes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FieldTypes.Merge(m, src)
 }
-func (m *ConsistencyParams) XXX_Size() int {
-	return xxx_messageInfo_ConsistencyParams.Size(m)
+func (m *FieldTypes) XXX_Size() int {
+	return xxx_messageInfo_FieldTypes.Size(m)
 }
-func (m *ConsistencyParams) XXX_DiscardUnknown() {
-	xxx_messageInfo_ConsistencyParams.DiscardUnknown(m)
+func (m *FieldTypes) XXX_DiscardUnknown() {
+	xxx_messageInfo_FieldTypes.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_ConsistencyParams proto.InternalMessageInfo
-
-func (m *ConsistencyParams) GetLevel() string {
-	if m != nil {
-		return m.Level
-	}
-	return ""
-}
+var xxx_messageInfo_FieldTypes proto.InternalMessageInfo
 
-func (m *ConsistencyParams) GetVectors() map[string]*ConsistencyVectors {
+func (m *FieldTypes) GetTypes() []string {
 	if m != nil {
-		return m.Vectors
+		return m.Types
 	}
 	return nil
 }
 
-func (m *ConsistencyParams) GetResults() string {
-	if m != nil {
-		return m.Results
-	}
-	return ""
-}
-
-// QueryCtl defines the JSON parameters that control query execution
-// and which are independent of any specific pindex type.
-type QueryCtl struct {
-	Timeout              int64              `protobuf:"varint,1,opt,name=Timeout,proto3" json:"Timeout,omitempty"`
-	Consistency          *ConsistencyParams `protobuf:"bytes,2,opt,name=Consistency,proto3" json:"Consistency,omitempty"`
-	PartitionSelection   string             `protobuf:"bytes,3,opt,name=PartitionSelection,proto3" json:"PartitionSelection,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
-	XXX_unrecognized     []byte             `json:"-"`
-	XXX_sizecache        int32              `json:"-"`
+type FieldsWithTypesResult struct {
+	// Keyed by field name, for the fields whose type all the
+	// pindexes agree on.
+	FieldTypes map[string]string `protobuf:"bytes,1,rep,name=FieldTypes,proto3" json:"FieldTypes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Keyed by field name, for the fields whose type differs
+	// across the pindexes.
+	Conflicts map[string]*FieldTypes `protobuf:"bytes,2,rep,name=Conflicts,proto3" json:"Conflicts,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Keyed by pindex name, for the pindexes that failed.
+	Errors               map[string]string `protobuf:"bytes,3,rep,name=Errors,proto3" json:"Errors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
-func (m *QueryCtl) Reset()         { *m = QueryCtl{} }
-func (m *QueryCtl) String() string { return proto.CompactTextString(m) }
-func (*QueryCtl) ProtoMessage()    {}
-func (*QueryCtl) Descriptor() ([]byte, []int) {
+func (m *FieldsWithTypesResult) Reset()         { *m = FieldsWithTypesResult{} }
+func (m *FieldsWithTypesResult) String() string { return proto.CompactTextString(m) }
+func (*FieldsWithTypesResult) ProtoMessage()    {}
+func (*FieldsWithTypesResult) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{6}
 }
 
-func (m *QueryCtl) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_QueryCtl.Unmarshal(m, b)
+func (m *FieldsWithTypesResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FieldsWithTypesResult.Unmarshal(m, b)
 }
-func (m *QueryCtl) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_QueryCtl.Marshal(b, m, deterministic)
+func (m *FieldsWithTypesResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FieldsWithTypesResult.Marshal(b, m, deterministic)
 }
-func (m *QueryCtl) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_QueryCtl.Merge(m, src)
+func (m *FieldsWithTypesResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FieldsWithTypesResult.Merge(m, src)
 }
-func (m *QueryCtl) XXX_Size() int {
-	return xxx_messageInfo_QueryCtl.Size(m)
+func (m *FieldsWithTypesResult) XXX_Size() int {
+	return xxx_messageInfo_FieldsWithTypesResult.Size(m)
 }
-func (m *QueryCtl) XXX_DiscardUnknown() {
-	xxx_messageInfo_QueryCtl.DiscardUnknown(m)
+func (m *FieldsWithTypesResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_FieldsWithTypesResult.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_QueryCtl proto.InternalMessageInfo
+var xxx_messageInfo_FieldsWithTypesResult proto.InternalMessageInfo
 
-func (m *QueryCtl) GetTimeout() int64 {
+func (m *FieldsWithTypesResult) GetFieldTypes() map[string]string {
 	if m != nil {
-		return m.Timeout
+		return m.FieldTypes
 	}
-	return 0
+	return nil
 }
 
-func (m *QueryCtl) GetConsistency() *ConsistencyParams {
+func (m *FieldsWithTypesResult) GetConflicts() map[string]*FieldTypes {
 	if m != nil {
-		return m.Consistency
+		return m.Conflicts
 	}
 	return nil
 }
 
-func (m *QueryCtl) GetPartitionSelection() string {
+func (m *FieldsWithTypesResult) GetErrors() map[string]string {
 	if m != nil {
-		return m.PartitionSelection
+		return m.Errors
 	}
-	return ""
+	return nil
 }
 
-// QueryCtlParams defines the JSON that includes the "ctl" part of a
-// query request.  These "ctl" query request parameters are
-// independent of any specific pindex type.
-type QueryCtlParams struct {
-	Ctl                  *QueryCtl `protobuf:"bytes,1,opt,name=Ctl,proto3" json:"Ctl,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
-	XXX_unrecognized     []byte    `json:"-"`
-	XXX_sizecache        int32     `json:"-"`
+type FieldsResult struct {
+	// The sorted, distinct fields across the pindexes.
+	Fields []string `protobuf:"bytes,1,rep,name=Fields,proto3" json:"Fields,omitempty"`
+	// Keyed by pindex name, for the pindexes that failed.
+	Errors               map[string]string `protobuf:"bytes,2,rep,name=Errors,proto3" json:"Errors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
-func (m *QueryCtlParams) Reset()         { *m = QueryCtlParams{} }
-func (m *QueryCtlParams) String() string { return proto.CompactTextString(m) }
-func (*QueryCtlParams) ProtoMessage()    {}
-func (*QueryCtlParams) Descriptor() ([]byte, []int) {
+func (m *FieldsResult) Reset()         { *m = FieldsResult{} }
+func (m *FieldsResult) String() string { return proto.CompactTextString(m) }
+func (*FieldsResult) ProtoMessage()    {}
+func (*FieldsResult) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{7}
 }
 
-func (m *QueryCtlParams) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_QueryCtlParams.Unmarshal(m, b)
+func (m *FieldsResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FieldsResult.Unmarshal(m, b)
 }
-func (m *QueryCtlParams) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_QueryCtlParams.Marshal(b, m, deterministic)
+func (m *FieldsResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FieldsResult.Marshal(b, m, deterministic)
 }
-func (m *QueryCtlParams) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_QueryCtlParams.Merge(m, src)
+func (m *FieldsResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FieldsResult.Merge(m, src)
 }
-func (m *QueryCtlParams) XXX_Size() int {
-	return xxx_messageInfo_QueryCtlParams.Size(m)
+func (m *FieldsResult) XXX_Size() int {
+	return xxx_messageInfo_FieldsResult.Size(m)
 }
-func (m *QueryCtlParams) XXX_DiscardUnknown() {
-	xxx_messageInfo_QueryCtlParams.DiscardUnknown(m)
+func (m *FieldsResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_FieldsResult.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_QueryCtlParams proto.InternalMessageInfo
+var xxx_messageInfo_FieldsResult proto.InternalMessageInfo
 
-func (m *QueryCtlParams) GetCtl() *QueryCtl {
+func (m *FieldsResult) GetFields() []string {
 	if m != nil {
-		return m.Ctl
+		return m.Fields
 	}
 	return nil
 }
 
-type QueryPIndexes struct {
-	// An empty or nil PIndexNames means the query should use all
-	// the pindexes of the index.
-	PIndexNames          []string `protobuf:"bytes,1,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+func (m *FieldsResult) GetErrors() map[string]string {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+type DumpRequest struct {
+	IndexName   string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID   string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	PIndexNames []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+	// One of "all", "doc" or "fields".
+	Kind string `protobuf:"bytes,4,opt,name=Kind,proto3" json:"Kind,omitempty"`
+	// The ID of the doc to dump, for the "doc" kind.
+	DocID                string   `protobuf:"bytes,5,opt,name=DocID,proto3" json:"DocID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *QueryPIndexes) Reset()         { *m = QueryPIndexes{} }
-func (m *QueryPIndexes) String() string { return proto.CompactTextString(m) }
-func (*QueryPIndexes) ProtoMessage()    {}
-func (*QueryPIndexes) Descriptor() ([]byte, []int) {
+func (m *DumpRequest) Reset()         { *m = DumpRequest{} }
+func (m *DumpRequest) String() string { return proto.CompactTextString(m) }
+func (*DumpRequest) ProtoMessage()    {}
+func (*DumpRequest) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{8}
 }
 
-func (m *QueryPIndexes) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_QueryPIndexes.Unmarshal(m, b)
+func (m *DumpRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpRequest.Unmarshal(m, b)
 }
-func (m *QueryPIndexes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_QueryPIndexes.Marshal(b, m, deterministic)
+func (m *DumpRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpRequest.Marshal(b, m, deterministic)
 }
-func (m *QueryPIndexes) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_QueryPIndexes.Merge(m, src)
+func (m *DumpRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpRequest.Merge(m, src)
 }
-func (m *QueryPIndexes) XXX_Size() int {
-	return xxx_messageInfo_QueryPIndexes.Size(m)
+func (m *DumpRequest) XXX_Size() int {
+	return xxx_messageInfo_DumpRequest.Size(m)
 }
-func (m *QueryPIndexes) XXX_DiscardUnknown() {
-	xxx_messageInfo_QueryPIndexes.DiscardUnknown(m)
+func (m *DumpRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_QueryPIndexes proto.InternalMessageInfo
+var xxx_messageInfo_DumpRequest proto.InternalMessageInfo
 
-func (m *QueryPIndexes) GetPIndexNames() []string {
+func (m *DumpRequest) GetIndexName() string {
+	if m != nil {
+		return m.IndexName
+	}
+	return ""
+}
+
+func (m *DumpRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *DumpRequest) GetPIndexNames() []string {
 	if m != nil {
 		return m.PIndexNames
 	}
 	return nil
 }
 
-type SearchRequest struct {
-	Contents             []byte   `protobuf:"bytes,1,opt,name=Contents,proto3" json:"Contents,omitempty"`
-	IndexName            string   `protobuf:"bytes,2,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
-	IndexUUID            string   `protobuf:"bytes,3,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
-	Stream               bool     `protobuf:"varint,4,opt,name=Stream,proto3" json:"Stream,omitempty"`
-	QueryCtlParams       []byte   `protobuf:"bytes,5,opt,name=QueryCtlParams,proto3" json:"QueryCtlParams,omitempty"`
-	QueryPIndexes        []byte   `protobuf:"bytes,6,opt,name=QueryPIndexes,proto3" json:"QueryPIndexes,omitempty"`
+func (m *DumpRequest) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *DumpRequest) GetDocID() string {
+	if m != nil {
+		return m.DocID
+	}
+	return ""
+}
+
+// A DumpResult is either a row dumped by a pindex or an error that
+// the pindex ran into while dumping.
+type DumpResult struct {
+	PIndexName           string   `protobuf:"bytes,1,opt,name=PIndexName,proto3" json:"PIndexName,omitempty"`
+	Key                  []byte   `protobuf:"bytes,2,opt,name=Key,proto3" json:"Key,omitempty"`
+	Value                []byte   `protobuf:"bytes,3,opt,name=Value,proto3" json:"Value,omitempty"`
+	Error                string   `protobuf:"bytes,4,opt,name=Error,proto3" json:"Error,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *SearchRequest) Reset()         { *m = SearchRequest{} }
-func (m *SearchRequest) String() string { return proto.CompactTextString(m) }
-func (*SearchRequest) ProtoMessage()    {}
-func (*SearchRequest) Descriptor() ([]byte, []int) {
+func (m *DumpResult) Reset()         { *m = DumpResult{} }
+func (m *DumpResult) String() string { return proto.CompactTextString(m) }
+func (*DumpResult) ProtoMessage()    {}
+func (*DumpResult) Descriptor() ([]byte, []int) {
 	return fileDescriptor_453745cff914010e, []int{9}
 }
 
-func (m *SearchRequest) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_SearchRequest.Unmarshal(m, b)
+func (m *DumpResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpResult.Unmarshal(m, b)
 }
-func (m *SearchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_SearchRequest.Marshal(b, m, deterministic)
+func (m *DumpResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpResult.Marshal(b, m, deterministic)
 }
-func (m *SearchRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_SearchRequest.Merge(m, src)
+func (m *DumpResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpResult.Merge(m, src)
 }
-func (m *SearchRequest) XXX_Size() int {
-	return xxx_messageInfo_SearchRequest.Size(m)
+func (m *DumpResult) XXX_Size() int {
+	return xxx_messageInfo_DumpResult.Size(m)
 }
-func (m *SearchRequest) XXX_DiscardUnknown() {
-	xxx_messageInfo_SearchRequest.DiscardUnknown(m)
+func (m *DumpResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpResult.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_SearchRequest proto.InternalMessageInfo
+var xxx_messageInfo_DumpResult proto.InternalMessageInfo
 
-func (m *SearchRequest) GetContents() []byte {
+func (m *DumpResult) GetPIndexName() string {
 	if m != nil {
-		return m.Contents
+		return m.PIndexName
+	}
+	return ""
+}
+
+func (m *DumpResult) GetKey() []byte {
+	if m != nil {
+		return m.Key
 	}
 	return nil
 }
 
-func (m *SearchRequest) GetIndexName() string {
+func (m *DumpResult) GetValue() []byte {
 	if m != nil {
-		return m.IndexName
+		return m.Value
 	}
-	return ""
+	return nil
 }
 
-func (m *SearchRequest) GetIndexUUID() string {
+func (m *DumpResult) GetError() string {
 	if m != nil {
-		return m.IndexUUID
+		return m.Error
 	}
 	return ""
 }
 
-func (m *SearchRequest) GetStream() bool {
+type TermDictionaryRequest struct {
+	IndexName   string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID   string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	PIndexNames []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+	Field       string   `protobuf:"bytes,4,opt,name=Field,proto3" json:"Field,omitempty"`
+	// One of "all", "range" or "prefix".
+	Kind string `protobuf:"bytes,5,opt,name=Kind,proto3" json:"Kind,omitempty"`
+	// The inclusive bounds of the terms, for the "range" kind.
+	StartTerm []byte `protobuf:"bytes,6,opt,name=StartTerm,proto3" json:"StartTerm,omitempty"`
+	EndTerm   []byte `protobuf:"bytes,7,opt,name=EndTerm,proto3" json:"EndTerm,omitempty"`
+	// The prefix of the terms, for the "prefix" kind.
+	TermPrefix           []byte   `protobuf:"bytes,8,opt,name=TermPrefix,proto3" json:"TermPrefix,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TermDictionaryRequest) Reset()         { *m = TermDictionaryRequest{} }
+func (m *TermDictionaryRequest) String() string { return proto.CompactTextString(m) }
+func (*TermDictionaryRequest) ProtoMessage()    {}
+func (*TermDictionaryRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{10}
+}
+
+func (m *TermDictionaryRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TermDictionaryRequest.Unmarshal(m, b)
+}
+func (m *TermDictionaryRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TermDictionaryRequest.Marshal(b, m, deterministic)
+}
+func (m *TermDictionaryRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TermDictionaryRequest.Merge(m, src)
+}
+func (m *TermDictionaryRequest) XXX_Size() int {
+	return xxx_messageInfo_TermDictionaryRequest.Size(m)
+}
+func (m *TermDictionaryRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TermDictionaryRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TermDictionaryRequest proto.InternalMessageInfo
+
+func (m *TermDictionaryRequest) GetIndexName() string {
 	if m != nil {
-		return m.Stream
+		return m.IndexName
 	}
-	return false
+	return ""
 }
 
-func (m *SearchRequest) GetQueryCtlParams() []byte {
+func (m *TermDictionaryRequest) GetIndexUUID() string {
 	if m != nil {
-		return m.QueryCtlParams
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *TermDictionaryRequest) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
 	}
 	return nil
 }
 
-func (m *SearchRequest) GetQueryPIndexes() []byte {
+func (m *TermDictionaryRequest) GetField() string {
 	if m != nil {
-		return m.QueryPIndexes
+		return m.Field
+	}
+	return ""
+}
+
+func (m *TermDictionaryRequest) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *TermDictionaryRequest) GetStartTerm() []byte {
+	if m != nil {
+		return m.StartTerm
 	}
 	return nil
 }
 
-type SearchResult struct {
-	Contents             []byte   `protobuf:"bytes,1,opt,name=Contents,proto3" json:"Contents,omitempty"`
+func (m *TermDictionaryRequest) GetEndTerm() []byte {
+	if m != nil {
+		return m.EndTerm
+	}
+	return nil
+}
+
+func (m *TermDictionaryRequest) GetTermPrefix() []byte {
+	if m != nil {
+		return m.TermPrefix
+	}
+	return nil
+}
+
+type TermDictionaryEntry struct {
+	Term                 string   `protobuf:"bytes,1,opt,name=Term,proto3" json:"Term,omitempty"`
+	Count                uint64   `protobuf:"varint,2,opt,name=Count,proto3" json:"Count,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *SearchResult) Reset()         { *m = SearchResult{} }
-func (m *SearchResult) String() string { return proto.CompactTextString(m) }
-func (*SearchResult) ProtoMessage()    {}
-func (*SearchResult) Descriptor() ([]byte, []int) {
-	return fileDescriptor_453745cff914010e, []int{10}
+func (m *TermDictionaryEntry) Reset()         { *m = TermDictionaryEntry{} }
+func (m *TermDictionaryEntry) String() string { return proto.CompactTextString(m) }
+func (*TermDictionaryEntry) ProtoMessage()    {}
+func (*TermDictionaryEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{11}
 }
 
-func (m *SearchResult) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_SearchResult.Unmarshal(m, b)
+func (m *TermDictionaryEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TermDictionaryEntry.Unmarshal(m, b)
 }
-func (m *SearchResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_SearchResult.Marshal(b, m, deterministic)
+func (m *TermDictionaryEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TermDictionaryEntry.Marshal(b, m, deterministic)
 }
-func (m *SearchResult) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_SearchResult.Merge(m, src)
+func (m *TermDictionaryEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TermDictionaryEntry.Merge(m, src)
 }
-func (m *SearchResult) XXX_Size() int {
-	return xxx_messageInfo_SearchResult.Size(m)
+func (m *TermDictionaryEntry) XXX_Size() int {
+	return xxx_messageInfo_TermDictionaryEntry.Size(m)
 }
-func (m *SearchResult) XXX_DiscardUnknown() {
-	xxx_messageInfo_SearchResult.DiscardUnknown(m)
+func (m *TermDictionaryEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_TermDictionaryEntry.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_SearchResult proto.InternalMessageInfo
+var xxx_messageInfo_TermDictionaryEntry proto.InternalMessageInfo
 
-func (m *SearchResult) GetContents() []byte {
+func (m *TermDictionaryEntry) GetTerm() string {
 	if m != nil {
-		return m.Contents
+		return m.Term
 	}
-	return nil
+	return ""
 }
 
-// A stream SearchResult describes the streaming results
-// of executing a SearchRequest.
-type StreamSearchResults struct {
-	// Types that are valid to be assigned to Contents:
-	//	*StreamSearchResults_Hits
-	//	*StreamSearchResults_SearchResult
-	Contents             isStreamSearchResults_Contents `protobuf_oneof:"Contents"`
-	XXX_NoUnkeyedLiteral struct{}                       `json:"-"`
-	XXX_unrecognized     []byte                         `json:"-"`
-	XXX_sizecache        int32                          `json:"-"`
+func (m *TermDictionaryEntry) GetCount() uint64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
 }
 
-func (m *StreamSearchResults) Reset()         { *m = StreamSearchResults{} }
-func (m *StreamSearchResults) String() string { return proto.CompactTextString(m) }
-func (*StreamSearchResults) ProtoMessage()    {}
-func (*StreamSearchResults) Descriptor() ([]byte, []int) {
-	return fileDescriptor_453745cff914010e, []int{11}
+// A TermDictionaryResult is a batch of the sorted terms of the field,
+// merged across the pindexes, with their counts summed up.
+type TermDictionaryResult struct {
+	Entries              []*TermDictionaryEntry `protobuf:"bytes,1,rep,name=Entries,proto3" json:"Entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
+	XXX_unrecognized     []byte                 `json:"-"`
+	XXX_sizecache        int32                  `json:"-"`
 }
 
-func (m *StreamSearchResults) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_StreamSearchResults.Unmarshal(m, b)
+func (m *TermDictionaryResult) Reset()         { *m = TermDictionaryResult{} }
+func (m *TermDictionaryResult) String() string { return proto.CompactTextString(m) }
+func (*TermDictionaryResult) ProtoMessage()    {}
+func (*TermDictionaryResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{12}
 }
-func (m *StreamSearchResults) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_StreamSearchResults.Marshal(b, m, deterministic)
+
+func (m *TermDictionaryResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TermDictionaryResult.Unmarshal(m, b)
 }
-func (m *StreamSearchResults) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_StreamSearchResults.Merge(m, src)
+func (m *TermDictionaryResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TermDictionaryResult.Marshal(b, m, deterministic)
 }
-func (m *StreamSearchResults) XXX_Size() int {
-	return xxx_messageInfo_StreamSearchResults.Size(m)
+func (m *TermDictionaryResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TermDictionaryResult.Merge(m, src)
 }
-func (m *StreamSearchResults) XXX_DiscardUnknown() {
-	xxx_messageInfo_StreamSearchResults.DiscardUnknown(m)
+func (m *TermDictionaryResult) XXX_Size() int {
+	return xxx_messageInfo_TermDictionaryResult.Size(m)
+}
+func (m *TermDictionaryResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_TermDictionaryResult.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_StreamSearchResults proto.InternalMessageInfo
+var xxx_messageInfo_TermDictionaryResult proto.InternalMessageInfo
 
-type isStreamSearchResults_Contents interface {
-	isStreamSearchResults_Contents()
+func (m *TermDictionaryResult) GetEntries() []*TermDictionaryEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
 }
 
-type StreamSearchResults_Hits struct {
-	Hits *StreamSearchResults_Batch `protobuf:"bytes,1,opt,name=Hits,proto3,oneof"`
+// Key is partition or partition/partitionUUID.  Value is seq.
+// For example, a DCP data source might have the key as either
+// "vbucketId" or "vbucketId/vbucketUUID".
+type ConsistencyVectors struct {
+	ConsistencyVector    map[string]uint64 `protobuf:"bytes,1,rep,name=ConsistencyVector,proto3" json:"ConsistencyVector,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
-type StreamSearchResults_SearchResult struct {
-	SearchResult []byte `protobuf:"bytes,2,opt,name=SearchResult,proto3,oneof"`
+func (m *ConsistencyVectors) Reset()         { *m = ConsistencyVectors{} }
+func (m *ConsistencyVectors) String() string { return proto.CompactTextString(m) }
+func (*ConsistencyVectors) ProtoMessage()    {}
+func (*ConsistencyVectors) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{13}
 }
 
-func (*StreamSearchResults_Hits) isStreamSearchResults_Contents() {}
+func (m *ConsistencyVectors) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsistencyVectors.Unmarshal(m, b)
+}
+func (m *ConsistencyVectors) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsistencyVectors.Marshal(b, m, deterministic)
+}
+func (m *ConsistencyVectors) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsistencyVectors.Merge(m, src)
+}
+func (m *ConsistencyVectors) XXX_Size() int {
+	return xxx_messageInfo_ConsistencyVectors.Size(m)
+}
+func (m *ConsistencyVectors) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsistencyVectors.DiscardUnknown(m)
+}
 
-func (*StreamSearchResults_SearchResult) isStreamSearchResults_Contents() {}
+var xxx_messageInfo_ConsistencyVectors proto.InternalMessageInfo
 
-func (m *StreamSearchResults) GetContents() isStreamSearchResults_Contents {
+func (m *ConsistencyVectors) GetConsistencyVector() map[string]uint64 {
 	if m != nil {
-		return m.Contents
+		return m.ConsistencyVector
 	}
 	return nil
 }
 
-func (m *StreamSearchResults) GetHits() *StreamSearchResults_Batch {
-	if x, ok := m.GetContents().(*StreamSearchResults_Hits); ok {
-		return x.Hits
-	}
-	return nil
+// ConsistencyParams represent the consistency requirements of a
+// client's request.
+type ConsistencyParams struct {
+	// A Level value of "" means stale is ok; "at_plus" means we need
+	// consistency at least at or beyond the consistency vector but
+	// not before.
+	Level string `protobuf:"bytes,1,opt,name=Level,proto3" json:"Level,omitempty"`
+	// Keyed by indexName.
+	Vectors              map[string]*ConsistencyVectors `protobuf:"bytes,2,rep,name=Vectors,proto3" json:"Vectors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Results              string                         `protobuf:"bytes,3,opt,name=Results,proto3" json:"Results,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                       `json:"-"`
+	XXX_unrecognized     []byte                         `json:"-"`
+	XXX_sizecache        int32                          `json:"-"`
 }
 
-func (m *StreamSearchResults) GetSearchResult() []byte {
-	if x, ok := m.GetContents().(*StreamSearchResults_SearchResult); ok {
-		return x.SearchResult
-	}
-	return nil
+func (m *ConsistencyParams) Reset()         { *m = ConsistencyParams{} }
+func (m *ConsistencyParams) String() string { return proto.CompactTextString(m) }
+func (*ConsistencyParams) ProtoMessage()    {}
+func (*ConsistencyParams) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{14}
 }
 
-// XXX_OneofFuncs is for the internal use of the proto package.
-func (*StreamSearchResults) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
-	return _StreamSearchResults_OneofMarshaler, _StreamSearchResults_OneofUnmarshaler, _StreamSearchResults_OneofSizer, []interface{}{
-		(*StreamSearchResults_Hits)(nil),
-		(*StreamSearchResults_SearchResult)(nil),
+func (m *ConsistencyParams) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsistencyParams.Unmarshal(m, b)
+}
+func (m *ConsistencyParams) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsistencyParams.Marshal(b, m, deterministic)
+}
+func (m *ConsistencyParams) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsistencyParams.Merge(m, src)
+}
+func (m *ConsistencyParams) XXX_Size() int {
+	return xxx_messageInfo_ConsistencyParams.Size(m)
+}
+func (m *ConsistencyParams) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsistencyParams.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConsistencyParams proto.InternalMessageInfo
+
+func (m *ConsistencyParams) GetLevel() string {
+	if m != nil {
+		return m.Level
 	}
+	return ""
 }
 
-func _StreamSearchResults_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
-	m := msg.(*StreamSearchResults)
-	// Contents
-	switch x := m.Contents.(type) {
-	case *StreamSearchResults_Hits:
-		b.EncodeVarint(1<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.Hits); err != nil {
-			return err
-		}
-	case *StreamSearchResults_SearchResult:
-		b.EncodeVarint(2<<3 | proto.WireBytes)
-		b.EncodeRawBytes(x.SearchResult)
-	case nil:
-	default:
-		return fmt.Errorf("StreamSearchResults.Contents has unexpected type %T", x)
+func (m *ConsistencyParams) GetVectors() map[string]*ConsistencyVectors {
+	if m != nil {
+		return m.Vectors
 	}
 	return nil
 }
 
-func _StreamSearchResults_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
-	m := msg.(*StreamSearchResults)
-	switch tag {
-	case 1: // Contents.Hits
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(StreamSearchResults_Batch)
-		err := b.DecodeMessage(msg)
-		m.Contents = &StreamSearchResults_Hits{msg}
-		return true, err
-	case 2: // Contents.SearchResult
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		x, err := b.DecodeRawBytes(true)
-		m.Contents = &StreamSearchResults_SearchResult{x}
-		return true, err
-	default:
-		return false, nil
+func (m *ConsistencyParams) GetResults() string {
+	if m != nil {
+		return m.Results
 	}
+	return ""
 }
 
-func _StreamSearchResults_OneofSizer(msg proto.Message) (n int) {
-	m := msg.(*StreamSearchResults)
-	// Contents
-	switch x := m.Contents.(type) {
-	case *StreamSearchResults_Hits:
-		s := proto.Size(x.Hits)
-		n += 1 // tag and wire
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *StreamSearchResults_SearchResult:
-		n += 1 // tag and wire
-		n += proto.SizeVarint(uint64(len(x.SearchResult)))
-		n += len(x.SearchResult)
+// QueryCtl defines the JSON parameters that control query execution
+// and which are independent of any specific pindex type.
+type QueryCtl struct {
+	Timeout              int64              `protobuf:"varint,1,opt,name=Timeout,proto3" json:"Timeout,omitempty"`
+	Consistency          *ConsistencyParams `protobuf:"bytes,2,opt,name=Consistency,proto3" json:"Consistency,omitempty"`
+	PartitionSelection   string             `protobuf:"bytes,3,opt,name=PartitionSelection,proto3" json:"PartitionSelection,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *QueryCtl) Reset()         { *m = QueryCtl{} }
+func (m *QueryCtl) String() string { return proto.CompactTextString(m) }
+func (*QueryCtl) ProtoMessage()    {}
+func (*QueryCtl) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{15}
+}
+
+func (m *QueryCtl) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryCtl.Unmarshal(m, b)
+}
+func (m *QueryCtl) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryCtl.Marshal(b, m, deterministic)
+}
+func (m *QueryCtl) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryCtl.Merge(m, src)
+}
+func (m *QueryCtl) XXX_Size() int {
+	return xxx_messageInfo_QueryCtl.Size(m)
+}
+func (m *QueryCtl) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryCtl.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryCtl proto.InternalMessageInfo
+
+func (m *QueryCtl) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *QueryCtl) GetConsistency() *ConsistencyParams {
+	if m != nil {
+		return m.Consistency
+	}
+	return nil
+}
+
+func (m *QueryCtl) GetPartitionSelection() string {
+	if m != nil {
+		return m.PartitionSelection
+	}
+	return ""
+}
+
+// QueryCtlParams defines the JSON that includes the "ctl" part of a
+// query request.  These "ctl" query request parameters are
+// independent of any specific pindex type.
+type QueryCtlParams struct {
+	Ctl                  *QueryCtl `protobuf:"bytes,1,opt,name=Ctl,proto3" json:"Ctl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *QueryCtlParams) Reset()         { *m = QueryCtlParams{} }
+func (m *QueryCtlParams) String() string { return proto.CompactTextString(m) }
+func (*QueryCtlParams) ProtoMessage()    {}
+func (*QueryCtlParams) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{16}
+}
+
+func (m *QueryCtlParams) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryCtlParams.Unmarshal(m, b)
+}
+func (m *QueryCtlParams) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryCtlParams.Marshal(b, m, deterministic)
+}
+func (m *QueryCtlParams) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryCtlParams.Merge(m, src)
+}
+func (m *QueryCtlParams) XXX_Size() int {
+	return xxx_messageInfo_QueryCtlParams.Size(m)
+}
+func (m *QueryCtlParams) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryCtlParams.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryCtlParams proto.InternalMessageInfo
+
+func (m *QueryCtlParams) GetCtl() *QueryCtl {
+	if m != nil {
+		return m.Ctl
+	}
+	return nil
+}
+
+type QueryPIndexes struct {
+	// An empty or nil PIndexNames means the query should use all
+	// the pindexes of the index.
+	PIndexNames          []string `protobuf:"bytes,1,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QueryPIndexes) Reset()         { *m = QueryPIndexes{} }
+func (m *QueryPIndexes) String() string { return proto.CompactTextString(m) }
+func (*QueryPIndexes) ProtoMessage()    {}
+func (*QueryPIndexes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{17}
+}
+
+func (m *QueryPIndexes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryPIndexes.Unmarshal(m, b)
+}
+func (m *QueryPIndexes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryPIndexes.Marshal(b, m, deterministic)
+}
+func (m *QueryPIndexes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryPIndexes.Merge(m, src)
+}
+func (m *QueryPIndexes) XXX_Size() int {
+	return xxx_messageInfo_QueryPIndexes.Size(m)
+}
+func (m *QueryPIndexes) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryPIndexes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryPIndexes proto.InternalMessageInfo
+
+func (m *QueryPIndexes) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
+	}
+	return nil
+}
+
+type SearchRequest struct {
+	Contents       []byte `protobuf:"bytes,1,opt,name=Contents,proto3" json:"Contents,omitempty"`
+	IndexName      string `protobuf:"bytes,2,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID      string `protobuf:"bytes,3,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	Stream         bool   `protobuf:"varint,4,opt,name=Stream,proto3" json:"Stream,omitempty"`
+	QueryCtlParams []byte `protobuf:"bytes,5,opt,name=QueryCtlParams,proto3" json:"QueryCtlParams,omitempty"`
+	QueryPIndexes  []byte `protobuf:"bytes,6,opt,name=QueryPIndexes,proto3" json:"QueryPIndexes,omitempty"`
+	// Whether the client asks for the interim facets of the pindexes as
+	// they complete, ahead of the SearchResult.
+	StreamFacets         bool     `protobuf:"varint,7,opt,name=StreamFacets,proto3" json:"StreamFacets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchRequest) Reset()         { *m = SearchRequest{} }
+func (m *SearchRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchRequest) ProtoMessage()    {}
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{18}
+}
+
+func (m *SearchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchRequest.Unmarshal(m, b)
+}
+func (m *SearchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchRequest.Marshal(b, m, deterministic)
+}
+func (m *SearchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchRequest.Merge(m, src)
+}
+func (m *SearchRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchRequest.Size(m)
+}
+func (m *SearchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchRequest proto.InternalMessageInfo
+
+func (m *SearchRequest) GetContents() []byte {
+	if m != nil {
+		return m.Contents
+	}
+	return nil
+}
+
+func (m *SearchRequest) GetIndexName() string {
+	if m != nil {
+		return m.IndexName
+	}
+	return ""
+}
+
+func (m *SearchRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *SearchRequest) GetStream() bool {
+	if m != nil {
+		return m.Stream
+	}
+	return false
+}
+
+func (m *SearchRequest) GetQueryCtlParams() []byte {
+	if m != nil {
+		return m.QueryCtlParams
+	}
+	return nil
+}
+
+func (m *SearchRequest) GetQueryPIndexes() []byte {
+	if m != nil {
+		return m.QueryPIndexes
+	}
+	return nil
+}
+
+func (m *SearchRequest) GetStreamFacets() bool {
+	if m != nil {
+		return m.StreamFacets
+	}
+	return false
+}
+
+type SearchResult struct {
+	Contents             []byte   `protobuf:"bytes,1,opt,name=Contents,proto3" json:"Contents,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchResult) Reset()         { *m = SearchResult{} }
+func (m *SearchResult) String() string { return proto.CompactTextString(m) }
+func (*SearchResult) ProtoMessage()    {}
+func (*SearchResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{19}
+}
+
+func (m *SearchResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchResult.Unmarshal(m, b)
+}
+func (m *SearchResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchResult.Marshal(b, m, deterministic)
+}
+func (m *SearchResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchResult.Merge(m, src)
+}
+func (m *SearchResult) XXX_Size() int {
+	return xxx_messageInfo_SearchResult.Size(m)
+}
+func (m *SearchResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchResult proto.InternalMessageInfo
+
+func (m *SearchResult) GetContents() []byte {
+	if m != nil {
+		return m.Contents
+	}
+	return nil
+}
+
+// A stream SearchResult describes the streaming results
+// of executing a SearchRequest.
+type StreamSearchResults struct {
+	// Types that are valid to be assigned to Contents:
+	//	*StreamSearchResults_Hits
+	//	*StreamSearchResults_SearchResult
+	//	*StreamSearchResults_Facets
+	Contents isStreamSearchResults_Contents `protobuf_oneof:"Contents"`
+	// The encoding of the Hits Bytes or the SearchResult, where ""
+	// means they're not compressed, which may differ per message of
+	// a stream.
+	ContentEncoding string `protobuf:"bytes,3,opt,name=ContentEncoding,proto3" json:"ContentEncoding,omitempty"`
+	// The pindexes of a grouped search that failed on their own, sent
+	// along with the SearchResult, where the results still carry the
+	// hits of the other pindexes of the group.
+	PIndexErrors         []*PIndexError `protobuf:"bytes,4,rep,name=PIndexErrors,proto3" json:"PIndexErrors,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *StreamSearchResults) Reset()         { *m = StreamSearchResults{} }
+func (m *StreamSearchResults) String() string { return proto.CompactTextString(m) }
+func (*StreamSearchResults) ProtoMessage()    {}
+func (*StreamSearchResults) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{20}
+}
+
+func (m *StreamSearchResults) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamSearchResults.Unmarshal(m, b)
+}
+func (m *StreamSearchResults) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamSearchResults.Marshal(b, m, deterministic)
+}
+func (m *StreamSearchResults) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamSearchResults.Merge(m, src)
+}
+func (m *StreamSearchResults) XXX_Size() int {
+	return xxx_messageInfo_StreamSearchResults.Size(m)
+}
+func (m *StreamSearchResults) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamSearchResults.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamSearchResults proto.InternalMessageInfo
+
+type isStreamSearchResults_Contents interface {
+	isStreamSearchResults_Contents()
+}
+
+type StreamSearchResults_Hits struct {
+	Hits *StreamSearchResults_Batch `protobuf:"bytes,1,opt,name=Hits,proto3,oneof"`
+}
+
+type StreamSearchResults_SearchResult struct {
+	SearchResult []byte `protobuf:"bytes,2,opt,name=SearchResult,proto3,oneof"`
+}
+
+type StreamSearchResults_Facets struct {
+	Facets []byte `protobuf:"bytes,5,opt,name=Facets,proto3,oneof"`
+}
+
+func (*StreamSearchResults_Hits) isStreamSearchResults_Contents() {}
+
+func (*StreamSearchResults_SearchResult) isStreamSearchResults_Contents() {}
+
+func (*StreamSearchResults_Facets) isStreamSearchResults_Contents() {}
+
+func (m *StreamSearchResults) GetContents() isStreamSearchResults_Contents {
+	if m != nil {
+		return m.Contents
+	}
+	return nil
+}
+
+func (m *StreamSearchResults) GetHits() *StreamSearchResults_Batch {
+	if x, ok := m.GetContents().(*StreamSearchResults_Hits); ok {
+		return x.Hits
+	}
+	return nil
+}
+
+func (m *StreamSearchResults) GetSearchResult() []byte {
+	if x, ok := m.GetContents().(*StreamSearchResults_SearchResult); ok {
+		return x.SearchResult
+	}
+	return nil
+}
+
+func (m *StreamSearchResults) GetFacets() []byte {
+	if x, ok := m.GetContents().(*StreamSearchResults_Facets); ok {
+		return x.Facets
+	}
+	return nil
+}
+
+func (m *StreamSearchResults) GetContentEncoding() string {
+	if m != nil {
+		return m.ContentEncoding
+	}
+	return ""
+}
+
+func (m *StreamSearchResults) GetPIndexErrors() []*PIndexError {
+	if m != nil {
+		return m.PIndexErrors
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*StreamSearchResults) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _StreamSearchResults_OneofMarshaler, _StreamSearchResults_OneofUnmarshaler, _StreamSearchResults_OneofSizer, []interface{}{
+		(*StreamSearchResults_Hits)(nil),
+		(*StreamSearchResults_SearchResult)(nil),
+		(*StreamSearchResults_Facets)(nil),
+	}
+}
+
+func _StreamSearchResults_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*StreamSearchResults)
+	// Contents
+	switch x := m.Contents.(type) {
+	case *StreamSearchResults_Hits:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Hits); err != nil {
+			return err
+		}
+	case *StreamSearchResults_SearchResult:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.SearchResult)
+	case *StreamSearchResults_Facets:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.Facets)
+	case nil:
+	default:
+		return fmt.Errorf("StreamSearchResults.Contents has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _StreamSearchResults_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*StreamSearchResults)
+	switch tag {
+	case 1: // Contents.Hits
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(StreamSearchResults_Batch)
+		err := b.DecodeMessage(msg)
+		m.Contents = &StreamSearchResults_Hits{msg}
+		return true, err
+	case 2: // Contents.SearchResult
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Contents = &StreamSearchResults_SearchResult{x}
+		return true, err
+	case 5: // Contents.Facets
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Contents = &StreamSearchResults_Facets{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _StreamSearchResults_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*StreamSearchResults)
+	// Contents
+	switch x := m.Contents.(type) {
+	case *StreamSearchResults_Hits:
+		s := proto.Size(x.Hits)
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *StreamSearchResults_SearchResult:
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(len(x.SearchResult)))
+		n += len(x.SearchResult)
+	case *StreamSearchResults_Facets:
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(len(x.Facets)))
+		n += len(x.Facets)
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
 	}
-	return n
+	return n
+}
+
+type StreamSearchResults_Batch struct {
+	Bytes                []byte   `protobuf:"bytes,1,opt,name=Bytes,proto3" json:"Bytes,omitempty"`
+	Offsets              []uint64 `protobuf:"varint,2,rep,packed,name=Offsets,proto3" json:"Offsets,omitempty"`
+	Total                uint64   `protobuf:"varint,3,opt,name=Total,proto3" json:"Total,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamSearchResults_Batch) Reset()         { *m = StreamSearchResults_Batch{} }
+func (m *StreamSearchResults_Batch) String() string { return proto.CompactTextString(m) }
+func (*StreamSearchResults_Batch) ProtoMessage()    {}
+func (*StreamSearchResults_Batch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{20, 0}
+}
+
+func (m *StreamSearchResults_Batch) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamSearchResults_Batch.Unmarshal(m, b)
+}
+func (m *StreamSearchResults_Batch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamSearchResults_Batch.Marshal(b, m, deterministic)
+}
+func (m *StreamSearchResults_Batch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamSearchResults_Batch.Merge(m, src)
+}
+func (m *StreamSearchResults_Batch) XXX_Size() int {
+	return xxx_messageInfo_StreamSearchResults_Batch.Size(m)
+}
+func (m *StreamSearchResults_Batch) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamSearchResults_Batch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamSearchResults_Batch proto.InternalMessageInfo
+
+func (m *StreamSearchResults_Batch) GetBytes() []byte {
+	if m != nil {
+		return m.Bytes
+	}
+	return nil
+}
+
+func (m *StreamSearchResults_Batch) GetOffsets() []uint64 {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+func (m *StreamSearchResults_Batch) GetTotal() uint64 {
+	if m != nil {
+		return m.Total
+	}
+	return 0
+}
+
+// A PIndexError is the failure of a pindex of a grouped search, with
+// the code and the message of its status.
+type PIndexError struct {
+	PIndexName           string   `protobuf:"bytes,1,opt,name=PIndexName,proto3" json:"PIndexName,omitempty"`
+	Code                 uint32   `protobuf:"varint,2,opt,name=Code,proto3" json:"Code,omitempty"`
+	Error                string   `protobuf:"bytes,3,opt,name=Error,proto3" json:"Error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PIndexError) Reset()         { *m = PIndexError{} }
+func (m *PIndexError) String() string { return proto.CompactTextString(m) }
+func (*PIndexError) ProtoMessage()    {}
+func (*PIndexError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{21}
+}
+
+func (m *PIndexError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PIndexError.Unmarshal(m, b)
+}
+func (m *PIndexError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PIndexError.Marshal(b, m, deterministic)
+}
+func (m *PIndexError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PIndexError.Merge(m, src)
+}
+func (m *PIndexError) XXX_Size() int {
+	return xxx_messageInfo_PIndexError.Size(m)
+}
+func (m *PIndexError) XXX_DiscardUnknown() {
+	xxx_messageInfo_PIndexError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PIndexError proto.InternalMessageInfo
+
+func (m *PIndexError) GetPIndexName() string {
+	if m != nil {
+		return m.PIndexName
+	}
+	return ""
+}
+
+func (m *PIndexError) GetCode() uint32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+func (m *PIndexError) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+// A BatchSearchRequest carries several independent search requests,
+// such as of different indexes, for a node to run in a single call.
+type BatchSearchRequest struct {
+	Requests             []*SearchRequest `protobuf:"bytes,1,rep,name=Requests,proto3" json:"Requests,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *BatchSearchRequest) Reset()         { *m = BatchSearchRequest{} }
+func (m *BatchSearchRequest) String() string { return proto.CompactTextString(m) }
+func (*BatchSearchRequest) ProtoMessage()    {}
+func (*BatchSearchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{22}
+}
+
+func (m *BatchSearchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchSearchRequest.Unmarshal(m, b)
+}
+func (m *BatchSearchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchSearchRequest.Marshal(b, m, deterministic)
+}
+func (m *BatchSearchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchSearchRequest.Merge(m, src)
+}
+func (m *BatchSearchRequest) XXX_Size() int {
+	return xxx_messageInfo_BatchSearchRequest.Size(m)
+}
+func (m *BatchSearchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchSearchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchSearchRequest proto.InternalMessageInfo
+
+func (m *BatchSearchRequest) GetRequests() []*SearchRequest {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+// A BatchSearchResult is a message of the results of one of the
+// requests of a batch, tagged with the position of the request, where
+// the results of the requests are interleaved as they complete.  The
+// last message of a request has Done set, along with the Code and the
+// Error of the request, if it failed, as a failed request doesn't fail
+// the others.
+type BatchSearchResult struct {
+	RequestIndex         uint32               `protobuf:"varint,1,opt,name=RequestIndex,proto3" json:"RequestIndex,omitempty"`
+	Results              *StreamSearchResults `protobuf:"bytes,2,opt,name=Results,proto3" json:"Results,omitempty"`
+	Done                 bool                 `protobuf:"varint,3,opt,name=Done,proto3" json:"Done,omitempty"`
+	Code                 uint32               `protobuf:"varint,4,opt,name=Code,proto3" json:"Code,omitempty"`
+	Error                string               `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *BatchSearchResult) Reset()         { *m = BatchSearchResult{} }
+func (m *BatchSearchResult) String() string { return proto.CompactTextString(m) }
+func (*BatchSearchResult) ProtoMessage()    {}
+func (*BatchSearchResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{23}
+}
+
+func (m *BatchSearchResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchSearchResult.Unmarshal(m, b)
+}
+func (m *BatchSearchResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchSearchResult.Marshal(b, m, deterministic)
+}
+func (m *BatchSearchResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchSearchResult.Merge(m, src)
+}
+func (m *BatchSearchResult) XXX_Size() int {
+	return xxx_messageInfo_BatchSearchResult.Size(m)
+}
+func (m *BatchSearchResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchSearchResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchSearchResult proto.InternalMessageInfo
+
+func (m *BatchSearchResult) GetRequestIndex() uint32 {
+	if m != nil {
+		return m.RequestIndex
+	}
+	return 0
+}
+
+func (m *BatchSearchResult) GetResults() *StreamSearchResults {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+func (m *BatchSearchResult) GetDone() bool {
+	if m != nil {
+		return m.Done
+	}
+	return false
+}
+
+func (m *BatchSearchResult) GetCode() uint32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+func (m *BatchSearchResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+// A MappingRequest asks for the mapping of an index, as served by any
+// of the local PIndexNames of the index.
+type MappingRequest struct {
+	IndexName            string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID            string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	PIndexNames          []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MappingRequest) Reset()         { *m = MappingRequest{} }
+func (m *MappingRequest) String() string { return proto.CompactTextString(m) }
+func (*MappingRequest) ProtoMessage()    {}
+func (*MappingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{24}
+}
+
+func (m *MappingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MappingRequest.Unmarshal(m, b)
+}
+func (m *MappingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MappingRequest.Marshal(b, m, deterministic)
+}
+func (m *MappingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MappingRequest.Merge(m, src)
+}
+func (m *MappingRequest) XXX_Size() int {
+	return xxx_messageInfo_MappingRequest.Size(m)
+}
+func (m *MappingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MappingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MappingRequest proto.InternalMessageInfo
+
+func (m *MappingRequest) GetIndexName() string {
+	if m != nil {
+		return m.IndexName
+	}
+	return ""
+}
+
+func (m *MappingRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *MappingRequest) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
+	}
+	return nil
+}
+
+// A MappingResult carries the JSON serialized IndexMapping of an index.
+type MappingResult struct {
+	Mapping              []byte   `protobuf:"bytes,1,opt,name=Mapping,proto3" json:"Mapping,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MappingResult) Reset()         { *m = MappingResult{} }
+func (m *MappingResult) String() string { return proto.CompactTextString(m) }
+func (*MappingResult) ProtoMessage()    {}
+func (*MappingResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{25}
+}
+
+func (m *MappingResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MappingResult.Unmarshal(m, b)
+}
+func (m *MappingResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MappingResult.Marshal(b, m, deterministic)
+}
+func (m *MappingResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MappingResult.Merge(m, src)
+}
+func (m *MappingResult) XXX_Size() int {
+	return xxx_messageInfo_MappingResult.Size(m)
+}
+func (m *MappingResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_MappingResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MappingResult proto.InternalMessageInfo
+
+func (m *MappingResult) GetMapping() []byte {
+	if m != nil {
+		return m.Mapping
+	}
+	return nil
+}
+
+// A SearchFlowRequest is a message of the client of a flow controlled
+// search, where the first message carries the Request along with the
+// initial window of Credits, and each later message grants more
+// Credits, where a credit allows the server to send a message of hits.
+// A later message may also name the AbandonPIndexes, whose searches
+// the server then stops, leaving out their hits from the results.
+type SearchFlowRequest struct {
+	Request              *SearchRequest `protobuf:"bytes,1,opt,name=Request,proto3" json:"Request,omitempty"`
+	Credits              uint32         `protobuf:"varint,2,opt,name=Credits,proto3" json:"Credits,omitempty"`
+	AbandonPIndexes      []string       `protobuf:"bytes,3,rep,name=AbandonPIndexes,proto3" json:"AbandonPIndexes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *SearchFlowRequest) Reset()         { *m = SearchFlowRequest{} }
+func (m *SearchFlowRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchFlowRequest) ProtoMessage()    {}
+func (*SearchFlowRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{26}
+}
+
+func (m *SearchFlowRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchFlowRequest.Unmarshal(m, b)
+}
+func (m *SearchFlowRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchFlowRequest.Marshal(b, m, deterministic)
+}
+func (m *SearchFlowRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchFlowRequest.Merge(m, src)
+}
+func (m *SearchFlowRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchFlowRequest.Size(m)
+}
+func (m *SearchFlowRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchFlowRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchFlowRequest proto.InternalMessageInfo
+
+func (m *SearchFlowRequest) GetRequest() *SearchRequest {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+func (m *SearchFlowRequest) GetCredits() uint32 {
+	if m != nil {
+		return m.Credits
+	}
+	return 0
+}
+
+func (m *SearchFlowRequest) GetAbandonPIndexes() []string {
+	if m != nil {
+		return m.AbandonPIndexes
+	}
+	return nil
+}
+
+// A PingRequest is a cheap pre-flight check of a node, ahead of the
+// scatter-gather, where the IndexName and the IndexUUID, when given,
+// are checked against the node's definition of the index.
+type PingRequest struct {
+	IndexName            string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID            string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingRequest) Reset()         { *m = PingRequest{} }
+func (m *PingRequest) String() string { return proto.CompactTextString(m) }
+func (*PingRequest) ProtoMessage()    {}
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{27}
+}
+
+func (m *PingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingRequest.Unmarshal(m, b)
+}
+func (m *PingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingRequest.Marshal(b, m, deterministic)
+}
+func (m *PingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingRequest.Merge(m, src)
+}
+func (m *PingRequest) XXX_Size() int {
+	return xxx_messageInfo_PingRequest.Size(m)
+}
+func (m *PingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingRequest proto.InternalMessageInfo
+
+func (m *PingRequest) GetIndexName() string {
+	if m != nil {
+		return m.IndexName
+	}
+	return ""
 }
 
-type StreamSearchResults_Batch struct {
-	Bytes                []byte   `protobuf:"bytes,1,opt,name=Bytes,proto3" json:"Bytes,omitempty"`
-	Offsets              []uint64 `protobuf:"varint,2,rep,packed,name=Offsets,proto3" json:"Offsets,omitempty"`
-	Total                uint64   `protobuf:"varint,3,opt,name=Total,proto3" json:"Total,omitempty"`
+func (m *PingRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+// A PingResult is the status of a node, where IndexUUIDCurrent tells
+// whether the IndexUUID of the request is the node's current UUID of
+// the index, and where the ActiveQueries and the MemoryPressure, from
+// 0 to 100, approximate the load of the node.
+type PingResult struct {
+	Status               HealthCheckResponse_ServingStatus `protobuf:"varint,1,opt,name=Status,proto3,enum=search.HealthCheckResponse_ServingStatus" json:"Status,omitempty"`
+	IndexUUIDCurrent     bool                              `protobuf:"varint,2,opt,name=IndexUUIDCurrent,proto3" json:"IndexUUIDCurrent,omitempty"`
+	IndexUUID            string                            `protobuf:"bytes,3,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	ActiveQueries        uint64                            `protobuf:"varint,4,opt,name=ActiveQueries,proto3" json:"ActiveQueries,omitempty"`
+	MemoryPressure       uint32                            `protobuf:"varint,5,opt,name=MemoryPressure,proto3" json:"MemoryPressure,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                          `json:"-"`
+	XXX_unrecognized     []byte                            `json:"-"`
+	XXX_sizecache        int32                             `json:"-"`
+}
+
+func (m *PingResult) Reset()         { *m = PingResult{} }
+func (m *PingResult) String() string { return proto.CompactTextString(m) }
+func (*PingResult) ProtoMessage()    {}
+func (*PingResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{28}
+}
+
+func (m *PingResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingResult.Unmarshal(m, b)
+}
+func (m *PingResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingResult.Marshal(b, m, deterministic)
+}
+func (m *PingResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingResult.Merge(m, src)
+}
+func (m *PingResult) XXX_Size() int {
+	return xxx_messageInfo_PingResult.Size(m)
+}
+func (m *PingResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingResult proto.InternalMessageInfo
+
+func (m *PingResult) GetStatus() HealthCheckResponse_ServingStatus {
+	if m != nil {
+		return m.Status
+	}
+	return HealthCheckResponse_UNKNOWN
+}
+
+func (m *PingResult) GetIndexUUIDCurrent() bool {
+	if m != nil {
+		return m.IndexUUIDCurrent
+	}
+	return false
+}
+
+func (m *PingResult) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *PingResult) GetActiveQueries() uint64 {
+	if m != nil {
+		return m.ActiveQueries
+	}
+	return 0
+}
+
+func (m *PingResult) GetMemoryPressure() uint32 {
+	if m != nil {
+		return m.MemoryPressure
+	}
+	return 0
+}
+
+// An IndexStatsRequest asks for the stats of the local PIndexNames of
+// an index.
+type IndexStatsRequest struct {
+	IndexName            string   `protobuf:"bytes,1,opt,name=IndexName,proto3" json:"IndexName,omitempty"`
+	IndexUUID            string   `protobuf:"bytes,2,opt,name=IndexUUID,proto3" json:"IndexUUID,omitempty"`
+	PIndexNames          []string `protobuf:"bytes,3,rep,name=PIndexNames,proto3" json:"PIndexNames,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *StreamSearchResults_Batch) Reset()         { *m = StreamSearchResults_Batch{} }
-func (m *StreamSearchResults_Batch) String() string { return proto.CompactTextString(m) }
-func (*StreamSearchResults_Batch) ProtoMessage()    {}
-func (*StreamSearchResults_Batch) Descriptor() ([]byte, []int) {
-	return fileDescriptor_453745cff914010e, []int{11, 0}
+func (m *IndexStatsRequest) Reset()         { *m = IndexStatsRequest{} }
+func (m *IndexStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*IndexStatsRequest) ProtoMessage()    {}
+func (*IndexStatsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{29}
 }
 
-func (m *StreamSearchResults_Batch) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_StreamSearchResults_Batch.Unmarshal(m, b)
+func (m *IndexStatsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IndexStatsRequest.Unmarshal(m, b)
 }
-func (m *StreamSearchResults_Batch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_StreamSearchResults_Batch.Marshal(b, m, deterministic)
+func (m *IndexStatsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IndexStatsRequest.Marshal(b, m, deterministic)
 }
-func (m *StreamSearchResults_Batch) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_StreamSearchResults_Batch.Merge(m, src)
+func (m *IndexStatsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IndexStatsRequest.Merge(m, src)
 }
-func (m *StreamSearchResults_Batch) XXX_Size() int {
-	return xxx_messageInfo_StreamSearchResults_Batch.Size(m)
+func (m *IndexStatsRequest) XXX_Size() int {
+	return xxx_messageInfo_IndexStatsRequest.Size(m)
 }
-func (m *StreamSearchResults_Batch) XXX_DiscardUnknown() {
-	xxx_messageInfo_StreamSearchResults_Batch.DiscardUnknown(m)
+func (m *IndexStatsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IndexStatsRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_StreamSearchResults_Batch proto.InternalMessageInfo
+var xxx_messageInfo_IndexStatsRequest proto.InternalMessageInfo
+
+func (m *IndexStatsRequest) GetIndexName() string {
+	if m != nil {
+		return m.IndexName
+	}
+	return ""
+}
+
+func (m *IndexStatsRequest) GetIndexUUID() string {
+	if m != nil {
+		return m.IndexUUID
+	}
+	return ""
+}
+
+func (m *IndexStatsRequest) GetPIndexNames() []string {
+	if m != nil {
+		return m.PIndexNames
+	}
+	return nil
+}
+
+// An IndexStatsResult carries the stats of the pindexes of the request.
+type IndexStatsResult struct {
+	// Keyed by pindex name, the JSON encoded stats map of each pindex,
+	// along with its doc_count and its pindex_uuid.
+	PIndexStats map[string][]byte `protobuf:"bytes,1,rep,name=PIndexStats,proto3" json:"PIndexStats,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Keyed by pindex name, for the pindexes that failed.
+	Errors               map[string]string `protobuf:"bytes,2,rep,name=Errors,proto3" json:"Errors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *IndexStatsResult) Reset()         { *m = IndexStatsResult{} }
+func (m *IndexStatsResult) String() string { return proto.CompactTextString(m) }
+func (*IndexStatsResult) ProtoMessage()    {}
+func (*IndexStatsResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_453745cff914010e, []int{30}
+}
+
+func (m *IndexStatsResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IndexStatsResult.Unmarshal(m, b)
+}
+func (m *IndexStatsResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IndexStatsResult.Marshal(b, m, deterministic)
+}
+func (m *IndexStatsResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IndexStatsResult.Merge(m, src)
+}
+func (m *IndexStatsResult) XXX_Size() int {
+	return xxx_messageInfo_IndexStatsResult.Size(m)
+}
+func (m *IndexStatsResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_IndexStatsResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IndexStatsResult proto.InternalMessageInfo
+
+func (m *IndexStatsResult) GetPIndexStats() map[string][]byte {
+	if m != nil {
+		return m.PIndexStats
+	}
+	return nil
+}
+
+func (m *IndexStatsResult) GetErrors() map[string]string {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("search.HealthCheckResponse_ServingStatus", HealthCheckResponse_ServingStatus_name, HealthCheckResponse_ServingStatus_value)
+	proto.RegisterType((*HealthCheckRequest)(nil), "search.HealthCheckRequest")
+	proto.RegisterType((*HealthCheckResponse)(nil), "search.HealthCheckResponse")
+	proto.RegisterType((*DocCountRequest)(nil), "search.DocCountRequest")
+	proto.RegisterType((*DocCountResult)(nil), "search.DocCountResult")
+	proto.RegisterMapType((map[string]int64)(nil), "search.DocCountResult.PIndexDocCountsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "search.DocCountResult.ErrorsEntry")
+	proto.RegisterType((*FieldsRequest)(nil), "search.FieldsRequest")
+	proto.RegisterType((*FieldTypes)(nil), "search.FieldTypes")
+	proto.RegisterType((*FieldsWithTypesResult)(nil), "search.FieldsWithTypesResult")
+	proto.RegisterMapType((map[string]string)(nil), "search.FieldsWithTypesResult.FieldTypesEntry")
+	proto.RegisterMapType((map[string]*FieldTypes)(nil), "search.FieldsWithTypesResult.ConflictsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "search.FieldsWithTypesResult.ErrorsEntry")
+	proto.RegisterType((*FieldsResult)(nil), "search.FieldsResult")
+	proto.RegisterMapType((map[string]string)(nil), "search.FieldsResult.ErrorsEntry")
+	proto.RegisterType((*DumpRequest)(nil), "search.DumpRequest")
+	proto.RegisterType((*DumpResult)(nil), "search.DumpResult")
+	proto.RegisterType((*TermDictionaryRequest)(nil), "search.TermDictionaryRequest")
+	proto.RegisterType((*TermDictionaryEntry)(nil), "search.TermDictionaryEntry")
+	proto.RegisterType((*TermDictionaryResult)(nil), "search.TermDictionaryResult")
+	proto.RegisterType((*ConsistencyVectors)(nil), "search.ConsistencyVectors")
+	proto.RegisterMapType((map[string]uint64)(nil), "search.ConsistencyVectors.ConsistencyVectorEntry")
+	proto.RegisterType((*ConsistencyParams)(nil), "search.ConsistencyParams")
+	proto.RegisterMapType((map[string]*ConsistencyVectors)(nil), "search.ConsistencyParams.VectorsEntry")
+	proto.RegisterType((*QueryCtl)(nil), "search.QueryCtl")
+	proto.RegisterType((*QueryCtlParams)(nil), "search.QueryCtlParams")
+	proto.RegisterType((*QueryPIndexes)(nil), "search.QueryPIndexes")
+	proto.RegisterType((*SearchRequest)(nil), "search.SearchRequest")
+	proto.RegisterType((*SearchResult)(nil), "search.SearchResult")
+	proto.RegisterType((*StreamSearchResults)(nil), "search.StreamSearchResults")
+	proto.RegisterType((*StreamSearchResults_Batch)(nil), "search.StreamSearchResults.Batch")
+	proto.RegisterType((*PIndexError)(nil), "search.PIndexError")
+	proto.RegisterType((*BatchSearchRequest)(nil), "search.BatchSearchRequest")
+	proto.RegisterType((*BatchSearchResult)(nil), "search.BatchSearchResult")
+	proto.RegisterType((*MappingRequest)(nil), "search.MappingRequest")
+	proto.RegisterType((*MappingResult)(nil), "search.MappingResult")
+	proto.RegisterType((*SearchFlowRequest)(nil), "search.SearchFlowRequest")
+	proto.RegisterType((*PingRequest)(nil), "search.PingRequest")
+	proto.RegisterType((*PingResult)(nil), "search.PingResult")
+	proto.RegisterType((*IndexStatsRequest)(nil), "search.IndexStatsRequest")
+	proto.RegisterType((*IndexStatsResult)(nil), "search.IndexStatsResult")
+	proto.RegisterMapType((map[string][]byte)(nil), "search.IndexStatsResult.PIndexStatsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "search.IndexStatsResult.ErrorsEntry")
+}
+
+func init() { proto.RegisterFile("search.proto", fileDescriptor_453745cff914010e) }
+
+var fileDescriptor_453745cff914010e = []byte{
+	// 1626 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x58, 0x4f, 0x6f, 0xdb, 0xc6,
+	0x12, 0x0f, 0x25, 0x4a, 0x96, 0x87, 0x92, 0xff, 0xac, 0xff, 0x3c, 0x99, 0x49, 0x1e, 0xfc, 0x08,
+	0x23, 0x70, 0xf2, 0xde, 0x53, 0x6c, 0xb5, 0x41, 0xd2, 0xa4, 0x4d, 0x63, 0x4b, 0xfe, 0x57, 0xd7,
+	0xb6, 0xba, 0xb2, 0x9d, 0x63, 0xc0, 0x48, 0xeb, 0x98, 0x8d, 0x44, 0x3a, 0x24, 0xe5, 0x46, 0xdf,
+	0xa0, 0xd7, 0x02, 0x2d, 0x50, 0xa0, 0x97, 0x7e, 0x80, 0x7e, 0x83, 0x02, 0x05, 0x7a, 0xef, 0x57,
+	0xe8, 0xb9, 0xe7, 0x9e, 0x7a, 0x2d, 0xf6, 0x1f, 0xb5, 0xa4, 0x28, 0x19, 0x6d, 0x03, 0x9f, 0xb8,
+	0x33, 0x3b, 0x33, 0x3b, 0xf3, 0xdb, 0x99, 0xd9, 0x5d, 0x42, 0x31, 0x20, 0xb6, 0xdf, 0x3a, 0xaf,
+	0x5c, 0xf8, 0x5e, 0xe8, 0xa1, 0x3c, 0xa7, 0xac, 0x0a, 0xa0, 0x5d, 0x62, 0x77, 0xc2, 0xf3, 0xda,
+	0x39, 0x69, 0xbd, 0xc6, 0xe4, 0x4d, 0x8f, 0x04, 0x21, 0x2a, 0xc3, 0x44, 0x40, 0xfc, 0x4b, 0xa7,
+	0x45, 0xca, 0xda, 0xb2, 0xb6, 0x3a, 0x89, 0x25, 0x69, 0x7d, 0xad, 0xc1, 0x5c, 0x4c, 0x21, 0xb8,
+	0xf0, 0xdc, 0x80, 0xa0, 0x0d, 0xc8, 0x07, 0xa1, 0x1d, 0xf6, 0x02, 0xa6, 0x30, 0x55, 0xbd, 0x5b,
+	0x11, 0xcb, 0xa5, 0x08, 0x57, 0x9a, 0xd4, 0x98, 0xfb, 0xaa, 0xc9, 0x14, 0xb0, 0x50, 0xb4, 0x1e,
+	0x43, 0x29, 0x36, 0x81, 0x0c, 0x98, 0x38, 0x39, 0xdc, 0x3f, 0x3c, 0x7a, 0x7e, 0x38, 0x73, 0x83,
+	0x12, 0xcd, 0x2d, 0x7c, 0xba, 0x77, 0xb8, 0x33, 0xa3, 0xa1, 0x69, 0x30, 0x0e, 0x8f, 0x8e, 0x5f,
+	0x48, 0x46, 0xc6, 0xf2, 0x60, 0xba, 0xee, 0xb5, 0x6a, 0x5e, 0xcf, 0x0d, 0x65, 0x0c, 0xb7, 0x60,
+	0x72, 0xcf, 0x6d, 0x93, 0xb7, 0x87, 0x76, 0x57, 0x46, 0x31, 0x60, 0x44, 0xb3, 0x27, 0x27, 0x7b,
+	0xf5, 0x72, 0x46, 0x99, 0xa5, 0x0c, 0xb4, 0x0c, 0x46, 0x23, 0x92, 0x0d, 0xca, 0xd9, 0xe5, 0xec,
+	0xea, 0x24, 0x56, 0x59, 0xd6, 0x4f, 0x19, 0x98, 0x1a, 0xac, 0x18, 0xf4, 0x3a, 0x21, 0x32, 0xa1,
+	0x20, 0x39, 0x6c, 0xbd, 0x2c, 0x8e, 0x68, 0x74, 0x02, 0xd3, 0x5c, 0x5b, 0x72, 0x82, 0x72, 0x66,
+	0x39, 0xbb, 0x6a, 0x54, 0xff, 0x2b, 0x71, 0x8a, 0x1b, 0xab, 0x24, 0xa4, 0xb7, 0xdc, 0xd0, 0xef,
+	0xe3, 0xa4, 0x0d, 0xf4, 0x18, 0xf2, 0x5b, 0xbe, 0xef, 0xf9, 0xdc, 0x45, 0xa3, 0x6a, 0x8d, 0xb0,
+	0xc6, 0x85, 0xb8, 0x11, 0xa1, 0x61, 0x6e, 0xc2, 0x7c, 0xda, 0x22, 0x68, 0x06, 0xb2, 0xaf, 0x49,
+	0x5f, 0x20, 0x46, 0x87, 0x68, 0x1e, 0x72, 0x97, 0x76, 0xa7, 0x47, 0x18, 0x4e, 0x59, 0xcc, 0x89,
+	0xc7, 0x99, 0x47, 0x9a, 0xf9, 0x01, 0x18, 0x8a, 0xe9, 0xab, 0x54, 0x27, 0x15, 0x55, 0xab, 0x0b,
+	0xa5, 0x6d, 0x87, 0x74, 0xda, 0xc1, 0xf5, 0xec, 0x97, 0x05, 0xc0, 0x96, 0x3b, 0xee, 0x5f, 0x90,
+	0x80, 0xba, 0xc5, 0x06, 0x65, 0x8d, 0x49, 0x72, 0xc2, 0xfa, 0x39, 0x0b, 0x0b, 0xdc, 0xa7, 0xe7,
+	0x4e, 0x78, 0xce, 0x78, 0x62, 0x6b, 0x0f, 0x54, 0x6d, 0xa6, 0x64, 0x54, 0xff, 0x2f, 0xb1, 0x4e,
+	0x55, 0xa9, 0x0c, 0xe4, 0x39, 0xec, 0xea, 0xf2, 0x9f, 0xc0, 0x64, 0xcd, 0x73, 0xcf, 0x3a, 0x4e,
+	0x2b, 0xca, 0x83, 0xff, 0x8d, 0xb7, 0x16, 0x89, 0x73, 0x63, 0x03, 0x75, 0x5a, 0x78, 0xb1, 0x14,
+	0xb8, 0x3b, 0xde, 0x50, 0x5a, 0x26, 0x7c, 0x04, 0xd3, 0x09, 0x6f, 0xff, 0xca, 0x4e, 0x9a, 0x0d,
+	0x98, 0x8a, 0xbb, 0x97, 0xa2, 0xbd, 0xaa, 0x6a, 0x1b, 0x55, 0x14, 0x73, 0x92, 0xfb, 0xf7, 0x6e,
+	0xd2, 0xea, 0x3b, 0x0d, 0x8a, 0x32, 0xaf, 0xd8, 0xd6, 0x2d, 0x42, 0x9e, 0xd3, 0x62, 0xaf, 0x05,
+	0x85, 0x1e, 0x45, 0xb8, 0xf1, 0x0d, 0x58, 0x8e, 0xe3, 0x36, 0x06, 0xae, 0x7f, 0xe0, 0xdd, 0x37,
+	0x1a, 0x18, 0xf5, 0x5e, 0xf7, 0xe2, 0x5a, 0x72, 0x1e, 0x21, 0xd0, 0xf7, 0x1d, 0xb7, 0x5d, 0xd6,
+	0x99, 0x2a, 0x1b, 0x53, 0xdf, 0xea, 0x5e, 0x6b, 0xaf, 0x5e, 0xce, 0x71, 0xdf, 0x18, 0x61, 0x7d,
+	0x0e, 0xc0, 0xdd, 0x62, 0x90, 0xfd, 0x1b, 0xa0, 0x91, 0x74, 0x4b, 0xe1, 0xd0, 0x88, 0xf7, 0x49,
+	0x9f, 0x79, 0x54, 0xc4, 0x74, 0x48, 0xad, 0x9e, 0xb2, 0x88, 0xb3, 0x8c, 0xc7, 0x09, 0xca, 0x65,
+	0x40, 0x09, 0x07, 0x38, 0x61, 0xfd, 0xa1, 0xc1, 0xc2, 0x31, 0xf1, 0xbb, 0x75, 0xa7, 0x15, 0x3a,
+	0x9e, 0x6b, 0xfb, 0xfd, 0xeb, 0x41, 0x63, 0x1e, 0x72, 0x6c, 0x6b, 0xa5, 0x37, 0x8c, 0x88, 0x30,
+	0xca, 0x29, 0x18, 0xdd, 0x82, 0xc9, 0x66, 0x68, 0xfb, 0x21, 0xf5, 0xb2, 0x9c, 0x67, 0x11, 0x0d,
+	0x18, 0xf4, 0x6c, 0xdc, 0x72, 0xdb, 0x6c, 0x6e, 0x82, 0xcd, 0x49, 0x92, 0xe2, 0x46, 0xbf, 0x0d,
+	0x9f, 0x9c, 0x39, 0x6f, 0xcb, 0x05, 0x36, 0xa9, 0x70, 0xac, 0x8f, 0x61, 0x2e, 0x1e, 0x38, 0x4f,
+	0x20, 0x04, 0x3a, 0xb3, 0xc6, 0x23, 0x66, 0x63, 0xea, 0x2c, 0x3f, 0x48, 0x68, 0xa0, 0x3a, 0xe6,
+	0x84, 0x75, 0x00, 0xf3, 0x49, 0xe4, 0xd8, 0x86, 0x3d, 0xa0, 0x2e, 0x85, 0xbe, 0x13, 0xf5, 0xa6,
+	0x9b, 0x32, 0x99, 0x53, 0xd6, 0xc3, 0x52, 0xd6, 0xfa, 0x51, 0x03, 0x54, 0xf3, 0xdc, 0xc0, 0x09,
+	0x42, 0xe2, 0xb6, 0xfa, 0xa7, 0xa4, 0x15, 0x7a, 0x7e, 0x80, 0x5e, 0xc0, 0xec, 0x10, 0x57, 0xd8,
+	0x5d, 0x97, 0x76, 0x87, 0xd5, 0x86, 0x59, 0x7c, 0xb5, 0x61, 0x5b, 0x66, 0x1d, 0x16, 0xd3, 0x85,
+	0xaf, 0xaa, 0x25, 0x5d, 0xad, 0xa5, 0x5f, 0xb5, 0x98, 0x9f, 0x0d, 0xdb, 0xb7, 0xbb, 0x6c, 0x97,
+	0x3f, 0x25, 0x97, 0xa4, 0x23, 0x6c, 0x70, 0x02, 0x3d, 0x83, 0x09, 0xe1, 0xa6, 0xa8, 0xf6, 0x3b,
+	0x29, 0x81, 0x70, 0x0b, 0x15, 0x21, 0x28, 0xb0, 0x12, 0x14, 0xdd, 0x75, 0x0e, 0x76, 0xc0, 0x72,
+	0x7c, 0x12, 0x4b, 0xd2, 0x3c, 0x85, 0xa2, 0xaa, 0x92, 0x12, 0xc3, 0x5a, 0xbc, 0xf9, 0x99, 0xa3,
+	0x41, 0x54, 0xe3, 0xfb, 0x4a, 0x83, 0xc2, 0x67, 0x3d, 0xe2, 0xf7, 0x6b, 0x61, 0x87, 0x2e, 0x7f,
+	0xec, 0x74, 0x89, 0xd7, 0x93, 0x57, 0x0b, 0x49, 0xa2, 0x27, 0x60, 0x28, 0x76, 0xc4, 0x12, 0x4b,
+	0x23, 0xc3, 0xc3, 0xaa, 0x34, 0xaa, 0x00, 0x6a, 0xd8, 0x7e, 0xe8, 0xd0, 0xfc, 0x68, 0x92, 0x0e,
+	0x61, 0x89, 0x22, 0x02, 0x4c, 0x99, 0xb1, 0xde, 0x87, 0x29, 0xe9, 0x92, 0xc0, 0xdb, 0x82, 0x6c,
+	0x2d, 0xe4, 0x68, 0x1b, 0xd5, 0x19, 0xb9, 0xac, 0x14, 0xc2, 0x74, 0xd2, 0x5a, 0x87, 0x12, 0x63,
+	0xf0, 0x6a, 0x24, 0x41, 0xb2, 0x58, 0xb5, 0xe1, 0xe3, 0xfa, 0x77, 0x8d, 0x5e, 0x06, 0xa9, 0x2d,
+	0xd9, 0x1c, 0x4c, 0x28, 0xd4, 0x3c, 0x37, 0x24, 0xf4, 0xea, 0xa4, 0xb1, 0xd2, 0x8a, 0xe8, 0x78,
+	0xe3, 0xc8, 0x8c, 0x6d, 0x1c, 0xd9, 0x64, 0xe3, 0x58, 0x84, 0x7c, 0x33, 0xf4, 0x89, 0xdd, 0x65,
+	0x7d, 0xa1, 0x80, 0x05, 0x85, 0xee, 0x24, 0x43, 0x65, 0x2d, 0xa2, 0x88, 0x93, 0x00, 0xac, 0x24,
+	0x82, 0x13, 0x0d, 0x23, 0x11, 0xb1, 0x05, 0x45, 0x6e, 0x77, 0xdb, 0x6e, 0x91, 0x30, 0x60, 0x9d,
+	0xa3, 0x80, 0x63, 0x3c, 0xeb, 0x1e, 0x14, 0x65, 0xc8, 0xf2, 0x3e, 0x39, 0x2a, 0x62, 0xeb, 0x97,
+	0x0c, 0xcc, 0x71, 0x65, 0x55, 0x25, 0x40, 0x0f, 0x41, 0xdf, 0x75, 0x84, 0xbc, 0x51, 0xfd, 0x8f,
+	0xdc, 0x8f, 0x14, 0xd1, 0xca, 0xa6, 0x1d, 0xb6, 0xce, 0x77, 0x6f, 0x60, 0xa6, 0x80, 0x56, 0xe2,
+	0x8b, 0xf3, 0xe6, 0xbe, 0x7b, 0x03, 0xc7, 0x5d, 0x2a, 0x43, 0x5e, 0x04, 0x90, 0x13, 0xf3, 0x82,
+	0x46, 0xab, 0x30, 0x2d, 0x9c, 0xdb, 0x72, 0x5b, 0x5e, 0xdb, 0x71, 0x5f, 0x09, 0xa8, 0x93, 0x6c,
+	0xf4, 0x10, 0x8a, 0x1c, 0x16, 0x71, 0xfc, 0xea, 0xac, 0x20, 0xe7, 0xa4, 0xab, 0xca, 0x1c, 0x8e,
+	0x09, 0x9a, 0x07, 0x90, 0x63, 0x3e, 0xd3, 0x1a, 0xdf, 0xec, 0x87, 0x44, 0xa2, 0xc2, 0x09, 0x5a,
+	0x22, 0x47, 0x67, 0x67, 0x01, 0x11, 0x57, 0x2a, 0x1d, 0x4b, 0x92, 0xdd, 0xf6, 0xbc, 0xd0, 0xee,
+	0x30, 0x8f, 0x74, 0xcc, 0x89, 0x4d, 0x18, 0xc0, 0x6b, 0x3d, 0x07, 0x43, 0x59, 0xea, 0xca, 0x03,
+	0x10, 0x81, 0x5e, 0xf3, 0xda, 0x3c, 0xd5, 0x4a, 0x98, 0x8d, 0x07, 0x87, 0x5d, 0x56, 0x3d, 0xec,
+	0x76, 0x00, 0x31, 0x9f, 0xe3, 0xb9, 0xbc, 0x0e, 0x05, 0x31, 0x94, 0x0d, 0x7b, 0x21, 0xda, 0x29,
+	0x55, 0x10, 0x47, 0x62, 0xd6, 0x0f, 0x1a, 0xcc, 0xc6, 0x2c, 0xb1, 0xfd, 0xb0, 0xa0, 0x28, 0x24,
+	0x98, 0x73, 0xcc, 0xd5, 0x12, 0x8e, 0xf1, 0xe8, 0xe1, 0x20, 0x3b, 0x17, 0x6f, 0x0e, 0x37, 0xc7,
+	0x64, 0x45, 0xd4, 0xd6, 0x68, 0x8c, 0x75, 0xcf, 0xe5, 0x27, 0x7a, 0x01, 0xb3, 0x71, 0x14, 0xb7,
+	0x9e, 0x16, 0x77, 0x4e, 0x8d, 0xdb, 0x85, 0xa9, 0x03, 0xfb, 0xe2, 0xc2, 0x71, 0x5f, 0x5d, 0xcf,
+	0xf5, 0xfe, 0x2e, 0x94, 0xa2, 0xf5, 0x44, 0xa6, 0x4e, 0x08, 0x86, 0xc8, 0x12, 0x49, 0x5a, 0x5f,
+	0x6a, 0x30, 0xcb, 0x63, 0xde, 0xee, 0x78, 0x5f, 0x48, 0xf7, 0xee, 0xc3, 0x84, 0x18, 0x8a, 0xda,
+	0x19, 0xb1, 0x23, 0x13, 0xca, 0x13, 0xb9, 0xe6, 0x93, 0xb6, 0x23, 0x60, 0x2d, 0x61, 0x49, 0xd2,
+	0x52, 0xd8, 0x78, 0x69, 0xbb, 0x6d, 0xcf, 0x8d, 0x7a, 0x02, 0xf7, 0x38, 0xc9, 0xb6, 0xf6, 0xc0,
+	0x68, 0xbc, 0x1b, 0x88, 0xac, 0xdf, 0x34, 0x80, 0xc6, 0x20, 0xfc, 0x0d, 0xc8, 0xf3, 0x47, 0xf4,
+	0xdf, 0x78, 0x8e, 0xf3, 0x2f, 0xba, 0x07, 0x33, 0x91, 0xf9, 0x5a, 0xcf, 0xf7, 0x89, 0xb8, 0x8d,
+	0x14, 0xf0, 0x10, 0xff, 0x8a, 0x16, 0xbb, 0x02, 0xa5, 0x8d, 0x56, 0xe8, 0x5c, 0x12, 0xda, 0x13,
+	0xe9, 0x25, 0x45, 0x67, 0x75, 0x18, 0x67, 0xd2, 0x86, 0x7b, 0x40, 0xba, 0x9e, 0xdf, 0x6f, 0xf8,
+	0x24, 0x08, 0x7a, 0x3e, 0x61, 0x19, 0x55, 0xc2, 0x09, 0xae, 0xf5, 0x06, 0x66, 0x99, 0x69, 0xea,
+	0xe6, 0x35, 0x3d, 0x1e, 0xbf, 0xcd, 0xc0, 0x8c, 0xba, 0x26, 0x83, 0x78, 0x5f, 0xaa, 0x31, 0xa6,
+	0xa8, 0xe3, 0x08, 0xe7, 0xa4, 0x78, 0x45, 0x91, 0xe5, 0x57, 0x0b, 0x55, 0x1b, 0x7d, 0x98, 0x78,
+	0x8d, 0xac, 0x8c, 0xb4, 0x93, 0xf6, 0x22, 0x79, 0x0a, 0x33, 0x49, 0xf3, 0x57, 0x5d, 0xa5, 0x8a,
+	0xef, 0xe6, 0xbd, 0x55, 0xfd, 0x3e, 0x2f, 0x0f, 0xea, 0x26, 0xff, 0x43, 0x84, 0x9e, 0x42, 0x9e,
+	0x33, 0x50, 0x7a, 0x09, 0x99, 0xe3, 0xfa, 0xcf, 0x9a, 0x86, 0x9e, 0x41, 0x8e, 0xa5, 0x27, 0x32,
+	0x53, 0x73, 0x36, 0x61, 0x23, 0xed, 0x5f, 0xd4, 0x93, 0xc1, 0x8f, 0x18, 0xf4, 0xaf, 0xe1, 0x3f,
+	0x22, 0xdc, 0xc2, 0x62, 0xfa, 0xaf, 0x12, 0xb4, 0x03, 0xd3, 0x89, 0x97, 0xf3, 0x20, 0x8e, 0xd8,
+	0x0f, 0x0b, 0xf3, 0xf6, 0xd8, 0x97, 0x36, 0x7a, 0x20, 0x1f, 0x9e, 0xa3, 0xf4, 0xe7, 0xd3, 0x5e,
+	0x9c, 0x68, 0x1d, 0x74, 0xfa, 0x14, 0x43, 0xd1, 0x81, 0xa8, 0xbc, 0x17, 0x4d, 0x14, 0x67, 0x52,
+	0x85, 0x35, 0x0d, 0x1d, 0xc1, 0x54, 0xfc, 0x9e, 0x8f, 0x6e, 0xa7, 0xdf, 0xff, 0xa5, 0x99, 0x5b,
+	0xa3, 0xa6, 0x85, 0xc1, 0x6d, 0x30, 0x94, 0xb3, 0x66, 0xb0, 0x11, 0xc3, 0x47, 0x99, 0xb9, 0x94,
+	0x3a, 0x27, 0xec, 0x3c, 0x01, 0xd8, 0x21, 0xa1, 0x68, 0xbc, 0x28, 0x42, 0x3c, 0x7e, 0x32, 0x98,
+	0x0b, 0x43, 0x7c, 0x06, 0x44, 0x13, 0x16, 0xb8, 0x39, 0x0a, 0x2c, 0x6d, 0xd5, 0xf4, 0xb4, 0xf6,
+	0xbd, 0x0e, 0x5a, 0x8a, 0xa7, 0x95, 0xd2, 0xc5, 0xc7, 0xa6, 0xd6, 0xaa, 0xb6, 0xa6, 0xa1, 0xfb,
+	0xa0, 0xd3, 0x2e, 0x39, 0x40, 0x57, 0xe9, 0xbf, 0x26, 0x8a, 0x33, 0x45, 0x23, 0x05, 0xa5, 0x4c,
+	0x97, 0xd2, 0xca, 0x92, 0x2b, 0x97, 0x47, 0x55, 0xec, 0xcb, 0x3c, 0xfb, 0xe3, 0xfa, 0xde, 0x9f,
+	0x03, 0x00, 0x7b, 0xd3, 0x9f, 0xac, 0x81, 0x15, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// SearchServiceClient is the client API for SearchService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SearchServiceClient interface {
+	// external rpcs, for rpc clients
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (SearchService_SearchClient, error)
+	Check(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
+	DocCount(ctx context.Context, in *DocCountRequest, opts ...grpc.CallOption) (*DocCountResult, error)
+	FieldsWithTypes(ctx context.Context, in *FieldsRequest, opts ...grpc.CallOption) (*FieldsWithTypesResult, error)
+	Fields(ctx context.Context, in *FieldsRequest, opts ...grpc.CallOption) (*FieldsResult, error)
+	Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (SearchService_DumpClient, error)
+	TermDictionary(ctx context.Context, in *TermDictionaryRequest, opts ...grpc.CallOption) (SearchService_TermDictionaryClient, error)
+	BatchSearch(ctx context.Context, in *BatchSearchRequest, opts ...grpc.CallOption) (SearchService_BatchSearchClient, error)
+	GetMapping(ctx context.Context, in *MappingRequest, opts ...grpc.CallOption) (*MappingResult, error)
+	SearchWithFlowControl(ctx context.Context, opts ...grpc.CallOption) (SearchService_SearchWithFlowControlClient, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResult, error)
+	IndexStats(ctx context.Context, in *IndexStatsRequest, opts ...grpc.CallOption) (*IndexStatsResult, error)
+}
+
+type searchServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSearchServiceClient(cc *grpc.ClientConn) SearchServiceClient {
+	return &searchServiceClient{cc}
+}
+
+func (c *searchServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (SearchService_SearchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[0], "/search.SearchService/Search", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &searchServiceSearchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SearchService_SearchClient interface {
+	Recv() (*StreamSearchResults, error)
+	grpc.ClientStream
+}
+
+type searchServiceSearchClient struct {
+	grpc.ClientStream
+}
+
+func (x *searchServiceSearchClient) Recv() (*StreamSearchResults, error) {
+	m := new(StreamSearchResults)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *searchServiceClient) Check(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error) {
+	out := new(HealthCheckResponse)
+	err := c.cc.Invoke(ctx, "/search.SearchService/Check", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchServiceClient) DocCount(ctx context.Context, in *DocCountRequest, opts ...grpc.CallOption) (*DocCountResult, error) {
+	out := new(DocCountResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/DocCount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchServiceClient) FieldsWithTypes(ctx context.Context, in *FieldsRequest, opts ...grpc.CallOption) (*FieldsWithTypesResult, error) {
+	out := new(FieldsWithTypesResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/FieldsWithTypes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchServiceClient) Fields(ctx context.Context, in *FieldsRequest, opts ...grpc.CallOption) (*FieldsResult, error) {
+	out := new(FieldsResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/Fields", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchServiceClient) Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (SearchService_DumpClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[1], "/search.SearchService/Dump", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &searchServiceDumpClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SearchService_DumpClient interface {
+	Recv() (*DumpResult, error)
+	grpc.ClientStream
+}
 
-func (m *StreamSearchResults_Batch) GetBytes() []byte {
-	if m != nil {
-		return m.Bytes
-	}
-	return nil
+type searchServiceDumpClient struct {
+	grpc.ClientStream
 }
 
-func (m *StreamSearchResults_Batch) GetOffsets() []uint64 {
-	if m != nil {
-		return m.Offsets
+func (x *searchServiceDumpClient) Recv() (*DumpResult, error) {
+	m := new(DumpResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
 	}
-	return nil
+	return m, nil
 }
 
-func (m *StreamSearchResults_Batch) GetTotal() uint64 {
-	if m != nil {
-		return m.Total
+func (c *searchServiceClient) TermDictionary(ctx context.Context, in *TermDictionaryRequest, opts ...grpc.CallOption) (SearchService_TermDictionaryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[2], "/search.SearchService/TermDictionary", opts...)
+	if err != nil {
+		return nil, err
 	}
-	return 0
+	x := &searchServiceTermDictionaryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-func init() {
-	proto.RegisterEnum("search.HealthCheckResponse_ServingStatus", HealthCheckResponse_ServingStatus_name, HealthCheckResponse_ServingStatus_value)
-	proto.RegisterType((*HealthCheckRequest)(nil), "search.HealthCheckRequest")
-	proto.RegisterType((*HealthCheckResponse)(nil), "search.HealthCheckResponse")
-	proto.RegisterType((*DocCountRequest)(nil), "search.DocCountRequest")
-	proto.RegisterType((*DocCountResult)(nil), "search.DocCountResult")
-	proto.RegisterType((*ConsistencyVectors)(nil), "search.ConsistencyVectors")
-	proto.RegisterMapType((map[string]uint64)(nil), "search.ConsistencyVectors.ConsistencyVectorEntry")
-	proto.RegisterType((*ConsistencyParams)(nil), "search.ConsistencyParams")
-	proto.RegisterMapType((map[string]*ConsistencyVectors)(nil), "search.ConsistencyParams.VectorsEntry")
-	proto.RegisterType((*QueryCtl)(nil), "search.QueryCtl")
-	proto.RegisterType((*QueryCtlParams)(nil), "search.QueryCtlParams")
-	proto.RegisterType((*QueryPIndexes)(nil), "search.QueryPIndexes")
-	proto.RegisterType((*SearchRequest)(nil), "search.SearchRequest")
-	proto.RegisterType((*SearchResult)(nil), "search.SearchResult")
-	proto.RegisterType((*StreamSearchResults)(nil), "search.StreamSearchResults")
-	proto.RegisterType((*StreamSearchResults_Batch)(nil), "search.StreamSearchResults.Batch")
+type SearchService_TermDictionaryClient interface {
+	Recv() (*TermDictionaryResult, error)
+	grpc.ClientStream
 }
 
-func init() { proto.RegisterFile("search.proto", fileDescriptor_453745cff914010e) }
-
-var fileDescriptor_453745cff914010e = []byte{
-	// 703 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x55, 0xed, 0x4e, 0x13, 0x4d,
-	0x14, 0x66, 0xfb, 0x45, 0x39, 0x5b, 0xa0, 0xef, 0xf0, 0x8a, 0xeb, 0xea, 0x8f, 0x3a, 0x21, 0x04,
-	0x8d, 0xd9, 0x40, 0x35, 0xd1, 0x40, 0x62, 0x90, 0x42, 0x2c, 0x51, 0x4a, 0x9d, 0x02, 0xfe, 0x24,
-	0xeb, 0x3a, 0xd8, 0x86, 0xed, 0x2e, 0xee, 0xcc, 0x12, 0x7b, 0x19, 0x26, 0xde, 0x8d, 0xd7, 0xa0,
-	0x5e, 0x81, 0xf7, 0x62, 0x66, 0x66, 0xa7, 0xdd, 0x6d, 0xb7, 0xfc, 0xdb, 0xe7, 0x7c, 0x3f, 0x67,
-	0xce, 0x39, 0x0b, 0x35, 0x46, 0xdd, 0xc8, 0xeb, 0x3b, 0x37, 0x51, 0xc8, 0x43, 0x54, 0x51, 0x08,
-	0x3b, 0x80, 0xda, 0xd4, 0xf5, 0x79, 0xbf, 0xd5, 0xa7, 0xde, 0x35, 0xa1, 0x5f, 0x63, 0xca, 0x38,
-	0xb2, 0x60, 0x91, 0xd1, 0xe8, 0x76, 0xe0, 0x51, 0xcb, 0x68, 0x18, 0x5b, 0x4b, 0x44, 0x43, 0xfc,
-	0xc3, 0x80, 0xb5, 0x8c, 0x03, 0xbb, 0x09, 0x03, 0x46, 0xd1, 0x1b, 0xa8, 0x30, 0xee, 0xf2, 0x98,
-	0x49, 0x87, 0x95, 0xe6, 0x13, 0x27, 0x49, 0x97, 0x63, 0xec, 0xf4, 0x44, 0xb0, 0xe0, 0x4b, 0x4f,
-	0x3a, 0x90, 0xc4, 0x11, 0xef, 0xc2, 0x72, 0x46, 0x81, 0x4c, 0x58, 0x3c, 0xef, 0xbc, 0xeb, 0x9c,
-	0x7e, 0xec, 0xd4, 0x17, 0x04, 0xe8, 0x1d, 0x91, 0x8b, 0xe3, 0xce, 0xdb, 0xba, 0x81, 0x56, 0xc1,
-	0xec, 0x9c, 0x9e, 0x5d, 0x6a, 0x41, 0x01, 0x9f, 0xc0, 0xea, 0x61, 0xe8, 0xb5, 0xc2, 0x38, 0xe0,
-	0x9a, 0xc3, 0x23, 0x58, 0x3a, 0x0e, 0x3e, 0xd3, 0x6f, 0x1d, 0x77, 0xa8, 0x59, 0x4c, 0x04, 0x63,
-	0xed, 0xf9, 0xf9, 0xf1, 0xa1, 0x55, 0x48, 0x69, 0x85, 0x00, 0x3f, 0x83, 0x95, 0x49, 0x38, 0x16,
-	0xfb, 0x1c, 0xd9, 0x50, 0xd5, 0x12, 0x19, 0xac, 0x48, 0xc6, 0x18, 0xff, 0x34, 0x00, 0xb5, 0xc2,
-	0x80, 0x0d, 0x18, 0xa7, 0x81, 0x37, 0xba, 0xa0, 0x1e, 0x0f, 0x23, 0x86, 0x2e, 0xe1, 0xbf, 0x19,
-	0xa9, 0x65, 0x34, 0x8a, 0x5b, 0x66, 0x73, 0x47, 0x77, 0x67, 0xd6, 0x6d, 0x56, 0x74, 0x14, 0xf0,
-	0x68, 0x44, 0x66, 0x63, 0xd9, 0x87, 0xb0, 0x9e, 0x6f, 0x8c, 0xea, 0x50, 0xbc, 0xa6, 0xa3, 0x84,
-	0xb5, 0xf8, 0x44, 0xff, 0x43, 0xf9, 0xd6, 0xf5, 0x63, 0x2a, 0xb9, 0x96, 0x88, 0x02, 0xbb, 0x85,
-	0x57, 0x06, 0xfe, 0x6b, 0x64, 0xea, 0xec, 0xba, 0x91, 0x3b, 0x64, 0xc2, 0xfe, 0x3d, 0xbd, 0xa5,
-	0x7e, 0x12, 0x43, 0x01, 0xb4, 0x0f, 0x8b, 0x49, 0x99, 0x56, 0x41, 0x12, 0xd9, 0xcc, 0x21, 0xa2,
-	0x22, 0x38, 0x89, 0xa1, 0xaa, 0x5e, 0xbb, 0x89, 0xc9, 0x52, 0x1d, 0x65, 0x56, 0x51, 0x4d, 0x56,
-	0x02, 0xed, 0x0b, 0xa8, 0xa5, 0x5d, 0x72, 0x38, 0x6c, 0xa7, 0x39, 0x98, 0x4d, 0x7b, 0x7e, 0x13,
-	0xd3, 0xfc, 0xbe, 0x1b, 0x50, 0xfd, 0x10, 0xd3, 0x68, 0xd4, 0xe2, 0xbe, 0x48, 0x7f, 0x36, 0x18,
-	0xd2, 0x30, 0xd6, 0xaf, 0xa8, 0x21, 0xda, 0x03, 0x33, 0x15, 0x27, 0x49, 0xf1, 0x60, 0x2e, 0x3d,
-	0x92, 0xb6, 0x46, 0x0e, 0xa0, 0xae, 0x1b, 0xf1, 0x01, 0x1f, 0x84, 0x41, 0x8f, 0xfa, 0xd4, 0x13,
-	0x1f, 0x09, 0xc1, 0x1c, 0x0d, 0x7e, 0x01, 0x2b, 0xba, 0xa4, 0xa4, 0xdf, 0x18, 0x8a, 0x2d, 0xae,
-	0xba, 0x6d, 0x36, 0xeb, 0x3a, 0xad, 0x36, 0x22, 0x42, 0x89, 0x77, 0x60, 0x59, 0x0a, 0xba, 0x72,
-	0x50, 0x29, 0x43, 0x0d, 0x30, 0xbb, 0xe3, 0x91, 0x66, 0x72, 0xb6, 0x96, 0x48, 0x5a, 0x84, 0x7f,
-	0x19, 0x62, 0xa9, 0x44, 0x2c, 0xbd, 0x16, 0x36, 0x54, 0x5b, 0x61, 0xc0, 0x69, 0xc0, 0xd5, 0xaa,
-	0xd6, 0xc8, 0x18, 0x67, 0x57, 0xa6, 0x70, 0xe7, 0xca, 0x14, 0xa7, 0x56, 0x06, 0xad, 0x43, 0xa5,
-	0xc7, 0x23, 0xea, 0x0e, 0xad, 0x52, 0xc3, 0xd8, 0xaa, 0x92, 0x04, 0xa1, 0xcd, 0x69, 0xaa, 0x56,
-	0x59, 0x66, 0x9d, 0x6e, 0xc0, 0xc6, 0x14, 0x39, 0xab, 0x22, 0xcd, 0xb2, 0x42, 0xfc, 0x14, 0x6a,
-	0x9a, 0x8e, 0x5e, 0xcb, 0x79, 0x6c, 0xf0, 0x1f, 0x03, 0xd6, 0x54, 0x11, 0x69, 0x17, 0x86, 0x5e,
-	0x42, 0xa9, 0x3d, 0x48, 0xec, 0xcd, 0xe6, 0x63, 0xdd, 0xeb, 0x1c, 0x53, 0xe7, 0xc0, 0xe5, 0x5e,
-	0xbf, 0xbd, 0x40, 0xa4, 0x03, 0xda, 0xc8, 0x26, 0x97, 0x1d, 0xaa, 0xb5, 0x17, 0x48, 0x46, 0x6a,
-	0x9f, 0x40, 0x59, 0xba, 0x89, 0x15, 0x3a, 0x18, 0x71, 0xaa, 0x0b, 0x53, 0x40, 0x4c, 0xe0, 0xe9,
-	0xd5, 0x15, 0xa3, 0x5c, 0xad, 0x50, 0x89, 0x68, 0x28, 0xec, 0xcf, 0x42, 0xee, 0xfa, 0xb2, 0xb7,
-	0x25, 0xa2, 0xc0, 0x01, 0x4c, 0x18, 0x36, 0x7f, 0x8f, 0x5f, 0xb3, 0xa7, 0xce, 0x31, 0x7a, 0x0d,
-	0x15, 0x25, 0x40, 0xf7, 0xc6, 0x3c, 0xd2, 0xcf, 0x6d, 0x3f, 0xbc, 0x83, 0xde, 0xb6, 0x81, 0xf6,
-	0xa1, 0x2c, 0x4f, 0x33, 0xb2, 0x73, 0xef, 0xf5, 0x54, 0x8c, 0xbc, 0xc3, 0xbf, 0x37, 0x39, 0x8c,
-	0xe8, 0xbe, 0x36, 0x9c, 0xba, 0xc5, 0xf6, 0xfa, 0xac, 0x42, 0x54, 0xf0, 0xa9, 0x22, 0x7f, 0x46,
-	0xcf, 0xff, 0x05, 0x00, 0x00, 0xff, 0xff, 0x2f, 0x7d, 0xc0, 0x13, 0x9c, 0x06, 0x00, 0x00,
+type searchServiceTermDictionaryClient struct {
+	grpc.ClientStream
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+func (x *searchServiceTermDictionaryClient) Recv() (*TermDictionaryResult, error) {
+	m := new(TermDictionaryResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-// SearchServiceClient is the client API for SearchService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type SearchServiceClient interface {
-	// external rpcs, for rpc clients
-	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (SearchService_SearchClient, error)
-	Check(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
-	DocCount(ctx context.Context, in *DocCountRequest, opts ...grpc.CallOption) (*DocCountResult, error)
+func (c *searchServiceClient) BatchSearch(ctx context.Context, in *BatchSearchRequest, opts ...grpc.CallOption) (SearchService_BatchSearchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[3], "/search.SearchService/BatchSearch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &searchServiceBatchSearchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-type searchServiceClient struct {
-	cc *grpc.ClientConn
+type SearchService_BatchSearchClient interface {
+	Recv() (*BatchSearchResult, error)
+	grpc.ClientStream
 }
 
-func NewSearchServiceClient(cc *grpc.ClientConn) SearchServiceClient {
-	return &searchServiceClient{cc}
+type searchServiceBatchSearchClient struct {
+	grpc.ClientStream
 }
 
-func (c *searchServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (SearchService_SearchClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[0], "/search.SearchService/Search", opts...)
-	if err != nil {
+func (x *searchServiceBatchSearchClient) Recv() (*BatchSearchResult, error) {
+	m := new(BatchSearchResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
-	x := &searchServiceSearchClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
+	return m, nil
+}
+
+func (c *searchServiceClient) GetMapping(ctx context.Context, in *MappingRequest, opts ...grpc.CallOption) (*MappingResult, error) {
+	out := new(MappingResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/GetMapping", in, out, opts...)
+	if err != nil {
 		return nil, err
 	}
-	if err := x.ClientStream.CloseSend(); err != nil {
+	return out, nil
+}
+
+func (c *searchServiceClient) SearchWithFlowControl(ctx context.Context, opts ...grpc.CallOption) (SearchService_SearchWithFlowControlClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SearchService_serviceDesc.Streams[4], "/search.SearchService/SearchWithFlowControl", opts...)
+	if err != nil {
 		return nil, err
 	}
+	x := &searchServiceSearchWithFlowControlClient{stream}
 	return x, nil
 }
 
-type SearchService_SearchClient interface {
+type SearchService_SearchWithFlowControlClient interface {
+	Send(*SearchFlowRequest) error
 	Recv() (*StreamSearchResults, error)
 	grpc.ClientStream
 }
 
-type searchServiceSearchClient struct {
+type searchServiceSearchWithFlowControlClient struct {
 	grpc.ClientStream
 }
 
-func (x *searchServiceSearchClient) Recv() (*StreamSearchResults, error) {
+func (x *searchServiceSearchWithFlowControlClient) Send(m *SearchFlowRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *searchServiceSearchWithFlowControlClient) Recv() (*StreamSearchResults, error) {
 	m := new(StreamSearchResults)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
@@ -901,18 +2352,18 @@ func (x *searchServiceSearchClient) Recv() (*StreamSearchResults, error) {
 	return m, nil
 }
 
-func (c *searchServiceClient) Check(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error) {
-	out := new(HealthCheckResponse)
-	err := c.cc.Invoke(ctx, "/search.SearchService/Check", in, out, opts...)
+func (c *searchServiceClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResult, error) {
+	out := new(PingResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/Ping", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *searchServiceClient) DocCount(ctx context.Context, in *DocCountRequest, opts ...grpc.CallOption) (*DocCountResult, error) {
-	out := new(DocCountResult)
-	err := c.cc.Invoke(ctx, "/search.SearchService/DocCount", in, out, opts...)
+func (c *searchServiceClient) IndexStats(ctx context.Context, in *IndexStatsRequest, opts ...grpc.CallOption) (*IndexStatsResult, error) {
+	out := new(IndexStatsResult)
+	err := c.cc.Invoke(ctx, "/search.SearchService/IndexStats", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -925,6 +2376,15 @@ type SearchServiceServer interface {
 	Search(*SearchRequest, SearchService_SearchServer) error
 	Check(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
 	DocCount(context.Context, *DocCountRequest) (*DocCountResult, error)
+	FieldsWithTypes(context.Context, *FieldsRequest) (*FieldsWithTypesResult, error)
+	Fields(context.Context, *FieldsRequest) (*FieldsResult, error)
+	Dump(*DumpRequest, SearchService_DumpServer) error
+	TermDictionary(*TermDictionaryRequest, SearchService_TermDictionaryServer) error
+	BatchSearch(*BatchSearchRequest, SearchService_BatchSearchServer) error
+	GetMapping(context.Context, *MappingRequest) (*MappingResult, error)
+	SearchWithFlowControl(SearchService_SearchWithFlowControlServer) error
+	Ping(context.Context, *PingRequest) (*PingResult, error)
+	IndexStats(context.Context, *IndexStatsRequest) (*IndexStatsResult, error)
 }
 
 func RegisterSearchServiceServer(s *grpc.Server, srv SearchServiceServer) {
@@ -988,6 +2448,185 @@ func _SearchService_DocCount_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SearchService_FieldsWithTypes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FieldsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServiceServer).FieldsWithTypes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/search.SearchService/FieldsWithTypes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServiceServer).FieldsWithTypes(ctx, req.(*FieldsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchService_Fields_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FieldsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServiceServer).Fields(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/search.SearchService/Fields",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServiceServer).Fields(ctx, req.(*FieldsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchService_Dump_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DumpRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SearchServiceServer).Dump(m, &searchServiceDumpServer{stream})
+}
+
+type SearchService_DumpServer interface {
+	Send(*DumpResult) error
+	grpc.ServerStream
+}
+
+type searchServiceDumpServer struct {
+	grpc.ServerStream
+}
+
+func (x *searchServiceDumpServer) Send(m *DumpResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _SearchService_TermDictionary_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TermDictionaryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SearchServiceServer).TermDictionary(m, &searchServiceTermDictionaryServer{stream})
+}
+
+type SearchService_TermDictionaryServer interface {
+	Send(*TermDictionaryResult) error
+	grpc.ServerStream
+}
+
+type searchServiceTermDictionaryServer struct {
+	grpc.ServerStream
+}
+
+func (x *searchServiceTermDictionaryServer) Send(m *TermDictionaryResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _SearchService_BatchSearch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchSearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SearchServiceServer).BatchSearch(m, &searchServiceBatchSearchServer{stream})
+}
+
+type SearchService_BatchSearchServer interface {
+	Send(*BatchSearchResult) error
+	grpc.ServerStream
+}
+
+type searchServiceBatchSearchServer struct {
+	grpc.ServerStream
+}
+
+func (x *searchServiceBatchSearchServer) Send(m *BatchSearchResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _SearchService_GetMapping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MappingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServiceServer).GetMapping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/search.SearchService/GetMapping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServiceServer).GetMapping(ctx, req.(*MappingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchService_SearchWithFlowControl_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SearchServiceServer).SearchWithFlowControl(&searchServiceSearchWithFlowControlServer{stream})
+}
+
+type SearchService_SearchWithFlowControlServer interface {
+	Send(*StreamSearchResults) error
+	Recv() (*SearchFlowRequest, error)
+	grpc.ServerStream
+}
+
+type searchServiceSearchWithFlowControlServer struct {
+	grpc.ServerStream
+}
+
+func (x *searchServiceSearchWithFlowControlServer) Send(m *StreamSearchResults) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *searchServiceSearchWithFlowControlServer) Recv() (*SearchFlowRequest, error) {
+	m := new(SearchFlowRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _SearchService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/search.SearchService/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServiceServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchService_IndexStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IndexStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServiceServer).IndexStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/search.SearchService/IndexStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServiceServer).IndexStats(ctx, req.(*IndexStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _SearchService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "search.SearchService",
 	HandlerType: (*SearchServiceServer)(nil),
@@ -1000,6 +2639,26 @@ var _SearchService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DocCount",
 			Handler:    _SearchService_DocCount_Handler,
 		},
+		{
+			MethodName: "FieldsWithTypes",
+			Handler:    _SearchService_FieldsWithTypes_Handler,
+		},
+		{
+			MethodName: "Fields",
+			Handler:    _SearchService_Fields_Handler,
+		},
+		{
+			MethodName: "GetMapping",
+			Handler:    _SearchService_GetMapping_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _SearchService_Ping_Handler,
+		},
+		{
+			MethodName: "IndexStats",
+			Handler:    _SearchService_IndexStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1007,6 +2666,27 @@ var _SearchService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _SearchService_Search_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Dump",
+			Handler:       _SearchService_Dump_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "TermDictionary",
+			Handler:       _SearchService_TermDictionary_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BatchSearch",
+			Handler:       _SearchService_BatchSearch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SearchWithFlowControl",
+			Handler:       _SearchService_SearchWithFlowControl_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "search.proto",
 }
@@ -0,0 +1,152 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The metadata keys carrying the auth of the user of a query to the
+// remote nodes, alongside the cluster-internal auth of the connection,
+// so that the remote nodes can authorize the scatter-gather requests
+// per user.
+const (
+	// rpcAuthTokenKey carries the bearer token of the user.
+	rpcAuthTokenKey = "rpcauthtoken"
+
+	// rpcOnBehalfOfKey carries the base64 encoded "user:domain" of the
+	// user, when there's no token.
+	rpcOnBehalfOfKey = "rpconbehalfof"
+)
+
+// cbOnBehalfOfHeader is the header that cbauth authenticates a request
+// made on behalf of a user by, alongside the cluster-internal auth.
+const cbOnBehalfOfHeader = "cb-on-behalf-of"
+
+// QueryAuth is the auth of the user a query runs on behalf of.
+type QueryAuth struct {
+	// BearerToken, when set, is preferred over the user identity.
+	BearerToken string
+
+	User   string
+	Domain string
+}
+
+type queryAuthKeyType string
+
+const queryAuthKey = queryAuthKeyType("queryAuth")
+
+// WithQueryAuth returns a ctx that has the queries made with it carry
+// the auth of the user to the remote servers, rather than rely only on
+// the cluster-internal auth.
+func WithQueryAuth(ctx context.Context, auth QueryAuth) context.Context {
+	return context.WithValue(ctx, queryAuthKey, auth)
+}
+
+// queryAuthFromContext returns the auth of the ctx, if any.
+func queryAuthFromContext(ctx context.Context) (QueryAuth, bool) {
+	auth, ok := ctx.Value(queryAuthKey).(QueryAuth)
+	return auth, ok
+}
+
+// appendQueryAuth adds the auth of the ctx, if any, to its outgoing
+// metadata, where a ctx without any leaves the requests with just the
+// cluster-internal auth, as do the connections without TLS, which the
+// credentials of the user are never sent over.
+func appendQueryAuth(ctx context.Context, secure bool) context.Context {
+	auth, ok := queryAuthFromContext(ctx)
+	if !ok || !secure {
+		return ctx
+	}
+
+	if auth.BearerToken != "" {
+		return metadata.AppendToOutgoingContext(ctx,
+			rpcAuthTokenKey, "Bearer "+auth.BearerToken)
+	}
+
+	if auth.User != "" {
+		return metadata.AppendToOutgoingContext(ctx,
+			rpcOnBehalfOfKey, base64.StdEncoding.EncodeToString(
+				[]byte(auth.User+":"+auth.Domain)))
+	}
+
+	return ctx
+}
+
+// wrapQueryAuthCallbacks embeds the authentication callback of the user
+// whose auth the incoming scatter-gather request carries, if any, into
+// the ctx, as verified by cbauth, so that the request is authorized for
+// the user rather than only by the cluster-internal auth.
+func wrapQueryAuthCallbacks(srv interface{}, ctx context.Context,
+	rpcPath string) (context.Context, error) {
+	token, _ := extractMetaHeader(ctx, rpcAuthTokenKey)
+	onBehalfOf, _ := extractMetaHeader(ctx, rpcOnBehalfOfKey)
+	if token == "" && onBehalfOf == "" {
+		return ctx, nil
+	}
+
+	if !peerIsSecure(ctx) {
+		return ctx, status.Error(codes.Unauthenticated,
+			"grpc_auth: query auth over an insecure connection")
+	}
+
+	s, ok := srv.(*SearchService)
+	if !ok || s == nil {
+		return ctx, status.Error(codes.Internal,
+			"grpc_auth: invalid request type")
+	}
+
+	req := &http.Request{Header: http.Header{}}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	} else {
+		auth, err := extractMetaHeader(ctx, "authorization")
+		if err != nil {
+			return ctx, status.Errorf(codes.Unauthenticated,
+				"grpc_auth: query auth, err: %v", err)
+		}
+		req.Header.Set("Authorization", auth)
+		req.Header.Set(cbOnBehalfOfHeader, onBehalfOf)
+	}
+
+	creds, err := CBAuthWebCreds(req)
+	if err != nil {
+		return ctx, status.Errorf(codes.Unauthenticated,
+			"grpc_auth: query auth, err: %v", err)
+	}
+
+	aw := &authWrapper{mgr: s.mgr, creds: creds,
+		path: rpcPath[strings.LastIndex(rpcPath, "/"):], method: "RPC"}
+
+	return context.WithValue(ctx, gRPCAuthHandlerKey,
+		gRPCAuthHandler(aw.authenticate)), nil
+}
+
+// peerIsSecure returns whether the peer of the incoming request is
+// connected over TLS.
+func peerIsSecure(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return false
+	}
+	_, ok = p.AuthInfo.(credentials.TLSInfo)
+	return ok
+}
@@ -0,0 +1,91 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/cbauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestWrapQueryAuthCallbacks(t *testing.T) {
+	origCBAuthWebCreds := CBAuthWebCreds
+	defer func() {
+		CBAuthWebCreds = origCBAuthWebCreds
+	}()
+
+	var authReq *http.Request
+	var authErr error
+	CBAuthWebCreds = func(req *http.Request) (cbauth.Creds, error) {
+		authReq = req
+		return nil, authErr
+	}
+
+	const path = "/protobuf.SearchService/Search"
+	srv := &SearchService{}
+	secure := func(ctx context.Context) context.Context {
+		return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{}})
+	}
+	incoming := func(kv ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(append([]string{"authorization", "Basic aW50"},
+				kv...)...))
+	}
+
+	// the requests without the user's auth keep to the internal auth
+	ctx, err := wrapQueryAuthCallbacks(srv, secure(incoming()), path)
+	if err != nil || ctx.Value(gRPCAuthHandlerKey) != nil || authReq != nil {
+		t.Errorf("expected no query auth, err: %v", err)
+	}
+
+	// the user's auth is refused over the insecure connections
+	_, err = wrapQueryAuthCallbacks(srv,
+		incoming(rpcOnBehalfOfKey, "YWxpY2U6bG9jYWw="), path)
+	if status.Code(err) != codes.Unauthenticated || authReq != nil {
+		t.Errorf("expected the insecure query auth refused, err: %v", err)
+	}
+
+	// and verified by cbauth otherwise, along with the internal auth
+	ctx, err = wrapQueryAuthCallbacks(srv,
+		secure(incoming(rpcOnBehalfOfKey, "YWxpY2U6bG9jYWw=")), path)
+	if err != nil || ctx.Value(gRPCAuthHandlerKey) == nil || authReq == nil ||
+		authReq.Header.Get("Authorization") != "Basic aW50" ||
+		authReq.Header.Get(cbOnBehalfOfHeader) != "YWxpY2U6bG9jYWw=" {
+		t.Fatalf("expected the on behalf of auth verified, err: %v", err)
+	}
+	if err = verifyRPCAuth(ctx, "idx", nil); err != nil {
+		t.Errorf("expected the request authorized, err: %v", err)
+	}
+
+	// where a token is verified on its own
+	ctx, err = wrapQueryAuthCallbacks(srv,
+		secure(incoming(rpcAuthTokenKey, "Bearer t0k3n")), path)
+	if err != nil || ctx.Value(gRPCAuthHandlerKey) == nil ||
+		authReq.Header.Get("Authorization") != "Bearer t0k3n" {
+		t.Errorf("expected the token auth verified, err: %v", err)
+	}
+
+	authErr = fmt.Errorf("unknown user")
+	_, err = wrapQueryAuthCallbacks(srv,
+		secure(incoming(rpcAuthTokenKey, "Bearer t0k3n")), path)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected the query auth rejected, err: %v", err)
+	}
+}
@@ -11,7 +11,10 @@
 
 package cbft
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // RegistryQueryEventCallbacks should be treated as read-only after
 // process init()'ialization.
@@ -20,8 +23,56 @@ var RegistryQueryEventCallback func(int, QueryEvent, uint64) error
 type QueryEvent struct {
 	Kind     QueryEventKind
 	Duration time.Duration
+
+	// IndexName and Priority are of the query, when known, which is
+	// only the case for the events of the top level (depth 0) queries.
+	IndexName string
+	Priority  QueryPriority
+
+	// CanDegrade is of the start events of the queries that may be
+	// admitted in the degraded mode rather than be rejected, and
+	// Degraded is of the end events of the queries that were.
+	CanDegrade bool
+	Degraded   bool
+
+	// ResultSize is of the end events of the top level queries, when
+	// known, as the size of the merged search result of the query,
+	// which calibrates the estimates of the later queries of the
+	// index, as those estimate the memory needed for the result.
+	ResultSize uint64
+
+	// Admission is of the top level queries, shared by their start and
+	// end events, for the callback of the start event to note how the
+	// query was admitted, which the end event then releases.
+	Admission *QueryAdmission
+}
+
+// QueryAdmission is how a top level query was admitted.
+type QueryAdmission struct {
+	// ReservedSlot is whether the query took over a reserved slot.
+	ReservedSlot bool
 }
 
+// Optional callback that returns the memory estimate of a top level
+// query of an index, corrected by the result sizes of the earlier
+// queries of the index, which is then the size of both its start and
+// its end events.
+var CorrectQueryEstimate func(indexName string, estimate uint64) uint64
+
+// correctQueryEstimate returns the estimate corrected by the
+// CorrectQueryEstimate, if any.
+func correctQueryEstimate(indexName string, estimate uint64) uint64 {
+	if CorrectQueryEstimate != nil {
+		return CorrectQueryEstimate(indexName, estimate)
+	}
+	return estimate
+}
+
+// ErrQueryDegraded is returned by the callback of a start event that
+// admits the query in the memory-bounded degraded mode, rather than
+// rejecting it for being over the query quota.
+var ErrQueryDegraded = errors.New("query admitted in the degraded mode")
+
 // QueryEventKind represents an event code for OnEvent() callbacks.
 type QueryEventKind int
 
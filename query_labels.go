@@ -0,0 +1,200 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// rpcQueryLabelsKey is the metadata key carrying the JSON encoded
+// labels of a query, such as {"app": "search-ui", "team": "catalog"}.
+const rpcQueryLabelsKey = "rpcquerylabels"
+
+// The bounds of the labels of a query, which keep the metadata small.
+const (
+	maxQueryLabels   = 8
+	maxQueryLabelLen = 64 // Of each label name and value.
+)
+
+// maxQueryLabelStats bounds the distinct labels tracked in the stats,
+// beyond which the queries are attributed to queryLabelStatsOther, as
+// each label adds its own stats keys to every stats scrape.
+const maxQueryLabelStats = 64
+
+const queryLabelStatsOther = "_other"
+
+// validateQueryLabels checks the labels against their bounds.
+func validateQueryLabels(labels map[string]string) error {
+	if len(labels) > maxQueryLabels {
+		return fmt.Errorf("query_labels: too many labels: %d,"+
+			" max: %d", len(labels), maxQueryLabels)
+	}
+
+	for name, value := range labels {
+		if name == "" {
+			return fmt.Errorf("query_labels: empty label name")
+		}
+		if len(name) > maxQueryLabelLen || len(value) > maxQueryLabelLen {
+			return fmt.Errorf("query_labels: label: %q too long,"+
+				" max name and value length: %d", name, maxQueryLabelLen)
+		}
+	}
+
+	return nil
+}
+
+type queryLabelsKeyType string
+
+const queryLabelsKey = queryLabelsKeyType("queryLabels")
+
+// WithQueryLabels returns a ctx that has the queries made with it carry
+// the given labels to the remote servers, which attribute their stats
+// to the labels.  Oversized label sets are rejected.
+func WithQueryLabels(ctx context.Context, labels map[string]string) (
+	context.Context, error) {
+	if err := validateQueryLabels(labels); err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, queryLabelsKey, labels), nil
+}
+
+// queryLabelsFromContext returns the labels of the ctx, if any.
+func queryLabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(queryLabelsKey).(map[string]string)
+	return labels
+}
+
+// appendQueryLabels adds the labels of the ctx, if any, to its
+// outgoing metadata.
+func appendQueryLabels(ctx context.Context) (context.Context, error) {
+	labels := queryLabelsFromContext(ctx)
+	if len(labels) == 0 {
+		return ctx, nil
+	}
+
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.AppendToOutgoingContext(ctx,
+		rpcQueryLabelsKey, string(b)), nil
+}
+
+// queryLabelsFromMetadata returns the validated labels of an incoming
+// request, if any.
+func queryLabelsFromMetadata(ctx context.Context) (map[string]string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	vals := md.Get(rpcQueryLabelsKey)
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	var labels map[string]string
+	err := json.Unmarshal([]byte(vals[0]), &labels)
+	if err != nil {
+		return nil, fmt.Errorf("query_labels: parsing labels, err: %v", err)
+	}
+
+	return labels, validateQueryLabels(labels)
+}
+
+// ---------------------------------------------------------
+
+// QueryLabelStats represents the stats of the gRPC queries served for
+// a label.
+type QueryLabelStats struct {
+	TotGrpcRequest               uint64
+	TotGrpcRequestTimeNS         uint64
+	TotGrpcRequestErr            uint64
+	TotGrpcInternalRequest       uint64
+	TotGrpcInternalRequestTimeNS uint64
+}
+
+var queryLabelStatsMutex sync.Mutex
+var queryLabelStats = map[string]*QueryLabelStats{}
+
+// getQueryLabelStats returns the stats of a "name=value" label,
+// which are shared by all the labels past maxQueryLabelStats.
+func getQueryLabelStats(label string) *QueryLabelStats {
+	queryLabelStatsMutex.Lock()
+	rv, exists := queryLabelStats[label]
+	if !exists {
+		if len(queryLabelStats) >= maxQueryLabelStats {
+			label = queryLabelStatsOther
+			rv = queryLabelStats[label]
+		}
+		if rv == nil {
+			rv = &QueryLabelStats{}
+			queryLabelStats[label] = rv
+		}
+	}
+	queryLabelStatsMutex.Unlock()
+	return rv
+}
+
+// updateQueryLabelStats attributes a served query to each of its
+// labels, where internal means the query was a scatter-gather request
+// from a coordinating node.
+func updateQueryLabelStats(labels map[string]string, internal bool,
+	d time.Duration, err error) {
+	for name, value := range labels {
+		s := getQueryLabelStats(name + "=" + value)
+		if internal {
+			atomic.AddUint64(&s.TotGrpcInternalRequest, 1)
+			atomic.AddUint64(&s.TotGrpcInternalRequestTimeNS, uint64(d))
+			continue
+		}
+
+		atomic.AddUint64(&s.TotGrpcRequest, 1)
+		atomic.AddUint64(&s.TotGrpcRequestTimeNS, uint64(d))
+		if err != nil {
+			atomic.AddUint64(&s.TotGrpcRequestErr, 1)
+		}
+	}
+}
+
+// queryLabelStatsSnapshot returns a copy of the stats, keyed by the
+// "name=value" labels.
+func queryLabelStatsSnapshot() map[string]QueryLabelStats {
+	queryLabelStatsMutex.Lock()
+	labels := make([]string, 0, len(queryLabelStats))
+	stats := make([]*QueryLabelStats, 0, len(queryLabelStats))
+	for label, s := range queryLabelStats {
+		labels = append(labels, label)
+		stats = append(stats, s)
+	}
+	queryLabelStatsMutex.Unlock()
+
+	rv := make(map[string]QueryLabelStats, len(labels))
+	for i, s := range stats {
+		rv[labels[i]] = QueryLabelStats{
+			TotGrpcRequest:               atomic.LoadUint64(&s.TotGrpcRequest),
+			TotGrpcRequestTimeNS:         atomic.LoadUint64(&s.TotGrpcRequestTimeNS),
+			TotGrpcRequestErr:            atomic.LoadUint64(&s.TotGrpcRequestErr),
+			TotGrpcInternalRequest:       atomic.LoadUint64(&s.TotGrpcInternalRequest),
+			TotGrpcInternalRequestTimeNS: atomic.LoadUint64(&s.TotGrpcInternalRequestTimeNS),
+		}
+	}
+	return rv
+}
@@ -0,0 +1,124 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestValidateQueryLabels(t *testing.T) {
+	if err := validateQueryLabels(nil); err != nil {
+		t.Errorf("expected no labels to be valid, err: %v", err)
+	}
+
+	tooMany := map[string]string{}
+	for i := 0; i <= maxQueryLabels; i++ {
+		tooMany[fmt.Sprintf("l%d", i)] = "v"
+	}
+
+	tests := []map[string]string{
+		tooMany,
+		{"": "v"},
+		{strings.Repeat("n", maxQueryLabelLen+1): "v"},
+		{"app": strings.Repeat("v", maxQueryLabelLen+1)},
+	}
+	for i, labels := range tests {
+		if _, err := WithQueryLabels(context.Background(), labels); err == nil {
+			t.Errorf("test %d, expected labels to be rejected", i)
+		}
+	}
+}
+
+func TestQueryLabelsMetadata(t *testing.T) {
+	labels := map[string]string{"app": "search-ui", "team": "catalog"}
+
+	ctx, err := WithQueryLabels(context.Background(), labels)
+	if err != nil {
+		t.Fatalf("expected valid labels, err: %v", err)
+	}
+
+	ctx, err = appendQueryLabels(ctx)
+	if err != nil {
+		t.Fatalf("expected labels to be appended, err: %v", err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	got, err := queryLabelsFromMetadata(
+		metadata.NewIncomingContext(context.Background(), md))
+	if err != nil || !reflect.DeepEqual(got, labels) {
+		t.Errorf("expected labels: %v, got: %v, err: %v", labels, got, err)
+	}
+
+	// no labels means no metadata
+	ctx, _ = appendQueryLabels(context.Background())
+	if md, ok := metadata.FromOutgoingContext(ctx); ok &&
+		len(md.Get(rpcQueryLabelsKey)) > 0 {
+		t.Errorf("expected no labels metadata, got: %v", md)
+	}
+
+	// oversized labels are rejected by the server too
+	md = metadata.Pairs(rpcQueryLabelsKey,
+		`{"app": "`+strings.Repeat("v", maxQueryLabelLen+1)+`"}`)
+	if _, err = queryLabelsFromMetadata(
+		metadata.NewIncomingContext(context.Background(), md)); err == nil {
+		t.Errorf("expected oversized labels to be rejected")
+	}
+}
+
+func TestQueryLabelStats(t *testing.T) {
+	queryLabelStatsMutex.Lock()
+	prev := queryLabelStats
+	queryLabelStats = map[string]*QueryLabelStats{}
+	queryLabelStatsMutex.Unlock()
+	defer func() {
+		queryLabelStatsMutex.Lock()
+		queryLabelStats = prev
+		queryLabelStatsMutex.Unlock()
+	}()
+
+	labels := map[string]string{"app": "ui"}
+	updateQueryLabelStats(labels, false, time.Second, nil)
+	updateQueryLabelStats(labels, false, time.Second, fmt.Errorf("failed"))
+	updateQueryLabelStats(labels, true, time.Second, nil)
+
+	exp := QueryLabelStats{
+		TotGrpcRequest:               2,
+		TotGrpcRequestTimeNS:         uint64(2 * time.Second),
+		TotGrpcRequestErr:            1,
+		TotGrpcInternalRequest:       1,
+		TotGrpcInternalRequestTimeNS: uint64(time.Second),
+	}
+	if got := queryLabelStatsSnapshot()["app=ui"]; got != exp {
+		t.Errorf("expected label stats: %+v, got: %+v", exp, got)
+	}
+
+	// the labels past the max share the overflow stats
+	for i := 0; i < maxQueryLabelStats; i++ {
+		updateQueryLabelStats(map[string]string{"n": fmt.Sprint(i)},
+			false, 0, nil)
+	}
+	snapshot := queryLabelStatsSnapshot()
+	if len(snapshot) != maxQueryLabelStats+1 {
+		t.Errorf("expected %d label stats, got: %d",
+			maxQueryLabelStats+1, len(snapshot))
+	}
+	if n := snapshot[queryLabelStatsOther].TotGrpcRequest; n != 1 {
+		t.Errorf("expected 1 overflow query, got: %d", n)
+	}
+}
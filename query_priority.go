@@ -0,0 +1,101 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// QueryPriority is the admission priority of a query.
+type QueryPriority int
+
+const (
+	// QueryPriorityHigh is the default priority, meant for the
+	// interactive queries.
+	QueryPriorityHigh QueryPriority = iota
+
+	// QueryPriorityLow is meant for the background queries, which are
+	// rejected ahead of the high priority queries as the queryQuota
+	// fills up.
+	QueryPriorityLow
+)
+
+func (p QueryPriority) String() string {
+	if p == QueryPriorityLow {
+		return "low"
+	}
+	return "high"
+}
+
+// ParseQueryPriority parses a priority, where "" means high.
+func ParseQueryPriority(s string) (QueryPriority, error) {
+	switch s {
+	case "", "high":
+		return QueryPriorityHigh, nil
+	case "low":
+		return QueryPriorityLow, nil
+	}
+	return QueryPriorityHigh, fmt.Errorf("query_priority: unknown"+
+		" priority: %q", s)
+}
+
+// rpcQueryPriorityKey is the metadata key carrying the priority of a
+// query, which is only sent when not the default.
+const rpcQueryPriorityKey = "rpcquerypriority"
+
+type queryPriorityKeyType string
+
+const queryPriorityKey = queryPriorityKeyType("queryPriority")
+
+// WithQueryPriority returns a ctx that has the queries made with it
+// carry the given priority to the remote servers.
+func WithQueryPriority(ctx context.Context,
+	priority QueryPriority) context.Context {
+	return context.WithValue(ctx, queryPriorityKey, priority)
+}
+
+// queryPriorityFromContext returns the priority of the ctx, which is
+// high by default.
+func queryPriorityFromContext(ctx context.Context) QueryPriority {
+	priority, _ := ctx.Value(queryPriorityKey).(QueryPriority)
+	return priority
+}
+
+// appendQueryPriority adds the priority of the ctx to its outgoing
+// metadata, unless it's the default.
+func appendQueryPriority(ctx context.Context) context.Context {
+	priority := queryPriorityFromContext(ctx)
+	if priority == QueryPriorityHigh {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		rpcQueryPriorityKey, priority.String())
+}
+
+// queryPriorityFromMetadata returns the priority of an incoming
+// request, which is high by default.
+func queryPriorityFromMetadata(ctx context.Context) (QueryPriority, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return QueryPriorityHigh, nil
+	}
+
+	vals := md.Get(rpcQueryPriorityKey)
+	if len(vals) == 0 {
+		return QueryPriorityHigh, nil
+	}
+
+	return ParseQueryPriority(vals[0])
+}
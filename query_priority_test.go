@@ -0,0 +1,70 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParseQueryPriority(t *testing.T) {
+	tests := []struct {
+		s      string
+		exp    QueryPriority
+		expErr bool
+	}{
+		{"", QueryPriorityHigh, false},
+		{"high", QueryPriorityHigh, false},
+		{"low", QueryPriorityLow, false},
+		{"urgent", QueryPriorityHigh, true},
+	}
+
+	for i, test := range tests {
+		p, err := ParseQueryPriority(test.s)
+		if p != test.exp || (err != nil) != test.expErr {
+			t.Errorf("test %d, expected priority: %v, err: %v,"+
+				" got: %v, err: %v", i, test.exp, test.expErr, p, err)
+		}
+	}
+}
+
+func TestQueryPriorityMetadata(t *testing.T) {
+	ctx := appendQueryPriority(
+		WithQueryPriority(context.Background(), QueryPriorityLow))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	p, err := queryPriorityFromMetadata(
+		metadata.NewIncomingContext(context.Background(), md))
+	if err != nil || p != QueryPriorityLow {
+		t.Errorf("expected the low priority, got: %v, err: %v", p, err)
+	}
+
+	// the default priority means no metadata
+	ctx = appendQueryPriority(context.Background())
+	if md, ok := metadata.FromOutgoingContext(ctx); ok &&
+		len(md.Get(rpcQueryPriorityKey)) > 0 {
+		t.Errorf("expected no priority metadata, got: %v", md)
+	}
+
+	p, err = queryPriorityFromMetadata(context.Background())
+	if err != nil || p != QueryPriorityHigh {
+		t.Errorf("expected the high priority, got: %v, err: %v", p, err)
+	}
+
+	md = metadata.Pairs(rpcQueryPriorityKey, "urgent")
+	if _, err = queryPriorityFromMetadata(
+		metadata.NewIncomingContext(context.Background(), md)); err == nil {
+		t.Errorf("expected an unknown priority to be rejected")
+	}
+}
@@ -0,0 +1,132 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/cbgt/rest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The limits that a query may be rejected for.
+const (
+	QueryRejectLimitQuery       = "query"
+	QueryRejectLimitApp         = "app"
+	QueryRejectLimitLowPriority = "lowPriority"
+	QueryRejectLimitIndexShare  = "indexShare"
+	QueryRejectLimitDraining    = "draining"
+)
+
+// QueryRejectedError is returned by the callback of a start event that
+// rejects the query, telling which limit the query hit and by how
+// much, so that the client may choose between retrying, downscoping
+// and backing off.  It wraps the rest.ErrorQueryReqRejected.
+type QueryRejectedError struct {
+	// Limit is one of the QueryRejectLimit's.
+	Limit string `json:"limit"`
+
+	// Quota is the bytes of the limit that was hit.
+	Quota int64 `json:"quota"`
+
+	// Estimate is the estimated bytes of the query.
+	Estimate uint64 `json:"estimate"`
+
+	// Usage is the bytes in use against the limit, including the
+	// estimate of the query.
+	Usage int64 `json:"usage"`
+}
+
+func (e *QueryRejectedError) Error() string {
+	return fmt.Sprintf("%v, limit: %s, quota: %d, estimate: %d, usage: %d",
+		rest.ErrorQueryReqRejected, e.Limit, e.Quota, e.Estimate, e.Usage)
+}
+
+func (e *QueryRejectedError) Unwrap() error {
+	return rest.ErrorQueryReqRejected
+}
+
+// GRPCStatus returns the gRPC status of the rejection, which is
+// Unavailable while the node is shutting down, or else
+// ResourceExhausted.
+func (e *QueryRejectedError) GRPCStatus() *status.Status {
+	code := codes.ResourceExhausted
+	if e.Limit == QueryRejectLimitDraining {
+		code = codes.Unavailable
+	}
+	return status.New(code, e.Error())
+}
+
+// rpcQueryRejectedKey is the trailer metadata key carrying the JSON of
+// the QueryRejectedError of a search rejected by a remote server.
+const rpcQueryRejectedKey = "queryrejected"
+
+// setQueryRejectedTrailer reports the rejection of a search in the
+// trailer of its stream, for the client to decode.
+func setQueryRejectedTrailer(stream grpc.ServerStream,
+	e *QueryRejectedError) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	stream.SetTrailer(metadata.Pairs(rpcQueryRejectedKey, string(b)))
+}
+
+// queryRejectedFromTrailer returns the rejection of a search reported
+// in its trailer, or nil when there's none, as by the servers of older
+// versions.
+func queryRejectedFromTrailer(md metadata.MD) *QueryRejectedError {
+	vals := md.Get(rpcQueryRejectedKey)
+	if len(vals) == 0 || vals[0] == "" {
+		return nil
+	}
+
+	var rv QueryRejectedError
+	if err := json.Unmarshal([]byte(vals[0]), &rv); err != nil {
+		return nil
+	}
+	return &rv
+}
+
+// writeQueryRejected responds to a rejected query with the status 429,
+// or 503 while the node is shutting down, and a JSON body of the limit
+// that was hit.
+func writeQueryRejected(w http.ResponseWriter, e *QueryRejectedError) {
+	status := http.StatusTooManyRequests
+	if e.Limit == QueryRejectLimitDraining {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	mustEncode(w, struct {
+		Status   string `json:"status"`
+		Error    string `json:"error"`
+		Limit    string `json:"limit"`
+		Quota    int64  `json:"quota"`
+		Estimate uint64 `json:"estimate"`
+		Usage    int64  `json:"usage"`
+	}{
+		Status:   "fail",
+		Error:    e.Error(),
+		Limit:    e.Limit,
+		Quota:    e.Quota,
+		Estimate: e.Estimate,
+		Usage:    e.Usage,
+	})
+}
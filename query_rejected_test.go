@@ -0,0 +1,152 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"github.com/couchbase/cbgt/rest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestQueryRejectedError(t *testing.T) {
+	var err error = &QueryRejectedError{
+		Limit:    QueryRejectLimitApp,
+		Quota:    1000,
+		Estimate: 100,
+		Usage:    1100,
+	}
+
+	if !errors.Is(err, rest.ErrorQueryReqRejected) {
+		t.Errorf("expected the rejection to wrap ErrorQueryReqRejected")
+	}
+
+	w := httptest.NewRecorder()
+	writeQueryRejected(w, err.(*QueryRejectedError))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the status 429, got: %d", w.Code)
+	}
+
+	var body struct {
+		Status   string `json:"status"`
+		Error    string `json:"error"`
+		Limit    string `json:"limit"`
+		Quota    int64  `json:"quota"`
+		Estimate uint64 `json:"estimate"`
+		Usage    int64  `json:"usage"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body, err: %v", err)
+	}
+	if body.Status != "fail" || body.Error != err.Error() ||
+		body.Limit != QueryRejectLimitApp || body.Quota != 1000 ||
+		body.Estimate != 100 || body.Usage != 1100 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+}
+
+// rejectingStreamClient is a pb.SearchServiceClient whose Searches are
+// rejected with the err, along with the trailer.
+type rejectingStreamClient struct {
+	pb.SearchServiceClient
+	err     error
+	trailer metadata.MD
+}
+
+func (c *rejectingStreamClient) Search(ctx context.Context,
+	in *pb.SearchRequest, opts ...grpc.CallOption) (
+	pb.SearchService_SearchClient, error) {
+	return &rejectingStream{err: c.err, trailer: c.trailer}, nil
+}
+
+type rejectingStream struct {
+	grpc.ClientStream
+	err     error
+	trailer metadata.MD
+}
+
+func (s *rejectingStream) Recv() (*pb.StreamSearchResults, error) {
+	return nil, s.err
+}
+
+func (s *rejectingStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func TestGrpcClientQueryRejected(t *testing.T) {
+	rejected := &QueryRejectedError{
+		Limit:    QueryRejectLimitQuery,
+		Quota:    1000,
+		Estimate: 400,
+		Usage:    1200,
+	}
+	b, err := json.Marshal(rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &rejectingStreamClient{
+		err: status.Errorf(rejected.GRPCStatus().Code(),
+			"grpc_server: Search query reject on not enough quota: %v",
+			rejected),
+		trailer: metadata.Pairs(rpcQueryRejectedKey, string(b)),
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0"},
+		GrpcCli:     cli,
+	}
+
+	query := func() error {
+		_, err := g.Query(context.Background(), &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		return err
+	}
+
+	// the reason of the rejection is decoded from the trailer
+	err = query()
+	qre, ok := err.(*QueryRejectedError)
+	if !ok || *qre != *rejected {
+		t.Fatalf("expected the rejection of the server, got: %#v", err)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the rejection to keep its code, got: %s",
+			status.Code(err))
+	}
+
+	// servers of older versions only reject with the status
+	cli.trailer = nil
+	if err = query(); err == nil {
+		t.Fatalf("expected the query to be rejected")
+	}
+	if _, ok = err.(*QueryRejectedError); ok {
+		t.Errorf("expected no decoded rejection without the trailer")
+	}
+
+	draining := &QueryRejectedError{Limit: QueryRejectLimitDraining}
+	if code := status.Code(draining); code != codes.Unavailable {
+		t.Errorf("expected the draining to be unavailable, got: %s", code)
+	}
+}
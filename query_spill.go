@@ -0,0 +1,272 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/couchbase/cbgt"
+)
+
+// ExecutionModeDegraded is the execution mode in the results of the
+// queries admitted in the degraded mode, which are searched with
+// their intermediate hits spilled to disk.
+const ExecutionModeDegraded = "degraded"
+
+// totQueryDegraded tracks the number of queries that were admitted
+// in the degraded mode, rather than rejected over the query quota.
+var totQueryDegraded uint64
+
+// querySpillDir returns the dir of the spill files of the queries in
+// the degraded mode, which defaults to a dir within the data dir.
+func querySpillDir(mgr *cbgt.Manager) string {
+	if dir := mgr.Options()["querySpillDir"]; dir != "" {
+		return dir
+	}
+	return filepath.Join(mgr.DataDir(), "query_spill")
+}
+
+// spillSearch is the memory-bounded search of the degraded mode, where
+// the indexes of the alias are searched one at a time, rather than
+// concurrently, and the hits of each are spilled to a file, so that
+// only the results of a single index are in memory at a time.  The
+// spilled hits are then merged from the files, keeping only the hits
+// of the requested page.
+func spillSearch(ctx context.Context, dir string, alias BleveIndexCollector,
+	req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	searchStart := time.Now()
+
+	var indexes []bleve.Index
+	alias.VisitIndexes(func(i bleve.Index) {
+		indexes = append(indexes, i)
+	})
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// each index returns the hits of the pages before the requested
+	// one, as the page is only known once all the hits are merged
+	childReq := *req
+	childReq.From = 0
+	childReq.Size = req.From + req.Size
+
+	rv := &bleve.SearchResult{
+		Status: &bleve.SearchStatus{
+			Errors: make(map[string]error),
+		},
+	}
+
+	var spills []*hitSpill
+	defer func() {
+		for _, s := range spills {
+			s.close()
+		}
+	}()
+
+	for _, index := range indexes {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result, err := index.SearchInContext(ctx, &childReq)
+		if err != nil {
+			rv.Status.Total++
+			rv.Status.Failed++
+			rv.Status.Errors[index.Name()] = err
+			continue
+		}
+
+		s, err := spillHits(dir, result.Hits)
+		if err != nil {
+			return nil, err
+		}
+		spills = append(spills, s)
+
+		result.Hits = nil
+		rv.Merge(result)
+	}
+
+	rv.Hits, err = mergeSpilledHits(spills, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, fr := range req.Facets {
+		rv.Facets.Fixup(name, fr.Size)
+	}
+
+	rv.Request = req
+	rv.Took = time.Since(searchStart)
+
+	atomic.AddUint64(&totQueryDegraded, 1)
+
+	return rv, nil
+}
+
+// hitSpill is a file of the sorted hits of an index, which are
+// decoded one at a time while merging.
+type hitSpill struct {
+	f    *os.File
+	dec  *json.Decoder
+	head *search.DocumentMatch
+}
+
+// spilledHit is the record of a hit in a spill file, which carries
+// the HitNumber that the json of the hit omits, as the sort order of
+// the hits falls back to it on the ties.
+type spilledHit struct {
+	*search.DocumentMatch
+	HitNumber uint64 `json:"hitNumber"`
+}
+
+// spillHits writes the hits to a new spill file, which is removed
+// once closed.
+func spillHits(dir string, hits search.DocumentMatchCollection) (
+	*hitSpill, error) {
+	f, err := ioutil.TempFile(dir, "hits-")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &hitSpill{f: f}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, hit := range hits {
+		err = enc.Encode(spilledHit{DocumentMatch: hit,
+			HitNumber: hit.HitNumber})
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	if err = w.Flush(); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	s.dec = json.NewDecoder(bufio.NewReader(f))
+
+	return s, nil
+}
+
+// next decodes the next hit into the head, which is nil once the hits
+// are exhausted.
+func (s *hitSpill) next() error {
+	hit := spilledHit{DocumentMatch: &search.DocumentMatch{}}
+	err := s.dec.Decode(&hit)
+	if err == io.EOF {
+		s.head = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hit.DocumentMatch.HitNumber = hit.HitNumber
+	s.head = hit.DocumentMatch
+	return nil
+}
+
+func (s *hitSpill) close() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// mergeSpilledHits merges the sorted hits of the spills into the hits
+// of the requested page.
+func mergeSpilledHits(spills []*hitSpill, req *bleve.SearchRequest) (
+	search.DocumentMatchCollection, error) {
+	h := &hitSpillHeap{
+		sort:          req.Sort,
+		cachedScoring: req.Sort.CacheIsScore(),
+		cachedDesc:    req.Sort.CacheDescending(),
+	}
+
+	for _, s := range spills {
+		if err := s.next(); err != nil {
+			return nil, err
+		}
+		if s.head != nil {
+			h.spills = append(h.spills, s)
+		}
+	}
+	heap.Init(h)
+
+	var rv search.DocumentMatchCollection
+	for skipped := 0; h.Len() > 0 && len(rv) < req.Size; {
+		s := h.spills[0]
+		if skipped < req.From {
+			skipped++
+		} else {
+			rv = append(rv, s.head)
+		}
+
+		if err := s.next(); err != nil {
+			return nil, err
+		}
+		if s.head != nil {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return rv, nil
+}
+
+// hitSpillHeap orders the spills by their heads, in the sort order of
+// the request.
+type hitSpillHeap struct {
+	spills        []*hitSpill
+	sort          search.SortOrder
+	cachedScoring []bool
+	cachedDesc    []bool
+}
+
+func (h *hitSpillHeap) Len() int { return len(h.spills) }
+
+func (h *hitSpillHeap) Less(i, j int) bool {
+	return h.sort.Compare(h.cachedScoring, h.cachedDesc,
+		h.spills[i].head, h.spills[j].head) < 0
+}
+
+func (h *hitSpillHeap) Swap(i, j int) {
+	h.spills[i], h.spills[j] = h.spills[j], h.spills[i]
+}
+
+func (h *hitSpillHeap) Push(x interface{}) {
+	h.spills = append(h.spills, x.(*hitSpill))
+}
+
+func (h *hitSpillHeap) Pop() interface{} {
+	n := len(h.spills)
+	x := h.spills[n-1]
+	h.spills = h.spills[:n-1]
+	return x
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+func TestMergeSpilledHits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query_spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hits := func(scores ...float64) search.DocumentMatchCollection {
+		var rv search.DocumentMatchCollection
+		for _, score := range scores {
+			rv = append(rv, &search.DocumentMatch{
+				ID:    "doc",
+				Score: score,
+				Sort:  []string{"_score"},
+			})
+		}
+		return rv
+	}
+
+	var spills []*hitSpill
+	for _, h := range []search.DocumentMatchCollection{
+		hits(9, 6, 3), hits(8, 5), hits(), hits(7, 4, 1),
+	} {
+		s, err := spillHits(dir, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spills = append(spills, s)
+	}
+
+	req := bleve.NewSearchRequestOptions(query.NewMatchAllQuery(), 4, 2, false)
+
+	rv, err := mergeSpilledHits(spills, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scores []float64
+	for _, hit := range rv {
+		scores = append(scores, hit.Score)
+	}
+	exp := []float64{7, 6, 5, 4}
+	if len(scores) != len(exp) {
+		t.Fatalf("expected the hits of the page: %v, got: %v", exp, scores)
+	}
+	for i := range exp {
+		if scores[i] != exp[i] {
+			t.Fatalf("expected the hits of the page: %v, got: %v", exp, scores)
+		}
+	}
+
+	for _, s := range spills {
+		s.close()
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, got: %d", len(files))
+	}
+}
+
+func TestSpilledHitsKeepHitNumber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query_spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := spillHits(dir, search.DocumentMatchCollection{
+		&search.DocumentMatch{ID: "a", Score: 1, HitNumber: 7},
+		&search.DocumentMatch{ID: "b", Score: 1, HitNumber: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// the hit numbers, which order the ties, survive the spill
+	for _, exp := range []struct {
+		id        string
+		hitNumber uint64
+	}{{"a", 7}, {"b", 3}} {
+		if err = s.next(); err != nil {
+			t.Fatal(err)
+		}
+		if s.head == nil || s.head.ID != exp.id ||
+			s.head.HitNumber != exp.hitNumber {
+			t.Fatalf("expected the hit: %s, hit number: %d, got: %+v",
+				exp.id, exp.hitNumber, s.head)
+		}
+	}
+}
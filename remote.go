@@ -18,6 +18,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -33,7 +35,51 @@ import (
 	log "github.com/couchbase/clog"
 )
 
+// The known cluster actions, sent by the scatter-gather requests
+// between nodes as the rpcClusterActionKey metadata or the
+// rest.CLUSTER_ACTION header.  The default "fts/scatter-gather" is for
+// interactive queries, while "fts/scatter-gather/background" is for
+// background, analytical queries, which servers may route or rate
+// limit differently.
 const clusterActionScatterGather = "fts/scatter-gather"
+const ClusterActionScatterGatherBackground = "fts/scatter-gather/background"
+
+// scatterGatherClusterActions is the allowlist of the cluster actions
+// that a query may override the default scatter-gather action with.
+var scatterGatherClusterActions = map[string]bool{
+	clusterActionScatterGather:           true,
+	ClusterActionScatterGatherBackground: true,
+}
+
+// isScatterGatherClusterAction returns true for any of the known
+// scatter-gather cluster actions.
+func isScatterGatherClusterAction(action string) bool {
+	return scatterGatherClusterActions[action]
+}
+
+type clusterActionKeyType string
+
+const clusterActionKey = clusterActionKeyType("clusterAction")
+
+// WithClusterAction returns a ctx that overrides the cluster action of
+// the scatter-gather requests of a query, which must be one of the
+// known scatter-gather cluster actions.
+func WithClusterAction(ctx context.Context, action string) (
+	context.Context, error) {
+	if !isScatterGatherClusterAction(action) {
+		return nil, fmt.Errorf("remote: unknown cluster action: %q", action)
+	}
+	return context.WithValue(ctx, clusterActionKey, action), nil
+}
+
+// clusterActionFromContext returns the cluster action of the ctx,
+// defaulting to the interactive scatter-gather action.
+func clusterActionFromContext(ctx context.Context) string {
+	if action, ok := ctx.Value(clusterActionKey).(string); ok {
+		return action
+	}
+	return clusterActionScatterGather
+}
 
 func RegisterRemoteClientsForSecurity() {
 	cbgt.RegisterConfigRefreshCallback("fts/remoteClients",
@@ -57,11 +103,183 @@ type RemoteClient interface {
 	SetStreamHandler(streamHandler)
 }
 
+// Transports used to reach the remote pindexes.
+const (
+	RemoteTransportGRPC = "grpc"
+	RemoteTransportHTTP = "http"
+)
+
+// pindexTransports returns the transport used for each remote pindex,
+// keyed by pindex name, but only when some of the remote pindexes had
+// to fall back from gRPC to http; otherwise it returns nil.
+func pindexTransports(remoteClients []RemoteClient) map[string]string {
+	var fallback bool
+	for _, remoteClient := range remoteClients {
+		if ic, ok := remoteClient.(*IndexClient); ok && ic.grpcFallback {
+			fallback = true
+			break
+		}
+	}
+	if !fallback {
+		return nil
+	}
+
+	rv := map[string]string{}
+	for _, remoteClient := range remoteClients {
+		switch rc := remoteClient.(type) {
+		case *GrpcClient:
+			for _, pindexName := range rc.PIndexNames {
+				rv[pindexName] = RemoteTransportGRPC
+			}
+		case *IndexClient:
+			for _, pindexName := range rc.PIndexNames {
+				rv[pindexName] = RemoteTransportHTTP
+			}
+		}
+	}
+
+	return rv
+}
+
+// QueryPlanEntry describes how a remote pindex was queried, where
+// StatusCode is the last http status seen from its remote client, or
+// 0 when the remote client never replied.
+type QueryPlanEntry struct {
+	PIndexName string `json:"pindexName"`
+	HostPort   string `json:"hostPort"`
+	Transport  string `json:"transport"`
+	StatusCode int    `json:"statusCode"`
+}
+
+// QueryPlan is the effective scatter plan of a query, which helps
+// explain incomplete results.  The pindexes not listed in Remote were
+// either searched locally or were missing.
+type QueryPlan struct {
+	NumPIndexes int              `json:"numPIndexes"`
+	Remote      []QueryPlanEntry `json:"remote,omitempty"`
+}
+
+// newQueryPlan returns the effective scatter plan of a query, based on
+// its remote clients, so it should be called after the search.
+func newQueryPlan(numPIndexes int, remoteClients []RemoteClient) *QueryPlan {
+	rv := &QueryPlan{NumPIndexes: numPIndexes}
+
+	for _, remoteClient := range remoteClients {
+		var pindexNames []string
+		var transport string
+		switch rc := remoteClient.(type) {
+		case *GrpcClient:
+			pindexNames, transport = rc.PIndexNames, RemoteTransportGRPC
+		case *IndexClient:
+			pindexNames, transport = rc.PIndexNames, RemoteTransportHTTP
+		default:
+			continue
+		}
+
+		statusCode, _ := remoteClient.GetLast()
+		for _, pindexName := range pindexNames {
+			rv.Remote = append(rv.Remote, QueryPlanEntry{
+				PIndexName: pindexName,
+				HostPort:   remoteClient.GetHostPort(),
+				Transport:  transport,
+				StatusCode: statusCode,
+			})
+		}
+	}
+
+	sort.Slice(rv.Remote, func(i, j int) bool {
+		return rv.Remote[i].PIndexName < rv.Remote[j].PIndexName
+	})
+
+	return rv
+}
+
+// The reasons for a node being unhealthy during a query.
+const (
+	NodeUnhealthyUnreachable = "unreachable"
+	NodeUnhealthyNoResponse  = "no response"
+)
+
+// ErrorNoHealthyNodes is returned for a query when none of its pindexes
+// were searched, as every node that hosts them was unhealthy.
+type ErrorNoHealthyNodes struct {
+	IndexName string `json:"indexName"`
+
+	// Unhealthy holds the reason of each unhealthy node, keyed by
+	// its hostPort.
+	Unhealthy map[string]string `json:"unhealthy"`
+}
+
+func (e *ErrorNoHealthyNodes) Error() string {
+	hostPorts := make([]string, 0, len(e.Unhealthy))
+	for hostPort := range e.Unhealthy {
+		hostPorts = append(hostPorts, hostPort)
+	}
+	sort.Strings(hostPorts)
+
+	reasons := make([]string, 0, len(hostPorts))
+	for _, hostPort := range hostPorts {
+		reasons = append(reasons, hostPort+": "+e.Unhealthy[hostPort])
+	}
+
+	return fmt.Sprintf("no healthy nodes available for index: %s,"+
+		" unhealthy nodes: [%s]", e.IndexName, strings.Join(reasons, ", "))
+}
+
+// nodeUnhealthyReason returns the reason of a remote client's node
+// being unhealthy, based on the last status of the client, or "" when
+// the node was healthy, even if it failed the query itself.
+func nodeUnhealthyReason(remoteClient RemoteClient) string {
+	switch lastStatus, _ := remoteClient.GetLast(); lastStatus {
+	case 0: // never heard back
+		return NodeUnhealthyNoResponse
+	case http.StatusServiceUnavailable:
+		return NodeUnhealthyUnreachable
+	}
+	return ""
+}
+
+// noHealthyNodesErr returns an *ErrorNoHealthyNodes when the query
+// searched none of its pindexes and all of its remote clients were
+// unhealthy, otherwise nil.
+func noHealthyNodesErr(indexName string, searchResult *bleve.SearchResult,
+	remoteClients []RemoteClient) error {
+	if len(remoteClients) == 0 ||
+		searchResult.Status == nil || searchResult.Status.Successful > 0 {
+		return nil
+	}
+
+	unhealthy := make(map[string]string, len(remoteClients))
+	for _, remoteClient := range remoteClients {
+		reason := nodeUnhealthyReason(remoteClient)
+		if reason == "" {
+			return nil
+		}
+		unhealthy[remoteClient.GetHostPort()] = reason
+	}
+
+	return &ErrorNoHealthyNodes{IndexName: indexName, Unhealthy: unhealthy}
+}
+
 type addRemoteClients func(mgr *cbgt.Manager, indexName, indexUUID string,
 	remotePlanPIndexes []*cbgt.RemotePlanPIndex,
 	consistencyParams *cbgt.ConsistencyParams, onlyPIndexes map[string]bool,
 	collector BleveIndexCollector, groupByNode bool) ([]RemoteClient, error)
 
+// scatterGatherGroupingDisabled returns true when the
+// "disableScatterGatherGrouping" manager option is set, which forces
+// one remote client per remote pindex instead of one per node.  This
+// is meant for debugging, so that the latency and the errors of an
+// individual pindex are attributable, at the cost of a remote request
+// per pindex instead of per node for every query.
+func scatterGatherGroupingDisabled(mgr *cbgt.Manager) bool {
+	if mgr == nil {
+		return false
+	}
+	v, err := strconv.ParseBool(mgr.Options()["disableScatterGatherGrouping"])
+	return err == nil && v
+}
+
 const RemoteRequestOverhead = 500 * time.Millisecond
 
 var HttpTransportDialContextTimeout = 30 * time.Second   // Go's default is 30 secs.
@@ -107,6 +325,10 @@ type IndexClient struct {
 	Consistency    *cbgt.ConsistencyParams
 	httpClient     *http.Client
 
+	// grpcFallback is true when the IndexClient stands in for a
+	// GrpcClient, as the remote node has no gRPC port available.
+	grpcFallback bool
+
 	lastMutex        sync.RWMutex
 	lastSearchStatus int
 	lastErrBody      []byte
@@ -12,8 +12,11 @@
 package cbft
 
 import (
+	"context"
 	"reflect"
 	"testing"
+
+	"github.com/blevesearch/bleve"
 )
 
 func TestNegativeIndexClient(t *testing.T) {
@@ -149,3 +152,97 @@ func TestGroupIndexClientsByHostPort(t *testing.T) {
 		t.Errorf("expect 0 hostPorts")
 	}
 }
+
+func TestNewQueryPlan(t *testing.T) {
+	remoteClients := []RemoteClient{
+		&GrpcClient{
+			HostPort:         "b:9130",
+			PIndexNames:      []string{"p2", "p1"},
+			lastSearchStatus: 200,
+		},
+		&IndexClient{
+			HostPort:         "c:8094",
+			PIndexNames:      []string{"p3"},
+			lastSearchStatus: 412,
+			grpcFallback:     true,
+		},
+	}
+
+	exp := &QueryPlan{
+		NumPIndexes: 4,
+		Remote: []QueryPlanEntry{
+			{"p1", "b:9130", RemoteTransportGRPC, 200},
+			{"p2", "b:9130", RemoteTransportGRPC, 200},
+			{"p3", "c:8094", RemoteTransportHTTP, 412},
+		},
+	}
+	if plan := newQueryPlan(4, remoteClients); !reflect.DeepEqual(plan, exp) {
+		t.Errorf("expected query plan: %+v, got: %+v", exp, plan)
+	}
+}
+
+func TestWithClusterAction(t *testing.T) {
+	ctx := context.Background()
+	if action := clusterActionFromContext(ctx); action !=
+		clusterActionScatterGather {
+		t.Errorf("expected the default cluster action, got: %s", action)
+	}
+
+	bctx, err := WithClusterAction(ctx, ClusterActionScatterGatherBackground)
+	if err != nil {
+		t.Fatalf("expected a known cluster action, err: %v", err)
+	}
+	if action := clusterActionFromContext(bctx); action !=
+		ClusterActionScatterGatherBackground {
+		t.Errorf("expected the background cluster action, got: %s", action)
+	}
+
+	if _, err = WithClusterAction(ctx, "fts/anything"); err == nil {
+		t.Errorf("expected an unknown cluster action to be rejected")
+	}
+}
+
+func TestNoHealthyNodesErr(t *testing.T) {
+	failed := &bleve.SearchResult{
+		Status: &bleve.SearchStatus{Total: 2, Failed: 2},
+	}
+	unreachable := &GrpcClient{HostPort: "b:9130", lastSearchStatus: 503}
+	silent := &IndexClient{HostPort: "c:8094"}
+	rejected := &GrpcClient{HostPort: "d:9130", lastSearchStatus: 429}
+
+	err := noHealthyNodesErr("idx", failed,
+		[]RemoteClient{unreachable, silent})
+	exp := &ErrorNoHealthyNodes{
+		IndexName: "idx",
+		Unhealthy: map[string]string{
+			"b:9130": NodeUnhealthyUnreachable,
+			"c:8094": NodeUnhealthyNoResponse,
+		},
+	}
+	if !reflect.DeepEqual(err, exp) {
+		t.Errorf("expected err: %+v, got: %+v", exp, err)
+	}
+	if msg := err.Error(); msg != "no healthy nodes available for index: idx,"+
+		" unhealthy nodes: [b:9130: unreachable, c:8094: no response]" {
+		t.Errorf("unexpected err message: %s", msg)
+	}
+
+	// a node that was healthy, yet failed the query, isn't explained
+	if err = noHealthyNodesErr("idx", failed,
+		[]RemoteClient{unreachable, rejected}); err != nil {
+		t.Errorf("expected no err with a healthy node, got: %v", err)
+	}
+
+	// nor is a query that searched some of its pindexes
+	partial := &bleve.SearchResult{
+		Status: &bleve.SearchStatus{Total: 2, Failed: 1, Successful: 1},
+	}
+	if err = noHealthyNodesErr("idx", partial,
+		[]RemoteClient{unreachable}); err != nil {
+		t.Errorf("expected no err with a successful pindex, got: %v", err)
+	}
+
+	if err = noHealthyNodesErr("idx", failed, nil); err != nil {
+		t.Errorf("expected no err without remote clients, got: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"context"
+
+	"github.com/couchbase/cbgt"
+	"google.golang.org/grpc/metadata"
+)
+
+// rpcRequestIDKey is the metadata key carrying the ID of the request
+// that a query fanned out from, which the server echoes in its header,
+// so that the log lines of the client and of the servers correlate.
+const rpcRequestIDKey = "rpcrequestid"
+
+type requestIDKeyType string
+
+const requestIDKey = requestIDKeyType("requestID")
+
+// WithRequestID returns a ctx that has the queries made with it carry
+// the given request ID to the remote servers and into the log lines.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// requestIDFromContext returns the request ID of the ctx, if any.
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// ensureRequestID returns the ctx along with its request ID, where a
+// ctx without one is stamped with a new request ID.
+func ensureRequestID(ctx context.Context) (context.Context, string) {
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		return ctx, requestID
+	}
+	requestID := cbgt.NewUUID()
+	return WithRequestID(ctx, requestID), requestID
+}
+
+// appendRequestID adds the request ID of the ctx, if any, to its
+// outgoing metadata.
+func appendRequestID(ctx context.Context) context.Context {
+	requestID := requestIDFromContext(ctx)
+	if requestID == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, rpcRequestIDKey, requestID)
+}
+
+// requestIDFromMetadata returns the request ID of an incoming request,
+// if any.
+func requestIDFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	vals := md.Get(rpcRequestIDKey)
+	if len(vals) == 0 {
+		return ""
+	}
+
+	return vals[0]
+}
@@ -0,0 +1,132 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	pb "github.com/couchbase/cbft/protobuf"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestEnsureRequestID(t *testing.T) {
+	ctx, requestID := ensureRequestID(context.Background())
+	if requestID == "" || requestIDFromContext(ctx) != requestID {
+		t.Errorf("expected a new request ID, got: %q", requestID)
+	}
+
+	_, again := ensureRequestID(ctx)
+	if again != requestID {
+		t.Errorf("expected the request ID to be kept, got: %q", again)
+	}
+
+	_, stamped := ensureRequestID(WithRequestID(context.Background(), "r1"))
+	if stamped != "r1" {
+		t.Errorf("expected the stamped request ID, got: %q", stamped)
+	}
+}
+
+func TestGrpcClientQueryRequestID(t *testing.T) {
+	cli := &trailerStreamClient{
+		msgs: []*pb.StreamSearchResults{{
+			Contents: &pb.StreamSearchResults_SearchResult{
+				SearchResult: []byte(`{"total_hits":1}`),
+			},
+		}},
+	}
+	g := &GrpcClient{
+		HostPort:    "localhost:15000",
+		IndexName:   "idx",
+		PIndexNames: []string{"idx_pindex_0"},
+		GrpcCli:     cli,
+	}
+
+	query := func(ctx context.Context) []string {
+		_, err := g.Query(ctx, &scatterRequest{
+			searchRequest: bleve.NewSearchRequest(bleve.NewMatchAllQuery()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, _ := metadata.FromOutgoingContext(cli.ctx)
+		return md[rpcRequestIDKey]
+	}
+
+	if got := query(WithRequestID(context.Background(), "r1")); len(got) != 1 ||
+		got[0] != "r1" {
+		t.Errorf("expected the stamped request ID to be sent, got: %v", got)
+	}
+
+	if got := query(context.Background()); len(got) != 1 || got[0] == "" {
+		t.Errorf("expected a new request ID to be sent, got: %v", got)
+	}
+}
+
+// headerServerStream is a grpc.ServerStream of the ctx that captures
+// the header that's set.
+type headerServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *headerServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *headerServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestServerInterceptorRequestID(t *testing.T) {
+	intercept := func(md metadata.MD) (*headerServerStream, string) {
+		ss := &headerServerStream{
+			ctx: metadata.NewIncomingContext(context.Background(), md),
+		}
+
+		var got string
+		err := serverInterceptor(nil, ss, &grpc.StreamServerInfo{},
+			func(srv interface{}, stream grpc.ServerStream) error {
+				got = requestIDFromContext(stream.Context())
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss, got
+	}
+
+	ss, got := intercept(metadata.Pairs(
+		rpcClusterActionKey, clusterActionScatterGather,
+		rpcRequestIDKey, "r1"))
+	if got != "r1" {
+		t.Errorf("expected the request ID of the client, got: %q", got)
+	}
+	if echoed := ss.header.Get(rpcRequestIDKey); len(echoed) != 1 ||
+		echoed[0] != "r1" {
+		t.Errorf("expected the request ID to be echoed, got: %v", echoed)
+	}
+
+	ss, got = intercept(metadata.Pairs(
+		rpcClusterActionKey, clusterActionScatterGather))
+	if got == "" {
+		t.Errorf("expected a new request ID")
+	}
+	if echoed := ss.header.Get(rpcRequestIDKey); len(echoed) != 1 ||
+		echoed[0] != got {
+		t.Errorf("expected the new request ID to be echoed, got: %v", echoed)
+	}
+}
@@ -13,6 +13,7 @@ package cbft
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -38,6 +39,10 @@ func init() {
 	LogLevels["WARN"] = 2
 }
 
+// Optional callback when the manager options were updated over REST,
+// such as to apply a changed memory quota without a restart.
+var OnManagerOptionsUpdated func(options map[string]string)
+
 // ManagerOptionsExt is a REST handler that serves as a wrapper for
 // ManagerOptions - where it sets the manager options, and updates
 // the logLevel upon request.
@@ -124,6 +129,27 @@ func NewManagerOptionsExt(mgr *cbgt.Manager) *ManagerOptionsExt {
 			}
 		}
 
+		// Validate the memory quota and its fractions, which are applied
+		// to the herder without a restart
+		if options["ftsMemoryQuota"] != "" {
+			ftsMemoryQuota, err := strconv.Atoi(options["ftsMemoryQuota"])
+			if err != nil || ftsMemoryQuota < 0 {
+				return nil, fmt.Errorf("illegal value for ftsMemoryQuota: '%v'",
+					options["ftsMemoryQuota"])
+			}
+		}
+
+		for _, name := range []string{"memApplicationFraction",
+			"memIndexingFraction", "memQueryingFraction"} {
+			if options[name] != "" {
+				f, err := strconv.ParseFloat(options[name], 64)
+				if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+					return nil, fmt.Errorf("illegal value for %s: '%v'",
+						name, options[name])
+				}
+			}
+		}
+
 		return options, nil
 	}
 
@@ -150,6 +176,10 @@ func (h *ManagerOptionsExt) ServeHTTP(
 		bleveMaxClauseCount, _ := strconv.Atoi(bleveMaxClauseCountStr)
 		bleveSearcher.DisjunctionMaxClauseCount = bleveMaxClauseCount
 	}
+
+	if OnManagerOptionsUpdated != nil {
+		OnManagerOptionsUpdated(h.mgr.Options())
+	}
 }
 
 type ConciseOptions struct {
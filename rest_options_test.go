@@ -0,0 +1,85 @@
+//  Copyright (c) 2019 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the
+//  License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an "AS
+//  IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+//  express or implied. See the License for the specific language
+//  governing permissions and limitations under the License.
+
+package cbft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestManagerOptionsExtMemQuota(t *testing.T) {
+	emptyDir, _ := ioutil.TempDir("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	cfg := cbgt.NewCfgMem()
+	meh := &TestMEH{}
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", emptyDir, "some-datasource", meh)
+	mgr.Start("wanted")
+	mgr.Kick("test-start-kick")
+
+	defer func(f func(map[string]string)) { OnManagerOptionsUpdated = f }(
+		OnManagerOptionsUpdated)
+	var updated map[string]string
+	OnManagerOptionsUpdated = func(options map[string]string) {
+		updated = options
+	}
+
+	h := NewManagerOptionsExt(mgr)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"memQueryingFraction":"half"}`, http.StatusBadRequest},
+		{`{"memIndexingFraction":"Inf"}`, http.StatusBadRequest},
+		{`{"memApplicationFraction":"NaN"}`, http.StatusBadRequest},
+		{`{"ftsMemoryQuota":"-1"}`, http.StatusBadRequest},
+		{`{"ftsMemoryQuota":"1000","memQueryingFraction":"0.5"}`,
+			http.StatusOK},
+		{`{"ftsMemoryQuota":"1000","memQueryingFraction":"0.5",` +
+			`"memIndexingFraction":"-0.5","memApplicationFraction":"1.5"}`,
+			http.StatusOK},
+	}
+
+	for i, test := range tests {
+		updated = nil
+		record := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/managerOptions",
+			bytes.NewBufferString(test.body))
+		h.ServeHTTP(record, req)
+
+		if record.Code != test.status {
+			t.Errorf("test: %d, body: %s, expected status: %d, got: %d,"+
+				" response: %s", i, test.body, test.status, record.Code,
+				record.Body)
+		}
+		if test.status != http.StatusOK {
+			if mgr.Options()["ftsMemoryQuota"] != "" {
+				t.Errorf("test: %d, expected the options not to be set,"+
+					" got: %v", i, mgr.Options())
+			}
+			continue
+		}
+		if updated["ftsMemoryQuota"] != "1000" ||
+			updated["memQueryingFraction"] != "0.5" {
+			t.Errorf("test: %d, expected the updated options to be"+
+				" applied, got: %v", i, updated)
+		}
+	}
+}
@@ -91,6 +91,9 @@ cluster.logs.fts!read
 GET /api/log
 cluster.logs.fts!read
 
+GET /api/planReachability
+cluster.settings.fts!read
+
 GET /api/runtime
 cluster.settings.fts!read
 
@@ -15,9 +15,12 @@
 package cbft
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search"
@@ -37,9 +40,63 @@ type streamHandler interface {
 	write([]byte, []uint64, int) error
 }
 
+// contextStreamHandler is a streamHandler whose writes may block while
+// its consumer is slow, instead of buffering the hits, until the ctx of
+// the search is done.  As the hits are written from the receive loop of
+// the search, a blocked write pauses the loop, and so the server of the
+// search, by the stream credits or the gRPC window.
+type contextStreamHandler interface {
+	streamHandler
+	writeContext(context.Context, []byte, []uint64, int) error
+}
+
+// totGrpcStreamWriteTimeNS tracks the time the receive loops of the
+// searches spent writing the hits to the stream handlers, which is
+// mostly the time they were paused by slow consumers, and
+// totGrpcStreamWriteAborts the writes given up as the ctx of the search
+// was done.
+var totGrpcStreamWriteTimeNS uint64
+var totGrpcStreamWriteAborts uint64
+
+// writeStream writes the hits to the stream handler, blocking for as
+// long as the handler applies backpressure, where a handler that takes
+// the ctx returns promptly once the ctx is done.
+func writeStream(ctx context.Context, sw streamHandler,
+	b []byte, offsets []uint64, hitsCount int) error {
+	startTime := time.Now()
+
+	// the write may block on a slow consumer, so it gives up the
+	// scatter-gather worker, for the other queries, meanwhile
+	resume := yieldScatterWorker(ctx)
+
+	var err error
+	if cw, ok := sw.(contextStreamHandler); ok {
+		err = cw.writeContext(ctx, b, offsets, hitsCount)
+	} else {
+		err = sw.write(b, offsets, hitsCount)
+	}
+
+	if er := resume(); er != nil && err == nil {
+		err = er
+	}
+
+	atomic.AddUint64(&totGrpcStreamWriteTimeNS,
+		uint64(time.Since(startTime)))
+	if err != nil && ctx.Err() != nil {
+		atomic.AddUint64(&totGrpcStreamWriteAborts, 1)
+		return ctx.Err()
+	}
+	return err
+}
+
 type streamer struct {
 	index string
 
+	// sendSlot serializes the sends onto the stream, apart from the m,
+	// so that the writers waiting behind a send that blocks on a slow
+	// consumer give up the wait once their ctx is done.
+	sendSlot chan struct{}
+
 	m       sync.Mutex
 	req     *bleve.SearchRequest
 	stream  pb.SearchService_SearchServer
@@ -49,16 +106,28 @@ type streamer struct {
 
 	curSkip int
 	curSize int
+
+	// transform, when set, is applied to the hits before they're
+	// streamed, where the first transform error aborts the query.
+	transform    HitTransform
+	transformErr error
+
+	// compressMinBytes is the size from which the streamed hits are
+	// compressed, where -1 means no compression.
+	compressMinBytes int
 }
 
 func newStreamHandler(index string, req *bleve.SearchRequest,
 	outStream pb.SearchService_SearchServer) *streamer {
 	rv := &streamer{
-		index:   index,
-		curSize: int(req.Size),
-		curSkip: int(req.From),
-		stream:  outStream,
-		req:     req,
+		index:    index,
+		sendSlot: make(chan struct{}, 1),
+		curSize:  int(req.Size),
+		curSkip:  int(req.From),
+		stream:   outStream,
+		req:      req,
+
+		compressMinBytes: -1,
 	}
 	if req.Size > 0 {
 		rv.sizeSet = true
@@ -70,14 +139,57 @@ func newStreamHandler(index string, req *bleve.SearchRequest,
 }
 
 func (s *streamer) write(b []byte, offsets []uint64, hitsCount int) error {
+	return s.writeContext(context.Background(), b, offsets, hitsCount)
+}
+
+// writeContext is like write, but gives up the write once the ctx is
+// done, as the hits of a search are no longer awaited then, including
+// while waiting for a send onto the stream that blocks on a slow
+// consumer.
+func (s *streamer) writeContext(ctx context.Context, b []byte,
+	offsets []uint64, hitsCount int) error {
+	// the slot is taken ahead of the hits, so that the hits are sent in
+	// the order of their writes
+	if err := s.acquireSendSlot(ctx); err != nil {
+		return err
+	}
+
+	hitRes, err := s.hitsResults(b, offsets, hitsCount)
+	if err != nil || hitRes == nil {
+		s.releaseSendSlot()
+		return err
+	}
+
+	return s.sendHoldingSlot(hitRes)
+}
+
+// hitsResults returns the msg of the hits that are within the page of
+// the request, if any.
+func (s *streamer) hitsResults(b []byte, offsets []uint64,
+	hitsCount int) (*pb.StreamSearchResults, error) {
+	if s.transform != nil {
+		var err error
+		b, offsets, err = transformHitsBytes(s.transform, b)
+		if err != nil {
+			s.m.Lock()
+			if s.transformErr == nil {
+				s.transformErr = err
+			}
+			s.m.Unlock()
+			return nil, err
+		}
+		hitsCount = len(offsets)
+	}
+
 	s.m.Lock()
+	defer s.m.Unlock()
+
 	s.total += hitsCount
 
 	if s.curSkip > 0 && s.skipSet {
 		if hitsCount <= s.curSkip {
 			s.curSkip -= hitsCount
-			s.m.Unlock()
-			return nil
+			return nil, nil
 		}
 
 		// advance the hit bytes by the skip factor
@@ -97,8 +209,7 @@ func (s *streamer) write(b []byte, offsets []uint64, hitsCount int) error {
 
 	// we have already streamed the requested page
 	if s.curSize == 0 && s.sizeSet {
-		s.m.Unlock()
-		return nil
+		return nil, nil
 	}
 
 	if s.curSize > 0 && s.sizeSet {
@@ -113,8 +224,13 @@ func (s *streamer) write(b []byte, offsets []uint64, hitsCount int) error {
 		}
 	}
 
+	b, encoding, err := encodeContents(b, s.compressMinBytes)
+	if err != nil {
+		return nil, err
+	}
+
 	// TODO: perf, can hitRes be reused across stream.Send() calls?
-	hitRes := &pb.StreamSearchResults{
+	return &pb.StreamSearchResults{
 		Contents: &pb.StreamSearchResults_Hits{
 			Hits: &pb.StreamSearchResults_Batch{
 				Bytes:   b,
@@ -122,14 +238,35 @@ func (s *streamer) write(b []byte, offsets []uint64, hitsCount int) error {
 				Total:   uint64(len(offsets)),
 			},
 		},
-	}
-	if err := s.stream.Send(hitRes); err != nil {
-		s.m.Unlock()
+		ContentEncoding: encoding,
+	}, nil
+}
+
+// acquireSendSlot waits for the sends in flight, if any, unless the ctx
+// is done first.
+func (s *streamer) acquireSendSlot(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	select {
+	case s.sendSlot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	s.m.Unlock()
-	return nil
+func (s *streamer) releaseSendSlot() {
+	<-s.sendSlot
+}
+
+// sendHoldingSlot sends the msg onto the stream, and releases the send
+// slot once sent, where a send blocked on a slow consumer is unblocked
+// once the stream, which the ctx of the search is derived from, is done.
+func (s *streamer) sendHoldingSlot(m *pb.StreamSearchResults) error {
+	err := s.stream.Send(m)
+	s.releaseSendSlot()
+	return err
 }
 
 type docMatchHandler struct {
@@ -218,6 +355,23 @@ func (dmh *docMatchHandler) documentMatchHandler(hit *search.DocumentMatch) erro
 	return nil
 }
 
+// send sends a message other than the hits onto the stream, which is
+// serialized with the streaming of the hits, unless the ctx of the
+// search is done first.
+func (s *streamer) send(ctx context.Context, m *pb.StreamSearchResults) error {
+	if err := s.acquireSendSlot(ctx); err != nil {
+		return err
+	}
+	return s.sendHoldingSlot(m)
+}
+
+// TransformErr returns the first hit transform error, if any.
+func (s *streamer) TransformErr() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.transformErr
+}
+
 func (s *streamer) MakeDocumentMatchHandler(
 	ctx *search.SearchContext) (search.DocumentMatchHandler, bool, error) {
 	var highlighter highlight.Highlighter